@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+	"github.com/spf13/cobra"
+)
+
+// savedPlan is the file written by `create cluster --target=dryrun --out`
+type savedPlan struct {
+	Options   CreateClusterCmd `json:"options"`
+	Generated *generatedConfig `json:"generated"`
+	Plan      *fi.Plan         `json:"plan"`
+}
+
+type ApplyCmd struct {
+	Parallelism int
+}
+
+var apply ApplyCmd
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "apply PLAN",
+		Short: "Apply a saved plan",
+		Long: `Applies exactly the changes in a plan saved by create cluster --target=dryrun --out PLAN.
+
+Refuses to apply if the cluster has changed since the plan was made.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := apply.Run(args)
+			if err != nil {
+				glog.Exitf("%v", err)
+			}
+		},
+	}
+
+	RootCmd.AddCommand(cmd)
+
+	addParallelismFlag(cmd, &apply.Parallelism)
+}
+
+func (c *ApplyCmd) Run(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected exactly one argument: the plan file")
+	}
+	planFile := args[0]
+
+	data, err := ioutil.ReadFile(planFile)
+	if err != nil {
+		return fmt.Errorf("error reading plan file %q: %v", planFile, err)
+	}
+	saved := &savedPlan{}
+	err = json.Unmarshal(data, saved)
+	if err != nil {
+		return fmt.Errorf("error parsing plan file %q: %v", planFile, err)
+	}
+	if saved.Plan == nil || saved.Generated == nil {
+		return fmt.Errorf("plan file %q is not a saved plan", planFile)
+	}
+
+	options := saved.Options
+
+	// Re-compute the plan, to verify that the actual state has not changed
+	{
+		cc, err := options.buildCluster(saved.Generated)
+		if err != nil {
+			return err
+		}
+
+		dryRunTarget, err := fi.NewDryRunTarget(cc.filestore)
+		if err != nil {
+			return err
+		}
+		_, err = cc.run(dryRunTarget, fi.ModeConfigure, c.Parallelism)
+		if err != nil {
+			return err
+		}
+
+		current, err := dryRunTarget.BuildPlan()
+		if err != nil {
+			return err
+		}
+		err = fi.ComparePlans(saved.Plan, current)
+		if err != nil {
+			return fmt.Errorf("refusing to apply plan %q, because the cluster has changed since the plan was made: %v", planFile, err)
+		}
+	}
+
+	glog.Infof("Cluster state matches plan; applying")
+
+	cc, err := options.buildCluster(saved.Generated)
+	if err != nil {
+		return err
+	}
+	target := fi.NewPlanTarget(cc.newAPITarget(), saved.Plan)
+	_, err = cc.run(target, fi.ModeConfigure, c.Parallelism)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\n\nDone\n")
+	return nil
+}
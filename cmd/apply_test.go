@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/kopeio/kope/pkg/fi"
+)
+
+// TestApplyPlanWithCreate applies a plan that creates a unit that other planned changes reference, so the
+// references are only known after the unit is created
+func TestApplyPlanWithCreate(t *testing.T) {
+	defer func(original func(string, map[string]string) *fi.AWSCloud) {
+		NewAWSCloud = original
+	}(NewAWSCloud)
+
+	c := newTestCluster(t)
+	defer c.Close()
+
+	create := c.options()
+	err := create.Run()
+	if err != nil {
+		t.Fatalf("error creating cluster: %v", err)
+	}
+
+	// Delete the internet gateway; recreating it changes the attachment & the route
+	cloud := c.fake.NewCloud("us-east-1", nil)
+	igws, err := cloud.EC2.DescribeInternetGateways(&ec2.DescribeInternetGatewaysInput{})
+	if err != nil {
+		t.Fatalf("error listing internet gateways: %v", err)
+	}
+	if len(igws.InternetGateways) != 1 {
+		t.Fatalf("expected one internet gateway, found %d", len(igws.InternetGateways))
+	}
+	igw := igws.InternetGateways[0]
+	for _, attachment := range igw.Attachments {
+		_, err = cloud.EC2.DetachInternetGateway(&ec2.DetachInternetGatewayInput{InternetGatewayId: igw.InternetGatewayId, VpcId: attachment.VpcId})
+		if err != nil {
+			t.Fatalf("error detaching internet gateway: %v", err)
+		}
+	}
+	_, err = cloud.EC2.DeleteInternetGateway(&ec2.DeleteInternetGatewayInput{InternetGatewayId: igw.InternetGatewayId})
+	if err != nil {
+		t.Fatalf("error deleting internet gateway: %v", err)
+	}
+
+	planFile := path.Join(c.dir, "plan.json")
+	dryrun := c.options()
+	dryrun.Target = "dryrun"
+	dryrun.Out = planFile
+	err = dryrun.Run()
+	if err != nil {
+		t.Fatalf("error planning cluster: %v", err)
+	}
+
+	var created []string
+	for _, change := range readSavedPlan(t, planFile).Plan.Changes {
+		if change.Action == fi.PlanActionCreate {
+			created = append(created, change.Type)
+		}
+	}
+	if len(created) != 2 || created[0] != "InternetGateway" || created[1] != "InternetGatewayAttachment" {
+		t.Fatalf("expected the plan to create the internet gateway & attachment, got %v", created)
+	}
+
+	a := &ApplyCmd{Parallelism: 1}
+	err = a.Run([]string{planFile})
+	if err != nil {
+		t.Fatalf("error applying plan: %v", err)
+	}
+
+	igws, err = cloud.EC2.DescribeInternetGateways(&ec2.DescribeInternetGatewaysInput{})
+	if err != nil {
+		t.Fatalf("error listing internet gateways: %v", err)
+	}
+	if len(igws.InternetGateways) != 1 || len(igws.InternetGateways[0].Attachments) != 1 {
+		t.Fatalf("expected the internet gateway to be recreated & attached, found %v", igws.InternetGateways)
+	}
+
+	validate := &ValidateClusterCmd{Options: c.options(), Output: "text"}
+	err = validate.Run()
+	if err != nil {
+		t.Fatalf("expected no drift after applying the plan, got: %v", err)
+	}
+}
+
+// TestApplyPlanForNewCluster plans & applies a cluster that does not yet exist; the master certificate can only
+// be issued once the master public IP is allocated, when the plan is applied
+func TestApplyPlanForNewCluster(t *testing.T) {
+	defer func(original func(string, map[string]string) *fi.AWSCloud) {
+		NewAWSCloud = original
+	}(NewAWSCloud)
+
+	c := newTestCluster(t)
+	defer c.Close()
+
+	planFile := path.Join(c.dir, "plan.json")
+	dryrun := c.options()
+	dryrun.Target = "dryrun"
+	dryrun.Out = planFile
+	err := dryrun.Run()
+	if err != nil {
+		t.Fatalf("error planning cluster: %v", err)
+	}
+
+	var masterCert *fi.PlanChange
+	for _, change := range readSavedPlan(t, planFile).Plan.Changes {
+		if change.Path == "master-certificate" {
+			masterCert = change
+		}
+	}
+	if masterCert == nil || masterCert.Action != fi.PlanActionCreate || len(masterCert.Fields) != 1 || !strings.Contains(masterCert.Fields[0].New, fi.PlanValueKnownAfterApply) {
+		t.Fatalf("expected the plan to create the master certificate, with a SAN known after apply; got %v", masterCert)
+	}
+	if c.fake.EC2.Count("instance") != 0 {
+		t.Fatalf("planning the cluster created instances")
+	}
+
+	a := &ApplyCmd{Parallelism: 1}
+	err = a.Run([]string{planFile})
+	if err != nil {
+		t.Fatalf("error applying plan: %v", err)
+	}
+	if c.fake.EC2.Count("instance") != 1 {
+		t.Fatalf("expected the master instance to be created, found %d instances", c.fake.EC2.Count("instance"))
+	}
+
+	validate := &ValidateClusterCmd{Options: c.options(), Output: "text"}
+	err = validate.Run()
+	if err != nil {
+		t.Fatalf("expected no drift after applying the plan, got: %v", err)
+	}
+}
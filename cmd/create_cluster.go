@@ -3,27 +3,50 @@ package cmd
 import (
 	"fmt"
 
-	"github.com/spf13/cobra"
+	"bytes"
+	"encoding/json"
 	"github.com/golang/glog"
-	"github.com/kopeio/kope/pkg/units/awsunits"
 	"github.com/kopeio/kope/pkg/fi"
-	"path"
-	"os"
-	"io/ioutil"
-	"golang.org/x/crypto/ssh"
 	"github.com/kopeio/kope/pkg/kutil"
+	"github.com/kopeio/kope/pkg/units"
+	_ "github.com/kopeio/kope/pkg/units/awsunits"
+	_ "github.com/kopeio/kope/pkg/units/gceunits"
+	"github.com/kopeio/kope/pkg/units/k8sunits"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
-	"bytes"
+	"time"
 )
 
 type CreateClusterCmd struct {
-	ClusterID  string
-	S3Bucket   string
-	S3Region   string
-	SSHKey     string
-	StateDir   string
-	ReleaseDir string
-	Target     string
+	ClusterID         string
+	S3Bucket          string
+	S3Region          string
+	SSHKey            string
+	StateDir          string
+	State             string
+	StateRegion       string
+	KeyManager        string
+	ReleaseDir        string
+	Target            string
+	Out               string
+	Output            string
+	Parallelism       int
+	RollbackOnFailure bool
+	CloudProvider     string
+	Project           string
+	GCSBucket         string
+	GCSPrivate        bool
+	GCSSigningKey     string
+	GCSURLExpiry      time.Duration
+	ArtifactsDir      string
+	ArtifactsURL      string
+	S3Private         bool
+	S3URLExpiry       time.Duration
 }
 
 var createCluster CreateClusterCmd
@@ -32,8 +55,8 @@ func init() {
 	cmd := &cobra.Command{
 		Use:   "cluster",
 		Short: "Create cluster",
-		Long: `Creates a new k8s cluster.`,
-		Run: func(cmd *cobra.Command, args[]string) {
+		Long:  `Creates a new k8s cluster.`,
+		Run: func(cmd *cobra.Command, args []string) {
 			err := createCluster.Run()
 			if err != nil {
 				glog.Exitf("%v", err)
@@ -43,18 +66,189 @@ func init() {
 
 	createCmd.AddCommand(cmd)
 
-	cmd.Flags().StringVarP(&createCluster.StateDir, "dir", "d", "", "Directory to load & store state")
+	cmd.Flags().StringVarP(&createCluster.StateDir, "dir", "d", "", "Directory to load & store state (with --state, only the rollback journal is stored here)")
+	cmd.Flags().StringVar(&createCluster.State, "state", "", "Shared state store, holding the state for each cluster by cluster id (e.g. s3://bucket/prefix, or a local directory)")
+	cmd.Flags().StringVar(&createCluster.StateRegion, "state-region", "", "Region of the S3 bucket holding --state (defaults to $AWS_REGION)")
+	cmd.Flags().StringVar(&createCluster.KeyManager, "key-manager", "", "Encrypt the private keys in the state: passphrase (read from $KOPE_KEY_PASSPHRASE), or file:PATH (a local key file, generated if missing)")
 	cmd.Flags().StringVarP(&createCluster.ReleaseDir, "release", "r", "", "Directory to load release from")
 	cmd.Flags().StringVar(&createCluster.S3Region, "s3-region", "", "Region in which to create the S3 bucket (if it does not exist)")
 	cmd.Flags().StringVar(&createCluster.S3Bucket, "s3-bucket", "", "S3 bucket for upload of artifacts")
+	cmd.Flags().BoolVar(&createCluster.S3Private, "s3-private", false, "Keep the artifacts in S3 private, and give the instances presigned URLs")
+	cmd.Flags().DurationVar(&createCluster.S3URLExpiry, "s3-url-expiry", fi.MaxPresignExpiry, "Expiry of the presigned URLs with --s3-private; instances launched after half this time need kope to be re-run")
 	cmd.Flags().StringVarP(&createCluster.SSHKey, "i", "i", "", "SSH Key for cluster")
-	cmd.Flags().StringVarP(&createCluster.Target, "target", "t", "direct", "Target type.  Suported: direct, bash")
+	cmd.Flags().StringVarP(&createCluster.Target, "target", "t", "direct", "Target type.  Suported: direct, bash, dryrun, terraform, cloudformation")
+	cmd.Flags().StringVarP(&createCluster.Output, "output", "o", "text", "Output format for the dryrun target.  Supported: text, json, yaml")
+	cmd.Flags().StringVar(&createCluster.Out, "out", "", "Output location (the directory for terraform; the script for bash; the template file for cloudformation, which is otherwise applied as a stack; the plan file for dryrun, to be applied with kope apply)")
 
 	cmd.Flags().StringVar(&createCluster.ClusterID, "cluster-id", "", "cluster id")
+	cmd.Flags().StringVar(&createCluster.CloudProvider, "cloud", "", "Cloud provider to use (overrides the state).  Supported: aws, gce")
+	cmd.Flags().StringVar(&createCluster.Project, "project", "", "Google Cloud project (gce only)")
+	cmd.Flags().StringVar(&createCluster.GCSBucket, "gcs-bucket", "", "GCS bucket for upload of artifacts (gce only)")
+	cmd.Flags().BoolVar(&createCluster.GCSPrivate, "gcs-private", false, "Keep the artifacts in GCS private, and give the instances signed URLs (gce only)")
+	cmd.Flags().StringVar(&createCluster.GCSSigningKey, "gcs-signing-key", "", "Service account JSON key file with which to sign the URLs with --gcs-private")
+	cmd.Flags().DurationVar(&createCluster.GCSURLExpiry, "gcs-url-expiry", fi.MaxPresignExpiry, "Expiry of the signed URLs with --gcs-private; instances launched after half this time need kope to be re-run")
+	cmd.Flags().StringVar(&createCluster.ArtifactsDir, "artifacts-dir", "", "Local directory for artifacts, instead of S3 or GCS; serve it with kope serve-artifacts")
+	cmd.Flags().StringVar(&createCluster.ArtifactsURL, "artifacts-url", "", "URL at which the instances can reach the artifacts dir (e.g. http://10.1.2.3:8080)")
+	addParallelismFlag(cmd, &createCluster.Parallelism)
+	cmd.Flags().BoolVar(&createCluster.RollbackOnFailure, "rollback-on-failure", false, "If the direct target fails, delete the resources that were created, and the artifacts that were uploaded")
+}
+
+func (c *CreateClusterCmd) Run() error {
+	if c.Output != "text" && c.Output != fi.PlanFormatJSON && c.Output != fi.PlanFormatYAML {
+		return fmt.Errorf("unsupported output format %q", c.Output)
+	}
+	if c.Output != "text" && c.Target != "dryrun" {
+		return fmt.Errorf("--output=%s is only supported with the dryrun target", c.Output)
+	}
+
+	cc, err := c.buildCluster(nil)
+	if err != nil {
+		return err
+	}
+
+	if c.RollbackOnFailure && c.Target != "direct" {
+		return fmt.Errorf("--rollback-on-failure is only supported with the direct target")
+	}
+	if c.RollbackOnFailure && c.StateDir == "" {
+		return fmt.Errorf("--rollback-on-failure requires --dir, where the created resources are recorded")
+	}
+
+	awsCloud, isAWS := cc.cloud.(*fi.AWSCloud)
+	if !isAWS {
+		if c.RollbackOnFailure {
+			return fmt.Errorf("--rollback-on-failure is only supported on aws")
+		}
+		if c.Target != "direct" && c.Target != "dryrun" {
+			return fmt.Errorf("the %s target is only supported on aws", c.Target)
+		}
+	}
+
+	var target fi.Target
+	var bashTarget *fi.BashTarget
+	var dryRunTarget *fi.DryRunTarget
+	var journal *fi.Journal
+
+	switch c.Target {
+	case "direct":
+		target = cc.newAPITarget()
+		if apiTarget, ok := target.(*fi.AWSAPITarget); ok && c.StateDir != "" {
+			journal, err = loadJournal(c.StateDir, awsCloud.Region, cc.k.ClusterID)
+			if err != nil {
+				return err
+			}
+			apiTarget.Journal = journal
+			if s3FileStore, ok := cc.filestore.(*fi.S3FileStore); ok {
+				s3FileStore.Journal = journal
+			}
+		}
+	case "bash":
+		bashTarget = fi.NewBashTarget(awsCloud, cc.filestore)
+		target = bashTarget
+	case "dryrun":
+		dryRunTarget, err = fi.NewDryRunTarget(cc.filestore)
+		if err != nil {
+			return err
+		}
+		target = dryRunTarget
+	case "terraform":
+		if c.Out == "" {
+			return fmt.Errorf("--out is required for the terraform target")
+		}
+		target = fi.NewTerraformTarget(awsCloud, cc.filestore, c.Out)
+	case "cloudformation":
+		target = fi.NewCloudFormationTarget(awsCloud, cc.filestore, "kubernetes-"+cc.k.ClusterID, c.Out)
+	default:
+		return fmt.Errorf("unsupported target type %q", c.Target)
+	}
+
+	_, err = cc.run(target, fi.ModeConfigure, c.Parallelism)
+	if err != nil {
+		if journal != nil && len(journal.Entries) != 0 {
+			if c.RollbackOnFailure {
+				glog.Warningf("error creating cluster, rolling back: %v", err)
+				rollback := &kutil.Rollback{Cloud: awsCloud, Journal: journal}
+				rollbackErr := rollback.Run()
+				if rollbackErr != nil {
+					return fmt.Errorf("%v (and rollback failed: %v)", err, rollbackErr)
+				}
+			} else {
+				glog.Warningf("The resources that were created are recorded in %s; to delete them run: kope rollback --dir %s --yes", journal.Path(), c.StateDir)
+			}
+		}
+		return err
+	}
+
+	if journal != nil {
+		err = journal.Remove()
+		if err != nil {
+			return err
+		}
+	}
+
+	if bashTarget != nil {
+		var script bytes.Buffer
+		err = bashTarget.PrintShellCommands(&script)
+		if err != nil {
+			return fmt.Errorf("error building shell commands: %v", err)
+		}
+		if c.Out != "" {
+			err = ioutil.WriteFile(c.Out, script.Bytes(), 0755)
+			if err != nil {
+				return fmt.Errorf("error writing script to %q: %v", c.Out, err)
+			}
+			fmt.Printf("\n\nScript written to %s\n", c.Out)
+		} else {
+			_, err = script.WriteTo(os.Stdout)
+			if err != nil {
+				return err
+			}
+		}
+	} else if dryRunTarget != nil {
+		if c.Out != "" {
+			err = c.savePlan(cc, dryRunTarget)
+			if err != nil {
+				return err
+			}
+		}
+		if c.Output == "text" {
+			err = dryRunTarget.PrintReport(os.Stdout)
+		} else {
+			err = dryRunTarget.PrintPlan(os.Stdout, c.Output)
+		}
+		if err != nil {
+			glog.Fatal("error printing dry-run report: %v", err)
+		}
+		if c.Out != "" {
+			fmt.Printf("\n\nPlan written to %s; apply it with: kope apply %s\n", c.Out, c.Out)
+		}
+	} else if c.Out != "" {
+		fmt.Printf("\n\nOutput written to %s\n", c.Out)
+	} else {
+		fmt.Printf("\n\nDone\n")
+	}
+	return nil
+}
+
+// generatedConfig holds the values that are generated (or looked up) when the cluster configuration is built,
+// so that applying a saved plan uses exactly the same values as the dry-run
+type generatedConfig struct {
+	ImageID        string `json:"imageID"`
+	KubePassword   string `json:"kubePassword"`
+	KubeletToken   string `json:"kubeletToken"`
+	KubeProxyToken string `json:"kubeProxyToken"`
+}
+
+type clusterConfig struct {
+	k         *k8sunits.K8s
+	cloud     fi.Cloud
+	filestore fi.FileStore
+	castore   fi.CAStore
 }
 
-func (c*CreateClusterCmd) Run() error {
-	k := &awsunits.K8s{}
+// buildCluster loads the configuration for the cluster; if generated is not nil those values are used
+// in place of generating new ones
+func (c *CreateClusterCmd) buildCluster(generated *generatedConfig) (*clusterConfig, error) {
+	k := &k8sunits.K8s{}
 	k.Init()
 
 	k.ClusterID = c.ClusterID
@@ -62,12 +256,12 @@ func (c*CreateClusterCmd) Run() error {
 	if c.SSHKey != "" {
 		buffer, err := ioutil.ReadFile(c.SSHKey)
 		if err != nil {
-			return fmt.Errorf("error reading SSH key file %q: %v", c.SSHKey, err)
+			return nil, fmt.Errorf("error reading SSH key file %q: %v", c.SSHKey, err)
 		}
 
 		privateKey, err := ssh.ParsePrivateKey(buffer)
 		if err != nil {
-			return fmt.Errorf("error parsing key file %q: %v", c.SSHKey, err)
+			return nil, fmt.Errorf("error parsing key file %q: %v", c.SSHKey, err)
 		}
 
 		publicKey := privateKey.PublicKey()
@@ -76,72 +270,142 @@ func (c*CreateClusterCmd) Run() error {
 		k.SSHPublicKey = fi.NewStringResource(string(authorized))
 	}
 
-	if c.StateDir == "" {
-		return fmt.Errorf("state dir is required")
+	if c.ReleaseDir == "" {
+		return nil, fmt.Errorf("release dir is required")
 	}
 
-	if c.ReleaseDir == "" {
-		return fmt.Errorf("release dir is required")
+	stateStore, err := c.buildStateStore()
+	if err != nil {
+		return nil, err
 	}
 
 	{
-		confFile := path.Join(c.StateDir, "kubernetes.yaml")
-		b, err := ioutil.ReadFile(confFile)
+		confFile := stateStore.Location("kubernetes.yaml")
+		b, err := stateStore.ReadFile("kubernetes.yaml")
 		if err != nil {
-			if !os.IsNotExist(err) {
-				return fmt.Errorf("error loading state file %q: %v", confFile, err)
-			}
+			return nil, fmt.Errorf("error loading state file: %v", err)
 		}
 		glog.Infof("Loading state from %q", confFile)
 		err = k.MergeState(b)
 		if err != nil {
-			return fmt.Errorf("error parsing state file %q: %v", confFile, err)
+			return nil, fmt.Errorf("error parsing state file %q: %v", confFile, err)
 		}
 	}
 
+	if generated != nil {
+		k.ImageID = generated.ImageID
+		k.KubePassword = generated.KubePassword
+		k.KubeletToken = generated.KubeletToken
+		k.KubeProxyToken = generated.KubeProxyToken
+	}
+
+	if c.CloudProvider != "" {
+		k.CloudProvider = c.CloudProvider
+	}
+	k.SetCloudDefaults()
+
 	if k.SSHPublicKey == nil {
 		// TODO: Implement the generation logic
-		return fmt.Errorf("ssh key is required (for now!).  Specify with -i")
+		return nil, fmt.Errorf("ssh key is required (for now!).  Specify with -i")
 	}
 
 	k.ServerBinaryTar = fi.NewFileResource(path.Join(c.ReleaseDir, "server/kubernetes-server-linux-amd64.tar.gz"))
 	k.SaltTar = fi.NewFileResource(path.Join(c.ReleaseDir, "server/kubernetes-salt.tar.gz"))
 
-	k.MasterRoleDocument = fi.NewFileResource(path.Join(c.ReleaseDir, "cluster/aws/templates/iam/kubernetes-master-role.json"))
-	k.MasterRolePolicy = fi.NewFileResource(path.Join(c.ReleaseDir, "cluster/aws/templates/iam/kubernetes-master-policy.json"))
+	var bootstrapScript string
+	switch k.CloudProvider {
+	case "aws":
+		k.MasterRoleDocument = fi.NewFileResource(path.Join(c.ReleaseDir, "cluster/aws/templates/iam/kubernetes-master-role.json"))
+		k.MasterRolePolicy = fi.NewFileResource(path.Join(c.ReleaseDir, "cluster/aws/templates/iam/kubernetes-master-policy.json"))
 
-	k.NodeRoleDocument = fi.NewFileResource(path.Join(c.ReleaseDir, "cluster/aws/templates/iam/kubernetes-minion-role.json"))
-	k.NodeRolePolicy = fi.NewFileResource(path.Join(c.ReleaseDir, "cluster/aws/templates/iam/kubernetes-minion-policy.json"))
+		k.NodeRoleDocument = fi.NewFileResource(path.Join(c.ReleaseDir, "cluster/aws/templates/iam/kubernetes-minion-role.json"))
+		k.NodeRolePolicy = fi.NewFileResource(path.Join(c.ReleaseDir, "cluster/aws/templates/iam/kubernetes-minion-policy.json"))
 
-	bootstrapScript, err := buildAWSBootstrapScript(c.ReleaseDir)
+		bootstrapScript, err = buildAWSBootstrapScript(c.ReleaseDir)
+	case "gce":
+		bootstrapScript, err = buildGCEBootstrapScript(c.ReleaseDir)
+	default:
+		return nil, fmt.Errorf("unsupported CloudProvider %q", k.CloudProvider)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	k.BootstrapScript = fi.NewStringResource(bootstrapScript)
 
-	glog.V(4).Infof("Configuration is %s", awsunits.DebugPrint(k))
+	glog.V(4).Infof("Configuration is %s", units.DebugPrint(k))
 
 	if k.ClusterID == "" {
-		return fmt.Errorf("ClusterID is required")
+		return nil, fmt.Errorf("ClusterID is required")
 	}
 
+	var cloud fi.Cloud
+	if k.CloudProvider == "gce" {
+		cloud, err = c.buildGCECloud(k)
+	} else {
+		cloud, err = c.buildAWSCloud(k)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var filestore fi.FileStore
+	s3Prefix := kutil.ArtifactsPrefix(k.ClusterID)
+	if c.ArtifactsDir != "" {
+		if c.ArtifactsURL == "" {
+			return nil, fmt.Errorf("--artifacts-url is required with --artifacts-dir")
+		}
+		filestore, err = fi.NewFilesystemFileStore(c.ArtifactsDir, c.ArtifactsURL, s3Prefix)
+	} else {
+		switch cloud := cloud.(type) {
+		case *fi.GCECloud:
+			filestore, err = c.buildGCSFileStore(k, cloud, s3Prefix)
+		case *fi.AWSCloud:
+			filestore, err = c.buildS3FileStore(cloud, s3Prefix)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keyManager, err := buildKeyManager(c.KeyManager)
+	if err != nil {
+		return nil, err
+	}
+
+	castore, err := fi.NewCAStore(stateStore, "pki", fi.KeyAlgorithm(k.KeyAlgorithm), keyManager)
+	if err != nil {
+		return nil, fmt.Errorf("error building CA store: %v", err)
+	}
+
+	return &clusterConfig{
+		k:         k,
+		cloud:     cloud,
+		filestore: filestore,
+		castore:   castore,
+	}, nil
+}
+
+func (c *CreateClusterCmd) buildAWSCloud(k *k8sunits.K8s) (*fi.AWSCloud, error) {
 	az := k.Zone
 	if len(az) <= 2 {
-		return fmt.Errorf("Invalid AZ: ", az)
-	}
-	region := az[:len(az) - 1]
-	if c.S3Region == "" {
-		c.S3Region = region
+		return nil, fmt.Errorf("Invalid AZ: %s", az)
 	}
+	region := az[:len(az)-1]
 
 	tags := map[string]string{"KubernetesCluster": k.ClusterID}
-	cloud := fi.NewAWSCloud(region, tags)
+	return NewAWSCloud(region, tags), nil
+}
+
+func (c *CreateClusterCmd) buildS3FileStore(cloud *fi.AWSCloud, prefix string) (fi.FileStore, error) {
+	if c.S3Region == "" {
+		c.S3Region = cloud.Region
+	}
 
 	if c.S3Bucket == "" {
 		b, err := kutil.GetDefaultS3Bucket(cloud)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		glog.Infof("Using default S3 bucket: %s", b)
 		c.S3Bucket = b
@@ -149,71 +413,130 @@ func (c*CreateClusterCmd) Run() error {
 
 	s3Bucket, err := cloud.S3.EnsureBucket(c.S3Bucket, c.S3Region)
 	if err != nil {
-		return fmt.Errorf("error creating s3 bucket: %v", err)
+		return nil, fmt.Errorf("error creating s3 bucket: %v", err)
 	}
-	s3Prefix := "devel/" + k.ClusterID + "/"
-	filestore := fi.NewS3FileStore(s3Bucket, s3Prefix)
-	castore, err := fi.NewCAStore(path.Join(c.StateDir, "pki"))
-	if err != nil {
-		return fmt.Errorf("error building CA store: %v", err)
+	filestore := fi.NewS3FileStore(s3Bucket, prefix)
+	filestore.Private = c.S3Private
+	filestore.PresignExpiry = c.S3URLExpiry
+	return filestore, nil
+}
+
+func (c *CreateClusterCmd) buildGCECloud(k *k8sunits.K8s) (*fi.GCECloud, error) {
+	// GCE zones are the region with a suffix, e.g. us-central1-b
+	zone := k.Zone
+	lastDash := strings.LastIndex(zone, "-")
+	if lastDash <= 0 {
+		return nil, fmt.Errorf("Invalid zone: %s", zone)
 	}
+	region := zone[:lastDash]
 
-	var target fi.Target
-	var bashTarget *fi.BashTarget
-	var dryRunTarget *fi.DryRunTarget
+	if c.Project != "" {
+		k.GCEProject = c.Project
+	}
+	if k.GCEProject == "" {
+		return nil, fmt.Errorf("project is required for gce.  Specify with --project")
+	}
 
-	switch (c.Target) {
-	case "direct":
-		target = fi.NewAWSAPITarget(cloud, filestore)
-	case "bash":
-		bashTarget, err = fi.NewBashTarget(cloud, filestore, ".")
-		if err != nil {
-			return err
+	labels := map[string]string{"KubernetesCluster": k.ClusterID}
+	return fi.NewGCECloud(region, k.GCEProject, labels)
+}
+
+func (c *CreateClusterCmd) buildGCSFileStore(k *k8sunits.K8s, cloud *fi.GCECloud, prefix string) (fi.FileStore, error) {
+	if c.GCSBucket != "" {
+		k.GCSBucketName = c.GCSBucket
+	}
+	if k.GCSBucketName == "" {
+		k.GCSBucketName = "kope-" + k.GCEProject
+		glog.Infof("Using default GCS bucket: %s", k.GCSBucketName)
+	}
+
+	bucket, err := cloud.EnsureGCSBucket(k.GCSBucketName, cloud.Region)
+	if err != nil {
+		return nil, fmt.Errorf("error creating gcs bucket: %v", err)
+	}
+	filestore := fi.NewGCSFileStore(bucket, prefix)
+	if c.GCSPrivate {
+		if c.GCSSigningKey == "" {
+			return nil, fmt.Errorf("--gcs-signing-key is required with --gcs-private")
 		}
-		target = bashTarget
-	case "dryrun":
-		dryRunTarget, err = fi.NewDryRunTarget()
+		filestore.Signer, err = fi.NewGCSURLSignerFromFile(c.GCSSigningKey)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		target = dryRunTarget
+		filestore.Private = true
+		filestore.SignedURLExpiry = c.GCSURLExpiry
+	}
+	return filestore, nil
+}
+
+// newAPITarget returns the target that applies changes directly to the cloud
+func (cc *clusterConfig) newAPITarget() fi.Target {
+	switch cloud := cc.cloud.(type) {
+	case *fi.GCECloud:
+		return fi.NewGCEAPITarget(cloud, cc.filestore)
+	case *fi.AWSCloud:
+		return fi.NewAWSAPITarget(cloud, cc.filestore)
 	default:
-		return fmt.Errorf("unsupported target type %q", c.Target)
+		glog.Fatalf("unhandled cloud type %T", cloud)
+		return nil
 	}
+}
 
-	context, err := fi.NewContext(cloud, castore)
+// addParallelismFlag registers --parallelism, for the commands that run the units
+func addParallelismFlag(cmd *cobra.Command, parallelism *int) {
+	cmd.Flags().IntVar(parallelism, "parallelism", 4, "Maximum number of units to run concurrently against the cloud (1 runs them one at a time); the other targets always run them one at a time")
+}
+
+// run builds and runs the units for the cluster against the target
+func (cc *clusterConfig) run(target fi.Target, runMode fi.RunMode, parallelism int) (*fi.RunContext, error) {
+	context, err := fi.NewContext(cc.cloud, cc.castore)
 	if err != nil {
-		return fmt.Errorf("error building config: %v", err)
+		return nil, fmt.Errorf("error building config: %v", err)
 	}
 
-	bc := context.NewBuildContext()
-	bc.Add(k)
-
-	runMode := fi.ModeConfigure
-	//if validate {
-	//	runMode = fi.ModeValidate
-	//}
+	bc := context.NewBuildContext(target)
+	bc.Add(cc.k)
 
 	rc := context.NewRunContext(target, runMode)
+	rc.Parallelism = parallelism
 	err = rc.Run()
 	if err != nil {
-		return fmt.Errorf("error running configuration: %v", err)
+		return nil, fmt.Errorf("error running configuration: %v", err)
 	}
 
-	if bashTarget != nil {
-		err = bashTarget.PrintShellCommands(os.Stdout)
+	if declarative, ok := target.(fi.DeclarativeTarget); ok && runMode == fi.ModeConfigure {
+		err = declarative.Finish()
 		if err != nil {
-			glog.Fatal("error building shell commands: %v", err)
+			return nil, fmt.Errorf("error writing output: %v", err)
 		}
-	} else if dryRunTarget != nil {
-		err = dryRunTarget.PrintReport(os.Stdout)
+	}
+
+	if _, isDryRun := target.(*fi.DryRunTarget); runMode == fi.ModeConfigure && !isDryRun {
+		err = cc.castore.EncryptPlaintextKeys()
 		if err != nil {
-			glog.Fatal("error printing dry-run report: %v", err)
+			return nil, err
 		}
-	} else {
-		fmt.Printf("\n\nDone\n")
 	}
-	return nil
+	return rc, nil
+}
+
+func (cc *clusterConfig) generatedConfig() *generatedConfig {
+	return &generatedConfig{
+		ImageID:        cc.k.ImageID,
+		KubePassword:   cc.k.KubePassword,
+		KubeletToken:   cc.k.KubeletToken,
+		KubeProxyToken: cc.k.KubeProxyToken,
+	}
+}
+
+// buildGCEBootstrapScript returns the script that configures GCE instances; it is passed as the startup-script metadata
+func buildGCEBootstrapScript(releaseDir string) (string, error) {
+	p := path.Join(releaseDir, "cluster/gce/configure-vm.sh")
+	gceConfigure, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", fmt.Errorf("error reading script %q: %v", p, err)
+	}
+	return string(gceConfigure), nil
 }
 
 func buildAWSBootstrapScript(releaseDir string) (string, error) {
@@ -256,4 +579,51 @@ func buildAWSBootstrapScript(releaseDir string) (string, error) {
 	}
 
 	return b.String(), nil
-}
\ No newline at end of file
+}
+
+// savePlan writes the dry-run plan to c.Out, along with everything needed to apply it with `kope apply`
+func (c *CreateClusterCmd) savePlan(cc *clusterConfig, dryRunTarget *fi.DryRunTarget) error {
+	plan, err := dryRunTarget.BuildPlan()
+	if err != nil {
+		return err
+	}
+
+	options := *c
+	options.Target = ""
+	options.Out = ""
+	options.Output = ""
+	for _, p := range []*string{&options.StateDir, &options.State, &options.ReleaseDir, &options.SSHKey, &options.ArtifactsDir} {
+		if *p == "" || isStateStoreURL(*p) {
+			continue
+		}
+		abs, err := filepath.Abs(*p)
+		if err != nil {
+			return fmt.Errorf("error resolving path %q: %v", *p, err)
+		}
+		*p = abs
+	}
+	if strings.HasPrefix(options.KeyManager, keyManagerFilePrefix) {
+		abs, err := filepath.Abs(strings.TrimPrefix(options.KeyManager, keyManagerFilePrefix))
+		if err != nil {
+			return fmt.Errorf("error resolving path %q: %v", options.KeyManager, err)
+		}
+		options.KeyManager = keyManagerFilePrefix + abs
+	}
+
+	saved := &savedPlan{
+		Options:   options,
+		Generated: cc.generatedConfig(),
+		Plan:      plan,
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing plan: %v", err)
+	}
+
+	// The plan includes the generated secrets
+	err = ioutil.WriteFile(c.Out, data, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing plan to %q: %v", c.Out, err)
+	}
+	return nil
+}
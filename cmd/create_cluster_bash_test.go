@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/kopeio/kope/pkg/fi"
+)
+
+// createClusterBashGolden is the script generated for the cluster in testdata/create_cluster, against an empty AWS
+const createClusterBashGolden = "testdata/create_cluster.sh"
+
+// bashMutatingCommand matches the AWS CLI commands in the script that create or change resources
+var bashMutatingCommand = regexp.MustCompile(`aws (ec2|autoscaling|iam) (create|run|allocate|associate|attach|authorize|put|add|import)-`)
+
+var bashTimestampRegex = regexp.MustCompile(`[0-9]{8}T[0-9]{6}Z`)
+
+// runBashTarget runs create cluster with the bash target, returning the script
+func runBashTarget(t *testing.T, options CreateClusterCmd, dir string) string {
+	scriptFile := path.Join(dir, "create.sh")
+	options.Target = "bash"
+	options.Out = scriptFile
+	err := options.Run()
+	if err != nil {
+		t.Fatalf("error generating script: %v", err)
+	}
+	script, err := ioutil.ReadFile(scriptFile)
+	if err != nil {
+		t.Fatalf("error reading script: %v", err)
+	}
+	return string(script)
+}
+
+// checkBashCreatesGuarded checks that every command that creates a resource is guarded by a lookup, so the
+// script can be re-run
+func checkBashCreatesGuarded(t *testing.T, script string) {
+	lines := strings.Split(script, "\n")
+	inFunction := false
+	for i, line := range lines {
+		// Skip the helper functions
+		if strings.HasPrefix(line, "function ") {
+			inFunction = true
+		} else if line == "}" {
+			inFunction = false
+		}
+		if inFunction || !bashMutatingCommand.MatchString(line) {
+			continue
+		}
+		if i == 0 || !strings.HasPrefix(lines[i-1], "if ") || !strings.HasPrefix(line, "  ") {
+			t.Errorf("command is not guarded: %s", line)
+		}
+	}
+}
+
+func TestCreateClusterBash(t *testing.T) {
+	defer func(original func(string, map[string]string) *fi.AWSCloud) {
+		NewAWSCloud = original
+	}(NewAWSCloud)
+
+	// The state in testdata has fixed keys & certificates, so the script is the same on every run
+	dir, err := ioutil.TempDir("", "kope-test")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	copyTestFiles(t, createClusterGoldenDir, dir)
+	os.Remove(path.Join(dir, "aws.json"))
+
+	f := newTestFakeAWS()
+	NewAWSCloud = f.NewCloud
+
+	script := runBashTarget(t, testCreateClusterCmd(dir), dir)
+	script = bashTimestampRegex.ReplaceAllString(script, "<timestamp>")
+
+	if f.EC2.Count("instance") != 0 {
+		t.Fatalf("generating the script created instances")
+	}
+	checkBashCreatesGuarded(t, script)
+
+	if *updateGolden {
+		err = ioutil.WriteFile(createClusterBashGolden, []byte(script), 0644)
+		if err != nil {
+			t.Fatalf("error writing %q: %v", createClusterBashGolden, err)
+		}
+	}
+	expected, err := ioutil.ReadFile(createClusterBashGolden)
+	if err != nil {
+		t.Fatalf("error reading %q: %v", createClusterBashGolden, err)
+	}
+	if script != string(expected) {
+		t.Fatalf("unexpected script\nexpected:\n%s\nactual:\n%s\n(if the change is intended, run the test with -update)", expected, script)
+	}
+}
+
+// TestCreateClusterBashNewCluster generates the script for a cluster with no certificates, so the master certificate
+// is issued before the master IP is allocated
+func TestCreateClusterBashNewCluster(t *testing.T) {
+	defer func(original func(string, map[string]string) *fi.AWSCloud) {
+		NewAWSCloud = original
+	}(NewAWSCloud)
+
+	c := newTestCluster(t)
+	defer c.Close()
+
+	script := runBashTarget(t, c.options(), c.dir)
+	checkBashCreatesGuarded(t, script)
+
+	// The next run against the cloud reissues the certificate with the IP
+	create := c.options()
+	err := create.Run()
+	if err != nil {
+		t.Fatalf("error creating cluster: %v", err)
+	}
+	validate := &ValidateClusterCmd{Options: c.options(), Output: "text"}
+	err = validate.Run()
+	if err != nil {
+		t.Fatalf("expected no drift after create cluster, got: %v", err)
+	}
+}
+
+// TestCreateClusterBashPartialCluster generates the script for a cluster where only some resources are missing;
+// the script must look up the existing resources that the missing ones reference
+func TestCreateClusterBashPartialCluster(t *testing.T) {
+	defer func(original func(string, map[string]string) *fi.AWSCloud) {
+		NewAWSCloud = original
+	}(NewAWSCloud)
+
+	c := newTestCluster(t)
+	defer c.Close()
+
+	create := c.options()
+	err := create.Run()
+	if err != nil {
+		t.Fatalf("error creating cluster: %v", err)
+	}
+
+	cloud := c.fake.NewCloud("us-east-1", nil)
+	igws, err := cloud.EC2.DescribeInternetGateways(&ec2.DescribeInternetGatewaysInput{})
+	if err != nil {
+		t.Fatalf("error listing internet gateways: %v", err)
+	}
+	for _, igw := range igws.InternetGateways {
+		for _, attachment := range igw.Attachments {
+			_, err = cloud.EC2.DetachInternetGateway(&ec2.DetachInternetGatewayInput{InternetGatewayId: igw.InternetGatewayId, VpcId: attachment.VpcId})
+			if err != nil {
+				t.Fatalf("error detaching internet gateway: %v", err)
+			}
+		}
+		_, err = cloud.EC2.DeleteInternetGateway(&ec2.DeleteInternetGatewayInput{InternetGatewayId: igw.InternetGatewayId})
+		if err != nil {
+			t.Fatalf("error deleting internet gateway: %v", err)
+		}
+	}
+
+	script := runBashTarget(t, c.options(), c.dir)
+	checkBashCreatesGuarded(t, script)
+
+	for _, expected := range []string{
+		// The VPC is unchanged, so is looked up for the attachment
+		"aws ec2 describe-vpcs --filters",
+		"aws ec2 create-internet-gateway",
+		"aws ec2 attach-internet-gateway",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("expected script to contain %q:\n%s", expected, script)
+		}
+	}
+	if strings.Contains(script, "aws ec2 create-vpc") {
+		t.Errorf("expected script not to create the VPC:\n%s", script)
+	}
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/kopeio/kope/pkg/fi"
+)
+
+var updateGolden = flag.Bool("update", false, "Record the AWS calls in testdata again, against the fake AWS")
+
+// testdata/create_cluster holds the files for a cluster (the state, including the keys, is fixed so that the
+// user-data is the same on every run), and aws.json, the AWS calls made by create cluster
+const createClusterGoldenDir = "testdata/create_cluster"
+
+// TestCreateClusterReplay checks that create cluster makes exactly the recorded AWS calls
+func TestCreateClusterReplay(t *testing.T) {
+	defer func(original func(string, map[string]string) *fi.AWSCloud) {
+		NewAWSCloud = original
+	}(NewAWSCloud)
+
+	if *updateGolden {
+		recordCreateCluster(t)
+	}
+
+	// create cluster may write to the state, so we run from a copy
+	dir, err := ioutil.TempDir("", "kope-test")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	copyTestFiles(t, createClusterGoldenDir, dir)
+
+	replayer, err := fi.NewAWSReplayer(path.Join(dir, "aws.json"))
+	if err != nil {
+		t.Fatalf("error loading recording: %v", err)
+	}
+	config := replayer.Config()
+	NewAWSCloud = func(region string, tags map[string]string) *fi.AWSCloud {
+		return fi.NewAWSCloudWithConfig(region, tags, config)
+	}
+
+	create := testCreateClusterCmd(dir)
+	err = create.Run()
+	if err != nil {
+		t.Fatalf("error creating cluster: %v", err)
+	}
+	err = replayer.Verify()
+	if err != nil {
+		t.Fatalf("%v\n(if the change is intended, run the test with -update)", err)
+	}
+}
+
+// recordCreateCluster creates the cluster against the fake AWS, recording the calls into testdata
+func recordCreateCluster(t *testing.T) {
+	err := os.RemoveAll(createClusterGoldenDir)
+	if err != nil {
+		t.Fatalf("error removing %q: %v", createClusterGoldenDir, err)
+	}
+	writeTestClusterFiles(t, createClusterGoldenDir)
+
+	f := newTestFakeAWS()
+	recorder := fi.NewAWSRecorderWithTransport(path.Join(createClusterGoldenDir, "aws.json"), f.Transport())
+	config := recorder.Config()
+	NewAWSCloud = func(region string, tags map[string]string) *fi.AWSCloud {
+		return f.NewHTTPCloud(region, tags, config)
+	}
+
+	create := testCreateClusterCmd(createClusterGoldenDir)
+	err = create.Run()
+	if err != nil {
+		t.Fatalf("error creating cluster while recording: %v", err)
+	}
+}
+
+func copyTestFiles(t *testing.T, src string, dest string) {
+	err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := path.Join(dest, relative)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, info.Mode())
+	})
+	if err != nil {
+		t.Fatalf("error copying %q: %v", src, err)
+	}
+}
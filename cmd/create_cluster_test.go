@@ -0,0 +1,279 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/kopeio/kope/pkg/fakeaws"
+	"github.com/kopeio/kope/pkg/fi"
+)
+
+const testClusterID = "testcluster"
+const testS3Bucket = "testcluster-artifacts"
+
+// testCluster holds the directories for a cluster created against a fake AWS
+type testCluster struct {
+	dir  string
+	fake *fakeaws.FakeAWS
+}
+
+// newTestCluster builds a state dir, release dir & SSH key in a temporary directory, and points NewAWSCloud at a new fake;
+// callers must restore NewAWSCloud
+func newTestCluster(t *testing.T) *testCluster {
+	dir, err := ioutil.TempDir("", "kope-test")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	writeTestClusterFiles(t, dir)
+
+	f := newTestFakeAWS()
+	NewAWSCloud = f.NewCloud
+
+	return &testCluster{dir: dir, fake: f}
+}
+
+// newTestFakeAWS builds a fake AWS with the images that create cluster uses
+func newTestFakeAWS() *fakeaws.FakeAWS {
+	f := fakeaws.New()
+	f.EC2.AddImage("721322707521", "k8s-1.2-debian-jessie-amd64-hvm-2016-03-05-ebs")
+	return f
+}
+
+// writeTestClusterFiles writes the initial state dir, a minimal release dir & an SSH key into dir
+func writeTestClusterFiles(t *testing.T, dir string) {
+	files := map[string]string{
+		"state/kubernetes.yaml":                                           "Zone: us-east-1b\nKubePassword: password\nKubeletToken: kubelet-token\nKubeProxyToken: kube-proxy-token\n",
+		"release/server/kubernetes-server-linux-amd64.tar.gz":             "server",
+		"release/server/kubernetes-salt.tar.gz":                           "salt",
+		"release/cluster/gce/configure-vm.sh":                             "#!/bin/bash\n#+AWS_OVERRIDES_HERE\necho done\n",
+		"release/cluster/aws/templates/configure-vm-aws.sh":               "# aws\n",
+		"release/cluster/aws/templates/format-disks.sh":                   "# disks\n",
+		"release/cluster/aws/templates/iam/kubernetes-master-role.json":   "{}",
+		"release/cluster/aws/templates/iam/kubernetes-master-policy.json": "{}",
+		"release/cluster/aws/templates/iam/kubernetes-minion-role.json":   "{}",
+		"release/cluster/aws/templates/iam/kubernetes-minion-policy.json": "{}",
+	}
+
+	sshKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating SSH key: %v", err)
+	}
+	files["id_rsa"] = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(sshKey)}))
+
+	for name, contents := range files {
+		p := path.Join(dir, name)
+		err := os.MkdirAll(path.Dir(p), 0755)
+		if err != nil {
+			t.Fatalf("error creating directory: %v", err)
+		}
+		err = ioutil.WriteFile(p, []byte(contents), 0600)
+		if err != nil {
+			t.Fatalf("error writing %q: %v", p, err)
+		}
+	}
+}
+
+func (c *testCluster) Close() {
+	os.RemoveAll(c.dir)
+}
+
+func (c *testCluster) options() CreateClusterCmd {
+	return testCreateClusterCmd(c.dir)
+}
+
+// testCreateClusterCmd builds the options to create the test cluster from the files in dir
+func testCreateClusterCmd(dir string) CreateClusterCmd {
+	return CreateClusterCmd{
+		ClusterID:   testClusterID,
+		S3Bucket:    testS3Bucket,
+		SSHKey:      path.Join(dir, "id_rsa"),
+		StateDir:    path.Join(dir, "state"),
+		ReleaseDir:  path.Join(dir, "release"),
+		Target:      "direct",
+		Output:      "text",
+		Parallelism: 1,
+	}
+}
+
+func TestCreateUpdateDeleteCluster(t *testing.T) {
+	defer func(original func(string, map[string]string) *fi.AWSCloud) {
+		NewAWSCloud = original
+	}(NewAWSCloud)
+
+	c := newTestCluster(t)
+	defer c.Close()
+
+	create := c.options()
+	err := create.Run()
+	if err != nil {
+		t.Fatalf("error creating cluster: %v", err)
+	}
+
+	if c.fake.EC2.Count("instance") != 1 {
+		t.Fatalf("expected the master instance to be created, found %d instances", c.fake.EC2.Count("instance"))
+	}
+	created := c.fake.Resources()
+
+	// Running create again should change nothing; a changed launch configuration would be created with a new name
+	recreate := c.options()
+	err = recreate.Run()
+	if err != nil {
+		t.Fatalf("error re-running create cluster: %v", err)
+	}
+	recreated := c.fake.Resources()
+	if strings.Join(created, "\n") != strings.Join(recreated, "\n") {
+		t.Fatalf("re-running create cluster changed the resources\nbefore:\n%s\nafter:\n%s", strings.Join(created, "\n"), strings.Join(recreated, "\n"))
+	}
+
+	deleteRetryInterval = 0
+	d := &DeleteClusterCmd{ClusterID: testClusterID, Zone: "us-east-1b", S3Bucket: testS3Bucket, Yes: true}
+	err = d.Run()
+	if err != nil {
+		t.Fatalf("error deleting cluster: %v", err)
+	}
+
+	// The IAM roles & SSH key are named independently of the cluster and shared between clusters, so are not deleted
+	var remaining []string
+	for _, r := range c.fake.Resources() {
+		if strings.HasPrefix(r, "role ") || strings.HasPrefix(r, "instance-profile ") || strings.HasPrefix(r, "keypair ") {
+			continue
+		}
+		remaining = append(remaining, r)
+	}
+	if len(remaining) != 0 {
+		t.Fatalf("resources remain after delete cluster:\n%s", strings.Join(remaining, "\n"))
+	}
+}
+
+// planAfterCreateGolden is the json plan for a cluster that was just created: no changes, only the resources
+// (which are not uploaded again)
+const planAfterCreateGolden = "testdata/plan_after_create.json"
+
+func TestPlanAfterCreateCluster(t *testing.T) {
+	defer func(original func(string, map[string]string) *fi.AWSCloud) {
+		NewAWSCloud = original
+	}(NewAWSCloud)
+
+	c := newTestCluster(t)
+	defer c.Close()
+
+	create := c.options()
+	err := create.Run()
+	if err != nil {
+		t.Fatalf("error creating cluster: %v", err)
+	}
+
+	planFile := path.Join(c.dir, "plan.json")
+	dryrun := c.options()
+	dryrun.Target = "dryrun"
+	dryrun.Output = fi.PlanFormatJSON
+	dryrun.Out = planFile
+	err = dryrun.Run()
+	if err != nil {
+		t.Fatalf("error planning cluster: %v", err)
+	}
+
+	saved := readSavedPlan(t, planFile)
+	var actual bytes.Buffer
+	err = fi.WritePlan(&actual, saved.Plan, fi.PlanFormatJSON)
+	if err != nil {
+		t.Fatalf("error writing plan: %v", err)
+	}
+
+	if *updateGolden {
+		err = ioutil.WriteFile(planAfterCreateGolden, actual.Bytes(), 0644)
+		if err != nil {
+			t.Fatalf("error writing %q: %v", planAfterCreateGolden, err)
+		}
+	}
+	expected, err := ioutil.ReadFile(planAfterCreateGolden)
+	if err != nil {
+		t.Fatalf("error reading %q: %v", planAfterCreateGolden, err)
+	}
+	if actual.String() != string(expected) {
+		t.Fatalf("unexpected plan after create cluster\nexpected:\n%s\nactual:\n%s\n(if the change is intended, run the test with -update)", expected, actual.String())
+	}
+}
+
+// readSavedPlan loads a plan saved by create cluster --target=dryrun --out
+func readSavedPlan(t *testing.T, p string) *savedPlan {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatalf("error reading plan %q: %v", p, err)
+	}
+	saved := &savedPlan{}
+	err = json.Unmarshal(data, saved)
+	if err != nil {
+		t.Fatalf("error parsing plan %q: %v", p, err)
+	}
+	return saved
+}
+
+func TestCreateClusterPrivateS3(t *testing.T) {
+	defer func(original func(string, map[string]string) *fi.AWSCloud) {
+		NewAWSCloud = original
+	}(NewAWSCloud)
+
+	c := newTestCluster(t)
+	defer c.Close()
+
+	create := c.options()
+	create.S3Private = true
+	create.S3URLExpiry = fi.MaxPresignExpiry
+	err := create.Run()
+	if err != nil {
+		t.Fatalf("error creating cluster: %v", err)
+	}
+
+	userData := c.masterUserData(t)
+	var wget string
+	for _, line := range strings.Split(userData, "\n") {
+		if strings.HasPrefix(line, "wget ") {
+			wget = line
+		}
+	}
+	// The presigned URL has several query parameters, so must be quoted or bash would stop at the first &
+	if !strings.Contains(wget, "X-Amz-Signature=") || !strings.HasPrefix(wget, "wget -O bootstrap '") || !strings.HasSuffix(wget, "'") {
+		t.Fatalf("expected wget of a quoted presigned URL, got %q", wget)
+	}
+}
+
+// masterUserData returns the decoded user-data of the master instance
+func (c *testCluster) masterUserData(t *testing.T) string {
+	cloud := c.fake.NewCloud("us-east-1", nil)
+	instances, err := cloud.EC2.DescribeInstances(&ec2.DescribeInstancesInput{})
+	if err != nil {
+		t.Fatalf("error listing instances: %v", err)
+	}
+	if len(instances.Reservations) != 1 {
+		t.Fatalf("expected one instance, found %d", len(instances.Reservations))
+	}
+	id := instances.Reservations[0].Instances[0].InstanceId
+	attr, err := cloud.EC2.DescribeInstanceAttribute(&ec2.DescribeInstanceAttributeInput{InstanceId: id, Attribute: aws.String("userData")})
+	if err != nil {
+		t.Fatalf("error getting instance user-data: %v", err)
+	}
+	userData, err := base64.StdEncoding.DecodeString(aws.StringValue(attr.UserData.Value))
+	if err != nil {
+		t.Fatalf("error decoding user-data: %v", err)
+	}
+	if bytes.HasPrefix(userData, []byte{0x1f, 0x8b}) {
+		userData, err = fi.GunzipBytes(userData)
+		if err != nil {
+			t.Fatalf("error decompressing user-data: %v", err)
+		}
+	}
+	return string(userData)
+}
@@ -3,10 +3,9 @@ package cmd
 import (
 	"fmt"
 
-	"github.com/spf13/cobra"
 	"github.com/golang/glog"
-	"github.com/kopeio/kope/pkg/fi"
 	"github.com/kopeio/kope/pkg/kutil"
+	"github.com/spf13/cobra"
 	"time"
 )
 
@@ -14,16 +13,20 @@ type DeleteClusterCmd struct {
 	ClusterID string
 	Yes       bool
 	Zone      string
+	S3Bucket  string
 }
 
 var deleteCluster DeleteClusterCmd
 
+// deleteRetryInterval is how long we wait before retrying the resources that could not yet be deleted
+var deleteRetryInterval = 10 * time.Second
+
 func init() {
 	cmd := &cobra.Command{
 		Use:   "cluster",
 		Short: "Delete cluster",
-		Long: `Deletes a k8s cluster.`,
-		Run: func(cmd *cobra.Command, args[]string) {
+		Long:  `Deletes a k8s cluster.`,
+		Run: func(cmd *cobra.Command, args []string) {
 			err := deleteCluster.Run()
 			if err != nil {
 				glog.Exitf("%v", err)
@@ -37,9 +40,10 @@ func init() {
 
 	cmd.Flags().StringVar(&deleteCluster.ClusterID, "cluster-id", "", "cluster id")
 	cmd.Flags().StringVar(&deleteCluster.Zone, "zone", "", "zone")
+	cmd.Flags().StringVar(&deleteCluster.S3Bucket, "s3-bucket", "", "S3 bucket holding the cluster artifacts (default: the bucket create uses by default)")
 }
 
-func (c*DeleteClusterCmd) Run() error {
+func (c *DeleteClusterCmd) Run() error {
 	if c.Zone == "" {
 		return fmt.Errorf("--zone is required")
 	}
@@ -49,12 +53,12 @@ func (c*DeleteClusterCmd) Run() error {
 
 	az := c.Zone
 	if len(az) <= 2 {
-		return fmt.Errorf("Invalid AZ: ", az)
+		return fmt.Errorf("Invalid AZ: %s", az)
 	}
-	region := az[:len(az) - 1]
+	region := az[:len(az)-1]
 
 	tags := map[string]string{"KubernetesCluster": c.ClusterID}
-	cloud := fi.NewAWSCloud(region, tags)
+	cloud := NewAWSCloud(region, tags)
 
 	d := &kutil.DeleteCluster{}
 
@@ -62,7 +66,15 @@ func (c*DeleteClusterCmd) Run() error {
 	d.Zone = c.Zone
 	d.Cloud = cloud
 
-	glog.Infof("TODO: S3 bucket removal")
+	if c.S3Bucket == "" {
+		b, err := kutil.GetDefaultS3Bucket(cloud)
+		if err != nil {
+			return err
+		}
+		glog.Infof("Using default S3 bucket: %s", b)
+		c.S3Bucket = b
+	}
+	d.S3Bucket = c.S3Bucket
 
 	resources, err := d.ListResources()
 	if err != nil {
@@ -96,8 +108,8 @@ func (c*DeleteClusterCmd) Run() error {
 		if len(resources) == 0 {
 			break
 		}
-		time.Sleep(10 * time.Second)
+		time.Sleep(deleteRetryInterval)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
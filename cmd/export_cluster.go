@@ -3,19 +3,20 @@ package cmd
 import (
 	"fmt"
 
-	"github.com/spf13/cobra"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
 	"github.com/kopeio/kope/pkg/kutil"
-	"path"
-	"strings"
-	"strconv"
+	"github.com/kopeio/kope/pkg/units/k8sunits"
+	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
-	"encoding/json"
-	"github.com/kopeio/kope/pkg/fi"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/kopeio/kope/pkg/units/awsunits"
+	"path"
+	"strconv"
+	"strings"
 )
 
 type ExportClusterCmd struct {
@@ -31,8 +32,8 @@ func init() {
 	cmd := &cobra.Command{
 		Use:   "cluster",
 		Short: "export cluster configuration",
-		Long: `Connects to your master server over SSH, and exports the configuration from the settings.`,
-		Run: func(cmd *cobra.Command, args[]string) {
+		Long:  `Connects to your master server over SSH, and exports the configuration from the settings.`,
+		Run: func(cmd *cobra.Command, args []string) {
 			err := exportClusterCmd.Run()
 			if err != nil {
 				glog.Exitf("%v", err)
@@ -48,7 +49,7 @@ func init() {
 	cmd.Flags().StringVarP(&exportClusterCmd.DestDir, "dest", "d", "", "Destination directory")
 }
 
-func (c*ExportClusterCmd) Run() error {
+func (c *ExportClusterCmd) Run() error {
 	if c.Master == "" {
 		return fmt.Errorf("--master must be specified")
 	}
@@ -88,7 +89,7 @@ func (c*ExportClusterCmd) Run() error {
 		return fmt.Errorf("cannot determine INSTANCE_PREFIX")
 	}
 
-	k8s := &awsunits.K8s{}
+	k8s := &k8sunits.K8s{}
 	k8s.CloudProvider = "aws"
 	k8s.ClusterID = instancePrefix
 
@@ -125,8 +126,6 @@ func (c*ExportClusterCmd) Run() error {
 	}
 	k8s.VPCID = &vpcID
 
-
-
 	// We want to upgrade!
 	// k8s.ImageId = ""
 
@@ -182,11 +181,11 @@ func (c*ExportClusterCmd) Run() error {
 
 	az := k8s.Zone
 	if len(az) <= 2 {
-		return fmt.Errorf("Invalid AZ: ", az)
+		return fmt.Errorf("Invalid AZ: %s", az)
 	}
-	region := az[:len(az) - 1]
+	region := az[:len(az)-1]
 	tags := map[string]string{"KubernetesCluster": k8s.ClusterID}
-	cloud := fi.NewAWSCloud(region, tags)
+	cloud := NewAWSCloud(region, tags)
 
 	masterInstance, err := cloud.DescribeInstance(masterInstanceID)
 	if err != nil {
@@ -213,7 +212,7 @@ func (c*ExportClusterCmd) Run() error {
 		return err
 	}
 	if igw == nil {
-		return fmt.Errorf("unable to find internet gateway for VPC %q", k8s.VPCID)
+		return fmt.Errorf("unable to find internet gateway for VPC %q", aws.StringValue(k8s.VPCID))
 	}
 	k8s.InternetGatewayID = igw.InternetGatewayId
 
@@ -222,11 +221,10 @@ func (c*ExportClusterCmd) Run() error {
 		return err
 	}
 	if rt == nil {
-		return fmt.Errorf("unable to find route table for Subnet %q", k8s.SubnetID)
+		return fmt.Errorf("unable to find route table for Subnet %q", aws.StringValue(k8s.SubnetID))
 	}
 	k8s.RouteTableID = rt.RouteTableId
 
-
 	//b.Context = "aws_" + instancePrefix
 
 	caCertPath := path.Join(c.DestDir, "pki/ca.crt")
@@ -298,7 +296,7 @@ func parseInt(s string) (int, error) {
 	return int(n), nil
 }
 
-func writeConf(p string, k8s *awsunits.K8s) (error) {
+func writeConf(p string, k8s *k8sunits.K8s) error {
 	jsonBytes, err := json.Marshal(k8s)
 	if err != nil {
 		return fmt.Errorf("error serializing configuration (json write phase): %v", err)
@@ -376,7 +374,7 @@ func findRouteTable(cloud *fi.AWSCloud, subnetID string) (*ec2.RouteTable, error
 	return rt, nil
 }
 
-func findElasticIP(cloud*fi.AWSCloud, publicIP string) (*ec2.Address, error) {
+func findElasticIP(cloud *fi.AWSCloud, publicIP string) (*ec2.Address, error) {
 	request := &ec2.DescribeAddressesInput{
 		PublicIps: []*string{&publicIP},
 	}
@@ -398,4 +396,4 @@ func findElasticIP(cloud*fi.AWSCloud, publicIP string) (*ec2.Address, error) {
 		return nil, fmt.Errorf("found multiple Addresses matching IP %q", publicIP)
 	}
 	return response.Addresses[0], nil
-}
\ No newline at end of file
+}
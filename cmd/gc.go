@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Garbage collect resources",
+	Long:  `Removes resources that are no longer used by a cluster.`,
+}
+
+func init() {
+	RootCmd.AddCommand(gcCmd)
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/kutil"
+	"github.com/spf13/cobra"
+)
+
+type GCArtifactsCmd struct {
+	ClusterID string
+	Zone      string
+	S3Bucket  string
+	MinAge    time.Duration
+	Yes       bool
+}
+
+var gcArtifacts GCArtifactsCmd
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "artifacts",
+		Short: "Delete unused artifacts",
+		Long: `Deletes the artifacts in S3 that are not referenced by the cluster's instances or launch configurations,
+and aborts the incomplete uploads of artifacts left by a failed create.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := gcArtifacts.Run()
+			if err != nil {
+				glog.Exitf("%v", err)
+			}
+		},
+	}
+
+	gcCmd.AddCommand(cmd)
+
+	cmd.Flags().BoolVar(&gcArtifacts.Yes, "yes", false, "Delete the artifacts; otherwise we only print what would be deleted")
+
+	cmd.Flags().StringVar(&gcArtifacts.ClusterID, "cluster-id", "", "cluster id")
+	cmd.Flags().StringVar(&gcArtifacts.Zone, "zone", "", "zone")
+	cmd.Flags().StringVar(&gcArtifacts.S3Bucket, "s3-bucket", "", "S3 bucket holding the cluster artifacts (default: the bucket create uses by default)")
+	cmd.Flags().DurationVar(&gcArtifacts.MinAge, "min-age", time.Hour, "Artifacts uploaded more recently than this are never deleted")
+}
+
+func (c *GCArtifactsCmd) Run() error {
+	if c.Zone == "" {
+		return fmt.Errorf("--zone is required")
+	}
+	if c.ClusterID == "" {
+		return fmt.Errorf("--cluster-id is required")
+	}
+
+	az := c.Zone
+	if len(az) <= 2 {
+		return fmt.Errorf("Invalid AZ: %s", az)
+	}
+	region := az[:len(az)-1]
+
+	tags := map[string]string{"KubernetesCluster": c.ClusterID}
+	cloud := NewAWSCloud(region, tags)
+
+	if c.S3Bucket == "" {
+		b, err := kutil.GetDefaultS3Bucket(cloud)
+		if err != nil {
+			return err
+		}
+		glog.Infof("Using default S3 bucket: %s", b)
+		c.S3Bucket = b
+	}
+
+	bucket, err := cloud.S3.FindBucketIfExists(c.S3Bucket)
+	if err != nil {
+		return err
+	}
+	if bucket == nil {
+		return fmt.Errorf("S3 bucket %q not found", c.S3Bucket)
+	}
+
+	gc := &kutil.GCArtifacts{
+		ClusterID: c.ClusterID,
+		Cloud:     cloud,
+		Bucket:    bucket,
+		MinAge:    c.MinAge,
+	}
+
+	objects, err := gc.FindUnreferenced()
+	if err != nil {
+		return err
+	}
+
+	uploads, err := gc.FindAbandonedUploads()
+	if err != nil {
+		return err
+	}
+
+	if len(objects) == 0 && len(uploads) == 0 {
+		fmt.Printf("No unreferenced artifacts found\n")
+		return nil
+	}
+
+	if !c.Yes {
+		for _, o := range objects {
+			fmt.Printf("Would delete %s\n", o)
+		}
+		for _, u := range uploads {
+			fmt.Printf("Would abort %s\n", u)
+		}
+		fmt.Printf("Must specify --yes to delete\n")
+		return nil
+	}
+
+	for _, o := range objects {
+		fmt.Printf("Deleting %s\n", o)
+		err := o.Delete()
+		if err != nil {
+			return err
+		}
+	}
+	for _, u := range uploads {
+		fmt.Printf("Aborting %s\n", u)
+		err := u.Abort()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/kutil"
+	"github.com/spf13/cobra"
+)
+
+type RollbackCmd struct {
+	StateDir string
+	Yes      bool
+}
+
+var rollbackCmd RollbackCmd
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "Delete the resources created by a failed run",
+		Long: `Deletes exactly the resources that were created by a failed create, in the reverse order to which they were created.
+Artifacts that the failed create uploaded to S3 are also deleted; artifacts that were already present are kept.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := rollbackCmd.Run()
+			if err != nil {
+				glog.Exitf("%v", err)
+			}
+		},
+	}
+
+	RootCmd.AddCommand(cmd)
+
+	cmd.Flags().StringVarP(&rollbackCmd.StateDir, "dir", "d", "", "Directory to load & store state")
+	cmd.Flags().BoolVar(&rollbackCmd.Yes, "yes", false, "Delete without confirmation")
+}
+
+// journalPath is the location of the journal of created resources, in the state dir
+func journalPath(stateDir string) string {
+	return path.Join(stateDir, "journal.json")
+}
+
+// loadJournal loads the journal for the cluster, so that a run following a failed run adds to the existing journal
+func loadJournal(stateDir string, region string, clusterID string) (*fi.Journal, error) {
+	journal, err := fi.LoadJournal(journalPath(stateDir))
+	if err != nil {
+		return nil, err
+	}
+	if len(journal.Entries) != 0 && (journal.Region != region || journal.ClusterID != clusterID) {
+		return nil, fmt.Errorf("journal %q is for cluster %q in region %q; run kope rollback first", journal.Path(), journal.ClusterID, journal.Region)
+	}
+	journal.Region = region
+	journal.ClusterID = clusterID
+	return journal, nil
+}
+
+func (c *RollbackCmd) Run() error {
+	if c.StateDir == "" {
+		return fmt.Errorf("--dir is required")
+	}
+
+	journal, err := fi.LoadJournal(journalPath(c.StateDir))
+	if err != nil {
+		return err
+	}
+	if len(journal.Entries) == 0 {
+		fmt.Printf("No resources to roll back\n")
+		return nil
+	}
+
+	tags := map[string]string{"KubernetesCluster": journal.ClusterID}
+	cloud := NewAWSCloud(journal.Region, tags)
+
+	rollback := &kutil.Rollback{Cloud: cloud, Journal: journal}
+
+	resources, err := rollback.ListResources()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range resources {
+		fmt.Printf("%v\n", r)
+	}
+
+	if !c.Yes {
+		return fmt.Errorf("Must specify --yes to delete")
+	}
+
+	err = rollback.Run()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\n\nDone\n")
+	return nil
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/kopeio/kope/pkg/fi"
+)
+
+// failingRunInstancesEC2 fails to launch instances, so create cluster fails after most resources are created
+type failingRunInstancesEC2 struct {
+	ec2iface.EC2API
+}
+
+func (e *failingRunInstancesEC2) RunInstances(request *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	return nil, awserr.New("InsufficientInstanceCapacity", "no capacity", nil)
+}
+
+// TestCreateClusterRollbackOnFailure checks that rolling back a failed create deletes everything it created,
+// including the artifacts it uploaded
+func TestCreateClusterRollbackOnFailure(t *testing.T) {
+	defer func(original func(string, map[string]string) *fi.AWSCloud) {
+		NewAWSCloud = original
+	}(NewAWSCloud)
+
+	c := newTestCluster(t)
+	defer c.Close()
+
+	NewAWSCloud = func(region string, tags map[string]string) *fi.AWSCloud {
+		cloud := c.fake.NewCloud(region, tags)
+		cloud.EC2 = &failingRunInstancesEC2{EC2API: cloud.EC2}
+		return cloud
+	}
+
+	create := c.options()
+	create.RollbackOnFailure = true
+	err := create.Run()
+	if err == nil {
+		t.Fatalf("expected create cluster to fail")
+	}
+
+	if resources := c.fake.Resources(); len(resources) != 0 {
+		t.Fatalf("expected rollback to delete every resource, found %v", resources)
+	}
+}
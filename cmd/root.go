@@ -1,21 +1,39 @@
 package cmd
 
 import (
+	goflag "flag"
 	"fmt"
 	"os"
-	goflag "flag"
 
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var cfgFile string
 
+// NewAWSCloud builds the cloud used by all the commands; tests replace it with fakeaws.FakeAWS.NewCloud
+var NewAWSCloud = fi.NewAWSCloud
+
+// Golden files to record the AWS API calls to, or to replay them from
+var awsRecord string
+var awsReplay string
+var awsRecorder *fi.AWSRecorder
+
 // This represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "kope",
 	Short: "Kubernetes admin toolbelt",
-	Long: `Toolkit for Kubernetes Administrators`,
+	Long:  `Toolkit for Kubernetes Administrators`,
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		if awsRecorder != nil {
+			err := awsRecorder.Verify()
+			if err != nil {
+				glog.Exitf("%v", err)
+			}
+		}
+	},
 }
 
 func Execute() {
@@ -26,10 +44,35 @@ func Execute() {
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	cobra.OnInitialize(initConfig, initAWSRecorder)
 
 	RootCmd.PersistentFlags().AddGoFlagSet(goflag.CommandLine)
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.kope.yaml)")
+	RootCmd.PersistentFlags().StringVar(&awsRecord, "aws-record", "", "Record the AWS API calls to this golden file")
+	RootCmd.PersistentFlags().StringVar(&awsReplay, "aws-replay", "", "Replay the AWS API calls from this golden file, instead of calling AWS.  Use the same state & --parallelism=1 as the recording")
+}
+
+func initAWSRecorder() {
+	if awsRecord != "" && awsReplay != "" {
+		glog.Exitf("cannot specify both --aws-record and --aws-replay")
+	}
+
+	if awsRecord != "" {
+		awsRecorder = fi.NewAWSRecorder(awsRecord)
+	} else if awsReplay != "" {
+		var err error
+		awsRecorder, err = fi.NewAWSReplayer(awsReplay)
+		if err != nil {
+			glog.Exitf("%v", err)
+		}
+	} else {
+		return
+	}
+
+	config := awsRecorder.Config()
+	NewAWSCloud = func(region string, tags map[string]string) *fi.AWSCloud {
+		return fi.NewAWSCloudWithConfig(region, tags, config)
+	}
 }
 
 func initConfig() {
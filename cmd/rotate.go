@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotate keys",
+	Long:  `Replaces keys and certificates in a running cluster.`,
+}
+
+func init() {
+	RootCmd.AddCommand(rotateCmd)
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/kutil"
+	"github.com/spf13/cobra"
+)
+
+const (
+	rotateCAStart   = "start"
+	rotateCAReissue = "reissue"
+	rotateCAFinish  = "finish"
+)
+
+type RotateCACmd struct {
+	Options CreateClusterCmd
+	Yes     bool
+}
+
+var rotateCA RotateCACmd
+
+func init() {
+	caCmd := &cobra.Command{
+		Use:   "ca",
+		Short: "Rotate the CA",
+		Long: `Replaces the CA, without a period where the cluster components don't trust each other.
+
+Run each step in turn, after the previous step has been rolled out to all the instances:
+
+  start:   generate a new CA, and distribute both the old and new CA certificates
+  reissue: sign with the new CA, and reissue all the certificates from it
+  finish:  stop trusting the old CA`,
+	}
+	rotateCmd.AddCommand(caCmd)
+
+	for _, step := range []string{rotateCAStart, rotateCAReissue, rotateCAFinish} {
+		step := step
+		cmd := &cobra.Command{
+			Use:   step,
+			Short: "CA rotation step: " + step,
+			Run: func(cmd *cobra.Command, args []string) {
+				err := rotateCA.Run(step)
+				if err != nil {
+					glog.Exitf("%v", err)
+				}
+			},
+		}
+		caCmd.AddCommand(cmd)
+
+		options := &rotateCA.Options
+		cmd.Flags().StringVarP(&options.StateDir, "dir", "d", "", "Directory to load & store state")
+		cmd.Flags().StringVar(&options.State, "state", "", "Shared state store, holding the state for each cluster by cluster id (e.g. s3://bucket/prefix, or a local directory)")
+		cmd.Flags().StringVar(&options.StateRegion, "state-region", "", "Region of the S3 bucket holding --state (defaults to $AWS_REGION)")
+		cmd.Flags().StringVar(&options.KeyManager, "key-manager", "", "Encrypt the private keys in the state: passphrase (read from $KOPE_KEY_PASSPHRASE), or file:PATH (a local key file, generated if missing)")
+		cmd.Flags().StringVarP(&options.ReleaseDir, "release", "r", "", "Directory to load release from")
+		cmd.Flags().StringVar(&options.S3Region, "s3-region", "", "Region of the S3 bucket")
+		cmd.Flags().StringVar(&options.S3Bucket, "s3-bucket", "", "S3 bucket for upload of artifacts")
+		cmd.Flags().BoolVar(&options.S3Private, "s3-private", false, "Keep the artifacts in S3 private, and give the instances presigned URLs")
+		cmd.Flags().DurationVar(&options.S3URLExpiry, "s3-url-expiry", fi.MaxPresignExpiry, "Expiry of the presigned URLs with --s3-private")
+		cmd.Flags().StringVarP(&options.SSHKey, "i", "i", "", "SSH Key for cluster, also used to connect to the instances")
+		cmd.Flags().StringVar(&options.ClusterID, "cluster-id", "", "cluster id")
+		addParallelismFlag(cmd, &options.Parallelism)
+		cmd.Flags().BoolVar(&rotateCA.Yes, "yes", false, "Make the changes; otherwise we only print what would be done")
+	}
+}
+
+func (c *RotateCACmd) Run(step string) error {
+	if c.Options.SSHKey == "" {
+		return fmt.Errorf("-i is required, to connect to the instances")
+	}
+
+	cc, err := c.Options.buildCluster(nil)
+	if err != nil {
+		return err
+	}
+	awsCloud, ok := cc.cloud.(*fi.AWSCloud)
+	if !ok {
+		return fmt.Errorf("rotate ca is only supported on aws")
+	}
+
+	castore := cc.castore
+	phase, err := castore.GetCARotationPhase()
+	if err != nil {
+		return err
+	}
+
+	var allCertificates []string
+	for name := range kutil.CertificateNames {
+		allCertificates = append(allCertificates, name)
+	}
+	sort.Strings(allCertificates)
+
+	u := &kutil.UpdateCertificates{
+		ClusterID:   cc.k.ClusterID,
+		Cloud:       awsCloud,
+		CAStore:     castore,
+		SSHIdentity: c.Options.SSHKey,
+		UpdateCA:    true,
+	}
+
+	var next string
+	switch step {
+	case rotateCAStart:
+		if phase == fi.CARotationPromoted {
+			return fmt.Errorf("the new CA has already been promoted; run kope rotate ca finish")
+		}
+		if phase == fi.CARotationStarted {
+			fmt.Printf("CA rotation was already started; the new CA will be distributed again\n")
+		} else {
+			fmt.Printf("A new CA will be generated, and both CAs distributed\n")
+		}
+		next = rotateCAReissue
+
+	case rotateCAReissue:
+		if phase == fi.CARotationNone {
+			return fmt.Errorf("CA rotation has not been started; run kope rotate ca start")
+		}
+		fmt.Printf("The new CA will be used to sign certificates, and these certificates reissued: %v\n", allCertificates)
+		fmt.Printf("The master certificate keeps its key, so service account tokens remain valid\n")
+		u.Certificates = allCertificates
+		next = rotateCAFinish
+
+	case rotateCAFinish:
+		if phase != fi.CARotationPromoted {
+			return fmt.Errorf("CA rotation is not ready to finish; it must be started, and then certificates reissued")
+		}
+		// Otherwise we would stop trusting certificates that are in use
+		u.Certificates = allCertificates
+		err = u.CheckSignedByCA()
+		if err != nil {
+			return fmt.Errorf("%v; run kope rotate ca reissue", err)
+		}
+		u.Certificates = nil
+		fmt.Printf("The old CA will no longer be trusted\n")
+
+	default:
+		return fmt.Errorf("unknown CA rotation step %q", step)
+	}
+
+	instances, err := u.FindInstances()
+	if err != nil {
+		return err
+	}
+	for _, instance := range instances {
+		if u.NeedsPush(instance) {
+			fmt.Printf("Instance to update: %s\n", instance)
+		}
+	}
+
+	if !c.Yes {
+		return fmt.Errorf("Must specify --yes to update")
+	}
+
+	switch step {
+	case rotateCAStart:
+		if phase == fi.CARotationNone {
+			err = castore.StartCARotation()
+		}
+	case rotateCAReissue:
+		if phase == fi.CARotationStarted {
+			err = castore.PromoteNextCA()
+		}
+		if err == nil {
+			err = u.Reissue()
+		}
+	case rotateCAFinish:
+		err = castore.FinishCARotation()
+	}
+	if err != nil {
+		return err
+	}
+
+	// Update the configuration (in particular the launch configuration), so new instances get the new CA bundle & certificates
+	_, err = cc.run(cc.newAPITarget(), fi.ModeConfigure, c.Options.Parallelism)
+	if err != nil {
+		return fmt.Errorf("error updating cluster configuration: %v", err)
+	}
+
+	var failed []*kutil.ClusterInstance
+	for _, instance := range instances {
+		if !u.NeedsPush(instance) {
+			continue
+		}
+		fmt.Printf("Updating %s\n", instance)
+		err := u.Push(instance)
+		if err != nil {
+			fmt.Printf("error updating %s: %v\n", instance, err)
+			failed = append(failed, instance)
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("failed to update %d instance(s); re-run kope rotate ca %s to retry", len(failed), step)
+	}
+
+	fmt.Printf("\nThe CA certificates have changed; update your kubeconfig with: kope create kubecfg\n")
+	if next != "" {
+		fmt.Printf("When all instances are updated, continue with: kope rotate ca %s\n", next)
+	}
+	fmt.Printf("\n\nDone\n")
+	return nil
+}
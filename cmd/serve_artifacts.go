@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+)
+
+type ServeArtifactsCmd struct {
+	ArtifactsDir string
+	Listen       string
+}
+
+var serveArtifacts ServeArtifactsCmd
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "serve-artifacts",
+		Short: "Serve artifacts over HTTP",
+		Long:  `Serves the artifacts written by create cluster --artifacts-dir over HTTP, so that instances can download them without S3.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := serveArtifacts.Run()
+			if err != nil {
+				glog.Exitf("%v", err)
+			}
+		},
+	}
+
+	RootCmd.AddCommand(cmd)
+
+	cmd.Flags().StringVar(&serveArtifacts.ArtifactsDir, "artifacts-dir", "", "Directory containing the artifacts")
+	cmd.Flags().StringVar(&serveArtifacts.Listen, "listen", ":8080", "Address on which to listen")
+}
+
+func (c *ServeArtifactsCmd) Run() error {
+	if c.ArtifactsDir == "" {
+		return fmt.Errorf("--artifacts-dir is required")
+	}
+	stat, err := os.Stat(c.ArtifactsDir)
+	if err != nil {
+		return fmt.Errorf("error reading artifacts dir %q: %v", c.ArtifactsDir, err)
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("artifacts dir %q is not a directory", c.ArtifactsDir)
+	}
+
+	glog.Infof("Serving %s on %s", c.ArtifactsDir, c.Listen)
+	return http.ListenAndServe(c.Listen, &artifactsHandler{files: http.FileServer(http.Dir(c.ArtifactsDir))})
+}
+
+// artifactsHandler serves only the artifact files: not directory listings, nor files that are still being written
+type artifactsHandler struct {
+	files http.Handler
+}
+
+func (h *artifactsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/") || strings.HasPrefix(path.Base(r.URL.Path), ".") {
+		http.NotFound(w, r)
+		return
+	}
+	glog.V(2).Infof("%s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+	h.files.ServeHTTP(w, r)
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/kopeio/kope/pkg/fi"
+	"github.com/spf13/cobra"
+)
+
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Manage the state store",
+	Long:  `Manages the shared state store, which holds the configuration and PKI for each cluster.`,
+}
+
+func init() {
+	RootCmd.AddCommand(stateCmd)
+}
+
+// isStateStoreURL returns true if the --state location is a remote store, rather than a local directory
+func isStateStoreURL(location string) bool {
+	return strings.HasPrefix(location, "s3://")
+}
+
+// buildStateStore returns the store for the cluster's state, under location (a local directory, or s3://bucket/prefix);
+// region is the region of the S3 bucket
+func buildStateStore(location string, region string, clusterID string) (fi.StateStore, error) {
+	if clusterID == "" {
+		return nil, fmt.Errorf("--cluster-id is required with --state")
+	}
+
+	if !isStateStoreURL(location) {
+		return fi.NewFilesystemStateStore(path.Join(location, clusterID)), nil
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing state store location %q: %v", location, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("state store location %q does not specify a bucket", location)
+	}
+
+	// We read the config (and so the cluster's zone) from the state store, so the region must be specified
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		return nil, fmt.Errorf("--state-region (or $AWS_REGION) is required with --state %s", location)
+	}
+	cloud := NewAWSCloud(region, nil)
+	bucket, err := cloud.S3.FindBucketIfExists(u.Host)
+	if err != nil {
+		return nil, err
+	}
+	if bucket == nil {
+		return nil, fmt.Errorf("S3 bucket %q for the state store not found; it must be created (and secured) first", u.Host)
+	}
+
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return fi.NewS3StateStore(bucket, prefix+clusterID), nil
+}
+
+// buildStateStore returns the store configured by --state, or by --dir
+func (c *CreateClusterCmd) buildStateStore() (fi.StateStore, error) {
+	if c.State != "" {
+		return buildStateStore(c.State, c.StateRegion, c.ClusterID)
+	}
+	if c.StateDir == "" {
+		return nil, fmt.Errorf("--dir or --state is required")
+	}
+	return fi.NewFilesystemStateStore(c.StateDir), nil
+}
+
+const keyManagerFilePrefix = "file:"
+
+// buildKeyManager returns the KeyManager configured by --key-manager, or nil if private keys are not encrypted
+func buildKeyManager(spec string) (fi.KeyManager, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	if spec == "passphrase" {
+		passphrase := os.Getenv("KOPE_KEY_PASSPHRASE")
+		if passphrase == "" {
+			return nil, fmt.Errorf("KOPE_KEY_PASSPHRASE must be set with --key-manager=passphrase")
+		}
+		return fi.NewPassphraseKeyManager(passphrase)
+	}
+	if strings.HasPrefix(spec, keyManagerFilePrefix) {
+		p := strings.TrimPrefix(spec, keyManagerFilePrefix)
+		if p == "" {
+			return nil, fmt.Errorf("--key-manager=file: requires the path to the key file")
+		}
+		return fi.NewFileKeyManager(p)
+	}
+	return nil, fmt.Errorf("unknown --key-manager %q (expected passphrase or file:PATH)", spec)
+}
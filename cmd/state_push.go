@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+)
+
+type StatePushCmd struct {
+	StateDir    string
+	State       string
+	StateRegion string
+	ClusterID   string
+}
+
+var statePush StatePushCmd
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "push",
+		Short: "Copy local state to the state store",
+		Long: `Copies kubernetes.yaml and the pki directory from a local state directory (as used with --dir) to the
+shared state store, so that the cluster can then be managed with --state.
+
+kubernetes.yaml is replaced if it has changed, but existing certificates and keys are never replaced.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := statePush.Run()
+			if err != nil {
+				glog.Exitf("%v", err)
+			}
+		},
+	}
+
+	stateCmd.AddCommand(cmd)
+
+	cmd.Flags().StringVarP(&statePush.StateDir, "dir", "d", "", "Local state directory to copy from")
+	cmd.Flags().StringVar(&statePush.State, "state", "", "State store to copy to (e.g. s3://bucket/prefix)")
+	cmd.Flags().StringVar(&statePush.StateRegion, "state-region", "", "Region of the S3 bucket holding --state (defaults to $AWS_REGION)")
+	cmd.Flags().StringVar(&statePush.ClusterID, "cluster-id", "", "cluster id")
+}
+
+func (c *StatePushCmd) Run() error {
+	if c.StateDir == "" {
+		return fmt.Errorf("--dir is required")
+	}
+	if c.State == "" {
+		return fmt.Errorf("--state is required")
+	}
+
+	store, err := buildStateStore(c.State, c.StateRegion, c.ClusterID)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	if _, err := os.Stat(filepath.Join(c.StateDir, "kubernetes.yaml")); err == nil {
+		files = append(files, "kubernetes.yaml")
+	}
+	pkiDir := filepath.Join(c.StateDir, "pki")
+	err = filepath.Walk(pkiDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == pkiDir {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") || strings.HasSuffix(info.Name(), ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(c.StateDir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error listing files in %q: %v", pkiDir, err)
+	}
+
+	if len(files) == 0 {
+		return fmt.Errorf("no state found in %q", c.StateDir)
+	}
+
+	for _, f := range files {
+		existing, err := store.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(filepath.Join(c.StateDir, f))
+		if err != nil {
+			return fmt.Errorf("error reading %q: %v", f, err)
+		}
+		if existing != nil {
+			if string(existing) == string(data) {
+				continue
+			}
+			// Replacing the configuration is how it is edited, but replacing the CA (or any key) would break the cluster
+			if f != "kubernetes.yaml" {
+				return fmt.Errorf("%s already exists with different contents", store.Location(f))
+			}
+		}
+		fmt.Printf("Copying %s\n", store.Location(f))
+		err = store.WriteFile(f, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/kopeio/kope/pkg/fi"
+)
+
+// Two people operating on the same cluster must not silently overwrite each other's changes to the state
+func TestS3StateStoreConcurrentWrites(t *testing.T) {
+	f := newTestFakeAWS()
+	NewAWSCloud = f.NewCloud
+
+	_, err := f.NewCloud("eu-west-1", nil).S3.EnsureBucket("kope-state", "eu-west-1")
+	if err != nil {
+		t.Fatalf("error creating bucket: %v", err)
+	}
+
+	stores := make([]fi.StateStore, 2)
+	for i := range stores {
+		stores[i], err = buildStateStore("s3://kope-state/clusters", "eu-west-1", testClusterID)
+		if err != nil {
+			t.Fatalf("error building state store: %v", err)
+		}
+		_, err = stores[i].ReadFile("kubernetes.yaml")
+		if err != nil {
+			t.Fatalf("error reading state: %v", err)
+		}
+	}
+
+	err = stores[0].WriteFile("kubernetes.yaml", []byte("first"))
+	if err != nil {
+		t.Fatalf("error writing state: %v", err)
+	}
+	err = stores[1].WriteFile("kubernetes.yaml", []byte("second"))
+	if err == nil || !strings.Contains(err.Error(), "changed by someone else") {
+		t.Errorf("expected error overwriting a concurrent change, got %v", err)
+	}
+
+	// Once the change has been read, it can be replaced
+	data, err := stores[1].ReadFile("kubernetes.yaml")
+	if err != nil {
+		t.Fatalf("error reading state: %v", err)
+	}
+	if string(data) != "first" {
+		t.Errorf("unexpected state %q", data)
+	}
+	err = stores[1].WriteFile("kubernetes.yaml", []byte("second"))
+	if err != nil {
+		t.Fatalf("error writing state: %v", err)
+	}
+	err = stores[0].WriteFile("kubernetes.yaml", []byte("third"))
+	if err == nil {
+		t.Errorf("expected error overwriting a concurrent change")
+	}
+
+	response, err := f.NewCloud("eu-west-1", nil).GetS3("eu-west-1").HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String("kope-state"),
+		Key:    aws.String("clusters/" + testClusterID + "/kubernetes.yaml"),
+	})
+	if err != nil {
+		t.Fatalf("error getting state object: %v", err)
+	}
+	if aws.StringValue(response.ServerSideEncryption) != s3.ServerSideEncryptionAes256 {
+		t.Errorf("state was not encrypted; ServerSideEncryption was %q", aws.StringValue(response.ServerSideEncryption))
+	}
+}
@@ -0,0 +1,593 @@
+#!/bin/bash
+set -ex
+
+# add-tag RESOURCE-ID KEY VALUE
+function add-tag {
+  aws ec2 create-tags --resources "$1" --tags "Key=$2,Value=$3"
+}
+
+# wait-for-instance-state INSTANCE-ID STATE
+function wait-for-instance-state {
+  local instance_id="$1"
+  local state="$2"
+  while true; do
+    local current=$(aws ec2 describe-instances --instance-ids "${instance_id}" --query 'Reservations[0].Instances[0].State.Name')
+    if [[ "${current}" == "${state}" ]]; then
+      return 0
+    fi
+    echo "Waiting for instance ${instance_id} to be ${state} (is ${current})"
+    sleep 5
+  done
+}
+
+# missing VALUE returns true if a lookup did not find anything
+function missing {
+  [[ -z "$1" || "$1" == "None" ]]
+}
+
+export AWS_DEFAULT_OUTPUT='text'
+export AWS_DEFAULT_REGION='us-east-1'
+
+KOPE_RESOURCES=$(mktemp -d)
+trap 'rm -rf "${KOPE_RESOURCES}"' EXIT
+base64 --decode > "${KOPE_RESOURCES}/FileResource_1" << 'EOF_RESOURCE'
+e30=
+EOF_RESOURCE
+base64 --decode > "${KOPE_RESOURCES}/FileResource_2" << 'EOF_RESOURCE'
+e30=
+EOF_RESOURCE
+base64 --decode > "${KOPE_RESOURCES}/FileResource_3" << 'EOF_RESOURCE'
+e30=
+EOF_RESOURCE
+base64 --decode > "${KOPE_RESOURCES}/FileResource_4" << 'EOF_RESOURCE'
+e30=
+EOF_RESOURCE
+base64 --decode > "${KOPE_RESOURCES}/StringResource_1" << 'EOF_RESOURCE'
+c3NoLXJzYSBBQUFBQjNOemFDMXljMkVBQUFBREFRQUJBQUFCQVFDNVdibXBiKzZoUVFCQkhOMzQ1
+akgrMTUzQ3ZXWUZNQkF4WExTcTdiSExLVVF1dEI5UXVPUk5hbFdSTDZiV0VsWk1aR0FkdS8yT2Rv
+MzNCRHFLNVJVZUw2UkFCNmpMRW5raS90eDVrUnFPSFk1Y2N0cXVISGdwcXo1N3lFU21DWElBRWVY
+TFE1N09SSnAxdGtRQ1FwclRCM3c2MzFoR0I3Mkpzb1BaRVZHbXRXQ21SYWpSVXVSb2kvcUZqK0p6
+WkNwWmcySVU2U0JMK2pISDkzSllyN1N5R2lXWW1LUVJ4L3c2UXRuRHRyYllUMmRIdytjeGR5WW00
+bk8xM1hsVnd3bVBHYm9ZSzdhcmdHUDl3eDBua0QvVnkrV1R2KzRmTDQ0L1NtSUVPdEtxV0xtZlRC
+djQ3OEdTL0hmczU2R2ZSYndMaUVSTVMwZzdQcEkzVjFnQ2pMNloK
+EOF_RESOURCE
+base64 --decode > "${KOPE_RESOURCES}/BytesResource_1" << 'EOF_RESOURCE'
+H4sIAAAAAAAA/8T8XZOrOLYmjt/vT7Gn5mIu/qerJDBZ2xUzE5GABMZGpIReQDcZgKjEIDBpk37h
+0/8jd1V3V1efnplzfhMxt1pLQg4j1nqeZ2n91//y9af6OP1UV5fuyziY4/nrX+avP12r809N1XTt
+T8NH3Z6ndmkvfzlOl6Wy9ktj/ncO1fL1v//3r+g1e8Vf/+fXT4/Xdrr++KhG++U5THd5vsvIa5AR
+zrLDL19JNbaXuWraw/HXtnk0tv23w3E8Lqya3trzv+Vt83E+Lo/gNC3tfQnb6fFveXu+Hpv2uWlO
+H9Pyb6y9nD7OTUs/Tkv1by/t+XK8LO20yJP9GNtDVbf2y/PhkAXPHL2SLESvwS5k+S9ff1jOH+0P
+X55fdq85YhJ9jsGfnR8d8CP4cfvlWfDsVbxE7DlEf3MOnl8DxPgvXw85WA45DJgwCT/6IZOJyIVO
+KMCCfbeBJRhgkgsWtQMJqSrPGmA3F4mvgWWCa6UdAc1jQQ0eXBGTmWH8oCt6MIQzOppzo7pzJRrP
+rH5AJV7ZgBGV5Z7LhBggmRJMCYkRK8ytGVmRj/e+5vKikVHtAAOCOyTR8OCS+S3yBOWSsMi4vMdX
+hZjgPCES4Q0fmE/o/DebAVowyQgdZEw5lkzgDRdLl0I9K9vtjZxLMd77779LksQAxpSYDzWckyrW
+PR8wYyIJhMAoGM3EpO8z9OmLr9QxExOJn6+GNWrLctXNmbJHgSxvFLk2kl6VaDyikpsudL93dc77
+BAgsHI3NwI9LqdVpn2JpS1F6jfC05OQoBSzU8fIgw3wnhTxql54rab2sMJC5SW8K/0BG7eg+mRhK
+fGrJe867uY3grRWdw4ruVL/NaZYviLpsSJ35WgfLi7AzEWorhMUFQ8lTqZajHuSZodOD4ERLxzuX
+o7ySgfRmSMIaQd6KmaU8qRqFPOkQL+ibTQq6o4hgnGHsaNfKFJqZQN+tY3yrrJlqNE+tIrs09m8c
+6F3qdsXhscw1sC8Z19kBDjdikzMb5SOPyabOL/tmWN6looAXJpVxkjDH8sbOqobolhWdl8YJOQBS
+psUMyEB6BvFAwP0qnOSUr+YuHIgykT7kOoC6kAkF4NG8zTs6JtJM5EyESQ4Qc+7am47ktUXmgwrr
+VGjr5ip51Fa/5+OMVbSEtDCYCtzxcSYMSJdFRjOs8xT7vhBv12BIfCGYr4GMuUAbBtBdKoYNkMHB
+NYwN0OefZ6Pv3Bzj3+aibUCtxWzYuWZcFjIsmRHJxlgJ5IhjPr7tjfR1OSZQRB2hfff9fdXIukJa
+Qkfs/+u5HRTYHstJ0gpBP0UmFC6OBbDH9DYjIWRAXSOpQODzzAqxW5vi+U6G7thYtm9lUqXDfWkn
+eWzjExCxPhyAPhEH3TKEVwK7FxmVZ7J274fVdGYq3cD6xwOcQzKyMrc2OKy6y6U5crUF+QDTcpWw
+LhKRYeznQ3OueyNSTvbauZct8p6EsC8Vty97p5Mm2t0E9HVjxZ4A+5TFek3dhKbqThikV93rnUTy
+WPVvdwGSI8d+VPcJP4D0ng6JZWNzb8f7mMfNjYt7WeXLUQz00cABSHUD9W3WtU10DZJeqzcgEPZr
+aUozpq4e0nMlcW+sKaQ8PRhaTg1I9qm013aYUVmgm4pObo3uR8GlKyJ5LN3Zp1x7wTi/y3yLUuQ9
+pZx9mLCEe4c8EZcIORJPO/NFP77BHC2Q5NtJ8/Jcc7ukA3ZqNRPueB/MuZ01AE7j7O4VmsOSN/s6
+Op2rYeNkaDtQoKsDnGUbasQL6eeRV7QRcXjRnEWEezUlIjiC5ZCDhQkP7RDBwrKEDTakkuHDb9/v
+/Zfg+XWPyn/62OcCoDeBk0QOnx97/8Dk8P2Df6AzyYXFtWsSOmA/B8QXQvrNxMJGdX4lTJoO85pF
+3aGJ7dzgZ087u7WO5YX09on0FqefL5Bkt1SebukqB2L9PLA7WMO3R73ik4wYEEVX1b051g581FxC
+uqZOPs5pClJQFswj4/Jogot3cLDPhfTZsJBqsilz8EeuxEYiE5duuU9XRvIVrVpsZxVSyMZlQ50u
+Y0OiueiyRiYgw4Rx5R0rpE+lkrkJ9XsNZi9D8iCLLs3VHSk8L028u6tpTs3b/FQ6bN1De2xRemZo
+FjrCafPYhtLFCx9xKBVE+WRG4cAzwXLUgz4eVn3QTuOmzrc1U7NokGGy16su5rjs9RpMOBM9mdoB
+A+6SqRTzwUT3okJMpKBLhUueMjSPCn1bazC7atitjStP+SRxM8lbDi1N0b0qezKXnE15MXeNk+7Z
+xPjetaKC9j0TSULAckm5fmcK91QNZ8aNX8USamxILWCuJU4YwoyKxK+BDahIXAluQCOzk1YmtUNA
+dTutbb+D2p6gBlDQ0fQZLzeVkJGM5yNV0M1C/2yQfVE9y5pQrhrMwHA9ErSEjfSLOtZ+EzYbIpJK
+TcmjHZNzMA5n4c69GHaQSpkLF9PSdksd264R3bVyu4kMNmX5xSVDc64tSxvHfPDRUoXmxYjdVcRv
+G+7YXriJn+JyYxy6F8XbjTkLqJWN0mJ+YVY7spB7OWlOrHFVPCuN5FOtBtDiJEylXemaQFngMweJ
+bxAuyWA8KS6PvGdKhuIq6Uxznuwb23kUovsBADcdzTUVULSF8FIsV7qSDyO7mBSDS1TyUkWMCi5P
+FGA/49qmvR0rZBhXXsgxObXFcAt6xvi4BFWM97W1qJa6Z2MnGsgU592tmowrLYukc3sosQX1agoh
+fUSdTpTQLlKUDouaG7P2qeLi3IBF1dHbXsV4I5FXZIhMSkg/zRfdRslTzTvehBbU3EwmfrsqlFya
+GAGF5blVwpEhgibYVrVDClH4+9zqSx4bpiMbNm+zU6OkawWWFZBxE3dVGuLIgCQupeQ6utPU0cfG
+2a1mtFmKhdtyH4pBBixMJuKwQeEZk9iWrehygdg1h7MXWB3mq/+UO9tDi6SgauPJqVNs8nXV+yEf
+cUeBhTXXBxLd49JZdnXIhET2UUkZlNObl09GN2EXamgxLU63qt/t1aiPTSwPOS6vctjeiJV35iyY
+KIxkb8IKz3eZb6/VkHAR7qAp/LtxtqoRkOt+5xDF9rW6rC1O1yZYIg5JSJ5P99zxhBEebmQHKPQn
+dtziUsBOSjM2nD705I9paG+5g1/qoUN5PDjpsD1S2cn9apWayKbGZJFSXEvntppe74Kh8+XEGJ18
+VwBJU/nspYV9oZN4iGhBwtrLHhhfjvilHhdFx+SlBGRSIlnNRF5kdI+z6LK2sT0rtHSVAjcBdnsK
+2aWe0gddu0MjUig4IQ1armbClg/LItVuY1ZGDo5ZK0s+6qn0mGWlBlpXkOyb1TKlthHjNuEIRyn0
+h/btBOtRvhBLkCmEq6euJ9xKjbwx7xNbQTaxYnfOVGe5YKp0NjCVA9g7SSClLI1Db42r+4pbaQr8
+knE806K7Bna+lOANVg6R1NVrXcxExRRW/A0qjA90NBEbvR2HROahXSVaNpnYJnxMYB5tc4ZlpuEs
+iTo9BPSP2kWbTLztFUZrA755Bu9WgfDQRujGRnOQAj04PD0q6yciwodSstEA6Neyc0tXahKS9xKU
+LokTr7bm0XACS5vQDM1peTuBw2MJS8FuIthGbNxuWHS68hF60vX3jRhWgo0v8NuNOtt9HnWYAh2w
+SV7LovPl0Nx5yBARXScdDelqb5X7fA1Gdspw59fABFQsFReYU8sKPiaZxL7IXb2qUcAa6ZlD86LB
+XWqY3ERhsY7ZvY3trVJbKwHb1RGcauHle9DsSyfd8En2rCB+PlxAGmyPBC15hSTK5dsjD59Xsfo7
+U3Q3juydKgMqx/N0sGjhsJI+tmcdivsBLIOGdidWRms6e1QaZw+w1T19UPdtFUgHeiRnMp2uJMKo
+Cm2sx/tBTN1MMBk0xouxc9S4CWfDUhEh3OAIlkMOFiY8tMMJp7lPxWAVlQzv0IJVDpZDDpbg7X/8
+jy/BQeQcsdfdyyt7JhH65SsEPzqbzY/gR/ATfPoSkvw1zNLnHfnla2M/Lkt7/tGemsp+CUn+ytDL
+YRc85798/QH+8CUk+WuOmETsdffyy1cIfgQ/gh8h+BJmwR6x15xn7DlCv3ytPn69fEEOes15xp4j
+9MpRzl8RkTuWkRQR/svXH374gg7POd8FOXpmQfx6yKJoR6JXhl4Ou+A5/+XrD/CHL4g8+wf0GhxE
+zhF7DUn+y9cflvNH+8MXRJ79A3oNDiLniL0esijakeiXrz8s54/2hy+IPPsH9BocRM4Re00zsuMZ
+25Hol6/H6Vf7cTf1F0Se/QN6DQ4i54i9MhTtcs7KX77+8MMXRJ79A3oNDiLniL2K3d9YAESe/QN6
+Pfz8esieQ//58EyC7+tOp6n9gsizf0Cv6TPZYZTzV8EOf1zvOwXx573Gzzv2siOvafadbPjhy47k
+/JkE6PWFIbwrfvm6tJelsR+XpT1/2QsfvT6HYUZeGYp2OWfl90nfx330zBB75dkekb+PhlmwR+zf
+cd+lzxF65c/R34denvNcZSz85etcXS6309n8Ps6yovzrwp/Mzl/m8+n++MtyGtrpNxeRI/bL18qM
+x98GAhz9xwmT/XdiQTV/JExyKuxUWhvwYNGpc7+nDmGVaGA12TLF2GUY63xYrmqwJzOSjEqMOUoY
+LZo9swxL9D1/91MpMS06kjtolaOtFJqhsl1eR+SJKhmZ1ff4OGMDoJCFeZEIAS7YUArtpvGcVYN0
+uWTM3OY/2GCQFx1mCL/Q0WRySHwuWZBO9K4UVJWdl9I1kwZy5gLdGCC8EYbnarcyjDEdMJMC+wTZ
+IBiYS4vvvgeqtp84gjFk3ttYWjWCs5gs4ZEmspg/ynXn1uPwqMbOO6w7wAbiN2B7omDw9u7bI3UI
+yAF+HNbnfRWmwAz3nLtvoO7JSqKua1w5coFvFU7PAuhEg3QjsD2Vhb4zMBcE++7e1aNYraXCPhqp
+b61Y7nwgi8LIVc/z0jgDYBZ3tfAOQjIoHbSpB+Gy4e6IqLnnoPvgmAmBdy4Zuqrsk6c27O58StaG
+4y6dymuGBo+NdqcHjSpXo2CyjsKJJ1cMiTK7VC2nPPJE1euMoQWUwISN2M6p++yQcb7nA7YmwnMj
+tn2tvFGhZUzXBMoen9oQi1SUdyKHPZEa5nBejXOHRHhjDlhcOyzXrj+msTnJ0Oy1mFO5ar0HTFDL
+VDmSW86xc3C2dwlwL6S8l4JZhahTFaZr32amB7NrlVUELVfB2eXgJCsX0qphWNPjovg0s3zq3BTp
+rBazrwEMqMBxNTJBp5lQzn4nLpjLxO5qIMbB8EkWdhNDWPz2TnVcCokYwgc6mqkZkokJHQhFEFWz
+z0Uycat3YoABVeWGCfxOBSa0938nRdgkZLOnQyKoJWkuPMZj7KR2eMix2TSC+WJMnhpXAPn5TKUD
+DTyVY0KYMIhKM1GLLVnl3ij7Xo6mL3ucSmtO1dusy/H0YOvOrXomqPAC7Sz3Klpmtlo3RYkvChJR
+gV8o+CQHsT2sGAqkrRy3twOAo4ggKYd5rabS0zGJDUrGwM4zc5FHwf2mBwsVmjdieLsyO7PK2U71
+43Kl47aikIxKbbvGYe/aIR+NMw9Z1NzomDxSqz+MW15bsQWfRAcbv+2zwt74qJ8U75iJ7FUXyUUC
+AGuFIeEyIs52qmzikAFLXsxji4Aj0M6rwgSWj21QTYaqfoAN1MrYxuHYZyWdxwqQs1TlPUNkNsPW
+F8E2atXO4yJxpRhcxstN9dg+6ND5WQTPbEw4n+ZCP7ZIxPZsVkYOYE64SG8VtIuQ8hJMya5GcGDR
+8qGhhqW6rBzBJHVl3gh2IGF3Z9HWF07pcpdCHcp7hRqouJ/wWL8Q15xE8XbXRbJWaPPI5OlaA7AX
+3CZyomuL5wdxzEah7Um4DBKIvLw38gATweRu1WCb65CsHHSpslYZzk4CiIeayJFHS8cjtqEcbP+P
+CJW/hoT/K6xKG33blKO3qEmfS2cOU3eOOBcrnwQkMbuSiDhZIQOhTMqiLaukPzYOAym4cw0u16C3
+iQ6WtOW+aBy5z7h5yaV58Mh7omLRstDnCpOEFhqlgy2aXp8qqSELO9r06c3YbqLg4tQR7qsCv4vC
+v6To2z7l8pEXMpDC3CpLzoybS13ofYu2G7GaJ4I8XoXSS0P/KY3EuQqTIivSjR6W95Kbg8EGNMdv
+Vwr9XKm3tcUI8ufZzeLdPY3IxUQJVGLnqcHmhD+DvTOztkhKOpp7NW4BGSWsVz0L0T2ks71Xo7dv
+wvTeqo2XFclFY9trLDwxMhtY8yLHZK5VQvIxgRJ3nna7e46lzuGbx4WVe9eWlUzvRkGqV9+VHBcp
+WDgfd1cyLBcNOsyGZdEruZjHcm1As29Wf9dGb5vauVvqYszBPKa9QXToFgE2nhnvolz9sHRIRi2r
+KokThjCjIvFrYAMqkgNxyzsZbGRQgnJpN4aeHkrhkaz2XA/CocDLy0LO7YgvBJ3uWcjCVOBjM26J
+GeVCCjMRd5bafYMVNle2du9Z2HVa+i9q6OY03yojkBeMNq0G5BnHHPNo4xixc6Sj3cZZuhTgoIrg
+KMMUmkieMwEdE5q+hrhsMHKpnJ+YHBwdk0fl0lWD9Eyi5UmHw54Ds+dK3OtocA7OFgrAXnTIVO0i
+qIGZ88LMBPtdLXGRC/rYg/LeQGZT3g3pmKQc2nce2yjneKfU5qztTOXzLGtxuRFg+GH1O+3qqxaw
+IwCSFCRLNeKgLshe4uerwjOuimTm/QDSEXl7AFcmLTYCn9PBAr3KuQQyypCVgcV5DmVnYjukrsUk
+8jI9MJa73UW4nUxxt2ORlyuxLdoi2VTxyWVqO7WRsWyQUtvkoyzmygzdhkuWsynxtXPbq0HDNCI5
+E2RSQvoEv61ytLs9xKmI/Ws56YGAt3sJNCOjgSzWceV094wnNxOaI8Hz0QB8oy57kgMpK3d3Lem8
+5NgPcmUyjpL3EsxexZNNFgsnB9tTM8lShP69jjVS4wakit3TgURkWPYCXO5714+MTYRE3nvd44AA
+T4phmYMh6fS4dJmUXuXM72ayDxkssURvbgpODrXIM5H3VD8WaXgXm8mG5SpRFUpP4HnMeXdpVuyR
+vgs1tJhyVrRxuWfgDkloczPZimN2yPLtIZ/0PZ1wRcfkuHdPQIxdIsD2IsY5K2FyzSJ41FGyr3DS
+K0kc7sixcZ/XTHm45fjGbvNDTvqYj8uQxURQoF/2ron4sPXafNlxYD5Ir6+tJKWR2NYuifdwvmYY
+OTpMVF1YmE/puVFel1u/oNaGDHUoGO+sFvShZeOWYAbNeOfNKG8akFiPCSHDbtNM5MJCC9maghTO
+uASf/y0OSJ/Csu947STRfi3XxrWWAO8m+t2eSBwbayG3CaxA6qi49CqYXPKV3kyod1lRPlo7h82w
+CCVsoCMbp3AeBEymKmSlgcY1PfkorY6yAjl7Z9GUzjjD9kXZt0+2ecMnErShcEs43EqQxBIJh8hh
+zdCb07i402h5ydAOaHU6aydBSuobt/olt3OmxP1GhQfoasNgwCOJkwOd/EIozy/VMquhfOjpdBf2
+e/YV6VEOjdJIo23GoAAielulxICj7kSHuTOA7MUIdT7asZmQR4+XfTnYqunJUUd3SyJzV1z7Mt8q
+qRLEpKHVmgQq375rm8TMgafy+M0j6h7JVb7kcu7aCIc5Kl0WDY/KYstjnfHb/FE7O1iHyc2MZBaF
+j3jM+j0oYR0s71JAayBeGocETHVPzWDmg6uXPdSd6jsl4nLN43mkqlx5tB3oqFcpOh5Y/4lx69fA
+BFQAoBF72jt0JYPnGyuJRnaRzskxkAHtfLuxaLmkQjwMTu4V76zqGdT2+UEEsUp5kSjmYybSvSre
+rlKSSU0JEZGHpfRFOaTngztXAuinrOhOzXR6SHd41G7j1U53bkDnk364NgPxqPAO9Sp16dBri+cs
+HeFLdZvHPLIHDvBJ8mRT5YvQwC6p8LiIlkza57UCOlEoiTJObDsmbq0IkaH/VGFZyNFSNjVrcATL
+Z6byXRLCCae5T8Vg1WcG80+symcmc0D8PwFuq9/ArfMHcMuYfXbL8eRy22FpzaWKlrGNuj6VFLbD
+/+tqAPnOJP4bSPmtGqD7vRrAbOqIIfqZkgH774JwOiSMCvYJaGnwWcHw6au2oXYMpgPelxMJxbg4
+OaKParyTymJER5Yqa64HiDZCdEFVdJmIrc/jGZrJHBTvnMbVM7GdT8Rl3xSMtFhn1G0c4j6vojcf
+zfECNWa2itlUFeW1LjAQhe3TgfEayFnh7lQKDSSSVAJ9J0geSoFBjbyu6ZOOPM/lAdi0joVTTt27
+iOZ7FlvNUXeoe7svex1UYB4rR/N0sBsD8a0Z7XiA+Km1flLF+q7kXHJuFrOWqy7mTTrsvGAc7orL
+nYnYlYUIqLh7aicsGyhfUmlPOoJnhVkhe7uUI12F2jmG252efCEQfpARrik0L2xIfDZih4TkpUaX
+vV7ZoRWzx0QJhdiK1PXvGTc0dbZBOpK9nIRXx0zUE7lJh8hMLVMJ7VrD07md9MfBtZ0umjPjJs+V
+d6w5+6BvJzeNEkScGfFwcIXAXTUNq+6TrBRkUaG0EpqD5EalcrZMEN8A6VOFs1TAHS8M0tAiaXc3
+gTDLg21AJUbBgCctMKED/P5OaTQzISChahtTQFYGPgkRHRgAQy6w++dqAQqgTwuzE/K3tTlIAiXE
+ng2wVxb7uUse6WBYiy2pwqRkUbnJRNfLQWxaBIMMfSeChrwwTAkYlAJHtDdO5QAnBx6kU+fIgqlS
+mKx6nv91pQBgn5UuoYHSp5x9whNGRzkLqR096f1htXGz2qBWp00e4rmN5yAXXcnAEgQjXGunvLWR
+ZTomjEi9SWPNqnE7q4nxBnm2VpClEZn3K3JyxxapTfBhFWeJjJNFy6np0zsL7Zwh6Fa9pDz/tqeC
++Dw63U3IPpoBehowlcvnh1Lmlg4GUeXdWKgDEvtjvto9c5ispB3KyV/MuF1Nr7WAttMgOXOVLBob
+n9I55Wo51cLKJiaH1iZJFm2FiLxdHRuvtdZLp/mdT+iqEL6rQQPBzVRxlkpF9mb152rwzjpaqHa7
+uQTz3Sj8EgxkZoM9ErF7sIHe2GDXvVs+aO9XRsw0hcOmdkxIXLw00Ih00JdaWVBJM4k1CYTVVhQS
+1GMy8uLN4bxj9ZTu25H0lYOgsfqmJuKJcR7omjwxbsTBkb2SHRGheSFAvlejPPGJXllPikp0L8Y1
+rB2pQ8LdtcZ0//u3HfOBvlEgc4lslAviSyR+h6env4eD/xSwbRD+B2Bbx9LNB8OziNBSbGUrPJ+L
+8maie1f1FrUI9wzccRpsP8HpCzlu/Saavb07nHns98HAzk2Bbu2gZYruqJnkh0Yd1EXp1iHOyNA8
+KLhc9w6+p590q7VZ7hiej8yycVukArJM2qIZDWuibZhacW+Kcq+m5KT7bjKycZtYxzxfXppp3muX
+9I0qb3Wsrwziyx7uPI7te46SsC7k5ylAAoFNJZpzE3WX0p1hObCHDg3Nn2edh0kqx/msOXpo6JdV
+pHcCbW/C7RiBemZ90omRZcq+PdREV40Sp+HNTQfLhfMuNBPu2pFpgiWmUt8PztwHvZaNYFMz2I9c
+4rJGCIpILk1P1oMjpxzPbo0bN7XNnTvSq/G8kWHC6dTNreo+CPh21sftvgkNIpgBpuxg1G5fKbIz
+NllFLAkvytUgHDTST3Ww1anaHtSoL0TOusEMKKwvBP8Z2JINh2ijhexyhQMyePvqed5JsRUl/0yG
+FpJzHZLJn1uLjzJk+3Qie72+PbTT5YRLkeWXuxjmS8bplTpyX4vuQwvvUU4zIcjATCRnE886GMDN
+YPZSgbtLoi3TaLchtry3gnpl32UMLIwVphLWXmVv3kXhpwSTcb92mwpt31k8o1zBgY2bc8UlbdZh
+beO3vYHomqp5IVjnqSR72vsXEUkqrHkiIb1xYGyDbKqG5b0ukEfipCCPLWqFGRrH24ihPOdRR1uO
+3dLOscLJTj/PI5M6Espba8Q4UzMyCCct6kKjZle4NqplUuUFK5V8u8pixrxAD6K6LOPpRka60tHb
+Rk/2VKN5SKOT18g3Nxitbjk5Mef+wkYKa0R26WO5GAVvXCRZM/kzW+VLGs1Mhd2BuuTSjFvchMOq
+cXJrOJGVy6Y0TioubqCWxFXjbk+i2znv9VOGyKSE9FtcrtXYldrOlcI2FGqmpdpmMjK2kcanx20u
+oyQ3Sp55NKw0/7bRkdzzicUmwpWIvrnsee4rPnjM9c+5k3ip1V7qXNzDKlmuvCwvkkvjJGs6Jldq
+DdQWR6XYxmloT3xYLqwwx5LLuQrfzkQlG7Oacw3tFPR6oYIhLuCZTEnJuD9UE1mVnT9Ufrll0lR5
+kSAmtnsjxFWuYhVIvguXPShvNo3YMnJcrnLtQg0tpkWzyeNyvwfpzSDTyXGZBfJg3duDUHKph6Ws
+ROcoRTZ5Lz+Iom4WPju5ZVGGyU7ZxK1Wes9hp0uX2VpiRGJ6M5FB6u10VwMcUkFgM7K8weiqjwum
+rv+Sr8mTjLqk7k1Jhp2j3RntXV+KeL5wa09mnDHBhLBBEyk1yWSna1B6aYxR0DNoBPJ0qJEEcDo4
+26Fen9dMlY9KJlEWEs2H0qEDq1KxYAPmLkNkUkL69bilspcpnfxj9dheSvEGU3HXmbjtSW+UKrDb
+WHmrBb2VdjibaJ6MS/xUSZzCTkmQeGLCpejfzofV/2BYwIPL4jTerTWUcd0n3AD2VDl3W0vt58/z
+ron0VSL2LuFMy3F7NQDfhUxvJN9WuSITn2ZGV3uoZZLruHwQi51ULYcD6IQJ2WjG5aaFt6lDfCyH
+252JxgmG+1pD5LFYHwzGvQQ2pNymYpWLxvhMHaPpAK9Z2O018Cpl5xOzNuPSXo0Q9wY+OxyJcybQ
+o+4bJ0eEEXHaEzUrEemPfJAB60uHRexk5JzXUg4mLGHKfaVkgiog3w2e13a4M9GzR9rvbsSSs+qT
+jMXEJaE8CrBdSlcO/G0WmWJ+flwOzWrTPegUF+VDYLpm3KdcsLGKzDGdTMcjDczaVbXwOMOYNc7G
+NSF5kEKmrbofWyWvAu7O+Zg4Qe+/c5AchVgCDXHYhh1izgUKtLWZ2FZUkHcRIycv2FgpmLLQXqgw
+YaPumYrsrcYnkEWWc9nt+ciYhDggqNy38fBogL1KZzlmEquMlxvi6GMFcEDU6d6MeE5lwnKp31uL
+d9RqT/Ik1xERDdBKh5hJrBVfu7t2vl0JNzOl805McyxjNpajTRpcwlrciVIs5AN+0T25yuF+oH3j
+coAABXZJxw1sh2QnwebG5BxmEcSp22xf6Gk55GA5CJmx4H+Vk/whm/mD1Gvb5Q86LyOIo/w1fc45
+Yn8Tsf/J8kqeU/QP0vVfxuq7gv27/v0aopzvyDPfZeSXr62tLsuxubTVuem+/JN+/seB1xg9h98f
+/cOX35/1H0bim+8yc4H+JDPPYY3lgblkbwoT5BEGBG3HNrrHNZb/z2Vm43ZxNWD9B5kZS/c3mblV
+ySJ7v1IIgtKaqB1ZLCf7mWMG+adEOCRT5eJT5dg46H+X5YT8myyXIfzdlzrm8LtM59bjVlarRPXQ
+HXWfkLyYx1T6x9SZtRbbXCAdtzHhqSTn34h/s8j8m5c7nq9Hj+ZDBzg3XCBPk1VWKZx7XXSoUvco
+XwXQ0uwayyLiaqdRd7cO05saT488bM4CbMFhJX75NvdV0U17IDEP7T13oKigvqaFXdNB81qYgAtG
+a+sfzYrupkh6I4Z7q4YNdYBTysZpuBwMmHsiu/c0Zqd2YizocS+nJK/t3DUjKcp8O8h8e+RwPmrY
+uPVxO8vYfJhY79thKTKppY5nJ1/tSABz0+PyIV07NwJd2+P2SUdb0aBve8KxIpIsBCxHE75duZg3
++5U46Zqc9MQi6eq45eQuQ3StYhlkYmH5cHdLgK8pJjhF0jaxjFmMD1WhX8ywfae3Ociwts3guU2R
+TNz1sY6Sm8i/ra3aOM2A30s4H01kLXWTE3cTzpVXSTtPghso3dQRwiIqsU/HbyAXSSgRCYMBM1kY
+pOFvSPqPKJ0qU3xK1Vz8jpYtZlzAT1IwpC6JqcSSDYnLRUJoYX6XmeWnhL3/1yhdRmy4x6povDbG
+d4J3G4MtkA7ZCMfsqygpNbB3Lnab31F/YiDWXCQdpfO/RumxpEolRyP9Exc2FuivcrjEGtreOEYL
+QMK6+PNZ2YbBIBcTYl1L7ZUjVCmeS17IikG9KNmpxmFJLs0/33/h8qysqaqIFW3op/WweSjOkur7
+mR/2/1vfYoDVmOzkNA8Mao9HcGPs8GATmSp0uinbZSkeHm2I+xQml9IZ7uZ51gptPm2HHH1iCuxT
+YXZ0WNzPEpJadAGX9kkN+F/cy7l/v5fzO/uG+JQ+qEtkMHVPdE3CkqdrhvFOjN/OakpKxZOBTZbm
+iAxmuJzVlHp7IEsOZKeHO2XFaaNiPVArdxqAK+vtRx1rRoCd0n63z2TiVSM8GnEnlWtSqshTHc8z
+BTbSYB7ZKDMqGzfnZM5FIprwzamOiyuB+UiRGVthex6bMX1s3/PeLmbVx4bOrArJYorkVKt5V0rq
+lH3HtDN3aeHvBMQZA11USx1kAjil2i4N7zoDYMUhiYmbXFtu9gdnO2qlD42cn1KF98E474RNCjls
+e4WSlDpenIouTRUeCZCRBsZvYBKkRedq4Ik8go7qk4NZ5SFT2iltAqkyL6wgexbb97IYPL2ivQBG
+kp5gHiVVycmVy+az1FPrT8ax0CHrzZpLNpHHsikHdlSRDMkko8axm5L757TQ+9SxIh2kIAKeqUU3
+czuBgyPO1YDuOtR3xlOQhW//ZzL87xHzTxWBTz+CH8FPzuav5v+cSO/8o0hvHO8q1QWyyNuVY/ng
+wj7pHtOyJ+9KyRdh/Yi53SpWzCuXxH+OH8Ew7wwCroB+pngDVU+q0pEbFtkLGy6QRLLI40SwzxM+
+4YtxbmuK5/d0eHP3kLyY4NtKVfKn+AH2MlpUvWKvWZNIODCmA0Qi8mg5Nfe0kI/SlUlafDJrZCEj
+LsiEoI7NvlxlWfU+qOIZCP6n+EFPV43FtRy2VTmagjibayWZW0/Myaf5IFerpGJPZrh4ujfIrLtz
+bY1XFdg/uKdrO7KrjP2XP8ePYID7Nrispk8fVaQBs8Zh7rA2kt4PTgdpbw9CzKlxiF+vPmfcx7rw
+IQPsnjvW4a5+qQbyp/jxvGeKHRuMrqlrP8iU+NVIqnJisxbkUaHtQwD4oayuNESukWYl9s9XH3BF
+uB/X0aJ4aDd6ZNA8n27MpWsem6kZZ1k7c2dcO1Yuq+hwf5R2PlefpTTR9qMdPFyKJEuH2c8H/CSx
+9AS4y8PapVKwtSo6ZZTeZHGng9Em7XGbM+fitliuBOkrWbsDk+JmrH+UInnJOfaEPV01391SBe8E
+6X09JHMOk06p5J3EBlKrj8b1e7EmuRhO+0yilSjwyPItbOwOZGIZKgVTEz97rRCglSzlqFm1egNq
+TPrysUR5T4618oK9q0MVs2suZ4/zbjCjlu24WfXz6VENyZyK3S2HDTRimzUC3YzT+QdHRnWPVn28
+wCwuz7k7ZxLpMsuXa8vZnEqNSpU4wjKRi+WRQnk6gLdNCQgOhvtKe/+Q51urxNZtgD23qntvFDkZ
+kWANTFY5idAcOY3EXhbLjENC5CClxOaaDjAik34vx8Zp8m8gHe+nfLzs65HlKtZZhj6FXOmnE72m
+I77xnqlGbcc6JEkWQcgljkowlxX65rDjNjDHJWtivMnBPeXYDALQu4oayCV9GPp5H5S4urehGphD
+Ri/KCx8z+wwJ0IQP5kVw/V47w1ULO6UOBSXo9nq0PAVM15bx5rHFuk82GndZvvrCONYLRvzSHJeg
+UotXKRsLmBy1IO9Zvn2Y1dzbEHeNPZ1zkMx5nPTcEsjyb46KIGc9mdqCvDA5XytFQg0tppyuZXHa
+k1UnlX1+ZKGcSE8OeagzUsyOGMAjRQlVcvC06lyhbCSQznWkdxoZxHr5bsa7zG2X5cowMc6oEqyj
+x22mnmdcFcM9DeVGSF3V4NtdRcQSm4zEIXMNu4tGWO7BDqbydK2BfeIRw0TRjRGeVaLbM2gnFelD
+gxbv4GCdIQwD63cCvF1L7nPC8aKd25qKxeNgAwWwnKDh3ODONiG2GTcvqSJLhsikBGa5K8VhZREB
+W0bVTFXUXHWwzXP0bc8c/GGsBrxIH1IQP1PdPgck4sEWN5ZY4Vq/lrPPgmUSyq610IQBonXYnPer
+vmjn/mDIZumwoHTSmIxzxd7mgoD7kMfzpgHJWCF2aY6XexqVN8X9XaY6n7vMM1M3pxOWKZZKusMq
+YPngE17TYd4Lp9lwgc9ZmCCymg8ixTXoWcWdrSdtCoTUL40ks3TJUcDEr2PzKdIH6YB1Fn4qg81D
+FMPKIgJUKAGVHWgEC1LIgIpKLxWfhZf+ToblnnD2oRQkVSynyoE9EVvMnPujHXTIoX/lE92ooUtS
+F8Em9mkVdo5w0kcupRLj5izH5ZZi6XHU7apBTrmaE03nfRaySE2dJtIGLMRz/liO6frsCsHO1ahv
+h8fCKGw8blNHiY7kww7s192mUfqllXPGQrKrxfZmxvsHCzuXRW8gsHhp8ezXwPh06j44Jywf7k+l
+ujj1yj7yAvclaCArkvtnyTRzkzAFeiOG5EiUeNCVlHToaGlJIAevaHGH03XY14JMeb792K/MV4iQ
+NiZdLuee2vnIZKLVMO/rXmLjdH0JunfWd8K4c5X3AgqxKC68QTgYk4L0ZWGTHMqUP88qKyzZO5Dm
+iGzqET2qfEvyEF3N0NEmNmGK8dNnTJbj/BDI6+tBYwm7kjom1dy/MSG2/yGRniCuMrZ/fWGZ3H2n
+C74X6H+vwP9fFdj/ZTxOx9P0hWTkk8mgAn02AXgNduFvjAMR6evnIvkvX39wfviSvSDyvaHB8+7w
++gcS5K9F9f9gfxH+YRe85sIniP+z+a9zmCB8l6LPRgl499tQ/nzgr/yZvcbPefzL19rZegbCn6Hr
+Vdufn1xTOd+c9mdTtT+7lddUPxu39WoI/z7v+0WEblnmyy8//XRxf6zGaj1N1e3yY3Maf/rj76/O
+y/HXqlkuP5n22to/2n66VHb5y//5wxGTiL36O/LMyj/s3jXt5tctgL/++mvtgqr52QE1aH9u6ydv
+U29+rSrHGOD+Wv07C/xf+hnt+dqe//If2scu+PtFlH9Inj87RoCf4NOXL98bX3z5cntrl69/yb7W
+p9NyWc7V/PW//X/e8d/W+kv9rdqY5sm432BrfjZuXW+dzdPm1583Dqg2TvXNdZzK3Xz7b1+abjyZ
+r/+/+9838odOH+3S/KGFx5fx+o/tOv49h78t82fjT987f/zP34bPzW83iL53AsnwKwteD1nwfPjy
+X//L944jl+7rX9ovf17h71ts78flK/jyD1P/tff/fwDQZYEMyEQAAA==
+EOF_RESOURCE
+base64 --decode > "${KOPE_RESOURCES}/NodeScript_1" << 'EOF_RESOURCE'
+IyEgL2Jpbi9iYXNoCm1rZGlyIC1wIC92YXIvY2FjaGUva3ViZXJuZXRlcy1pbnN0YWxsCmNkIC92
+YXIvY2FjaGUva3ViZXJuZXRlcy1pbnN0YWxsCmNhdCA8PCBFX09fRiA+IGt1YmVfZW52LnlhbWwK
+QURNSVNTSU9OX0NPTlRST0w6IE5hbWVzcGFjZUxpZmVjeWNsZSxMaW1pdFJhbmdlcixTZWN1cml0
+eUNvbnRleHREZW55LFNlcnZpY2VBY2NvdW50LFJlc291cmNlUXVvdGEsUGVyc2lzdGVudFZvbHVt
+ZUxhYmVsCkFMTE9DQVRFX05PREVfQ0lEUlM6ICJ0cnVlIgpBUElfU0VSVkVSUzogMTcyLjIwLjAu
+OQpBVVRPX1VQR1JBREU6ICJ0cnVlIgpDQV9DRVJUOiBMUzB0TFMxQ1JVZEpUaUJEUlZKVVNVWkpR
+MEZVUlMwdExTMHRDazFKU1VSR2VrTkRRV1lyWjBGM1NVSkJaMGxSVVRaV1oyVTFkeXRFY0ZrM1VI
+TnBSRkZ5UXpFeVJFRk9RbWRyY1docmFVYzVkekJDUVZGelJrRkVRVllLVFZKTmQwVlJXVVJXVVZG
+RVJYZHdjbVJYU214amJUVnNaRWRXZWsxQ05GaEVWRWt5VFZSQmVFNVVRVFZOUkdkM1RqRnZXRVJV
+VFRKTlZFRjRUa1JCTlFwTlJHZDNUakZ2ZDBaVVJWUk5Ra1ZIUVRGVlJVRjRUVXRoTTFacFdsaEtk
+VnBZVW14amVrTkRRVk5KZDBSUldVcExiMXBKYUhaalRrRlJSVUpDVVVGRUNtZG5SVkJCUkVORFFW
+RnZRMmRuUlVKQlN6ZFJjVzlSU1docE9XbGlVRWxUY1dOdmNWUXZXVWM1TldKd1pYWmpLM1pTVGpK
+MFVGVTJaRmRrVGl0WVpXb0tNRlZsWVVZNWNVNVpWVE5pVlUxWFdpc3lOa3B4TlhWaVozUXJhVmw1
+T1hkMVIzSmpkWEJMTm1aMlpqSm5SRUpCUWxOcVNUaHBlRzF3ZVVoMlJYaG9iZ3BNT1N0RVEzUmtN
+MnB2YkN0UFVscE5VVzlVVWxGWFJFSjZZV3RpWmtWclJFb3lORkpaVjI1clltVnZOa05qZGtKRGJF
+MVRlVXBSTVRKYWNXRTVWMk41Q2pjNE0waGlVRzFIT0ZGMlozbFZNMWRwTjFCM2JIRndhbGRuYkVw
+bmVXTklNSEJ3VDBaSU0zaFhMeXRwYjBsUE9UWk9MMWt3TmxKclJtVnlTSE40YlNzS2NrdHFWV1Ew
+VFhkTVZISkpSMmxUY2xwV2IxRXdPWGg1TUhKTkwwTllNWHAwTmtOalIxRmtOMHh2VTJKb1N6ZHhV
+MjFFT1VNeVZ6azBiWFZKUTAweWNncElRbUpWZG5Ock5VZEpMMUZUVDNsd1pHVnZlRWR1UVVsMmFF
+OTNTV0p5YmxacVNtcEZXR3REUVhkRlFVRmhUbXBOUjBWM1JHZFpSRlpTTUZCQlVVZ3ZDa0pCVVVS
+QlowVkhUVUU0UjBFeFZXUkZkMFZDTDNkUlJrMUJUVUpCWmpoM1NGRlpSRlpTTUU5Q1FsbEZSa0kz
+ZG10dE5rdE9kVUo0ZGxWMFZtRkhUbWdLZFZCWlltSjFVR2hOUWpoSFFURlZaRWwzVVZsTlFtRkJS
+a0kzZG10dE5rdE9kVUo0ZGxWMFZtRkhUbWgxVUZsaVluVlFhRTFCTUVkRFUzRkhVMGxpTXdwRVVV
+VkNRM2RWUVVFMFNVSkJVVUl6Y1hBeE5raGljbFJLZVZKYU1reHRlblZpZUhvMFVIWkxMMFpvTjJF
+d09FRnpOMWhQVkdZck56aHFMemRoZG5ZM0NsQmlMMXBETm1SWVNsbENMelpoU1ZkaVRXOTBTazFN
+WXpWMWJYSlVPRkZCU2tjcmJqZFVNVE5LWjJ4WWVFNTZVVWxQYVRsUEsyaFZkR0l3VTFCWmNsVUtO
+MGw2T0haek0zSlFNV3hOUjFRdlpqWklWRVZpYWpneFUwSmlURkJHYmpKVEwwTXhNa0psUm1jeGVt
+eG1TSGN3VFV4WWFTdGlVa1F5YzFrMFZXdzBid3BaYmxKWmIwSmpaV2cwVUVGQmJWZFlkbU0zWmtN
+cmFWRmpkbGRYVlZveVJFdG9jMEpLTVZsdmVrcEVZWEV3V0dvM2JFeGlVVFYzVUdWaVkzcEJRVFo1
+Q21wcVZTOUVNRTU2TVRSdWREWTFLMk42TjNOVVZtTjVaMnBzWnk4MVNFdDFOUzluWlRZcmJUbHRN
+a0YyYldwTlQyNXVSMndyWjAwMmMySXhhRXBEWVRjS2JHb3JhazQyT0U5a1EwWmFMMXBWZURaRVRY
+VkJTRzVYZUdOMlRYY3JVR0ZqV25KVUNpMHRMUzB0UlU1RUlFTkZVbFJKUmtsRFFWUkZMUzB0TFMw
+SwpDQV9LRVk6IExTMHRMUzFDUlVkSlRpQlNVMEVnVUZKSlZrRlVSU0JMUlZrdExTMHRMUXBOU1Vs
+RmIzZEpRa0ZCUzBOQlVVVkJjblJEY1doQmFVZE1Na3B6T0doTGNIbHBjRkE1WjJJemJIVnNOamw2
+TmpsRk0yRXdPVlJ3TVZvd016VmtObEJTQ2xJMWIxZ3liekZvVkdSMFVYaGFiamRpYjIxeWJUVjFR
+ek0yU21wTU0wTTBZWFI1Tm10eWNDczVMMkZCVFVWQlJrdE5hbmxNUjJGdVNXVTRWRWRIWTNZS016
+Uk5TekV6WlU5cFdEUTFSbXQ0UTJoT1JrSlpUVWhPY1ZKME9GTlJUVzVpYUVab1lXVlNkRFpxYjBw
+NU9FVkxWWGhNU1d4RVdGcHRjSEl4V25wTWRncDZZMlJ6SzFsaWVFTXJSRXBVWkdGTWN5OURWM0Z0
+VG1GRFZXMUVTbmRtVTIxck5GVm1aa1ppTHpaTFoyYzNNMjh6T1dwVWNFZFJWalp6WlhwSFlqWnpD
+bkZPVWpObmVrRjBUM05uWVVwTGRHeFhhRVJVTTBoTVUzTjZPRXBtV0U4emIwcDNXa0l6YzNWb1Nu
+VkZjblZ3UzFsUU1FeGFZak5wWVRSblNYcGhjMk1LUm5SVEszbFVhMWxxT1VKSk4wdHNNVFpxUldG
+alFXa3JSVGRCYUhWMVpGZE5iVTFTWlZGSlJFRlJRVUpCYjBsQ1FVSjNWMHcwWkVkSVZsVkpiMk4w
+YXdvemVqSTFabG8xWjAxVVFtZGpPVFk0YVVWR1ZIcGlRVzEzT0RCcmRFbFBXalJPY0RWelowcDBk
+VFptTkV0RGNWQlhiSFpCY0RjNE5VSmFXbkp5ZW1KckNta3JVM3BqVWtJMVFWVlNVM0ZRWWxodGJI
+bGhjVWh2YTNobk5rbE1SU3MzTmtjcmJsUk1jMmR1VG1sUVdFcHRkVUl2VUhnNFQybGpVM0pCTUZZ
+NGQyUUtVWGd3UjJ0MGJXbEdNWHBQUmxaMlZYVktWblpUTmxkM1dIcFdaRVY2YldrMGVGSkRNVmx6
+UXpKMVZYRnJUMEpCZEVGWU5rZDVWVXN5U2pSV1ZEVXZWUXBRU1RKS2NsaDVRMUV4TDAwM01tZHZN
+VTFVZVhVNU1GVnpRek51ZFZoSE5YazNOV0pQYUdSUVVUVm9RMEZCT1RabE1qbG1hRWRSVFc1RFRG
+Tm9lWGt3Q2pSUlRtdENhSEZLYmxsRWJWWmpSbWhVYzFSV1RUaHdhbmQzVmxSR1Yyd3lXVTkwYnpk
+WFVWQkVRMmhVWTFsdFZVWTJSR2N3UmxsNmFUVXJjMHRXYkdnS1dIRjRWRTVYT0VObldVVkJNU3Ra
+ZUdKNmJUaFRjRGwwYlRkbmRIZ3ZXRUpzY0hFMFdGVnJlV1UyVkRFMWRDOWFiMk5YVVhCS1NsWnNT
+SGRSWkdsRGNncDJiRUpoZVVGVmEwVkhjSGhhTURGR2QwSkhZVlZUWkd4UU0yWmljMkl6ZG1sT01G
+VTNlVEIxVWtWQ1JESm5OMlJrV0ZwRk5IbFllVWhTVUVSdlMxcDVDbFpEU3pCNlMyOUxlRVZVUVc0
+NVZuaFdSbkJaYWpCRFRtRmhRMGwxYlRaTE5HeEhZMnRJYkRSVVZFbHlhVlZDWW5nNVNuZFpjRGhE
+WjFsRlFYb3dhaklLV21aaWNIVkxTRll2Vms5d05sVnhSMnRGTldGRVZqZERhRnB4VlM5dmFrSlRV
+REkxZFhCeGQyOVdjVTFUWmpJMk5XUktiV3N6ZUZNemNDdEdUMU5ETkFveFMyNVVkVTVGY1ZoMFEx
+Qm5SaTlGWVUxaFZWZG1jVFF5Wm5CbU1EbHdTMkZQYmtoRVNIazJNazlpUVZoVkt6bFdXbk40YkZO
+dFZWVXZZMnd6ZGpaSUNraEJWblJSUW5CM1UwVlFNVkE1TVhsUFFuVXlVR3RFVWxsc0swZEJWbUZQ
+Ym10V1FtSlBZME5uV1VKemRuTlBWR3hIT0dzemVIbHJXRXRoYVcwd1UwSUtRMVJzYm5NeVF6aExj
+VU0xVVROTmNFdHZkbkZsVGt0dFZXSTRkelJOTDJkemFsTnViblk1UmxSWVowWlphMU5LY3psUldX
+OUdSVGxKVEVGR00xQmtlZ28xYm1WUE5sTkVkWFUzWm5oak5UbFZaRTVtU2pKbGExUm5SWElyT1do
+bFRVUldZMjQxTVZrMEsySkNWVlZZZDJRd2MzWmphVGxWZFhGUE9URnBRWGh2Q2xwc1kwZzFhMk5W
+UTNaemJYcE5XSFExYVRnMVdGRkxRbWRHUm01SVQxTlZTRGx6VkV0NE9VOUpUbUoxU0c5U1JGVk9a
+MXBWTldveVUxQmlaM0U0T1VnS1dGRXpjMDg1ZEZJelVFRmtlR0V3Um1kTFZVRXlUMW95YWxCSlVH
+RkxZVlJtZDAxQmJWaDNZM1ZaTkROcVkwWTNOSEo1YmxkeWNUTjFZbEpRT0VwTVl3bzBMeXREWVVS
+d1VDOUdSbTk0UkdvdlRtMTVWM0JLY1Vrek5GZEJVRmd3UTI5S1NHaEZRMFpDUm5WdllYaEJWa2N4
+VERSRU5VaGhWMloxUXpsd2EzQXZDbVJvT0ZoQmIwZENRVXRhVFVGVFFsUlhUbUpPVkZCVVMzWnpX
+bVUxYkVacFQxZFBaMHhWWjFKd1VYbEZaSFJ4ZUhsd2FXOWxWMFJJYkcxbmJVNVNLMGNLWTJNNFRu
+VmpSWE5CU2tzME1DOWlORXRTYUVWRVNWZ3lTREF6VXpCSWRYaHdURWx4UVdkMGEyNTVaQ3RaVTJS
+WVF5OXJaRFV4TDB0a1oxbElVelJRYlFwNVFWZDJLMEZsWmpReVEzZ3pVRVpDWm1Ock5ub3ZOR0ZF
+YURsSFpteExVbmhwTkZOa1pGRnRkbHBHYzNKVFJrdGFOVVUzQ2kwdExTMHRSVTVFSUZKVFFTQlFV
+a2xXUVZSRklFdEZXUzB0TFMwdENnPT0KQ0xVU1RFUl9JUF9SQU5HRTogMTAuMjQ0LjAuMC8xNgpE
+TlNfRE9NQUlOOiBjbHVzdGVyLmxvY2FsCkROU19SRVBMSUNBUzogIjEiCkROU19TRVJWRVJfSVA6
+IDEwLjAuMC4xMApET0NLRVJfU1RPUkFHRTogYXVmcwpFMkVfU1RPUkFHRV9URVNUX0VOVklST05N
+RU5UOiAiIgpFTEFTVElDU0VBUkNIX0xPR0dJTkdfUkVQTElDQVM6ICIxIgpFTkFCTEVfQ0xVU1RF
+Ul9ETlM6ICJ0cnVlIgpFTkFCTEVfQ0xVU1RFUl9MT0dHSU5HOiAidHJ1ZSIKRU5BQkxFX0NMVVNU
+RVJfTU9OSVRPUklORzogaW5mbHV4ZGIKRU5BQkxFX0NMVVNURVJfUkVHSVNUUlk6ICIiCkVOQUJM
+RV9DTFVTVEVSX1VJOiAidHJ1ZSIKRU5BQkxFX0w3X0xPQURCQUxBTkNJTkc6IG5vbmUKRU5BQkxF
+X05PREVfTE9HR0lORzogInRydWUiCkVYVFJBX0RPQ0tFUl9PUFRTOiAiIgpIQUlSUElOX01PREU6
+ICIiCklOU1RBTkNFX1BSRUZJWDogdGVzdGNsdXN0ZXIKS1VCRV9BRERPTl9SRUdJU1RSWTogIiIK
+S1VCRV9ET0NLRVJfUkVHSVNUUlk6ICIiCktVQkVfSU1BR0VfVEFHOiAiIgpLVUJFX1BST1hZX1RP
+S0VOOiBrdWJlLXByb3h5LXRva2VuCktVQkVMRVRfQ0VSVDogTFMwdExTMUNSVWRKVGlCRFJWSlVT
+VVpKUTBGVVJTMHRMUzB0Q2sxSlNVUktha05EUVdjMlowRjNTVUpCWjBsUlJsQTNZbW8zVGxoRlZs
+ZHNhR3RtZUdoak1WUTFla0ZPUW1kcmNXaHJhVWM1ZHpCQ1FWRnpSa0ZFUVZZS1RWSk5kMFZSV1VS
+V1VWRkVSWGR3Y21SWFNteGpiVFZzWkVkV2VrMUNORmhFVkVreVRWUkJlRTVVUVRWTlJHZDNUakZ2
+V0VSVVRUSk5WRUY0VGtSQk5RcE5SR2QzVGpGdmQwVnFSVkZOUVRSSFFURlZSVUY0VFVoaE0xWnBX
+bGQ0YkdSRVEwTkJVMGwzUkZGWlNrdHZXa2xvZG1OT1FWRkZRa0pSUVVSblowVlFDa0ZFUTBOQlVX
+OURaMmRGUWtGS1luTkRVbXQyU0VReWFteE5hbEZFUW1STVdsZHZMMUU0VVVoQ2FYaE9VSGxCVEhw
+MWRuZExXVGgyYzNacE5saEJOVXNLY1hSTmVGWk9RM2MyTjNBelVqZHVjaXMxWkZSbGFIUm5hWFl2
+YlhGMFVYbGpNa1JUYjBWcFdGaG9ZVVowVkVWUVYwWnhORVZMWVVGMGJFNWhjakpoTkFwWUwwbE1i
+SFUyWW5ocVVHcHhPSGxaVEVoTGJqbEtZalpDYTBwbWEyWlRNa2w0ZDFGd2NtbG1MMUY2ZWxCSmFI
+WnhXVnBZVFRkdGR6WXpaWHA0TWtJNUNta3hXVFZJZEdSdlJERTBXSGg2ZW5GVmMxVlBNVmxvWkcx
+cldGUlhWamx0WW1RelVXSTJkVGxJWm5CVVVFRnlObTF6TTFkUFJrSkJSbUYyTkROUGJFc0taelJM
+ZVVwNVJVWTFVVTlVTTNCeE9UZFFNMjlDTW1OS1ZuVTViSFJVYm5Od1YyTlZPV3RuWTFsemIxb3Jl
+blp1TDNsaFpYY3JSVGRTU1c1aWJUUnVRZ28zTUdKRU4ycEVURGszVVVGaGFua3paakpPWVVOdFdE
+VmxWMWRMVlRkV01WcGxSVU5CZDBWQlFXRk9NVTFJVFhkRVoxbEVWbEl3VUVGUlNDOUNRVkZFQ2tG
+blpVRk5RazFIUVRGVlpFcFJVVTFOUVc5SFEwTnpSMEZSVlVaQ2QwMURUVUYzUjBFeFZXUkZkMFZD
+TDNkUlEwMUJRWGRJVVZsRVZsSXdUMEpDV1VVS1JrMWpXbEZCUzNOeU1rZFJlRmxOYURKWVJHWTRP
+VWhqVmtVNGVFMUNPRWRCTVZWa1NYZFJXVTFDWVVGR1FqZDJhMjAyUzA1MVFuaDJWWFJXWVVkT2FB
+cDFVRmxpWW5WUWFFMUJNRWREVTNGSFUwbGlNMFJSUlVKRGQxVkJRVFJKUWtGUlFtVnBVVloyWm5a
+S0x6bEhjemxDYldvNFNERnBlSHBDU1VoWVIwdENDbTF6YjJZd2VHbFJaSE5STlZaNE1IWlJhbTlw
+V25SVGNFNWxiVzFSTUdOcEt6RTJTMmxYTWxKRkx6VXJWRWQyT0d0b2NqTXhSRGxwT0UxM2FqVlFU
+UzhLUVVOQlRHb3hkRFJ1Y2sxNVowUldTVkF5V1dkd01rZEVRVzV3UkRaQ05IQm1TemxLUjJSVmFW
+bGtZbkJ0ZG05emRqWlpVMWxoWjBKclRXSnRaRmRCUVFwTVRXdG9iVWxWY0hOTGVsSkpPRzlVVUc1
+SWJIZDVlbGw1TW5wcVRuRXZXRUZ4V2taMFVUZG5hVFJNVldOS2R6QnBhazVyWkd0UVozaHBZMHB4
+ZFdGUENrTnBSa2xpTlVJeVJrUXdSa2x6SzNZeVFqQmFkVXBRTTFrNGIyZEROM0Z0YzFkVU1rWnNi
+V2wwYVZkblV6SkNVbFpsVVhWMGJtSm1UWGcyVFRoUmJuTUtlbU5qYTJFMWRsWndXbk41VW1wa1F6
+SjZSVGRVTDJWaldWaE5VRGRQTjBWcWFtVm9UblF2UmpOWGFVaFBkM2RSZW1RMk5ESXZiRlFLTFMw
+dExTMUZUa1FnUTBWU1ZFbEdTVU5CVkVVdExTMHRMUW89CktVQkVMRVRfS0VZOiBMUzB0TFMxQ1JV
+ZEpUaUJTVTBFZ1VGSkpWa0ZVUlNCTFJWa3RMUzB0TFFwTlNVbEZjRUZKUWtGQlMwTkJVVVZCYkhW
+M1NrZFRPR05RWVU5VmVVNUJUVVl3ZEd4aGFqbEVlRUZqUjB4Rk1DOUpRWFpQTmk5QmNHcDVLM2ty
+VEhCakNrUnJjWEV3ZWtaVk1FeEVjblZ1WkVoMVpYWTNiREZPTmtjeVEwc3ZLMkZ4TVVSS2VsbE9T
+MmRUU21SbFJtOVhNVTFST1ZsWGNtZFJjRzlETWxVeGNYWUtXbkpvWmpobmRWYzNjSFpIVFN0UGNu
+cEtaM05qY1dZd2JIWnZSMUZzSzFJNVRGbHFTRUpEYlhWS0x6bEVVRTA0YVVjcmNHaHNZM3AxWWtS
+eVpEZFFTQXBaU0RKTVZtcHJaVEV5WjFCWWFHWklVRTl3VTNoUk4xWnBSakpoVW1ST1dsZ3lXblF6
+WkVKMmNUY3daQ3RzVFRoRGRuRmhlbVJaTkZWRlFWWnhMMnBqQ2paVmNVUm5ja2x1U1ZGWWJFRTFV
+R1Z0Y2pOekwyVm5TRnAzYkZjM01sY3hUMlY1YkZwNFZESlRRbmhwZVdodU4wOHJaaTlLY0RkRU5G
+UjBSV2xrZFdJS2FXTklkbEp6VUhWTlRYWXpkRUZDY1ZCTVpDOVpNVzlMV21ac05WcFpjRlIwV0Za
+c05GRkpSRUZSUVVKQmIwbENRVU40VDFFNFpVVmhTV0ZDTms1S2FBcElWVTlVWVRCVVlrdE5TVFpE
+Tm5CcGVsRmlWRFJLTW5OS1p6Z3laMmhTTlRWVU9Tc3hVa3BzT1RRdlEyVktiVWh1WlU1eVlucE5O
+RWQxT1VKcmRIcFpDazB3ZEZSUGEweDNORzlSWkVJNE5sWXhlVVE1WWpoT1IwdFJSWGRhVWxsdlZq
+ZHFVWEJNTkZObUt6aDRhRTlxUkhwRVNXMWtSbTRyYVRWUWN6a3plSGdLZDFFdk1XcHRORlpTTVZO
+S1FqQnNVR1ZRVWxkNk5EUXdUMGRsY0VsTVdrdHFiWEU1TkhKWE55OUVlVWRrYzI1NFVrWXJTR2hR
+ZVRGM1lscEhXRkpJWkFwbVJWWkdVVzV6YkVSVFJXcEVkRUZKZUVoRGRXcDNVM2xHYlZKYVNYUllX
+Vmd2VlhwRlRYRXlOV2hPT1RNNFZHWmFaR2c0Wm5sb2JFcGtNR281Y1ZnM0NtbFplVE5vUjJ4UFJt
+UTFiRU5JTXl0c2RXMXdUVUpPY25CcFJ6VlBNR3BSV0RoTFEzTnNjbTlGY0RrelpGSndjVE5WYTNS
+bk1ISmFUVXcwYlZOM1dtSUtOR3dyU2paNk9FTm5XVVZCZUZZemFtaFlabHBhV0ZsRFVXcFFZVzlP
+VkdkbGNWZEJRaTlTVkdKU2RXVnJUR2t6UVM4NFpHVktUblJIZEdGYVVHODNSQXBqYVRrNVIzQnJT
+Mko1TWxaNU0yczNMelZSU1c1T1NYSnNjMkp6TW1KdlFsZDFabEZHWVU5SE1EbG9Ua3RzUlhkaVlU
+VnBhRGdyTldKNGR6ZHJiMWxuQ2padFFVUkVUVTFyTm5KWVJUQmthbk56V2xwdVdTc3dPVmRhU1hK
+RVJVOUtkVVV2VnpVelVFVnFVM1J5UVRjNGNVOVJOaXR2VnpoRFoxbEZRWGM0U0hZS0swTXdkRWRo
+Vm10cFVFNTFiamxMVVdWdGJrdFlhVWgyV1dONFNqVnVOV1EzT0RBMlNsUkdPRk5JV2xKM2F6UXhT
+MWhaWTNSbGJWRkVOSFF3ZEdkRVdnb3hXazFrTVVOMWNtUlNjRkV2Wml0RlEzQlBTeko2VkdoSmJq
+ZFlOa0kyWjNwRUszQlZVSHBzVGxsb2RtcEZORk5OUmtaTlZWWk5PVmhaYjBZNU1IRkVDalIxZFVF
+NVpEWkVWMDFuTDI5a2J6QXpPV1l5YVZKR09ETlpUa1kyUWtSYU1VdEZkMHBoT0VObldVVkJibTlR
+VmpWTVFuQmlheTlzWVVnMU1VeFpPVXdLTmpkV1dYRjNjbFZ3YlVRd1lsa3JkR3BuZDNOQk1XVkZN
+MWhXVjBKNVVuRllVamdyTHpCdVJGVTFMM1JITUhJemIxVkhiakpUZDBSNmEyeGxiVlpCU0FwSWNH
+WnZWRVJxVjFwUVltOXZkMEZ4VVZNd05TOWFTV05uVG5wUlF6bExiVkpTWkhZeU5sRjJNV3RMTDBo
+VWREUm1kbXR3WlU1NGJERmlZa3d4UlVjMkNreHpiMUU1UkhaTGRGRmpWMGxEUVRsTVV6VnRaRkZy
+UTJkWlFrMXZPRGhLWjA1YVdscG9SbGxPVFZsdmRVVXhjMUEyVEVVck9VRXliamMyU0VOUk5Vb0tO
+V3BXVUdadVNrVkNSalkyUkdSb2RWcFNiVlZrZERZMU1UQldXVkpFYTBWcWRGcHpla3hSVWpSeU1q
+SXdObE5yV2pKT1JITjNORFZpVTA5dFkzVmtUZ3BVT1dSQlNpdExjemxNSzBoV1RVWXlVRlF6T1RC
+UVRVUm1hR2RpTW5kaFRHWjBkemhhYlU1VFJGRlJjMjQzZEROeU5YVk1lV3hpZVdWdlUxSXJTbUoy
+Q2pCcVQwSmlVVXRDWjFGRGVEaEVSMnMxVUU5bE9VOWFRVU5xVUhFMlNYUm1hVzFNUkRsc1FVZERj
+V3hPV0dsd2JGbzBPR2xUVFZoS1RtUlJWMUZDTkVZS2VIa3ljMGx2VjJ0aU9WRldPVFk0TjJaaWEw
+RkNOV294Y21GcE1WSlJTVlpxZWxGSVFsWjVWVEpTWkdOVWMwWldaREZSVkZaV1R6aHhaMjh2TlRk
+cFFRcElVbnBIVkhSbVltbEpjRlkxYlV4TldXUkRUa0ZQWmpOdlZreExRamMzVDBFMFEwbHRNbTQx
+ZWtKSVYwNHdSVnBET0cxRk0zYzlQUW90TFMwdExVVk9SQ0JTVTBFZ1VGSkpWa0ZVUlNCTFJWa3RM
+UzB0TFFvPQpLVUJFTEVUX1RPS0VOOiBrdWJlbGV0LXRva2VuCktVQkVSTkVURVNfTUFTVEVSOiAi
+ZmFsc2UiCktVQkVSTkVURVNfTUFTVEVSX05BTUU6IHRlc3RjbHVzdGVyLW1hc3RlcgpMT0dHSU5H
+X0RFU1RJTkFUSU9OOiBlbGFzdGljc2VhcmNoCk1BU1RFUl9JUF9SQU5HRTogMTAuMjQ2LjAuMC8y
+NApORVRXT1JLX1BST1ZJREVSOiBub25lCk5PREVfSU5TVEFOQ0VfUFJFRklYOiB0ZXN0Y2x1c3Rl
+ci1taW5pb24KTk9OX01BU1FVRVJBREVfQ0lEUjogIiIKT1BFTkNPTlRSQUlMX0tVQkVSTkVURVNf
+VEFHOiAiIgpPUEVOQ09OVFJBSUxfUFVCTElDX1NVQk5FVDogIiIKT1BFTkNPTlRSQUlMX1RBRzog
+IiIKUlVOVElNRV9DT05GSUc6ICIiClNBTFRfVEFSX0hBU0g6IGIyOTVkMTE3MTM1YTk3NjNkYTI4
+MmU3ZGFlNzNhNWNhN2QzZTViMTEKU0FMVF9UQVJfVVJMOiBodHRwczovL3MzLmFtYXpvbmF3cy5j
+b20vdGVzdGNsdXN0ZXItYXJ0aWZhY3RzL2RldmVsL3Rlc3RjbHVzdGVyL3NhbHQtYjI5NWQxMTcx
+MzVhOTc2M2RhMjgyZTdkYWU3M2E1Y2E3ZDNlNWIxMQpTRVJWRVJfQklOQVJZX1RBUl9IQVNIOiAz
+ZGU0ZjkwMWZmZmIzMGFjNzIwYjBlN2ViNjU0YjRmYWEyZGQwM2ZhClNFUlZFUl9CSU5BUllfVEFS
+X1VSTDogaHR0cHM6Ly9zMy5hbWF6b25hd3MuY29tL3Rlc3RjbHVzdGVyLWFydGlmYWN0cy9kZXZl
+bC90ZXN0Y2x1c3Rlci9zZXJ2ZXItM2RlNGY5MDFmZmZiMzBhYzcyMGIwZTdlYjY1NGI0ZmFhMmRk
+MDNmYQpTRVJWSUNFX0NMVVNURVJfSVBfUkFOR0U6IDEwLjAuMC4wLzE2ClpPTkU6IHVzLWVhc3Qt
+MWIKCkVfT19GCgp3Z2V0IC1PIGJvb3RzdHJhcCAnaHR0cHM6Ly9zMy5hbWF6b25hd3MuY29tL3Rl
+c3RjbHVzdGVyLWFydGlmYWN0cy9kZXZlbC90ZXN0Y2x1c3Rlci9ib290c3RyYXAtYjhhNGRjNmQz
+ODFlZDdkM2JiOTI0NjRmNzQyMGE0MmE4MzIyYTM0OCcKY2htb2QgK3ggYm9vdHN0cmFwCm1rZGly
+IC1wIC9ldGMva3ViZXJuZXRlcwptdiBrdWJlX2Vudi55YW1sIC9ldGMva3ViZXJuZXRlcwptdiBi
+b290c3RyYXAgL2V0Yy9rdWJlcm5ldGVzLwpjYXQgPiAvZXRjL3JjLmxvY2FsIDw8IEVPRl9SQ19M
+T0NBTAojIS9iaW4vc2ggLWUKL2V0Yy9rdWJlcm5ldGVzL2Jvb3RzdHJhcApleGl0IDAKRU9GX1JD
+X0xPQ0FMCi9ldGMva3ViZXJuZXRlcy9ib290c3RyYXAK
+EOF_RESOURCE
+
+PERSISTENTVOLUME_1=$(aws ec2 describe-volumes --filters Name=tag:KubernetesCluster,Values=testcluster Name=tag:Name,Values=testcluster-master-pd --query 'Volumes[0].VolumeId')
+if missing "${PERSISTENTVOLUME_1}"; then
+  PERSISTENTVOLUME_1=$(aws ec2 create-volume --availability-zone us-east-1b --volume-type gp2 --size 20 --query VolumeId)
+fi
+add-tag "${PERSISTENTVOLUME_1}" KubernetesCluster testcluster
+add-tag "${PERSISTENTVOLUME_1}" Name testcluster-master-pd
+ELASTICIP_1_PUBLICIP=$(aws ec2 describe-tags --filters "Name=resource-id,Values=${PERSISTENTVOLUME_1}" Name=key,Values=kubernetes.io/master-ip --query 'Tags[0].Value')
+if ! missing "${ELASTICIP_1_PUBLICIP}"; then
+  ELASTICIP_1=$(aws ec2 describe-addresses --public-ips "${ELASTICIP_1_PUBLICIP}" --query 'Addresses[0].AllocationId')
+fi
+if missing "${ELASTICIP_1}"; then
+  ELASTICIP_1=$(aws ec2 allocate-address --domain vpc --query AllocationId)
+fi
+ELASTICIP_1_PUBLICIP=$(aws ec2 describe-addresses --allocation-ids "${ELASTICIP_1}" --query 'Addresses[].PublicIp')
+add-tag "${PERSISTENTVOLUME_1}" kubernetes.io/master-ip "${ELASTICIP_1_PUBLICIP}"
+IAMROLE_1=$(aws iam get-role --role-name kubernetes-master --query Role.RoleId 2>/dev/null || true)
+if missing "${IAMROLE_1}"; then
+  IAMROLE_1=$(aws iam create-role --role-name kubernetes-master --assume-role-policy-document "file://${KOPE_RESOURCES}/FileResource_1" --query Role.RoleId)
+fi
+IAMROLEPOLICY_1=$(aws iam get-role-policy --role-name kubernetes-master --policy-name kubernetes-master --query PolicyName 2>/dev/null || true)
+if missing "${IAMROLEPOLICY_1}"; then
+  aws iam put-role-policy --role-name kubernetes-master --policy-name kubernetes-master --policy-document "file://${KOPE_RESOURCES}/FileResource_2"
+fi
+IAMINSTANCEPROFILE_1=$(aws iam get-instance-profile --instance-profile-name kubernetes-master --query InstanceProfile.InstanceProfileId 2>/dev/null || true)
+if missing "${IAMINSTANCEPROFILE_1}"; then
+  IAMINSTANCEPROFILE_1=$(aws iam create-instance-profile --instance-profile-name kubernetes-master --query InstanceProfile.InstanceProfileId)
+fi
+IAMINSTANCEPROFILEROLE_1=$(aws iam get-instance-profile --instance-profile-name kubernetes-master --query "InstanceProfile.Roles[?RoleName=='kubernetes-master'].RoleName" 2>/dev/null || true)
+if missing "${IAMINSTANCEPROFILEROLE_1}"; then
+  aws iam add-role-to-instance-profile --instance-profile-name kubernetes-master --role-name kubernetes-master
+fi
+IAMROLE_2=$(aws iam get-role --role-name kubernetes-minion --query Role.RoleId 2>/dev/null || true)
+if missing "${IAMROLE_2}"; then
+  IAMROLE_2=$(aws iam create-role --role-name kubernetes-minion --assume-role-policy-document "file://${KOPE_RESOURCES}/FileResource_3" --query Role.RoleId)
+fi
+IAMROLEPOLICY_2=$(aws iam get-role-policy --role-name kubernetes-minion --policy-name kubernetes-minion --query PolicyName 2>/dev/null || true)
+if missing "${IAMROLEPOLICY_2}"; then
+  aws iam put-role-policy --role-name kubernetes-minion --policy-name kubernetes-minion --policy-document "file://${KOPE_RESOURCES}/FileResource_4"
+fi
+IAMINSTANCEPROFILE_2=$(aws iam get-instance-profile --instance-profile-name kubernetes-minion --query InstanceProfile.InstanceProfileId 2>/dev/null || true)
+if missing "${IAMINSTANCEPROFILE_2}"; then
+  IAMINSTANCEPROFILE_2=$(aws iam create-instance-profile --instance-profile-name kubernetes-minion --query InstanceProfile.InstanceProfileId)
+fi
+IAMINSTANCEPROFILEROLE_2=$(aws iam get-instance-profile --instance-profile-name kubernetes-minion --query "InstanceProfile.Roles[?RoleName=='kubernetes-minion'].RoleName" 2>/dev/null || true)
+if missing "${IAMINSTANCEPROFILEROLE_2}"; then
+  aws iam add-role-to-instance-profile --instance-profile-name kubernetes-minion --role-name kubernetes-minion
+fi
+SSHKEY_1=$(aws ec2 describe-key-pairs --key-names kubernetes-14ddc5e31c57c0c6badd0e7c79408800 --query 'KeyPairs[0].KeyName' 2>/dev/null || true)
+if missing "${SSHKEY_1}"; then
+  aws ec2 import-key-pair --key-name kubernetes-14ddc5e31c57c0c6badd0e7c79408800 --public-key-material "file://${KOPE_RESOURCES}/StringResource_1"
+fi
+VPC_1=$(aws ec2 describe-vpcs --filters Name=tag:KubernetesCluster,Values=testcluster Name=tag:Name,Values=kubernetes-testcluster --query 'Vpcs[0].VpcId')
+if missing "${VPC_1}"; then
+  VPC_1=$(aws ec2 create-vpc --cidr-block 172.20.0.0/16 --query Vpc.VpcId)
+fi
+aws ec2 modify-vpc-attribute --vpc-id "${VPC_1}" --enable-dns-support '{"Value": true}'
+aws ec2 modify-vpc-attribute --vpc-id "${VPC_1}" --enable-dns-hostnames '{"Value": true}'
+add-tag "${VPC_1}" KubernetesCluster testcluster
+add-tag "${VPC_1}" Name kubernetes-testcluster
+DHCPOPTIONS_1=$(aws ec2 describe-dhcp-options --filters Name=tag:KubernetesCluster,Values=testcluster Name=tag:Name,Values=kubernetes-testcluster --query 'DhcpOptions[0].DhcpOptionsId')
+if missing "${DHCPOPTIONS_1}"; then
+  DHCPOPTIONS_1=$(aws ec2 create-dhcp-options --dhcp-configuration Key=domain-name,Values=ec2.internal Key=domain-name-servers,Values=AmazonProvidedDNS --query DhcpOptions.DhcpOptionsId)
+fi
+add-tag "${DHCPOPTIONS_1}" KubernetesCluster testcluster
+add-tag "${DHCPOPTIONS_1}" Name kubernetes-testcluster
+VPCDHCPOPTIONSASSOCIATION_1=$(aws ec2 describe-vpcs --vpc-ids "${VPC_1}" --query 'Vpcs[0].DhcpOptionsId')
+if [[ "${VPCDHCPOPTIONSASSOCIATION_1}" != "${DHCPOPTIONS_1}" ]]; then
+  aws ec2 associate-dhcp-options --dhcp-options-id "${DHCPOPTIONS_1}" --vpc-id "${VPC_1}"
+fi
+SUBNET_1=$(aws ec2 describe-subnets --filters Name=tag:KubernetesCluster,Values=testcluster Name=tag:Name,Values=kubernetes-testcluster --query 'Subnets[0].SubnetId')
+if missing "${SUBNET_1}"; then
+  SUBNET_1=$(aws ec2 create-subnet --cidr-block 172.20.0.0/24 --vpc-id "${VPC_1}" --query Subnet.SubnetId --availability-zone us-east-1b)
+fi
+add-tag "${SUBNET_1}" KubernetesCluster testcluster
+add-tag "${SUBNET_1}" Name kubernetes-testcluster
+INTERNETGATEWAY_1=$(aws ec2 describe-internet-gateways --filters Name=tag:KubernetesCluster,Values=testcluster Name=tag:Name,Values=kubernetes-testcluster --query 'InternetGateways[0].InternetGatewayId')
+if missing "${INTERNETGATEWAY_1}"; then
+  INTERNETGATEWAY_1=$(aws ec2 create-internet-gateway --query InternetGateway.InternetGatewayId)
+fi
+add-tag "${INTERNETGATEWAY_1}" KubernetesCluster testcluster
+add-tag "${INTERNETGATEWAY_1}" Name kubernetes-testcluster
+INTERNETGATEWAYATTACHMENT_1=$(aws ec2 describe-internet-gateways --internet-gateway-ids "${INTERNETGATEWAY_1}" --query 'InternetGateways[0].Attachments[0].VpcId')
+if missing "${INTERNETGATEWAYATTACHMENT_1}"; then
+  aws ec2 attach-internet-gateway --internet-gateway-id "${INTERNETGATEWAY_1}" --vpc-id "${VPC_1}"
+fi
+ROUTETABLE_1=$(aws ec2 describe-route-tables --filters Name=tag:KubernetesCluster,Values=testcluster Name=tag:Name,Values=kubernetes-testcluster --query 'RouteTables[0].RouteTableId')
+if missing "${ROUTETABLE_1}"; then
+  ROUTETABLE_1=$(aws ec2 create-route-table --vpc-id "${VPC_1}" --query RouteTable.RouteTableId)
+fi
+add-tag "${ROUTETABLE_1}" KubernetesCluster testcluster
+add-tag "${ROUTETABLE_1}" Name kubernetes-testcluster
+ROUTE_1=$(aws ec2 describe-route-tables --route-table-ids "${ROUTETABLE_1}" --query "RouteTables[0].Routes[?DestinationCidrBlock=='0.0.0.0/0'].DestinationCidrBlock")
+if missing "${ROUTE_1}"; then
+  aws ec2 create-route --route-table-id "${ROUTETABLE_1}" --destination-cidr-block 0.0.0.0/0 --gateway-id "${INTERNETGATEWAY_1}"
+fi
+ROUTETABLEASSOCIATION_1=$(aws ec2 describe-route-tables --route-table-ids "${ROUTETABLE_1}" --query "RouteTables[0].Associations[?SubnetId=='${SUBNET_1}'].RouteTableAssociationId")
+if missing "${ROUTETABLEASSOCIATION_1}"; then
+  ROUTETABLEASSOCIATION_1=$(aws ec2 associate-route-table --route-table-id "${ROUTETABLE_1}" --subnet-id "${SUBNET_1}" --query AssociationId)
+fi
+SECURITYGROUP_1=$(aws ec2 describe-security-groups --filters Name=tag:KubernetesCluster,Values=testcluster Name=tag:Name,Values=kubernetes-master-testcluster --query 'SecurityGroups[0].GroupId')
+if missing "${SECURITYGROUP_1}"; then
+  SECURITYGROUP_1=$(aws ec2 create-security-group --group-name kubernetes-master-testcluster --description 'Security group for master nodes' --vpc-id "${VPC_1}" --query GroupId)
+fi
+add-tag "${SECURITYGROUP_1}" KubernetesCluster testcluster
+add-tag "${SECURITYGROUP_1}" Name kubernetes-master-testcluster
+SECURITYGROUP_2=$(aws ec2 describe-security-groups --filters Name=tag:KubernetesCluster,Values=testcluster Name=tag:Name,Values=kubernetes-minion-testcluster --query 'SecurityGroups[0].GroupId')
+if missing "${SECURITYGROUP_2}"; then
+  SECURITYGROUP_2=$(aws ec2 create-security-group --group-name kubernetes-minion-testcluster --description 'Security group for minion nodes' --vpc-id "${VPC_1}" --query GroupId)
+fi
+add-tag "${SECURITYGROUP_2}" KubernetesCluster testcluster
+add-tag "${SECURITYGROUP_2}" Name kubernetes-minion-testcluster
+SECURITYGROUPINGRESS_1=$(aws ec2 describe-security-groups --group-ids "${SECURITYGROUP_1}" --query "SecurityGroups[0].IpPermissions[?IpProtocol=='-1'].UserIdGroupPairs[][?GroupId=='${SECURITYGROUP_1}'][].GroupId")
+if missing "${SECURITYGROUPINGRESS_1}"; then
+  aws ec2 authorize-security-group-ingress --group-id "${SECURITYGROUP_1}" --protocol all --source-group "${SECURITYGROUP_1}"
+fi
+SECURITYGROUPINGRESS_2=$(aws ec2 describe-security-groups --group-ids "${SECURITYGROUP_1}" --query "SecurityGroups[0].IpPermissions[?IpProtocol=='-1'].UserIdGroupPairs[][?GroupId=='${SECURITYGROUP_2}'][].GroupId")
+if missing "${SECURITYGROUPINGRESS_2}"; then
+  aws ec2 authorize-security-group-ingress --group-id "${SECURITYGROUP_1}" --protocol all --source-group "${SECURITYGROUP_2}"
+fi
+SECURITYGROUPINGRESS_3=$(aws ec2 describe-security-groups --group-ids "${SECURITYGROUP_2}" --query "SecurityGroups[0].IpPermissions[?IpProtocol=='-1'].UserIdGroupPairs[][?GroupId=='${SECURITYGROUP_1}'][].GroupId")
+if missing "${SECURITYGROUPINGRESS_3}"; then
+  aws ec2 authorize-security-group-ingress --group-id "${SECURITYGROUP_2}" --protocol all --source-group "${SECURITYGROUP_1}"
+fi
+SECURITYGROUPINGRESS_4=$(aws ec2 describe-security-groups --group-ids "${SECURITYGROUP_2}" --query "SecurityGroups[0].IpPermissions[?IpProtocol=='-1'].UserIdGroupPairs[][?GroupId=='${SECURITYGROUP_2}'][].GroupId")
+if missing "${SECURITYGROUPINGRESS_4}"; then
+  aws ec2 authorize-security-group-ingress --group-id "${SECURITYGROUP_2}" --protocol all --source-group "${SECURITYGROUP_2}"
+fi
+SECURITYGROUPINGRESS_5=$(aws ec2 describe-security-groups --group-ids "${SECURITYGROUP_2}" --query "SecurityGroups[0].IpPermissions[?IpProtocol=='tcp' && FromPort==\`22\` && ToPort==\`22\`].IpRanges[][?CidrIp=='0.0.0.0/0'][].CidrIp")
+if missing "${SECURITYGROUPINGRESS_5}"; then
+  aws ec2 authorize-security-group-ingress --group-id "${SECURITYGROUP_2}" --protocol tcp --port 22 --cidr 0.0.0.0/0
+fi
+SECURITYGROUPINGRESS_6=$(aws ec2 describe-security-groups --group-ids "${SECURITYGROUP_1}" --query "SecurityGroups[0].IpPermissions[?IpProtocol=='tcp' && FromPort==\`22\` && ToPort==\`22\`].IpRanges[][?CidrIp=='0.0.0.0/0'][].CidrIp")
+if missing "${SECURITYGROUPINGRESS_6}"; then
+  aws ec2 authorize-security-group-ingress --group-id "${SECURITYGROUP_1}" --protocol tcp --port 22 --cidr 0.0.0.0/0
+fi
+SECURITYGROUPINGRESS_7=$(aws ec2 describe-security-groups --group-ids "${SECURITYGROUP_1}" --query "SecurityGroups[0].IpPermissions[?IpProtocol=='tcp' && FromPort==\`443\` && ToPort==\`443\`].IpRanges[][?CidrIp=='0.0.0.0/0'][].CidrIp")
+if missing "${SECURITYGROUPINGRESS_7}"; then
+  aws ec2 authorize-security-group-ingress --group-id "${SECURITYGROUP_1}" --protocol tcp --port 443 --cidr 0.0.0.0/0
+fi
+INSTANCE_1=$(aws ec2 describe-instances --filters Name=tag:KubernetesCluster,Values=testcluster Name=tag:Name,Values=testcluster-master Name=instance-state-name,Values=pending,running,stopping,stopped --query 'Reservations[0].Instances[0].InstanceId')
+if missing "${INSTANCE_1}"; then
+  INSTANCE_1=$(aws ec2 run-instances --image-id ami-00000001 --instance-type m3.medium --key-name kubernetes-14ddc5e31c57c0c6badd0e7c79408800 --associate-public-ip-address --block-device-mappings '[{"DeviceName":"/dev/sdc","VirtualName":"ephemeral0"},{"DeviceName":"/dev/sdd","VirtualName":"ephemeral1"},{"DeviceName":"/dev/sde","VirtualName":"ephemeral2"},{"DeviceName":"/dev/sdf","VirtualName":"ephemeral3"}]' --security-group-ids "${SECURITYGROUP_1}" --iam-instance-profile Name=kubernetes-master --user-data "fileb://${KOPE_RESOURCES}/BytesResource_1" --subnet-id "${SUBNET_1}" --private-ip-address 172.20.0.9 --query 'Instances[0].InstanceId')
+fi
+add-tag "${INSTANCE_1}" KubernetesCluster testcluster
+add-tag "${INSTANCE_1}" Name testcluster-master
+add-tag "${INSTANCE_1}" Role master
+wait-for-instance-state "${INSTANCE_1}" running
+INSTANCEELASTICIPATTACHMENT_1=$(aws ec2 describe-addresses --allocation-ids "${ELASTICIP_1}" --query 'Addresses[0].AssociationId')
+if missing "${INSTANCEELASTICIPATTACHMENT_1}"; then
+  aws ec2 associate-address --allocation-id "${ELASTICIP_1}" --instance-id "${INSTANCE_1}"
+fi
+wait-for-instance-state "${INSTANCE_1}" running
+INSTANCEVOLUMEATTACHMENT_1=$(aws ec2 describe-volumes --volume-ids "${PERSISTENTVOLUME_1}" --query 'Volumes[0].Attachments[0].InstanceId')
+if missing "${INSTANCEVOLUMEATTACHMENT_1}"; then
+  aws ec2 attach-volume --volume-id "${PERSISTENTVOLUME_1}" --instance-id "${INSTANCE_1}" --device /dev/sdb
+fi
+AUTOSCALINGGROUP_1=$(aws autoscaling describe-auto-scaling-groups --auto-scaling-group-names testcluster-minion-group --query 'AutoScalingGroups[0].AutoScalingGroupName')
+if missing "${AUTOSCALINGGROUP_1}"; then
+  aws autoscaling create-launch-configuration --launch-configuration-name testcluster-minion-group-<timestamp> --image-id ami-00000001 --instance-type m3.medium --key-name kubernetes-14ddc5e31c57c0c6badd0e7c79408800 --associate-public-ip-address --block-device-mappings '[{"DeviceName":"/dev/sdc","VirtualName":"ephemeral0"},{"DeviceName":"/dev/sdd","VirtualName":"ephemeral1"},{"DeviceName":"/dev/sde","VirtualName":"ephemeral2"},{"DeviceName":"/dev/sdf","VirtualName":"ephemeral3"}]' --security-groups "${SECURITYGROUP_2}" --iam-instance-profile kubernetes-minion --user-data "file://${KOPE_RESOURCES}/NodeScript_1"
+fi
+if missing "${AUTOSCALINGGROUP_1}"; then
+  aws autoscaling create-auto-scaling-group --auto-scaling-group-name testcluster-minion-group --launch-configuration-name testcluster-minion-group-<timestamp> --min-size 2 --max-size 2 --vpc-zone-identifier "${SUBNET_1}" --tags ResourceId=testcluster-minion-group,ResourceType=auto-scaling-group,Key=KubernetesCluster,Value=testcluster ResourceId=testcluster-minion-group,ResourceType=auto-scaling-group,Key=Name,Value=testcluster-minion-group ResourceId=testcluster-minion-group,ResourceType=auto-scaling-group,Key=Role,Value=node
+fi
@@ -0,0 +1 @@
+# aws
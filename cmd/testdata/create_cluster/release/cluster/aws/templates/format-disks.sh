@@ -0,0 +1 @@
+# disks
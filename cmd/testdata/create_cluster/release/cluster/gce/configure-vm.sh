@@ -0,0 +1,3 @@
+#!/bin/bash
+#+AWS_OVERRIDES_HERE
+echo done
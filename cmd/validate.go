@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate resources",
+	Long:  `Checks that resources match their desired configuration.`,
+}
+
+func init() {
+	RootCmd.AddCommand(validateCmd)
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+	"github.com/spf13/cobra"
+)
+
+type ValidateClusterCmd struct {
+	Options CreateClusterCmd
+	Output  string
+}
+
+var validateCluster ValidateClusterCmd
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "cluster",
+		Short: "Validate cluster",
+		Long: `Compares the actual state of a k8s cluster with its configuration, without making any changes.
+
+Reports every resource that differs, and exits non-zero if any do.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := validateCluster.Run()
+			if err != nil {
+				glog.Exitf("%v", err)
+			}
+		},
+	}
+
+	validateCmd.AddCommand(cmd)
+
+	options := &validateCluster.Options
+	cmd.Flags().StringVarP(&options.StateDir, "dir", "d", "", "Directory to load & store state")
+	cmd.Flags().StringVar(&options.State, "state", "", "Shared state store, holding the state for each cluster by cluster id (e.g. s3://bucket/prefix, or a local directory)")
+	cmd.Flags().StringVar(&options.StateRegion, "state-region", "", "Region of the S3 bucket holding --state (defaults to $AWS_REGION)")
+	cmd.Flags().StringVar(&options.KeyManager, "key-manager", "", "Encrypt the private keys in the state: passphrase (read from $KOPE_KEY_PASSPHRASE), or file:PATH (a local key file, generated if missing)")
+	cmd.Flags().StringVarP(&options.ReleaseDir, "release", "r", "", "Directory to load release from")
+	cmd.Flags().StringVar(&options.S3Region, "s3-region", "", "Region of the S3 bucket")
+	cmd.Flags().StringVar(&options.S3Bucket, "s3-bucket", "", "S3 bucket for upload of artifacts")
+	cmd.Flags().BoolVar(&options.S3Private, "s3-private", false, "Keep the artifacts in S3 private, and give the instances presigned URLs")
+	cmd.Flags().DurationVar(&options.S3URLExpiry, "s3-url-expiry", fi.MaxPresignExpiry, "Expiry of the presigned URLs with --s3-private")
+	cmd.Flags().StringVarP(&options.SSHKey, "i", "i", "", "SSH Key for cluster")
+	cmd.Flags().StringVar(&options.ClusterID, "cluster-id", "", "cluster id")
+	cmd.Flags().StringVar(&options.CloudProvider, "cloud", "", "Cloud provider to use (overrides the state).  Supported: aws, gce")
+	cmd.Flags().StringVar(&options.Project, "project", "", "Google Cloud project (gce only)")
+	cmd.Flags().StringVar(&options.GCSBucket, "gcs-bucket", "", "GCS bucket for upload of artifacts (gce only)")
+	cmd.Flags().StringVar(&options.ArtifactsDir, "artifacts-dir", "", "Local directory for artifacts, instead of S3 or GCS")
+	cmd.Flags().StringVar(&options.ArtifactsURL, "artifacts-url", "", "URL at which the instances can reach the artifacts dir")
+	addParallelismFlag(cmd, &options.Parallelism)
+
+	cmd.Flags().StringVarP(&validateCluster.Output, "output", "o", "text", "Output format for the drift report.  Supported: text, json, yaml")
+}
+
+func (c *ValidateClusterCmd) Run() error {
+	if c.Output != "text" && c.Output != fi.PlanFormatJSON && c.Output != fi.PlanFormatYAML {
+		return fmt.Errorf("unsupported output format %q", c.Output)
+	}
+
+	cc, err := c.Options.buildCluster(nil)
+	if err != nil {
+		return err
+	}
+
+	// Resources are hashed, but not uploaded
+	target, err := fi.NewDryRunTarget(cc.filestore)
+	if err != nil {
+		return err
+	}
+
+	rc, err := cc.run(target, fi.ModeValidate, c.Options.Parallelism)
+	if err != nil {
+		return err
+	}
+
+	drift, err := rc.Drift()
+	if err != nil {
+		return err
+	}
+
+	// Changes that only affect references to other units are not drift
+	var changes []*fi.PlanChange
+	for _, change := range drift.Changes {
+		if change.Action != fi.PlanActionNoop {
+			changes = append(changes, change)
+		}
+	}
+	drift.Changes = changes
+	drift.Resources = nil
+
+	if c.Output == "text" {
+		printDriftReport(drift)
+	} else {
+		err = fi.WritePlan(os.Stdout, drift, c.Output)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(drift.Changes) != 0 || rc.IsDirty() {
+		return fmt.Errorf("cluster %q does not match its configuration", cc.k.ClusterID)
+	}
+	return nil
+}
+
+func printDriftReport(drift *fi.Plan) {
+	if len(drift.Changes) == 0 {
+		fmt.Printf("No resources have drifted\n")
+		return
+	}
+
+	for _, c := range drift.Changes {
+		if c.Action == fi.PlanActionCreate {
+			fmt.Printf("Missing: %s\t%s\n", c.Type, c.Path)
+			continue
+		}
+
+		fmt.Printf("Changed: %s\t%s\n", c.Type, c.Path)
+		for _, f := range c.Fields {
+			if f.Old != nil {
+				fmt.Printf("    %s %s (desired %s)\n", f.Name, *f.Old, f.New)
+			} else {
+				fmt.Printf("    %s\n", f.Name)
+			}
+		}
+	}
+}
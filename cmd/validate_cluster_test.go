@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/kopeio/kope/pkg/fi"
+)
+
+// TestValidateClusterAfterCreate checks that a cluster that was just created has not drifted
+func TestValidateClusterAfterCreate(t *testing.T) {
+	defer func(original func(string, map[string]string) *fi.AWSCloud) {
+		NewAWSCloud = original
+	}(NewAWSCloud)
+
+	c := newTestCluster(t)
+	defer c.Close()
+
+	create := c.options()
+	err := create.Run()
+	if err != nil {
+		t.Fatalf("error creating cluster: %v", err)
+	}
+
+	validate := &ValidateClusterCmd{Options: c.options(), Output: "text"}
+	err = validate.Run()
+	if err != nil {
+		t.Fatalf("expected no drift after create cluster, got: %v", err)
+	}
+}
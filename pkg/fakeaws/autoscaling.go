@@ -0,0 +1,290 @@
+package fakeaws
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+)
+
+// FakeAutoscaling is an in-memory implementation of the Autoscaling calls that kope makes.
+// Groups are recorded, but do not launch instances.
+type FakeAutoscaling struct {
+	autoscalingiface.AutoScalingAPI
+
+	mutex sync.Mutex
+
+	launchConfigurations map[string]*autoscaling.LaunchConfiguration
+	groups               map[string]*autoscaling.Group
+}
+
+var _ autoscalingiface.AutoScalingAPI = &FakeAutoscaling{}
+
+func newFakeAutoscaling() *FakeAutoscaling {
+	return &FakeAutoscaling{
+		launchConfigurations: make(map[string]*autoscaling.LaunchConfiguration),
+		groups:               make(map[string]*autoscaling.Group),
+	}
+}
+
+func autoscalingValidationError(message string, args ...interface{}) error {
+	return awserr.New("ValidationError", fmt.Sprintf(message, args...), nil)
+}
+
+func (f *FakeAutoscaling) CreateLaunchConfiguration(request *autoscaling.CreateLaunchConfigurationInput) (*autoscaling.CreateLaunchConfigurationOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name := aws.StringValue(request.LaunchConfigurationName)
+	if f.launchConfigurations[name] != nil {
+		return nil, awserr.New("AlreadyExists", fmt.Sprintf("Launch Configuration by this name already exists - A launch configuration already exists with the name %s", name), nil)
+	}
+
+	// Copy, so we don't share the caller's slices
+	request = awsutil.CopyOf(request).(*autoscaling.CreateLaunchConfigurationInput)
+	f.launchConfigurations[name] = &autoscaling.LaunchConfiguration{
+		LaunchConfigurationName:  aws.String(name),
+		LaunchConfigurationARN:   aws.String("arn:aws:autoscaling:::launchConfiguration:" + name),
+		ImageId:                  request.ImageId,
+		InstanceType:             request.InstanceType,
+		KeyName:                  request.KeyName,
+		SecurityGroups:           request.SecurityGroups,
+		UserData:                 request.UserData,
+		IamInstanceProfile:       request.IamInstanceProfile,
+		AssociatePublicIpAddress: request.AssociatePublicIpAddress,
+		BlockDeviceMappings:      request.BlockDeviceMappings,
+		CreatedTime:              aws.Time(time.Now()),
+	}
+	return &autoscaling.CreateLaunchConfigurationOutput{}, nil
+}
+
+func (f *FakeAutoscaling) DescribeLaunchConfigurations(request *autoscaling.DescribeLaunchConfigurationsInput) (*autoscaling.DescribeLaunchConfigurationsOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var names []string
+	for name := range f.launchConfigurations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	response := &autoscaling.DescribeLaunchConfigurationsOutput{}
+	for _, name := range names {
+		if !matchesIDs(request.LaunchConfigurationNames, name) {
+			continue
+		}
+		response.LaunchConfigurations = append(response.LaunchConfigurations, awsutil.CopyOf(f.launchConfigurations[name]).(*autoscaling.LaunchConfiguration))
+	}
+
+	start, end, next, err := paginate(len(response.LaunchConfigurations), request.NextToken, request.MaxRecords)
+	if err != nil {
+		return nil, err
+	}
+	response.LaunchConfigurations = response.LaunchConfigurations[start:end]
+	response.NextToken = next
+	return response, nil
+}
+
+func (f *FakeAutoscaling) DeleteLaunchConfiguration(request *autoscaling.DeleteLaunchConfigurationInput) (*autoscaling.DeleteLaunchConfigurationOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name := aws.StringValue(request.LaunchConfigurationName)
+	if f.launchConfigurations[name] == nil {
+		return nil, autoscalingValidationError("Launch configuration name not found - %s", name)
+	}
+	for _, g := range f.groups {
+		if aws.StringValue(g.LaunchConfigurationName) == name {
+			return nil, awserr.New("ResourceInUse", fmt.Sprintf("Cannot delete launch configuration %s because it is attached to AutoScalingGroup %s", name, aws.StringValue(g.AutoScalingGroupName)), nil)
+		}
+	}
+
+	delete(f.launchConfigurations, name)
+	return &autoscaling.DeleteLaunchConfigurationOutput{}, nil
+}
+
+func (f *FakeAutoscaling) CreateAutoScalingGroup(request *autoscaling.CreateAutoScalingGroupInput) (*autoscaling.CreateAutoScalingGroupOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name := aws.StringValue(request.AutoScalingGroupName)
+	if f.groups[name] != nil {
+		return nil, awserr.New("AlreadyExists", fmt.Sprintf("AutoScalingGroup by this name already exists - A group with the name %s already exists", name), nil)
+	}
+	launchConfigurationName := aws.StringValue(request.LaunchConfigurationName)
+	if f.launchConfigurations[launchConfigurationName] == nil {
+		return nil, autoscalingValidationError("Launch configuration name not found - %s", launchConfigurationName)
+	}
+
+	g := &autoscaling.Group{
+		AutoScalingGroupName:    aws.String(name),
+		AutoScalingGroupARN:     aws.String("arn:aws:autoscaling:::autoScalingGroup:" + name),
+		LaunchConfigurationName: aws.String(launchConfigurationName),
+		MinSize:                 request.MinSize,
+		MaxSize:                 request.MaxSize,
+		DesiredCapacity:         request.MinSize,
+		VPCZoneIdentifier:       request.VPCZoneIdentifier,
+		CreatedTime:             aws.Time(time.Now()),
+	}
+	for _, tag := range request.Tags {
+		g.Tags = append(g.Tags, &autoscaling.TagDescription{
+			Key:               tag.Key,
+			Value:             tag.Value,
+			PropagateAtLaunch: tag.PropagateAtLaunch,
+			ResourceId:        aws.String(name),
+			ResourceType:      aws.String("auto-scaling-group"),
+		})
+	}
+	sort.Sort(tagDescriptionsByKey(g.Tags))
+	f.groups[name] = g
+
+	return &autoscaling.CreateAutoScalingGroupOutput{}, nil
+}
+
+type tagDescriptionsByKey []*autoscaling.TagDescription
+
+func (a tagDescriptionsByKey) Len() int {
+	return len(a)
+}
+func (a tagDescriptionsByKey) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a tagDescriptionsByKey) Less(i, j int) bool {
+	return aws.StringValue(a[i].Key) < aws.StringValue(a[j].Key)
+}
+
+func (f *FakeAutoscaling) sortedGroupNames() []string {
+	var names []string
+	for name := range f.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *FakeAutoscaling) DescribeAutoScalingGroups(request *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	response := &autoscaling.DescribeAutoScalingGroupsOutput{}
+	for _, name := range f.sortedGroupNames() {
+		if !matchesIDs(request.AutoScalingGroupNames, name) {
+			continue
+		}
+		response.AutoScalingGroups = append(response.AutoScalingGroups, awsutil.CopyOf(f.groups[name]).(*autoscaling.Group))
+	}
+
+	start, end, next, err := paginate(len(response.AutoScalingGroups), request.NextToken, request.MaxRecords)
+	if err != nil {
+		return nil, err
+	}
+	response.AutoScalingGroups = response.AutoScalingGroups[start:end]
+	response.NextToken = next
+	return response, nil
+}
+
+func (f *FakeAutoscaling) UpdateAutoScalingGroup(request *autoscaling.UpdateAutoScalingGroupInput) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name := aws.StringValue(request.AutoScalingGroupName)
+	g := f.groups[name]
+	if g == nil {
+		return nil, autoscalingValidationError("AutoScalingGroup name not found - %s", name)
+	}
+	if request.LaunchConfigurationName != nil {
+		if f.launchConfigurations[*request.LaunchConfigurationName] == nil {
+			return nil, autoscalingValidationError("Launch configuration name not found - %s", *request.LaunchConfigurationName)
+		}
+		g.LaunchConfigurationName = request.LaunchConfigurationName
+	}
+	if request.MinSize != nil {
+		g.MinSize = request.MinSize
+	}
+	if request.MaxSize != nil {
+		g.MaxSize = request.MaxSize
+	}
+	if request.DesiredCapacity != nil {
+		g.DesiredCapacity = request.DesiredCapacity
+	}
+	if request.VPCZoneIdentifier != nil {
+		g.VPCZoneIdentifier = request.VPCZoneIdentifier
+	}
+	return &autoscaling.UpdateAutoScalingGroupOutput{}, nil
+}
+
+func (f *FakeAutoscaling) DeleteAutoScalingGroup(request *autoscaling.DeleteAutoScalingGroupInput) (*autoscaling.DeleteAutoScalingGroupOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name := aws.StringValue(request.AutoScalingGroupName)
+	if f.groups[name] == nil {
+		return nil, autoscalingValidationError("AutoScalingGroup name not found - %s", name)
+	}
+
+	delete(f.groups, name)
+	return &autoscaling.DeleteAutoScalingGroupOutput{}, nil
+}
+
+func (f *FakeAutoscaling) DescribeTags(request *autoscaling.DescribeTagsInput) (*autoscaling.DescribeTagsOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	response := &autoscaling.DescribeTagsOutput{}
+	for _, name := range f.sortedGroupNames() {
+		for _, tag := range f.groups[name].Tags {
+			match := true
+			for _, filter := range request.Filters {
+				var actual string
+				switch aws.StringValue(filter.Name) {
+				case "auto-scaling-group":
+					actual = name
+				case "key":
+					actual = aws.StringValue(tag.Key)
+				case "value":
+					actual = aws.StringValue(tag.Value)
+				case "propagate-at-launch":
+					actual = fmt.Sprintf("%v", aws.BoolValue(tag.PropagateAtLaunch))
+				default:
+					return nil, autoscalingValidationError("Filter type %s is not correct", aws.StringValue(filter.Name))
+				}
+				if !matchesValues(filter.Values, []string{actual}) {
+					match = false
+					break
+				}
+			}
+			if match {
+				response.Tags = append(response.Tags, awsutil.CopyOf(tag).(*autoscaling.TagDescription))
+			}
+		}
+	}
+
+	start, end, next, err := paginate(len(response.Tags), request.NextToken, request.MaxRecords)
+	if err != nil {
+		return nil, err
+	}
+	response.Tags = response.Tags[start:end]
+	response.NextToken = next
+	return response, nil
+}
+
+func (f *FakeAutoscaling) resources() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var resources []string
+	for name := range f.launchConfigurations {
+		resources = append(resources, "launch-configuration "+name)
+	}
+	for name := range f.groups {
+		resources = append(resources, "autoscaling-group "+name)
+	}
+	sort.Strings(resources)
+	return resources
+}
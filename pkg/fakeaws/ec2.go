@@ -0,0 +1,1708 @@
+package fakeaws
+
+import (
+	"crypto/md5"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"golang.org/x/crypto/ssh"
+)
+
+// The account that owns the fake resources
+const fakeAccountID = "123456789012"
+
+// FakeEC2 is an in-memory implementation of the EC2 calls that kope makes
+type FakeEC2 struct {
+	ec2iface.EC2API
+
+	mutex sync.Mutex
+	ids   idGenerator
+
+	// order holds the ids of every resource, in the order they were created, so that results are stable
+	order         []string
+	resourceTypes map[string]string
+	tags          map[string]map[string]string
+
+	vpcs             map[string]*ec2.Vpc
+	vpcAttributes    map[string]map[string]bool
+	subnets          map[string]*ec2.Subnet
+	internetGateways map[string]*ec2.InternetGateway
+	routeTables      map[string]*ec2.RouteTable
+	securityGroups   map[string]*ec2.SecurityGroup
+	dhcpOptions      map[string]*ec2.DhcpOptions
+	volumes          map[string]*ec2.Volume
+	addresses        map[string]*ec2.Address
+	instances        map[string]*ec2.Instance
+	// instanceUserData is the base64 user-data, which EC2 only returns from DescribeInstanceAttribute
+	instanceUserData map[string]*string
+	keyPairs         map[string]*ec2.KeyPairInfo
+	images           map[string]*ec2.Image
+	// clientTokens maps the ClientToken of each RunInstances call to the instance it launched
+	clientTokens map[string]string
+
+	nextIP int
+
+	// iam is used to resolve the instance profiles of instances
+	iam *FakeIAM
+}
+
+var _ ec2iface.EC2API = &FakeEC2{}
+
+func newFakeEC2() *FakeEC2 {
+	return &FakeEC2{
+		resourceTypes:    make(map[string]string),
+		tags:             make(map[string]map[string]string),
+		vpcs:             make(map[string]*ec2.Vpc),
+		vpcAttributes:    make(map[string]map[string]bool),
+		subnets:          make(map[string]*ec2.Subnet),
+		internetGateways: make(map[string]*ec2.InternetGateway),
+		routeTables:      make(map[string]*ec2.RouteTable),
+		securityGroups:   make(map[string]*ec2.SecurityGroup),
+		dhcpOptions:      make(map[string]*ec2.DhcpOptions),
+		volumes:          make(map[string]*ec2.Volume),
+		addresses:        make(map[string]*ec2.Address),
+		instances:        make(map[string]*ec2.Instance),
+		instanceUserData: make(map[string]*string),
+		keyPairs:         make(map[string]*ec2.KeyPairInfo),
+		images:           make(map[string]*ec2.Image),
+		clientTokens:     make(map[string]string),
+	}
+}
+
+// AddImage registers an image, so that it can be found with DescribeImages
+func (f *FakeEC2) AddImage(ownerID string, name string) string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := f.ids.newID("ami")
+	f.images[id] = &ec2.Image{
+		ImageId: aws.String(id),
+		Name:    aws.String(name),
+		OwnerId: aws.String(ownerID),
+		State:   aws.String(ec2.ImageStateAvailable),
+	}
+	f.order = append(f.order, id)
+	return id
+}
+
+// Count returns the number of (non-deleted) resources of the specified type (e.g. "vpc" or "instance");
+// terminated instances are not counted
+func (f *FakeEC2) Count(resourceType string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	n := 0
+	for id, t := range f.resourceTypes {
+		if t != resourceType {
+			continue
+		}
+		if i := f.instances[id]; i != nil && aws.StringValue(i.State.Name) == ec2.InstanceStateNameTerminated {
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+func (f *FakeEC2) addResource(resourceType string, id string) {
+	f.order = append(f.order, id)
+	f.resourceTypes[id] = resourceType
+}
+
+func (f *FakeEC2) removeResource(id string) {
+	delete(f.resourceTypes, id)
+	delete(f.tags, id)
+}
+
+func (f *FakeEC2) ec2Tags(id string) []*ec2.Tag {
+	var keys []string
+	for k := range f.tags[id] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var tags []*ec2.Tag
+	for _, k := range keys {
+		tags = append(tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(f.tags[id][k])})
+	}
+	return tags
+}
+
+// matchesFilters checks the filters against the tags of the resource and the supplied attribute values
+func (f *FakeEC2) matchesFilters(id string, filters []*ec2.Filter, attributes map[string][]string) (bool, error) {
+	for _, filter := range filters {
+		name := aws.StringValue(filter.Name)
+		var actual []string
+		if strings.HasPrefix(name, "tag:") {
+			v, found := f.tags[id][strings.TrimPrefix(name, "tag:")]
+			if found {
+				actual = []string{v}
+			}
+		} else {
+			values, found := attributes[name]
+			if !found {
+				return false, invalidParameter("The filter %q is invalid", name)
+			}
+			actual = values
+		}
+		if !matchesValues(filter.Values, actual) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (f *FakeEC2) nextPrivateIP(cidr string) string {
+	f.nextIP++
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Sprintf("10.0.0.%d", f.nextIP+10)
+	}
+	ip := ipNet.IP.To4()
+	n := (uint32(ip[0]) << 24) | (uint32(ip[1]) << 16) | (uint32(ip[2]) << 8) | uint32(ip[3])
+	n += uint32(f.nextIP + 10)
+	return net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n)).String()
+}
+
+func (f *FakeEC2) CreateTags(request *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, id := range request.Resources {
+		if f.resourceTypes[*id] == "" {
+			return nil, awserr.New("InvalidID", fmt.Sprintf("The ID %q is not valid", *id), nil)
+		}
+	}
+	for _, id := range request.Resources {
+		tags := f.tags[*id]
+		if tags == nil {
+			tags = make(map[string]string)
+			f.tags[*id] = tags
+		}
+		for _, tag := range request.Tags {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (f *FakeEC2) DescribeTags(request *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	response := &ec2.DescribeTagsOutput{}
+	for _, id := range f.order {
+		resourceType := f.resourceTypes[id]
+		if resourceType == "" {
+			continue
+		}
+		for _, tag := range f.ec2Tags(id) {
+			match, err := f.matchesFilters(id, request.Filters, map[string][]string{
+				"key":           {*tag.Key},
+				"value":         {*tag.Value},
+				"resource-id":   {id},
+				"resource-type": {resourceType},
+			})
+			if err != nil {
+				return nil, err
+			}
+			if !match {
+				continue
+			}
+			response.Tags = append(response.Tags, &ec2.TagDescription{
+				Key:          tag.Key,
+				Value:        tag.Value,
+				ResourceId:   aws.String(id),
+				ResourceType: aws.String(resourceType),
+			})
+		}
+	}
+
+	start, end, next, err := paginate(len(response.Tags), request.NextToken, request.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	response.Tags = response.Tags[start:end]
+	response.NextToken = next
+	return response, nil
+}
+
+func (f *FakeEC2) CreateVpc(request *ec2.CreateVpcInput) (*ec2.CreateVpcOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, _, err := net.ParseCIDR(aws.StringValue(request.CidrBlock)); err != nil {
+		return nil, invalidParameter("invalid CIDR %q", aws.StringValue(request.CidrBlock))
+	}
+
+	id := f.ids.newID("vpc")
+	vpc := &ec2.Vpc{
+		VpcId:           aws.String(id),
+		CidrBlock:       request.CidrBlock,
+		DhcpOptionsId:   aws.String("default"),
+		InstanceTenancy: aws.String(ec2.TenancyDefault),
+		IsDefault:       aws.Bool(false),
+		State:           aws.String(ec2.VpcStateAvailable),
+	}
+	f.vpcs[id] = vpc
+	f.vpcAttributes[id] = map[string]bool{
+		ec2.VpcAttributeNameEnableDnsSupport:   true,
+		ec2.VpcAttributeNameEnableDnsHostnames: false,
+	}
+	f.addResource("vpc", id)
+
+	return &ec2.CreateVpcOutput{Vpc: f.describeVpc(id)}, nil
+}
+
+func (f *FakeEC2) describeVpc(id string) *ec2.Vpc {
+	vpc := awsutil.CopyOf(f.vpcs[id]).(*ec2.Vpc)
+	vpc.Tags = f.ec2Tags(id)
+	return vpc
+}
+
+func (f *FakeEC2) DescribeVpcs(request *ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, id := range request.VpcIds {
+		if f.vpcs[*id] == nil {
+			return nil, notFound("InvalidVpcID.NotFound", *id)
+		}
+	}
+
+	response := &ec2.DescribeVpcsOutput{}
+	for _, id := range f.order {
+		vpc := f.vpcs[id]
+		if vpc == nil || !matchesIDs(request.VpcIds, id) {
+			continue
+		}
+		match, err := f.matchesFilters(id, request.Filters, map[string][]string{
+			"vpc-id":          {id},
+			"cidr":            {aws.StringValue(vpc.CidrBlock)},
+			"dhcp-options-id": {aws.StringValue(vpc.DhcpOptionsId)},
+			"state":           {aws.StringValue(vpc.State)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			response.Vpcs = append(response.Vpcs, f.describeVpc(id))
+		}
+	}
+	return response, nil
+}
+
+func (f *FakeEC2) DescribeVpcAttribute(request *ec2.DescribeVpcAttributeInput) (*ec2.DescribeVpcAttributeOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.VpcId)
+	attributes := f.vpcAttributes[id]
+	if attributes == nil {
+		return nil, notFound("InvalidVpcID.NotFound", id)
+	}
+
+	response := &ec2.DescribeVpcAttributeOutput{VpcId: aws.String(id)}
+	switch aws.StringValue(request.Attribute) {
+	case ec2.VpcAttributeNameEnableDnsSupport:
+		response.EnableDnsSupport = &ec2.AttributeBooleanValue{Value: aws.Bool(attributes[ec2.VpcAttributeNameEnableDnsSupport])}
+	case ec2.VpcAttributeNameEnableDnsHostnames:
+		response.EnableDnsHostnames = &ec2.AttributeBooleanValue{Value: aws.Bool(attributes[ec2.VpcAttributeNameEnableDnsHostnames])}
+	default:
+		return nil, invalidParameter("unknown attribute %q", aws.StringValue(request.Attribute))
+	}
+	return response, nil
+}
+
+func (f *FakeEC2) ModifyVpcAttribute(request *ec2.ModifyVpcAttributeInput) (*ec2.ModifyVpcAttributeOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.VpcId)
+	attributes := f.vpcAttributes[id]
+	if attributes == nil {
+		return nil, notFound("InvalidVpcID.NotFound", id)
+	}
+
+	// Like EC2, only one attribute can be changed at a time
+	if request.EnableDnsSupport != nil && request.EnableDnsHostnames != nil {
+		return nil, invalidParameter("only one attribute can be modified at a time")
+	}
+	if request.EnableDnsSupport != nil {
+		attributes[ec2.VpcAttributeNameEnableDnsSupport] = aws.BoolValue(request.EnableDnsSupport.Value)
+	}
+	if request.EnableDnsHostnames != nil {
+		attributes[ec2.VpcAttributeNameEnableDnsHostnames] = aws.BoolValue(request.EnableDnsHostnames.Value)
+	}
+	return &ec2.ModifyVpcAttributeOutput{}, nil
+}
+
+func (f *FakeEC2) DeleteVpc(request *ec2.DeleteVpcInput) (*ec2.DeleteVpcOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.VpcId)
+	if f.vpcs[id] == nil {
+		return nil, notFound("InvalidVpcID.NotFound", id)
+	}
+
+	for _, s := range f.subnets {
+		if aws.StringValue(s.VpcId) == id {
+			return nil, dependencyViolation("The vpc %q has dependencies and cannot be deleted", id)
+		}
+	}
+	for _, igw := range f.internetGateways {
+		for _, a := range igw.Attachments {
+			if aws.StringValue(a.VpcId) == id {
+				return nil, dependencyViolation("The vpc %q has dependencies and cannot be deleted", id)
+			}
+		}
+	}
+	for _, rt := range f.routeTables {
+		if aws.StringValue(rt.VpcId) == id {
+			return nil, dependencyViolation("The vpc %q has dependencies and cannot be deleted", id)
+		}
+	}
+	for _, sg := range f.securityGroups {
+		if aws.StringValue(sg.VpcId) == id {
+			return nil, dependencyViolation("The vpc %q has dependencies and cannot be deleted", id)
+		}
+	}
+
+	delete(f.vpcs, id)
+	delete(f.vpcAttributes, id)
+	f.removeResource(id)
+	return &ec2.DeleteVpcOutput{}, nil
+}
+
+func (f *FakeEC2) CreateDhcpOptions(request *ec2.CreateDhcpOptionsInput) (*ec2.CreateDhcpOptionsOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := f.ids.newID("dopt")
+	o := &ec2.DhcpOptions{DhcpOptionsId: aws.String(id)}
+	for _, c := range request.DhcpConfigurations {
+		config := &ec2.DhcpConfiguration{Key: c.Key}
+		for _, v := range c.Values {
+			config.Values = append(config.Values, &ec2.AttributeValue{Value: aws.String(aws.StringValue(v))})
+		}
+		o.DhcpConfigurations = append(o.DhcpConfigurations, config)
+	}
+	f.dhcpOptions[id] = o
+	f.addResource("dhcp-options", id)
+
+	return &ec2.CreateDhcpOptionsOutput{DhcpOptions: f.describeDhcpOptions(id)}, nil
+}
+
+func (f *FakeEC2) describeDhcpOptions(id string) *ec2.DhcpOptions {
+	o := awsutil.CopyOf(f.dhcpOptions[id]).(*ec2.DhcpOptions)
+	o.Tags = f.ec2Tags(id)
+	return o
+}
+
+func (f *FakeEC2) DescribeDhcpOptions(request *ec2.DescribeDhcpOptionsInput) (*ec2.DescribeDhcpOptionsOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, id := range request.DhcpOptionsIds {
+		if f.dhcpOptions[*id] == nil {
+			return nil, notFound("InvalidDhcpOptionID.NotFound", *id)
+		}
+	}
+
+	response := &ec2.DescribeDhcpOptionsOutput{}
+	for _, id := range f.order {
+		if f.dhcpOptions[id] == nil || !matchesIDs(request.DhcpOptionsIds, id) {
+			continue
+		}
+		match, err := f.matchesFilters(id, request.Filters, map[string][]string{
+			"dhcp-options-id": {id},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			response.DhcpOptions = append(response.DhcpOptions, f.describeDhcpOptions(id))
+		}
+	}
+	return response, nil
+}
+
+func (f *FakeEC2) AssociateDhcpOptions(request *ec2.AssociateDhcpOptionsInput) (*ec2.AssociateDhcpOptionsOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	dhcpOptionsID := aws.StringValue(request.DhcpOptionsId)
+	if dhcpOptionsID != "default" && f.dhcpOptions[dhcpOptionsID] == nil {
+		return nil, notFound("InvalidDhcpOptionID.NotFound", dhcpOptionsID)
+	}
+	vpc := f.vpcs[aws.StringValue(request.VpcId)]
+	if vpc == nil {
+		return nil, notFound("InvalidVpcID.NotFound", aws.StringValue(request.VpcId))
+	}
+	vpc.DhcpOptionsId = aws.String(dhcpOptionsID)
+	return &ec2.AssociateDhcpOptionsOutput{}, nil
+}
+
+func (f *FakeEC2) DeleteDhcpOptions(request *ec2.DeleteDhcpOptionsInput) (*ec2.DeleteDhcpOptionsOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.DhcpOptionsId)
+	if f.dhcpOptions[id] == nil {
+		return nil, notFound("InvalidDhcpOptionID.NotFound", id)
+	}
+	for _, vpc := range f.vpcs {
+		if aws.StringValue(vpc.DhcpOptionsId) == id {
+			return nil, dependencyViolation("The dhcpOptions %q has dependencies and cannot be deleted", id)
+		}
+	}
+
+	delete(f.dhcpOptions, id)
+	f.removeResource(id)
+	return &ec2.DeleteDhcpOptionsOutput{}, nil
+}
+
+func (f *FakeEC2) CreateSubnet(request *ec2.CreateSubnetInput) (*ec2.CreateSubnetOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	vpcID := aws.StringValue(request.VpcId)
+	if f.vpcs[vpcID] == nil {
+		return nil, notFound("InvalidVpcID.NotFound", vpcID)
+	}
+	if _, _, err := net.ParseCIDR(aws.StringValue(request.CidrBlock)); err != nil {
+		return nil, invalidParameter("invalid CIDR %q", aws.StringValue(request.CidrBlock))
+	}
+
+	id := f.ids.newID("subnet")
+	f.subnets[id] = &ec2.Subnet{
+		SubnetId:                aws.String(id),
+		VpcId:                   aws.String(vpcID),
+		CidrBlock:               request.CidrBlock,
+		AvailabilityZone:        request.AvailabilityZone,
+		AvailableIpAddressCount: aws.Int64(251),
+		State:                   aws.String(ec2.SubnetStateAvailable),
+	}
+	f.addResource("subnet", id)
+
+	return &ec2.CreateSubnetOutput{Subnet: f.describeSubnet(id)}, nil
+}
+
+func (f *FakeEC2) describeSubnet(id string) *ec2.Subnet {
+	s := awsutil.CopyOf(f.subnets[id]).(*ec2.Subnet)
+	s.Tags = f.ec2Tags(id)
+	return s
+}
+
+func (f *FakeEC2) DescribeSubnets(request *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, id := range request.SubnetIds {
+		if f.subnets[*id] == nil {
+			return nil, notFound("InvalidSubnetID.NotFound", *id)
+		}
+	}
+
+	response := &ec2.DescribeSubnetsOutput{}
+	for _, id := range f.order {
+		s := f.subnets[id]
+		if s == nil || !matchesIDs(request.SubnetIds, id) {
+			continue
+		}
+		match, err := f.matchesFilters(id, request.Filters, map[string][]string{
+			"subnet-id":         {id},
+			"vpc-id":            {aws.StringValue(s.VpcId)},
+			"cidr-block":        {aws.StringValue(s.CidrBlock)},
+			"availability-zone": {aws.StringValue(s.AvailabilityZone)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			response.Subnets = append(response.Subnets, f.describeSubnet(id))
+		}
+	}
+	return response, nil
+}
+
+func (f *FakeEC2) DeleteSubnet(request *ec2.DeleteSubnetInput) (*ec2.DeleteSubnetOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.SubnetId)
+	if f.subnets[id] == nil {
+		return nil, notFound("InvalidSubnetID.NotFound", id)
+	}
+	for _, i := range f.instances {
+		if aws.StringValue(i.SubnetId) == id && aws.StringValue(i.State.Name) != ec2.InstanceStateNameTerminated {
+			return nil, dependencyViolation("The subnet %q has dependencies and cannot be deleted", id)
+		}
+	}
+
+	// Like EC2, deleting a subnet removes its route table associations
+	for _, rt := range f.routeTables {
+		var associations []*ec2.RouteTableAssociation
+		for _, a := range rt.Associations {
+			if aws.StringValue(a.SubnetId) != id {
+				associations = append(associations, a)
+			}
+		}
+		rt.Associations = associations
+	}
+
+	delete(f.subnets, id)
+	f.removeResource(id)
+	return &ec2.DeleteSubnetOutput{}, nil
+}
+
+func (f *FakeEC2) CreateInternetGateway(request *ec2.CreateInternetGatewayInput) (*ec2.CreateInternetGatewayOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := f.ids.newID("igw")
+	f.internetGateways[id] = &ec2.InternetGateway{
+		InternetGatewayId: aws.String(id),
+	}
+	f.addResource("internet-gateway", id)
+
+	return &ec2.CreateInternetGatewayOutput{InternetGateway: f.describeInternetGateway(id)}, nil
+}
+
+func (f *FakeEC2) describeInternetGateway(id string) *ec2.InternetGateway {
+	igw := awsutil.CopyOf(f.internetGateways[id]).(*ec2.InternetGateway)
+	igw.Tags = f.ec2Tags(id)
+	return igw
+}
+
+func (f *FakeEC2) DescribeInternetGateways(request *ec2.DescribeInternetGatewaysInput) (*ec2.DescribeInternetGatewaysOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, id := range request.InternetGatewayIds {
+		if f.internetGateways[*id] == nil {
+			return nil, notFound("InvalidInternetGatewayID.NotFound", *id)
+		}
+	}
+
+	response := &ec2.DescribeInternetGatewaysOutput{}
+	for _, id := range f.order {
+		igw := f.internetGateways[id]
+		if igw == nil || !matchesIDs(request.InternetGatewayIds, id) {
+			continue
+		}
+		var vpcIDs []string
+		for _, a := range igw.Attachments {
+			vpcIDs = append(vpcIDs, aws.StringValue(a.VpcId))
+		}
+		match, err := f.matchesFilters(id, request.Filters, map[string][]string{
+			"internet-gateway-id": {id},
+			"attachment.vpc-id":   vpcIDs,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			response.InternetGateways = append(response.InternetGateways, f.describeInternetGateway(id))
+		}
+	}
+	return response, nil
+}
+
+func (f *FakeEC2) AttachInternetGateway(request *ec2.AttachInternetGatewayInput) (*ec2.AttachInternetGatewayOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.InternetGatewayId)
+	igw := f.internetGateways[id]
+	if igw == nil {
+		return nil, notFound("InvalidInternetGatewayID.NotFound", id)
+	}
+	vpcID := aws.StringValue(request.VpcId)
+	if f.vpcs[vpcID] == nil {
+		return nil, notFound("InvalidVpcID.NotFound", vpcID)
+	}
+	if len(igw.Attachments) != 0 {
+		return nil, awserr.New("Resource.AlreadyAssociated", fmt.Sprintf("resource %s is already attached", id), nil)
+	}
+
+	igw.Attachments = append(igw.Attachments, &ec2.InternetGatewayAttachment{
+		VpcId: aws.String(vpcID),
+		State: aws.String("available"),
+	})
+	return &ec2.AttachInternetGatewayOutput{}, nil
+}
+
+func (f *FakeEC2) DetachInternetGateway(request *ec2.DetachInternetGatewayInput) (*ec2.DetachInternetGatewayOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.InternetGatewayId)
+	igw := f.internetGateways[id]
+	if igw == nil {
+		return nil, notFound("InvalidInternetGatewayID.NotFound", id)
+	}
+
+	var attachments []*ec2.InternetGatewayAttachment
+	for _, a := range igw.Attachments {
+		if aws.StringValue(a.VpcId) != aws.StringValue(request.VpcId) {
+			attachments = append(attachments, a)
+		}
+	}
+	if len(attachments) == len(igw.Attachments) {
+		return nil, awserr.New("Gateway.NotAttached", fmt.Sprintf("resource %s is not attached to network %s", id, aws.StringValue(request.VpcId)), nil)
+	}
+	igw.Attachments = attachments
+	return &ec2.DetachInternetGatewayOutput{}, nil
+}
+
+func (f *FakeEC2) DeleteInternetGateway(request *ec2.DeleteInternetGatewayInput) (*ec2.DeleteInternetGatewayOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.InternetGatewayId)
+	igw := f.internetGateways[id]
+	if igw == nil {
+		return nil, notFound("InvalidInternetGatewayID.NotFound", id)
+	}
+	if len(igw.Attachments) != 0 {
+		return nil, dependencyViolation("The internetGateway %q has dependencies and cannot be deleted", id)
+	}
+
+	delete(f.internetGateways, id)
+	f.removeResource(id)
+	return &ec2.DeleteInternetGatewayOutput{}, nil
+}
+
+func (f *FakeEC2) CreateRouteTable(request *ec2.CreateRouteTableInput) (*ec2.CreateRouteTableOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	vpcID := aws.StringValue(request.VpcId)
+	vpc := f.vpcs[vpcID]
+	if vpc == nil {
+		return nil, notFound("InvalidVpcID.NotFound", vpcID)
+	}
+
+	id := f.ids.newID("rtb")
+	f.routeTables[id] = &ec2.RouteTable{
+		RouteTableId: aws.String(id),
+		VpcId:        aws.String(vpcID),
+		Routes: []*ec2.Route{
+			{
+				DestinationCidrBlock: vpc.CidrBlock,
+				GatewayId:            aws.String("local"),
+				State:                aws.String(ec2.RouteStateActive),
+				Origin:               aws.String(ec2.RouteOriginCreateRouteTable),
+			},
+		},
+	}
+	f.addResource("route-table", id)
+
+	return &ec2.CreateRouteTableOutput{RouteTable: f.describeRouteTable(id)}, nil
+}
+
+func (f *FakeEC2) describeRouteTable(id string) *ec2.RouteTable {
+	rt := awsutil.CopyOf(f.routeTables[id]).(*ec2.RouteTable)
+	rt.Tags = f.ec2Tags(id)
+	return rt
+}
+
+func (f *FakeEC2) DescribeRouteTables(request *ec2.DescribeRouteTablesInput) (*ec2.DescribeRouteTablesOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, id := range request.RouteTableIds {
+		if f.routeTables[*id] == nil {
+			return nil, notFound("InvalidRouteTableID.NotFound", *id)
+		}
+	}
+
+	response := &ec2.DescribeRouteTablesOutput{}
+	for _, id := range f.order {
+		rt := f.routeTables[id]
+		if rt == nil || !matchesIDs(request.RouteTableIds, id) {
+			continue
+		}
+		var subnetIDs, associationIDs []string
+		for _, a := range rt.Associations {
+			subnetIDs = append(subnetIDs, aws.StringValue(a.SubnetId))
+			associationIDs = append(associationIDs, aws.StringValue(a.RouteTableAssociationId))
+		}
+		match, err := f.matchesFilters(id, request.Filters, map[string][]string{
+			"route-table-id":                         {id},
+			"vpc-id":                                 {aws.StringValue(rt.VpcId)},
+			"association.subnet-id":                  subnetIDs,
+			"association.route-table-association-id": associationIDs,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			response.RouteTables = append(response.RouteTables, f.describeRouteTable(id))
+		}
+	}
+	return response, nil
+}
+
+func (f *FakeEC2) CreateRoute(request *ec2.CreateRouteInput) (*ec2.CreateRouteOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.RouteTableId)
+	rt := f.routeTables[id]
+	if rt == nil {
+		return nil, notFound("InvalidRouteTableID.NotFound", id)
+	}
+	cidr := aws.StringValue(request.DestinationCidrBlock)
+	for _, r := range rt.Routes {
+		if aws.StringValue(r.DestinationCidrBlock) == cidr {
+			return nil, awserr.New("RouteAlreadyExists", fmt.Sprintf("The route identified by %s already exists", cidr), nil)
+		}
+	}
+	if request.GatewayId != nil && f.internetGateways[*request.GatewayId] == nil {
+		return nil, notFound("InvalidGatewayID.NotFound", *request.GatewayId)
+	}
+	if request.InstanceId != nil && f.instances[*request.InstanceId] == nil {
+		return nil, notFound("InvalidInstanceID.NotFound", *request.InstanceId)
+	}
+
+	rt.Routes = append(rt.Routes, &ec2.Route{
+		DestinationCidrBlock: aws.String(cidr),
+		GatewayId:            request.GatewayId,
+		InstanceId:           request.InstanceId,
+		State:                aws.String(ec2.RouteStateActive),
+		Origin:               aws.String(ec2.RouteOriginCreateRoute),
+	})
+	return &ec2.CreateRouteOutput{Return: aws.Bool(true)}, nil
+}
+
+func (f *FakeEC2) AssociateRouteTable(request *ec2.AssociateRouteTableInput) (*ec2.AssociateRouteTableOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.RouteTableId)
+	rt := f.routeTables[id]
+	if rt == nil {
+		return nil, notFound("InvalidRouteTableID.NotFound", id)
+	}
+	subnetID := aws.StringValue(request.SubnetId)
+	if f.subnets[subnetID] == nil {
+		return nil, notFound("InvalidSubnetID.NotFound", subnetID)
+	}
+	for _, other := range f.routeTables {
+		for _, a := range other.Associations {
+			if aws.StringValue(a.SubnetId) == subnetID {
+				return nil, awserr.New("Resource.AlreadyAssociated", fmt.Sprintf("the specified association for route table %s conflicts with an existing association", id), nil)
+			}
+		}
+	}
+
+	associationID := f.ids.newID("rtbassoc")
+	rt.Associations = append(rt.Associations, &ec2.RouteTableAssociation{
+		RouteTableAssociationId: aws.String(associationID),
+		RouteTableId:            aws.String(id),
+		SubnetId:                aws.String(subnetID),
+		Main:                    aws.Bool(false),
+	})
+	return &ec2.AssociateRouteTableOutput{AssociationId: aws.String(associationID)}, nil
+}
+
+func (f *FakeEC2) DisassociateRouteTable(request *ec2.DisassociateRouteTableInput) (*ec2.DisassociateRouteTableOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	associationID := aws.StringValue(request.AssociationId)
+	for _, rt := range f.routeTables {
+		for i, a := range rt.Associations {
+			if aws.StringValue(a.RouteTableAssociationId) == associationID {
+				rt.Associations = append(rt.Associations[:i], rt.Associations[i+1:]...)
+				return &ec2.DisassociateRouteTableOutput{}, nil
+			}
+		}
+	}
+	return nil, notFound("InvalidAssociationID.NotFound", associationID)
+}
+
+func (f *FakeEC2) DeleteRouteTable(request *ec2.DeleteRouteTableInput) (*ec2.DeleteRouteTableOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.RouteTableId)
+	rt := f.routeTables[id]
+	if rt == nil {
+		return nil, notFound("InvalidRouteTableID.NotFound", id)
+	}
+	if len(rt.Associations) != 0 {
+		return nil, dependencyViolation("The routeTable %q has dependencies and cannot be deleted", id)
+	}
+
+	delete(f.routeTables, id)
+	f.removeResource(id)
+	return &ec2.DeleteRouteTableOutput{}, nil
+}
+
+func (f *FakeEC2) CreateSecurityGroup(request *ec2.CreateSecurityGroupInput) (*ec2.CreateSecurityGroupOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	vpcID := aws.StringValue(request.VpcId)
+	if f.vpcs[vpcID] == nil {
+		return nil, notFound("InvalidVpcID.NotFound", vpcID)
+	}
+	name := aws.StringValue(request.GroupName)
+	for _, sg := range f.securityGroups {
+		if aws.StringValue(sg.VpcId) == vpcID && aws.StringValue(sg.GroupName) == name {
+			return nil, awserr.New("InvalidGroup.Duplicate", fmt.Sprintf("The security group %q already exists for VPC %q", name, vpcID), nil)
+		}
+	}
+
+	id := f.ids.newID("sg")
+	f.securityGroups[id] = &ec2.SecurityGroup{
+		GroupId:     aws.String(id),
+		GroupName:   aws.String(name),
+		Description: request.Description,
+		VpcId:       aws.String(vpcID),
+		OwnerId:     aws.String(fakeAccountID),
+	}
+	f.addResource("security-group", id)
+
+	return &ec2.CreateSecurityGroupOutput{GroupId: aws.String(id)}, nil
+}
+
+func (f *FakeEC2) DescribeSecurityGroups(request *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, id := range request.GroupIds {
+		if f.securityGroups[*id] == nil {
+			return nil, notFound("InvalidGroup.NotFound", *id)
+		}
+	}
+
+	response := &ec2.DescribeSecurityGroupsOutput{}
+	for _, id := range f.order {
+		sg := f.securityGroups[id]
+		if sg == nil || !matchesIDs(request.GroupIds, id) {
+			continue
+		}
+		match, err := f.matchesFilters(id, request.Filters, map[string][]string{
+			"group-id":   {id},
+			"group-name": {aws.StringValue(sg.GroupName)},
+			"vpc-id":     {aws.StringValue(sg.VpcId)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			actual := awsutil.CopyOf(sg).(*ec2.SecurityGroup)
+			actual.Tags = f.ec2Tags(id)
+			response.SecurityGroups = append(response.SecurityGroups, actual)
+		}
+	}
+	return response, nil
+}
+
+// buildIpPermissions normalizes the permissions in an authorize / revoke request, which can be specified
+// either as IpPermissions or (for a single CIDR) as top-level fields
+func buildIpPermissions(permissions []*ec2.IpPermission, protocol *string, fromPort *int64, toPort *int64, cidr *string) []*ec2.IpPermission {
+	var normalized []*ec2.IpPermission
+	if len(permissions) == 0 {
+		permissions = []*ec2.IpPermission{{}}
+	}
+	for _, p := range permissions {
+		n := awsutil.CopyOf(p).(*ec2.IpPermission)
+		if n.IpProtocol == nil {
+			n.IpProtocol = protocol
+		}
+		if n.IpProtocol == nil {
+			n.IpProtocol = aws.String("-1")
+		}
+		if n.FromPort == nil {
+			n.FromPort = fromPort
+		}
+		if n.ToPort == nil {
+			n.ToPort = toPort
+		}
+		if cidr != nil && len(n.IpRanges) == 0 {
+			n.IpRanges = []*ec2.IpRange{{CidrIp: cidr}}
+		}
+		normalized = append(normalized, n)
+	}
+	return normalized
+}
+
+func (f *FakeEC2) AuthorizeSecurityGroupIngress(request *ec2.AuthorizeSecurityGroupIngressInput) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.GroupId)
+	sg := f.securityGroups[id]
+	if sg == nil {
+		return nil, notFound("InvalidGroup.NotFound", id)
+	}
+
+	permissions := buildIpPermissions(request.IpPermissions, request.IpProtocol, request.FromPort, request.ToPort, request.CidrIp)
+	for _, p := range permissions {
+		for _, pair := range p.UserIdGroupPairs {
+			if f.securityGroups[aws.StringValue(pair.GroupId)] == nil {
+				return nil, notFound("InvalidGroup.NotFound", aws.StringValue(pair.GroupId))
+			}
+		}
+		for _, existing := range sg.IpPermissions {
+			if reflect.DeepEqual(existing, p) {
+				return nil, awserr.New("InvalidPermission.Duplicate", "the specified rule already exists", nil)
+			}
+		}
+	}
+	sg.IpPermissions = append(sg.IpPermissions, permissions...)
+	return &ec2.AuthorizeSecurityGroupIngressOutput{}, nil
+}
+
+func (f *FakeEC2) RevokeSecurityGroupIngress(request *ec2.RevokeSecurityGroupIngressInput) (*ec2.RevokeSecurityGroupIngressOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.GroupId)
+	sg := f.securityGroups[id]
+	if sg == nil {
+		return nil, notFound("InvalidGroup.NotFound", id)
+	}
+
+	permissions := buildIpPermissions(request.IpPermissions, request.IpProtocol, request.FromPort, request.ToPort, request.CidrIp)
+	for _, p := range permissions {
+		found := false
+		for i, existing := range sg.IpPermissions {
+			if reflect.DeepEqual(existing, p) {
+				sg.IpPermissions = append(sg.IpPermissions[:i], sg.IpPermissions[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, awserr.New("InvalidPermission.NotFound", "the specified rule does not exist in this security group", nil)
+		}
+	}
+	return &ec2.RevokeSecurityGroupIngressOutput{}, nil
+}
+
+func (f *FakeEC2) DeleteSecurityGroup(request *ec2.DeleteSecurityGroupInput) (*ec2.DeleteSecurityGroupOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.GroupId)
+	if f.securityGroups[id] == nil {
+		return nil, notFound("InvalidGroup.NotFound", id)
+	}
+	for _, i := range f.instances {
+		if aws.StringValue(i.State.Name) == ec2.InstanceStateNameTerminated {
+			continue
+		}
+		for _, g := range i.SecurityGroups {
+			if aws.StringValue(g.GroupId) == id {
+				return nil, dependencyViolation("resource %s has a dependent object", id)
+			}
+		}
+	}
+	for otherID, other := range f.securityGroups {
+		if otherID == id {
+			continue
+		}
+		for _, p := range other.IpPermissions {
+			for _, pair := range p.UserIdGroupPairs {
+				if aws.StringValue(pair.GroupId) == id {
+					return nil, dependencyViolation("resource %s has a dependent object", id)
+				}
+			}
+		}
+	}
+
+	delete(f.securityGroups, id)
+	f.removeResource(id)
+	return &ec2.DeleteSecurityGroupOutput{}, nil
+}
+
+func (f *FakeEC2) CreateVolume(request *ec2.CreateVolumeInput) (*ec2.Volume, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	volumeType := request.VolumeType
+	if volumeType == nil {
+		volumeType = aws.String(ec2.VolumeTypeStandard)
+	}
+
+	id := f.ids.newID("vol")
+	f.volumes[id] = &ec2.Volume{
+		VolumeId:         aws.String(id),
+		AvailabilityZone: request.AvailabilityZone,
+		Size:             request.Size,
+		VolumeType:       volumeType,
+		Encrypted:        aws.Bool(aws.BoolValue(request.Encrypted)),
+		State:            aws.String(ec2.VolumeStateAvailable),
+		CreateTime:       aws.Time(time.Now()),
+	}
+	f.addResource("volume", id)
+
+	return f.describeVolume(id), nil
+}
+
+func (f *FakeEC2) describeVolume(id string) *ec2.Volume {
+	v := awsutil.CopyOf(f.volumes[id]).(*ec2.Volume)
+	v.Tags = f.ec2Tags(id)
+	return v
+}
+
+func (f *FakeEC2) DescribeVolumes(request *ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, id := range request.VolumeIds {
+		if f.volumes[*id] == nil {
+			return nil, notFound("InvalidVolume.NotFound", *id)
+		}
+	}
+
+	response := &ec2.DescribeVolumesOutput{}
+	for _, id := range f.order {
+		v := f.volumes[id]
+		if v == nil || !matchesIDs(request.VolumeIds, id) {
+			continue
+		}
+		var instanceIDs []string
+		for _, a := range v.Attachments {
+			instanceIDs = append(instanceIDs, aws.StringValue(a.InstanceId))
+		}
+		match, err := f.matchesFilters(id, request.Filters, map[string][]string{
+			"volume-id":              {id},
+			"availability-zone":      {aws.StringValue(v.AvailabilityZone)},
+			"status":                 {aws.StringValue(v.State)},
+			"attachment.instance-id": instanceIDs,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			response.Volumes = append(response.Volumes, f.describeVolume(id))
+		}
+	}
+	return response, nil
+}
+
+func (f *FakeEC2) AttachVolume(request *ec2.AttachVolumeInput) (*ec2.VolumeAttachment, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	volumeID := aws.StringValue(request.VolumeId)
+	v := f.volumes[volumeID]
+	if v == nil {
+		return nil, notFound("InvalidVolume.NotFound", volumeID)
+	}
+	instanceID := aws.StringValue(request.InstanceId)
+	i := f.instances[instanceID]
+	if i == nil {
+		return nil, notFound("InvalidInstanceID.NotFound", instanceID)
+	}
+	if aws.StringValue(v.State) != ec2.VolumeStateAvailable {
+		return nil, awserr.New("IncorrectState", fmt.Sprintf("%s is not 'available'", volumeID), nil)
+	}
+	if aws.StringValue(i.State.Name) != ec2.InstanceStateNameRunning {
+		return nil, awserr.New("IncorrectState", fmt.Sprintf("%s is not 'running'", instanceID), nil)
+	}
+	if aws.StringValue(v.AvailabilityZone) != aws.StringValue(i.Placement.AvailabilityZone) {
+		return nil, invalidParameter("volume %s is not in the same availability zone as instance %s", volumeID, instanceID)
+	}
+
+	f.attachVolume(i, v, aws.StringValue(request.Device), false)
+
+	return &ec2.VolumeAttachment{
+		VolumeId:   aws.String(volumeID),
+		InstanceId: aws.String(instanceID),
+		Device:     request.Device,
+		State:      aws.String(ec2.VolumeAttachmentStateAttached),
+	}, nil
+}
+
+func (f *FakeEC2) attachVolume(i *ec2.Instance, v *ec2.Volume, device string, deleteOnTermination bool) {
+	v.State = aws.String(ec2.VolumeStateInUse)
+	v.Attachments = []*ec2.VolumeAttachment{
+		{
+			VolumeId:            v.VolumeId,
+			InstanceId:          i.InstanceId,
+			Device:              aws.String(device),
+			State:               aws.String(ec2.VolumeAttachmentStateAttached),
+			DeleteOnTermination: aws.Bool(deleteOnTermination),
+		},
+	}
+	i.BlockDeviceMappings = append(i.BlockDeviceMappings, &ec2.InstanceBlockDeviceMapping{
+		DeviceName: aws.String(device),
+		Ebs: &ec2.EbsInstanceBlockDevice{
+			VolumeId:            v.VolumeId,
+			Status:              aws.String(ec2.AttachmentStatusAttached),
+			DeleteOnTermination: aws.Bool(deleteOnTermination),
+		},
+	})
+}
+
+func (f *FakeEC2) detachVolume(v *ec2.Volume) {
+	for _, a := range v.Attachments {
+		i := f.instances[aws.StringValue(a.InstanceId)]
+		if i == nil {
+			continue
+		}
+		var mappings []*ec2.InstanceBlockDeviceMapping
+		for _, bdm := range i.BlockDeviceMappings {
+			if bdm.Ebs != nil && aws.StringValue(bdm.Ebs.VolumeId) == aws.StringValue(v.VolumeId) {
+				continue
+			}
+			mappings = append(mappings, bdm)
+		}
+		i.BlockDeviceMappings = mappings
+	}
+	v.Attachments = nil
+	v.State = aws.String(ec2.VolumeStateAvailable)
+}
+
+func (f *FakeEC2) DetachVolume(request *ec2.DetachVolumeInput) (*ec2.VolumeAttachment, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	volumeID := aws.StringValue(request.VolumeId)
+	v := f.volumes[volumeID]
+	if v == nil {
+		return nil, notFound("InvalidVolume.NotFound", volumeID)
+	}
+	if len(v.Attachments) == 0 {
+		return nil, awserr.New("IncorrectState", fmt.Sprintf("Volume %q is in the 'available' state", volumeID), nil)
+	}
+	a := v.Attachments[0]
+	if request.InstanceId != nil && aws.StringValue(a.InstanceId) != *request.InstanceId {
+		return nil, awserr.New("InvalidAttachment.NotFound", fmt.Sprintf("volume %q is not attached to %q", volumeID, *request.InstanceId), nil)
+	}
+
+	f.detachVolume(v)
+
+	return &ec2.VolumeAttachment{
+		VolumeId:   aws.String(volumeID),
+		InstanceId: a.InstanceId,
+		Device:     a.Device,
+		State:      aws.String(ec2.VolumeAttachmentStateDetached),
+	}, nil
+}
+
+func (f *FakeEC2) DeleteVolume(request *ec2.DeleteVolumeInput) (*ec2.DeleteVolumeOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.VolumeId)
+	v := f.volumes[id]
+	if v == nil {
+		return nil, notFound("InvalidVolume.NotFound", id)
+	}
+	if len(v.Attachments) != 0 {
+		return nil, awserr.New("VolumeInUse", fmt.Sprintf("Volume %s is currently attached", id), nil)
+	}
+
+	delete(f.volumes, id)
+	f.removeResource(id)
+	return &ec2.DeleteVolumeOutput{}, nil
+}
+
+func (f *FakeEC2) AllocateAddress(request *ec2.AllocateAddressInput) (*ec2.AllocateAddressOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := f.ids.newID("eipalloc")
+	f.nextIP++
+	publicIP := fmt.Sprintf("198.51.%d.%d", 100+f.nextIP/250, f.nextIP%250+1)
+
+	f.addresses[id] = &ec2.Address{
+		AllocationId: aws.String(id),
+		PublicIp:     aws.String(publicIP),
+		Domain:       aws.String(ec2.DomainTypeVpc),
+	}
+	f.addResource("elastic-ip", id)
+
+	return &ec2.AllocateAddressOutput{
+		AllocationId: aws.String(id),
+		PublicIp:     aws.String(publicIP),
+		Domain:       aws.String(ec2.DomainTypeVpc),
+	}, nil
+}
+
+func (f *FakeEC2) DescribeAddresses(request *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, id := range request.AllocationIds {
+		if f.addresses[*id] == nil {
+			return nil, notFound("InvalidAllocationID.NotFound", *id)
+		}
+	}
+
+	response := &ec2.DescribeAddressesOutput{}
+	for _, id := range f.order {
+		a := f.addresses[id]
+		if a == nil || !matchesIDs(request.AllocationIds, id) {
+			continue
+		}
+		if len(request.PublicIps) != 0 && !matchesIDs(request.PublicIps, aws.StringValue(a.PublicIp)) {
+			continue
+		}
+		match, err := f.matchesFilters(id, request.Filters, map[string][]string{
+			"allocation-id":  {id},
+			"public-ip":      {aws.StringValue(a.PublicIp)},
+			"domain":         {aws.StringValue(a.Domain)},
+			"instance-id":    {aws.StringValue(a.InstanceId)},
+			"association-id": {aws.StringValue(a.AssociationId)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			response.Addresses = append(response.Addresses, awsutil.CopyOf(a).(*ec2.Address))
+		}
+	}
+	return response, nil
+}
+
+func (f *FakeEC2) AssociateAddress(request *ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.AllocationId)
+	a := f.addresses[id]
+	if a == nil {
+		return nil, notFound("InvalidAllocationID.NotFound", id)
+	}
+	instanceID := aws.StringValue(request.InstanceId)
+	i := f.instances[instanceID]
+	if i == nil {
+		return nil, notFound("InvalidInstanceID.NotFound", instanceID)
+	}
+	if aws.StringValue(i.State.Name) != ec2.InstanceStateNameRunning {
+		return nil, awserr.New("IncorrectInstanceState", fmt.Sprintf("The instance %q is not in a valid state for this operation", instanceID), nil)
+	}
+	if a.AssociationId != nil && !aws.BoolValue(request.AllowReassociation) {
+		return nil, awserr.New("Resource.AlreadyAssociated", fmt.Sprintf("resource %s is already associated", id), nil)
+	}
+
+	f.disassociateAddress(a)
+	associationID := f.ids.newID("eipassoc")
+	a.AssociationId = aws.String(associationID)
+	a.InstanceId = aws.String(instanceID)
+	a.PrivateIpAddress = i.PrivateIpAddress
+	i.PublicIpAddress = a.PublicIp
+
+	return &ec2.AssociateAddressOutput{AssociationId: aws.String(associationID)}, nil
+}
+
+func (f *FakeEC2) disassociateAddress(a *ec2.Address) {
+	if a.InstanceId != nil {
+		if i := f.instances[*a.InstanceId]; i != nil {
+			i.PublicIpAddress = nil
+		}
+	}
+	a.AssociationId = nil
+	a.InstanceId = nil
+	a.PrivateIpAddress = nil
+}
+
+func (f *FakeEC2) DisassociateAddress(request *ec2.DisassociateAddressInput) (*ec2.DisassociateAddressOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	associationID := aws.StringValue(request.AssociationId)
+	for _, a := range f.addresses {
+		if aws.StringValue(a.AssociationId) == associationID {
+			f.disassociateAddress(a)
+			return &ec2.DisassociateAddressOutput{}, nil
+		}
+	}
+	return nil, notFound("InvalidAssociationID.NotFound", associationID)
+}
+
+func (f *FakeEC2) ReleaseAddress(request *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.AllocationId)
+	a := f.addresses[id]
+	if a == nil {
+		return nil, notFound("InvalidAllocationID.NotFound", id)
+	}
+	if a.AssociationId != nil {
+		return nil, awserr.New("InvalidIPAddress.InUse", fmt.Sprintf("Address %s is in use", aws.StringValue(a.PublicIp)), nil)
+	}
+
+	delete(f.addresses, id)
+	f.removeResource(id)
+	return &ec2.ReleaseAddressOutput{}, nil
+}
+
+func (f *FakeEC2) ImportKeyPair(request *ec2.ImportKeyPairInput) (*ec2.ImportKeyPairOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name := aws.StringValue(request.KeyName)
+	if f.keyPairs[name] != nil {
+		return nil, awserr.New("InvalidKeyPair.Duplicate", fmt.Sprintf("The keypair %q already exists", name), nil)
+	}
+
+	// Like EC2, the fingerprint of an imported key is the MD5 of the key in SSH wire format
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(request.PublicKeyMaterial)
+	if err != nil {
+		return nil, awserr.New("InvalidKey.Format", "Key is not in valid OpenSSH public key format", nil)
+	}
+	hash := md5.Sum(publicKey.Marshal())
+	var fingerprint []string
+	for _, b := range hash {
+		fingerprint = append(fingerprint, fmt.Sprintf("%02x", b))
+	}
+
+	f.keyPairs[name] = &ec2.KeyPairInfo{
+		KeyName:        aws.String(name),
+		KeyFingerprint: aws.String(strings.Join(fingerprint, ":")),
+	}
+	f.order = append(f.order, name)
+
+	return &ec2.ImportKeyPairOutput{
+		KeyName:        aws.String(name),
+		KeyFingerprint: f.keyPairs[name].KeyFingerprint,
+	}, nil
+}
+
+func (f *FakeEC2) DescribeKeyPairs(request *ec2.DescribeKeyPairsInput) (*ec2.DescribeKeyPairsOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, name := range request.KeyNames {
+		if f.keyPairs[*name] == nil {
+			return nil, awserr.New("InvalidKeyPair.NotFound", fmt.Sprintf("The key pair %q does not exist", *name), nil)
+		}
+	}
+
+	response := &ec2.DescribeKeyPairsOutput{}
+	for _, name := range f.order {
+		k := f.keyPairs[name]
+		if k == nil || !matchesIDs(request.KeyNames, name) {
+			continue
+		}
+		match, err := f.matchesFilters(name, request.Filters, map[string][]string{
+			"key-name":    {name},
+			"fingerprint": {aws.StringValue(k.KeyFingerprint)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			response.KeyPairs = append(response.KeyPairs, awsutil.CopyOf(k).(*ec2.KeyPairInfo))
+		}
+	}
+	return response, nil
+}
+
+func (f *FakeEC2) DeleteKeyPair(request *ec2.DeleteKeyPairInput) (*ec2.DeleteKeyPairOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	// Like EC2, deleting a key pair that does not exist is not an error
+	delete(f.keyPairs, aws.StringValue(request.KeyName))
+	return &ec2.DeleteKeyPairOutput{}, nil
+}
+
+func (f *FakeEC2) DescribeImages(request *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	response := &ec2.DescribeImagesOutput{}
+	for _, id := range f.order {
+		image := f.images[id]
+		if image == nil || !matchesIDs(request.ImageIds, id) {
+			continue
+		}
+		if len(request.Owners) != 0 && !matchesIDs(request.Owners, aws.StringValue(image.OwnerId)) {
+			continue
+		}
+		match, err := f.matchesFilters(id, request.Filters, map[string][]string{
+			"image-id": {id},
+			"name":     {aws.StringValue(image.Name)},
+			"owner-id": {aws.StringValue(image.OwnerId)},
+			"state":    {aws.StringValue(image.State)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			response.Images = append(response.Images, awsutil.CopyOf(image).(*ec2.Image))
+		}
+	}
+	return response, nil
+}
+
+func (f *FakeEC2) RunInstances(request *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if aws.Int64Value(request.MinCount) != 1 || aws.Int64Value(request.MaxCount) != 1 {
+		return nil, invalidParameter("only a single instance can be launched by the fake")
+	}
+	// As in EC2, repeating a request with the same ClientToken returns the instance that was already launched
+	if token := aws.StringValue(request.ClientToken); token != "" && f.clientTokens[token] != "" {
+		return &ec2.Reservation{
+			ReservationId: aws.String(f.ids.newID("r")),
+			OwnerId:       aws.String(fakeAccountID),
+			Instances:     []*ec2.Instance{f.describeInstance(f.clientTokens[token])},
+		}, nil
+	}
+	imageID := aws.StringValue(request.ImageId)
+	if f.images[imageID] == nil {
+		return nil, notFound("InvalidAMIID.NotFound", imageID)
+	}
+
+	subnetID := request.SubnetId
+	privateIP := request.PrivateIpAddress
+	securityGroupIDs := request.SecurityGroupIds
+	for _, ni := range request.NetworkInterfaces {
+		if aws.Int64Value(ni.DeviceIndex) != 0 {
+			continue
+		}
+		subnetID = ni.SubnetId
+		privateIP = ni.PrivateIpAddress
+		securityGroupIDs = ni.Groups
+	}
+
+	subnet := f.subnets[aws.StringValue(subnetID)]
+	if subnet == nil {
+		return nil, notFound("InvalidSubnetID.NotFound", aws.StringValue(subnetID))
+	}
+	var groups []*ec2.GroupIdentifier
+	for _, sgID := range securityGroupIDs {
+		sg := f.securityGroups[aws.StringValue(sgID)]
+		if sg == nil {
+			return nil, notFound("InvalidGroup.NotFound", aws.StringValue(sgID))
+		}
+		groups = append(groups, &ec2.GroupIdentifier{GroupId: sg.GroupId, GroupName: sg.GroupName})
+	}
+	if request.KeyName != nil && f.keyPairs[*request.KeyName] == nil {
+		return nil, awserr.New("InvalidKeyPair.NotFound", fmt.Sprintf("The key pair %q does not exist", *request.KeyName), nil)
+	}
+	if privateIP == nil {
+		privateIP = aws.String(f.nextPrivateIP(aws.StringValue(subnet.CidrBlock)))
+	}
+
+	id := f.ids.newID("i")
+	i := &ec2.Instance{
+		InstanceId:       aws.String(id),
+		ImageId:          aws.String(imageID),
+		InstanceType:     request.InstanceType,
+		KeyName:          request.KeyName,
+		SubnetId:         subnet.SubnetId,
+		VpcId:            subnet.VpcId,
+		PrivateIpAddress: privateIP,
+		SecurityGroups:   groups,
+		Placement:        &ec2.Placement{AvailabilityZone: subnet.AvailabilityZone},
+		State:            &ec2.InstanceState{Code: aws.Int64(16), Name: aws.String(ec2.InstanceStateNameRunning)},
+		LaunchTime:       aws.Time(time.Now()),
+	}
+	if request.IamInstanceProfile != nil {
+		name := aws.StringValue(request.IamInstanceProfile.Name)
+		var ip *iam.InstanceProfile
+		if f.iam != nil {
+			ip = f.iam.findInstanceProfile(name)
+		}
+		if ip == nil {
+			return nil, invalidParameter("Value (%s) for parameter iamInstanceProfile.name is invalid. Invalid IAM Instance Profile name", name)
+		}
+		i.IamInstanceProfile = &ec2.IamInstanceProfile{
+			Arn: ip.Arn,
+			Id:  ip.InstanceProfileId,
+		}
+	}
+	f.instances[id] = i
+	f.instanceUserData[id] = request.UserData
+	if token := aws.StringValue(request.ClientToken); token != "" {
+		f.clientTokens[token] = id
+	}
+	f.addResource("instance", id)
+
+	// Volumes in the block device mappings are created with the instance
+	for _, bdm := range request.BlockDeviceMappings {
+		if bdm.Ebs == nil {
+			continue
+		}
+		volumeID := f.ids.newID("vol")
+		v := &ec2.Volume{
+			VolumeId:         aws.String(volumeID),
+			AvailabilityZone: subnet.AvailabilityZone,
+			Size:             bdm.Ebs.VolumeSize,
+			VolumeType:       bdm.Ebs.VolumeType,
+			State:            aws.String(ec2.VolumeStateAvailable),
+			CreateTime:       aws.Time(time.Now()),
+		}
+		f.volumes[volumeID] = v
+		f.addResource("volume", volumeID)
+		deleteOnTermination := bdm.Ebs.DeleteOnTermination == nil || *bdm.Ebs.DeleteOnTermination
+		f.attachVolume(i, v, aws.StringValue(bdm.DeviceName), deleteOnTermination)
+	}
+
+	reservation := &ec2.Reservation{
+		ReservationId: aws.String(f.ids.newID("r")),
+		OwnerId:       aws.String(fakeAccountID),
+		Instances:     []*ec2.Instance{f.describeInstance(id)},
+	}
+	return reservation, nil
+}
+
+func (f *FakeEC2) describeInstance(id string) *ec2.Instance {
+	i := awsutil.CopyOf(f.instances[id]).(*ec2.Instance)
+	i.Tags = f.ec2Tags(id)
+	return i
+}
+
+func (f *FakeEC2) DescribeInstanceAttribute(request *ec2.DescribeInstanceAttributeInput) (*ec2.DescribeInstanceAttributeOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id := aws.StringValue(request.InstanceId)
+	if f.instances[id] == nil {
+		return nil, notFound("InvalidInstanceID.NotFound", id)
+	}
+
+	response := &ec2.DescribeInstanceAttributeOutput{InstanceId: request.InstanceId}
+	switch aws.StringValue(request.Attribute) {
+	case ec2.InstanceAttributeNameUserData:
+		response.UserData = &ec2.AttributeValue{Value: f.instanceUserData[id]}
+	default:
+		return nil, invalidParameter("attribute %q is not supported by the fake", aws.StringValue(request.Attribute))
+	}
+	return response, nil
+}
+
+func (f *FakeEC2) DescribeInstances(request *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, id := range request.InstanceIds {
+		if f.instances[*id] == nil {
+			return nil, notFound("InvalidInstanceID.NotFound", *id)
+		}
+	}
+
+	response := &ec2.DescribeInstancesOutput{}
+	for _, id := range f.order {
+		i := f.instances[id]
+		if i == nil || !matchesIDs(request.InstanceIds, id) {
+			continue
+		}
+		match, err := f.matchesFilters(id, request.Filters, map[string][]string{
+			"instance-id":         {id},
+			"instance-state-name": {aws.StringValue(i.State.Name)},
+			"subnet-id":           {aws.StringValue(i.SubnetId)},
+			"vpc-id":              {aws.StringValue(i.VpcId)},
+			"key-name":            {aws.StringValue(i.KeyName)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			response.Reservations = append(response.Reservations, &ec2.Reservation{
+				OwnerId:   aws.String(fakeAccountID),
+				Instances: []*ec2.Instance{f.describeInstance(id)},
+			})
+		}
+	}
+
+	start, end, next, err := paginate(len(response.Reservations), request.NextToken, request.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	response.Reservations = response.Reservations[start:end]
+	response.NextToken = next
+	return response, nil
+}
+
+func (f *FakeEC2) TerminateInstances(request *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, id := range request.InstanceIds {
+		if f.instances[*id] == nil {
+			return nil, notFound("InvalidInstanceID.NotFound", *id)
+		}
+	}
+
+	response := &ec2.TerminateInstancesOutput{}
+	for _, id := range request.InstanceIds {
+		i := f.instances[*id]
+		previous := i.State
+		// Terminated instances remain visible (as in EC2), but release their resources immediately
+		i.State = &ec2.InstanceState{Code: aws.Int64(48), Name: aws.String(ec2.InstanceStateNameTerminated)}
+
+		for _, a := range f.addresses {
+			if aws.StringValue(a.InstanceId) == *id {
+				f.disassociateAddress(a)
+			}
+		}
+		for _, bdm := range i.BlockDeviceMappings {
+			if bdm.Ebs == nil {
+				continue
+			}
+			v := f.volumes[aws.StringValue(bdm.Ebs.VolumeId)]
+			if v == nil {
+				continue
+			}
+			f.detachVolume(v)
+			if aws.BoolValue(bdm.Ebs.DeleteOnTermination) {
+				delete(f.volumes, *v.VolumeId)
+				f.removeResource(*v.VolumeId)
+			}
+		}
+		i.BlockDeviceMappings = nil
+
+		response.TerminatingInstances = append(response.TerminatingInstances, &ec2.InstanceStateChange{
+			InstanceId:    aws.String(*id),
+			PreviousState: previous,
+			CurrentState:  i.State,
+		})
+	}
+	return response, nil
+}
+
+// resources describes the resources that exist, other than images and terminated instances
+func (f *FakeEC2) resources() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var resources []string
+	for _, id := range f.order {
+		t := f.resourceTypes[id]
+		if t == "" {
+			continue
+		}
+		if i := f.instances[id]; i != nil && aws.StringValue(i.State.Name) == ec2.InstanceStateNameTerminated {
+			continue
+		}
+		resources = append(resources, t+" "+id)
+	}
+	var keyNames []string
+	for name := range f.keyPairs {
+		keyNames = append(keyNames, name)
+	}
+	sort.Strings(keyNames)
+	for _, name := range keyNames {
+		resources = append(resources, "keypair "+name)
+	}
+	return resources
+}
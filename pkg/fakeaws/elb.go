@@ -0,0 +1,164 @@
+package fakeaws
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+)
+
+// FakeELB is an in-memory implementation of the ELB calls that kope makes
+type FakeELB struct {
+	elbiface.ELBAPI
+
+	mutex sync.Mutex
+
+	loadBalancers map[string]*elb.LoadBalancerDescription
+	tags          map[string][]*elb.Tag
+}
+
+var _ elbiface.ELBAPI = &FakeELB{}
+
+func newFakeELB() *FakeELB {
+	return &FakeELB{
+		loadBalancers: make(map[string]*elb.LoadBalancerDescription),
+		tags:          make(map[string][]*elb.Tag),
+	}
+}
+
+func loadBalancerNotFound(name string) error {
+	return awserr.New("LoadBalancerNotFound", fmt.Sprintf("There is no ACTIVE Load Balancer named '%s'", name), nil)
+}
+
+func (f *FakeELB) CreateLoadBalancer(request *elb.CreateLoadBalancerInput) (*elb.CreateLoadBalancerOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name := aws.StringValue(request.LoadBalancerName)
+	if f.loadBalancers[name] != nil {
+		return nil, awserr.New("DuplicateLoadBalancerName", fmt.Sprintf("Load balancer %s already exists", name), nil)
+	}
+
+	request = awsutil.CopyOf(request).(*elb.CreateLoadBalancerInput)
+	dnsName := name + ".elb.amazonaws.com"
+	lb := &elb.LoadBalancerDescription{
+		LoadBalancerName: aws.String(name),
+		DNSName:          aws.String(dnsName),
+		Subnets:          request.Subnets,
+		SecurityGroups:   request.SecurityGroups,
+		Scheme:           request.Scheme,
+		CreatedTime:      aws.Time(time.Now()),
+	}
+	for _, l := range request.Listeners {
+		lb.ListenerDescriptions = append(lb.ListenerDescriptions, &elb.ListenerDescription{Listener: l})
+	}
+	f.loadBalancers[name] = lb
+	f.tags[name] = request.Tags
+
+	return &elb.CreateLoadBalancerOutput{DNSName: aws.String(dnsName)}, nil
+}
+
+func (f *FakeELB) DescribeLoadBalancers(request *elb.DescribeLoadBalancersInput) (*elb.DescribeLoadBalancersOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, name := range request.LoadBalancerNames {
+		if f.loadBalancers[*name] == nil {
+			return nil, loadBalancerNotFound(*name)
+		}
+	}
+
+	var names []string
+	for name := range f.loadBalancers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	response := &elb.DescribeLoadBalancersOutput{}
+	for _, name := range names {
+		if !matchesIDs(request.LoadBalancerNames, name) {
+			continue
+		}
+		response.LoadBalancerDescriptions = append(response.LoadBalancerDescriptions, awsutil.CopyOf(f.loadBalancers[name]).(*elb.LoadBalancerDescription))
+	}
+
+	start, end, next, err := paginate(len(response.LoadBalancerDescriptions), request.Marker, request.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	response.LoadBalancerDescriptions = response.LoadBalancerDescriptions[start:end]
+	response.NextMarker = next
+	return response, nil
+}
+
+func (f *FakeELB) AddTags(request *elb.AddTagsInput) (*elb.AddTagsOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, name := range request.LoadBalancerNames {
+		if f.loadBalancers[*name] == nil {
+			return nil, loadBalancerNotFound(*name)
+		}
+	}
+	for _, name := range request.LoadBalancerNames {
+		for _, tag := range request.Tags {
+			replaced := false
+			for _, existing := range f.tags[*name] {
+				if aws.StringValue(existing.Key) == aws.StringValue(tag.Key) {
+					existing.Value = tag.Value
+					replaced = true
+				}
+			}
+			if !replaced {
+				f.tags[*name] = append(f.tags[*name], &elb.Tag{Key: tag.Key, Value: tag.Value})
+			}
+		}
+	}
+	return &elb.AddTagsOutput{}, nil
+}
+
+func (f *FakeELB) DescribeTags(request *elb.DescribeTagsInput) (*elb.DescribeTagsOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	response := &elb.DescribeTagsOutput{}
+	for _, name := range request.LoadBalancerNames {
+		if f.loadBalancers[*name] == nil {
+			return nil, loadBalancerNotFound(*name)
+		}
+		response.TagDescriptions = append(response.TagDescriptions, &elb.TagDescription{
+			LoadBalancerName: aws.String(*name),
+			Tags:             awsutil.CopyOf(f.tags[*name]).([]*elb.Tag),
+		})
+	}
+	return response, nil
+}
+
+func (f *FakeELB) DeleteLoadBalancer(request *elb.DeleteLoadBalancerInput) (*elb.DeleteLoadBalancerOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	// Like ELB, deleting a load balancer that does not exist is not an error
+	name := aws.StringValue(request.LoadBalancerName)
+	delete(f.loadBalancers, name)
+	delete(f.tags, name)
+	return &elb.DeleteLoadBalancerOutput{}, nil
+}
+
+func (f *FakeELB) resources() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var resources []string
+	for name := range f.loadBalancers {
+		resources = append(resources, "load-balancer "+name)
+	}
+	sort.Strings(resources)
+	return resources
+}
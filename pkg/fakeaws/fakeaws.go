@@ -0,0 +1,144 @@
+// Package fakeaws is an in-memory implementation of the AWS services used by kope (EC2, IAM, Autoscaling, ELB & S3),
+// so that clusters can be created, updated and deleted without an AWS account.
+//
+// Only the calls (and filters) that kope makes are implemented; calling anything else will panic.
+package fakeaws
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/kopeio/kope/pkg/fi"
+)
+
+// FakeAWS holds the state of the fake services; clouds built from the same FakeAWS share that state
+type FakeAWS struct {
+	EC2         *FakeEC2
+	IAM         *FakeIAM
+	Autoscaling *FakeAutoscaling
+	ELB         *FakeELB
+	S3          *FakeS3
+}
+
+func New() *FakeAWS {
+	f := &FakeAWS{}
+	f.EC2 = newFakeEC2()
+	f.IAM = newFakeIAM()
+	f.Autoscaling = newFakeAutoscaling()
+	f.ELB = newFakeELB()
+	f.S3 = newFakeS3()
+
+	f.EC2.iam = f.IAM
+	return f
+}
+
+// NewCloud builds an AWSCloud which uses the fake services
+func (f *FakeAWS) NewCloud(region string, tags map[string]string) *fi.AWSCloud {
+	cloud := fi.NewAWSCloud(region, tags)
+
+	cloud.Credentials = credentials.NewStaticCredentials("FAKEACCESSKEYID", "fakesecretaccesskey", "")
+	cloud.EC2 = f.EC2
+	cloud.IAM = f.IAM
+	cloud.Autoscaling = f.Autoscaling
+	cloud.ELB = f.ELB
+	cloud.S3 = fi.NewS3HelperWithClients(f.S3.client(region), func(region string) s3iface.S3API {
+		return f.S3.client(region)
+	})
+	// CloudFormation is not faked
+	cloud.CloudFormation = nil
+
+	return cloud
+}
+
+// Resources describes every resource that exists in the fake (except the images added with AddImage,
+// and empty S3 buckets), so that tests can check that a cluster was completely deleted
+func (f *FakeAWS) Resources() []string {
+	var resources []string
+	resources = append(resources, f.EC2.resources()...)
+	resources = append(resources, f.IAM.resources()...)
+	resources = append(resources, f.Autoscaling.resources()...)
+	resources = append(resources, f.ELB.resources()...)
+	resources = append(resources, f.S3.resources()...)
+	return resources
+}
+
+// idGenerator builds AWS-style IDs, that are unique within the fake
+type idGenerator struct {
+	mutex sync.Mutex
+	next  int
+}
+
+func (g *idGenerator) newID(prefix string) string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.next++
+	return fmt.Sprintf("%s-%08x", prefix, g.next)
+}
+
+// PageSize is the most results that a paginated call returns when the caller does not ask for fewer.
+// AWS pages are usually this size; tests can lower it to exercise pagination.
+var PageSize = 1000
+
+// paginate returns the range [start, end) of the n results that are in the page starting at token,
+// and the token for the following page, which is nil on the last page
+func paginate(n int, token *string, maxResults *int64) (int, int, *string, error) {
+	start := 0
+	if token != nil && *token != "" {
+		if _, err := fmt.Sscanf(*token, "page-%d", &start); err != nil || start < 0 || start > n {
+			return 0, 0, nil, invalidParameter("invalid pagination token %q", *token)
+		}
+	}
+
+	pageSize := PageSize
+	if maxResults != nil && *maxResults > 0 && int(*maxResults) < pageSize {
+		pageSize = int(*maxResults)
+	}
+
+	end := start + pageSize
+	if end >= n {
+		return start, n, nil, nil
+	}
+	next := fmt.Sprintf("page-%d", end)
+	return start, end, &next, nil
+}
+
+func notFound(code string, id string) error {
+	return awserr.New(code, fmt.Sprintf("The ID %q does not exist", id), nil)
+}
+
+func invalidParameter(message string, args ...interface{}) error {
+	return awserr.New("InvalidParameterValue", fmt.Sprintf(message, args...), nil)
+}
+
+func dependencyViolation(message string, args ...interface{}) error {
+	return awserr.New("DependencyViolation", fmt.Sprintf(message, args...), nil)
+}
+
+// matchesValues returns true if any of the actual values is one of the filter values
+func matchesValues(filterValues []*string, actual []string) bool {
+	for _, fv := range filterValues {
+		for _, a := range actual {
+			if fv != nil && *fv == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesIDs returns true if ids is empty, or id is in ids
+func matchesIDs(ids []*string, id string) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	for _, s := range ids {
+		if s != nil && *s == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,399 @@
+package fakeaws
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/kopeio/kope/pkg/fi"
+)
+
+// The fake answers HTTP requests rather than SDK calls in NewHTTPCloud, so that the HTTP traffic can be recorded
+// (by fi.AWSRecorder).  Rather than parse the requests, the SDK request is passed to Transport in the context
+// of the HTTP request, and the fake's output is encoded as AWS would encode it.
+
+type sdkRequestKey struct{}
+
+const fakeRequestID = "fakeaws"
+
+// NewHTTPCloud builds an AWSCloud which uses the real SDK clients, sending their HTTP requests to config's
+// HTTPClient.  That client must eventually send the requests to Transport.
+func (f *FakeAWS) NewHTTPCloud(region string, tags map[string]string, baseConfig *aws.Config) *fi.AWSCloud {
+	config := baseConfig.Copy().WithCredentials(credentials.NewStaticCredentials("FAKEACCESSKEYID", "fakesecretaccesskey", ""))
+	cloud := fi.NewAWSCloudWithConfig(region, tags, config)
+
+	addSDKRequestToContext(&cloud.EC2.(*ec2.EC2).Handlers)
+	addSDKRequestToContext(&cloud.IAM.(*iam.IAM).Handlers)
+	addSDKRequestToContext(&cloud.Autoscaling.(*autoscaling.AutoScaling).Handlers)
+	addSDKRequestToContext(&cloud.ELB.(*elb.ELB).Handlers)
+
+	newS3Client := func(region string) s3iface.S3API {
+		regionConfig := config.Copy().WithRegion(region)
+		client := s3.New(session.New(regionConfig), regionConfig)
+		addSDKRequestToContext(&client.Handlers)
+		return client
+	}
+	cloud.S3 = fi.NewS3HelperWithClients(newS3Client(region), newS3Client)
+	// CloudFormation is not faked
+	cloud.CloudFormation = nil
+
+	return cloud
+}
+
+func addSDKRequestToContext(handlers *request.Handlers) {
+	handlers.Send.PushFront(func(r *request.Request) {
+		r.HTTPRequest = r.HTTPRequest.WithContext(context.WithValue(r.HTTPRequest.Context(), sdkRequestKey{}, r))
+	})
+}
+
+// Transport returns an http.RoundTripper that answers the requests from the clients built by NewHTTPCloud
+func (f *FakeAWS) Transport() http.RoundTripper {
+	return &fakeTransport{aws: f}
+}
+
+type fakeTransport struct {
+	aws *FakeAWS
+}
+
+func (t *fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r, ok := req.Context().Value(sdkRequestKey{}).(*request.Request)
+	if !ok {
+		return nil, fmt.Errorf("request was not made by a client from NewHTTPCloud: %s %s", req.Method, req.URL)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading request body: %v", err)
+		}
+	}
+
+	var service interface{}
+	switch r.ClientInfo.ServiceName {
+	case ec2.ServiceName:
+		service = t.aws.EC2
+	case iam.ServiceName:
+		service = t.aws.IAM
+	case autoscaling.ServiceName:
+		service = t.aws.Autoscaling
+	case elb.ServiceName:
+		service = t.aws.ELB
+	case s3.ServiceName:
+		service = t.aws.S3.client(aws.StringValue(r.Config.Region))
+	default:
+		return nil, fmt.Errorf("service %q is not faked", r.ClientInfo.ServiceName)
+	}
+
+	output, err := callFake(service, r.Operation.Name, r.Params, body)
+
+	response := &http.Response{
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+	response.Header.Set("X-Amzn-Requestid", fakeRequestID)
+
+	var responseBody []byte
+	if err != nil {
+		response.StatusCode, responseBody = encodeError(r.ClientInfo.ServiceName, err)
+	} else if r.ClientInfo.ServiceName == s3.ServiceName {
+		response.StatusCode, responseBody = encodeRESTOutput(r.Operation.Name, output, response.Header)
+	} else {
+		responseBody = encodeQueryOutput(r.ClientInfo.ServiceName, r.Operation.Name, output)
+	}
+	if req.Method == "HEAD" {
+		responseBody = nil
+	}
+
+	response.Status = fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode))
+	response.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+	response.ContentLength = int64(len(responseBody))
+	return response, nil
+}
+
+// callFake calls the method of the fake for the operation.  The SDK has already read the Body of the input
+// (when it sent the request), so the fake is given a copy of the input with the body that was sent.
+func callFake(service interface{}, operation string, params interface{}, body []byte) (output interface{}, err error) {
+	method := reflect.ValueOf(service).MethodByName(operation)
+	if !method.IsValid() {
+		return nil, fmt.Errorf("operation %s is not faked", operation)
+	}
+
+	input := reflect.ValueOf(params)
+	if bodyField := input.Elem().FieldByName("Body"); bodyField.IsValid() && bodyField.Type() == reflect.TypeOf((*io.ReadSeeker)(nil)).Elem() {
+		copied := reflect.New(input.Elem().Type())
+		copied.Elem().Set(input.Elem())
+		copied.Elem().FieldByName("Body").Set(reflect.ValueOf(io.ReadSeeker(bytes.NewReader(body))))
+		input = copied
+	}
+
+	// The fake clients embed the SDK interface, so an operation that is not faked panics
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("operation %s is not faked: %v", operation, recovered)
+		}
+	}()
+
+	results := method.Call([]reflect.Value{input})
+	if !results[1].IsNil() {
+		return nil, results[1].Interface().(error)
+	}
+	return results[0].Interface(), nil
+}
+
+// Errors that S3 reports with a 404
+var s3NotFoundCodes = map[string]bool{
+	"NoSuchBucket": true,
+	"NoSuchKey":    true,
+	"NoSuchUpload": true,
+	"NotFound":     true,
+}
+
+func encodeError(serviceName string, err error) (int, []byte) {
+	// Not a 5xx, which would be retried
+	code := "NotImplemented"
+	message := err.Error()
+	status := http.StatusBadRequest
+	if awsError, ok := err.(awserr.Error); ok {
+		code = awsError.Code()
+		message = awsError.Message()
+	}
+
+	var b bytes.Buffer
+	switch serviceName {
+	case ec2.ServiceName:
+		b.WriteString("<Response><Errors><Error>")
+		writeXMLElement(&b, "Code", code)
+		writeXMLElement(&b, "Message", message)
+		b.WriteString("</Error></Errors>")
+		writeXMLElement(&b, "RequestID", fakeRequestID)
+		b.WriteString("</Response>")
+	case s3.ServiceName:
+		if s3NotFoundCodes[code] {
+			status = http.StatusNotFound
+		}
+		b.WriteString("<Error>")
+		writeXMLElement(&b, "Code", code)
+		writeXMLElement(&b, "Message", message)
+		b.WriteString("</Error>")
+	default:
+		b.WriteString("<ErrorResponse><Error>")
+		writeXMLElement(&b, "Code", code)
+		writeXMLElement(&b, "Message", message)
+		b.WriteString("</Error>")
+		writeXMLElement(&b, "RequestId", fakeRequestID)
+		b.WriteString("</ErrorResponse>")
+	}
+	return status, b.Bytes()
+}
+
+// encodeQueryOutput encodes the output of an EC2 or query API (IAM, Autoscaling, ELB) call
+func encodeQueryOutput(serviceName string, operation string, output interface{}) []byte {
+	var b bytes.Buffer
+	b.WriteString("<" + operation + "Response>")
+	if serviceName == ec2.ServiceName {
+		writeXMLElement(&b, "requestId", fakeRequestID)
+		writeXMLFields(&b, reflect.ValueOf(output))
+	} else {
+		encodeXMLValue(&b, operation+"Result", reflect.ValueOf(output), "")
+		b.WriteString("<ResponseMetadata>")
+		writeXMLElement(&b, "RequestId", fakeRequestID)
+		b.WriteString("</ResponseMetadata>")
+	}
+	b.WriteString("</" + operation + "Response>")
+	return b.Bytes()
+}
+
+// encodeRESTOutput encodes the output of an S3 call: the headers, and the payload or the body fields
+func encodeRESTOutput(operation string, output interface{}, header http.Header) (int, []byte) {
+	status := http.StatusOK
+	v := reflect.ValueOf(output).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+		if field.PkgPath != "" || (value.Kind() == reflect.Ptr && value.IsNil()) {
+			continue
+		}
+		switch field.Tag.Get("location") {
+		case "header":
+			header.Set(field.Tag.Get("locationName"), encodeHeaderValue(value.Elem()))
+		case "headers":
+			prefix := field.Tag.Get("locationName")
+			for _, k := range value.MapKeys() {
+				header.Set(prefix+k.String(), aws.StringValue(value.MapIndex(k).Interface().(*string)))
+			}
+		case "statusCode":
+			status = int(value.Elem().Int())
+		}
+	}
+
+	if operation == "GetBucketLocation" {
+		// The SDK extracts the location from the body with a regex
+		var b bytes.Buffer
+		writeXMLElement(&b, "LocationConstraint", aws.StringValue(output.(*s3.GetBucketLocationOutput).LocationConstraint))
+		return status, b.Bytes()
+	}
+
+	if metadata, ok := t.FieldByName("_"); ok {
+		if payload := metadata.Tag.Get("payload"); payload != "" {
+			field, _ := t.FieldByName(payload)
+			value := v.FieldByName(payload)
+			if field.Type.Kind() == reflect.Interface {
+				// A streaming body, e.g. GetObject
+				if value.IsNil() {
+					return status, nil
+				}
+				data, err := ioutil.ReadAll(value.Interface().(io.Reader))
+				if err != nil {
+					return http.StatusInternalServerError, []byte(err.Error())
+				}
+				return status, data
+			}
+			var b bytes.Buffer
+			encodeXMLValue(&b, field.Tag.Get("locationName"), value, field.Tag)
+			return status, b.Bytes()
+		}
+	}
+
+	var b bytes.Buffer
+	encodeXMLValue(&b, operation+"Result", v, "")
+	return status, b.Bytes()
+}
+
+func encodeHeaderValue(v reflect.Value) string {
+	switch value := v.Interface().(type) {
+	case time.Time:
+		return value.UTC().Format(http.TimeFormat)
+	default:
+		return encodeScalar(v)
+	}
+}
+
+func encodeScalar(v reflect.Value) string {
+	switch value := v.Interface().(type) {
+	case string:
+		return value
+	case bool:
+		return strconv.FormatBool(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case time.Time:
+		return value.UTC().Format("2006-01-02T15:04:05.000Z")
+	case []byte:
+		return base64.StdEncoding.EncodeToString(value)
+	default:
+		panic(fmt.Sprintf("cannot encode %T", value))
+	}
+}
+
+func writeXMLElement(b *bytes.Buffer, name string, text string) {
+	b.WriteString("<" + name + ">")
+	xml.EscapeText(b, []byte(text))
+	b.WriteString("</" + name + ">")
+}
+
+// encodeXMLValue encodes v as the SDK's XML unmarshaller expects it, following the locationName tags
+func encodeXMLValue(b *bytes.Buffer, name string, v reflect.Value, tag reflect.StructTag) {
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if _, ok := v.Interface().(time.Time); ok {
+			writeXMLElement(b, name, encodeScalar(v))
+			return
+		}
+		b.WriteString("<" + name + ">")
+		writeXMLFields(b, v)
+		b.WriteString("</" + name + ">")
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			writeXMLElement(b, name, encodeScalar(v))
+			return
+		}
+		if tag.Get("flattened") != "" {
+			for i := 0; i < v.Len(); i++ {
+				encodeXMLValue(b, name, v.Index(i), "")
+			}
+			return
+		}
+		member := tag.Get("locationNameList")
+		if member == "" {
+			member = "member"
+		}
+		b.WriteString("<" + name + ">")
+		for i := 0; i < v.Len(); i++ {
+			encodeXMLValue(b, member, v.Index(i), "")
+		}
+		b.WriteString("</" + name + ">")
+
+	case reflect.Map:
+		b.WriteString("<" + name + ">")
+		for _, k := range v.MapKeys() {
+			b.WriteString("<entry>")
+			writeXMLElement(b, "key", k.String())
+			encodeXMLValue(b, "value", v.MapIndex(k), "")
+			b.WriteString("</entry>")
+		}
+		b.WriteString("</" + name + ">")
+
+	default:
+		writeXMLElement(b, name, encodeScalar(v))
+	}
+}
+
+// writeXMLFields encodes the body fields of the struct v
+func writeXMLFields(b *bytes.Buffer, v reflect.Value) {
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("location") != "" || strings.HasPrefix(field.Tag.Get("xml"), "-") {
+			continue
+		}
+		name := field.Tag.Get("locationName")
+		if field.Tag.Get("flattened") != "" && field.Tag.Get("locationNameList") != "" {
+			name = field.Tag.Get("locationNameList")
+		}
+		if name == "" {
+			name = field.Name
+		}
+		encodeXMLValue(b, name, v.Field(i), field.Tag)
+	}
+}
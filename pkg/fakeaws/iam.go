@@ -0,0 +1,281 @@
+package fakeaws
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+// FakeIAM is an in-memory implementation of the IAM calls that kope makes
+type FakeIAM struct {
+	iamiface.IAMAPI
+
+	mutex sync.Mutex
+	ids   idGenerator
+
+	roles            map[string]*iam.Role
+	rolePolicies     map[string]map[string]string
+	instanceProfiles map[string]*iam.InstanceProfile
+}
+
+var _ iamiface.IAMAPI = &FakeIAM{}
+
+func newFakeIAM() *FakeIAM {
+	return &FakeIAM{
+		roles:            make(map[string]*iam.Role),
+		rolePolicies:     make(map[string]map[string]string),
+		instanceProfiles: make(map[string]*iam.InstanceProfile),
+	}
+}
+
+func noSuchEntity(kind string, name string) error {
+	return awserr.New("NoSuchEntity", fmt.Sprintf("The %s with name %s cannot be found.", kind, name), nil)
+}
+
+func entityAlreadyExists(kind string, name string) error {
+	return awserr.New("EntityAlreadyExists", fmt.Sprintf("%s with name %s already exists.", kind, name), nil)
+}
+
+func deleteConflict(message string, args ...interface{}) error {
+	return awserr.New("DeleteConflict", fmt.Sprintf(message, args...), nil)
+}
+
+func (f *FakeIAM) CreateRole(request *iam.CreateRoleInput) (*iam.CreateRoleOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name := aws.StringValue(request.RoleName)
+	if f.roles[name] != nil {
+		return nil, entityAlreadyExists("Role", name)
+	}
+
+	role := &iam.Role{
+		RoleId:   aws.String(f.ids.newID("AROA")),
+		RoleName: aws.String(name),
+		Path:     aws.String("/"),
+		Arn:      aws.String("arn:aws:iam::" + fakeAccountID + ":role/" + name),
+		// Like IAM, policy documents are returned URL-encoded
+		AssumeRolePolicyDocument: aws.String(url.QueryEscape(aws.StringValue(request.AssumeRolePolicyDocument))),
+		CreateDate:               aws.Time(time.Now()),
+	}
+	f.roles[name] = role
+
+	return &iam.CreateRoleOutput{Role: awsutil.CopyOf(role).(*iam.Role)}, nil
+}
+
+func (f *FakeIAM) GetRole(request *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name := aws.StringValue(request.RoleName)
+	role := f.roles[name]
+	if role == nil {
+		return nil, noSuchEntity("role", name)
+	}
+	return &iam.GetRoleOutput{Role: awsutil.CopyOf(role).(*iam.Role)}, nil
+}
+
+func (f *FakeIAM) DeleteRole(request *iam.DeleteRoleInput) (*iam.DeleteRoleOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name := aws.StringValue(request.RoleName)
+	if f.roles[name] == nil {
+		return nil, noSuchEntity("role", name)
+	}
+	if len(f.rolePolicies[name]) != 0 {
+		return nil, deleteConflict("Cannot delete entity, must delete policies first.")
+	}
+	for _, ip := range f.instanceProfiles {
+		for _, r := range ip.Roles {
+			if aws.StringValue(r.RoleName) == name {
+				return nil, deleteConflict("Cannot delete entity, must remove roles from instance profile first.")
+			}
+		}
+	}
+
+	delete(f.roles, name)
+	delete(f.rolePolicies, name)
+	return &iam.DeleteRoleOutput{}, nil
+}
+
+func (f *FakeIAM) PutRolePolicy(request *iam.PutRolePolicyInput) (*iam.PutRolePolicyOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	roleName := aws.StringValue(request.RoleName)
+	if f.roles[roleName] == nil {
+		return nil, noSuchEntity("role", roleName)
+	}
+
+	policies := f.rolePolicies[roleName]
+	if policies == nil {
+		policies = make(map[string]string)
+		f.rolePolicies[roleName] = policies
+	}
+	policies[aws.StringValue(request.PolicyName)] = aws.StringValue(request.PolicyDocument)
+	return &iam.PutRolePolicyOutput{}, nil
+}
+
+func (f *FakeIAM) GetRolePolicy(request *iam.GetRolePolicyInput) (*iam.GetRolePolicyOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	roleName := aws.StringValue(request.RoleName)
+	if f.roles[roleName] == nil {
+		return nil, noSuchEntity("role", roleName)
+	}
+	policyName := aws.StringValue(request.PolicyName)
+	policy, found := f.rolePolicies[roleName][policyName]
+	if !found {
+		return nil, noSuchEntity("role policy", policyName)
+	}
+
+	return &iam.GetRolePolicyOutput{
+		RoleName:       aws.String(roleName),
+		PolicyName:     aws.String(policyName),
+		PolicyDocument: aws.String(url.QueryEscape(policy)),
+	}, nil
+}
+
+func (f *FakeIAM) DeleteRolePolicy(request *iam.DeleteRolePolicyInput) (*iam.DeleteRolePolicyOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	roleName := aws.StringValue(request.RoleName)
+	policyName := aws.StringValue(request.PolicyName)
+	if _, found := f.rolePolicies[roleName][policyName]; !found {
+		return nil, noSuchEntity("role policy", policyName)
+	}
+
+	delete(f.rolePolicies[roleName], policyName)
+	return &iam.DeleteRolePolicyOutput{}, nil
+}
+
+func (f *FakeIAM) CreateInstanceProfile(request *iam.CreateInstanceProfileInput) (*iam.CreateInstanceProfileOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name := aws.StringValue(request.InstanceProfileName)
+	if f.instanceProfiles[name] != nil {
+		return nil, entityAlreadyExists("Instance Profile", name)
+	}
+
+	ip := &iam.InstanceProfile{
+		InstanceProfileId:   aws.String(f.ids.newID("AIPA")),
+		InstanceProfileName: aws.String(name),
+		Path:                aws.String("/"),
+		Arn:                 aws.String("arn:aws:iam::" + fakeAccountID + ":instance-profile/" + name),
+		CreateDate:          aws.Time(time.Now()),
+		Roles:               []*iam.Role{},
+	}
+	f.instanceProfiles[name] = ip
+
+	return &iam.CreateInstanceProfileOutput{InstanceProfile: awsutil.CopyOf(ip).(*iam.InstanceProfile)}, nil
+}
+
+func (f *FakeIAM) GetInstanceProfile(request *iam.GetInstanceProfileInput) (*iam.GetInstanceProfileOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name := aws.StringValue(request.InstanceProfileName)
+	ip := f.instanceProfiles[name]
+	if ip == nil {
+		return nil, noSuchEntity("instance profile", name)
+	}
+	return &iam.GetInstanceProfileOutput{InstanceProfile: awsutil.CopyOf(ip).(*iam.InstanceProfile)}, nil
+}
+
+// findInstanceProfile returns a copy of the named instance profile, or nil if it does not exist
+func (f *FakeIAM) findInstanceProfile(name string) *iam.InstanceProfile {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	ip := f.instanceProfiles[name]
+	if ip == nil {
+		return nil
+	}
+	return awsutil.CopyOf(ip).(*iam.InstanceProfile)
+}
+
+func (f *FakeIAM) DeleteInstanceProfile(request *iam.DeleteInstanceProfileInput) (*iam.DeleteInstanceProfileOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name := aws.StringValue(request.InstanceProfileName)
+	ip := f.instanceProfiles[name]
+	if ip == nil {
+		return nil, noSuchEntity("instance profile", name)
+	}
+	if len(ip.Roles) != 0 {
+		return nil, deleteConflict("Cannot delete entity, must remove roles from instance profile first.")
+	}
+
+	delete(f.instanceProfiles, name)
+	return &iam.DeleteInstanceProfileOutput{}, nil
+}
+
+func (f *FakeIAM) AddRoleToInstanceProfile(request *iam.AddRoleToInstanceProfileInput) (*iam.AddRoleToInstanceProfileOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name := aws.StringValue(request.InstanceProfileName)
+	ip := f.instanceProfiles[name]
+	if ip == nil {
+		return nil, noSuchEntity("instance profile", name)
+	}
+	roleName := aws.StringValue(request.RoleName)
+	role := f.roles[roleName]
+	if role == nil {
+		return nil, noSuchEntity("role", roleName)
+	}
+	// Like IAM, an instance profile can only hold a single role
+	if len(ip.Roles) != 0 {
+		return nil, awserr.New("LimitExceeded", "Cannot exceed quota for InstanceSessionsPerInstanceProfile: 1", nil)
+	}
+
+	ip.Roles = append(ip.Roles, awsutil.CopyOf(role).(*iam.Role))
+	return &iam.AddRoleToInstanceProfileOutput{}, nil
+}
+
+func (f *FakeIAM) RemoveRoleFromInstanceProfile(request *iam.RemoveRoleFromInstanceProfileInput) (*iam.RemoveRoleFromInstanceProfileOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name := aws.StringValue(request.InstanceProfileName)
+	ip := f.instanceProfiles[name]
+	if ip == nil {
+		return nil, noSuchEntity("instance profile", name)
+	}
+	roleName := aws.StringValue(request.RoleName)
+	for i, r := range ip.Roles {
+		if aws.StringValue(r.RoleName) == roleName {
+			ip.Roles = append(ip.Roles[:i], ip.Roles[i+1:]...)
+			return &iam.RemoveRoleFromInstanceProfileOutput{}, nil
+		}
+	}
+	return nil, noSuchEntity("role", roleName)
+}
+
+func (f *FakeIAM) resources() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var resources []string
+	for name := range f.roles {
+		resources = append(resources, "role "+name)
+	}
+	for name := range f.instanceProfiles {
+		resources = append(resources, "instance-profile "+name)
+	}
+	sort.Strings(resources)
+	return resources
+}
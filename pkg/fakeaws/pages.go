@@ -0,0 +1,100 @@
+package fakeaws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+)
+
+// The *Pages calls page through the results as the SDK does, calling fn with each page until it returns false
+
+func (f *FakeEC2) DescribeTagsPages(input *ec2.DescribeTagsInput, fn func(*ec2.DescribeTagsOutput, bool) bool) error {
+	request := *input
+	for {
+		response, err := f.DescribeTags(&request)
+		if err != nil {
+			return err
+		}
+		lastPage := aws.StringValue(response.NextToken) == ""
+		if !fn(response, lastPage) || lastPage {
+			return nil
+		}
+		request.NextToken = response.NextToken
+	}
+}
+
+func (f *FakeEC2) DescribeInstancesPages(input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	request := *input
+	for {
+		response, err := f.DescribeInstances(&request)
+		if err != nil {
+			return err
+		}
+		lastPage := aws.StringValue(response.NextToken) == ""
+		if !fn(response, lastPage) || lastPage {
+			return nil
+		}
+		request.NextToken = response.NextToken
+	}
+}
+
+func (f *FakeAutoscaling) DescribeTagsPages(input *autoscaling.DescribeTagsInput, fn func(*autoscaling.DescribeTagsOutput, bool) bool) error {
+	request := *input
+	for {
+		response, err := f.DescribeTags(&request)
+		if err != nil {
+			return err
+		}
+		lastPage := aws.StringValue(response.NextToken) == ""
+		if !fn(response, lastPage) || lastPage {
+			return nil
+		}
+		request.NextToken = response.NextToken
+	}
+}
+
+func (f *FakeAutoscaling) DescribeAutoScalingGroupsPages(input *autoscaling.DescribeAutoScalingGroupsInput, fn func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool) error {
+	request := *input
+	for {
+		response, err := f.DescribeAutoScalingGroups(&request)
+		if err != nil {
+			return err
+		}
+		lastPage := aws.StringValue(response.NextToken) == ""
+		if !fn(response, lastPage) || lastPage {
+			return nil
+		}
+		request.NextToken = response.NextToken
+	}
+}
+
+func (f *FakeAutoscaling) DescribeLaunchConfigurationsPages(input *autoscaling.DescribeLaunchConfigurationsInput, fn func(*autoscaling.DescribeLaunchConfigurationsOutput, bool) bool) error {
+	request := *input
+	for {
+		response, err := f.DescribeLaunchConfigurations(&request)
+		if err != nil {
+			return err
+		}
+		lastPage := aws.StringValue(response.NextToken) == ""
+		if !fn(response, lastPage) || lastPage {
+			return nil
+		}
+		request.NextToken = response.NextToken
+	}
+}
+
+func (f *FakeELB) DescribeLoadBalancersPages(input *elb.DescribeLoadBalancersInput, fn func(*elb.DescribeLoadBalancersOutput, bool) bool) error {
+	request := *input
+	for {
+		response, err := f.DescribeLoadBalancers(&request)
+		if err != nil {
+			return err
+		}
+		lastPage := aws.StringValue(response.NextMarker) == ""
+		if !fn(response, lastPage) || lastPage {
+			return nil
+		}
+		request.Marker = response.NextMarker
+	}
+}
@@ -0,0 +1,588 @@
+package fakeaws
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const aclAllUsers = "http://acs.amazonaws.com/groups/global/AllUsers"
+
+// FakeS3 is an in-memory implementation of the S3 calls that kope makes.  Buckets are global, as in S3,
+// but each bucket remembers the region in which it was created.
+type FakeS3 struct {
+	mutex   sync.Mutex
+	buckets map[string]*fakeBucket
+
+	lastUploadID int
+}
+
+type fakeBucket struct {
+	region  string
+	objects map[string]*fakeObject
+	// uploads are the incomplete multipart uploads, by upload id
+	uploads map[string]*fakeUpload
+}
+
+type fakeUpload struct {
+	key       string
+	metadata  map[string]*string
+	initiated time.Time
+	parts     map[int64]*fakePart
+}
+
+type fakePart struct {
+	data []byte
+	etag string
+}
+
+type fakeObject struct {
+	data                 []byte
+	etag                 string
+	metadata             map[string]*string
+	public               bool
+	lastModified         time.Time
+	serverSideEncryption *string
+}
+
+func newFakeS3() *FakeS3 {
+	return &FakeS3{
+		buckets: make(map[string]*fakeBucket),
+	}
+}
+
+// fakeS3Client is the client for a single region
+type fakeS3Client struct {
+	s3iface.S3API
+
+	s3     *FakeS3
+	region string
+}
+
+func (f *FakeS3) client(region string) s3iface.S3API {
+	return &fakeS3Client{s3: f, region: region}
+}
+
+// GetObjectRequest is only used to presign URLs, which needs no calls to S3, so we build a real request
+func (c *fakeS3Client) GetObjectRequest(input *s3.GetObjectInput) (*request.Request, *s3.GetObjectOutput) {
+	config := aws.NewConfig().WithRegion(c.region).WithCredentials(credentials.NewStaticCredentials("FAKEACCESSKEYID", "fakesecretaccesskey", ""))
+	return s3.New(session.New(config)).GetObjectRequest(input)
+}
+
+func noSuchBucket(name string) error {
+	return awserr.New("NoSuchBucket", fmt.Sprintf("The specified bucket does not exist: %s", name), nil)
+}
+
+func (f *FakeS3) getBucket(name *string) (*fakeBucket, error) {
+	b := f.buckets[aws.StringValue(name)]
+	if b == nil {
+		return nil, noSuchBucket(aws.StringValue(name))
+	}
+	return b, nil
+}
+
+func (c *fakeS3Client) GetBucketLocation(request *s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error) {
+	c.s3.mutex.Lock()
+	defer c.s3.mutex.Unlock()
+
+	b, err := c.s3.getBucket(request.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &s3.GetBucketLocationOutput{}
+	// Like S3, US Classic does not return a region
+	if b.region != "us-east-1" {
+		response.LocationConstraint = aws.String(b.region)
+	}
+	return response, nil
+}
+
+func (c *fakeS3Client) CreateBucket(request *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	c.s3.mutex.Lock()
+	defer c.s3.mutex.Unlock()
+
+	name := aws.StringValue(request.Bucket)
+	if c.s3.buckets[name] != nil {
+		return nil, awserr.New("BucketAlreadyOwnedByYou", fmt.Sprintf("Your previous request to create the named bucket succeeded and you already own it: %s", name), nil)
+	}
+
+	region := c.region
+	if request.CreateBucketConfiguration != nil && request.CreateBucketConfiguration.LocationConstraint != nil {
+		region = *request.CreateBucketConfiguration.LocationConstraint
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	c.s3.buckets[name] = &fakeBucket{
+		region:  region,
+		objects: make(map[string]*fakeObject),
+		uploads: make(map[string]*fakeUpload),
+	}
+	return &s3.CreateBucketOutput{Location: aws.String("/" + name)}, nil
+}
+
+func (c *fakeS3Client) PutObject(request *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	var data []byte
+	if request.Body != nil {
+		var err error
+		data, err = ioutil.ReadAll(request.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading body: %v", err)
+		}
+	}
+
+	c.s3.mutex.Lock()
+	defer c.s3.mutex.Unlock()
+
+	b, err := c.s3.getBucket(request.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &fakeObject{
+		data:                 data,
+		etag:                 md5ETag(data),
+		metadata:             request.Metadata,
+		public:               aws.StringValue(request.ACL) == s3.ObjectCannedACLPublicRead,
+		lastModified:         time.Now(),
+		serverSideEncryption: request.ServerSideEncryption,
+	}
+	b.objects[aws.StringValue(request.Key)] = o
+
+	return &s3.PutObjectOutput{ETag: aws.String(o.etag), ServerSideEncryption: o.serverSideEncryption}, nil
+}
+
+func (c *fakeS3Client) getObject(bucket *string, key *string) (*fakeObject, error) {
+	b, err := c.s3.getBucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	o := b.objects[aws.StringValue(key)]
+	if o == nil {
+		return nil, awserr.New("NoSuchKey", "The specified key does not exist.", nil)
+	}
+	return o, nil
+}
+
+func (c *fakeS3Client) HeadObject(request *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	c.s3.mutex.Lock()
+	defer c.s3.mutex.Unlock()
+
+	o, err := c.getObject(request.Bucket, request.Key)
+	if err != nil {
+		// HEAD responses have no body, so S3 only reports the status code
+		return nil, awserr.NewRequestFailure(awserr.New("NotFound", "Not Found", nil), 404, "")
+	}
+
+	return &s3.HeadObjectOutput{
+		ETag:                 aws.String(o.etag),
+		ContentLength:        aws.Int64(int64(len(o.data))),
+		Metadata:             o.metadata,
+		LastModified:         aws.Time(o.lastModified),
+		ServerSideEncryption: o.serverSideEncryption,
+	}, nil
+}
+
+func (c *fakeS3Client) GetObject(request *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	c.s3.mutex.Lock()
+	defer c.s3.mutex.Unlock()
+
+	o, err := c.getObject(request.Bucket, request.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3.GetObjectOutput{
+		Body:                 ioutil.NopCloser(bytes.NewReader(o.data)),
+		ETag:                 aws.String(o.etag),
+		ContentLength:        aws.Int64(int64(len(o.data))),
+		Metadata:             o.metadata,
+		LastModified:         aws.Time(o.lastModified),
+		ServerSideEncryption: o.serverSideEncryption,
+	}, nil
+}
+
+func (c *fakeS3Client) DeleteObject(request *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	c.s3.mutex.Lock()
+	defer c.s3.mutex.Unlock()
+
+	b, err := c.s3.getBucket(request.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	// Like S3, deleting an object that does not exist is not an error
+	delete(b.objects, aws.StringValue(request.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (c *fakeS3Client) ListObjects(request *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
+	c.s3.mutex.Lock()
+	defer c.s3.mutex.Unlock()
+
+	b, err := c.s3.getBucket(request.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for k := range b.objects {
+		if strings.HasPrefix(k, aws.StringValue(request.Prefix)) && k > aws.StringValue(request.Marker) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	response := &s3.ListObjectsOutput{
+		Name:        request.Bucket,
+		Prefix:      request.Prefix,
+		Marker:      request.Marker,
+		IsTruncated: aws.Bool(false),
+	}
+	maxKeys := int(aws.Int64Value(request.MaxKeys))
+	if maxKeys <= 0 {
+		maxKeys = 1000
+	}
+	for _, k := range keys {
+		if len(response.Contents) >= maxKeys {
+			response.IsTruncated = aws.Bool(true)
+			break
+		}
+		o := b.objects[k]
+		response.Contents = append(response.Contents, &s3.Object{
+			Key:          aws.String(k),
+			ETag:         aws.String(o.etag),
+			Size:         aws.Int64(int64(len(o.data))),
+			LastModified: aws.Time(o.lastModified),
+		})
+	}
+	return response, nil
+}
+
+func (c *fakeS3Client) GetObjectAcl(request *s3.GetObjectAclInput) (*s3.GetObjectAclOutput, error) {
+	c.s3.mutex.Lock()
+	defer c.s3.mutex.Unlock()
+
+	o, err := c.getObject(request.Bucket, request.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := &s3.Owner{ID: aws.String(fakeAccountID)}
+	response := &s3.GetObjectAclOutput{Owner: owner}
+	response.Grants = append(response.Grants, &s3.Grant{
+		Grantee:    &s3.Grantee{ID: owner.ID, Type: aws.String(s3.TypeCanonicalUser)},
+		Permission: aws.String(s3.PermissionFullControl),
+	})
+	if o.public {
+		response.Grants = append(response.Grants, &s3.Grant{
+			Grantee:    &s3.Grantee{URI: aws.String(aclAllUsers), Type: aws.String(s3.TypeGroup)},
+			Permission: aws.String(s3.PermissionRead),
+		})
+	}
+	return response, nil
+}
+
+func (c *fakeS3Client) PutObjectAcl(request *s3.PutObjectAclInput) (*s3.PutObjectAclOutput, error) {
+	c.s3.mutex.Lock()
+	defer c.s3.mutex.Unlock()
+
+	o, err := c.getObject(request.Bucket, request.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.ACL != nil {
+		o.public = *request.ACL == s3.ObjectCannedACLPublicRead
+	}
+	if request.GrantRead != nil {
+		o.public = strings.Contains(*request.GrantRead, aclAllUsers)
+	}
+	return &s3.PutObjectAclOutput{}, nil
+}
+
+func noSuchUpload(uploadID *string) error {
+	return awserr.New("NoSuchUpload", fmt.Sprintf("The specified upload does not exist: %s", aws.StringValue(uploadID)), nil)
+}
+
+func md5ETag(data []byte) string {
+	hash := md5.Sum(data)
+	return "\"" + hex.EncodeToString(hash[:]) + "\""
+}
+
+func (c *fakeS3Client) CreateMultipartUpload(request *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	c.s3.mutex.Lock()
+	defer c.s3.mutex.Unlock()
+
+	b, err := c.s3.getBucket(request.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	c.s3.lastUploadID++
+	uploadID := fmt.Sprintf("upload-%d", c.s3.lastUploadID)
+	b.uploads[uploadID] = &fakeUpload{
+		key:       aws.StringValue(request.Key),
+		metadata:  request.Metadata,
+		initiated: time.Now(),
+		parts:     make(map[int64]*fakePart),
+	}
+	return &s3.CreateMultipartUploadOutput{
+		Bucket:   request.Bucket,
+		Key:      request.Key,
+		UploadId: aws.String(uploadID),
+	}, nil
+}
+
+func (c *fakeS3Client) getUpload(bucket *string, key *string, uploadID *string) (*fakeBucket, *fakeUpload, error) {
+	b, err := c.s3.getBucket(bucket)
+	if err != nil {
+		return nil, nil, err
+	}
+	u := b.uploads[aws.StringValue(uploadID)]
+	if u == nil || u.key != aws.StringValue(key) {
+		return nil, nil, noSuchUpload(uploadID)
+	}
+	return b, u, nil
+}
+
+func (c *fakeS3Client) UploadPart(request *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	var data []byte
+	if request.Body != nil {
+		var err error
+		data, err = ioutil.ReadAll(request.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading body: %v", err)
+		}
+	}
+
+	c.s3.mutex.Lock()
+	defer c.s3.mutex.Unlock()
+
+	_, u, err := c.getUpload(request.Bucket, request.Key, request.UploadId)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := md5.Sum(data)
+	if request.ContentMD5 != nil && *request.ContentMD5 != base64.StdEncoding.EncodeToString(hash[:]) {
+		return nil, awserr.New("BadDigest", "The Content-MD5 you specified did not match what we received.", nil)
+	}
+
+	part := &fakePart{data: data, etag: md5ETag(data)}
+	u.parts[aws.Int64Value(request.PartNumber)] = part
+	return &s3.UploadPartOutput{ETag: aws.String(part.etag)}, nil
+}
+
+func (c *fakeS3Client) CompleteMultipartUpload(request *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	c.s3.mutex.Lock()
+	defer c.s3.mutex.Unlock()
+
+	b, u, err := c.getUpload(request.Bucket, request.Key, request.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	if request.MultipartUpload == nil || len(request.MultipartUpload.Parts) == 0 {
+		return nil, awserr.New("MalformedXML", "The XML you provided was not well-formed", nil)
+	}
+
+	// Like S3, the ETag is the MD5 of the part MD5s, with the number of parts
+	var data []byte
+	var partHashes []byte
+	lastPartNumber := int64(0)
+	for _, completed := range request.MultipartUpload.Parts {
+		partNumber := aws.Int64Value(completed.PartNumber)
+		if partNumber <= lastPartNumber {
+			return nil, awserr.New("InvalidPartOrder", "The list of parts was not in ascending order.", nil)
+		}
+		lastPartNumber = partNumber
+		part := u.parts[partNumber]
+		if part == nil || part.etag != aws.StringValue(completed.ETag) {
+			return nil, awserr.New("InvalidPart", fmt.Sprintf("Part %d could not be found", partNumber), nil)
+		}
+		data = append(data, part.data...)
+		hash := md5.Sum(part.data)
+		partHashes = append(partHashes, hash[:]...)
+	}
+	hash := md5.Sum(partHashes)
+
+	o := &fakeObject{
+		data:         data,
+		etag:         fmt.Sprintf("\"%s-%d\"", hex.EncodeToString(hash[:]), len(request.MultipartUpload.Parts)),
+		metadata:     u.metadata,
+		lastModified: time.Now(),
+	}
+	b.objects[u.key] = o
+	delete(b.uploads, aws.StringValue(request.UploadId))
+
+	return &s3.CompleteMultipartUploadOutput{
+		Bucket: request.Bucket,
+		Key:    request.Key,
+		ETag:   aws.String(o.etag),
+	}, nil
+}
+
+func (c *fakeS3Client) AbortMultipartUpload(request *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	c.s3.mutex.Lock()
+	defer c.s3.mutex.Unlock()
+
+	b, _, err := c.getUpload(request.Bucket, request.Key, request.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	delete(b.uploads, aws.StringValue(request.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (c *fakeS3Client) ListMultipartUploads(request *s3.ListMultipartUploadsInput) (*s3.ListMultipartUploadsOutput, error) {
+	c.s3.mutex.Lock()
+	defer c.s3.mutex.Unlock()
+
+	b, err := c.s3.getBucket(request.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like S3, uploads are listed in key order, then by upload id, starting after the markers
+	keyMarker := aws.StringValue(request.KeyMarker)
+	uploadIDMarker := aws.StringValue(request.UploadIdMarker)
+	var ids []string
+	for id, u := range b.uploads {
+		if !strings.HasPrefix(u.key, aws.StringValue(request.Prefix)) {
+			continue
+		}
+		if u.key < keyMarker || (u.key == keyMarker && id <= uploadIDMarker) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Sort(&uploadsByKey{uploads: b.uploads, ids: ids})
+
+	pageSize := PageSize
+	if request.MaxUploads != nil && *request.MaxUploads > 0 && int(*request.MaxUploads) < pageSize {
+		pageSize = int(*request.MaxUploads)
+	}
+
+	response := &s3.ListMultipartUploadsOutput{
+		Bucket:         request.Bucket,
+		Prefix:         request.Prefix,
+		KeyMarker:      request.KeyMarker,
+		UploadIdMarker: request.UploadIdMarker,
+		IsTruncated:    aws.Bool(false),
+	}
+	for _, id := range ids {
+		if len(response.Uploads) >= pageSize {
+			response.IsTruncated = aws.Bool(true)
+			break
+		}
+		u := b.uploads[id]
+		response.Uploads = append(response.Uploads, &s3.MultipartUpload{
+			Key:       aws.String(u.key),
+			UploadId:  aws.String(id),
+			Initiated: aws.Time(u.initiated),
+		})
+		response.NextKeyMarker = aws.String(u.key)
+		response.NextUploadIdMarker = aws.String(id)
+	}
+	return response, nil
+}
+
+type uploadsByKey struct {
+	uploads map[string]*fakeUpload
+	ids     []string
+}
+
+func (s *uploadsByKey) Len() int {
+	return len(s.ids)
+}
+
+func (s *uploadsByKey) Less(i, j int) bool {
+	ki, kj := s.uploads[s.ids[i]].key, s.uploads[s.ids[j]].key
+	if ki != kj {
+		return ki < kj
+	}
+	return s.ids[i] < s.ids[j]
+}
+
+func (s *uploadsByKey) Swap(i, j int) {
+	s.ids[i], s.ids[j] = s.ids[j], s.ids[i]
+}
+
+func (c *fakeS3Client) ListParts(request *s3.ListPartsInput) (*s3.ListPartsOutput, error) {
+	c.s3.mutex.Lock()
+	defer c.s3.mutex.Unlock()
+
+	_, u, err := c.getUpload(request.Bucket, request.Key, request.UploadId)
+	if err != nil {
+		return nil, err
+	}
+
+	var partNumbers []int
+	for partNumber := range u.parts {
+		if partNumber > aws.Int64Value(request.PartNumberMarker) {
+			partNumbers = append(partNumbers, int(partNumber))
+		}
+	}
+	sort.Ints(partNumbers)
+
+	pageSize := PageSize
+	if request.MaxParts != nil && *request.MaxParts > 0 && int(*request.MaxParts) < pageSize {
+		pageSize = int(*request.MaxParts)
+	}
+
+	response := &s3.ListPartsOutput{
+		Bucket:           request.Bucket,
+		Key:              request.Key,
+		UploadId:         request.UploadId,
+		PartNumberMarker: request.PartNumberMarker,
+		IsTruncated:      aws.Bool(false),
+	}
+	for _, partNumber := range partNumbers {
+		if len(response.Parts) >= pageSize {
+			response.IsTruncated = aws.Bool(true)
+			break
+		}
+		part := u.parts[int64(partNumber)]
+		response.Parts = append(response.Parts, &s3.Part{
+			PartNumber: aws.Int64(int64(partNumber)),
+			ETag:       aws.String(part.etag),
+			Size:       aws.Int64(int64(len(part.data))),
+		})
+		response.NextPartNumberMarker = aws.Int64(int64(partNumber))
+	}
+	return response, nil
+}
+
+// resources describes the objects and incomplete uploads in every bucket; empty buckets are not included
+func (f *FakeS3) resources() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var resources []string
+	for name, b := range f.buckets {
+		for key := range b.objects {
+			resources = append(resources, "object s3://"+name+"/"+key)
+		}
+		for id, u := range b.uploads {
+			resources = append(resources, "upload s3://"+name+"/"+u.key+" "+id)
+		}
+	}
+	sort.Strings(resources)
+	return resources
+}
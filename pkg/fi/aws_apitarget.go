@@ -6,19 +6,44 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
-	"time"
 )
 
 type AWSAPITarget struct {
 	Cloud     *AWSCloud
 	filestore FileStore
+
+	// Journal, if set, records the resources we create, so that they can be rolled back
+	Journal *Journal
 }
 
 var _ Target = &AWSAPITarget{}
+var _ ConcurrentTarget = &AWSAPITarget{}
+
+// AWSRenderer is implemented by units that can be applied directly using the AWS API
+type AWSRenderer interface {
+	RenderAWS(t *AWSAPITarget, a, e, changes Unit) error
+}
+
+func init() {
+	RegisterRenderer(&AWSAPITarget{}, (*AWSRenderer)(nil))
+}
+
+// IsConcurrent is true, because each unit makes its own API calls
+func (t *AWSAPITarget) IsConcurrent() bool {
+	return true
+}
+
+func (t *AWSAPITarget) Render(a, e, changes Unit) error {
+	r, ok := e.(AWSRenderer)
+	if !ok {
+		return fmt.Errorf("%T does not support the AWS API target", e)
+	}
+	return r.RenderAWS(t, a, e, changes)
+}
 
-func NewAWSAPITarget(cloud*AWSCloud, filestore FileStore) *AWSAPITarget {
+func NewAWSAPITarget(cloud *AWSCloud, filestore FileStore) *AWSAPITarget {
 	return &AWSAPITarget{
-		Cloud: cloud,
+		Cloud:     cloud,
 		filestore: filestore,
 	}
 }
@@ -48,7 +73,10 @@ func (t *AWSAPITarget) AddAWSTags(id string, expected map[string]string) error {
 			})
 		}
 
-		_, err := t.Cloud.EC2.CreateTags(request)
+		err := t.Cloud.RetryOnError(fmt.Sprintf("adding tags to resource %q", id), func() error {
+			_, err := t.Cloud.EC2.CreateTags(request)
+			return err
+		})
 		if err != nil {
 			return fmt.Errorf("error adding tags to resource %q: %v", id, err)
 		}
@@ -57,6 +85,14 @@ func (t *AWSAPITarget) AddAWSTags(id string, expected map[string]string) error {
 	return nil
 }
 
+// RecordCreation adds a resource that was just created to the journal
+func (t *AWSAPITarget) RecordCreation(resourceType string, id string) error {
+	if t.Journal == nil {
+		return nil
+	}
+	return t.Journal.Record(resourceType, id)
+}
+
 func (t *AWSAPITarget) PutResource(key string, r Resource, hashAlgorithm HashAlgorithm) (string, string, error) {
 	if r == nil {
 		glog.Fatalf("Attempt to put null resource for %q", key)
@@ -64,33 +100,46 @@ func (t *AWSAPITarget) PutResource(key string, r Resource, hashAlgorithm HashAlg
 	return t.filestore.PutResource(key, r, hashAlgorithm)
 }
 
-func (t *AWSAPITarget) WaitForInstanceRunning(instanceID string) (error) {
-	attempt := 0
-	for {
-		instance, err := t.Cloud.DescribeInstance(instanceID)
+func (t *AWSAPITarget) WaitForInstanceRunning(instanceID string) error {
+	state := "?"
+	err := t.Cloud.Poll(fmt.Sprintf("waiting for instance %q to be running", instanceID), func() (bool, error) {
+		// We call DescribeInstances directly so that we see the AWS error code;
+		// EC2 may report InvalidInstanceID.NotFound for an instance we just created
+		response, err := t.Cloud.EC2.DescribeInstances(&ec2.DescribeInstancesInput{
+			InstanceIds: []*string{&instanceID},
+		})
 		if err != nil {
-			return fmt.Errorf("error while waiting for instance to be running: %v", err)
+			return false, err
 		}
 
+		var instance *ec2.Instance
+		for _, reservation := range response.Reservations {
+			for _, i := range reservation.Instances {
+				instance = i
+			}
+		}
 		if instance == nil {
-			// TODO: Wait if we _just_ created the instance?
-			return fmt.Errorf("instance not found while waiting for instance to be running")
+			// Not yet visible
+			return false, nil
 		}
 
-		state := "?"
 		if instance.State != nil {
 			state = aws.StringValue(instance.State.Name)
 		}
 		glog.V(4).Infof("state of instance %q is %q", instanceID, state)
-		if state == "running" {
-			return nil
-		}
-
-		time.Sleep(10 * time.Second)
-		attempt++
-		if attempt > 30 {
-			return fmt.Errorf("timeout waiting for instance %q to be running, state was %q", instanceID, state)
+		switch state {
+		case ec2.InstanceStateNameTerminated, ec2.InstanceStateNameShuttingDown:
+			// The instance will never be running (e.g. it failed to launch); don't wait for the timeout
+			reason := "unknown reason"
+			if instance.StateReason != nil {
+				reason = aws.StringValue(instance.StateReason.Message)
+			}
+			return false, fmt.Errorf("instance %q is %s: %s", instanceID, state, reason)
 		}
+		return state == ec2.InstanceStateNameRunning, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error while waiting for instance to be running (state was %q): %v", state, err)
 	}
+	return nil
 }
-
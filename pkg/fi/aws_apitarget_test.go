@@ -0,0 +1,61 @@
+package fi
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// instanceStateEC2 reports a single instance, in the specified state
+type instanceStateEC2 struct {
+	ec2iface.EC2API
+	state string
+	calls int
+}
+
+func (e *instanceStateEC2) DescribeInstances(request *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	e.calls++
+	instance := &ec2.Instance{
+		InstanceId: request.InstanceIds[0],
+		State:      &ec2.InstanceState{Name: aws.String(e.state)},
+	}
+	if e.state == ec2.InstanceStateNameTerminated {
+		instance.StateReason = &ec2.StateReason{Message: aws.String("Client.InstanceInitiatedShutdown")}
+	}
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{instance}}},
+	}, nil
+}
+
+func TestWaitForInstanceRunning(t *testing.T) {
+	tests := []struct {
+		state string
+		err   string
+	}{
+		{state: ec2.InstanceStateNameRunning},
+		{state: ec2.InstanceStateNameTerminated, err: "is terminated: Client.InstanceInitiatedShutdown"},
+		{state: ec2.InstanceStateNameShuttingDown, err: "is shutting-down: unknown reason"},
+	}
+	for _, test := range tests {
+		fake := &instanceStateEC2{state: test.state}
+		// The timeout is long, so the test would hang if we waited on an instance that will never be running
+		cloud := &AWSCloud{EC2: fake, Retry: NewAWSRetryPolicy(time.Hour)}
+		target := &AWSAPITarget{Cloud: cloud}
+
+		err := target.WaitForInstanceRunning("i-1234")
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.state, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, got %v", test.state, test.err, err)
+		}
+		if fake.calls != 1 {
+			t.Errorf("%s: expected one call to DescribeInstances, got %d", test.state, fake.calls)
+		}
+	}
+}
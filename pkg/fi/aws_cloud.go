@@ -1,27 +1,44 @@
 package fi
 
 import (
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
 	"github.com/aws/aws-sdk-go/service/iam"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"fmt"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/golang/glog"
-	"github.com/aws/aws-sdk-go/service/elb"
 )
 
+// AWSCloud holds the clients for the AWS services.  The services are interfaces so that they can be replaced,
+// for example by the in-memory implementations in the fakeaws package.
 type AWSCloud struct {
-	EC2         *ec2.EC2
-	S3          *S3Helper
-	IAM         *iam.IAM
-	ELB         *elb.ELB
-	Autoscaling *autoscaling.AutoScaling
+	EC2            ec2iface.EC2API
+	S3             *S3Helper
+	IAM            iamiface.IAMAPI
+	ELB            elbiface.ELBAPI
+	Autoscaling    autoscalingiface.AutoScalingAPI
+	CloudFormation cloudformationiface.CloudFormationAPI
 
-	Region      string
+	// Credentials are the credentials used to call AWS
+	Credentials *credentials.Credentials
 
-	tags        map[string]string
+	Region string
+
+	// Retry is the policy for retrying calls that fail with transient errors
+	Retry *AWSRetryPolicy
+
+	tags map[string]string
 }
 
 var _ Cloud = &AWSCloud{}
@@ -43,20 +60,30 @@ func (c *AWSCloud) ProviderID() ProviderID {
 }
 
 func NewAWSCloud(region string, tags map[string]string) *AWSCloud {
+	return NewAWSCloudWithConfig(region, tags, aws.NewConfig())
+}
+
+// NewAWSCloudWithConfig builds an AWSCloud whose clients all use baseConfig, e.g. to record the API calls
+func NewAWSCloudWithConfig(region string, tags map[string]string, baseConfig *aws.Config) *AWSCloud {
 	c := &AWSCloud{Region: region}
+	c.Retry = NewAWSRetryPolicy(DefaultAWSRetryTimeout)
 
-	config := aws.NewConfig().WithRegion(region)
-	c.EC2 = ec2.New(session.New(), config)
+	config := baseConfig.Copy().WithRegion(region)
+	config = request.WithRetryer(config, &awsRetryer{policy: c.Retry})
+	sess := session.New(config)
+	c.Credentials = sess.Config.Credentials
+	c.EC2 = ec2.New(sess, config)
 	c.S3 = NewS3Helper(config)
-	c.IAM = iam.New(session.New(), config)
-	c.ELB = elb.New(session.New(), config)
-	c.Autoscaling = autoscaling.New(session.New(), config)
+	c.IAM = iam.New(sess, config)
+	c.ELB = elb.New(sess, config)
+	c.Autoscaling = autoscaling.New(sess, config)
+	c.CloudFormation = cloudformation.New(sess, config)
 
 	c.tags = tags
 	return c
 }
 
-func (c*AWSCloud) GetS3(region string) *s3.S3 {
+func (c *AWSCloud) GetS3(region string) s3iface.S3API {
 	return c.S3.GetS3(region)
 }
 
@@ -90,23 +117,24 @@ func (c *AWSCloud) GetTags(resourceId string) (map[string]string, error) {
 		},
 	}
 
-	response, err := c.EC2.DescribeTags(request)
+	err := c.EC2.DescribeTagsPages(request, func(p *ec2.DescribeTagsOutput, lastPage bool) bool {
+		for _, tag := range p.Tags {
+			if tag == nil {
+				glog.Warning("unexpected nil tag")
+				continue
+			}
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		return true
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error listing tags on %v: %v", resourceId, err)
 	}
 
-	for _, tag := range response.Tags {
-		if tag == nil {
-			glog.Warning("unexpected nil tag")
-			continue
-		}
-		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
-	}
-
 	return tags, nil
 }
 
-func (c *AWSCloud) CreateTags(resourceId string, tags map[string]string) (error) {
+func (c *AWSCloud) CreateTags(resourceId string, tags map[string]string) error {
 	if len(tags) == 0 {
 		return nil
 	}
@@ -116,7 +144,7 @@ func (c *AWSCloud) CreateTags(resourceId string, tags map[string]string) (error)
 		ec2Tags = append(ec2Tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
 	}
 	request := &ec2.CreateTagsInput{
-		Tags: ec2Tags,
+		Tags:      ec2Tags,
 		Resources: []*string{&resourceId},
 	}
 
@@ -155,7 +183,7 @@ func (c *AWSCloud) BuildFilters(name *string) []*ec2.Filter {
 	}
 
 	for k, v := range merged {
-		filter := NewEC2Filter("tag:" + k, v)
+		filter := NewEC2Filter("tag:"+k, v)
 		filters = append(filters, filter)
 	}
 	return filters
@@ -218,6 +246,3 @@ func (t *AWSCloud) DescribeVPC(vpcID string) (*ec2.Vpc, error) {
 	vpc := response.Vpcs[0]
 	return vpc, nil
 }
-
-
-
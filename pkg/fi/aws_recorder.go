@@ -0,0 +1,574 @@
+package fi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/golang/glog"
+)
+
+const (
+	AWSRecorderModeRecord = "record"
+	AWSRecorderModeReplay = "replay"
+)
+
+// AWSInteraction is a single AWS API call, as stored in a golden file
+type AWSInteraction struct {
+	Service   string               `json:"service"`
+	Operation string               `json:"operation"`
+	Request   *AWSRecordedRequest  `json:"request"`
+	Response  *AWSRecordedResponse `json:"response"`
+}
+
+// AWSRecordedRequest is the normalized form of a request: values that change from run to run (signatures, dates,
+// timestamps in names) are removed, so that requests from different runs can be compared
+type AWSRecordedRequest struct {
+	Method     string            `json:"method"`
+	Host       string            `json:"host"`
+	Path       string            `json:"path"`
+	Params     []string          `json:"params,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	BodySHA256 string            `json:"bodySHA256,omitempty"`
+}
+
+type AWSRecordedResponse struct {
+	Status     int               `json:"status"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	BodyBase64 string            `json:"bodyBase64,omitempty"`
+}
+
+type awsGolden struct {
+	Interactions []*AWSInteraction `json:"interactions"`
+}
+
+// AWSRecorder is an http.RoundTripper for the AWS clients, which either records every API call to a golden file,
+// or replays the calls from a golden file without network access.  On replay a call that does not match an
+// unused recorded call is an error, as is a recorded call that is never made (see Verify).
+type AWSRecorder struct {
+	mode      string
+	path      string
+	transport http.RoundTripper
+
+	mutex  sync.Mutex
+	golden awsGolden
+	used   []bool
+	errors []string
+	// secrets maps the hashes of secret values to the values, so they can be restored in replayed responses
+	secrets map[string]string
+}
+
+var _ http.RoundTripper = &AWSRecorder{}
+
+// NewAWSRecorder builds an AWSRecorder that makes real calls, and records them to path
+func NewAWSRecorder(path string) *AWSRecorder {
+	return NewAWSRecorderWithTransport(path, http.DefaultTransport)
+}
+
+// NewAWSRecorderWithTransport builds an AWSRecorder that records the calls made through transport, rather than to AWS
+func NewAWSRecorderWithTransport(path string, transport http.RoundTripper) *AWSRecorder {
+	return &AWSRecorder{
+		mode:      AWSRecorderModeRecord,
+		path:      path,
+		transport: transport,
+	}
+}
+
+// NewAWSReplayer builds an AWSRecorder that replays the calls recorded in path
+func NewAWSReplayer(path string) (*AWSRecorder, error) {
+	r := &AWSRecorder{
+		mode:    AWSRecorderModeReplay,
+		path:    path,
+		secrets: make(map[string]string),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading AWS golden file %q: %v", path, err)
+	}
+	err = json.Unmarshal(data, &r.golden)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing AWS golden file %q: %v", path, err)
+	}
+	r.used = make([]bool, len(r.golden.Interactions))
+	return r, nil
+}
+
+// Config returns the aws.Config that routes the calls through the recorder
+func (r *AWSRecorder) Config() *aws.Config {
+	config := aws.NewConfig().WithHTTPClient(&http.Client{Transport: r})
+	if r.mode == AWSRecorderModeReplay {
+		// Requests are still signed, but the credentials are never checked
+		config = config.WithCredentials(credentials.NewStaticCredentials("REPLAYACCESSKEYID", "replaysecretaccesskey", ""))
+	}
+	return config
+}
+
+func (r *AWSRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading request body: %v", err)
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	secrets := make(map[string]string)
+	recorded, err := normalizeAWSRequest(req, body, secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.mode == AWSRecorderModeReplay {
+		return r.replay(req, recorded, secrets)
+	}
+	return r.record(req, recorded)
+}
+
+func (r *AWSRecorder) record(req *http.Request, recorded *AWSRecordedRequest) (*http.Response, error) {
+	response, err := r.transport.RoundTrip(req)
+	if err != nil {
+		// We only record calls that reached AWS
+		return nil, err
+	}
+
+	responseBody, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+
+	interaction := &AWSInteraction{
+		Service:   awsServiceForHost(recorded.Host),
+		Operation: awsOperation(recorded),
+		Request:   recorded,
+		Response: &AWSRecordedResponse{
+			Status:  response.StatusCode,
+			Headers: recordedResponseHeaders(response.Header),
+		},
+	}
+	if utf8.Valid(responseBody) {
+		interaction.Response.Body = redactAWSResponseBody(string(responseBody))
+	} else {
+		interaction.Response.BodyBase64 = base64.StdEncoding.EncodeToString(responseBody)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	glog.V(2).Infof("Recording AWS call %s %s", interaction.Service, interaction.Operation)
+	r.golden.Interactions = append(r.golden.Interactions, interaction)
+	err = r.write()
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (r *AWSRecorder) replay(req *http.Request, recorded *AWSRecordedRequest, secrets map[string]string) (*http.Response, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for hash, value := range secrets {
+		r.secrets[hash] = value
+	}
+
+	var match *AWSInteraction
+	for i, interaction := range r.golden.Interactions {
+		if r.used[i] {
+			continue
+		}
+		if reflect.DeepEqual(interaction.Request, recorded) {
+			r.used[i] = true
+			match = interaction
+			break
+		}
+	}
+
+	if match == nil {
+		message := fmt.Sprintf("unexpected AWS call %s %s", awsServiceForHost(recorded.Host), awsOperation(recorded))
+		// If there is a call to the same operation, describe how the call differs
+		for i, interaction := range r.golden.Interactions {
+			if r.used[i] || interaction.Operation != awsOperation(recorded) || interaction.Request.Host != recorded.Host {
+				continue
+			}
+			message += ": " + describeAWSRequestDifferences(interaction.Request, recorded)
+			break
+		}
+		r.errors = append(r.errors, message)
+		return nil, &awsReplayError{message: message}
+	}
+
+	glog.V(2).Infof("Replaying AWS call %s %s", match.Service, match.Operation)
+
+	var body []byte
+	if match.Response.BodyBase64 != "" {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(match.Response.BodyBase64)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding recorded response for %s: %v", match.Operation, err)
+		}
+	} else {
+		body = []byte(r.restoreAWSResponseBody(match.Response.Body))
+	}
+
+	header := make(http.Header)
+	for k, v := range match.Response.Headers {
+		header.Set(k, v)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", match.Response.Status, http.StatusText(match.Response.Status)),
+		StatusCode:    match.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// awsReplayError is returned for a call that was not recorded.  It is not Temporary, so the SDK does not retry the call.
+type awsReplayError struct {
+	message string
+}
+
+func (e *awsReplayError) Error() string {
+	return e.message
+}
+
+func (e *awsReplayError) Temporary() bool {
+	return false
+}
+
+// Verify checks that a replay made exactly the calls that were recorded
+func (r *AWSRecorder) Verify() error {
+	if r.mode != AWSRecorderModeReplay {
+		return nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	problems := r.errors
+	for i, interaction := range r.golden.Interactions {
+		if !r.used[i] {
+			problems = append(problems, fmt.Sprintf("recorded AWS call was not made: %s %s", interaction.Service, interaction.Operation))
+		}
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("AWS calls did not match %s:\n  %s", r.path, strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func (r *AWSRecorder) write() error {
+	// The responses are mostly XML, which is much easier to read in the golden file if it isn't escaped
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(&r.golden)
+	if err != nil {
+		return fmt.Errorf("error serializing AWS recording: %v", err)
+	}
+	data := b.Bytes()
+
+	// Write & rename, so we never leave a partial file
+	tmp := r.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing AWS recording %q: %v", tmp, err)
+	}
+	err = os.Rename(tmp, r.path)
+	if err != nil {
+		return fmt.Errorf("error writing AWS recording %q: %v", r.path, err)
+	}
+	return nil
+}
+
+// Names of launch configurations (and the like) include the time at which they were created
+var awsTimestampRegex = regexp.MustCompile(`[0-9]{8}T[0-9]{6}Z`)
+
+func scrubAWSValue(s string) string {
+	return awsTimestampRegex.ReplaceAllString(s, "<timestamp>")
+}
+
+// Idempotency tokens are unique to each call
+var awsRunVaryingParams = map[string]bool{
+	"ClientToken": true,
+}
+
+// Parameters & headers that hold secrets (the user-data holds the cluster's private keys and tokens); they are
+// recorded as a hash, so that a golden file can be committed
+var awsSecretParams = map[string]bool{
+	"UserData":   true,
+	"PrivateKey": true,
+	"Password":   true,
+	"X-Amz-Server-Side-Encryption-Customer-Key": true,
+}
+
+func isAWSSecretParam(k string) bool {
+	for _, token := range strings.Split(k, ".") {
+		if awsSecretParams[token] {
+			return true
+		}
+	}
+	return false
+}
+
+// hashAWSSecret returns the form in which a secret is recorded, and remembers the secret under that form in secrets
+func hashAWSSecret(v string, secrets map[string]string) string {
+	hash := sha256.Sum256([]byte(v))
+	hashed := "redacted:sha256:" + hex.EncodeToString(hash[:])
+	if secrets != nil {
+		secrets[hashed] = v
+	}
+	return hashed
+}
+
+// The user-data in responses: autoscaling DescribeLaunchConfigurations & EC2 DescribeInstanceAttribute
+var awsResponseSecretRegexes = []*regexp.Regexp{
+	regexp.MustCompile(`(<UserData>)([^<]+)(</UserData>)`),
+	regexp.MustCompile(`(<userData>\s*<value>)([^<]+)(</value>)`),
+}
+
+// redactAWSResponseBody replaces the secrets in a response with their hashes
+func redactAWSResponseBody(body string) string {
+	for _, re := range awsResponseSecretRegexes {
+		body = re.ReplaceAllStringFunc(body, func(match string) string {
+			groups := re.FindStringSubmatch(match)
+			return groups[1] + hashAWSSecret(groups[2], nil) + groups[3]
+		})
+	}
+	return body
+}
+
+// restoreAWSResponseBody replaces the hashes in a recorded response with the secrets they were computed from, if
+// the secret was sent in an earlier request in this replay.  Otherwise the hash is left, which kope will see as a change.
+func (r *AWSRecorder) restoreAWSResponseBody(body string) string {
+	for _, re := range awsResponseSecretRegexes {
+		body = re.ReplaceAllStringFunc(body, func(match string) string {
+			groups := re.FindStringSubmatch(match)
+			value, found := r.secrets[groups[2]]
+			if !found {
+				return match
+			}
+			return groups[1] + value + groups[3]
+		})
+	}
+	return body
+}
+
+// Request headers that vary between runs, or that are set by the SDK itself
+var awsIgnoredRequestHeaders = map[string]bool{
+	"Authorization":        true,
+	"User-Agent":           true,
+	"X-Amz-Date":           true,
+	"X-Amz-Content-Sha256": true,
+	"X-Amz-Security-Token": true,
+	"Content-Md5":          true,
+}
+
+// normalizeAWSRequest builds the recorded form of a request; the secrets that are replaced by their hashes are added to secrets
+func normalizeAWSRequest(req *http.Request, body []byte, secrets map[string]string) (*AWSRecordedRequest, error) {
+	recorded := &AWSRecordedRequest{
+		Method: req.Method,
+		Host:   req.URL.Host,
+		Path:   scrubAWSValue(req.URL.Path),
+	}
+
+	params := req.URL.Query()
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		// EC2, IAM, ELB & Autoscaling send their parameters in the body
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing request body: %v", err)
+		}
+		for k, v := range form {
+			params[k] = append(params[k], v...)
+		}
+	} else if len(body) != 0 {
+		hash := sha256.Sum256(body)
+		recorded.BodySHA256 = hex.EncodeToString(hash[:])
+	}
+	for k, values := range params {
+		for i, v := range values {
+			if isAWSSecretParam(k) {
+				values[i] = hashAWSSecret(v, secrets)
+			} else if awsRunVaryingParams[k] {
+				values[i] = "<" + k + ">"
+			}
+		}
+	}
+	recorded.Params = normalizeAWSParams(params)
+
+	for k, v := range req.Header {
+		if !strings.HasPrefix(k, "X-Amz-") || awsIgnoredRequestHeaders[k] {
+			continue
+		}
+		if recorded.Headers == nil {
+			recorded.Headers = make(map[string]string)
+		}
+		if isAWSSecretParam(k) {
+			recorded.Headers[k] = hashAWSSecret(strings.Join(v, ","), secrets)
+		} else {
+			recorded.Headers[k] = scrubAWSValue(strings.Join(v, ","))
+		}
+	}
+
+	return recorded, nil
+}
+
+// normalizeAWSParams returns the parameters as sorted key=value strings.  The members of list parameters
+// (e.g. Filter.1.Name, Filter.1.Value.1) are also sorted and renumbered, because many of the lists that kope
+// sends are built from maps, and so are in a different order on every run.
+func normalizeAWSParams(params url.Values) []string {
+	var normalized []string
+
+	// prefix -> member index -> "rest=value"
+	lists := make(map[string]map[string][]string)
+	for k, values := range params {
+		for _, v := range values {
+			v = scrubAWSValue(v)
+
+			tokens := strings.Split(k, ".")
+			indexPos := -1
+			for i, token := range tokens {
+				if _, err := strconv.Atoi(token); err == nil {
+					indexPos = i
+					break
+				}
+			}
+			if indexPos == -1 {
+				normalized = append(normalized, k+"="+v)
+				continue
+			}
+
+			prefix := strings.Join(tokens[:indexPos], ".")
+			rest := strings.Join(tokens[indexPos+1:], ".")
+			if lists[prefix] == nil {
+				lists[prefix] = make(map[string][]string)
+			}
+			lists[prefix][tokens[indexPos]] = append(lists[prefix][tokens[indexPos]], rest+"="+v)
+		}
+	}
+
+	for prefix, members := range lists {
+		var serialized []string
+		for _, fields := range members {
+			sort.Strings(fields)
+			serialized = append(serialized, strings.Join(fields, "\x00"))
+		}
+		sort.Strings(serialized)
+
+		for i, member := range serialized {
+			for _, field := range strings.Split(member, "\x00") {
+				key := prefix + "." + strconv.Itoa(i+1)
+				if !strings.HasPrefix(field, "=") {
+					key += "."
+				}
+				normalized = append(normalized, key+field)
+			}
+		}
+	}
+
+	sort.Strings(normalized)
+	return normalized
+}
+
+// Response headers that vary between runs
+var awsIgnoredResponseHeaders = map[string]bool{
+	"Date":             true,
+	"Server":           true,
+	"Connection":       true,
+	"Content-Length":   true,
+	"X-Amz-Request-Id": true,
+	"X-Amz-Id-2":       true,
+	"X-Amzn-Requestid": true,
+}
+
+func recordedResponseHeaders(header http.Header) map[string]string {
+	recorded := make(map[string]string)
+	for k, v := range header {
+		if awsIgnoredResponseHeaders[k] {
+			continue
+		}
+		recorded[k] = strings.Join(v, ",")
+	}
+	return recorded
+}
+
+func awsServiceForHost(host string) string {
+	for _, token := range strings.Split(host, ".") {
+		if token == "s3" || strings.HasPrefix(token, "s3-") {
+			return "s3"
+		}
+	}
+	return strings.Split(host, ".")[0]
+}
+
+// awsOperation returns the name of the call: the Action for query APIs, otherwise the method & path (for S3)
+func awsOperation(recorded *AWSRecordedRequest) string {
+	for _, param := range recorded.Params {
+		if strings.HasPrefix(param, "Action=") {
+			return strings.TrimPrefix(param, "Action=")
+		}
+	}
+	return recorded.Method + " " + recorded.Path
+}
+
+func describeAWSRequestDifferences(expected, actual *AWSRecordedRequest) string {
+	var differences []string
+
+	expectedParams := make(map[string]bool)
+	for _, p := range expected.Params {
+		expectedParams[p] = true
+	}
+	actualParams := make(map[string]bool)
+	for _, p := range actual.Params {
+		actualParams[p] = true
+	}
+	for _, p := range expected.Params {
+		if !actualParams[p] {
+			differences = append(differences, "-"+p)
+		}
+	}
+	for _, p := range actual.Params {
+		if !expectedParams[p] {
+			differences = append(differences, "+"+p)
+		}
+	}
+	if !reflect.DeepEqual(expected.Headers, actual.Headers) {
+		differences = append(differences, fmt.Sprintf("headers %v -> %v", expected.Headers, actual.Headers))
+	}
+	if expected.BodySHA256 != actual.BodySHA256 {
+		differences = append(differences, fmt.Sprintf("body sha256 %s -> %s", expected.BodySHA256, actual.BodySHA256))
+	}
+	if len(differences) == 0 {
+		return "no differences in parameters"
+	}
+	return strings.Join(differences, " ")
+}
@@ -0,0 +1,206 @@
+package fi
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/golang/glog"
+)
+
+// DefaultAWSRetryTimeout is how long we will keep retrying an AWS call (or polling) before giving up
+const DefaultAWSRetryTimeout = 20 * time.Minute
+
+// AWSRetryPolicy controls how we retry AWS calls that fail with transient errors, and how we poll for AWS
+// resources to reach a state.  Delays grow exponentially from InitialDelay up to MaxDelay.  Each call (or poll)
+// is retried until Timeout after it started, so a cloud can be used for any length of time.
+type AWSRetryPolicy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Timeout      time.Duration
+}
+
+func NewAWSRetryPolicy(timeout time.Duration) *AWSRetryPolicy {
+	return &AWSRetryPolicy{
+		InitialDelay: 1 * time.Second,
+		MaxDelay:     30 * time.Second,
+		Timeout:      timeout,
+	}
+}
+
+// transientAWSErrorCodes are the errors that any call may fail with, and that are worth retrying: throttling and
+// transient server errors.  These are retried by the SDK, for every call, using awsRetryer.
+var transientAWSErrorCodes = map[string]bool{
+	// Throttling
+	"RequestLimitExceeded":     true,
+	"Throttling":               true,
+	"ThrottlingException":      true,
+	"RequestThrottled":         true,
+	"TooManyRequestsException": true,
+	"SlowDown":                 true,
+
+	// Server errors
+	"InternalError":      true,
+	"InternalFailure":    true,
+	"ServiceUnavailable": true,
+	"Unavailable":        true,
+}
+
+// eventualConsistencyAWSErrorCodes are the "not found" errors that AWS returns until a resource we just created
+// becomes visible.  Most calls should not retry them (the resource may really not exist), so they are only
+// retried where we wrap the call in RetryOnError.
+var eventualConsistencyAWSErrorCodes = map[string]bool{
+	// EC2 eventual consistency
+	"InvalidInstanceID.NotFound":        true,
+	"InvalidVpcID.NotFound":             true,
+	"InvalidSubnetID.NotFound":          true,
+	"InvalidGroup.NotFound":             true,
+	"InvalidInternetGatewayID.NotFound": true,
+	"InvalidRouteTableID.NotFound":      true,
+	"InvalidDhcpOptionID.NotFound":      true,
+	"InvalidAllocationID.NotFound":      true,
+	"InvalidVolume.NotFound":            true,
+
+	// IAM propagation: a role or instance profile we just created may not be visible yet
+	"NoSuchEntity": true,
+}
+
+// isTransientAWSError returns true if err is a throttling or server error, which may succeed if the call is retried
+func isTransientAWSError(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && transientAWSErrorCodes[awsErr.Code()]
+}
+
+// IsEventualConsistencyAWSError returns true if err is an AWS error which may succeed when a resource that
+// we just created becomes visible
+func IsEventualConsistencyAWSError(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	if eventualConsistencyAWSErrorCodes[awsErr.Code()] {
+		return true
+	}
+	// EC2 reports an instance profile that has not yet propagated from IAM as an invalid parameter
+	if awsErr.Code() == "InvalidParameterValue" && strings.Contains(awsErr.Message(), "Invalid IAM Instance Profile") {
+		return true
+	}
+	return false
+}
+
+// delayForAttempt is the delay before the retry-th retry (counting from 0)
+func (p *AWSRetryPolicy) delayForAttempt(retry int) time.Duration {
+	delay := p.InitialDelay
+	for i := 0; i < retry && delay < p.MaxDelay; i++ {
+		delay = p.nextDelay(delay)
+	}
+	return delay
+}
+
+func (p *AWSRetryPolicy) nextDelay(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// sleep waits for delay, returning false if that would take us past the deadline
+func (p *AWSRetryPolicy) sleep(delay time.Duration, deadline time.Time) bool {
+	if time.Now().Add(delay).After(deadline) {
+		return false
+	}
+	time.Sleep(delay)
+	return true
+}
+
+// awsRetryer is the SDK Retryer for all our AWS clients, so that every call retries throttling and transient
+// errors with the policy's backoff, until the policy's timeout after the call was made
+type awsRetryer struct {
+	policy *AWSRetryPolicy
+}
+
+var _ request.Retryer = &awsRetryer{}
+
+// The deadline is what stops us retrying; this is only a backstop
+const maxAWSRetries = 100
+
+func (r *awsRetryer) MaxRetries() int {
+	return maxAWSRetries
+}
+
+func (r *awsRetryer) RetryRules(req *request.Request) time.Duration {
+	return r.policy.delayForAttempt(req.RetryCount)
+}
+
+func (r *awsRetryer) ShouldRetry(req *request.Request) bool {
+	retry := false
+	if req.Retryable != nil {
+		retry = *req.Retryable
+	} else {
+		retry = req.IsErrorRetryable() || req.IsErrorThrottle() || isTransientAWSError(req.Error)
+	}
+	if !retry {
+		return false
+	}
+	// req.Time is when the call was made (it is not reset by retries)
+	start := req.Time
+	if start.IsZero() {
+		start = time.Now()
+	}
+	deadline := start.Add(r.policy.Timeout)
+	if time.Now().Add(r.RetryRules(req)).After(deadline) {
+		return false
+	}
+	glog.V(2).Infof("retryable error calling %s.%s, will retry: %v", req.ClientInfo.ServiceName, req.Operation.Name, req.Error)
+	return true
+}
+
+// RetryOnError calls fn, retrying with backoff for as long as it fails with an eventual-consistency error.
+// Throttling and transient errors are already retried by the SDK.
+func (c *AWSCloud) RetryOnError(description string, fn func() error) error {
+	p := c.Retry
+	deadline := time.Now().Add(p.Timeout)
+	delay := p.InitialDelay
+	for {
+		err := fn()
+		if err == nil || !IsEventualConsistencyAWSError(err) {
+			return err
+		}
+
+		glog.V(2).Infof("retryable error %s, will retry in %v: %v", description, delay, err)
+		if !p.sleep(delay, deadline) {
+			return fmt.Errorf("timeout %s: %v", description, err)
+		}
+		delay = p.nextDelay(delay)
+	}
+}
+
+// Poll calls fn with backoff until it reports done, or returns a non-retryable error
+func (c *AWSCloud) Poll(description string, fn func() (bool, error)) error {
+	p := c.Retry
+	deadline := time.Now().Add(p.Timeout)
+	delay := p.InitialDelay
+	for {
+		done, err := fn()
+		if err != nil && !IsEventualConsistencyAWSError(err) {
+			return err
+		}
+		if err == nil && done {
+			return nil
+		}
+
+		if err != nil {
+			glog.V(2).Infof("retryable error %s, will retry in %v: %v", description, delay, err)
+		}
+		if !p.sleep(delay, deadline) {
+			if err != nil {
+				return fmt.Errorf("timeout %s: %v", description, err)
+			}
+			return fmt.Errorf("timeout %s", description)
+		}
+		delay = p.nextDelay(delay)
+	}
+}
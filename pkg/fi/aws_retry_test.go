@@ -0,0 +1,35 @@
+package fi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+func TestAWSRetryerDeadlineIsPerRequest(t *testing.T) {
+	policy := NewAWSRetryPolicy(time.Minute)
+	retryer := &awsRetryer{policy: policy}
+
+	tests := []struct {
+		name    string
+		started time.Duration
+		retry   bool
+	}{
+		{name: "new request", started: 0, retry: true},
+		{name: "request made after the policy was created", started: -30 * time.Second, retry: true},
+		{name: "request that has been retried past the timeout", started: -2 * time.Minute, retry: false},
+	}
+	for _, test := range tests {
+		req := &request.Request{
+			Operation: &request.Operation{Name: "DescribeInstances"},
+			Time:      time.Now().Add(test.started),
+			Error:     awserr.New("RequestLimitExceeded", "throttled", nil),
+		}
+		retry := retryer.ShouldRetry(req)
+		if retry != test.retry {
+			t.Errorf("%s: expected ShouldRetry=%v, got %v", test.name, test.retry, retry)
+		}
+	}
+}
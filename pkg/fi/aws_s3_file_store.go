@@ -1,23 +1,64 @@
 package fi
 
 import (
+	"fmt"
 	"github.com/golang/glog"
+	"sync"
+	"time"
 )
 
+// s3HashMetadataPrefix is the prefix of the object metadata key in which we store the hash, e.g. kope-hash-sha1
+const s3HashMetadataPrefix = "kope-hash-"
+
+// MaxPresignExpiry is the longest expiry that S3 accepts for a presigned URL
+const MaxPresignExpiry = 7 * 24 * time.Hour
+
 type S3FileStore struct {
 	bucket *S3Bucket
 	prefix string
+
+	// Private, if set, keeps the objects private and returns presigned URLs, instead of making the objects public.
+	// Instances that boot after the URLs expire (e.g. autoscaling replacements) will not be able to download
+	// the artifacts, so kope must be re-run within PresignExpiry / 2 to refresh them.
+	Private       bool
+	PresignExpiry time.Duration
+
+	// UploadConcurrency is the number of parts of a large object that are uploaded at once
+	UploadConcurrency int
+
+	// Journal, if set, records the objects that we upload (but not those that were already present), so that
+	// they are deleted if the run is rolled back
+	Journal *Journal
+
+	// keyLocks ensures that concurrent puts of the same object are not uploaded twice
+	mutex    sync.Mutex
+	keyLocks map[string]*sync.Mutex
 }
 
 func NewS3FileStore(bucket *S3Bucket, prefix string) *S3FileStore {
 	return &S3FileStore{
-		bucket: bucket,
-		prefix: prefix,
+		bucket:            bucket,
+		prefix:            prefix,
+		keyLocks:          make(map[string]*sync.Mutex),
+		UploadConcurrency: DefaultS3UploadConcurrency,
 	}
 }
 
-func (s*S3FileStore) PutResource(key string, r Resource, hashAlgorithm HashAlgorithm) (string, string, error) {
-	hashes, err := HashesForResource(r, []HashAlgorithm{HashAlgorithmMD5, hashAlgorithm })
+func (s *S3FileStore) lockKey(s3key string) *sync.Mutex {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	l := s.keyLocks[s3key]
+	if l == nil {
+		l = &sync.Mutex{}
+		s.keyLocks[s3key] = l
+	}
+	l.Lock()
+	return l
+}
+
+func (s *S3FileStore) PutResource(key string, r Resource, hashAlgorithm HashAlgorithm) (string, string, error) {
+	hashes, err := HashesForResource(r, []HashAlgorithm{HashAlgorithmMD5, hashAlgorithm})
 	if err != nil {
 		return "", "", err
 	}
@@ -26,6 +67,10 @@ func (s*S3FileStore) PutResource(key string, r Resource, hashAlgorithm HashAlgor
 	userHash := hashes[hashAlgorithm]
 
 	s3key := s.prefix + key + "-" + userHash
+
+	l := s.lockKey(s3key)
+	defer l.Unlock()
+
 	o, err := s.bucket.FindObjectIfExists(s3key)
 	if err != nil {
 		return "", "", err
@@ -33,29 +78,40 @@ func (s*S3FileStore) PutResource(key string, r Resource, hashAlgorithm HashAlgor
 
 	alreadyPresent := false
 
-	s3hash := ""
+	// The ETag of a multipart upload is not the MD5, so we record our own hash in the metadata
+	metadataKey := s3HashMetadataPrefix + string(hashAlgorithm)
 	if o != nil {
-		s3hash, err = o.Etag()
-		if err != nil {
-			return "", "", err
-		}
-		if s3hash == md5 {
-			alreadyPresent = true
+		if storedHash := o.Metadata(metadataKey); storedHash != "" {
+			if storedHash == userHash {
+				alreadyPresent = true
+			} else {
+				glog.Infof("Found file, but did not match: %q (%s vs %s)", o, storedHash, userHash)
+			}
 		} else {
-			glog.Infof("Found file, but did not match: %q (%s vs %s)", o, s3hash, md5)
+			// Uploaded before we recorded the hash; these were never multipart uploads
+			s3hash, err := o.Etag()
+			if err != nil {
+				return "", "", err
+			}
+			if s3hash == md5 {
+				alreadyPresent = true
+			} else {
+				glog.Infof("Found file, but did not match: %q (%s vs %s)", o, s3hash, md5)
+			}
 		}
 	}
 
 	if !alreadyPresent {
-		body, err := r.Open()
+		metadata := map[string]string{metadataKey: userHash}
+		o, err = s.bucket.PutResource(s3key, r, metadata, s.UploadConcurrency)
 		if err != nil {
 			return "", "", err
 		}
-		defer SafeClose(body)
-
-		o, err = s.bucket.PutObject(s3key, body)
-		if err != nil {
-			return "", "", err
+		if s.Journal != nil {
+			err = s.Journal.Record(JournalTypeS3Object, s.bucket.Name+"/"+s3key)
+			if err != nil {
+				return "", "", err
+			}
 		}
 	}
 
@@ -63,12 +119,55 @@ func (s*S3FileStore) PutResource(key string, r Resource, hashAlgorithm HashAlgor
 	if err != nil {
 		return "", "", err
 	}
-	if !isPublic {
+
+	if s.Private && isPublic {
+		err = o.SetPrivateACL()
+		if err != nil {
+			return "", "", err
+		}
+	}
+	if !s.Private && !isPublic {
 		err = o.SetPublicACL()
 		if err != nil {
 			return "", "", err
 		}
 	}
 
-	return o.PublicURL(), userHash, nil
-}
\ No newline at end of file
+	url, err := s.objectURL(o)
+	if err != nil {
+		return "", "", err
+	}
+	return url, userHash, nil
+}
+
+func (s *S3FileStore) ResourceURL(key string, r Resource, hashAlgorithm HashAlgorithm) (string, string, error) {
+	userHash, err := HashForResource(r, hashAlgorithm)
+	if err != nil {
+		return "", "", err
+	}
+
+	o := &S3Object{
+		Bucket: s.bucket,
+		Key:    s.prefix + key + "-" + userHash,
+	}
+	url, err := s.objectURL(o)
+	if err != nil {
+		return "", "", err
+	}
+	return url, userHash, nil
+}
+
+// objectURL returns the URL from which the instances download the object: presigned if the store is private
+func (s *S3FileStore) objectURL(o *S3Object) (string, error) {
+	if !s.Private {
+		return o.PublicURL(), nil
+	}
+
+	if s.PresignExpiry <= 0 || s.PresignExpiry > MaxPresignExpiry {
+		return "", fmt.Errorf("presigned URL expiry must be between 0 and %v, was %v", MaxPresignExpiry, s.PresignExpiry)
+	}
+	// We round the signing time so that the URL (and so the instance user-data) is the same across runs;
+	// the URL is still valid for at least half the expiry
+	signTime := time.Now().Truncate(s.PresignExpiry / 2)
+	return o.PresignedURL(s.PresignExpiry, signTime)
+}
@@ -1,13 +1,18 @@
 package fi
 
 import (
-	"github.com/aws/aws-sdk-go/service/s3"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"fmt"
-	"github.com/golang/glog"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/golang/glog"
 	"io"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -15,32 +20,49 @@ const (
 )
 
 type S3Helper struct {
-	config    *aws.Config
-	defaultS3 *s3.S3
-	regions   map[string]*s3.S3
+	defaultS3 s3iface.S3API
+
+	// newClient builds the client for a region
+	newClient func(region string) s3iface.S3API
+
+	mutex   sync.Mutex
+	regions map[string]s3iface.S3API
 }
 
 func NewS3Helper(defaultConfig *aws.Config) *S3Helper {
+	config := defaultConfig.Copy()
+	newClient := func(region string) s3iface.S3API {
+		regionConfig := config.Copy().WithRegion(region)
+		return s3.New(session.New(regionConfig), regionConfig)
+	}
+	s := NewS3HelperWithClients(s3.New(session.New(defaultConfig), defaultConfig), newClient)
+	return s
+}
+
+// NewS3HelperWithClients builds an S3Helper that uses the supplied clients, rather than the real S3 service
+func NewS3HelperWithClients(defaultS3 s3iface.S3API, newClient func(region string) s3iface.S3API) *S3Helper {
 	s := &S3Helper{
-		config: defaultConfig.Copy(),
-		defaultS3: s3.New(session.New(), defaultConfig),
-		regions: make(map[string]*s3.S3),
+		defaultS3: defaultS3,
+		newClient: newClient,
+		regions:   make(map[string]s3iface.S3API),
 	}
 
 	return s
 }
 
-func (s*S3Helper) GetS3(region string) *s3.S3 {
+func (s *S3Helper) GetS3(region string) s3iface.S3API {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	client, found := s.regions[region]
 	if !found {
-		config := s.config.Copy().WithRegion(region)
-		client = s3.New(session.New(), config)
+		client = s.newClient(region)
 		s.regions[region] = client
 	}
 	return client
 }
 
-func (s*S3Helper) FindBucketIfExists(name string) (*S3Bucket, error) {
+func (s *S3Helper) FindBucketIfExists(name string) (*S3Bucket, error) {
 	glog.V(2).Infof("Getting location of S3 bucket: %s", name)
 	request := &s3.GetBucketLocationInput{
 		Bucket: aws.String(name),
@@ -70,13 +92,13 @@ func (s*S3Helper) FindBucketIfExists(name string) (*S3Bucket, error) {
 
 	bucket := &S3Bucket{
 		region: region,
-		s3: s.GetS3(region),
-		Name: name,
+		s3:     s.GetS3(region),
+		Name:   name,
 	}
 	return bucket, nil
 }
 
-func (s*S3Helper) EnsureBucket(name string, region string) (*S3Bucket, error) {
+func (s *S3Helper) EnsureBucket(name string, region string) (*S3Bucket, error) {
 	bucket, err := s.FindBucketIfExists(name)
 	if err != nil {
 		return nil, err
@@ -93,8 +115,8 @@ func (s*S3Helper) EnsureBucket(name string, region string) (*S3Bucket, error) {
 		}
 		bucket = &S3Bucket{
 			region: region,
-			s3: client,
-			Name : name,
+			s3:     client,
+			Name:   name,
 		}
 	}
 	return bucket, nil
@@ -102,18 +124,18 @@ func (s*S3Helper) EnsureBucket(name string, region string) (*S3Bucket, error) {
 
 type S3Bucket struct {
 	region string
-	s3     *s3.S3
+	s3     s3iface.S3API
 	Name   string
 }
 
-func (b*S3Bucket) Region() string {
+func (b *S3Bucket) Region() string {
 	return b.region
 }
 
-func (b*S3Bucket) FindObjectIfExists(key string) (*S3Object, error) {
+func (b *S3Bucket) FindObjectIfExists(key string) (*S3Object, error) {
 	o := &S3Object{
 		Bucket: b,
-		Key: key,
+		Key:    key,
 	}
 	response, err := o.headObject()
 	if err != nil {
@@ -123,10 +145,45 @@ func (b*S3Bucket) FindObjectIfExists(key string) (*S3Object, error) {
 		return nil, nil
 	}
 	o.etag = response.ETag
+	o.metadata = response.Metadata
+	o.LastModified = aws.TimeValue(response.LastModified)
 	return o, nil
 }
 
-func (b*S3Bucket) PublicURL() (string) {
+// ListObjects returns all the objects in the bucket with keys starting with prefix.  The objects do not
+// have their metadata populated.
+func (b *S3Bucket) ListObjects(prefix string) ([]*S3Object, error) {
+	glog.V(2).Infof("Listing S3 objects in s3://%s/%s", b.Name, prefix)
+
+	request := &s3.ListObjectsInput{
+		Bucket: aws.String(b.Name),
+		Prefix: aws.String(prefix),
+	}
+	var objects []*S3Object
+	for {
+		response, err := b.s3.ListObjects(request)
+		if err != nil {
+			return nil, fmt.Errorf("error listing S3 objects in s3://%s/%s: %v", b.Name, prefix, err)
+		}
+		for _, c := range response.Contents {
+			objects = append(objects, &S3Object{
+				Bucket:       b,
+				Key:          aws.StringValue(c.Key),
+				etag:         c.ETag,
+				LastModified: aws.TimeValue(c.LastModified),
+			})
+		}
+
+		// NextMarker is only returned when a delimiter is specified; otherwise we continue from the last key
+		if !aws.BoolValue(response.IsTruncated) || len(response.Contents) == 0 {
+			break
+		}
+		request.Marker = response.Contents[len(response.Contents)-1].Key
+	}
+	return objects, nil
+}
+
+func (b *S3Bucket) PublicURL() string {
 	var regionURL string
 
 	if b.region == "us-east-1" {
@@ -140,25 +197,27 @@ func (b*S3Bucket) PublicURL() (string) {
 	return regionURL + b.Name + "/"
 }
 
-func (b*S3Bucket) PutObject(key string, body io.ReadSeeker) (*S3Object, error) {
+func (b *S3Bucket) PutObject(key string, body io.ReadSeeker, metadata map[string]string) (*S3Object, error) {
 	o := &S3Object{
 		Bucket: b,
-		Key: key,
+		Key:    key,
 	}
-	response, err := o.putObject(body)
+	response, err := o.putObject(body, metadata)
 	if err != nil {
 		return nil, err
 	}
 	o.etag = response.ETag
+	o.metadata = aws.StringMap(metadata)
 	return o, nil
 }
 
-func (o*S3Object) putObject(body io.ReadSeeker) (*s3.PutObjectOutput, error) {
+func (o *S3Object) putObject(body io.ReadSeeker, metadata map[string]string) (*s3.PutObjectOutput, error) {
 	glog.Infof("Uploading object to %q", o)
 	request := &s3.PutObjectInput{
-		Bucket: aws.String(o.Bucket.Name),
-		Key:    aws.String(o.Key),
-		Body: body,
+		Bucket:   aws.String(o.Bucket.Name),
+		Key:      aws.String(o.Key),
+		Body:     body,
+		Metadata: aws.StringMap(metadata),
 	}
 	response, err := o.Bucket.s3.PutObject(request)
 	if err != nil {
@@ -169,12 +228,25 @@ func (o*S3Object) putObject(body io.ReadSeeker) (*s3.PutObjectOutput, error) {
 }
 
 type S3Object struct {
-	Bucket *S3Bucket
-	Key    string
-	etag   *string
+	Bucket   *S3Bucket
+	Key      string
+	etag     *string
+	metadata map[string]*string
+
+	LastModified time.Time
 }
 
-func (o*S3Object) headObject() (*s3.HeadObjectOutput, error) {
+// Metadata returns the user metadata value for key; S3 does not preserve the case of metadata keys
+func (o *S3Object) Metadata(key string) string {
+	for k, v := range o.metadata {
+		if strings.EqualFold(k, key) {
+			return aws.StringValue(v)
+		}
+	}
+	return ""
+}
+
+func (o *S3Object) headObject() (*s3.HeadObjectOutput, error) {
 	glog.V(2).Infof("Checking for S3 object: %s", o)
 
 	request := &s3.HeadObjectInput{
@@ -197,16 +269,29 @@ func (o*S3Object) headObject() (*s3.HeadObjectOutput, error) {
 	return response, nil
 }
 
-func (o*S3Object) String() string {
+func (o *S3Object) String() string {
 	return fmt.Sprintf("s3://%s/%s", o.Bucket.Name, o.Key)
 }
 
-func (o*S3Object) IsPublic() (bool, error) {
+func (o *S3Object) Delete() error {
+	glog.V(2).Infof("Deleting S3 object %s", o)
+	request := &s3.DeleteObjectInput{
+		Bucket: aws.String(o.Bucket.Name),
+		Key:    aws.String(o.Key),
+	}
+	_, err := o.Bucket.s3.DeleteObject(request)
+	if err != nil {
+		return fmt.Errorf("error deleting S3 object %q: %v", o, err)
+	}
+	return nil
+}
+
+func (o *S3Object) IsPublic() (bool, error) {
 	glog.V(2).Infof("Getting for S3 object ACL: %s", o)
 
 	aclRequest := &s3.GetObjectAclInput{
 		Bucket: aws.String(o.Bucket.Name),
-		Key: aws.String(o.Key),
+		Key:    aws.String(o.Key),
 	}
 	aclResponse, err := o.Bucket.s3.GetObjectAcl(aclRequest)
 	if err != nil {
@@ -230,7 +315,7 @@ func (o*S3Object) IsPublic() (bool, error) {
 	return isPublic, nil
 }
 
-func (o*S3Object) Etag() (string, error) {
+func (o *S3Object) Etag() (string, error) {
 	etag := *o.etag
 	if len(etag) > 0 {
 		if etag[0] == '"' {
@@ -238,25 +323,25 @@ func (o*S3Object) Etag() (string, error) {
 		}
 	}
 	if len(etag) > 0 {
-		if etag[len(etag) - 1] == '"' {
-			etag = etag[:len(etag) - 1]
+		if etag[len(etag)-1] == '"' {
+			etag = etag[:len(etag)-1]
 		}
 	}
 
 	return etag, nil
 }
 
-func (o*S3Object) PublicURL() (string) {
+func (o *S3Object) PublicURL() string {
 	bucketBase := o.Bucket.PublicURL()
 	return bucketBase + o.Key
 }
 
-func (o*S3Object) SetPublicACL() (error) {
+func (o *S3Object) SetPublicACL() error {
 	glog.V(2).Infof("Setting S3 object ACL: %s", o)
 
 	request := &s3.PutObjectAclInput{
-		Bucket: aws.String(o.Bucket.Name),
-		Key: aws.String(o.Key),
+		Bucket:    aws.String(o.Bucket.Name),
+		Key:       aws.String(o.Key),
 		GrantRead: aws.String("uri=\"" + aclAllUsers + "\""),
 	}
 	_, err := o.Bucket.s3.PutObjectAcl(request)
@@ -266,3 +351,43 @@ func (o*S3Object) SetPublicACL() (error) {
 
 	return nil
 }
+
+func (o *S3Object) SetPrivateACL() error {
+	glog.V(2).Infof("Setting S3 object ACL to private: %s", o)
+
+	request := &s3.PutObjectAclInput{
+		Bucket: aws.String(o.Bucket.Name),
+		Key:    aws.String(o.Key),
+		ACL:    aws.String(s3.ObjectCannedACLPrivate),
+	}
+	_, err := o.Bucket.s3.PutObjectAcl(request)
+	if err != nil {
+		return fmt.Errorf("error setting S3 ACL for %q: %v", o, err)
+	}
+
+	return nil
+}
+
+// PresignedURL returns a URL that allows the object to be downloaded without credentials until signTime + expiry
+func (o *S3Object) PresignedURL(expiry time.Duration, signTime time.Time) (string, error) {
+	request, _ := o.Bucket.s3.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(o.Bucket.Name),
+		Key:    aws.String(o.Key),
+	})
+	request.ExpireTime = expiry
+	// Presigned URLs can't require headers
+	request.NotHoist = false
+
+	err := request.Build()
+	if err != nil {
+		return "", fmt.Errorf("error building presigned URL for %q: %v", o, err)
+	}
+	// request.Presign always signs with the current time
+	v4.SignSDKRequestWithCurrentTime(request, func() time.Time {
+		return signTime
+	})
+	if request.Error != nil {
+		return "", fmt.Errorf("error presigning URL for %q: %v", o, request.Error)
+	}
+	return request.HTTPRequest.URL.String(), nil
+}
@@ -0,0 +1,323 @@
+package fi
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/glog"
+)
+
+// S3MultipartPartSize is the part size for multipart uploads; smaller objects are uploaded with a single PutObject
+var S3MultipartPartSize int64 = 16 * 1024 * 1024
+
+// DefaultS3UploadConcurrency is the number of parts that we upload at once
+const DefaultS3UploadConcurrency = 4
+
+// S3 limits a multipart upload to 10000 parts
+const s3MaxParts = 10000
+
+// PutResource uploads the resource to key, using a multipart upload if it is large.  A multipart upload that
+// fails is left in place, so that the next attempt can reuse the parts that were uploaded.
+func (b *S3Bucket) PutResource(key string, r Resource, metadata map[string]string, concurrency int) (*S3Object, error) {
+	body, err := r.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer SafeClose(body)
+
+	size, err := body.Seek(0, 2)
+	if err != nil {
+		return nil, fmt.Errorf("error finding size of resource: %v", err)
+	}
+	if size <= S3MultipartPartSize {
+		_, err = body.Seek(0, 0)
+		if err != nil {
+			return nil, fmt.Errorf("error seeking resource: %v", err)
+		}
+		return b.PutObject(key, body, metadata)
+	}
+
+	return b.putMultipart(key, r, size, metadata, concurrency)
+}
+
+type s3MultipartUpload struct {
+	bucket   *S3Bucket
+	key      string
+	uploadID string
+	partSize int64
+	size     int64
+
+	// existing holds the parts uploaded by a previous attempt, by part number
+	existing map[int64]*s3.Part
+}
+
+func (b *S3Bucket) putMultipart(key string, r Resource, size int64, metadata map[string]string, concurrency int) (*S3Object, error) {
+	u := &s3MultipartUpload{
+		bucket:   b,
+		key:      key,
+		size:     size,
+		partSize: S3MultipartPartSize,
+	}
+	if size > u.partSize*s3MaxParts {
+		u.partSize = (size + s3MaxParts - 1) / s3MaxParts
+	}
+	partCount := int((size + u.partSize - 1) / u.partSize)
+
+	err := u.findExisting()
+	if err != nil {
+		return nil, err
+	}
+
+	if u.uploadID == "" {
+		glog.Infof("Starting multipart upload of %q (%d parts)", key, partCount)
+		request := &s3.CreateMultipartUploadInput{
+			Bucket:   aws.String(b.Name),
+			Key:      aws.String(key),
+			Metadata: aws.StringMap(metadata),
+		}
+		response, err := b.s3.CreateMultipartUpload(request)
+		if err != nil {
+			return nil, fmt.Errorf("error starting multipart upload of %q: %v", key, err)
+		}
+		u.uploadID = aws.StringValue(response.UploadId)
+	} else {
+		glog.Infof("Resuming multipart upload of %q (%d of %d parts already uploaded)", key, len(u.existing), partCount)
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	parts := make([]*s3.CompletedPart, partCount)
+
+	var mutex sync.Mutex
+	var firstErr error
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Each worker reads the resource independently
+			in, err := r.Open()
+			if err == nil {
+				defer SafeClose(in)
+			}
+			buffer := make([]byte, u.partSize)
+			for part := range work {
+				if err == nil {
+					parts[part], err = u.uploadPart(in, int64(part+1), buffer)
+				}
+				if err != nil {
+					mutex.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	for i := 0; i < partCount; i++ {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		glog.Warningf("multipart upload of %q failed; the next attempt will resume it", key)
+		return nil, firstErr
+	}
+
+	request := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(b.Name),
+		Key:             aws.String(key),
+		UploadId:        aws.String(u.uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	}
+	_, err = b.s3.CompleteMultipartUpload(request)
+	if err != nil {
+		return nil, fmt.Errorf("error completing multipart upload of %q: %v", key, err)
+	}
+
+	o, err := b.FindObjectIfExists(key)
+	if err != nil {
+		return nil, err
+	}
+	if o == nil {
+		return nil, fmt.Errorf("object %q not found after upload", key)
+	}
+	return o, nil
+}
+
+// findExisting finds an incomplete upload of the same key; because our keys include the hash, it has the same contents
+func (u *s3MultipartUpload) findExisting() error {
+	b := u.bucket
+
+	var latest *s3.MultipartUpload
+	request := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(b.Name),
+		Prefix: aws.String(u.key),
+	}
+	for {
+		response, err := b.s3.ListMultipartUploads(request)
+		if err != nil {
+			return fmt.Errorf("error listing multipart uploads: %v", err)
+		}
+		for _, upload := range response.Uploads {
+			if aws.StringValue(upload.Key) != u.key {
+				continue
+			}
+			if latest == nil || aws.TimeValue(upload.Initiated).After(aws.TimeValue(latest.Initiated)) {
+				latest = upload
+			}
+		}
+		if !aws.BoolValue(response.IsTruncated) {
+			break
+		}
+		request.KeyMarker = response.NextKeyMarker
+		request.UploadIdMarker = response.NextUploadIdMarker
+	}
+
+	if latest == nil {
+		return nil
+	}
+
+	u.uploadID = aws.StringValue(latest.UploadId)
+	u.existing = make(map[int64]*s3.Part)
+
+	partsRequest := &s3.ListPartsInput{
+		Bucket:   aws.String(b.Name),
+		Key:      aws.String(u.key),
+		UploadId: latest.UploadId,
+	}
+	for {
+		response, err := b.s3.ListParts(partsRequest)
+		if err != nil {
+			return fmt.Errorf("error listing parts of multipart upload: %v", err)
+		}
+		for _, part := range response.Parts {
+			u.existing[aws.Int64Value(part.PartNumber)] = part
+		}
+		if !aws.BoolValue(response.IsTruncated) {
+			break
+		}
+		partsRequest.PartNumberMarker = response.NextPartNumberMarker
+	}
+	return nil
+}
+
+func (u *s3MultipartUpload) uploadPart(in io.ReadSeeker, partNumber int64, buffer []byte) (*s3.CompletedPart, error) {
+	offset := (partNumber - 1) * u.partSize
+	length := u.partSize
+	if offset+length > u.size {
+		length = u.size - offset
+	}
+
+	_, err := in.Seek(offset, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error seeking resource: %v", err)
+	}
+	data := buffer[:length]
+	_, err = io.ReadFull(in, data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading resource: %v", err)
+	}
+
+	hash := md5.Sum(data)
+	md5Hex := hex.EncodeToString(hash[:])
+
+	if existing := u.existing[partNumber]; existing != nil {
+		if aws.Int64Value(existing.Size) == length && strings.Trim(aws.StringValue(existing.ETag), "\"") == md5Hex {
+			glog.V(2).Infof("Part %d of %q was already uploaded", partNumber, u.key)
+			return &s3.CompletedPart{PartNumber: aws.Int64(partNumber), ETag: existing.ETag}, nil
+		}
+	}
+
+	glog.V(2).Infof("Uploading part %d of %q", partNumber, u.key)
+	// S3 verifies the ContentMD5, so a part corrupted in transit is rejected
+	request := &s3.UploadPartInput{
+		Bucket:     aws.String(u.bucket.Name),
+		Key:        aws.String(u.key),
+		UploadId:   aws.String(u.uploadID),
+		PartNumber: aws.Int64(partNumber),
+		Body:       bytes.NewReader(data),
+		ContentMD5: aws.String(base64.StdEncoding.EncodeToString(hash[:])),
+	}
+	response, err := u.bucket.s3.UploadPart(request)
+	if err != nil {
+		return nil, fmt.Errorf("error uploading part %d of %q: %v", partNumber, u.key, err)
+	}
+	return &s3.CompletedPart{PartNumber: aws.Int64(partNumber), ETag: response.ETag}, nil
+}
+
+// S3IncompleteUpload is a multipart upload that was started but neither completed nor aborted; S3 keeps (and charges for)
+// its parts until it is aborted
+type S3IncompleteUpload struct {
+	Bucket    *S3Bucket
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+func (u *S3IncompleteUpload) String() string {
+	return fmt.Sprintf("s3://%s/%s (upload %s)", u.Bucket.Name, u.Key, u.UploadID)
+}
+
+// ListIncompleteUploads returns the incomplete multipart uploads of keys under prefix
+func (b *S3Bucket) ListIncompleteUploads(prefix string) ([]*S3IncompleteUpload, error) {
+	glog.V(2).Infof("Listing S3 multipart uploads in s3://%s/%s", b.Name, prefix)
+
+	request := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(b.Name),
+		Prefix: aws.String(prefix),
+	}
+	var uploads []*S3IncompleteUpload
+	for {
+		response, err := b.s3.ListMultipartUploads(request)
+		if err != nil {
+			return nil, fmt.Errorf("error listing multipart uploads in s3://%s/%s: %v", b.Name, prefix, err)
+		}
+		for _, upload := range response.Uploads {
+			uploads = append(uploads, &S3IncompleteUpload{
+				Bucket:    b,
+				Key:       aws.StringValue(upload.Key),
+				UploadID:  aws.StringValue(upload.UploadId),
+				Initiated: aws.TimeValue(upload.Initiated),
+			})
+		}
+		if !aws.BoolValue(response.IsTruncated) {
+			break
+		}
+		request.KeyMarker = response.NextKeyMarker
+		request.UploadIdMarker = response.NextUploadIdMarker
+	}
+	return uploads, nil
+}
+
+// Abort aborts the upload, deleting the parts that were uploaded
+func (u *S3IncompleteUpload) Abort() error {
+	glog.V(2).Infof("Aborting multipart upload %s", u)
+
+	request := &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(u.Bucket.Name),
+		Key:      aws.String(u.Key),
+		UploadId: aws.String(u.UploadID),
+	}
+	_, err := u.Bucket.s3.AbortMultipartUpload(request)
+	if err != nil {
+		return fmt.Errorf("error aborting multipart upload %s: %v", u, err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package fi
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3StateStore is a StateStore under a prefix in an S3 bucket.  The state includes the CA private key,
+// so the bucket must not be readable by anyone who should not be able to administer the cluster.
+//
+// Before each write we check that the file is unchanged since we read it, so that two people operating on the
+// cluster at the same time don't silently overwrite each other's changes.  (S3 has no conditional PUT,
+// so this narrows the window for a lost update rather than closing it.)
+type S3StateStore struct {
+	bucket *S3Bucket
+	prefix string
+
+	mutex sync.Mutex
+	// etags holds the ETag of each file we have read or written, or "" if it did not exist
+	etags map[string]string
+}
+
+var _ StateStore = &S3StateStore{}
+
+func NewS3StateStore(bucket *S3Bucket, prefix string) *S3StateStore {
+	return &S3StateStore{bucket: bucket, prefix: prefix, etags: make(map[string]string)}
+}
+
+func (s *S3StateStore) key(p string) string {
+	if s.prefix == "" {
+		return p
+	}
+	return s.prefix + "/" + p
+}
+
+func (s *S3StateStore) Location(p string) string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket.Name, s.key(p))
+}
+
+func (s *S3StateStore) ReadFile(p string) ([]byte, error) {
+	request := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket.Name),
+		Key:    aws.String(s.key(p)),
+	}
+	response, err := s.bucket.s3.GetObject(request)
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NoSuchKey" {
+			s.setETag(p, "")
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading %q: %v", s.Location(p), err)
+	}
+	defer SafeClose(response.Body)
+
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %q: %v", s.Location(p), err)
+	}
+	s.setETag(p, aws.StringValue(response.ETag))
+	return data, nil
+}
+
+func (s *S3StateStore) setETag(p string, etag string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.etags[p] = etag
+}
+
+func (s *S3StateStore) WriteFile(p string, data []byte) error {
+	// We don't set an ACL, so the object is private to the bucket owner
+	request := &s3.PutObjectInput{
+		Bucket:               aws.String(s.bucket.Name),
+		Key:                  aws.String(s.key(p)),
+		Body:                 bytes.NewReader(data),
+		ServerSideEncryption: aws.String(s3.ServerSideEncryptionAes256),
+	}
+
+	err := s.checkUnchanged(p)
+	if err != nil {
+		return err
+	}
+
+	response, err := s.bucket.s3.PutObject(request)
+	if err != nil {
+		return fmt.Errorf("error writing %q: %v", s.Location(p), err)
+	}
+	s.setETag(p, aws.StringValue(response.ETag))
+	return nil
+}
+
+// checkUnchanged returns an error if the file has changed since we read (or wrote) it
+func (s *S3StateStore) checkUnchanged(p string) error {
+	s.mutex.Lock()
+	etag, found := s.etags[p]
+	s.mutex.Unlock()
+	if !found {
+		return nil
+	}
+
+	request := &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket.Name),
+		Key:    aws.String(s.key(p)),
+	}
+	current := ""
+	response, err := s.bucket.s3.HeadObject(request)
+	if err != nil {
+		if requestFailure, ok := err.(awserr.RequestFailure); !ok || requestFailure.StatusCode() != 404 {
+			return fmt.Errorf("error checking %q: %v", s.Location(p), err)
+		}
+	} else {
+		current = aws.StringValue(response.ETag)
+	}
+
+	if current != etag {
+		return fmt.Errorf("%s was changed by someone else while we were running; re-run to pick up their changes", s.Location(p))
+	}
+	return nil
+}
+
+func (s *S3StateStore) DeleteFile(p string) error {
+	o := &S3Object{Bucket: s.bucket, Key: s.key(p)}
+	err := o.Delete()
+	if err != nil {
+		return err
+	}
+	s.setETag(p, "")
+	return nil
+}
@@ -0,0 +1,27 @@
+package fi
+
+// bashHelpers are the functions used by the commands in the scripts generated by BashTarget
+const bashHelpers = `# add-tag RESOURCE-ID KEY VALUE
+function add-tag {
+  aws ec2 create-tags --resources "$1" --tags "Key=$2,Value=$3"
+}
+
+# wait-for-instance-state INSTANCE-ID STATE
+function wait-for-instance-state {
+  local instance_id="$1"
+  local state="$2"
+  while true; do
+    local current=$(aws ec2 describe-instances --instance-ids "${instance_id}" --query 'Reservations[0].Instances[0].State.Name')
+    if [[ "${current}" == "${state}" ]]; then
+      return 0
+    fi
+    echo "Waiting for instance ${instance_id} to be ${state} (is ${current})"
+    sleep 5
+  done
+}
+
+# missing VALUE returns true if a lookup did not find anything
+function missing {
+  [[ -z "$1" || "$1" == "None" ]]
+}
+`
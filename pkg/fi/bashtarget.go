@@ -2,20 +2,27 @@ package fi
 
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
 	"io"
-	"path"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/golang/glog"
-	"os"
+	"sync"
 )
 
 type BashTarget struct {
 	// TODO: Remove cloud
-	Cloud                *AWSCloud
-	filestore            FileStore
+	Cloud     *AWSCloud
+	filestore FileStore
+
+	// mutex protects commands, vars, resources and the prefix counts, so that units can be rendered concurrently
+	mutex sync.Mutex
+	// lookupMutex serializes the lookups of referenced units, so that each unit is only looked up once
+	lookupMutex          sync.Mutex
 	commands             []*BashCommand
 	ec2Args              []string
 	autoscalingArgs      []string
@@ -24,13 +31,44 @@ type BashTarget struct {
 	prefixCounts         map[string]int
 	resourcePrefixCounts map[string]int
 
-	baseDir              string
-	resourcesDir         string
+	// resources are embedded in the script, and written to a temporary directory when it runs
+	resources []*bashResource
+}
+
+type bashResource struct {
+	name string
+	data []byte
 }
 
+// bashResourcesVar is the variable holding the temporary directory to which resources are written
+const bashResourcesVar = "KOPE_RESOURCES"
+
 var _ Target = &BashTarget{}
 
-func NewBashTarget(cloud *AWSCloud, filestore FileStore, baseDir string) (*BashTarget, error) {
+// BashRenderer is implemented by units that can be rendered to a bash script
+type BashRenderer interface {
+	RenderBash(t *BashTarget, a, e, changes Unit) error
+}
+
+// BashLookup is implemented by units that a script can find (e.g. by their tags), so that the script can reference
+// units that it does not create or change
+type BashLookup interface {
+	AddBashLookup(t *BashTarget) *BashCommand
+}
+
+func init() {
+	RegisterRenderer(&BashTarget{}, (*BashRenderer)(nil))
+}
+
+func (t *BashTarget) Render(a, e, changes Unit) error {
+	r, ok := e.(BashRenderer)
+	if !ok {
+		return fmt.Errorf("%T does not support the bash target", e)
+	}
+	return r.RenderBash(t, a, e, changes)
+}
+
+func NewBashTarget(cloud *AWSCloud, filestore FileStore) *BashTarget {
 	b := &BashTarget{Cloud: cloud, filestore: filestore}
 	b.ec2Args = []string{"aws", "ec2"}
 	b.autoscalingArgs = []string{"aws", "autoscaling"}
@@ -38,15 +76,7 @@ func NewBashTarget(cloud *AWSCloud, filestore FileStore, baseDir string) (*BashT
 	b.vars = make(map[string]*BashVar)
 	b.prefixCounts = make(map[string]int)
 	b.resourcePrefixCounts = make(map[string]int)
-
-	b.baseDir = baseDir
-	b.resourcesDir = path.Join(b.baseDir, "bash_resources")
-	err := os.MkdirAll(b.resourcesDir, 0700)
-	if err != nil {
-		return nil, fmt.Errorf("error creating directories %q: %v", b.resourcesDir, err)
-	}
-
-	return b, nil
+	return b
 }
 
 type BashVar struct {
@@ -65,6 +95,9 @@ func getKey(v Unit) string {
 }
 
 func (t *BashTarget) CreateVar(v Unit) *BashVar {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
 	key := getKey(v)
 	bv, found := t.vars[key]
 	if found {
@@ -85,6 +118,13 @@ type BashCommand struct {
 	parent   *BashTarget
 	args     []string
 	assignTo string
+
+	// literal is assigned to assignTo, in place of the output of running args
+	literal *string
+	// condition guards the command; it is only run if the condition is true
+	condition string
+	// ignoreErrors is used for lookups, where the AWS CLI reports a missing resource as an error
+	ignoreErrors bool
 }
 
 func (c *BashCommand) AssignTo(s Unit) *BashCommand {
@@ -92,7 +132,7 @@ func (c *BashCommand) AssignTo(s Unit) *BashCommand {
 }
 
 func (c *BashCommand) AssignToSuffixedVariable(s Unit, suffix string) *BashCommand {
-	bv := c.parent.vars[getKey(s)]
+	bv := c.parent.findVar(s)
 	if bv == nil {
 		glog.Fatal("no variable assigned to ", s)
 	}
@@ -103,80 +143,178 @@ func (c *BashCommand) AssignToSuffixedVariable(s Unit, suffix string) *BashComma
 	return c
 }
 
-func (c *BashCommand) String() string {
-	if c.assignTo != "" {
-		return c.assignTo + "=`" + strings.Join(c.args, " ") + "`"
-	} else {
-		return strings.Join(c.args, " ")
+// IfMissing only runs the command if the variable for the unit is empty, i.e. a lookup did not find the resource
+func (c *BashCommand) IfMissing(s Unit) *BashCommand {
+	return c.IfMissingSuffixedVariable(s, "")
+}
+
+func (c *BashCommand) IfMissingSuffixedVariable(s Unit, suffix string) *BashCommand {
+	bv := c.parent.findVar(s)
+	if bv == nil {
+		glog.Fatal("no variable assigned to ", s)
 	}
+	return c.If("missing " + bashVarRef(bv.name+suffix))
 }
 
-func (c *BashCommand) PrintShellCommand(w io.Writer) error {
-	var buf bytes.Buffer
+// If only runs the command if the shell condition is true
+func (c *BashCommand) If(condition string) *BashCommand {
+	c.condition = condition
+	return c
+}
 
-	if c.assignTo != "" {
-		buf.WriteString(c.assignTo)
-		buf.WriteString("=`")
-	}
+// IgnoreErrors treats a failure of the command as an empty result
+func (c *BashCommand) IgnoreErrors() *BashCommand {
+	c.ignoreErrors = true
+	return c
+}
 
-	for i, arg := range c.args {
-		if i != 0 {
-			buf.WriteString(" ")
+func (c *BashCommand) String() string {
+	var buf bytes.Buffer
+	c.writeTo(&buf)
+	return strings.TrimSpace(buf.String())
+}
+
+func (c *BashCommand) writeTo(buf *bytes.Buffer) {
+	var line string
+	if c.literal != nil {
+		line = c.assignTo + "=" + BashQuoteString(*c.literal)
+	} else {
+		var quoted []string
+		for _, arg := range c.args {
+			quoted = append(quoted, bashQuoteArg(arg))
+		}
+		line = strings.Join(quoted, " ")
+		if c.ignoreErrors {
+			line += " 2>/dev/null || true"
+		}
+		if c.assignTo != "" {
+			line = c.assignTo + "=$(" + line + ")"
 		}
-		buf.WriteString(arg)
 	}
 
-	if c.assignTo != "" {
-		buf.WriteString("`")
+	if c.condition != "" {
+		buf.WriteString("if " + c.condition + "; then\n")
+		buf.WriteString("  " + line + "\n")
+		buf.WriteString("fi\n")
+	} else {
+		buf.WriteString(line + "\n")
 	}
+}
 
-	buf.WriteString("\n")
-
+func (c *BashCommand) PrintShellCommand(w io.Writer) error {
+	var buf bytes.Buffer
+	c.writeTo(&buf)
 	_, err := buf.WriteTo(w)
 	return err
 }
 
-func (t *BashTarget) ReadVar(s Unit) string {
+func (t *BashTarget) ReadVar(s Unit) (string, error) {
 	return t.ReadVarWithSuffix(s, "")
 }
 
-func (t *BashTarget) ReadVarWithSuffix(s Unit, suffix string) string {
-	bv := t.vars[getKey(s)]
-	if bv == nil {
-		glog.Fatal("no variable assigned to ", s)
+func (t *BashTarget) findVar(u Unit) *BashVar {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.vars[getKey(u)]
+}
+
+func (t *BashTarget) ReadVarWithSuffix(s Unit, suffix string) (string, error) {
+	bv, err := t.lookupVar(s)
+	if err != nil {
+		return "", err
+	}
+	return bashVarRef(bv.name + suffix), nil
+}
+
+// lookupVar returns the variable for a unit.  Units that are unchanged are not rendered, so when they are
+// referenced the script looks them up, or uses the ID that was found in the cloud.
+func (t *BashTarget) lookupVar(u Unit) (*BashVar, error) {
+	t.lookupMutex.Lock()
+	defer t.lookupMutex.Unlock()
+
+	bv := t.findVar(u)
+	if bv != nil {
+		return bv, nil
+	}
+
+	if l, ok := u.(BashLookup); ok {
+		bv = t.CreateVar(u)
+		l.AddBashLookup(t)
+		return bv, nil
+	}
+
+	if hasID, ok := u.(HasID); ok && hasID.GetID() != nil {
+		bv = t.CreateVar(u)
+		t.AddAssignment(u, *hasID.GetID())
+		return bv, nil
 	}
-	// TODO: Escaping?
-	return "${" + bv.name + suffix + "}"
+
+	return nil, fmt.Errorf("no variable assigned to %v, and it cannot be looked up", u)
+}
+
+// bashVarRef returns the quoted reference to a variable.  References can be embedded in command arguments,
+// and are expanded in place when the arguments are quoted.
+func bashVarRef(name string) string {
+	return "\"${" + name + "}\""
 }
 
 func (t *BashTarget) DebugDump() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
 	for _, cmd := range t.commands {
 		glog.Info("CMD: ", cmd)
 	}
 }
 
+// PrintShellCommands writes a standalone script, including the helper functions and the resources it uses.
+// Resources are looked up before they are created, so the script can safely be re-run.
 func (t *BashTarget) PrintShellCommands(w io.Writer) error {
-	var header bytes.Buffer
-	header.WriteString("#!/bin/bash\n")
-	header.WriteString("set -ex\n\n")
-	header.WriteString(". ./helpers\n\n")
-
-	for k, v := range t.Cloud.EnvVars() {
-		header.WriteString("export " + k + "=" + BashQuoteString(v) + "\n")
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var b bytes.Buffer
+	b.WriteString("#!/bin/bash\n")
+	b.WriteString("set -ex\n\n")
+	b.WriteString(bashHelpers)
+	b.WriteString("\n")
+
+	env := t.Cloud.EnvVars()
+	var keys []string
+	for k := range env {
+		keys = append(keys, k)
 	}
-
-	_, err := header.WriteTo(w)
-	if err != nil {
-		return err
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString("export " + k + "=" + BashQuoteString(env[k]) + "\n")
+	}
+	b.WriteString("\n")
+
+	if len(t.resources) != 0 {
+		b.WriteString(bashResourcesVar + "=$(mktemp -d)\n")
+		b.WriteString("trap " + BashQuoteString("rm -rf "+bashVarRef(bashResourcesVar)) + " EXIT\n")
+		for _, r := range t.resources {
+			b.WriteString("base64 --decode > " + bashQuoteArg(bashVarRef(bashResourcesVar)+"/"+r.name) + " << 'EOF_RESOURCE'\n")
+			encoded := base64.StdEncoding.EncodeToString(r.data)
+			for len(encoded) > 76 {
+				b.WriteString(encoded[:76] + "\n")
+				encoded = encoded[76:]
+			}
+			if encoded != "" {
+				b.WriteString(encoded + "\n")
+			}
+			b.WriteString("EOF_RESOURCE\n")
+		}
+		b.WriteString("\n")
 	}
 
 	for _, cmd := range t.commands {
-		err = cmd.PrintShellCommand(w)
-		if err != nil {
-			return err
-		}
+		cmd.writeTo(&b)
 	}
-	return nil
+
+	_, err := b.WriteTo(w)
+	return err
 }
 
 func (t *BashTarget) AddEC2Command(args ...string) *BashCommand {
@@ -226,19 +364,50 @@ func (t *BashTarget) AddIAMCommand(args ...string) *BashCommand {
 	return t.AddCommand(cmd)
 }
 
+// BashQuoteString quotes a literal value, so that it is passed unchanged as a single word
 func BashQuoteString(s string) string {
-	// TODO: Escaping
+	return "'" + strings.Replace(s, "'", "'\\''", -1) + "'"
+}
+
+var bashVarRefPattern = regexp.MustCompile(`"\$\{[A-Za-z0-9_]+\}"`)
+
+// bashQuoteArg quotes a command argument; variable references (from ReadVar) are expanded, everything else is literal
+func bashQuoteArg(arg string) string {
+	if arg != "" && bashSafePattern.MatchString(arg) {
+		return arg
+	}
+
+	refs := bashVarRefPattern.FindAllStringIndex(arg, -1)
+	if len(refs) == 0 && !strings.Contains(arg, "'") {
+		return BashQuoteString(arg)
+	}
+
 	var quoted bytes.Buffer
+	quoted.WriteString("\"")
+	pos := 0
+	for _, ref := range refs {
+		quoted.WriteString(bashEscapeDoubleQuoted(arg[pos:ref[0]]))
+		// Strip the quotes from the reference
+		quoted.WriteString(arg[ref[0]+1 : ref[1]-1])
+		pos = ref[1]
+	}
+	quoted.WriteString(bashEscapeDoubleQuoted(arg[pos:]))
+	quoted.WriteString("\"")
+	return quoted.String()
+}
+
+var bashSafePattern = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+func bashEscapeDoubleQuoted(s string) string {
+	var escaped bytes.Buffer
 	for _, c := range s {
 		switch c {
-		case '"':
-			quoted.WriteString("\\\"")
-		default:
-			quoted.WriteString(string(c))
+		case '"', '\\', '$', '`':
+			escaped.WriteRune('\\')
 		}
+		escaped.WriteRune(c)
 	}
-
-	return "\"" + string(quoted.Bytes()) + "\""
+	return escaped.String()
 }
 
 func (t *BashTarget) AddAWSTags(s Unit, expected map[string]string) error {
@@ -262,83 +431,113 @@ func (t *BashTarget) AddAWSTags(s Unit, expected map[string]string) error {
 		missing = expected
 	}
 
-	for name, value := range missing {
+	var names []string
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := missing[name]
+		resourceVar, err := t.ReadVar(s)
+		if err != nil {
+			return err
+		}
 		cmd := &BashCommand{}
-		cmd.args = []string{"add-tag", t.ReadVar(s), BashQuoteString(name), BashQuoteString(value)}
+		cmd.args = []string{"add-tag", resourceVar, name, value}
 		t.AddCommand(cmd)
 	}
 
 	return nil
 }
 
+// EC2TagFilters returns the arguments to find EC2 resources by their tags (as set by AddAWSTags)
+func (t *BashTarget) EC2TagFilters(name *string) []string {
+	tags := t.Cloud.BuildTags(name)
+	var keys []string
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	args := []string{"--filters"}
+	for _, k := range keys {
+		args = append(args, "Name=tag:"+k+",Values="+tags[k])
+	}
+	return args
+}
+
+// AddEC2TagLookup assigns the ID of an existing EC2 resource (found by its tags) to the variable for the unit,
+// so that creation can be guarded with IfMissing
+func (t *BashTarget) AddEC2TagLookup(u Unit, name *string, describeCommand string, query string, extraFilters ...string) *BashCommand {
+	args := []string{describeCommand}
+	args = append(args, t.EC2TagFilters(name)...)
+	args = append(args, extraFilters...)
+	args = append(args, "--query", query)
+	return t.AddEC2Command(args...).AssignTo(u)
+}
+
 func (t *BashTarget) AddCommand(cmd *BashCommand) *BashCommand {
 	cmd.parent = t
 	glog.V(2).Infof("Add bash command: %v", cmd)
+
+	t.mutex.Lock()
 	t.commands = append(t.commands, cmd)
+	t.mutex.Unlock()
 
 	return cmd
 }
 
 func (t *BashTarget) AddAssignment(u Unit, value string) {
-	bv := t.vars[getKey(u)]
+	bv := t.findVar(u)
 	if bv == nil {
 		glog.Fatal("no variable assigned to ", u)
 	}
 
 	cmd := &BashCommand{}
-	assign := bv.name + "=" + BashQuoteString(value)
-	cmd.args = []string{assign}
+	cmd.assignTo = bv.name
+	cmd.literal = &value
 	t.AddCommand(cmd)
 
+	t.mutex.Lock()
 	bv.staticValue = &value
+	t.mutex.Unlock()
 }
 
 func (t *BashTarget) FindValue(u Unit) (string, bool) {
-	bv := t.vars[getKey(u)]
+	bv := t.findVar(u)
 	if bv == nil {
 		glog.Fatal("no variable assigned to ", u)
 	}
 
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
 	if bv.staticValue == nil {
 		return "", false
 	}
 	return *bv.staticValue, true
 }
 
-func (t *BashTarget) generateDynamicPath(prefix string) string {
+// AddLocalResource embeds the resource in the script, returning the (quoted) path to which it will be written
+func (t *BashTarget) AddLocalResource(r Resource) (string, error) {
+	data, err := ResourceAsBytes(r)
+	if err != nil {
+		return "", fmt.Errorf("error reading resource: %v", err)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	prefix := GetTypeName(r)
 	n := t.resourcePrefixCounts[prefix]
 	n++
 	t.resourcePrefixCounts[prefix] = n
 
 	name := prefix + "_" + strconv.Itoa(n)
-	p := path.Join(t.resourcesDir, name)
-	return p
-}
-
-func (t *BashTarget) AddLocalResource(r Resource) (string, error) {
-	switch r := r.(type) {
-	case *FileResource:
-		return r.Path, nil
-	}
+	t.resources = append(t.resources, &bashResource{name: name, data: data})
 
-	path := t.generateDynamicPath(GetTypeName(r))
-	f, err := os.Create(path)
-	if err != nil {
-		return "", err
-	}
-	defer func() {
-		err := f.Close()
-		if err != nil {
-			glog.Warning("Error closing resource file", err)
-		}
-	}()
-
-	err = CopyResource(f, r)
-	if err != nil {
-		return "", fmt.Errorf("error writing resource: %v", err)
-	}
-
-	return path, nil
+	return bashVarRef(bashResourcesVar) + "/" + name, nil
 }
 
 func (t *BashTarget) PutResource(key string, r Resource, hashAlgorithm HashAlgorithm) (string, string, error) {
@@ -348,9 +547,11 @@ func (t *BashTarget) PutResource(key string, r Resource, hashAlgorithm HashAlgor
 	return t.filestore.PutResource(key, r, hashAlgorithm)
 }
 
-func (t *BashTarget) WaitForInstanceRunning(instance Unit) {
-	instanceID := t.ReadVar(instance)
+func (t *BashTarget) WaitForInstanceRunning(instance Unit) error {
+	instanceID, err := t.ReadVar(instance)
+	if err != nil {
+		return err
+	}
 	t.AddBashCommand("wait-for-instance-state", instanceID, "running")
+	return nil
 }
-
-
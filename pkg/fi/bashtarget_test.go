@@ -0,0 +1,92 @@
+package fi
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestBashQuoteArg(t *testing.T) {
+	vpcRef := bashVarRef("VPC_1")
+
+	tests := []struct {
+		name   string
+		arg    string
+		quoted string
+		// value is the argument that bash passes to the command, with VPC_1=vpc-1234
+		value string
+	}{
+		{name: "safe", arg: "vpc-1234", quoted: "vpc-1234", value: "vpc-1234"},
+		{name: "empty", arg: "", quoted: "''", value: ""},
+		{name: "spaces", arg: "a b  c", quoted: "'a b  c'", value: "a b  c"},
+		{name: "single quote", arg: "it's", quoted: "\"it's\"", value: "it's"},
+		{name: "double quote", arg: "a\"b", quoted: "'a\"b'", value: "a\"b"},
+		{name: "backslash", arg: "a\\b", quoted: "'a\\b'", value: "a\\b"},
+		{name: "dollar", arg: "$HOME ${HOME}", quoted: "'$HOME ${HOME}'", value: "$HOME ${HOME}"},
+		{name: "backticks", arg: "`id` $(id)", quoted: "'`id` $(id)'", value: "`id` $(id)"},
+		{name: "newline", arg: "a\nb", quoted: "'a\nb'", value: "a\nb"},
+		{name: "quotes, dollar & backticks", arg: "it's $HOME `id` \"x\"", quoted: "\"it's \\$HOME \\`id\\` \\\"x\\\"\"", value: "it's $HOME `id` \"x\""},
+		{name: "variable", arg: vpcRef, quoted: "\"${VPC_1}\"", value: "vpc-1234"},
+		{name: "variable in a filter", arg: "Name=vpc-id,Values=" + vpcRef, quoted: "\"Name=vpc-id,Values=${VPC_1}\"", value: "Name=vpc-id,Values=vpc-1234"},
+		{name: "variable with quotes", arg: "[?GroupId=='" + vpcRef + "'] $x", quoted: "\"[?GroupId=='${VPC_1}'] \\$x\"", value: "[?GroupId=='vpc-1234'] $x"},
+		{name: "variable with a newline", arg: vpcRef + "\n`id`", quoted: "\"${VPC_1}\n\\`id\\`\"", value: "vpc-1234\n`id`"},
+	}
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Logf("bash not found; not running the quoted arguments")
+	}
+
+	for _, test := range tests {
+		quoted := bashQuoteArg(test.arg)
+		if quoted != test.quoted {
+			t.Errorf("%s: expected %s, got %s", test.name, test.quoted, quoted)
+			continue
+		}
+
+		if bash == "" {
+			continue
+		}
+		out, err := exec.Command(bash, "-c", "VPC_1=vpc-1234; printf '%s' "+quoted).Output()
+		if err != nil {
+			t.Errorf("%s: error running %s: %v", test.name, quoted, err)
+			continue
+		}
+		if string(out) != test.value {
+			t.Errorf("%s: expected bash to pass %q, got %q", test.name, test.value, string(out))
+		}
+	}
+}
+
+func TestBashQuoteString(t *testing.T) {
+	tests := []string{
+		"",
+		"a b",
+		"it's",
+		"'''",
+		"$HOME ${HOME} $(id)",
+		"`id`",
+		"a\nb\n",
+		"\"${VPC_1}\"",
+	}
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skipf("bash not found")
+	}
+
+	for _, s := range tests {
+		quoted := BashQuoteString(s)
+		if !strings.HasPrefix(quoted, "'") || !strings.HasSuffix(quoted, "'") {
+			t.Errorf("expected %q to be single-quoted, got %s", s, quoted)
+		}
+		out, err := exec.Command(bash, "-c", "printf '%s' "+quoted).Output()
+		if err != nil {
+			t.Errorf("error running %s: %v", quoted, err)
+			continue
+		}
+		if string(out) != s {
+			t.Errorf("expected bash to pass %q, got %q", s, string(out))
+		}
+	}
+}
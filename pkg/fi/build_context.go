@@ -7,7 +7,8 @@ import (
 
 type BuildContext struct {
 	*Context
-	node *node
+	target Target
+	node   *node
 }
 
 type Builder interface {
@@ -43,6 +44,13 @@ func (b *BuildContext) Add(unit Unit) {
 		glog.Exitf("could not determine set key for %T", unit)
 	}
 
+	if b.target != nil {
+		err := checkRenderer(b.target, unit)
+		if err != nil {
+			glog.Exitf("cannot add %s: %v", key, err)
+		}
+	}
+
 	builder, ok := unit.(Builder)
 	if ok {
 		childContext := b.createChildContext(childNode)
@@ -55,6 +63,7 @@ func (b *BuildContext) Add(unit Unit) {
 func (b *BuildContext) createChildContext(childNode *node) *BuildContext {
 	bc := &BuildContext{
 		Context: b.Context,
+		target:  b.target,
 		node:    childNode,
 	}
 	return bc
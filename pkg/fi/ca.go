@@ -1,22 +1,26 @@
 package fi
 
 import (
-	"crypto/x509/pkix"
-	"crypto/x509"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	crypto_rand "crypto/rand"
-	"fmt"
-	"time"
 	"crypto/rsa"
-	"math/big"
-	"io"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
+	"fmt"
 	"github.com/golang/glog"
+	"io"
+	"math/big"
+	"time"
 )
 
 type Certificate struct {
-	Subject     pkix.Name
-	IsCA        bool
+	Subject pkix.Name
+	IsCA    bool
 
 	Certificate *x509.Certificate
 	PublicKey   crypto.PublicKey
@@ -28,9 +32,87 @@ type CAStore interface {
 	FindCert(subject *pkix.Name) (*Certificate, error)
 	IssueCert(privateKey crypto.PrivateKey, template *x509.Certificate) (*Certificate, error)
 	FindPrivateKey(subject *pkix.Name) (crypto.PrivateKey, error)
-	CreatePrivateKey(subject *pkix.Name) (crypto.PrivateKey, error)
+	// CreatePrivateKey generates & stores a key of type keyAlgorithm, or of the store's KeyAlgorithm if keyAlgorithm is ""
+	CreatePrivateKey(subject *pkix.Name, keyAlgorithm KeyAlgorithm) (crypto.PrivateKey, error)
+
+	// GetTrustedCACerts returns the CA certificates that should be trusted: the CA, and during a rotation the other CA
+	GetTrustedCACerts() ([]*Certificate, error)
+	GetCARotationPhase() (CARotationPhase, error)
+	// StartCARotation generates the next CA, which is trusted but not yet used to sign certificates
+	StartCARotation() error
+	// PromoteNextCA makes the next CA the CA; the old CA is still trusted
+	PromoteNextCA() error
+	// FinishCARotation stops trusting the old CA
+	FinishCARotation() error
+
+	// EncryptPlaintextKeys re-writes the keys that were loaded unencrypted, if the store encrypts keys.
+	// It is only called when we are changing the cluster, not for a dry-run or validate.
+	EncryptPlaintextKeys() error
 }
 
+// CARotationPhase is how far through replacing the CA we are
+type CARotationPhase string
+
+// KeyAlgorithm is the type (and size) of the private keys we generate
+type KeyAlgorithm string
+
+const (
+	KeyAlgorithmRSA2048   = KeyAlgorithm("rsa-2048")
+	KeyAlgorithmRSA4096   = KeyAlgorithm("rsa-4096")
+	KeyAlgorithmECDSAP256 = KeyAlgorithm("ecdsa-p256")
+	KeyAlgorithmECDSAP384 = KeyAlgorithm("ecdsa-p384")
+
+	// DefaultKeyAlgorithm is used if the cluster does not specify a KeyAlgorithm
+	DefaultKeyAlgorithm = KeyAlgorithmRSA2048
+
+	// ServiceAccountKeyAlgorithm is used for the master key whatever the cluster's KeyAlgorithm: the master key
+	// also signs the service account tokens, and salt configures it as an RSA key for that
+	ServiceAccountKeyAlgorithm = KeyAlgorithmRSA2048
+)
+
+// Validate returns an error if we don't know how to generate keys of type a
+func (a KeyAlgorithm) Validate() error {
+	switch a {
+	case "", KeyAlgorithmRSA2048, KeyAlgorithmRSA4096, KeyAlgorithmECDSAP256, KeyAlgorithmECDSAP384:
+		return nil
+	default:
+		return fmt.Errorf("unknown KeyAlgorithm %q (valid values: %s, %s, %s, %s)", a, KeyAlgorithmRSA2048, KeyAlgorithmRSA4096, KeyAlgorithmECDSAP256, KeyAlgorithmECDSAP384)
+	}
+}
+
+func GeneratePrivateKey(a KeyAlgorithm) (crypto.PrivateKey, error) {
+	if a == "" {
+		a = DefaultKeyAlgorithm
+	}
+
+	var privateKey crypto.PrivateKey
+	var err error
+	switch a {
+	case KeyAlgorithmRSA2048:
+		privateKey, err = rsa.GenerateKey(crypto_rand.Reader, 2048)
+	case KeyAlgorithmRSA4096:
+		privateKey, err = rsa.GenerateKey(crypto_rand.Reader, 4096)
+	case KeyAlgorithmECDSAP256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), crypto_rand.Reader)
+	case KeyAlgorithmECDSAP384:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P384(), crypto_rand.Reader)
+	default:
+		return nil, a.Validate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error generating %s private key: %v", a, err)
+	}
+	return privateKey, nil
+}
+
+const (
+	CARotationNone = CARotationPhase("")
+	// CARotationStarted means the next CA has been generated
+	CARotationStarted = CARotationPhase("started")
+	// CARotationPromoted means the next CA is now the CA, but the old CA is still trusted
+	CARotationPromoted = CARotationPhase("promoted")
+)
+
 func LoadCertificate(pemData []byte) (*Certificate, error) {
 	cert, err := parsePEMCertificate(pemData)
 	if err != nil {
@@ -38,19 +120,19 @@ func LoadCertificate(pemData []byte) (*Certificate, error) {
 	}
 
 	c := &Certificate{
-		Subject: cert.Subject,
+		Subject:     cert.Subject,
 		Certificate: cert,
-		PublicKey: cert.PublicKey,
-		IsCA: cert.IsCA,
+		PublicKey:   cert.PublicKey,
+		IsCA:        cert.IsCA,
 	}
 	return c, nil
 }
 
 func SignNewCertificate(privateKey crypto.PrivateKey, template *x509.Certificate, signer *x509.Certificate, signerPrivateKey crypto.PrivateKey) (*Certificate, error) {
 	if template.PublicKey == nil {
-		rsaPrivateKey, ok := privateKey.(*rsa.PrivateKey)
+		signer, ok := privateKey.(crypto.Signer)
 		if ok {
-			template.PublicKey = rsaPrivateKey.Public()
+			template.PublicKey = signer.Public()
 		}
 	}
 
@@ -87,6 +169,23 @@ func SignNewCertificate(privateKey crypto.PrivateKey, template *x509.Certificate
 		template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
 	}
 
+	// Key encipherment is RSA key transport; it is not valid for an ECDSA key
+	if _, ok := template.PublicKey.(*ecdsa.PublicKey); ok {
+		template.KeyUsage &^= x509.KeyUsageKeyEncipherment
+	}
+
+	// The key identifiers let verifiers find the issuer by key, not just by name, e.g. when two CAs are trusted
+	if template.SubjectKeyId == nil {
+		subjectKeyID, err := buildSubjectKeyID(template.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		template.SubjectKeyId = subjectKeyID
+	}
+	if template.AuthorityKeyId == nil {
+		template.AuthorityKeyId = parent.SubjectKeyId
+	}
+
 	if template.ExtKeyUsage == nil {
 		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
 	}
@@ -109,7 +208,25 @@ func SignNewCertificate(privateKey crypto.PrivateKey, template *x509.Certificate
 	return c, nil
 }
 
-func (c*Certificate) WriteCertificate(w io.Writer) error {
+// buildSubjectKeyID computes the key identifier as the SHA-1 hash of the public key (RFC 5280, 4.2.1.2)
+func buildSubjectKeyID(publicKey crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("error serializing public key: %v", err)
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err = asn1.Unmarshal(der, &spki)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing public key: %v", err)
+	}
+	hash := sha1.Sum(spki.PublicKey.Bytes)
+	return hash[:], nil
+}
+
+func (c *Certificate) WriteCertificate(w io.Writer) error {
 	return pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: c.Certificate.Raw})
 }
 
@@ -137,7 +254,21 @@ func WritePrivateKey(privateKey crypto.PrivateKey, w io.Writer) error {
 		return pem.Encode(w, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaPrivateKey)})
 	}
 
-	return fmt.Errorf("unknown private key type: %T", privateKey)
+	ecdsaPrivateKey, ok := privateKey.(*ecdsa.PrivateKey)
+	if ok {
+		data, err := x509.MarshalECPrivateKey(ecdsaPrivateKey)
+		if err != nil {
+			return fmt.Errorf("error serializing ECDSA private key: %v", err)
+		}
+		return pem.Encode(w, &pem.Block{Type: "EC PRIVATE KEY", Bytes: data})
+	}
+
+	// Anything else goes in the generic PKCS8 container
+	data, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("unknown private key type: %T", privateKey)
+	}
+	return pem.Encode(w, &pem.Block{Type: "PRIVATE KEY", Bytes: data})
 }
 
 func parsePEMPrivateKey(pemData []byte) (crypto.PrivateKey, error) {
@@ -150,6 +281,9 @@ func parsePEMPrivateKey(pemData []byte) (crypto.PrivateKey, error) {
 		if block.Type == "RSA PRIVATE KEY" {
 			glog.V(2).Infof("Parsing pem block: %q", block.Type)
 			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		} else if block.Type == "EC PRIVATE KEY" {
+			glog.V(2).Infof("Parsing pem block: %q", block.Type)
+			return x509.ParseECPrivateKey(block.Bytes)
 		} else if block.Type == "PRIVATE KEY" {
 			glog.V(2).Infof("Parsing pem block: %q", block.Type)
 			k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
@@ -163,4 +297,4 @@ func parsePEMPrivateKey(pemData []byte) (crypto.PrivateKey, error) {
 
 		pemData = rest
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,92 @@
+package fi
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"testing"
+)
+
+var testKeyAlgorithms = []KeyAlgorithm{KeyAlgorithmRSA2048, KeyAlgorithmECDSAP256, KeyAlgorithmECDSAP384}
+
+// privateKeysEqual compares the keys themselves; reflect.DeepEqual would also compare the cached precomputed values
+func privateKeysEqual(a, b crypto.PrivateKey) bool {
+	k, ok := a.(interface {
+		Equal(crypto.PrivateKey) bool
+	})
+	return ok && k.Equal(b)
+}
+
+// Keys must load as they were written, both in the format we write and in PKCS8 (as written by other tools)
+func TestPrivateKeyRoundTrip(t *testing.T) {
+	for _, algorithm := range testKeyAlgorithms {
+		privateKey, err := GeneratePrivateKey(algorithm)
+		if err != nil {
+			t.Fatalf("%s: error generating key: %v", algorithm, err)
+		}
+
+		var written bytes.Buffer
+		err = WritePrivateKey(privateKey, &written)
+		if err != nil {
+			t.Fatalf("%s: error writing key: %v", algorithm, err)
+		}
+
+		pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			t.Fatalf("%s: error encoding key as PKCS8: %v", algorithm, err)
+		}
+
+		encodings := map[string][]byte{
+			"native": written.Bytes(),
+			"pkcs8":  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+		}
+		for name, data := range encodings {
+			loaded, err := parsePEMPrivateKey(data)
+			if err != nil {
+				t.Fatalf("%s: error loading %s key: %v", algorithm, name, err)
+			}
+			if !privateKeysEqual(loaded, privateKey) {
+				t.Errorf("%s: %s key did not round-trip", algorithm, name)
+			}
+		}
+	}
+}
+
+// The master key signs the service account tokens, so is RSA whatever the cluster's KeyAlgorithm
+func TestCreatePrivateKeyAlgorithm(t *testing.T) {
+	castore, cleanup := newTestCAStore(t, KeyAlgorithmECDSAP256)
+	defer cleanup()
+
+	caKey, err := castore.FindCAKey()
+	if err != nil {
+		t.Fatalf("error loading CA key: %v", err)
+	}
+	if _, ok := caKey.(*ecdsa.PrivateKey); !ok {
+		t.Errorf("expected ECDSA CA key, got %T", caKey)
+	}
+
+	key, err := castore.CreatePrivateKey(&pkix.Name{CommonName: "kubelet"}, "")
+	if err != nil {
+		t.Fatalf("error creating key: %v", err)
+	}
+	if _, ok := key.(*ecdsa.PrivateKey); !ok {
+		t.Errorf("expected ECDSA key, got %T", key)
+	}
+
+	masterSubject := &pkix.Name{CommonName: "kubernetes-master"}
+	_, err = castore.CreatePrivateKey(masterSubject, ServiceAccountKeyAlgorithm)
+	if err != nil {
+		t.Fatalf("error creating key: %v", err)
+	}
+	key, err = castore.FindPrivateKey(masterSubject)
+	if err != nil {
+		t.Fatalf("error loading key: %v", err)
+	}
+	if _, ok := key.(*rsa.PrivateKey); !ok {
+		t.Errorf("expected RSA master key, got %T", key)
+	}
+}
@@ -0,0 +1,361 @@
+package fi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/golang/glog"
+)
+
+// Templates larger than this must be uploaded to S3, rather than passed in the API call
+const CloudFormationMaxTemplateBodySize = 51200
+
+// CloudFormationTarget renders units into a single CloudFormation template.
+// The template is either written to a file, or applied as a stack (giving us stack-level rollback).
+type CloudFormationTarget struct {
+	Cloud     *AWSCloud
+	filestore FileStore
+	stackName string
+	outPath   string
+
+	mutex      sync.Mutex
+	resources  map[string]*cloudformationResource
+	tags       map[string]map[string]interface{}
+	parameters map[string]*cloudformationParameter
+}
+
+type cloudformationResource struct {
+	Type       string                 `json:"Type"`
+	Properties map[string]interface{} `json:"Properties,omitempty"`
+	DependsOn  []string               `json:"DependsOn,omitempty"`
+}
+
+type cloudformationParameter struct {
+	Type        string `json:"Type"`
+	Default     string `json:"Default,omitempty"`
+	Description string `json:"Description,omitempty"`
+}
+
+type cloudformationTag struct {
+	Key   string      `json:"Key"`
+	Value interface{} `json:"Value"`
+}
+
+var _ Target = &CloudFormationTarget{}
+var _ DeclarativeTarget = &CloudFormationTarget{}
+
+// CloudFormationRenderer is implemented by units that can be rendered to a cloudformation template
+type CloudFormationRenderer interface {
+	RenderCloudformation(t *CloudFormationTarget, a, e, changes Unit) error
+}
+
+func init() {
+	RegisterRenderer(&CloudFormationTarget{}, (*CloudFormationRenderer)(nil))
+}
+
+// NewCloudFormationTarget builds a target for the named stack; if outPath is set the template is written there instead of being applied
+func NewCloudFormationTarget(cloud *AWSCloud, filestore FileStore, stackName string, outPath string) *CloudFormationTarget {
+	return &CloudFormationTarget{
+		Cloud:      cloud,
+		filestore:  filestore,
+		stackName:  stackName,
+		outPath:    outPath,
+		resources:  make(map[string]*cloudformationResource),
+		tags:       make(map[string]map[string]interface{}),
+		parameters: make(map[string]*cloudformationParameter),
+	}
+}
+
+func (t *CloudFormationTarget) PutResource(key string, r Resource, hashAlgorithm HashAlgorithm) (string, string, error) {
+	if r == nil {
+		glog.Fatalf("Attempt to put null resource for %q", key)
+	}
+	return t.filestore.PutResource(key, r, hashAlgorithm)
+}
+
+func (t *CloudFormationTarget) Render(a, e, changes Unit) error {
+	r, ok := e.(CloudFormationRenderer)
+	if !ok {
+		return fmt.Errorf("%T does not support the cloudformation target", e)
+	}
+	return r.RenderCloudformation(t, a, e, changes)
+}
+
+var cloudformationInvalidIDChars = regexp.MustCompile("[^a-zA-Z0-9]+")
+
+// CloudFormationLogicalID builds a (alphanumeric) logical ID from a resource type (e.g. AWS::EC2::VPC) and a unit key
+func CloudFormationLogicalID(resourceType, key string) string {
+	id := strings.TrimPrefix(resourceType, "AWS::")
+	id = cloudformationInvalidIDChars.ReplaceAllString(id, "")
+	for _, word := range cloudformationInvalidIDChars.Split(key, -1) {
+		if word == "" {
+			continue
+		}
+		id += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return id
+}
+
+// CloudFormationRef returns a Ref to another resource in the template
+func CloudFormationRef(resourceType, key string) interface{} {
+	return map[string]interface{}{
+		"Ref": CloudFormationLogicalID(resourceType, key),
+	}
+}
+
+// CloudFormationGetAtt returns a Fn::GetAtt for an attribute of another resource in the template
+func CloudFormationGetAtt(resourceType, key, attribute string) interface{} {
+	return map[string]interface{}{
+		"Fn::GetAtt": []string{CloudFormationLogicalID(resourceType, key), attribute},
+	}
+}
+
+// RenderResource adds a resource to the template; properties are serialized using their json tags.
+// As with terraform, rendering the same resource again merges the properties.
+func (t *CloudFormationTarget) RenderResource(resourceType, key string, properties interface{}, dependsOn ...string) error {
+	id := CloudFormationLogicalID(resourceType, key)
+
+	data, err := json.Marshal(properties)
+	if err != nil {
+		return fmt.Errorf("error serializing cloudformation resource %s: %v", id, err)
+	}
+	attributes := make(map[string]interface{})
+	err = json.Unmarshal(data, &attributes)
+	if err != nil {
+		return fmt.Errorf("error serializing cloudformation resource %s: %v", id, err)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	existing := t.resources[id]
+	if existing == nil {
+		t.resources[id] = &cloudformationResource{
+			Type:       resourceType,
+			Properties: attributes,
+			DependsOn:  dependsOn,
+		}
+		return nil
+	}
+	if existing.Type != resourceType {
+		return fmt.Errorf("cloudformation logical ID %s is used by %s and %s", id, existing.Type, resourceType)
+	}
+	existing.DependsOn = append(existing.DependsOn, dependsOn...)
+	return mergeAttributes(existing.Properties, attributes, id)
+}
+
+// AddTags adds tags to a resource in the template
+func (t *CloudFormationTarget) AddTags(resourceType, key string, tags map[string]string) {
+	for k, v := range tags {
+		t.AddTag(resourceType, key, k, v)
+	}
+}
+
+// AddTag adds a tag to a resource in the template; the value can be an intrinsic function (e.g. a Ref)
+func (t *CloudFormationTarget) AddTag(resourceType, key string, tagKey string, value interface{}) {
+	id := CloudFormationLogicalID(resourceType, key)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	m := t.tags[id]
+	if m == nil {
+		m = make(map[string]interface{})
+		t.tags[id] = m
+	}
+	m[tagKey] = value
+}
+
+// AddParameter declares a string parameter for the template, returning a Ref to it.
+// Parameters let the stack be updated (e.g. to a new image) without re-rendering the template.
+func (t *CloudFormationTarget) AddParameter(name string, defaultValue string, description string) (interface{}, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	existing := t.parameters[name]
+	if existing != nil && existing.Default != defaultValue {
+		return nil, fmt.Errorf("conflicting values for cloudformation parameter %s: %q and %q", name, existing.Default, defaultValue)
+	}
+	t.parameters[name] = &cloudformationParameter{
+		Type:        "String",
+		Default:     defaultValue,
+		Description: description,
+	}
+	return map[string]interface{}{"Ref": name}, nil
+}
+
+func (t *CloudFormationTarget) buildTemplate() ([]byte, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for id, tags := range t.tags {
+		r := t.resources[id]
+		if r == nil {
+			return nil, fmt.Errorf("tags added to cloudformation resource %s, which was not rendered", id)
+		}
+		var keys []string
+		for k := range tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var cfTags []*cloudformationTag
+		for _, k := range keys {
+			cfTags = append(cfTags, &cloudformationTag{Key: k, Value: tags[k]})
+		}
+		r.Properties["Tags"] = cfTags
+	}
+
+	template := make(map[string]interface{})
+	template["AWSTemplateFormatVersion"] = "2010-09-09"
+	template["Description"] = "Kubernetes cluster " + t.stackName
+	if len(t.parameters) != 0 {
+		template["Parameters"] = t.parameters
+	}
+	template["Resources"] = t.resources
+
+	data, err := json.MarshalIndent(template, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error serializing cloudformation template: %v", err)
+	}
+	return data, nil
+}
+
+// Finish writes the template to disk, or creates / updates the stack
+func (t *CloudFormationTarget) Finish() error {
+	template, err := t.buildTemplate()
+	if err != nil {
+		return err
+	}
+
+	if t.outPath != "" {
+		err := os.MkdirAll(path.Dir(t.outPath), 0755)
+		if err != nil {
+			return fmt.Errorf("error creating directory %q: %v", path.Dir(t.outPath), err)
+		}
+		// The template includes the user-data, which contains secrets
+		err = ioutil.WriteFile(t.outPath, template, 0600)
+		if err != nil {
+			return fmt.Errorf("error writing cloudformation template %q: %v", t.outPath, err)
+		}
+		glog.Infof("Wrote cloudformation template to %q", t.outPath)
+		return nil
+	}
+
+	return t.applyStack(template)
+}
+
+func (t *CloudFormationTarget) applyStack(template []byte) error {
+	var templateBody, templateURL *string
+	if len(template) > CloudFormationMaxTemplateBodySize {
+		glog.V(2).Infof("Template is %d bytes; uploading", len(template))
+		url, _, err := t.filestore.PutResource("cloudformation/"+t.stackName+".json", NewBytesResource(template), HashAlgorithmSHA256)
+		if err != nil {
+			return fmt.Errorf("error uploading cloudformation template: %v", err)
+		}
+		templateURL = aws.String(url)
+	} else {
+		templateBody = aws.String(string(template))
+	}
+
+	var parameters []*cloudformation.Parameter
+	for k, p := range t.parameters {
+		parameters = append(parameters, &cloudformation.Parameter{
+			ParameterKey:   aws.String(k),
+			ParameterValue: aws.String(p.Default),
+		})
+	}
+
+	var tags []*cloudformation.Tag
+	for k, v := range t.Cloud.Tags() {
+		tags = append(tags, &cloudformation.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	// We name the IAM roles
+	capabilities := []*string{aws.String(cloudformation.CapabilityCapabilityNamedIam)}
+
+	exists, err := t.stackExists()
+	if err != nil {
+		return err
+	}
+
+	stackName := aws.String(t.stackName)
+	if !exists {
+		glog.Infof("Creating cloudformation stack %q", t.stackName)
+		request := &cloudformation.CreateStackInput{
+			StackName:    stackName,
+			TemplateBody: templateBody,
+			TemplateURL:  templateURL,
+			Parameters:   parameters,
+			Tags:         tags,
+			Capabilities: capabilities,
+			OnFailure:    aws.String(cloudformation.OnFailureRollback),
+		}
+		_, err := t.Cloud.CloudFormation.CreateStack(request)
+		if err != nil {
+			return fmt.Errorf("error creating cloudformation stack: %v", err)
+		}
+
+		glog.Infof("Waiting for cloudformation stack %q to be created", t.stackName)
+		err = t.Cloud.CloudFormation.WaitUntilStackCreateComplete(&cloudformation.DescribeStacksInput{StackName: stackName})
+		if err != nil {
+			return fmt.Errorf("error waiting for cloudformation stack creation (stack will be rolled back): %v", err)
+		}
+		return nil
+	}
+
+	glog.Infof("Updating cloudformation stack %q", t.stackName)
+	request := &cloudformation.UpdateStackInput{
+		StackName:    stackName,
+		TemplateBody: templateBody,
+		TemplateURL:  templateURL,
+		Parameters:   parameters,
+		Tags:         tags,
+		Capabilities: capabilities,
+	}
+	_, err = t.Cloud.CloudFormation.UpdateStack(request)
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && strings.Contains(awsErr.Message(), "No updates are to be performed") {
+			glog.Infof("No changes to cloudformation stack %q", t.stackName)
+			return nil
+		}
+		return fmt.Errorf("error updating cloudformation stack: %v", err)
+	}
+
+	glog.Infof("Waiting for cloudformation stack %q to be updated", t.stackName)
+	err = t.Cloud.CloudFormation.WaitUntilStackUpdateComplete(&cloudformation.DescribeStacksInput{StackName: stackName})
+	if err != nil {
+		return fmt.Errorf("error waiting for cloudformation stack update: %v", err)
+	}
+	return nil
+}
+
+func (t *CloudFormationTarget) stackExists() (bool, error) {
+	request := &cloudformation.DescribeStacksInput{
+		StackName: aws.String(t.stackName),
+	}
+	response, err := t.Cloud.CloudFormation.DescribeStacks(request)
+	if err != nil {
+		// A missing stack is reported as a validation error
+		if awsErr, ok := err.(awserr.Error); ok && strings.Contains(awsErr.Message(), "does not exist") {
+			return false, nil
+		}
+		return false, fmt.Errorf("error describing cloudformation stack %q: %v", t.stackName, err)
+	}
+	for _, s := range response.Stacks {
+		if aws.StringValue(s.StackStatus) == cloudformation.StackStatusDeleteComplete {
+			continue
+		}
+		return true, nil
+	}
+	return false, nil
+}
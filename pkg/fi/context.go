@@ -1,16 +1,21 @@
 package fi
 
+import "sync"
+
 type Context struct {
-	roles     []string
-	state     map[string]interface{}
+	roles      []string
+	stateMutex sync.Mutex
+	state      map[string]interface{}
 
 	//os        *OS
-	cloud     Cloud
-	castore   CAStore
+	cloud   Cloud
+	castore CAStore
 	//config    Config
 	resources *ResourcesList
 
-	root      *node
+	root *node
+	// nodes holds every unit node, in the order they were added
+	nodes []*node
 }
 
 //type Context struct {
@@ -26,13 +31,12 @@ type Context struct {
 //	return c
 //}
 
-
 func NewContext(cloud Cloud, castore CAStore) (*Context, error) {
 	c := &Context{
-		state:     make(map[string]interface{}),
+		state: make(map[string]interface{}),
 		//os:        &OS{},
 		cloud:     cloud,
-		castore: castore,
+		castore:   castore,
 		resources: &ResourcesList{},
 	}
 
@@ -49,16 +53,23 @@ func NewContext(cloud Cloud, castore CAStore) (*Context, error) {
 func (c *Context) NewRunContext(target Target, runMode RunMode) *RunContext {
 	rc := &RunContext{
 		Context: c,
-		Target: target,
+		Target:  target,
 		node:    c.root,
 		mode:    runMode,
 	}
+	if runMode == ModeValidate {
+		// Only changes are recorded in the drift; resources are put to the target
+		drift, _ := NewDryRunTarget(nil)
+		rc.validation = &validation{drift: drift}
+	}
 	return rc
 }
 
-func (c *Context) NewBuildContext() *BuildContext {
+// NewBuildContext builds a context for adding units; units are checked against the target they will be rendered to
+func (c *Context) NewBuildContext(target Target) *BuildContext {
 	bc := &BuildContext{
 		Context: c,
+		target:  target,
 		node:    c.root,
 	}
 	return bc
@@ -89,7 +100,12 @@ func (c *Context) CAStore() CAStore {
 	return c.castore
 }
 
+// GetState returns the value stored for key, calling builder to create it if it has not yet been built.
+// It is safe for concurrent use, but builder must not itself call GetState.
 func (c *Context) GetState(key string, builder func() (interface{}, error)) (interface{}, error) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+
 	v := c.state[key]
 	if v == nil {
 		var err error
@@ -106,6 +122,7 @@ func (c *Context) newNode(unit Unit) *node {
 	childNode := &node{
 		unit: unit,
 	}
+	c.nodes = append(c.nodes, childNode)
 
 	return childNode
 }
@@ -0,0 +1,208 @@
+package fi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// HasDependencies can be implemented by units that depend on units which are not reachable through their fields
+type HasDependencies interface {
+	GetDependencies() []Unit
+}
+
+// buildDependencies computes the dependencies of every node.
+// A node depends on its parent builder, and on any registered unit referenced from its exported fields
+// (directly, through slices or maps, or through embedded structs)
+func (c *Context) buildDependencies() {
+	byUnit := make(map[Unit]*node)
+	for _, n := range c.nodes {
+		byUnit[n.unit] = n
+	}
+
+	for _, n := range c.nodes {
+		deps := make(map[*node]bool)
+		var ordered []*node
+		add := func(d *node) {
+			if d == nil || d == n || deps[d] {
+				return
+			}
+			deps[d] = true
+			ordered = append(ordered, d)
+		}
+
+		if n.parent != nil && n.parent.unit != nil {
+			add(n.parent)
+		}
+
+		for _, u := range findUnitReferences(n.unit) {
+			d := byUnit[u]
+			if d == nil {
+				glog.V(4).Infof("ignoring reference from %v to unregistered unit %v", n.unit, u)
+				continue
+			}
+			add(d)
+		}
+
+		if hd, ok := n.unit.(HasDependencies); ok {
+			for _, u := range hd.GetDependencies() {
+				d := byUnit[u]
+				if d == nil {
+					glog.Exitf("unit %v depends on unregistered unit %v", n.unit, u)
+				}
+				add(d)
+			}
+		}
+
+		n.dependencies = ordered
+	}
+}
+
+// findUnitReferences returns the units referenced from the fields of the unit
+func findUnitReferences(unit Unit) []Unit {
+	var refs []Unit
+	v := reflect.ValueOf(unit)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	visitStructFields(v, func(u Unit) {
+		refs = append(refs, u)
+	})
+	return refs
+}
+
+func visitStructFields(v reflect.Value, visitor func(Unit)) {
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			// Unexported field
+			continue
+		}
+		visitValue(v.Field(i), visitor)
+	}
+}
+
+func visitValue(v reflect.Value, visitor func(Unit)) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		if !v.CanInterface() {
+			return
+		}
+		if u, ok := v.Interface().(Unit); ok {
+			visitor(u)
+			return
+		}
+		if v.Kind() == reflect.Interface {
+			visitValue(v.Elem(), visitor)
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			visitValue(v.Index(i), visitor)
+		}
+
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			visitValue(v.MapIndex(k), visitor)
+		}
+
+	case reflect.Struct:
+		visitStructFields(v, visitor)
+	}
+}
+
+// buildExecutionOrder returns the nodes sorted so that every node comes after its dependencies.
+// Where there is a choice, nodes are kept in the order in which they were added.
+func (c *Context) buildExecutionOrder() ([]*node, error) {
+	c.buildDependencies()
+
+	done := make(map[*node]bool)
+	var order []*node
+
+	for len(order) < len(c.nodes) {
+		progress := false
+		for _, n := range c.nodes {
+			if done[n] || !n.isReady(done) {
+				continue
+			}
+			done[n] = true
+			order = append(order, n)
+			progress = true
+			// Restart from the beginning, so that we prefer insertion order
+			break
+		}
+
+		if !progress {
+			var remaining []*node
+			for _, n := range c.nodes {
+				if !done[n] {
+					remaining = append(remaining, n)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle detected: %s", describeCycle(remaining))
+		}
+	}
+
+	return order, nil
+}
+
+func (n *node) isReady(done map[*node]bool) bool {
+	for _, d := range n.dependencies {
+		if !done[d] {
+			return false
+		}
+	}
+	return true
+}
+
+// describeCycle finds a cycle amongst nodes that could not be scheduled, and formats it for an error message
+func describeCycle(remaining []*node) string {
+	blocked := make(map[*node]bool)
+	for _, n := range remaining {
+		blocked[n] = true
+	}
+
+	// Every remaining node has at least one remaining dependency, so following them must eventually loop
+	n := remaining[0]
+	visited := make(map[*node]int)
+	var path []*node
+	for {
+		if i, found := visited[n]; found {
+			path = append(path[i:], n)
+			break
+		}
+		visited[n] = len(path)
+		path = append(path, n)
+
+		var next *node
+		for _, d := range n.dependencies {
+			if blocked[d] {
+				next = d
+				break
+			}
+		}
+		if next == nil {
+			// Should not happen
+			return n.String()
+		}
+		n = next
+	}
+
+	var names []string
+	for _, p := range path {
+		names = append(names, p.String())
+	}
+	return strings.Join(names, " -> ")
+}
@@ -3,10 +3,13 @@ package fi
 import (
 	"fmt"
 
+	"bytes"
 	"github.com/golang/glog"
 	"io"
-	"bytes"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 )
 
 type putResource struct {
@@ -22,14 +25,18 @@ type render struct {
 }
 
 type DryRunTarget struct {
+	// filestore computes the URLs of the resources, so that the user-data is the same as it would be when applied
+	filestore FileStore
+
+	mutex        sync.Mutex
 	putResources map[string]*putResource
 	changes      []*render
 }
 
 var _ Target = &DryRunTarget{}
 
-func NewDryRunTarget() (*DryRunTarget, error) {
-	t := &DryRunTarget{}
+func NewDryRunTarget(filestore FileStore) (*DryRunTarget, error) {
+	t := &DryRunTarget{filestore: filestore}
 	t.putResources = make(map[string]*putResource)
 	return t, nil
 }
@@ -38,13 +45,19 @@ func (t *DryRunTarget) PutResource(key string, r Resource, hashAlgorithm HashAlg
 	if r == nil {
 		glog.Fatalf("Attempt to put null resource for %q", key)
 	}
-	url := "dryrun://" + key
-	hash, err := HashForResource(r, hashAlgorithm)
+	if t.filestore == nil {
+		return "", "", fmt.Errorf("cannot put resource %q: dry-run target has no file store", key)
+	}
+	// Resources are not uploaded, but we compute the URL they would have
+	url, hash, err := t.filestore.ResourceURL(key, r, hashAlgorithm)
 	if err != nil {
-		return "", "", fmt.Errorf("error hashing resource %q: %v", key, err)
+		return "", "", fmt.Errorf("error computing URL for resource %q: %v", key, err)
 	}
-	t.putResources[key + ":" + hash] = &putResource{
-		Key: key,
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.putResources[key+":"+hash] = &putResource{
+		Key:  key,
 		Hash: hash,
 	}
 
@@ -55,91 +68,179 @@ func (t *DryRunTarget) Render(a, e, changes Unit) error {
 	valA := reflect.ValueOf(a)
 	aIsNil := valA.IsNil()
 
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
 	t.changes = append(t.changes, &render{
-		a: a,
-		aIsNil:aIsNil,
-		e: e,
+		a:       a,
+		aIsNil:  aIsNil,
+		e:       e,
 		changes: changes,
 	})
 	return nil
 }
 
-func (t*DryRunTarget) PrintReport(out io.Writer) error {
+// BuildPlan returns the changes & uploads as a Plan, sorted so that plans can be compared between runs
+func (t *DryRunTarget) BuildPlan() (*Plan, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	plan := &Plan{}
+
+	for _, r := range t.putResources {
+		plan.Resources = append(plan.Resources, &PlanResource{Key: r.Key, Hash: r.Hash})
+	}
+	sort.Sort(planResourcesByKey(plan.Resources))
+
+	for _, r := range t.changes {
+		change := &PlanChange{
+			Path: r.e.Path(),
+			Type: GetTypeName(r.e),
+		}
+		if r.aIsNil {
+			change.Action = PlanActionCreate
+		} else {
+			change.Action = PlanActionUpdate
+		}
+
+		fields, err := buildFieldChanges(r)
+		if err != nil {
+			return nil, err
+		}
+		change.Fields = fields
+		if change.Action == PlanActionUpdate && len(fields) == 0 {
+			// Only ignored fields (e.g. references to other units) differ
+			change.Action = PlanActionNoop
+		}
+
+		plan.Changes = append(plan.Changes, change)
+	}
+	sort.Sort(planChangesByPath(plan.Changes))
+
+	return plan, nil
+}
+
+func buildFieldChanges(r *render) ([]*PlanFieldChange, error) {
+	var fields []*PlanFieldChange
+
+	valC := reflect.ValueOf(r.changes)
+	valA := reflect.ValueOf(r.a)
+	if valC.Kind() == reflect.Ptr && !valC.IsNil() {
+		valC = valC.Elem()
+	}
+	if valA.Kind() == reflect.Ptr && !valA.IsNil() {
+		valA = valA.Elem()
+	}
+	if valC.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("unhandled change type: %v", valC.Type())
+	}
+
+	for i := 0; i < valC.NumField(); i++ {
+		fieldValC := valC.Field(i)
+		if (fieldValC.Kind() == reflect.Ptr || fieldValC.Kind() == reflect.Interface || fieldValC.Kind() == reflect.Map || fieldValC.Kind() == reflect.Slice) && fieldValC.IsNil() {
+			// No change
+			continue
+		}
+		if fieldValC.Kind() == reflect.Struct && fieldValC.CanInterface() && reflect.DeepEqual(fieldValC.Interface(), reflect.Zero(fieldValC.Type()).Interface()) {
+			// No change (to an embedded struct)
+			continue
+		}
+		field := &PlanFieldChange{Name: valC.Type().Field(i).Name}
+		if fieldValC.CanInterface() {
+			if _, ok := fieldValC.Interface().(SimpleUnit); ok {
+				continue
+			}
+			field.New = asString(fieldValC)
+			if !r.aIsNil {
+				old := asString(valA.Field(i))
+				field.Old = &old
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+type planResourcesByKey []*PlanResource
+
+func (a planResourcesByKey) Len() int {
+	return len(a)
+}
+func (a planResourcesByKey) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a planResourcesByKey) Less(i, j int) bool {
+	if a[i].Key != a[j].Key {
+		return a[i].Key < a[j].Key
+	}
+	return a[i].Hash < a[j].Hash
+}
+
+type planChangesByPath []*PlanChange
+
+func (a planChangesByPath) Len() int {
+	return len(a)
+}
+func (a planChangesByPath) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a planChangesByPath) Less(i, j int) bool {
+	return a[i].Path < a[j].Path
+}
+
+// PrintPlan writes the plan in a machine-readable format (json or yaml)
+func (t *DryRunTarget) PrintPlan(out io.Writer, format string) error {
+	plan, err := t.BuildPlan()
+	if err != nil {
+		return err
+	}
+	return WritePlan(out, plan, format)
+}
+
+func (t *DryRunTarget) PrintReport(out io.Writer) error {
+	plan, err := t.BuildPlan()
+	if err != nil {
+		return err
+	}
+
 	b := &bytes.Buffer{}
 
-	if len(t.putResources) != 0 {
+	if len(plan.Resources) != 0 {
 		fmt.Fprintf(b, "Upload resources:\n")
-		for _, r := range t.putResources {
+		for _, r := range plan.Resources {
 			fmt.Fprintf(b, "  %s\t%s\n", r.Key, r.Hash)
 		}
 	}
 
-	if len(t.changes) != 0 {
+	if len(plan.Changes) != 0 {
 		fmt.Fprintf(b, "Created resources:\n")
-		for _, r := range t.changes {
-			if !r.aIsNil {
+		for _, c := range plan.Changes {
+			if c.Action != PlanActionCreate {
 				continue
 			}
 
-			fmt.Fprintf(b, "  %T\t%s\n", r.changes, r.e.Path())
+			fmt.Fprintf(b, "  %s\t%s\n", c.Type, c.Path)
 		}
 
 		fmt.Fprintf(b, "Changed resources:\n")
-		for _, r := range t.changes {
-			if r.aIsNil {
+		for _, c := range plan.Changes {
+			if c.Action != PlanActionUpdate {
 				continue
 			}
-			var changeList []string
 
-			valC := reflect.ValueOf(r.changes)
-			valA := reflect.ValueOf(r.a)
-			if valC.Kind() == reflect.Ptr && !valC.IsNil() {
-				valC = valC.Elem()
-			}
-			if valA.Kind() == reflect.Ptr && !valA.IsNil() {
-				valA = valA.Elem()
-			}
-			if valC.Kind() == reflect.Struct {
-				for i := 0; i < valC.NumField(); i++ {
-					fieldValC := valC.Field(i)
-					if fieldValC.Kind() == reflect.Ptr && fieldValC.IsNil() {
-						// No change
-						continue
-					}
-					description := ""
-					ignored := false
-					if fieldValC.CanInterface() {
-						fieldValA := valA.Field(i)
-
-						switch fieldValC.Interface().(type) {
-						case SimpleUnit:
-							ignored = true
-						default:
-							description = fmt.Sprintf(" %v -> %v", asString(fieldValA), asString(fieldValC))
-						}
-					}
-					if ignored {
-						continue
-					}
-					changeList = append(changeList, valC.Type().Field(i).Name + description)
+			fmt.Fprintf(b, "  %s\t%s\n", c.Type, c.Path)
+			for _, f := range c.Fields {
+				if f.Old != nil {
+					fmt.Fprintf(b, "    %s %s -> %s\n", f.Name, *f.Old, f.New)
+				} else {
+					fmt.Fprintf(b, "    %s\n", f.Name)
 				}
-			} else {
-				return fmt.Errorf("unhandled change type: %v", valC.Type())
-			}
-
-			if len(changeList) == 0 {
-				continue
-			}
-
-			fmt.Fprintf(b, "  %T\t%s\n", r.changes, r.e.Path())
-			for _, f := range changeList {
-				fmt.Fprintf(b, "    %s\n", f)
 			}
 			fmt.Fprintf(b, "\n")
 		}
 	}
 
-	_, err := out.Write(b.Bytes())
+	_, err = out.Write(b.Bytes())
 	return err
 }
 
@@ -155,9 +256,23 @@ func asString(v reflect.Value) string {
 	}
 	if v.CanInterface() {
 		iv := v.Interface()
-		_, isResource := iv.(Resource)
+		r, isResource := iv.(Resource)
 		if isResource {
-			return "<resource>"
+			if IsKnownAfterApply(r) {
+				return PlanValueKnownAfterApply
+			}
+			// Include the hash, so that saved plans capture changes to the contents
+			hash, err := HashForResource(r, HashAlgorithmSHA256)
+			if err != nil {
+				glog.Warningf("error hashing resource: %v", err)
+				return "<resource>"
+			}
+			return "<resource sha256:" + hash + ">"
+		}
+		// References to other units are recorded by path, as the ID of a unit that will be created is only
+		// known when the plan is applied.  (Units that were found in the cloud have no path.)
+		if u, isUnit := iv.(Unit); isUnit && u.Path() != "" {
+			return "ref:" + u.Path()
 		}
 		_, isHasID := iv.(HasID)
 		if isHasID {
@@ -171,6 +286,24 @@ func asString(v reflect.Value) string {
 		switch iv.(type) {
 		case *string:
 			return *(iv.(*string))
+		}
+
+		// Print values rather than pointers, so that plans can be compared between runs
+		switch v.Kind() {
+		case reflect.Ptr:
+			return asString(v.Elem())
+		case reflect.Slice:
+			var elems []string
+			for i := 0; i < v.Len(); i++ {
+				elems = append(elems, asString(v.Index(i)))
+			}
+			return "[" + strings.Join(elems, ",") + "]"
+		case reflect.Struct:
+			var fields []string
+			for i := 0; i < v.NumField(); i++ {
+				fields = append(fields, v.Type().Field(i).Name+":"+asString(v.Field(i)))
+			}
+			return "{" + strings.Join(fields, " ") + "}"
 		default:
 			return fmt.Sprintf("%T (%v)", iv, iv)
 		}
@@ -179,4 +312,4 @@ func asString(v reflect.Value) string {
 		return fmt.Sprintf("Unhandled: %T", v.Type())
 
 	}
-}
\ No newline at end of file
+}
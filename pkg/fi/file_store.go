@@ -2,4 +2,7 @@ package fi
 
 type FileStore interface {
 	PutResource(key string, resource Resource, hashAlgorithm HashAlgorithm) (url string, hash string, err error)
-}
\ No newline at end of file
+
+	// ResourceURL returns the URL & hash that PutResource would return, without uploading the resource
+	ResourceURL(key string, resource Resource, hashAlgorithm HashAlgorithm) (url string, hash string, err error)
+}
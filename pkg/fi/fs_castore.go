@@ -1,38 +1,50 @@
 package fi
 
 import (
+	"bytes"
 	"crypto"
-	crypto_rand "crypto/rand"
-	"crypto/x509/pkix"
 	"crypto/x509"
-	"path"
-	"os"
-	"io/ioutil"
-	"crypto/rsa"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
-	"bytes"
 	"github.com/golang/glog"
+	"path"
+	"sort"
+	"sync"
+	"time"
 )
 
+// FilesystemCAStore stores the CA and the issued certificates & keys as files in a StateStore, under basedir
 type FilesystemCAStore struct {
-	basedir       string
+	store        StateStore
+	basedir      string
+	keyAlgorithm KeyAlgorithm
+	// keyManager, if set, encrypts the private keys at rest
+	keyManager KeyManager
+
+	mutex sync.Mutex
+	// plaintextKeys holds the keys that were loaded unencrypted, though we have a keyManager, by path
+	plaintextKeys map[string]crypto.PrivateKey
+
 	caCertificate *Certificate
 	caPrivateKey  crypto.PrivateKey
 }
 
 var _ CAStore = &FilesystemCAStore{}
 
-func NewCAStore(basedir string) (CAStore, error) {
-	c := &FilesystemCAStore{
-		basedir: basedir,
-	}
-	err := os.MkdirAll(path.Join(basedir, "private"), 0700)
+// NewCAStore builds a CAStore; keyAlgorithm is used for keys we generate, existing keys are loaded whatever their type.
+// If keyManager is not nil, private keys are stored encrypted; existing plaintext keys are encrypted by EncryptPlaintextKeys.
+func NewCAStore(store StateStore, basedir string, keyAlgorithm KeyAlgorithm, keyManager KeyManager) (CAStore, error) {
+	err := keyAlgorithm.Validate()
 	if err != nil {
-		return nil, fmt.Errorf("error creating directory: %v", err)
+		return nil, err
 	}
-	err = os.MkdirAll(path.Join(basedir, "issued"), 0700)
-	if err != nil {
-		return nil, fmt.Errorf("error creating directory: %v", err)
+	c := &FilesystemCAStore{
+		store:         store,
+		basedir:       basedir,
+		keyAlgorithm:  keyAlgorithm,
+		keyManager:    keyManager,
+		plaintextKeys: make(map[string]crypto.PrivateKey),
 	}
 	caCertificate, err := c.loadCertificate(path.Join(basedir, "ca.crt"))
 	if err != nil {
@@ -45,7 +57,7 @@ func NewCAStore(basedir string) (CAStore, error) {
 			return nil, err
 		}
 		if caPrivateKey == nil {
-			glog.Warningf("CA private key was not found %q", privateKeyPath)
+			glog.Warningf("CA private key was not found %q", store.Location(privateKeyPath))
 			//return nil, fmt.Errorf("error loading CA private key - key not found")
 		}
 		c.caCertificate = caCertificate
@@ -59,52 +71,171 @@ func NewCAStore(basedir string) (CAStore, error) {
 	return c, nil
 }
 
-func (c*FilesystemCAStore) generateCACertificate() error {
+func (c *FilesystemCAStore) generateCACertificate() error {
+	caCertificate, caPrivateKey, err := c.buildCA("ca", "kubernetes")
+	if err != nil {
+		return err
+	}
+
+	c.caPrivateKey = caPrivateKey
+	c.caCertificate = caCertificate
+	return nil
+}
+
+// buildCA generates a CA key & certificate, and stores them as name.crt & private/name.key
+func (c *FilesystemCAStore) buildCA(name string, commonName string) (*Certificate, crypto.PrivateKey, error) {
 	subject := &pkix.Name{
-		CommonName: "kubernetes",
+		CommonName: commonName,
 	}
 	template := &x509.Certificate{
-		Subject: *subject,
-		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
-		ExtKeyUsage: []x509.ExtKeyUsage{},
+		Subject:               *subject,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{},
 		BasicConstraintsValid: true,
-		IsCA: true,
+		IsCA:                  true,
 	}
 
-	caPrivateKey, err := rsa.GenerateKey(crypto_rand.Reader, 2048)
+	caPrivateKey, err := GeneratePrivateKey(c.keyAlgorithm)
 	if err != nil {
-		return fmt.Errorf("error generating RSA private key: %v", err)
+		return nil, nil, err
 	}
 
 	caCertificate, err := SignNewCertificate(caPrivateKey, template, nil, nil)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	keyPath := path.Join(c.basedir, "private", "ca.key")
+	keyPath := path.Join(c.basedir, "private", name+".key")
 	err = c.storePrivateKey(caPrivateKey, keyPath)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	certPath := path.Join(c.basedir, "ca.crt")
+	certPath := path.Join(c.basedir, name+".crt")
 	err = c.storeCertificate(caCertificate, certPath)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	// Make double-sure it round-trips
 	caCertificate, err = c.loadCertificate(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return caCertificate, caPrivateKey, nil
+}
+
+// During a CA rotation, the next CA is stored as ca-next, and after it is promoted the old CA certificate is kept as ca-previous
+func (c *FilesystemCAStore) rotationPath(name string) string {
+	return path.Join(c.basedir, name+".crt")
+}
+
+func (c *FilesystemCAStore) GetCARotationPhase() (CARotationPhase, error) {
+	next, err := c.loadCertificate(c.rotationPath("ca-next"))
+	if err != nil {
+		return CARotationNone, err
+	}
+	if next != nil {
+		return CARotationStarted, nil
+	}
+	previous, err := c.loadCertificate(c.rotationPath("ca-previous"))
+	if err != nil {
+		return CARotationNone, err
+	}
+	if previous != nil {
+		return CARotationPromoted, nil
+	}
+	return CARotationNone, nil
+}
+
+func (c *FilesystemCAStore) GetTrustedCACerts() ([]*Certificate, error) {
+	certs := []*Certificate{c.caCertificate}
+	for _, name := range []string{"ca-next", "ca-previous"} {
+		cert, err := c.loadCertificate(c.rotationPath(name))
+		if err != nil {
+			return nil, err
+		}
+		if cert != nil {
+			certs = append(certs, cert)
+		}
+	}
+	return certs, nil
+}
+
+func (c *FilesystemCAStore) StartCARotation() error {
+	phase, err := c.GetCARotationPhase()
 	if err != nil {
 		return err
 	}
+	if phase != CARotationNone {
+		return fmt.Errorf("CA rotation is already in progress (phase %q)", phase)
+	}
 
-	c.caPrivateKey = caPrivateKey
-	c.caCertificate = caCertificate
-	return nil
+	// During a CA rotation both CAs are trusted, so the next CA needs a distinct name for verifiers to tell them apart
+	_, _, err = c.buildCA("ca-next", fmt.Sprintf("kubernetes-%d", time.Now().Unix()))
+	return err
+}
+
+func (c *FilesystemCAStore) PromoteNextCA() error {
+	phase, err := c.GetCARotationPhase()
+	if err != nil {
+		return err
+	}
+	if phase != CARotationStarted {
+		return fmt.Errorf("CA rotation has not been started")
+	}
+
+	nextCert, err := c.loadCertificate(c.rotationPath("ca-next"))
+	if err != nil {
+		return err
+	}
+	nextKeyPath := path.Join(c.basedir, "private", "ca-next.key")
+	nextKey, err := c.loadPrivateKey(nextKeyPath)
+	if err != nil {
+		return err
+	}
+	if nextKey == nil {
+		return fmt.Errorf("next CA private key was not found %q", c.store.Location(nextKeyPath))
+	}
+
+	// The files are written in an order that means we can just re-run this if we are interrupted
+	if !bytes.Equal(c.caCertificate.Certificate.Raw, nextCert.Certificate.Raw) {
+		err = c.storeCertificate(c.caCertificate, c.rotationPath("ca-previous"))
+		if err != nil {
+			return err
+		}
+		err = c.storePrivateKey(nextKey, path.Join(c.basedir, "private", "ca.key"))
+		if err != nil {
+			return err
+		}
+		err = c.storeCertificate(nextCert, path.Join(c.basedir, "ca.crt"))
+		if err != nil {
+			return err
+		}
+	}
+	c.caCertificate = nextCert
+	c.caPrivateKey = nextKey
+
+	err = c.store.DeleteFile(c.rotationPath("ca-next"))
+	if err != nil {
+		return err
+	}
+	return c.store.DeleteFile(nextKeyPath)
+}
+
+func (c *FilesystemCAStore) FinishCARotation() error {
+	phase, err := c.GetCARotationPhase()
+	if err != nil {
+		return err
+	}
+	if phase != CARotationPromoted {
+		return fmt.Errorf("the next CA has not been promoted")
+	}
+	return c.store.DeleteFile(c.rotationPath("ca-previous"))
 }
 
-func (c*FilesystemCAStore) getSubjectKey(subject *pkix.Name) string {
+func (c *FilesystemCAStore) getSubjectKey(subject *pkix.Name) string {
 	seq := subject.ToRDNSequence()
 	var s bytes.Buffer
 	for _, rdnSet := range seq {
@@ -139,14 +270,14 @@ func (c*FilesystemCAStore) getSubjectKey(subject *pkix.Name) string {
 	return s.String()
 }
 
-func (c*FilesystemCAStore) buildCertificatePath(subject *pkix.Name) string {
+func (c *FilesystemCAStore) buildCertificatePath(subject *pkix.Name) string {
 	key := c.getSubjectKey(subject)
-	return path.Join(c.basedir, "issued", key + ".crt")
+	return path.Join(c.basedir, "issued", key+".crt")
 }
 
-func (c*FilesystemCAStore) buildPrivateKeyPath(subject *pkix.Name) string {
+func (c *FilesystemCAStore) buildPrivateKeyPath(subject *pkix.Name) string {
 	key := c.getSubjectKey(subject)
-	return path.Join(c.basedir, "private", key + ".key")
+	return path.Join(c.basedir, "private", key+".key")
 }
 
 func (c *FilesystemCAStore) GetCACert() (*Certificate, error) {
@@ -158,11 +289,12 @@ func (c *FilesystemCAStore) FindCAKey() (crypto.PrivateKey, error) {
 }
 
 func (c *FilesystemCAStore) loadCertificate(p string) (*Certificate, error) {
-	data, err := ioutil.ReadFile(p)
+	data, err := c.store.ReadFile(p)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
-		}
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
 	}
 	cert, err := LoadCertificate(data)
 	if err != nil {
@@ -197,30 +329,81 @@ func (c *FilesystemCAStore) IssueCert(privateKey crypto.PrivateKey, template *x5
 }
 
 func (c *FilesystemCAStore) loadPrivateKey(p string) (crypto.PrivateKey, error) {
-	data, err := ioutil.ReadFile(p)
+	data, err := c.store.ReadFile(p)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	encrypted := false
+	if block, _ := pem.Decode(data); block != nil && block.Type == encryptedPrivateKeyPEMType {
+		if c.keyManager == nil {
+			return nil, fmt.Errorf("private key %q is encrypted; a key manager is required to decrypt it", c.store.Location(p))
 		}
+		data, err = decryptPrivateKey(c.keyManager, block)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting private key %q: %v", c.store.Location(p), err)
+		}
+		encrypted = true
 	}
+
 	k, err := parsePEMPrivateKey(data)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing private key from %q: %v", p, err)
+		return nil, fmt.Errorf("error parsing private key from %q: %v", c.store.Location(p), err)
+	}
+
+	if !encrypted && c.keyManager != nil {
+		// We don't write the key here, because we might be running a dry-run or validate
+		c.mutex.Lock()
+		c.plaintextKeys[p] = k
+		c.mutex.Unlock()
 	}
 	return k, nil
 }
 
+func (c *FilesystemCAStore) EncryptPlaintextKeys() error {
+	c.mutex.Lock()
+	var paths []string
+	for p := range c.plaintextKeys {
+		paths = append(paths, p)
+	}
+	c.mutex.Unlock()
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		c.mutex.Lock()
+		k := c.plaintextKeys[p]
+		c.mutex.Unlock()
+		if k == nil {
+			// Replaced since it was loaded
+			continue
+		}
+
+		glog.Infof("Encrypting private key %q", c.store.Location(p))
+		err := c.storePrivateKey(k, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *FilesystemCAStore) FindPrivateKey(subject *pkix.Name) (crypto.PrivateKey, error) {
 	p := c.buildPrivateKeyPath(subject)
 	return c.loadPrivateKey(p)
 }
 
-func (c *FilesystemCAStore) CreatePrivateKey(subject *pkix.Name) (crypto.PrivateKey, error) {
+func (c *FilesystemCAStore) CreatePrivateKey(subject *pkix.Name, keyAlgorithm KeyAlgorithm) (crypto.PrivateKey, error) {
 	p := c.buildPrivateKeyPath(subject)
 
-	privateKey, err := rsa.GenerateKey(crypto_rand.Reader, 2048)
+	if keyAlgorithm == "" {
+		keyAlgorithm = c.keyAlgorithm
+	}
+	privateKey, err := GeneratePrivateKey(keyAlgorithm)
 	if err != nil {
-		return nil, fmt.Errorf("error generating RSA private key: %v", err)
+		return nil, err
 	}
 
 	err = c.storePrivateKey(privateKey, p)
@@ -231,17 +414,28 @@ func (c *FilesystemCAStore) CreatePrivateKey(subject *pkix.Name) (crypto.Private
 	return privateKey, nil
 }
 
-func (c*FilesystemCAStore) storePrivateKey(privateKey crypto.PrivateKey, p string) error {
+func (c *FilesystemCAStore) storePrivateKey(privateKey crypto.PrivateKey, p string) error {
+	c.mutex.Lock()
+	delete(c.plaintextKeys, p)
+	c.mutex.Unlock()
+
 	var data bytes.Buffer
 	err := WritePrivateKey(privateKey, &data)
 	if err != nil {
 		return err
 	}
 
+	if c.keyManager != nil {
+		encrypted, err := encryptPrivateKey(c.keyManager, data.Bytes())
+		if err != nil {
+			return fmt.Errorf("error encrypting private key for %q: %v", c.store.Location(p), err)
+		}
+		return c.writeFile(encrypted, p)
+	}
 	return c.writeFile(data.Bytes(), p)
 }
 
-func (c*FilesystemCAStore) storeCertificate(cert *Certificate, p string) error {
+func (c *FilesystemCAStore) storeCertificate(cert *Certificate, p string) error {
 	var data bytes.Buffer
 	err := cert.WriteCertificate(&data)
 	if err != nil {
@@ -251,12 +445,11 @@ func (c*FilesystemCAStore) storeCertificate(cert *Certificate, p string) error {
 	return c.writeFile(data.Bytes(), p)
 }
 
-func (c*FilesystemCAStore) writeFile(data []byte, p string) error {
+func (c *FilesystemCAStore) writeFile(data []byte, p string) error {
 	// TODO: concurrency?
-	err := ioutil.WriteFile(p, data, 0600)
+	err := c.store.WriteFile(p, data)
 	if err != nil {
-		// TODO: Delete file on disk?  Write a temp file and move it atomically?
-		return fmt.Errorf("error writing certificate/key data to path %q: %v", p, err)
+		return fmt.Errorf("error writing certificate/key data to %q: %v", c.store.Location(p), err)
 	}
 	return nil
 }
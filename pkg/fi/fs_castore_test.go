@@ -0,0 +1,111 @@
+package fi
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func newTestCAStore(t *testing.T, keyAlgorithm KeyAlgorithm) (CAStore, func()) {
+	dir, err := ioutil.TempDir("", "castore")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	castore, err := NewCAStore(NewFilesystemStateStore(dir), "pki", keyAlgorithm, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("error building CA store: %v", err)
+	}
+	return castore, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func issueTestCert(t *testing.T, castore CAStore, name string) *Certificate {
+	subject := &pkix.Name{CommonName: name}
+	privateKey, err := castore.CreatePrivateKey(subject, "")
+	if err != nil {
+		t.Fatalf("error creating private key: %v", err)
+	}
+	template := &x509.Certificate{
+		Subject:     *subject,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	cert, err := castore.IssueCert(privateKey, template)
+	if err != nil {
+		t.Fatalf("error issuing certificate: %v", err)
+	}
+	return cert
+}
+
+// During a CA rotation, certificates issued by either CA must verify against the trusted CAs
+func TestCARotationVerify(t *testing.T) {
+	castore, cleanup := newTestCAStore(t, "")
+	defer cleanup()
+
+	oldCA, err := castore.GetCACert()
+	if err != nil {
+		t.Fatalf("error getting CA: %v", err)
+	}
+	if oldCA.Subject.CommonName != "kubernetes" {
+		t.Fatalf("expected the initial CA to be named %q, was %q", "kubernetes", oldCA.Subject.CommonName)
+	}
+	oldCert := issueTestCert(t, castore, "old")
+
+	err = castore.StartCARotation()
+	if err != nil {
+		t.Fatalf("error starting rotation: %v", err)
+	}
+	err = castore.PromoteNextCA()
+	if err != nil {
+		t.Fatalf("error promoting CA: %v", err)
+	}
+
+	newCA, err := castore.GetCACert()
+	if err != nil {
+		t.Fatalf("error getting CA: %v", err)
+	}
+	if newCA.Subject.CommonName == oldCA.Subject.CommonName {
+		t.Fatalf("old and new CA have the same name %q", newCA.Subject.CommonName)
+	}
+	if bytes.Equal(newCA.Certificate.SubjectKeyId, oldCA.Certificate.SubjectKeyId) {
+		t.Fatalf("old and new CA have the same key id")
+	}
+	newCert := issueTestCert(t, castore, "new")
+
+	trusted, err := castore.GetTrustedCACerts()
+	if err != nil {
+		t.Fatalf("error getting trusted CAs: %v", err)
+	}
+	if len(trusted) != 2 {
+		t.Fatalf("expected 2 trusted CAs during the rotation, found %d", len(trusted))
+	}
+	roots := x509.NewCertPool()
+	for _, ca := range trusted {
+		roots.AddCert(ca.Certificate)
+	}
+
+	tests := []struct {
+		cert *Certificate
+		ca   *Certificate
+	}{
+		{cert: oldCert, ca: oldCA},
+		{cert: newCert, ca: newCA},
+	}
+	for _, test := range tests {
+		name := test.cert.Certificate.Subject.CommonName
+		if !bytes.Equal(test.cert.Certificate.AuthorityKeyId, test.ca.Certificate.SubjectKeyId) {
+			t.Errorf("certificate %q does not identify its issuer's key", name)
+		}
+		_, err := test.cert.Certificate.Verify(x509.VerifyOptions{
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err != nil {
+			t.Errorf("certificate %q did not verify against the trusted CAs: %v", name, err)
+		}
+	}
+}
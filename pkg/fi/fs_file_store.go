@@ -0,0 +1,134 @@
+package fi
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// FilesystemFileStore writes resources to a local directory, named by their hash, for serving over HTTP
+// (e.g. with kope serve-artifacts) in environments where S3 is not available
+type FilesystemFileStore struct {
+	basedir string
+	// baseURL is the URL at which basedir is served to the instances
+	baseURL string
+	prefix  string
+
+	// keyLocks ensures that concurrent puts of the same file are not written twice
+	mutex    sync.Mutex
+	keyLocks map[string]*sync.Mutex
+}
+
+var _ FileStore = &FilesystemFileStore{}
+
+func NewFilesystemFileStore(basedir string, baseURL string, prefix string) (*FilesystemFileStore, error) {
+	err := os.MkdirAll(path.Join(basedir, prefix), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating directory: %v", err)
+	}
+	return &FilesystemFileStore{
+		basedir:  basedir,
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		prefix:   prefix,
+		keyLocks: make(map[string]*sync.Mutex),
+	}, nil
+}
+
+func (s *FilesystemFileStore) lockKey(name string) *sync.Mutex {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	l := s.keyLocks[name]
+	if l == nil {
+		l = &sync.Mutex{}
+		s.keyLocks[name] = l
+	}
+	l.Lock()
+	return l
+}
+
+func (s *FilesystemFileStore) PutResource(key string, r Resource, hashAlgorithm HashAlgorithm) (string, string, error) {
+	hashes, err := HashesForResource(r, []HashAlgorithm{hashAlgorithm})
+	if err != nil {
+		return "", "", err
+	}
+	userHash := hashes[hashAlgorithm]
+
+	name := s.prefix + key + "-" + userHash
+	p := path.Join(s.basedir, name)
+
+	l := s.lockKey(name)
+	defer l.Unlock()
+
+	alreadyPresent := false
+	_, err = os.Stat(p)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return "", "", fmt.Errorf("error checking file %q: %v", p, err)
+		}
+	} else {
+		existing, err := HashesForResource(NewFileResource(p), []HashAlgorithm{hashAlgorithm})
+		if err != nil {
+			return "", "", err
+		}
+		if existing[hashAlgorithm] == userHash {
+			alreadyPresent = true
+		} else {
+			glog.Infof("Found file, but did not match: %q (%s vs %s)", p, existing[hashAlgorithm], userHash)
+		}
+	}
+
+	if !alreadyPresent {
+		glog.V(2).Infof("Writing %s", p)
+		err = writeResourceAtomic(p, r)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	return s.baseURL + "/" + name, userHash, nil
+}
+
+func (s *FilesystemFileStore) ResourceURL(key string, r Resource, hashAlgorithm HashAlgorithm) (string, string, error) {
+	userHash, err := HashForResource(r, hashAlgorithm)
+	if err != nil {
+		return "", "", err
+	}
+	return s.baseURL + "/" + s.prefix + key + "-" + userHash, userHash, nil
+}
+
+// writeResourceAtomic writes to a temporary file and renames it into place, so the file is never served partially written
+func writeResourceAtomic(p string, r Resource) error {
+	body, err := r.Open()
+	if err != nil {
+		return err
+	}
+	defer SafeClose(body)
+
+	tmp, err := ioutil.TempFile(path.Dir(p), "."+path.Base(p))
+	if err != nil {
+		return fmt.Errorf("error creating temp file: %v", err)
+	}
+	_, err = io.Copy(tmp, body)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing file %q: %v", p, err)
+	}
+	return nil
+}
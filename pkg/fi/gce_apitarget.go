@@ -0,0 +1,51 @@
+package fi
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+)
+
+type GCEAPITarget struct {
+	Cloud     *GCECloud
+	filestore FileStore
+}
+
+var _ Target = &GCEAPITarget{}
+var _ ConcurrentTarget = &GCEAPITarget{}
+
+// GCERenderer is implemented by units that can be applied directly using the GCE API
+type GCERenderer interface {
+	RenderGCE(t *GCEAPITarget, a, e, changes Unit) error
+}
+
+func init() {
+	RegisterRenderer(&GCEAPITarget{}, (*GCERenderer)(nil))
+}
+
+// IsConcurrent is true, because each unit makes its own API calls
+func (t *GCEAPITarget) IsConcurrent() bool {
+	return true
+}
+
+func (t *GCEAPITarget) Render(a, e, changes Unit) error {
+	r, ok := e.(GCERenderer)
+	if !ok {
+		return fmt.Errorf("%T does not support the GCE API target", e)
+	}
+	return r.RenderGCE(t, a, e, changes)
+}
+
+func NewGCEAPITarget(cloud *GCECloud, filestore FileStore) *GCEAPITarget {
+	return &GCEAPITarget{
+		Cloud:     cloud,
+		filestore: filestore,
+	}
+}
+
+func (t *GCEAPITarget) PutResource(key string, r Resource, hashAlgorithm HashAlgorithm) (string, string, error) {
+	if r == nil {
+		glog.Fatalf("Attempt to put null resource for %q", key)
+	}
+	return t.filestore.PutResource(key, r, hashAlgorithm)
+}
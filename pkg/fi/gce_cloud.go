@@ -0,0 +1,144 @@
+package fi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/storage/v1"
+)
+
+// GCEOperationTimeout is how long we will wait for a GCE operation to complete
+const GCEOperationTimeout = 10 * time.Minute
+
+// GCECloud holds the clients for the Google Cloud services, and the project & region that we are working in
+type GCECloud struct {
+	Compute *compute.Service
+	Storage *storage.Service
+
+	Region  string
+	Project string
+
+	// labels are the GCE equivalent of the AWS cluster tags; GCE resources don't support arbitrary tags,
+	// so today they are only used to name things
+	labels map[string]string
+}
+
+var _ Cloud = &GCECloud{}
+
+func (c *GCECloud) ProviderID() ProviderID {
+	return ProviderGCE
+}
+
+func NewGCECloud(region string, project string, labels map[string]string) (*GCECloud, error) {
+	c := &GCECloud{Region: region, Project: project, labels: labels}
+
+	ctx := context.Background()
+
+	client, err := google.DefaultClient(ctx, compute.ComputeScope, storage.DevstorageReadWriteScope)
+	if err != nil {
+		return nil, fmt.Errorf("error building google API client: %v", err)
+	}
+	c.Compute, err = compute.New(client)
+	if err != nil {
+		return nil, fmt.Errorf("error building compute API client: %v", err)
+	}
+	c.Storage, err = storage.New(client)
+	if err != nil {
+		return nil, fmt.Errorf("error building storage API client: %v", err)
+	}
+
+	return c, nil
+}
+
+func (c *GCECloud) Labels() map[string]string {
+	// Defensive copy
+	labels := make(map[string]string)
+	for k, v := range c.labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// IsGCENotFound returns true if err is the error the Google APIs return when a resource does not exist
+func IsGCENotFound(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == 404
+}
+
+// LastComponent returns the last component of a GCE resource URL, which is normally the name of the resource
+func LastComponent(s string) string {
+	lastSlash := strings.LastIndex(s, "/")
+	if lastSlash != -1 {
+		s = s[lastSlash+1:]
+	}
+	return s
+}
+
+// WaitForOp waits for a zonal, regional or global operation to complete, returning the error if it failed
+func (c *GCECloud) WaitForOp(op *compute.Operation) error {
+	deadline := time.Now().Add(GCEOperationTimeout)
+	delay := 1 * time.Second
+
+	for {
+		if op.Status == "DONE" {
+			if op.Error != nil && len(op.Error.Errors) != 0 {
+				var messages []string
+				for _, e := range op.Error.Errors {
+					messages = append(messages, fmt.Sprintf("%s: %s", e.Code, e.Message))
+				}
+				return fmt.Errorf("operation %q failed: %s", op.Name, strings.Join(messages, "; "))
+			}
+			return nil
+		}
+
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("timeout waiting for operation %q to complete", op.Name)
+		}
+		time.Sleep(delay)
+		if delay < 10*time.Second {
+			delay *= 2
+		}
+
+		glog.V(4).Infof("polling operation %q, status was %q", op.Name, op.Status)
+		var err error
+		if op.Zone != "" {
+			op, err = c.Compute.ZoneOperations.Get(c.Project, LastComponent(op.Zone), op.Name).Do()
+		} else if op.Region != "" {
+			op, err = c.Compute.RegionOperations.Get(c.Project, LastComponent(op.Region), op.Name).Do()
+		} else {
+			op, err = c.Compute.GlobalOperations.Get(c.Project, op.Name).Do()
+		}
+		if err != nil {
+			return fmt.Errorf("error polling operation: %v", err)
+		}
+	}
+}
+
+// GCSBucket is a Google Cloud Storage bucket
+type GCSBucket struct {
+	storage *storage.Service
+	Name    string
+}
+
+// EnsureGCSBucket returns the bucket with the given name, creating it in the project if it does not exist
+func (c *GCECloud) EnsureGCSBucket(name string, location string) (*GCSBucket, error) {
+	_, err := c.Storage.Buckets.Get(name).Do()
+	if err != nil {
+		if !IsGCENotFound(err) {
+			return nil, fmt.Errorf("error getting bucket %q: %v", name, err)
+		}
+
+		glog.V(2).Infof("Creating GCS bucket: %s", name)
+		_, err = c.Storage.Buckets.Insert(c.Project, &storage.Bucket{Name: name, Location: location}).Do()
+		if err != nil {
+			return nil, fmt.Errorf("error creating bucket %q: %v", name, err)
+		}
+	}
+	return &GCSBucket{storage: c.Storage, Name: name}, nil
+}
@@ -0,0 +1,152 @@
+package fi
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"google.golang.org/api/storage/v1"
+)
+
+// GCSFileStore uploads resources to a Google Cloud Storage bucket, as S3FileStore does for S3
+type GCSFileStore struct {
+	bucket *GCSBucket
+	prefix string
+
+	// Private, if set, keeps the objects private and returns URLs signed by Signer, instead of making the objects public.
+	// As with S3FileStore, kope must be re-run within SignedURLExpiry / 2 to refresh the URLs.
+	Private         bool
+	Signer          *GCSURLSigner
+	SignedURLExpiry time.Duration
+
+	// keyLocks ensures that concurrent puts of the same object are not uploaded twice
+	mutex    sync.Mutex
+	keyLocks map[string]*sync.Mutex
+}
+
+func NewGCSFileStore(bucket *GCSBucket, prefix string) *GCSFileStore {
+	return &GCSFileStore{
+		bucket:   bucket,
+		prefix:   prefix,
+		keyLocks: make(map[string]*sync.Mutex),
+	}
+}
+
+func (s *GCSFileStore) lockKey(name string) *sync.Mutex {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	l := s.keyLocks[name]
+	if l == nil {
+		l = &sync.Mutex{}
+		s.keyLocks[name] = l
+	}
+	l.Lock()
+	return l
+}
+
+func (s *GCSFileStore) PutResource(key string, r Resource, hashAlgorithm HashAlgorithm) (string, string, error) {
+	hashes, err := HashesForResource(r, []HashAlgorithm{HashAlgorithmMD5, hashAlgorithm})
+	if err != nil {
+		return "", "", err
+	}
+
+	// GCS reports the MD5 base64 encoded
+	md5, err := hex.DecodeString(hashes[HashAlgorithmMD5])
+	if err != nil {
+		return "", "", fmt.Errorf("error decoding hash: %v", err)
+	}
+	md5Base64 := base64.StdEncoding.EncodeToString(md5)
+	userHash := hashes[hashAlgorithm]
+
+	name := s.prefix + key + "-" + userHash
+
+	l := s.lockKey(name)
+	defer l.Unlock()
+
+	if s.Private && s.Signer == nil {
+		return "", "", fmt.Errorf("a signing key is required to keep GCS objects private")
+	}
+	predefinedACL := "publicRead"
+	if s.Private {
+		predefinedACL = "private"
+	}
+
+	alreadyPresent := false
+	o, err := s.bucket.storage.Objects.Get(s.bucket.Name, name).Projection("full").Do()
+	if err != nil {
+		if !IsGCENotFound(err) {
+			return "", "", fmt.Errorf("error getting object %q: %v", name, err)
+		}
+	} else if o.Md5Hash == md5Base64 {
+		alreadyPresent = true
+	} else {
+		glog.Infof("Found file, but did not match: %q (%s vs %s)", name, o.Md5Hash, md5Base64)
+	}
+
+	if alreadyPresent && isGCSObjectPublic(o) == s.Private {
+		glog.V(2).Infof("Setting ACL of gs://%s/%s to %s", s.bucket.Name, name, predefinedACL)
+		_, err = s.bucket.storage.Objects.Patch(s.bucket.Name, name, &storage.Object{}).PredefinedAcl(predefinedACL).Do()
+		if err != nil {
+			return "", "", fmt.Errorf("error setting ACL of object %q: %v", name, err)
+		}
+	}
+
+	if !alreadyPresent {
+		body, err := r.Open()
+		if err != nil {
+			return "", "", err
+		}
+		defer SafeClose(body)
+
+		glog.V(2).Infof("Uploading gs://%s/%s", s.bucket.Name, name)
+		_, err = s.bucket.storage.Objects.Insert(s.bucket.Name, &storage.Object{Name: name}).Media(body).PredefinedAcl(predefinedACL).Do()
+		if err != nil {
+			return "", "", fmt.Errorf("error uploading object %q: %v", name, err)
+		}
+	}
+
+	url, err := s.objectURL(name)
+	if err != nil {
+		return "", "", err
+	}
+	return url, userHash, nil
+}
+
+func (s *GCSFileStore) ResourceURL(key string, r Resource, hashAlgorithm HashAlgorithm) (string, string, error) {
+	userHash, err := HashForResource(r, hashAlgorithm)
+	if err != nil {
+		return "", "", err
+	}
+
+	url, err := s.objectURL(s.prefix + key + "-" + userHash)
+	if err != nil {
+		return "", "", err
+	}
+	return url, userHash, nil
+}
+
+// objectURL returns the URL from which the instances download the object: signed if the store is private
+func (s *GCSFileStore) objectURL(name string) (string, error) {
+	if !s.Private {
+		return "https://storage.googleapis.com/" + s.bucket.Name + "/" + name, nil
+	}
+	if s.Signer == nil {
+		return "", fmt.Errorf("a signing key is required to keep GCS objects private")
+	}
+	// As for S3, we round the signing time so that the URL is the same across runs
+	signTime := time.Now().Truncate(s.SignedURLExpiry / 2)
+	return s.Signer.SignedURL(s.bucket.Name, name, s.SignedURLExpiry, signTime)
+}
+
+func isGCSObjectPublic(o *storage.Object) bool {
+	for _, acl := range o.Acl {
+		if acl.Entity == "allUsers" && acl.Role == "READER" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package fi
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Service account keys are PKCS8, in a JSON key file
+func writeTestServiceAccountKey(t *testing.T, privateKey *rsa.PrivateKey) string {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("error encoding key: %v", err)
+	}
+	data, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "kope@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})),
+	})
+	if err != nil {
+		t.Fatalf("error encoding key file: %v", err)
+	}
+
+	f, err := ioutil.TempFile("", "service-account")
+	if err != nil {
+		t.Fatalf("error creating key file: %v", err)
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	if err != nil {
+		t.Fatalf("error writing key file: %v", err)
+	}
+	return f.Name()
+}
+
+func TestGCSSignedURL(t *testing.T) {
+	privateKey, err := GeneratePrivateKey(KeyAlgorithmRSA2048)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	keyFile := writeTestServiceAccountKey(t, privateKey.(*rsa.PrivateKey))
+	defer os.Remove(keyFile)
+
+	signer, err := NewGCSURLSignerFromFile(keyFile)
+	if err != nil {
+		t.Fatalf("error loading key: %v", err)
+	}
+
+	signTime := time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC)
+	signed, err := signer.SignedURL("kope-project", "devel/test/salt.tar.gz-abc", time.Hour, signTime)
+	if err != nil {
+		t.Fatalf("error signing URL: %v", err)
+	}
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("error parsing signed URL %q: %v", signed, err)
+	}
+	if u.Host != "storage.googleapis.com" || u.Path != "/kope-project/devel/test/salt.tar.gz-abc" {
+		t.Errorf("unexpected signed URL %q", signed)
+	}
+	query := u.Query()
+	expected := map[string]string{
+		"X-Goog-Algorithm":     "GOOG4-RSA-SHA256",
+		"X-Goog-Credential":    "kope@project.iam.gserviceaccount.com/20160601/auto/storage/goog4_request",
+		"X-Goog-Date":          "20160601T120000Z",
+		"X-Goog-Expires":       "3600",
+		"X-Goog-SignedHeaders": "host",
+	}
+	for k, v := range expected {
+		if query.Get(k) != v {
+			t.Errorf("expected %s=%q, got %q", k, v, query.Get(k))
+		}
+	}
+
+	// The signature covers the canonical request, which is the URL without the signature
+	canonicalQuery := strings.Split(u.RawQuery, "&X-Goog-Signature=")[0]
+	canonicalRequest := "GET\n" + u.Path + "\n" + canonicalQuery + "\nhost:storage.googleapis.com\n\nhost\nUNSIGNED-PAYLOAD"
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "GOOG4-RSA-SHA256\n20160601T120000Z\n20160601/auto/storage/goog4_request\n" + hex.EncodeToString(canonicalRequestHash[:])
+	digest := sha256.Sum256([]byte(stringToSign))
+	signature, err := hex.DecodeString(query.Get("X-Goog-Signature"))
+	if err != nil {
+		t.Fatalf("error decoding signature: %v", err)
+	}
+	err = rsa.VerifyPKCS1v15(&privateKey.(*rsa.PrivateKey).PublicKey, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		t.Errorf("signature did not verify: %v", err)
+	}
+
+	_, err = signer.SignedURL("kope-project", "key", MaxPresignExpiry+time.Hour, signTime)
+	if err == nil {
+		t.Errorf("expected error signing URL with expiry longer than %v", MaxPresignExpiry)
+	}
+}
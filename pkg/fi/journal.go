@@ -0,0 +1,134 @@
+package fi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// The types of resources recorded in a Journal
+const (
+	JournalTypeVPC                    = "vpc"
+	JournalTypeSubnet                 = "subnet"
+	JournalTypeInternetGateway        = "internet-gateway"
+	JournalTypeInternetGatewayAttach  = "internet-gateway-attachment"
+	JournalTypeRouteTable             = "route-table"
+	JournalTypeRouteTableAssociation  = "route-table-association"
+	JournalTypeSecurityGroup          = "security-group"
+	JournalTypeDHCPOptions            = "dhcp-options"
+	JournalTypeVolume                 = "volume"
+	JournalTypeVolumeAttachment       = "volume-attachment"
+	JournalTypeElasticIP              = "elastic-ip"
+	JournalTypeElasticIPAssociation   = "elastic-ip-association"
+	JournalTypeInstance               = "instance"
+	JournalTypeSSHKey                 = "ssh-key"
+	JournalTypeIAMRole                = "iam-role"
+	JournalTypeIAMRolePolicy          = "iam-role-policy"
+	JournalTypeIAMInstanceProfile     = "iam-instance-profile"
+	JournalTypeIAMInstanceProfileRole = "iam-instance-profile-role"
+	JournalTypeLaunchConfiguration    = "autoscaling-launchconfiguration"
+	JournalTypeAutoscalingGroup       = "autoscaling-group"
+	JournalTypeS3Object               = "s3-object"
+)
+
+// JournalEntry is a resource that was created.  For associations, ID is the IDs of both sides joined with a "/"
+type JournalEntry struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// Journal records the resources created by the AWSAPITarget, in order, so that a failed run can be rolled back.
+// The journal is written to disk after every entry, so that it survives a crash.
+type Journal struct {
+	Region    string          `json:"region"`
+	ClusterID string          `json:"clusterID"`
+	Entries   []*JournalEntry `json:"entries"`
+
+	mutex sync.Mutex
+	path  string
+}
+
+// LoadJournal loads the journal from path; if there is no journal, an empty journal is returned
+func LoadJournal(path string) (*Journal, error) {
+	j := &Journal{path: path}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return j, nil
+		}
+		return nil, fmt.Errorf("error reading journal %q: %v", path, err)
+	}
+
+	err = json.Unmarshal(data, j)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing journal %q: %v", path, err)
+	}
+	return j, nil
+}
+
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Record adds a created resource to the journal
+func (j *Journal) Record(resourceType string, id string) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	glog.V(2).Infof("Recording creation of %s %q in journal", resourceType, id)
+	j.Entries = append(j.Entries, &JournalEntry{Type: resourceType, ID: id})
+	return j.write()
+}
+
+// Replace sets the entries (e.g. to those that could not be rolled back), removing the journal if there are none
+func (j *Journal) Replace(entries []*JournalEntry) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.Entries = entries
+	if len(entries) == 0 {
+		return j.remove()
+	}
+	return j.write()
+}
+
+// Remove deletes the journal, for when the run completed successfully
+func (j *Journal) Remove() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.Entries = nil
+	return j.remove()
+}
+
+func (j *Journal) remove() error {
+	err := os.Remove(j.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing journal %q: %v", j.path, err)
+	}
+	return nil
+}
+
+func (j *Journal) write() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing journal: %v", err)
+	}
+
+	// Write & rename, so we never leave a partial journal
+	tmp := j.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing journal %q: %v", tmp, err)
+	}
+	err = os.Rename(tmp, j.path)
+	if err != nil {
+		return fmt.Errorf("error writing journal %q: %v", j.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package fi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	crypto_rand "crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KeyManager protects the data keys with which we encrypt private keys at rest (envelope encryption):
+// each private key is encrypted with its own data key, and only the wrapped data key is stored alongside it
+type KeyManager interface {
+	// Name identifies the KeyManager; it is recorded with each encrypted key, so we can tell which KeyManager it needs
+	Name() string
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+const encryptedPrivateKeyPEMType = "KOPE ENCRYPTED PRIVATE KEY"
+
+// AES-256
+const dataKeySize = 32
+
+// encryptPrivateKey encrypts the PEM encoded private key, returning a KOPE ENCRYPTED PRIVATE KEY PEM block
+func encryptPrivateKey(km KeyManager, plaintext []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	_, err := io.ReadFull(crypto_rand.Reader, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("error generating data key: %v", err)
+	}
+
+	wrapped, err := km.WrapKey(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	block := &pem.Block{
+		Type: encryptedPrivateKeyPEMType,
+		Headers: map[string]string{
+			"Key-Manager": km.Name(),
+			"Wrapped-Key": base64.StdEncoding.EncodeToString(wrapped),
+		},
+	}
+
+	block.Bytes, err = aesGCMSeal(dataKey, plaintext, encryptedPrivateKeyAdditionalData(block))
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// encryptedPrivateKeyAdditionalData returns the PEM headers in a canonical form, to be authenticated along with the key
+func encryptedPrivateKeyAdditionalData(block *pem.Block) []byte {
+	return []byte("Key-Manager: " + block.Headers["Key-Manager"] + "\nWrapped-Key: " + block.Headers["Wrapped-Key"] + "\n")
+}
+
+// decryptPrivateKey decrypts a KOPE ENCRYPTED PRIVATE KEY PEM block, returning the PEM encoded private key
+func decryptPrivateKey(km KeyManager, block *pem.Block) ([]byte, error) {
+	name := block.Headers["Key-Manager"]
+	if name != km.Name() {
+		return nil, fmt.Errorf("key was encrypted using the %q key manager, not %q", name, km.Name())
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(block.Headers["Wrapped-Key"])
+	if err != nil {
+		return nil, fmt.Errorf("error decoding wrapped key: %v", err)
+	}
+
+	dataKey, err := km.UnwrapKey(wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aesGCMOpen(dataKey, block.Bytes, encryptedPrivateKeyAdditionalData(block))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting key: %v", err)
+	}
+	return plaintext, nil
+}
+
+// aesGCMSeal encrypts plaintext with AES-GCM, returning the nonce followed by the ciphertext.
+// additionalData is authenticated but not encrypted (or stored); the same additionalData must be passed to aesGCMOpen.
+func aesGCMSeal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error building cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error building cipher: %v", err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(crypto_rand.Reader, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("error generating nonce: %v", err)
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func aesGCMOpen(key []byte, sealed []byte, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error building cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error building cipher: %v", err)
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted data is truncated")
+	}
+	nonce := sealed[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, sealed[gcm.NonceSize():], additionalData)
+}
+
+// PassphraseKeyManager wraps data keys with a key derived from a passphrase, using scrypt
+type PassphraseKeyManager struct {
+	passphrase []byte
+}
+
+var _ KeyManager = &PassphraseKeyManager{}
+
+// scrypt parameters, as recommended for interactive use
+const (
+	scryptSaltSize = 16
+	scryptN        = 32768
+	scryptR        = 8
+	scryptP        = 1
+)
+
+func NewPassphraseKeyManager(passphrase string) (*PassphraseKeyManager, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+	return &PassphraseKeyManager{passphrase: []byte(passphrase)}, nil
+}
+
+func (k *PassphraseKeyManager) Name() string {
+	return "passphrase"
+}
+
+// WrapKey encrypts the data key, with a fresh salt each time; the salt is stored before the encrypted key
+func (k *PassphraseKeyManager) WrapKey(dataKey []byte) ([]byte, error) {
+	salt := make([]byte, scryptSaltSize)
+	_, err := io.ReadFull(crypto_rand.Reader, salt)
+	if err != nil {
+		return nil, fmt.Errorf("error generating salt: %v", err)
+	}
+
+	kek, err := scrypt.Key(k.passphrase, salt, scryptN, scryptR, scryptP, dataKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving key from passphrase: %v", err)
+	}
+
+	sealed, err := aesGCMSeal(kek, dataKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	return append(salt, sealed...), nil
+}
+
+func (k *PassphraseKeyManager) UnwrapKey(wrapped []byte) ([]byte, error) {
+	if len(wrapped) < scryptSaltSize {
+		return nil, fmt.Errorf("wrapped key is truncated")
+	}
+	salt := wrapped[:scryptSaltSize]
+
+	kek, err := scrypt.Key(k.passphrase, salt, scryptN, scryptR, scryptP, dataKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving key from passphrase: %v", err)
+	}
+
+	dataKey, err := aesGCMOpen(kek, wrapped[scryptSaltSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting key; is the passphrase correct?")
+	}
+	return dataKey, nil
+}
+
+// FileKeyManager wraps data keys with a key held in a local file, which should be kept out of the state store.
+// It is the simplest implementation of KeyManager; a cloud KMS would implement the same interface.
+type FileKeyManager struct {
+	path string
+	key  []byte
+}
+
+var _ KeyManager = &FileKeyManager{}
+
+// NewFileKeyManager loads the (hex encoded) key from p, generating it if the file does not exist
+func NewFileKeyManager(p string) (*FileKeyManager, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error reading key file %q: %v", p, err)
+		}
+
+		glog.Infof("Generating key file %q", p)
+		key := make([]byte, dataKeySize)
+		_, err = io.ReadFull(crypto_rand.Reader, key)
+		if err != nil {
+			return nil, fmt.Errorf("error generating key: %v", err)
+		}
+		data = []byte(hex.EncodeToString(key) + "\n")
+		err = ioutil.WriteFile(p, data, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("error writing key file %q: %v", p, err)
+		}
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing key file %q: %v", p, err)
+	}
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("key file %q has a %d bit key; expected %d bits", p, len(key)*8, dataKeySize*8)
+	}
+	return &FileKeyManager{path: p, key: key}, nil
+}
+
+func (k *FileKeyManager) Name() string {
+	return "file"
+}
+
+func (k *FileKeyManager) WrapKey(dataKey []byte) ([]byte, error) {
+	return aesGCMSeal(k.key, dataKey, nil)
+}
+
+func (k *FileKeyManager) UnwrapKey(wrapped []byte) ([]byte, error) {
+	dataKey, err := aesGCMOpen(k.key, wrapped, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting key; was it encrypted with a different key file than %q?", k.path)
+	}
+	return dataKey, nil
+}
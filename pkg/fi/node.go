@@ -4,27 +4,26 @@ import "github.com/golang/glog"
 
 type node struct {
 	unit     Unit
+	parent   *node
 	children []*node
+
+	// dependencies are the nodes that must run before this node
+	dependencies []*node
 }
 
 func (c *node) Add(node *node) {
+	node.parent = c
 	c.children = append(c.children, node)
 }
 
-func (n *node) Run(c *RunContext) error {
-	if n.unit != nil {
-		glog.V(2).Infof("Executing unit %v", n.unit)
-		err := n.unit.Run(c)
-		if err != nil {
-			return err
-		}
-	}
-	for _, child := range n.children {
-		childContext := c.buildChildContext(child)
-		err := child.Run(childContext)
-		if err != nil {
-			return err
-		}
+func (n *node) String() string {
+	if n.unit == nil {
+		return "<root>"
 	}
-	return nil
+	return n.unit.Path()
+}
+
+func (n *node) Run(c *RunContext) error {
+	glog.V(2).Infof("Executing unit %v", n.unit)
+	return n.unit.Run(c)
 }
@@ -0,0 +1,115 @@
+package fi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	"gopkg.in/yaml.v2"
+)
+
+type PlanAction string
+
+const (
+	PlanActionCreate PlanAction = "create"
+	PlanActionUpdate PlanAction = "update"
+	PlanActionNoop   PlanAction = "no-op"
+)
+
+// PlanValueKnownAfterApply is the new value of a field that depends on resources that will be created when the
+// plan is applied; any value is accepted when applying
+const PlanValueKnownAfterApply = "<known after apply>"
+
+// Plan is the machine-readable form of the changes computed by a DryRunTarget
+type Plan struct {
+	Changes   []*PlanChange   `json:"changes" yaml:"changes"`
+	Resources []*PlanResource `json:"resources" yaml:"resources"`
+}
+
+// PlanChange describes the change to a single unit
+type PlanChange struct {
+	Path   string             `json:"path" yaml:"path"`
+	Type   string             `json:"type" yaml:"type"`
+	Action PlanAction         `json:"action" yaml:"action"`
+	Fields []*PlanFieldChange `json:"fields,omitempty" yaml:"fields,omitempty"`
+}
+
+// PlanFieldChange describes the change to a single field of a unit; Old is not set for creation
+type PlanFieldChange struct {
+	Name string  `json:"name" yaml:"name"`
+	Old  *string `json:"old,omitempty" yaml:"old,omitempty"`
+	New  string  `json:"new" yaml:"new"`
+}
+
+// PlanResource is a resource that will be uploaded to the file store
+type PlanResource struct {
+	Key  string `json:"key" yaml:"key"`
+	Hash string `json:"hash" yaml:"hash"`
+}
+
+const (
+	PlanFormatJSON = "json"
+	PlanFormatYAML = "yaml"
+)
+
+// WritePlan serializes the plan in the specified format (json or yaml)
+func WritePlan(out io.Writer, plan *Plan, format string) error {
+	var data []byte
+	var err error
+	switch format {
+	case PlanFormatJSON:
+		data, err = json.MarshalIndent(plan, "", "  ")
+		data = append(data, '\n')
+	case PlanFormatYAML:
+		data, err = yaml.Marshal(plan)
+	default:
+		return fmt.Errorf("unknown plan format %q", format)
+	}
+	if err != nil {
+		return fmt.Errorf("error serializing plan: %v", err)
+	}
+	_, err = out.Write(data)
+	return err
+}
+
+// ComparePlans returns an error describing the first difference if the plans are not the same
+func ComparePlans(expected, actual *Plan) error {
+	actualChanges := make(map[string]*PlanChange)
+	for _, c := range actual.Changes {
+		actualChanges[c.Path] = c
+	}
+	for _, e := range expected.Changes {
+		a := actualChanges[e.Path]
+		if a == nil {
+			return fmt.Errorf("%s is no longer changed", e.Path)
+		}
+		if !reflect.DeepEqual(e, a) {
+			return fmt.Errorf("%s has changed: was %s, now %s", e.Path, describeChange(e), describeChange(a))
+		}
+		delete(actualChanges, e.Path)
+	}
+	for path := range actualChanges {
+		return fmt.Errorf("%s is now changed", path)
+	}
+
+	if !reflect.DeepEqual(expected.Resources, actual.Resources) {
+		return fmt.Errorf("the resources to upload have changed")
+	}
+	return nil
+}
+
+func describeChange(c *PlanChange) string {
+	s := string(c.Action)
+	for _, f := range c.Fields {
+		s += " " + describeFieldChange(f)
+	}
+	return s
+}
+
+func describeFieldChange(f *PlanFieldChange) string {
+	if f.Old != nil {
+		return fmt.Sprintf("%s=%s->%s", f.Name, *f.Old, f.New)
+	}
+	return fmt.Sprintf("%s=%s", f.Name, f.New)
+}
@@ -0,0 +1,92 @@
+package fi
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// PlanTarget wraps another target, and only allows the changes that are in a previously computed plan
+type PlanTarget struct {
+	inner   Target
+	changes map[string]*PlanChange
+}
+
+var _ Target = &PlanTarget{}
+var _ ConcurrentTarget = &PlanTarget{}
+
+func NewPlanTarget(inner Target, plan *Plan) *PlanTarget {
+	t := &PlanTarget{
+		inner:   inner,
+		changes: make(map[string]*PlanChange),
+	}
+	for _, c := range plan.Changes {
+		t.changes[c.Path] = c
+	}
+	return t
+}
+
+func (t *PlanTarget) IsConcurrent() bool {
+	concurrent, ok := t.inner.(ConcurrentTarget)
+	return ok && concurrent.IsConcurrent()
+}
+
+func (t *PlanTarget) PutResource(key string, r Resource, hashAlgorithm HashAlgorithm) (string, string, error) {
+	return t.inner.PutResource(key, r, hashAlgorithm)
+}
+
+func (t *PlanTarget) Render(a, e, changes Unit) error {
+	path := e.Path()
+	planned := t.changes[path]
+	if planned == nil {
+		return fmt.Errorf("change to %q is not in the plan", path)
+	}
+
+	aIsNil := reflect.ValueOf(a).IsNil()
+	if aIsNil && planned.Action != PlanActionCreate {
+		return fmt.Errorf("%q would be created, but the plan has action %q", path, planned.Action)
+	}
+
+	fields, err := buildFieldChanges(&render{a: a, aIsNil: aIsNil, e: e, changes: changes})
+	if err != nil {
+		return err
+	}
+	if !aIsNil && planned.Action == PlanActionCreate {
+		// The cluster was checked against the plan before applying, so the unit was created as a side effect of
+		// creating another unit (e.g. every VPC has DHCP options); we only check that it gets the planned values
+		for i, f := range fields {
+			created := *f
+			created.Old = nil
+			fields[i] = &created
+		}
+	}
+	err = checkPlannedFields(path, planned, fields)
+	if err != nil {
+		return err
+	}
+
+	return t.inner.Render(a, e, changes)
+}
+
+// checkPlannedFields returns an error unless each of the fields would be changed exactly as in the plan
+func checkPlannedFields(path string, planned *PlanChange, fields []*PlanFieldChange) error {
+	plannedFields := make(map[string]*PlanFieldChange)
+	for _, f := range planned.Fields {
+		plannedFields[f.Name] = f
+	}
+	for _, f := range fields {
+		p := plannedFields[f.Name]
+		if p == nil {
+			return fmt.Errorf("%q would change %s, which is not in the plan", path, f.Name)
+		}
+		if p.New == PlanValueKnownAfterApply {
+			// The new value could not be computed when the plan was made
+			known := *p
+			known.New = f.New
+			p = &known
+		}
+		if !reflect.DeepEqual(p, f) {
+			return fmt.Errorf("%q would change %s differently from the plan: planned %s, now %s", path, f.Name, describeFieldChange(p), describeFieldChange(f))
+		}
+	}
+	return nil
+}
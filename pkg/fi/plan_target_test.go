@@ -0,0 +1,109 @@
+package fi
+
+import (
+	"strings"
+	"testing"
+)
+
+type planTestUnit struct {
+	SimpleUnit
+	Name *string
+	Size *string
+}
+
+func newPlanTestUnit(name, size *string) *planTestUnit {
+	u := &planTestUnit{Name: name, Size: size}
+	u.SetKey("volume")
+	return u
+}
+
+func TestPlanTargetFields(t *testing.T) {
+	s := func(v string) *string {
+		return &v
+	}
+
+	plan := &Plan{
+		Changes: []*PlanChange{
+			{Path: "volume", Type: "planTestUnit", Action: PlanActionUpdate, Fields: []*PlanFieldChange{
+				{Name: "Size", Old: s("10"), New: "20"},
+			}},
+		},
+	}
+
+	actual := newPlanTestUnit(s("data"), s("10"))
+	expected := newPlanTestUnit(s("data"), s("20"))
+
+	tests := []struct {
+		name    string
+		changes *planTestUnit
+		err     string
+	}{
+		{name: "planned change", changes: &planTestUnit{Size: s("20")}},
+		{name: "different value", changes: &planTestUnit{Size: s("30")}, err: "differently from the plan"},
+		{name: "unplanned field", changes: &planTestUnit{Name: s("other"), Size: s("20")}, err: "Name, which is not in the plan"},
+	}
+	for _, test := range tests {
+		target := NewPlanTarget(&testTarget{}, plan)
+		err := target.Render(actual, expected, test.changes)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestPlanTargetKnownAfterApply(t *testing.T) {
+	s := func(v string) *string {
+		return &v
+	}
+
+	tests := []struct {
+		name    string
+		planned *PlanChange
+		actual  *planTestUnit
+		changes *planTestUnit
+		err     string
+	}{
+		{
+			name:    "value known after apply",
+			planned: &PlanChange{Path: "volume", Action: PlanActionUpdate, Fields: []*PlanFieldChange{{Name: "Size", Old: s("10"), New: PlanValueKnownAfterApply}}},
+			actual:  newPlanTestUnit(s("data"), s("10")),
+			changes: &planTestUnit{Size: s("30")},
+		},
+		{
+			name:    "old value differs from the plan",
+			planned: &PlanChange{Path: "volume", Action: PlanActionUpdate, Fields: []*PlanFieldChange{{Name: "Size", Old: s("10"), New: PlanValueKnownAfterApply}}},
+			actual:  newPlanTestUnit(s("data"), s("15")),
+			changes: &planTestUnit{Size: s("30")},
+			err:     "differently from the plan",
+		},
+		{
+			name:    "created as a side effect",
+			planned: &PlanChange{Path: "volume", Action: PlanActionCreate, Fields: []*PlanFieldChange{{Name: "Name", New: "data"}, {Name: "Size", New: "20"}}},
+			actual:  newPlanTestUnit(s("data"), s("10")),
+			changes: &planTestUnit{Size: s("20")},
+		},
+		{
+			name:    "created as a side effect, with a different value",
+			planned: &PlanChange{Path: "volume", Action: PlanActionCreate, Fields: []*PlanFieldChange{{Name: "Name", New: "data"}, {Name: "Size", New: "20"}}},
+			actual:  newPlanTestUnit(s("data"), s("10")),
+			changes: &planTestUnit{Size: s("30")},
+			err:     "differently from the plan",
+		},
+	}
+	for _, test := range tests {
+		target := NewPlanTarget(&testTarget{}, &Plan{Changes: []*PlanChange{test.planned}})
+		expected := newPlanTestUnit(s("data"), test.changes.Size)
+		err := target.Render(test.actual, expected, test.changes)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+		}
+	}
+}
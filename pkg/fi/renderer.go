@@ -0,0 +1,54 @@
+package fi
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var renderersMutex sync.Mutex
+
+// renderers maps the type of a target to the interface units must implement to be rendered by it
+var renderers = make(map[reflect.Type]reflect.Type)
+
+// RegisterRenderer declares the interface that units must implement to be rendered to a type of target.
+// renderer is a nil pointer to the interface, for example:
+//
+//	RegisterRenderer(&AWSAPITarget{}, (*AWSRenderer)(nil))
+//
+// Targets that are not registered (e.g. DryRunTarget) accept any unit.
+func RegisterRenderer(target Target, renderer interface{}) {
+	rendererType := reflect.TypeOf(renderer)
+	if rendererType == nil || rendererType.Kind() != reflect.Ptr || rendererType.Elem().Kind() != reflect.Interface {
+		panic(fmt.Sprintf("renderer must be a nil pointer to an interface, was %T", renderer))
+	}
+
+	renderersMutex.Lock()
+	defer renderersMutex.Unlock()
+
+	renderers[reflect.TypeOf(target)] = rendererType.Elem()
+}
+
+// checkRenderer returns an error if the unit renders to targets, but does not support the type of this target
+func checkRenderer(target Target, unit Unit) error {
+	renderersMutex.Lock()
+	defer renderersMutex.Unlock()
+
+	required := renderers[reflect.TypeOf(target)]
+	if required == nil {
+		return nil
+	}
+
+	unitType := reflect.TypeOf(unit)
+	if unitType.Implements(required) {
+		return nil
+	}
+
+	// Units that don't render to any target (e.g. builders) are fine
+	for _, r := range renderers {
+		if unitType.Implements(r) {
+			return fmt.Errorf("%T does not support the %T target (must implement %v)", unit, target, required)
+		}
+	}
+	return nil
+}
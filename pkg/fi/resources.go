@@ -1,12 +1,12 @@
 package fi
 
 import (
-	"io"
 	"bytes"
-	"fmt"
-	"os"
 	"encoding/hex"
+	"fmt"
 	"hash"
+	"io"
+	"os"
 )
 
 type Resources interface {
@@ -160,7 +160,7 @@ type StringResource struct {
 var _ Resource = &StringResource{}
 
 func NewStringResource(s string) *StringResource {
-	return &StringResource{s:s}
+	return &StringResource{s: s}
 }
 
 func (s *StringResource) Open() (io.ReadSeeker, error) {
@@ -192,6 +192,38 @@ func (r *BytesResource) Open() (io.ReadSeeker, error) {
 	return reader, nil
 }
 
+// KnownAfterApply is implemented by resources whose contents are only known when a plan is applied, because they
+// depend on resources that will be created (e.g. the master certificate includes the IP that will be allocated)
+type KnownAfterApply interface {
+	IsKnownAfterApply() bool
+}
+
+// IsKnownAfterApply returns true if the contents of the resource are only known when a plan is applied
+func IsKnownAfterApply(r Resource) bool {
+	k, ok := r.(KnownAfterApply)
+	return ok && k.IsKnownAfterApply()
+}
+
+// PendingResource stands in (e.g. on the dry-run target) for a resource that is only built when a plan is applied
+type PendingResource struct {
+	description string
+}
+
+var _ Resource = &PendingResource{}
+var _ KnownAfterApply = &PendingResource{}
+
+func NewPendingResource(description string) *PendingResource {
+	return &PendingResource{description: description}
+}
+
+func (r *PendingResource) Open() (io.ReadSeeker, error) {
+	return bytes.NewReader([]byte("<" + r.description + ", known after apply>")), nil
+}
+
+func (r *PendingResource) IsKnownAfterApply() bool {
+	return true
+}
+
 type FileResource struct {
 	Path string
 }
@@ -199,7 +231,7 @@ type FileResource struct {
 var _ Resource = &FileResource{}
 
 func NewFileResource(path string) *FileResource {
-	return &FileResource{Path:path}
+	return &FileResource{Path: path}
 }
 func (r *FileResource) Open() (io.ReadSeeker, error) {
 	in, err := os.Open(r.Path)
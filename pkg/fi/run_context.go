@@ -1,9 +1,10 @@
 package fi
 
 import (
-	"github.com/golang/glog"
-	"reflect"
 	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
 )
 
 type RunMode int
@@ -18,18 +19,52 @@ type RunContext struct {
 
 	Target Target
 
+	// Parallelism is the maximum number of units that will be run concurrently
+	Parallelism int
+
 	parent *RunContext
 	node   *node
 	mode   RunMode
 
-	dirty  bool
+	dirty bool
+
+	// validation is shared by all the contexts of a run in ModeValidate
+	validation *validation
+}
+
+// validation records the drift found in ModeValidate, in place of making changes
+type validation struct {
+	mutex sync.Mutex
+	dirty bool
+	drift *DryRunTarget
 }
 
 func (c *RunContext) MarkDirty() {
 	c.dirty = true
 	if c.mode == ModeValidate {
 		glog.Infof("Configuration needed: %v", c.node.unit)
+		c.validation.mutex.Lock()
+		c.validation.dirty = true
+		c.validation.mutex.Unlock()
+	}
+}
+
+// IsDirty returns true if any unit found that configuration was needed in ModeValidate
+func (c *RunContext) IsDirty() bool {
+	if c.validation == nil {
+		return c.dirty
+	}
+	c.validation.mutex.Lock()
+	defer c.validation.mutex.Unlock()
+	return c.validation.dirty
+}
+
+// Drift returns the changes that would have been made, when run in ModeValidate
+func (c *RunContext) Drift() (*Plan, error) {
+	if c.validation == nil {
+		return nil, fmt.Errorf("drift is only recorded in validate mode")
 	}
+	return c.validation.drift.BuildPlan()
 }
 
 func (c *RunContext) IsConfigure() bool {
@@ -40,58 +75,103 @@ func (c *RunContext) IsValidate() bool {
 	return c.mode == ModeValidate
 }
 
+// IsDeclarative returns true if the target renders the complete configuration (e.g. terraform),
+// in which case units should treat all resources as new
+func (c *RunContext) IsDeclarative() bool {
+	_, ok := c.Target.(DeclarativeTarget)
+	return ok
+}
+
 func (c *RunContext) buildChildContext(n *node) *RunContext {
 	child := &RunContext{
-		Context: c.Context,
-		Target: c.Target,
-		parent:  c,
-		node:    n,
-		mode:    c.mode,
+		Context:    c.Context,
+		Target:     c.Target,
+		parent:     c,
+		node:       n,
+		mode:       c.mode,
+		validation: c.validation,
 	}
 	return child
 }
 
+// Run executes all the units, each after the units it depends on.
+// Up to Parallelism units whose dependencies are complete are run concurrently.
 func (c *RunContext) Run() error {
-	return c.node.Run(c)
-}
-
-func (c*RunContext) Render(a, e, changes Unit) error {
-	dryrun := false
-
-	var methodName string
-	switch c.Target.(type) {
-	case *AWSAPITarget:
-		methodName = "RenderAWS"
-	case *BashTarget:
-		methodName = "RenderBash"
-	case *DryRunTarget:
-		dryrun= true
-	default:
-		return fmt.Errorf("Unhandled target type: %T", c.Target)
+	order, err := c.Context.buildExecutionOrder()
+	if err != nil {
+		return err
 	}
 
-	if dryrun {
-		return c.Target.(*DryRunTarget).Render(a, e, changes)
+	parallelism := c.Parallelism
+	if concurrent, ok := c.Target.(ConcurrentTarget); !ok || !concurrent.IsConcurrent() {
+		// Running the units in order keeps the output (e.g. the bash script) the same on every run
+		parallelism = 1
+	}
+	if parallelism < 1 {
+		parallelism = 1
 	}
 
-	v := reflect.ValueOf(e)
-	vType := v.Type()
+	type result struct {
+		node *node
+		err  error
+	}
 
-	_, found := vType.MethodByName(methodName)
-	if !found {
-		return fmt.Errorf("Type does not support Render function %s: %T", methodName, v.Type())
+	work := make(chan *node)
+	results := make(chan *result, len(order))
+	for i := 0; i < parallelism; i++ {
+		go func() {
+			for n := range work {
+				childContext := c.buildChildContext(n)
+				err := n.Run(childContext)
+				results <- &result{node: n, err: err}
+			}
+		}()
 	}
-	var args  []reflect.Value
-	args = append(args, reflect.ValueOf(c.Target))
-	args = append(args, reflect.ValueOf(a))
-	args = append(args, reflect.ValueOf(e))
-	args = append(args, reflect.ValueOf(changes))
-	glog.V(4).Infof("Calling method %s on %T", methodName, e)
-	m := v.MethodByName(methodName)
-	rv := m.Call(args)
-	var rvErr error
-	if !rv[0].IsNil() {
-		rvErr = rv[0].Interface().(error)
+	defer close(work)
+
+	done := make(map[*node]bool)
+	running := make(map[*node]bool)
+	var runErr error
+	for {
+		// Once we have hit an error, we don't start anything new, but we wait for running units to finish
+		if runErr == nil {
+			for _, n := range order {
+				if len(running) >= parallelism {
+					break
+				}
+				if done[n] || running[n] || !n.isReady(done) {
+					continue
+				}
+				running[n] = true
+				work <- n
+			}
+		}
+
+		if len(running) == 0 {
+			break
+		}
+
+		r := <-results
+		delete(running, r.node)
+		done[r.node] = true
+		if r.err != nil {
+			if runErr == nil {
+				runErr = r.err
+			} else {
+				glog.Warningf("error running %v: %v", r.node, r.err)
+			}
+		}
 	}
-	return rvErr
-}
\ No newline at end of file
+
+	return runErr
+}
+
+func (c *RunContext) Render(a, e, changes Unit) error {
+	if c.mode == ModeValidate {
+		// Record the drift, but don't change anything
+		c.MarkDirty()
+		return c.validation.drift.Render(a, e, changes)
+	}
+	glog.V(4).Infof("Rendering %T to %T", e, c.Target)
+	return c.Target.Render(a, e, changes)
+}
@@ -0,0 +1,107 @@
+package fi
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runRecorder records the order in which units run, and how many ran at the same time
+type runRecorder struct {
+	mutex         sync.Mutex
+	order         []string
+	running       int
+	maxConcurrent int
+}
+
+type recordingUnit struct {
+	SimpleUnit
+	name     string
+	recorder *runRecorder
+}
+
+func (u *recordingUnit) Key() string {
+	return u.name
+}
+
+func (u *recordingUnit) Run(c *RunContext) error {
+	r := u.recorder
+	r.mutex.Lock()
+	r.order = append(r.order, u.name)
+	r.running++
+	if r.running > r.maxConcurrent {
+		r.maxConcurrent = r.running
+	}
+	r.mutex.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	r.mutex.Lock()
+	r.running--
+	r.mutex.Unlock()
+	return nil
+}
+
+type testTarget struct {
+	concurrent bool
+}
+
+var _ ConcurrentTarget = &testTarget{}
+
+func (t *testTarget) PutResource(key string, resource Resource, hashAlgorithm HashAlgorithm) (string, string, error) {
+	return "", "", fmt.Errorf("not implemented")
+}
+
+func (t *testTarget) Render(a, e, changes Unit) error {
+	return nil
+}
+
+func (t *testTarget) IsConcurrent() bool {
+	return t.concurrent
+}
+
+func runRecordingUnits(t *testing.T, target Target, parallelism int) *runRecorder {
+	context, err := NewContext(nil, nil)
+	if err != nil {
+		t.Fatalf("error building context: %v", err)
+	}
+	recorder := &runRecorder{}
+	bc := context.NewBuildContext(nil)
+	for i := 0; i < 8; i++ {
+		bc.Add(&recordingUnit{name: fmt.Sprintf("unit%d", i), recorder: recorder})
+	}
+
+	rc := context.NewRunContext(target, ModeConfigure)
+	rc.Parallelism = parallelism
+	err = rc.Run()
+	if err != nil {
+		t.Fatalf("error running units: %v", err)
+	}
+	return recorder
+}
+
+func TestRunParallelism(t *testing.T) {
+	expectedOrder := "unit0 unit1 unit2 unit3 unit4 unit5 unit6 unit7"
+
+	tests := []struct {
+		name          string
+		target        Target
+		maxConcurrent int
+	}{
+		{name: "concurrent target", target: &testTarget{concurrent: true}, maxConcurrent: 4},
+		{name: "sequential target", target: &testTarget{concurrent: false}, maxConcurrent: 1},
+		{name: "plan of concurrent target", target: NewPlanTarget(&testTarget{concurrent: true}, &Plan{}), maxConcurrent: 4},
+		{name: "plan of sequential target", target: NewPlanTarget(&testTarget{concurrent: false}, &Plan{}), maxConcurrent: 1},
+	}
+	for _, test := range tests {
+		r := runRecordingUnits(t, test.target, 4)
+		if r.maxConcurrent > test.maxConcurrent {
+			t.Errorf("%s: %d units ran at the same time, expected at most %d", test.name, r.maxConcurrent, test.maxConcurrent)
+		}
+		if test.maxConcurrent == 1 && strings.Join(r.order, " ") != expectedOrder {
+			t.Errorf("%s: units ran in order %v, expected %s", test.name, r.order, expectedOrder)
+		}
+	}
+}
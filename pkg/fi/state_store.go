@@ -0,0 +1,75 @@
+package fi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// StateStore holds the configuration and PKI for a cluster, so that they can be shared between everyone who
+// operates on the cluster.  Paths are slash-separated and relative to the root of the store.
+type StateStore interface {
+	// ReadFile returns the contents of the file, or nil if it does not exist
+	ReadFile(p string) ([]byte, error)
+	// WriteFile creates or replaces the file
+	WriteFile(p string, data []byte) error
+	// DeleteFile removes the file; it is not an error if it does not exist
+	DeleteFile(p string) error
+	// Location returns where the file is stored, for messages
+	Location(p string) string
+}
+
+// FilesystemStateStore is a StateStore in a local directory
+type FilesystemStateStore struct {
+	basedir string
+}
+
+var _ StateStore = &FilesystemStateStore{}
+
+func NewFilesystemStateStore(basedir string) *FilesystemStateStore {
+	return &FilesystemStateStore{basedir: basedir}
+}
+
+func (s *FilesystemStateStore) Location(p string) string {
+	return path.Join(s.basedir, p)
+}
+
+func (s *FilesystemStateStore) ReadFile(p string) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.Location(p))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading %q: %v", s.Location(p), err)
+	}
+	return data, nil
+}
+
+func (s *FilesystemStateStore) WriteFile(p string, data []byte) error {
+	dest := s.Location(p)
+	err := os.MkdirAll(path.Dir(dest), 0700)
+	if err != nil {
+		return fmt.Errorf("error creating directory: %v", err)
+	}
+
+	// Write & rename, so we never leave a partial file
+	tmp := dest + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing %q: %v", tmp, err)
+	}
+	err = os.Rename(tmp, dest)
+	if err != nil {
+		return fmt.Errorf("error writing %q: %v", dest, err)
+	}
+	return nil
+}
+
+func (s *FilesystemStateStore) DeleteFile(p string) error {
+	err := os.Remove(s.Location(p))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error deleting %q: %v", s.Location(p), err)
+	}
+	return nil
+}
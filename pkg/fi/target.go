@@ -2,4 +2,24 @@ package fi
 
 type Target interface {
 	PutResource(key string, resource Resource, hashAlgorithm HashAlgorithm) (url string, hash string, err error)
+
+	// Render applies the changes for a unit; a is the actual state (nil if not found), e is the expected state
+	Render(a, e, changes Unit) error
+}
+
+// A DeclarativeTarget renders the complete desired configuration, rather than the changes
+// needed to reach it from the actual state; units therefore do not look for existing resources.
+type DeclarativeTarget interface {
+	Target
+
+	// Finish writes out the rendered configuration, once all units have run
+	Finish() error
+}
+
+// A ConcurrentTarget can render several units at the same time.  Other targets are given the units one at a time,
+// in the execution order, because they write out the units in the order they are rendered.
+type ConcurrentTarget interface {
+	Target
+
+	IsConcurrent() bool
 }
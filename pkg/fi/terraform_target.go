@@ -0,0 +1,201 @@
+package fi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// TerraformTarget renders units as a terraform configuration (in the JSON syntax),
+// so that the cluster can be managed alongside other terraform-managed infrastructure.
+type TerraformTarget struct {
+	Cloud     *AWSCloud
+	filestore FileStore
+	outDir    string
+
+	mutex sync.Mutex
+	// resources maps resource type -> resource name -> attributes
+	resources map[string]map[string]map[string]interface{}
+	files     map[string][]byte
+}
+
+var _ Target = &TerraformTarget{}
+var _ DeclarativeTarget = &TerraformTarget{}
+
+// TerraformRenderer is implemented by units that can be rendered to terraform
+type TerraformRenderer interface {
+	RenderTerraform(t *TerraformTarget, a, e, changes Unit) error
+}
+
+func init() {
+	RegisterRenderer(&TerraformTarget{}, (*TerraformRenderer)(nil))
+}
+
+func NewTerraformTarget(cloud *AWSCloud, filestore FileStore, outDir string) *TerraformTarget {
+	return &TerraformTarget{
+		Cloud:     cloud,
+		filestore: filestore,
+		outDir:    outDir,
+		resources: make(map[string]map[string]map[string]interface{}),
+		files:     make(map[string][]byte),
+	}
+}
+
+func (t *TerraformTarget) PutResource(key string, r Resource, hashAlgorithm HashAlgorithm) (string, string, error) {
+	if r == nil {
+		glog.Fatalf("Attempt to put null resource for %q", key)
+	}
+	return t.filestore.PutResource(key, r, hashAlgorithm)
+}
+
+func (t *TerraformTarget) Render(a, e, changes Unit) error {
+	r, ok := e.(TerraformRenderer)
+	if !ok {
+		return fmt.Errorf("%T does not support the terraform target", e)
+	}
+	return r.RenderTerraform(t, a, e, changes)
+}
+
+var terraformInvalidNameChars = regexp.MustCompile("[^a-zA-Z0-9_-]")
+
+// TerraformName converts a unit key to a valid terraform resource name
+func TerraformName(key string) string {
+	name := terraformInvalidNameChars.ReplaceAllString(key, "_")
+	if name == "" || !((name[0] >= 'a' && name[0] <= 'z') || (name[0] >= 'A' && name[0] <= 'Z') || name[0] == '_') {
+		name = "_" + name
+	}
+	return name
+}
+
+// TerraformReference returns an interpolation expression for an attribute of another terraform resource
+func TerraformReference(resourceType, key, attribute string) string {
+	return "${" + resourceType + "." + TerraformName(key) + "." + attribute + "}"
+}
+
+// RenderResource adds a resource to the configuration; e is serialized using its json tags.
+// If a resource with the same type & key has already been rendered, the attributes are merged;
+// this lets association units (like IAMInstanceProfileRole) contribute to the resource they associate.
+func (t *TerraformTarget) RenderResource(resourceType, key string, e interface{}) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error serializing terraform resource %s.%s: %v", resourceType, key, err)
+	}
+	attributes := make(map[string]interface{})
+	err = json.Unmarshal(data, &attributes)
+	if err != nil {
+		return fmt.Errorf("error serializing terraform resource %s.%s: %v", resourceType, key, err)
+	}
+
+	name := TerraformName(key)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	byName := t.resources[resourceType]
+	if byName == nil {
+		byName = make(map[string]map[string]interface{})
+		t.resources[resourceType] = byName
+	}
+	existing := byName[name]
+	if existing == nil {
+		byName[name] = attributes
+		return nil
+	}
+	return mergeAttributes(existing, attributes, resourceType+"."+name)
+}
+
+// mergeAttributes merges src into dest, recursing into nested maps; any other duplicate is an error
+func mergeAttributes(dest, src map[string]interface{}, path string) error {
+	for k, v := range src {
+		existing, found := dest[k]
+		if !found {
+			dest[k] = v
+			continue
+		}
+		existingMap, ok1 := existing.(map[string]interface{})
+		vMap, ok2 := v.(map[string]interface{})
+		if !ok1 || !ok2 {
+			return fmt.Errorf("duplicate value for %q in resource %s", k, path)
+		}
+		err := mergeAttributes(existingMap, vMap, path+"."+k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddFile stores a resource alongside the configuration, and returns an expression that reads it.
+// This keeps large values (user-data, policy documents) out of the main configuration file.
+func (t *TerraformTarget) AddFile(resourceType, key, field string, r Resource) (string, error) {
+	p, err := t.AddFilePath(resourceType, key, field, r)
+	if err != nil {
+		return "", err
+	}
+	return "${file(\"" + p + "\")}", nil
+}
+
+// AddFilePath stores a resource alongside the configuration, and returns its path
+func (t *TerraformTarget) AddFilePath(resourceType, key, field string, r Resource) (string, error) {
+	data, err := ResourceAsBytes(r)
+	if err != nil {
+		return "", fmt.Errorf("error rendering %s for terraform resource %s.%s: %v", field, resourceType, key, err)
+	}
+
+	p := path.Join("data", resourceType+"_"+TerraformName(key)+"_"+field)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.files[p] = data
+
+	return "${path.module}/" + p, nil
+}
+
+// Finish writes the terraform configuration to the output directory
+func (t *TerraformTarget) Finish() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for p, data := range t.files {
+		err := t.writeFile(p, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	config := make(map[string]interface{})
+	config["provider"] = map[string]interface{}{
+		"aws": map[string]interface{}{
+			"region": t.Cloud.Region,
+		},
+	}
+	config["resource"] = t.resources
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing terraform configuration: %v", err)
+	}
+	return t.writeFile("kubernetes.tf.json", data)
+}
+
+func (t *TerraformTarget) writeFile(relativePath string, data []byte) error {
+	p := path.Join(t.outDir, relativePath)
+	err := os.MkdirAll(path.Dir(p), 0755)
+	if err != nil {
+		return fmt.Errorf("error creating directory %q: %v", path.Dir(p), err)
+	}
+	// Files can contain secrets (e.g. in user-data)
+	err = ioutil.WriteFile(p, data, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing file %q: %v", p, err)
+	}
+	glog.V(2).Infof("Wrote %s", p)
+	return nil
+}
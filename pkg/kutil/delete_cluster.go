@@ -2,14 +2,13 @@ package kutil
 
 import (
 	"fmt"
-	"github.com/golang/glog"
-	"github.com/kopeio/kope/pkg/fi"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/autoscaling"
-	"github.com/aws/aws-sdk-go/service/elb"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"encoding/base64"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
 	"strings"
 )
 
@@ -17,9 +16,12 @@ type DeleteCluster struct {
 	ClusterID string
 	Zone      string
 	Cloud     fi.Cloud
+
+	// S3Bucket is the bucket holding the cluster artifacts; if set, we delete the cluster's artifacts
+	S3Bucket string
 }
 
-func (c*DeleteCluster)  ListResources() ([]DeletableResource, error) {
+func (c *DeleteCluster) ListResources() ([]DeletableResource, error) {
 	cloud := c.Cloud.(*fi.AWSCloud)
 
 	var resources []DeletableResource
@@ -34,85 +36,90 @@ func (c*DeleteCluster)  ListResources() ([]DeletableResource, error) {
 			var asFilters []*autoscaling.Filter
 			for _, f := range filters {
 				asFilters = append(asFilters, &autoscaling.Filter{
-					Name: aws.String("value"),
+					Name:   aws.String("value"),
 					Values: f.Values,
 				})
 			}
 			request := &autoscaling.DescribeTagsInput{
 				Filters: asFilters,
 			}
-			response, err := cloud.Autoscaling.DescribeTags(request)
+			err := cloud.Autoscaling.DescribeTagsPages(request, func(p *autoscaling.DescribeTagsOutput, lastPage bool) bool {
+				for _, t := range p.Tags {
+					switch *t.ResourceType {
+					case "auto-scaling-group":
+						asgNames = append(asgNames, t.ResourceId)
+					default:
+						glog.Warningf("Unknown resource type: %v", *t.ResourceType)
+
+					}
+				}
+				return true
+			})
 			if err != nil {
 				return nil, fmt.Errorf("error listing autoscaling cluster tags: %v", err)
 			}
-
-			for _, t := range response.Tags {
-				switch (*t.ResourceType) {
-				case "auto-scaling-group":
-					asgNames = append(asgNames, t.ResourceId)
-				default:
-					glog.Warningf("Unknown resource type: %v", *t.ResourceType)
-
-				}
-			}
 		}
 
 		if len(asgNames) != 0 {
 			request := &autoscaling.DescribeAutoScalingGroupsInput{
 				AutoScalingGroupNames: asgNames,
 			}
-			response, err := cloud.Autoscaling.DescribeAutoScalingGroups(request)
+			err := cloud.Autoscaling.DescribeAutoScalingGroupsPages(request, func(p *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+				for _, t := range p.AutoScalingGroups {
+					if !matchesAsgTags(tags, t.Tags) {
+						continue
+					}
+					resources = append(resources, &DeletableASG{Name: *t.AutoScalingGroupName})
+				}
+				return true
+			})
 			if err != nil {
 				return nil, fmt.Errorf("error listing autoscaling groups: %v", err)
 			}
-
-			for _, t := range response.AutoScalingGroups {
-				if !matchesAsgTags(tags, t.Tags) {
-					continue
-				}
-				resources = append(resources, &DeletableASG{Name: *t.AutoScalingGroupName })
-			}
 		}
 	}
 
 	{
 		glog.V(2).Infof("Listing all Autoscaling LaunchConfigurations")
 
-		request := &autoscaling.DescribeLaunchConfigurationsInput{
-		}
-		response, err := cloud.Autoscaling.DescribeLaunchConfigurations(request)
-		if err != nil {
-			return nil, fmt.Errorf("error listing autoscaling LaunchConfigurations: %v", err)
-		}
-
-		for _, t := range response.LaunchConfigurations {
-			if t.UserData == nil {
-				continue
-			}
+		request := &autoscaling.DescribeLaunchConfigurationsInput{}
+		err := cloud.Autoscaling.DescribeLaunchConfigurationsPages(request, func(p *autoscaling.DescribeLaunchConfigurationsOutput, lastPage bool) bool {
+			for _, t := range p.LaunchConfigurations {
+				if t.UserData == nil {
+					continue
+				}
 
-			userData, err := base64.StdEncoding.DecodeString(*t.UserData)
-			if err != nil {
-				glog.Infof("Ignoring autoscaling LaunchConfiguration with invalid UserData: %v", *t.LaunchConfigurationName)
-				continue
-			}
+				userData, err := decodeUserData(*t.UserData)
+				if err != nil {
+					glog.Infof("Ignoring autoscaling LaunchConfiguration with invalid UserData: %v", *t.LaunchConfigurationName)
+					continue
+				}
 
-			if strings.Contains(string(userData), "\nINSTANCE_PREFIX: " + c.ClusterID + "\n") {
-				resources = append(resources, &DeletableAutoscalingLaunchConfiguration{Name: *t.LaunchConfigurationName })
+				if isClusterUserData(userData, c.ClusterID) {
+					resources = append(resources, &DeletableAutoscalingLaunchConfiguration{Name: *t.LaunchConfigurationName})
+				}
 			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing autoscaling LaunchConfigurations: %v", err)
 		}
 	}
 
 	{
 		glog.V(2).Infof("Listing all ELB tags")
 
-		request := &elb.DescribeLoadBalancersInput{
-		}
-		response, err := cloud.ELB.DescribeLoadBalancers(request)
+		request := &elb.DescribeLoadBalancersInput{}
+		var loadBalancers []*elb.LoadBalancerDescription
+		err := cloud.ELB.DescribeLoadBalancersPages(request, func(p *elb.DescribeLoadBalancersOutput, lastPage bool) bool {
+			loadBalancers = append(loadBalancers, p.LoadBalancerDescriptions...)
+			return true
+		})
 		if err != nil {
 			return nil, fmt.Errorf("error listing elb LoadBalancers: %v", err)
 		}
 
-		for _, lb := range response.LoadBalancerDescriptions {
+		for _, lb := range loadBalancers {
 			// TODO: batch?
 			request := &elb.DescribeTagsInput{
 				LoadBalancerNames: []*string{lb.LoadBalancerName},
@@ -126,7 +133,7 @@ func (c*DeleteCluster)  ListResources() ([]DeletableResource, error) {
 				if !matchesElbTags(tags, t.Tags) {
 					continue
 				}
-				resources = append(resources, &DeletableELBLoadBalancer{Name: *t.LoadBalancerName })
+				resources = append(resources, &DeletableELBLoadBalancer{Name: *t.LoadBalancerName})
 			}
 		}
 	}
@@ -137,14 +144,36 @@ func (c*DeleteCluster)  ListResources() ([]DeletableResource, error) {
 		request := &ec2.DescribeTagsInput{
 			Filters: filters,
 		}
-		response, err := cloud.EC2.DescribeTags(request)
+		var ec2Tags []*ec2.TagDescription
+		err := cloud.EC2.DescribeTagsPages(request, func(p *ec2.DescribeTagsOutput, lastPage bool) bool {
+			ec2Tags = append(ec2Tags, p.Tags...)
+			return true
+		})
 		if err != nil {
 			return nil, fmt.Errorf("error listing cluster tags: %v", err)
 		}
 
-		for _, t := range response.Tags {
+		// We get a tag for each tag on a matching resource, so we see each resource more than once
+		seen := make(map[string]bool)
+		for _, t := range ec2Tags {
+			// Elastic IPs can't be tagged, so the master IP is recorded as a tag on another resource
+			if aws.StringValue(t.Key) == masterIPTag {
+				resource, err := findElasticIP(cloud, aws.StringValue(t.Value))
+				if err != nil {
+					return nil, err
+				}
+				if resource != nil {
+					resources = append(resources, resource)
+				}
+			}
+
+			if seen[*t.ResourceId] {
+				continue
+			}
+			seen[*t.ResourceId] = true
+
 			var resource DeletableResource
-			switch (*t.ResourceType) {
+			switch *t.ResourceType {
 			case "instance":
 				resource = &DeletableInstance{ID: *t.ResourceId}
 			case "volume":
@@ -159,6 +188,8 @@ func (c*DeleteCluster)  ListResources() ([]DeletableResource, error) {
 				resource = &DeletableRouteTable{ID: *t.ResourceId}
 			case "vpc":
 				resource = &DeletableVPC{ID: *t.ResourceId}
+			case "dhcp-options":
+				resource = &DeletableDHCPOptions{ID: *t.ResourceId}
 			}
 
 			if resource == nil {
@@ -170,6 +201,24 @@ func (c*DeleteCluster)  ListResources() ([]DeletableResource, error) {
 		}
 	}
 
+	if c.S3Bucket != "" {
+		bucket, err := cloud.S3.FindBucketIfExists(c.S3Bucket)
+		if err != nil {
+			return nil, err
+		}
+		if bucket == nil {
+			glog.V(2).Infof("S3 bucket %q does not exist", c.S3Bucket)
+		} else {
+			objects, err := bucket.ListObjects(ArtifactsPrefix(c.ClusterID))
+			if err != nil {
+				return nil, err
+			}
+			for _, o := range objects {
+				resources = append(resources, &DeletableS3Object{Object: o})
+			}
+		}
+	}
+
 	return resources, nil
 }
 
@@ -213,24 +262,53 @@ type DeletableResource interface {
 	Delete(cloud fi.Cloud) error
 }
 
+// masterIPTag is the tag in which the master's elastic IP is recorded
+const masterIPTag = "kubernetes.io/master-ip"
+
+func findElasticIP(cloud *fi.AWSCloud, publicIP string) (DeletableResource, error) {
+	request := &ec2.DescribeAddressesInput{
+		Filters: []*ec2.Filter{fi.NewEC2Filter("public-ip", publicIP)},
+	}
+	response, err := cloud.EC2.DescribeAddresses(request)
+	if err != nil {
+		return nil, fmt.Errorf("error listing elastic IPs: %v", err)
+	}
+	if len(response.Addresses) == 0 {
+		return nil, nil
+	}
+	return &DeletableElasticIP{ID: aws.StringValue(response.Addresses[0].AllocationId)}, nil
+}
+
+// isNotFoundError returns true if the error means the resource does not exist (i.e. it is already deleted)
+func isNotFoundError(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		code := awsErr.Code()
+		return strings.HasSuffix(code, ".NotFound") || code == "NoSuchEntity"
+	}
+	return false
+}
+
 type DeletableInstance struct {
 	ID string
 }
 
-func (r*DeletableInstance) Delete(cloud fi.Cloud) error {
+func (r *DeletableInstance) Delete(cloud fi.Cloud) error {
 	c := cloud.(*fi.AWSCloud)
 
 	glog.V(2).Infof("Deleting EC2 instance %q", r.ID)
 	request := &ec2.TerminateInstancesInput{
-		InstanceIds: []*string{&r.ID },
+		InstanceIds: []*string{&r.ID},
 	}
 	_, err := c.EC2.TerminateInstances(request)
 	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
 		return fmt.Errorf("error deleting instance %q: %v", r.ID, err)
 	}
 	return nil
 }
-func (r*DeletableInstance) String() string {
+func (r *DeletableInstance) String() string {
 	return "instance:" + r.ID
 }
 
@@ -238,7 +316,7 @@ type DeletableSecurityGroup struct {
 	ID string
 }
 
-func (r*DeletableSecurityGroup) Delete(cloud fi.Cloud) error {
+func (r *DeletableSecurityGroup) Delete(cloud fi.Cloud) error {
 	c := cloud.(*fi.AWSCloud)
 
 	// First clear all inter-dependent rules
@@ -249,6 +327,9 @@ func (r*DeletableSecurityGroup) Delete(cloud fi.Cloud) error {
 		}
 		response, err := c.EC2.DescribeSecurityGroups(request)
 		if err != nil {
+			if isNotFoundError(err) {
+				return nil
+			}
 			return fmt.Errorf("error describing SecurityGroup %q: %v", r.ID, err)
 		}
 
@@ -262,7 +343,7 @@ func (r*DeletableSecurityGroup) Delete(cloud fi.Cloud) error {
 
 		if len(sg.IpPermissions) != 0 {
 			revoke := &ec2.RevokeSecurityGroupIngressInput{
-				GroupId: &r.ID,
+				GroupId:       &r.ID,
 				IpPermissions: sg.IpPermissions,
 			}
 			_, err = c.EC2.RevokeSecurityGroupIngress(revoke)
@@ -284,7 +365,7 @@ func (r*DeletableSecurityGroup) Delete(cloud fi.Cloud) error {
 	}
 	return nil
 }
-func (r*DeletableSecurityGroup) String() string {
+func (r *DeletableSecurityGroup) String() string {
 	return "SecurityGroup:" + r.ID
 }
 
@@ -292,7 +373,7 @@ type DeletableVolume struct {
 	ID string
 }
 
-func (r*DeletableVolume) Delete(cloud fi.Cloud) error {
+func (r *DeletableVolume) Delete(cloud fi.Cloud) error {
 	c := cloud.(*fi.AWSCloud)
 
 	glog.V(2).Infof("Deleting EC2 volume %q", r.ID)
@@ -301,16 +382,14 @@ func (r*DeletableVolume) Delete(cloud fi.Cloud) error {
 	}
 	_, err := c.EC2.DeleteVolume(request)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == "InvalidVolume.NotFound" {
-				return nil
-			}
+		if isNotFoundError(err) {
+			return nil
 		}
 		return fmt.Errorf("error deleting volume %q: %v", r.ID, err)
 	}
 	return nil
 }
-func (r*DeletableVolume) String() string {
+func (r *DeletableVolume) String() string {
 	return "volume:" + r.ID
 }
 
@@ -318,7 +397,7 @@ type DeletableSubnet struct {
 	ID string
 }
 
-func (r*DeletableSubnet) Delete(cloud fi.Cloud) error {
+func (r *DeletableSubnet) Delete(cloud fi.Cloud) error {
 	c := cloud.(*fi.AWSCloud)
 
 	glog.V(2).Infof("Deleting EC2 Subnet %q", r.ID)
@@ -327,11 +406,14 @@ func (r*DeletableSubnet) Delete(cloud fi.Cloud) error {
 	}
 	_, err := c.EC2.DeleteSubnet(request)
 	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
 		return fmt.Errorf("error deleting Subnet %q: %v", r.ID, err)
 	}
 	return nil
 }
-func (r*DeletableSubnet) String() string {
+func (r *DeletableSubnet) String() string {
 	return "Subnet:" + r.ID
 }
 
@@ -339,7 +421,7 @@ type DeletableRouteTable struct {
 	ID string
 }
 
-func (r*DeletableRouteTable) Delete(cloud fi.Cloud) error {
+func (r *DeletableRouteTable) Delete(cloud fi.Cloud) error {
 	c := cloud.(*fi.AWSCloud)
 
 	glog.V(2).Infof("Deleting EC2 RouteTable %q", r.ID)
@@ -348,11 +430,14 @@ func (r*DeletableRouteTable) Delete(cloud fi.Cloud) error {
 	}
 	_, err := c.EC2.DeleteRouteTable(request)
 	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
 		return fmt.Errorf("error deleting RouteTable %q: %v", r.ID, err)
 	}
 	return nil
 }
-func (r*DeletableRouteTable) String() string {
+func (r *DeletableRouteTable) String() string {
 	return "RouteTable:" + r.ID
 }
 
@@ -360,7 +445,7 @@ type DeletableInternetGateway struct {
 	ID string
 }
 
-func (r*DeletableInternetGateway) Delete(cloud fi.Cloud) error {
+func (r *DeletableInternetGateway) Delete(cloud fi.Cloud) error {
 	c := cloud.(*fi.AWSCloud)
 
 	var igw *ec2.InternetGateway
@@ -370,6 +455,9 @@ func (r*DeletableInternetGateway) Delete(cloud fi.Cloud) error {
 		}
 		response, err := c.EC2.DescribeInternetGateways(request)
 		if err != nil {
+			if isNotFoundError(err) {
+				return nil
+			}
 			return fmt.Errorf("error describing InternetGateway %q: %v", r.ID, err)
 		}
 		if response == nil || len(response.InternetGateways) == 0 {
@@ -385,7 +473,7 @@ func (r*DeletableInternetGateway) Delete(cloud fi.Cloud) error {
 		glog.V(2).Infof("Detaching EC2 InternetGateway %q", r.ID)
 		request := &ec2.DetachInternetGatewayInput{
 			InternetGatewayId: &r.ID,
-			VpcId: a.VpcId,
+			VpcId:             a.VpcId,
 		}
 		_, err := c.EC2.DetachInternetGateway(request)
 		if err != nil {
@@ -406,7 +494,7 @@ func (r*DeletableInternetGateway) Delete(cloud fi.Cloud) error {
 
 	return nil
 }
-func (r*DeletableInternetGateway) String() string {
+func (r *DeletableInternetGateway) String() string {
 	return "InternetGateway:" + r.ID
 }
 
@@ -414,7 +502,7 @@ type DeletableVPC struct {
 	ID string
 }
 
-func (r*DeletableVPC) Delete(cloud fi.Cloud) error {
+func (r *DeletableVPC) Delete(cloud fi.Cloud) error {
 	c := cloud.(*fi.AWSCloud)
 
 	glog.V(2).Infof("Deleting EC2 VPC %q", r.ID)
@@ -423,11 +511,14 @@ func (r*DeletableVPC) Delete(cloud fi.Cloud) error {
 	}
 	_, err := c.EC2.DeleteVpc(request)
 	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
 		return fmt.Errorf("error deleting VPC %q: %v", r.ID, err)
 	}
 	return nil
 }
-func (r*DeletableVPC) String() string {
+func (r *DeletableVPC) String() string {
 	return "VPC:" + r.ID
 }
 
@@ -435,13 +526,13 @@ type DeletableASG struct {
 	Name string
 }
 
-func (r*DeletableASG) Delete(cloud fi.Cloud) error {
+func (r *DeletableASG) Delete(cloud fi.Cloud) error {
 	c := cloud.(*fi.AWSCloud)
 
 	glog.V(2).Infof("Deleting autoscaling group %q", r.Name)
 	request := &autoscaling.DeleteAutoScalingGroupInput{
 		AutoScalingGroupName: &r.Name,
-		ForceDelete: aws.Bool(true),
+		ForceDelete:          aws.Bool(true),
 	}
 	_, err := c.Autoscaling.DeleteAutoScalingGroup(request)
 	if err != nil {
@@ -449,7 +540,7 @@ func (r*DeletableASG) Delete(cloud fi.Cloud) error {
 	}
 	return nil
 }
-func (r*DeletableASG) String() string {
+func (r *DeletableASG) String() string {
 	return "autoscaling-group:" + r.Name
 }
 
@@ -457,7 +548,7 @@ type DeletableAutoscalingLaunchConfiguration struct {
 	Name string
 }
 
-func (r*DeletableAutoscalingLaunchConfiguration) Delete(cloud fi.Cloud) error {
+func (r *DeletableAutoscalingLaunchConfiguration) Delete(cloud fi.Cloud) error {
 	c := cloud.(*fi.AWSCloud)
 
 	glog.V(2).Infof("Deleting autoscaling LaunchConfiguration %q", r.Name)
@@ -471,7 +562,7 @@ func (r*DeletableAutoscalingLaunchConfiguration) Delete(cloud fi.Cloud) error {
 	return nil
 }
 
-func (r*DeletableAutoscalingLaunchConfiguration) String() string {
+func (r *DeletableAutoscalingLaunchConfiguration) String() string {
 	return "autoscaling-launchconfiguration:" + r.Name
 }
 
@@ -479,7 +570,7 @@ type DeletableELBLoadBalancer struct {
 	Name string
 }
 
-func (r*DeletableELBLoadBalancer) Delete(cloud fi.Cloud) error {
+func (r *DeletableELBLoadBalancer) Delete(cloud fi.Cloud) error {
 	c := cloud.(*fi.AWSCloud)
 
 	glog.V(2).Infof("Deleting LoadBalancer %q", r.Name)
@@ -493,8 +584,18 @@ func (r*DeletableELBLoadBalancer) Delete(cloud fi.Cloud) error {
 	return nil
 }
 
-func (r*DeletableELBLoadBalancer) String() string {
+func (r *DeletableELBLoadBalancer) String() string {
 	return "LoadBalancer:" + r.Name
 }
 
+type DeletableS3Object struct {
+	Object *fi.S3Object
+}
 
+func (r *DeletableS3Object) Delete(cloud fi.Cloud) error {
+	return r.Object.Delete()
+}
+
+func (r *DeletableS3Object) String() string {
+	return "S3Object:" + r.Object.String()
+}
@@ -2,9 +2,9 @@ package kutil
 
 import (
 	"fmt"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
-	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
 type DiscoverClusters struct {
@@ -16,7 +16,7 @@ type DiscoveredCluster struct {
 	ClusterID string
 }
 
-func (c*DiscoverClusters)  ListClusters() (map[string]*DiscoveredCluster, error) {
+func (c *DiscoverClusters) ListClusters() (map[string]*DiscoveredCluster, error) {
 	cloud := c.Cloud.(*fi.AWSCloud)
 
 	clusters := make(map[string]*DiscoveredCluster)
@@ -29,17 +29,18 @@ func (c*DiscoverClusters)  ListClusters() (map[string]*DiscoveredCluster, error)
 		request := &ec2.DescribeTagsInput{
 			Filters: filters,
 		}
-		response, err := cloud.EC2.DescribeTags(request)
+		err := cloud.EC2.DescribeTagsPages(request, func(p *ec2.DescribeTagsOutput, lastPage bool) bool {
+			for _, t := range p.Tags {
+				clusterID := *t.Value
+				if clusters[clusterID] == nil {
+					clusters[clusterID] = &DiscoveredCluster{ClusterID: clusterID}
+				}
+			}
+			return true
+		})
 		if err != nil {
 			return nil, fmt.Errorf("error listing cluster tags: %v", err)
 		}
-
-		for _, t := range response.Tags {
-			clusterID := *t.Value
-			if clusters[clusterID] == nil {
-				clusters[clusterID] = &DiscoveredCluster{ClusterID: clusterID}
-			}
-		}
 	}
 
 	return clusters, nil
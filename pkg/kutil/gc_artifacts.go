@@ -0,0 +1,206 @@
+package kutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+)
+
+// ArtifactsPrefix is the prefix in the artifacts bucket under which we store the files for a cluster
+func ArtifactsPrefix(clusterID string) string {
+	return "devel/" + clusterID + "/"
+}
+
+// GCArtifacts finds the artifacts for a cluster that are no longer referenced by the user-data of any
+// of the cluster's instances or launch configurations
+type GCArtifacts struct {
+	ClusterID string
+	Cloud     *fi.AWSCloud
+	Bucket    *fi.S3Bucket
+
+	// MinAge protects recently uploaded artifacts, which a create that is still running may not yet have referenced
+	MinAge time.Duration
+}
+
+func (g *GCArtifacts) FindUnreferenced() ([]*fi.S3Object, error) {
+	userData, err := g.findUserData()
+	if err != nil {
+		return nil, err
+	}
+	if len(userData) == 0 {
+		// Otherwise we would delete everything; that is what delete cluster is for
+		return nil, fmt.Errorf("found no instances or launch configurations for cluster %q", g.ClusterID)
+	}
+
+	objects, err := g.Bucket.ListObjects(ArtifactsPrefix(g.ClusterID))
+	if err != nil {
+		return nil, err
+	}
+
+	referencedKeys := g.referencedKeys(userData)
+	cutoff := time.Now().Add(-g.MinAge)
+
+	var unreferenced []*fi.S3Object
+	for _, o := range objects {
+		if referencedKeys[o.Key] {
+			glog.V(2).Infof("Object is referenced: %s", o)
+			continue
+		}
+		if o.LastModified.After(cutoff) {
+			glog.Infof("Keeping recently uploaded object: %s", o)
+			continue
+		}
+		unreferenced = append(unreferenced, o)
+	}
+	return unreferenced, nil
+}
+
+// FindAbandonedUploads finds the incomplete multipart uploads of the cluster's artifacts that are older than MinAge;
+// a create that failed part way through leaves its upload in place, so that the next attempt can resume it
+func (g *GCArtifacts) FindAbandonedUploads() ([]*fi.S3IncompleteUpload, error) {
+	uploads, err := g.Bucket.ListIncompleteUploads(ArtifactsPrefix(g.ClusterID))
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-g.MinAge)
+
+	var abandoned []*fi.S3IncompleteUpload
+	for _, u := range uploads {
+		if u.Initiated.After(cutoff) {
+			glog.Infof("Keeping recently started upload: %s", u)
+			continue
+		}
+		abandoned = append(abandoned, u)
+	}
+	return abandoned, nil
+}
+
+var userDataURLRegexp = regexp.MustCompile(`https?://[^\s'"]+`)
+
+// referencedKeys returns the keys of the objects in our bucket whose URLs appear in the user-data.  The URLs are
+// either public (path-style) URLs or presigned URLs, which may be virtual-hosted style.
+func (g *GCArtifacts) referencedKeys(userData []string) map[string]bool {
+	bucket := g.Bucket.Name
+	keys := make(map[string]bool)
+	for _, d := range userData {
+		for _, s := range userDataURLRegexp.FindAllString(d, -1) {
+			u, err := url.Parse(s)
+			if err != nil {
+				glog.V(2).Infof("Ignoring invalid URL in user-data: %q", s)
+				continue
+			}
+			if strings.HasPrefix(u.Host, bucket+".") {
+				keys[strings.TrimPrefix(u.Path, "/")] = true
+			} else if strings.HasPrefix(u.Path, "/"+bucket+"/") {
+				keys[strings.TrimPrefix(u.Path, "/"+bucket+"/")] = true
+			}
+		}
+	}
+	return keys
+}
+
+// findUserData returns the decoded user-data of the cluster's instances and launch configurations
+func (g *GCArtifacts) findUserData() ([]string, error) {
+	cloud := g.Cloud
+
+	var userData []string
+
+	{
+		glog.V(2).Infof("Listing all EC2 instances matching cluster tags")
+		request := &ec2.DescribeInstancesInput{
+			Filters: cloud.BuildFilters(nil),
+		}
+		var instanceIDs []string
+		err := cloud.EC2.DescribeInstancesPages(request, func(p *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, r := range p.Reservations {
+				for _, i := range r.Instances {
+					if i.State != nil && aws.StringValue(i.State.Name) == "terminated" {
+						continue
+					}
+					instanceIDs = append(instanceIDs, aws.StringValue(i.InstanceId))
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing cluster instances: %v", err)
+		}
+
+		for _, id := range instanceIDs {
+			request := &ec2.DescribeInstanceAttributeInput{
+				InstanceId: aws.String(id),
+				Attribute:  aws.String("userData"),
+			}
+			response, err := cloud.EC2.DescribeInstanceAttribute(request)
+			if err != nil {
+				return nil, fmt.Errorf("error getting user-data for instance %q: %v", id, err)
+			}
+			if response.UserData == nil || response.UserData.Value == nil {
+				continue
+			}
+			d, err := decodeUserData(*response.UserData.Value)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding user-data for instance %q: %v", id, err)
+			}
+			userData = append(userData, d)
+		}
+	}
+
+	{
+		glog.V(2).Infof("Listing all Autoscaling LaunchConfigurations")
+
+		request := &autoscaling.DescribeLaunchConfigurationsInput{}
+		err := cloud.Autoscaling.DescribeLaunchConfigurationsPages(request, func(p *autoscaling.DescribeLaunchConfigurationsOutput, lastPage bool) bool {
+			for _, t := range p.LaunchConfigurations {
+				if t.UserData == nil {
+					continue
+				}
+				d, err := decodeUserData(*t.UserData)
+				if err != nil {
+					glog.Infof("Ignoring autoscaling LaunchConfiguration with invalid UserData: %v", *t.LaunchConfigurationName)
+					continue
+				}
+				if isClusterUserData(d, g.ClusterID) {
+					userData = append(userData, d)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing autoscaling LaunchConfigurations: %v", err)
+		}
+	}
+
+	return userData, nil
+}
+
+// decodeUserData decodes base64 user-data, which we gzip when it is too big to be stored uncompressed
+func decodeUserData(s string) (string, error) {
+	d, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	if bytes.HasPrefix(d, []byte{0x1f, 0x8b}) {
+		d, err = fi.GunzipBytes(d)
+		if err != nil {
+			return "", err
+		}
+	}
+	return string(d), nil
+}
+
+// isClusterUserData returns true if the (decoded) user-data is for the cluster
+func isClusterUserData(userData string, clusterID string) bool {
+	return strings.Contains(userData, "\nINSTANCE_PREFIX: "+clusterID+"\n")
+}
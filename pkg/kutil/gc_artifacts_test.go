@@ -0,0 +1,79 @@
+package kutil
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/kopeio/kope/pkg/fakeaws"
+	"github.com/kopeio/kope/pkg/fi"
+)
+
+func TestReferencedKeys(t *testing.T) {
+	g := &GCArtifacts{ClusterID: "test", Bucket: &fi.S3Bucket{Name: "artifacts"}}
+
+	userData := []string{
+		"SERVER_BINARY_TAR_URL: 'https://s3-eu-west-1.amazonaws.com/artifacts/devel/test/abc/server.tar.gz'\n" +
+			"SALT_TAR_URL: 'https://s3.amazonaws.com/other-bucket/devel/test/def/salt.tar.gz'\n",
+		"wget -O bootstrap \"https://artifacts.s3.eu-west-1.amazonaws.com/devel/test/ghi/bootstrap?X-Amz-Signature=123&X-Amz-Expires=60\"\n" +
+			"# devel/test/jkl/not-a-url\n",
+	}
+
+	expected := map[string]bool{
+		"devel/test/abc/server.tar.gz": true,
+		"devel/test/ghi/bootstrap":     true,
+	}
+	actual := g.referencedKeys(userData)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("unexpected referenced keys %v, expected %v", actual, expected)
+	}
+}
+
+func TestFindAbandonedUploads(t *testing.T) {
+	cloud := fakeaws.New().NewCloud("us-east-1", nil)
+	bucket, err := cloud.S3.EnsureBucket("artifacts", "us-east-1")
+	if err != nil {
+		t.Fatalf("error creating bucket: %v", err)
+	}
+	for _, key := range []string{ArtifactsPrefix("test") + "abc/server.tar.gz", ArtifactsPrefix("other") + "abc/server.tar.gz"} {
+		_, err = cloud.GetS3("us-east-1").CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+			Bucket: aws.String("artifacts"),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			t.Fatalf("error starting upload: %v", err)
+		}
+	}
+
+	g := &GCArtifacts{ClusterID: "test", Cloud: cloud, Bucket: bucket, MinAge: time.Hour}
+	uploads, err := g.FindAbandonedUploads()
+	if err != nil {
+		t.Fatalf("error finding uploads: %v", err)
+	}
+	if len(uploads) != 0 {
+		t.Errorf("recent uploads should not be abandoned, found %v", uploads)
+	}
+
+	g.MinAge = 0
+	uploads, err = g.FindAbandonedUploads()
+	if err != nil {
+		t.Fatalf("error finding uploads: %v", err)
+	}
+	if len(uploads) != 1 || uploads[0].Key != ArtifactsPrefix("test")+"abc/server.tar.gz" {
+		t.Fatalf("expected the cluster's upload to be abandoned, found %v", uploads)
+	}
+
+	err = uploads[0].Abort()
+	if err != nil {
+		t.Fatalf("error aborting upload: %v", err)
+	}
+	uploads, err = g.FindAbandonedUploads()
+	if err != nil {
+		t.Fatalf("error finding uploads: %v", err)
+	}
+	if len(uploads) != 0 {
+		t.Errorf("upload was not aborted, found %v", uploads)
+	}
+}
@@ -1,13 +1,16 @@
 package kutil
 
 import (
-	"golang.org/x/crypto/ssh"
-	"io/ioutil"
+	"bytes"
+	"encoding/base64"
 	"fmt"
-	"strings"
 	"github.com/golang/glog"
-	"encoding/base64"
 	"github.com/kopeio/kope/pkg/fi"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
 )
 
 type NodeSSH struct {
@@ -16,7 +19,7 @@ type NodeSSH struct {
 	sshClient *ssh.Client
 }
 
-func (m*NodeSSH) AddSSHIdentity(p string) error {
+func (m *NodeSSH) AddSSHIdentity(p string) error {
 	a, err := parsePrivateKeyFile(p)
 	if err != nil {
 		return err
@@ -25,7 +28,7 @@ func (m*NodeSSH) AddSSHIdentity(p string) error {
 	return nil
 }
 
-func (m*NodeSSH) dial() (*ssh.Client, error) {
+func (m *NodeSSH) dial() (*ssh.Client, error) {
 	users := []string{"admin", "ubuntu"}
 	if m.SSHConfig.User != "" {
 		users = []string{m.SSHConfig.User}
@@ -34,7 +37,7 @@ func (m*NodeSSH) dial() (*ssh.Client, error) {
 	var lastError error
 	for _, user := range users {
 		m.SSHConfig.User = user
-		sshClient, err := ssh.Dial("tcp", m.IP + ":22", &m.SSHConfig)
+		sshClient, err := ssh.Dial("tcp", m.IP+":22", &m.SSHConfig)
 		if err == nil {
 			return sshClient, err
 		}
@@ -44,7 +47,7 @@ func (m*NodeSSH) dial() (*ssh.Client, error) {
 	return nil, fmt.Errorf("error connecting to SSH on server %q: %v", m.IP, lastError)
 }
 
-func (m*NodeSSH) GetSSHClient() (*ssh.Client, error) {
+func (m *NodeSSH) GetSSHClient() (*ssh.Client, error) {
 	if m.sshClient == nil {
 		sshClient, err := m.dial()
 		if err != nil {
@@ -55,7 +58,7 @@ func (m*NodeSSH) GetSSHClient() (*ssh.Client, error) {
 	return m.sshClient, nil
 }
 
-func (m*NodeSSH) ReadConfiguration() (*MasterConfiguration, error) {
+func (m *NodeSSH) ReadConfiguration() (*MasterConfiguration, error) {
 	sshClient, err := m.GetSSHClient()
 	if err != nil {
 		return nil, err
@@ -67,7 +70,6 @@ func (m*NodeSSH) ReadConfiguration() (*MasterConfiguration, error) {
 	}
 	defer sshSession.Close()
 
-
 	//output, err := sshSession.CombinedOutput("cat /etc/kubernetes/kube_env.yaml")
 	//if err != nil {
 	//	return fmt.Errorf("error running SSH command: %v", err)
@@ -113,15 +115,15 @@ func (m*NodeSSH) ReadConfiguration() (*MasterConfiguration, error) {
 			v := ""
 			if sep != -1 {
 				k = line[0:sep]
-				v = line[sep + 1:]
+				v = line[sep+1:]
 			}
 
 			if k == "" {
 				glog.V(4).Infof("Unknown line: %s", line)
 			}
 
-			if len(v) >= 2 && v[0] == '\'' && v[len(v) - 1] == '\'' {
-				v = v[1:len(v) - 1]
+			if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+				v = v[1 : len(v)-1]
 			}
 			settings[k] = v
 		}
@@ -132,36 +134,37 @@ func (m*NodeSSH) ReadConfiguration() (*MasterConfiguration, error) {
 			v := ""
 			if sep != -1 {
 				k = line[0:sep]
-				v = line[sep + 2:]
+				v = line[sep+2:]
 			}
 
 			if k == "" {
 				glog.V(4).Infof("Unknown line: %s", line)
 			}
 
-			if len(v) >= 2 && v[0] == '"' && v[len(v) - 1] == '"' {
-				v = v[1:len(v) - 1]
+			if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+				v = v[1 : len(v)-1]
 			}
 			settings[k] = v
 		}
 	}
 
 	c := &MasterConfiguration{
-		Version: version,
+		Version:  version,
 		Settings: settings,
 	}
 	return c, nil
 }
 
-func (m*NodeSSH) ReadFile(remotePath string) ([]byte, error) {
-	b, err := m.exec("sudo cat " + remotePath)
+func (m *NodeSSH) ReadFile(remotePath string) ([]byte, error) {
+	b, err := m.Exec("sudo cat " + remotePath)
 	if err != nil {
 		return nil, fmt.Errorf("error reading remote file %q: %v", remotePath, err)
 	}
 	return b, nil
 }
 
-func (m*NodeSSH) exec(cmd string) ([]byte, error) {
+// Exec runs the command on the node, returning its output
+func (m *NodeSSH) Exec(cmd string) ([]byte, error) {
 	client, err := m.GetSSHClient()
 	if err != nil {
 		return nil, err
@@ -180,19 +183,43 @@ func (m*NodeSSH) exec(cmd string) ([]byte, error) {
 	return b, nil
 }
 
-func (m*NodeSSH) GetMetadata(key string) (string, error) {
-	b, err := m.exec("curl -s http://169.254.169.254/latest/meta-data/" + key)
+// WriteFile replaces the remote file (as root), writing a temp file and renaming it so the file is never partially written
+func (m *NodeSSH) WriteFile(remotePath string, data []byte, mode os.FileMode) error {
+	client, err := m.GetSSHClient()
+	if err != nil {
+		return err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("error creating SSH session: %v", err)
+	}
+	defer session.Close()
+
+	tmp := remotePath + ".tmp"
+	cmd := fmt.Sprintf("sudo sh -c 'mkdir -p %s && cat > %s && chown root:root %s && chmod %o %s && mv %s %s'",
+		path.Dir(remotePath), tmp, tmp, mode, tmp, tmp, remotePath)
+	session.Stdin = bytes.NewReader(data)
+	output, err := session.CombinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("error writing remote file %q: %v (output: %s)", remotePath, err, string(output))
+	}
+	return nil
+}
+
+func (m *NodeSSH) GetMetadata(key string) (string, error) {
+	b, err := m.Exec("curl -s http://169.254.169.254/latest/meta-data/" + key)
 	if err != nil {
-		return "", fmt.Errorf("error querying for metadata %q: %v", key,  err)
+		return "", fmt.Errorf("error querying for metadata %q: %v", key, err)
 	}
 	return string(b), nil
 }
 
-func (m*NodeSSH) InstanceType() (string, error) {
+func (m *NodeSSH) InstanceType() (string, error) {
 	return m.GetMetadata("instance-type")
 }
 
-func (m*NodeSSH) GetMetadataList(key string) ([]string, error) {
+func (m *NodeSSH) GetMetadataList(key string) ([]string, error) {
 	d, err := m.GetMetadata(key)
 	if err != nil {
 		return nil, err
@@ -211,7 +238,6 @@ func (m*NodeSSH) GetMetadataList(key string) ([]string, error) {
 	return macs, nil
 }
 
-
 func parsePrivateKeyFile(p string) (ssh.AuthMethod, error) {
 	buffer, err := ioutil.ReadFile(p)
 	if err != nil {
@@ -228,4 +254,4 @@ func parsePrivateKeyFile(p string) (ssh.AuthMethod, error) {
 type MasterConfiguration struct {
 	Version  string
 	Settings map[string]string
-}
\ No newline at end of file
+}
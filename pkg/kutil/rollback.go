@@ -0,0 +1,457 @@
+package kutil
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+)
+
+// Rollback deletes the resources recorded in a journal, in the reverse order to which they were created
+type Rollback struct {
+	Cloud   *fi.AWSCloud
+	Journal *fi.Journal
+
+	// MaxAttempts is the number of times we will try to delete each resource, waiting between attempts
+	// for dependencies (e.g. a terminating instance) to go away
+	MaxAttempts int
+}
+
+type journaledResource struct {
+	entry    *fi.JournalEntry
+	resource DeletableResource
+}
+
+// ListResources returns the resources in the journal, in the order in which they will be deleted
+func (r *Rollback) ListResources() ([]DeletableResource, error) {
+	journaled, err := r.buildResources()
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []DeletableResource
+	for _, j := range journaled {
+		resources = append(resources, j.resource)
+	}
+	return resources, nil
+}
+
+func (r *Rollback) buildResources() ([]*journaledResource, error) {
+	var resources []*journaledResource
+	for i := len(r.Journal.Entries) - 1; i >= 0; i-- {
+		e := r.Journal.Entries[i]
+		resource, err := journalEntryToDeletable(e)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, &journaledResource{entry: e, resource: resource})
+	}
+	return resources, nil
+}
+
+// Run deletes the resources; resources that could not be deleted are left in the journal
+func (r *Rollback) Run() error {
+	resources, err := r.buildResources()
+	if err != nil {
+		return err
+	}
+
+	maxAttempts := r.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 60
+	}
+
+	for attempt := 1; ; attempt++ {
+		var failed []*journaledResource
+		for _, j := range resources {
+			glog.Infof("Deleting resource %s", j.resource)
+			err := j.resource.Delete(r.Cloud)
+			if err != nil {
+				glog.Infof("error deleting resource %s, will retry: %v", j.resource, err)
+				failed = append(failed, j)
+			}
+		}
+		resources = failed
+
+		// Keep the journal in sync, so that an interrupted rollback can be resumed
+		var remaining []*fi.JournalEntry
+		for i := len(resources) - 1; i >= 0; i-- {
+			remaining = append(remaining, resources[i].entry)
+		}
+		err := r.Journal.Replace(remaining)
+		if err != nil {
+			return err
+		}
+
+		if len(resources) == 0 {
+			return nil
+		}
+		if attempt >= maxAttempts {
+			return fmt.Errorf("unable to delete %d resources; they remain in the journal %q", len(resources), r.Journal.Path())
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func journalEntryToDeletable(e *fi.JournalEntry) (DeletableResource, error) {
+	switch e.Type {
+	case fi.JournalTypeVPC:
+		return &DeletableVPC{ID: e.ID}, nil
+	case fi.JournalTypeSubnet:
+		return &DeletableSubnet{ID: e.ID}, nil
+	case fi.JournalTypeInternetGateway:
+		return &DeletableInternetGateway{ID: e.ID}, nil
+	case fi.JournalTypeRouteTable:
+		return &DeletableRouteTable{ID: e.ID}, nil
+	case fi.JournalTypeSecurityGroup:
+		return &DeletableSecurityGroup{ID: e.ID}, nil
+	case fi.JournalTypeDHCPOptions:
+		return &DeletableDHCPOptions{ID: e.ID}, nil
+	case fi.JournalTypeVolume:
+		return &DeletableVolume{ID: e.ID}, nil
+	case fi.JournalTypeElasticIP:
+		return &DeletableElasticIP{ID: e.ID}, nil
+	case fi.JournalTypeElasticIPAssociation:
+		return &DeletableElasticIPAssociation{ID: e.ID}, nil
+	case fi.JournalTypeInstance:
+		return &DeletableInstance{ID: e.ID}, nil
+	case fi.JournalTypeSSHKey:
+		return &DeletableSSHKey{Name: e.ID}, nil
+	case fi.JournalTypeRouteTableAssociation:
+		return &DeletableRouteTableAssociation{ID: e.ID}, nil
+	case fi.JournalTypeIAMRole:
+		return &DeletableIAMRole{Name: e.ID}, nil
+	case fi.JournalTypeIAMInstanceProfile:
+		return &DeletableIAMInstanceProfile{Name: e.ID}, nil
+	case fi.JournalTypeLaunchConfiguration:
+		return &DeletableAutoscalingLaunchConfiguration{Name: e.ID}, nil
+	case fi.JournalTypeAutoscalingGroup:
+		return &DeletableASG{Name: e.ID}, nil
+	}
+
+	// Associations are recorded as the IDs of both sides; S3 objects as the bucket & key
+	tokens := strings.SplitN(e.ID, "/", 2)
+	if len(tokens) != 2 {
+		return nil, fmt.Errorf("unexpected journal entry %s %q", e.Type, e.ID)
+	}
+	switch e.Type {
+	case fi.JournalTypeInternetGatewayAttach:
+		return &DeletableInternetGatewayAttachment{InternetGatewayID: tokens[0], VPCID: tokens[1]}, nil
+	case fi.JournalTypeVolumeAttachment:
+		return &DeletableVolumeAttachment{VolumeID: tokens[0], InstanceID: tokens[1]}, nil
+	case fi.JournalTypeIAMRolePolicy:
+		return &DeletableIAMRolePolicy{RoleName: tokens[0], PolicyName: tokens[1]}, nil
+	case fi.JournalTypeIAMInstanceProfileRole:
+		return &DeletableIAMInstanceProfileRole{InstanceProfileName: tokens[0], RoleName: tokens[1]}, nil
+	case fi.JournalTypeS3Object:
+		return &DeletableJournaledS3Object{Bucket: tokens[0], Key: tokens[1]}, nil
+	}
+	return nil, fmt.Errorf("unknown journal entry type %q", e.Type)
+}
+
+type DeletableDHCPOptions struct {
+	ID string
+}
+
+func (r *DeletableDHCPOptions) Delete(cloud fi.Cloud) error {
+	c := cloud.(*fi.AWSCloud)
+
+	glog.V(2).Infof("Deleting EC2 DHCPOptions %q", r.ID)
+	request := &ec2.DeleteDhcpOptionsInput{
+		DhcpOptionsId: &r.ID,
+	}
+	_, err := c.EC2.DeleteDhcpOptions(request)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return fmt.Errorf("error deleting DHCPOptions %q: %v", r.ID, err)
+	}
+	return nil
+}
+func (r *DeletableDHCPOptions) String() string {
+	return "DHCPOptions:" + r.ID
+}
+
+type DeletableElasticIP struct {
+	ID string
+}
+
+func (r *DeletableElasticIP) Delete(cloud fi.Cloud) error {
+	c := cloud.(*fi.AWSCloud)
+
+	glog.V(2).Infof("Releasing EC2 ElasticIP %q", r.ID)
+	request := &ec2.ReleaseAddressInput{
+		AllocationId: &r.ID,
+	}
+	_, err := c.EC2.ReleaseAddress(request)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return fmt.Errorf("error releasing ElasticIP %q: %v", r.ID, err)
+	}
+	return nil
+}
+func (r *DeletableElasticIP) String() string {
+	return "ElasticIP:" + r.ID
+}
+
+type DeletableElasticIPAssociation struct {
+	ID string
+}
+
+func (r *DeletableElasticIPAssociation) Delete(cloud fi.Cloud) error {
+	c := cloud.(*fi.AWSCloud)
+
+	glog.V(2).Infof("Disassociating EC2 ElasticIP association %q", r.ID)
+	request := &ec2.DisassociateAddressInput{
+		AssociationId: &r.ID,
+	}
+	_, err := c.EC2.DisassociateAddress(request)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return fmt.Errorf("error disassociating ElasticIP association %q: %v", r.ID, err)
+	}
+	return nil
+}
+func (r *DeletableElasticIPAssociation) String() string {
+	return "ElasticIPAssociation:" + r.ID
+}
+
+type DeletableRouteTableAssociation struct {
+	ID string
+}
+
+func (r *DeletableRouteTableAssociation) Delete(cloud fi.Cloud) error {
+	c := cloud.(*fi.AWSCloud)
+
+	glog.V(2).Infof("Disassociating EC2 RouteTable association %q", r.ID)
+	request := &ec2.DisassociateRouteTableInput{
+		AssociationId: &r.ID,
+	}
+	_, err := c.EC2.DisassociateRouteTable(request)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return fmt.Errorf("error disassociating RouteTable association %q: %v", r.ID, err)
+	}
+	return nil
+}
+func (r *DeletableRouteTableAssociation) String() string {
+	return "RouteTableAssociation:" + r.ID
+}
+
+type DeletableInternetGatewayAttachment struct {
+	InternetGatewayID string
+	VPCID             string
+}
+
+func (r *DeletableInternetGatewayAttachment) Delete(cloud fi.Cloud) error {
+	c := cloud.(*fi.AWSCloud)
+
+	glog.V(2).Infof("Detaching EC2 InternetGateway %q from VPC %q", r.InternetGatewayID, r.VPCID)
+	request := &ec2.DetachInternetGatewayInput{
+		InternetGatewayId: &r.InternetGatewayID,
+		VpcId:             &r.VPCID,
+	}
+	_, err := c.EC2.DetachInternetGateway(request)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "Gateway.NotAttached" {
+			return nil
+		}
+		return fmt.Errorf("error detaching InternetGateway %q: %v", r.InternetGatewayID, err)
+	}
+	return nil
+}
+func (r *DeletableInternetGatewayAttachment) String() string {
+	return "InternetGatewayAttachment:" + r.InternetGatewayID + "/" + r.VPCID
+}
+
+type DeletableVolumeAttachment struct {
+	VolumeID   string
+	InstanceID string
+}
+
+func (r *DeletableVolumeAttachment) Delete(cloud fi.Cloud) error {
+	c := cloud.(*fi.AWSCloud)
+
+	glog.V(2).Infof("Detaching EC2 volume %q from instance %q", r.VolumeID, r.InstanceID)
+	request := &ec2.DetachVolumeInput{
+		VolumeId:   &r.VolumeID,
+		InstanceId: &r.InstanceID,
+	}
+	_, err := c.EC2.DetachVolume(request)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "IncorrectState" {
+			// Already detached (or detaching)
+			return nil
+		}
+		return fmt.Errorf("error detaching volume %q: %v", r.VolumeID, err)
+	}
+	return nil
+}
+func (r *DeletableVolumeAttachment) String() string {
+	return "VolumeAttachment:" + r.VolumeID + "/" + r.InstanceID
+}
+
+type DeletableSSHKey struct {
+	Name string
+}
+
+func (r *DeletableSSHKey) Delete(cloud fi.Cloud) error {
+	c := cloud.(*fi.AWSCloud)
+
+	glog.V(2).Infof("Deleting EC2 SSHKey %q", r.Name)
+	request := &ec2.DeleteKeyPairInput{
+		KeyName: &r.Name,
+	}
+	_, err := c.EC2.DeleteKeyPair(request)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return fmt.Errorf("error deleting SSHKey %q: %v", r.Name, err)
+	}
+	return nil
+}
+func (r *DeletableSSHKey) String() string {
+	return "SSHKey:" + r.Name
+}
+
+// DeletableJournaledS3Object is an artifact that was uploaded by the failed run
+type DeletableJournaledS3Object struct {
+	Bucket string
+	Key    string
+}
+
+func (r *DeletableJournaledS3Object) Delete(cloud fi.Cloud) error {
+	c := cloud.(*fi.AWSCloud)
+
+	bucket, err := c.S3.FindBucketIfExists(r.Bucket)
+	if err != nil {
+		return err
+	}
+	if bucket == nil {
+		return nil
+	}
+	o := &fi.S3Object{Bucket: bucket, Key: r.Key}
+	return o.Delete()
+}
+func (r *DeletableJournaledS3Object) String() string {
+	return "S3Object:s3://" + r.Bucket + "/" + r.Key
+}
+
+type DeletableIAMRole struct {
+	Name string
+}
+
+func (r *DeletableIAMRole) Delete(cloud fi.Cloud) error {
+	c := cloud.(*fi.AWSCloud)
+
+	glog.V(2).Infof("Deleting IAM role %q", r.Name)
+	request := &iam.DeleteRoleInput{
+		RoleName: &r.Name,
+	}
+	_, err := c.IAM.DeleteRole(request)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return fmt.Errorf("error deleting IAM role %q: %v", r.Name, err)
+	}
+	return nil
+}
+func (r *DeletableIAMRole) String() string {
+	return "IAMRole:" + r.Name
+}
+
+type DeletableIAMRolePolicy struct {
+	RoleName   string
+	PolicyName string
+}
+
+func (r *DeletableIAMRolePolicy) Delete(cloud fi.Cloud) error {
+	c := cloud.(*fi.AWSCloud)
+
+	glog.V(2).Infof("Deleting IAM role policy %q/%q", r.RoleName, r.PolicyName)
+	request := &iam.DeleteRolePolicyInput{
+		RoleName:   &r.RoleName,
+		PolicyName: &r.PolicyName,
+	}
+	_, err := c.IAM.DeleteRolePolicy(request)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return fmt.Errorf("error deleting IAM role policy %q/%q: %v", r.RoleName, r.PolicyName, err)
+	}
+	return nil
+}
+func (r *DeletableIAMRolePolicy) String() string {
+	return "IAMRolePolicy:" + r.RoleName + "/" + r.PolicyName
+}
+
+type DeletableIAMInstanceProfile struct {
+	Name string
+}
+
+func (r *DeletableIAMInstanceProfile) Delete(cloud fi.Cloud) error {
+	c := cloud.(*fi.AWSCloud)
+
+	glog.V(2).Infof("Deleting IAM instance profile %q", r.Name)
+	request := &iam.DeleteInstanceProfileInput{
+		InstanceProfileName: &r.Name,
+	}
+	_, err := c.IAM.DeleteInstanceProfile(request)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return fmt.Errorf("error deleting IAM instance profile %q: %v", r.Name, err)
+	}
+	return nil
+}
+func (r *DeletableIAMInstanceProfile) String() string {
+	return "IAMInstanceProfile:" + r.Name
+}
+
+type DeletableIAMInstanceProfileRole struct {
+	InstanceProfileName string
+	RoleName            string
+}
+
+func (r *DeletableIAMInstanceProfileRole) Delete(cloud fi.Cloud) error {
+	c := cloud.(*fi.AWSCloud)
+
+	glog.V(2).Infof("Removing IAM role %q from instance profile %q", r.RoleName, r.InstanceProfileName)
+	request := &iam.RemoveRoleFromInstanceProfileInput{
+		InstanceProfileName: &r.InstanceProfileName,
+		RoleName:            &r.RoleName,
+	}
+	_, err := c.IAM.RemoveRoleFromInstanceProfile(request)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return fmt.Errorf("error removing IAM role %q from instance profile %q: %v", r.RoleName, r.InstanceProfileName, err)
+	}
+	return nil
+}
+func (r *DeletableIAMInstanceProfileRole) String() string {
+	return "IAMInstanceProfileRole:" + r.InstanceProfileName + "/" + r.RoleName
+}
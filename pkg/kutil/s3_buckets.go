@@ -1,16 +1,16 @@
 package kutil
 
 import (
-	"fmt"
 	"crypto/md5"
 	"encoding/hex"
-	"strings"
+	"fmt"
 	"github.com/kopeio/kope/pkg/fi"
 	"os"
+	"strings"
 )
 
 func GetDefaultS3Bucket(cloud *fi.AWSCloud) (string, error) {
-	credentials, err := cloud.EC2.Config.Credentials.Get()
+	credentials, err := cloud.Credentials.Get()
 	if err != nil {
 		return "", fmt.Errorf("error fetching EC2 credentials")
 	}
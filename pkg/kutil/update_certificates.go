@@ -0,0 +1,391 @@
+package kutil
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+)
+
+// CertificateNames maps the names of the certificates that can be reissued to their subject CommonName
+var CertificateNames = map[string]string{
+	"master":  "kubernetes-master",
+	"kubecfg": "kubecfg",
+	"kubelet": "kubelet",
+}
+
+// Where the configure script puts the certificates on the instances
+const (
+	remoteMasterPKIDir      = "/srv/kubernetes"
+	remoteKubeletKubeconfig = "/var/lib/kubelet/kubeconfig"
+	// salt copies the kubelet kubeconfig from here, so we update it too
+	remoteSaltKubeletKubeconfig = "/srv/salt-overlay/salt/kubelet/kubeconfig"
+)
+
+// UpdateCertificates reissues certificates from the CAStore, and pushes them to the running instances of a cluster
+type UpdateCertificates struct {
+	ClusterID   string
+	Cloud       *fi.AWSCloud
+	CAStore     fi.CAStore
+	SSHIdentity string
+
+	// Certificates are the names (keys of CertificateNames) of the certificates to update
+	Certificates []string
+	// UpdateCA is set if the trusted CA certificates have changed
+	UpdateCA bool
+	// NewMasterKey replaces the master key, rather than reissuing the master certificate for the existing key.
+	// The master key also signs the service account tokens, so replacing it invalidates every token.
+	NewMasterKey bool
+}
+
+// ClusterInstance is a running instance in the cluster
+type ClusterInstance struct {
+	ID   string
+	Role string
+	IP   string
+}
+
+func (i *ClusterInstance) String() string {
+	return fmt.Sprintf("%s (%s, %s)", i.ID, i.Role, i.IP)
+}
+
+func (u *UpdateCertificates) has(name string) bool {
+	for _, c := range u.Certificates {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Reissue generates a new key & certificate for each of the certificates, keeping the usages and SANs of the existing certificate.
+// The master certificate is reissued for the existing key (unless NewMasterKey is set), so that the service account
+// tokens remain valid.
+func (u *UpdateCertificates) Reissue() error {
+	caKey, err := u.CAStore.FindCAKey()
+	if err != nil {
+		return err
+	}
+	if caKey == nil {
+		return fmt.Errorf("CA key was not found; cannot issue certificates")
+	}
+
+	for _, name := range u.Certificates {
+		subject := &pkix.Name{CommonName: CertificateNames[name]}
+		existing, err := u.CAStore.FindCert(subject)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return fmt.Errorf("certificate %q has not been issued", subject.CommonName)
+		}
+
+		template := &x509.Certificate{
+			Subject:               *subject,
+			KeyUsage:              existing.Certificate.KeyUsage,
+			ExtKeyUsage:           existing.Certificate.ExtKeyUsage,
+			DNSNames:              existing.Certificate.DNSNames,
+			IPAddresses:           existing.Certificate.IPAddresses,
+			BasicConstraintsValid: true,
+			IsCA:                  false,
+		}
+
+		var privateKey crypto.PrivateKey
+		if name == "master" && !u.NewMasterKey {
+			privateKey, err = u.CAStore.FindPrivateKey(subject)
+			if err != nil {
+				return err
+			}
+			if privateKey == nil {
+				return fmt.Errorf("key for certificate %q was not found; a new key would invalidate the service account tokens, so it must be replaced explicitly (--new-master-key)", subject.CommonName)
+			}
+			if _, isRSA := privateKey.(*rsa.PrivateKey); !isRSA {
+				return fmt.Errorf("key for certificate %q is a %T, but it signs the service account tokens, so must be RSA; it must be replaced explicitly (--new-master-key)", subject.CommonName, privateKey)
+			}
+			glog.Infof("Reissuing certificate %q for the existing key", subject.CommonName)
+		} else {
+			// The master key also signs the service account tokens
+			keyAlgorithm := fi.KeyAlgorithm("")
+			if name == "master" {
+				keyAlgorithm = fi.ServiceAccountKeyAlgorithm
+			}
+
+			glog.Infof("Reissuing certificate %q with a new key", subject.CommonName)
+			privateKey, err = u.CAStore.CreatePrivateKey(subject, keyAlgorithm)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = u.CAStore.IssueCert(privateKey, template)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindInstances returns the running instances in the cluster
+func (u *UpdateCertificates) FindInstances() ([]*ClusterInstance, error) {
+	cloud := u.Cloud
+
+	glog.V(2).Infof("Listing all EC2 instances matching cluster tags")
+	request := &ec2.DescribeInstancesInput{
+		Filters: cloud.BuildFilters(nil),
+	}
+	var instances []*ClusterInstance
+	err := cloud.EC2.DescribeInstancesPages(request, func(p *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, r := range p.Reservations {
+			for _, i := range r.Instances {
+				if i.State == nil || aws.StringValue(i.State.Name) != "running" {
+					continue
+				}
+				instance := &ClusterInstance{
+					ID:   aws.StringValue(i.InstanceId),
+					Role: findEC2Tag(i.Tags, TagRole),
+				}
+				// We can only reach the private IP if we are running inside the VPC
+				instance.IP = aws.StringValue(i.PublicIpAddress)
+				if instance.IP == "" {
+					instance.IP = aws.StringValue(i.PrivateIpAddress)
+				}
+				instances = append(instances, instance)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing cluster instances: %v", err)
+	}
+	sort.Sort(clusterInstancesByID(instances))
+	return instances, nil
+}
+
+type clusterInstancesByID []*ClusterInstance
+
+func (a clusterInstancesByID) Len() int {
+	return len(a)
+}
+func (a clusterInstancesByID) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a clusterInstancesByID) Less(i, j int) bool {
+	return a[i].ID < a[j].ID
+}
+
+func findEC2Tag(tags []*ec2.Tag, key string) string {
+	for _, t := range tags {
+		if aws.StringValue(t.Key) == key {
+			return aws.StringValue(t.Value)
+		}
+	}
+	return ""
+}
+
+func isMasterRole(role string) bool {
+	return role == "master" || role == "kubernetes-master"
+}
+
+// NeedsPush returns true if the instance has any of the certificates we are updating
+func (u *UpdateCertificates) NeedsPush(instance *ClusterInstance) bool {
+	if u.UpdateCA || u.has("kubelet") {
+		return true
+	}
+	return isMasterRole(instance.Role) && (u.has("master") || u.has("kubecfg"))
+}
+
+// Push copies the current certificates to the instance over SSH, and restarts the components that use them
+func (u *UpdateCertificates) Push(instance *ClusterInstance) error {
+	node := &NodeSSH{
+		IP: instance.IP,
+	}
+	err := node.AddSSHIdentity(u.SSHIdentity)
+	if err != nil {
+		return err
+	}
+
+	var restarts []string
+
+	if isMasterRole(instance.Role) {
+		files := make(map[string][]byte)
+		if u.UpdateCA {
+			caCerts, err := u.buildCABundle()
+			if err != nil {
+				return err
+			}
+			files["ca.crt"] = caCerts
+		}
+		if u.has("master") {
+			cert, key, err := u.findCertAndKey("master")
+			if err != nil {
+				return err
+			}
+			files["server.cert"] = cert
+			files["server.key"] = key
+		}
+		if u.has("kubecfg") {
+			cert, key, err := u.findCertAndKey("kubecfg")
+			if err != nil {
+				return err
+			}
+			files["kubecfg.crt"] = cert
+			files["kubecfg.key"] = key
+		}
+		if len(files) != 0 {
+			for _, f := range sortedKeys(files) {
+				glog.V(2).Infof("Writing %s/%s on %s", remoteMasterPKIDir, f, instance)
+				err := node.WriteFile(remoteMasterPKIDir+"/"+f, files[f], 0600)
+				if err != nil {
+					return err
+				}
+			}
+			// The master components run in containers; restarting docker restarts them all
+			restarts = append(restarts, "docker")
+		}
+	}
+
+	if u.UpdateCA || u.has("kubelet") {
+		kubeconfig, err := u.buildKubeletKubeconfig()
+		if err != nil {
+			return err
+		}
+		for _, p := range []string{remoteSaltKubeletKubeconfig, remoteKubeletKubeconfig} {
+			glog.V(2).Infof("Writing %s on %s", p, instance)
+			err := node.WriteFile(p, kubeconfig, 0400)
+			if err != nil {
+				return err
+			}
+		}
+		restarts = append(restarts, "kubelet")
+	}
+
+	for _, service := range restarts {
+		glog.Infof("Restarting %s on %s", service, instance)
+		_, err := node.Exec("sudo service " + service + " restart")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (u *UpdateCertificates) findCertAndKey(name string) ([]byte, []byte, error) {
+	subject := &pkix.Name{CommonName: CertificateNames[name]}
+
+	cert, err := u.CAStore.FindCert(subject)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cert == nil {
+		return nil, nil, fmt.Errorf("certificate %q not found", subject.CommonName)
+	}
+	var certData bytes.Buffer
+	err = cert.WriteCertificate(&certData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := u.CAStore.FindPrivateKey(subject)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key == nil {
+		return nil, nil, fmt.Errorf("private key %q not found", subject.CommonName)
+	}
+	var keyData bytes.Buffer
+	err = fi.WritePrivateKey(key, &keyData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return certData.Bytes(), keyData.Bytes(), nil
+}
+
+// buildCABundle returns the trusted CA certificates, which during a CA rotation includes both CAs
+func (u *UpdateCertificates) buildCABundle() ([]byte, error) {
+	caCerts, err := u.CAStore.GetTrustedCACerts()
+	if err != nil {
+		return nil, err
+	}
+	var data bytes.Buffer
+	for _, caCert := range caCerts {
+		err = caCert.WriteCertificate(&data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data.Bytes(), nil
+}
+
+// CheckSignedByCA returns an error if any of the certificates were not issued by the current CA
+func (u *UpdateCertificates) CheckSignedByCA() error {
+	ca, err := u.CAStore.GetCACert()
+	if err != nil {
+		return err
+	}
+	for _, name := range u.Certificates {
+		subject := &pkix.Name{CommonName: CertificateNames[name]}
+		cert, err := u.CAStore.FindCert(subject)
+		if err != nil {
+			return err
+		}
+		if cert == nil {
+			continue
+		}
+		err = cert.Certificate.CheckSignatureFrom(ca.Certificate)
+		if err != nil {
+			return fmt.Errorf("certificate %q was not issued by the current CA", subject.CommonName)
+		}
+	}
+	return nil
+}
+
+// buildKubeletKubeconfig builds the kubeconfig that the configure script writes for the kubelet
+func (u *UpdateCertificates) buildKubeletKubeconfig() ([]byte, error) {
+	cert, key, err := u.findCertAndKey("kubelet")
+	if err != nil {
+		return nil, err
+	}
+
+	caData, err := u.buildCABundle()
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteString("apiVersion: v1\n")
+	b.WriteString("kind: Config\n")
+	b.WriteString("users:\n")
+	b.WriteString("- name: kubelet\n")
+	b.WriteString("  user:\n")
+	b.WriteString("    client-certificate-data: " + base64.StdEncoding.EncodeToString(cert) + "\n")
+	b.WriteString("    client-key-data: " + base64.StdEncoding.EncodeToString(key) + "\n")
+	b.WriteString("clusters:\n")
+	b.WriteString("- name: local\n")
+	b.WriteString("  cluster:\n")
+	b.WriteString("    certificate-authority-data: " + base64.StdEncoding.EncodeToString(caData) + "\n")
+	b.WriteString("contexts:\n")
+	b.WriteString("- context:\n")
+	b.WriteString("    cluster: local\n")
+	b.WriteString("    user: kubelet\n")
+	b.WriteString("  name: service-account-context\n")
+	b.WriteString("current-context: service-account-context\n")
+	return b.Bytes(), nil
+}
@@ -0,0 +1,107 @@
+package kutil
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/kopeio/kope/pkg/fi"
+)
+
+func issueTestCertificate(t *testing.T, castore fi.CAStore, name string, keyAlgorithm fi.KeyAlgorithm) {
+	subject := &pkix.Name{CommonName: CertificateNames[name]}
+	privateKey, err := castore.CreatePrivateKey(subject, keyAlgorithm)
+	if err != nil {
+		t.Fatalf("error creating private key: %v", err)
+	}
+	template := &x509.Certificate{
+		Subject:     *subject,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	_, err = castore.IssueCert(privateKey, template)
+	if err != nil {
+		t.Fatalf("error issuing certificate: %v", err)
+	}
+}
+
+func findTestCertificate(t *testing.T, castore fi.CAStore, name string) *fi.Certificate {
+	cert, err := castore.FindCert(&pkix.Name{CommonName: CertificateNames[name]})
+	if err != nil || cert == nil {
+		t.Fatalf("error finding certificate %q: %v", name, err)
+	}
+	return cert
+}
+
+func publicKeyBytes(t *testing.T, cert *fi.Certificate) []byte {
+	data, err := x509.MarshalPKIXPublicKey(cert.Certificate.PublicKey)
+	if err != nil {
+		t.Fatalf("error serializing public key: %v", err)
+	}
+	return data
+}
+
+// TestReissueKeepsMasterKey checks that reissuing the master certificate (e.g. during a CA rotation) keeps the
+// master key, which signs the service account tokens
+func TestReissueKeepsMasterKey(t *testing.T) {
+	tests := []struct {
+		newMasterKey bool
+		keyChanged   bool
+	}{
+		{newMasterKey: false, keyChanged: false},
+		{newMasterKey: true, keyChanged: true},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "castore")
+		if err != nil {
+			t.Fatalf("error creating temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+
+		castore, err := fi.NewCAStore(fi.NewFilesystemStateStore(dir), "pki", "", nil)
+		if err != nil {
+			t.Fatalf("error building CA store: %v", err)
+		}
+		issueTestCertificate(t, castore, "master", fi.ServiceAccountKeyAlgorithm)
+		issueTestCertificate(t, castore, "kubecfg", "")
+		oldMaster := findTestCertificate(t, castore, "master")
+		oldKubecfg := findTestCertificate(t, castore, "kubecfg")
+
+		err = castore.StartCARotation()
+		if err != nil {
+			t.Fatalf("error starting CA rotation: %v", err)
+		}
+		err = castore.PromoteNextCA()
+		if err != nil {
+			t.Fatalf("error promoting CA: %v", err)
+		}
+
+		u := &UpdateCertificates{CAStore: castore, Certificates: []string{"master", "kubecfg"}, NewMasterKey: test.newMasterKey}
+		err = u.Reissue()
+		if err != nil {
+			t.Fatalf("error reissuing certificates: %v", err)
+		}
+
+		newCA, err := castore.GetCACert()
+		if err != nil {
+			t.Fatalf("error getting CA: %v", err)
+		}
+		newMaster := findTestCertificate(t, castore, "master")
+		if !bytes.Equal(newMaster.Certificate.AuthorityKeyId, newCA.Certificate.SubjectKeyId) {
+			t.Errorf("newMasterKey=%v: master certificate was not reissued by the new CA", test.newMasterKey)
+		}
+		keyChanged := !bytes.Equal(publicKeyBytes(t, oldMaster), publicKeyBytes(t, newMaster))
+		if keyChanged != test.keyChanged {
+			t.Errorf("newMasterKey=%v: expected master key changed=%v, was %v", test.newMasterKey, test.keyChanged, keyChanged)
+		}
+
+		// Other certificates always get new keys
+		newKubecfg := findTestCertificate(t, castore, "kubecfg")
+		if bytes.Equal(publicKeyBytes(t, oldKubecfg), publicKeyBytes(t, newKubecfg)) {
+			t.Errorf("newMasterKey=%v: expected a new kubecfg key", test.newMasterKey)
+		}
+	}
+}
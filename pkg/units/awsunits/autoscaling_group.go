@@ -4,12 +4,14 @@ import (
 	"fmt"
 	"strconv"
 
+	"encoding/base64"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/golang/glog"
-	"github.com/aws/aws-sdk-go/aws"
-	"strings"
 	"github.com/kopeio/kope/pkg/fi"
-	"encoding/base64"
+	"github.com/kopeio/kope/pkg/units"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -23,15 +25,15 @@ func buildTimestampString() string {
 type AutoscalingGroup struct {
 	fi.SimpleUnit
 
-	Name                    *string
+	Name *string
 
 	InstanceCommonConfig
-	UserData                fi.Resource
+	UserData fi.Resource
 
-	MinSize                 *int64
-	MaxSize                 *int64
-	Subnet                  *Subnet
-	Tags                    map[string]string
+	MinSize *int64
+	MaxSize *int64
+	Subnet  *Subnet
+	Tags    map[string]string
 
 	launchConfigurationName *string
 }
@@ -45,6 +47,10 @@ func (s *AutoscalingGroup) GetID() *string {
 }
 
 func (e *AutoscalingGroup) find(c *fi.RunContext) (*AutoscalingGroup, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	request := &autoscaling.DescribeAutoScalingGroupsInput{
@@ -80,11 +86,16 @@ func (e *AutoscalingGroup) find(c *fi.RunContext) (*AutoscalingGroup, error) {
 		}
 	}
 
-	if len(g.Tags) != 0 {
-		actual.Tags = make(map[string]string)
-		for _, tag := range g.Tags {
-			actual.Tags[*tag.Key] = *tag.Value
+	// The cluster tags are added by buildTags; Tags only holds the extra tags
+	clusterTags := cloud.BuildTags(e.Name)
+	for _, tag := range g.Tags {
+		if _, found := clusterTags[*tag.Key]; found {
+			continue
+		}
+		if actual.Tags == nil {
+			actual.Tags = make(map[string]string)
 		}
+		actual.Tags[*tag.Key] = *tag.Value
 	}
 
 	if g.LaunchConfigurationName == nil {
@@ -110,7 +121,7 @@ func (e *AutoscalingGroup) Run(c *fi.RunContext) error {
 	}
 
 	changes := &AutoscalingGroup{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -126,7 +137,7 @@ func (e *AutoscalingGroup) Run(c *fi.RunContext) error {
 func (s *AutoscalingGroup) checkChanges(a, e, changes *AutoscalingGroup) error {
 	if a != nil {
 		if e.Name == nil {
-			return MissingValueError("Name is required when creating AutoscalingGroup")
+			return units.MissingValueError("Name is required when creating AutoscalingGroup")
 		}
 	}
 	return nil
@@ -143,7 +154,9 @@ func (e *AutoscalingGroup) buildTags(cloud *fi.AWSCloud) map[string]string {
 	return tags
 }
 
-func (_*AutoscalingGroup) RenderAWS(t*fi.AWSAPITarget,a, e, changes *AutoscalingGroup) error {
+func (_ *AutoscalingGroup) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e, changes := ua.(*AutoscalingGroup), ue.(*AutoscalingGroup), uchanges.(*AutoscalingGroup)
+
 	if a == nil {
 		launchConfigurationName := *e.Name + "-" + buildTimestampString()
 		glog.V(2).Infof("Creating autoscaling LaunchConfiguration with Name:%q", launchConfigurationName)
@@ -165,9 +178,9 @@ func (_*AutoscalingGroup) RenderAWS(t*fi.AWSAPITarget,a, e, changes *Autoscaling
 		tags := []*autoscaling.Tag{}
 		for k, v := range e.buildTags(t.Cloud) {
 			tags = append(tags, &autoscaling.Tag{
-				Key:aws.String(k),
-				Value: aws.String(v),
-				ResourceId: e.Name,
+				Key:          aws.String(k),
+				Value:        aws.String(v),
+				ResourceId:   e.Name,
 				ResourceType: aws.String("auto-scaling-group"),
 			})
 		}
@@ -177,6 +190,9 @@ func (_*AutoscalingGroup) RenderAWS(t*fi.AWSAPITarget,a, e, changes *Autoscaling
 		if err != nil {
 			return fmt.Errorf("error creating AutoscalingGroup: %v", err)
 		}
+		if err := t.RecordCreation(fi.JournalTypeAutoscalingGroup, *e.Name); err != nil {
+			return err
+		}
 	} else {
 		if changes.UserData != nil {
 			launchConfigurationName := *e.Name + "-" + buildTimestampString()
@@ -188,7 +204,7 @@ func (_*AutoscalingGroup) RenderAWS(t*fi.AWSAPITarget,a, e, changes *Autoscaling
 			}
 
 			request := &autoscaling.UpdateAutoScalingGroupInput{
-				AutoScalingGroupName: e.Name,
+				AutoScalingGroupName:    e.Name,
 				LaunchConfigurationName: &launchConfigurationName,
 			}
 			_, err = t.Cloud.Autoscaling.UpdateAutoScalingGroup(request)
@@ -201,15 +217,23 @@ func (_*AutoscalingGroup) RenderAWS(t*fi.AWSAPITarget,a, e, changes *Autoscaling
 	return nil //return output.AddAWSTags(cloud.Tags(), v, "vpc")
 }
 
-func (_ *AutoscalingGroup) RenderBash(t*fi.BashTarget, a, e, changes *AutoscalingGroup) error {
+func (_ *AutoscalingGroup) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e, changes := ua.(*AutoscalingGroup), ue.(*AutoscalingGroup), uchanges.(*AutoscalingGroup)
+
+	t.CreateVar(e)
 	if a == nil {
+		t.AddAutoscalingCommand("describe-auto-scaling-groups",
+			"--auto-scaling-group-names", *e.Name,
+			"--query", "AutoScalingGroups[0].AutoScalingGroupName").AssignTo(e)
+
 		launchConfigurationName := *e.Name + "-" + buildTimestampString()
 		glog.V(2).Infof("Creating autoscaling LaunchConfiguration with Name:%q", launchConfigurationName)
 
-		err := renderAutoscalingLaunchConfigurationBash(t, launchConfigurationName, e)
+		cmd, err := renderAutoscalingLaunchConfigurationBash(t, launchConfigurationName, e)
 		if err != nil {
 			return err
 		}
+		cmd.IfMissing(e)
 
 		glog.V(2).Infof("Creating autoscaling Group with Name:%q", *e.Name)
 
@@ -218,17 +242,27 @@ func (_ *AutoscalingGroup) RenderBash(t*fi.BashTarget, a, e, changes *Autoscalin
 		args = append(args, "--launch-configuration-name", launchConfigurationName)
 		args = append(args, "--min-size", strconv.FormatInt(*e.MinSize, 10))
 		args = append(args, "--max-size", strconv.FormatInt(*e.MaxSize, 10))
-		args = append(args, "--vpc-zone-identifier", t.ReadVar(e.Subnet))
+		subnetID, err := t.ReadVar(e.Subnet)
+		if err != nil {
+			return err
+		}
+		args = append(args, "--vpc-zone-identifier", subnetID)
 
 		tags := e.buildTags(t.Cloud)
 		if len(tags) != 0 {
+			var keys []string
+			for k := range tags {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
 			args = append(args, "--tags")
-			for k, v := range tags {
-				args = append(args, fmt.Sprintf("ResourceId=%s,ResourceType=auto-scaling-group,Key=%s,Value=%s", *e.Name, k, v))
+			for _, k := range keys {
+				args = append(args, fmt.Sprintf("ResourceId=%s,ResourceType=auto-scaling-group,Key=%s,Value=%s", *e.Name, k, tags[k]))
 			}
 		}
 
-		t.AddAutoscalingCommand(args...)
+		t.AddAutoscalingCommand(args...).IfMissing(e)
 	} else {
 		if changes.UserData != nil {
 			//ad, _ := fi.ResourceAsString(a.UserData)
@@ -246,11 +280,10 @@ func (_ *AutoscalingGroup) RenderBash(t*fi.BashTarget, a, e, changes *Autoscalin
 			//glog.Infof("ACTUAL DELTA %s", ad)
 			//glog.Infof("EXPECTED DELTA %s", ed)
 
-
 			launchConfigurationName := *e.Name + "-" + buildTimestampString()
 			glog.V(2).Infof("Creating autoscaling LaunchConfiguration with Name:%q", launchConfigurationName)
 
-			err := renderAutoscalingLaunchConfigurationBash(t, launchConfigurationName, e)
+			_, err := renderAutoscalingLaunchConfigurationBash(t, launchConfigurationName, e)
 			if err != nil {
 				return err
 			}
@@ -265,6 +298,192 @@ func (_ *AutoscalingGroup) RenderBash(t*fi.BashTarget, a, e, changes *Autoscalin
 	return nil
 }
 
+type terraformLaunchConfiguration struct {
+	NamePrefix               *string                 `json:"name_prefix,omitempty"`
+	ImageID                  *string                 `json:"image_id,omitempty"`
+	InstanceType             *string                 `json:"instance_type,omitempty"`
+	KeyName                  *string                 `json:"key_name,omitempty"`
+	SecurityGroups           []*string               `json:"security_groups,omitempty"`
+	AssociatePublicIPAddress *bool                   `json:"associate_public_ip_address,omitempty"`
+	IAMInstanceProfile       *string                 `json:"iam_instance_profile,omitempty"`
+	UserData                 *string                 `json:"user_data_base64,omitempty"`
+	EphemeralBlockDevices    []*terraformBlockDevice `json:"ephemeral_block_device,omitempty"`
+	Lifecycle                *terraformLifecycle     `json:"lifecycle,omitempty"`
+}
+
+type terraformLifecycle struct {
+	CreateBeforeDestroy *bool `json:"create_before_destroy,omitempty"`
+}
+
+type terraformAutoscalingGroupTag struct {
+	Key               *string `json:"key"`
+	Value             *string `json:"value"`
+	PropagateAtLaunch *bool   `json:"propagate_at_launch"`
+}
+
+type terraformAutoscalingGroup struct {
+	Name                *string                         `json:"name,omitempty"`
+	LaunchConfiguration *string                         `json:"launch_configuration,omitempty"`
+	MinSize             *int64                          `json:"min_size,omitempty"`
+	MaxSize             *int64                          `json:"max_size,omitempty"`
+	VPCZoneIdentifier   []*string                       `json:"vpc_zone_identifier,omitempty"`
+	Tags                []*terraformAutoscalingGroupTag `json:"tag,omitempty"`
+}
+
+func (_ *AutoscalingGroup) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*AutoscalingGroup)
+
+	// Launch configurations can't be updated, so we let terraform generate the name,
+	// and replace the configuration before the old one is removed
+	lc := &terraformLaunchConfiguration{
+		NamePrefix:               units.String(*e.Name + "-"),
+		ImageID:                  e.ImageID,
+		InstanceType:             e.InstanceType,
+		SecurityGroups:           e.buildTerraformSecurityGroups(),
+		AssociatePublicIPAddress: e.AssociatePublicIP,
+		EphemeralBlockDevices:    e.buildTerraformEphemeralBlockDevices(),
+		Lifecycle:                &terraformLifecycle{CreateBeforeDestroy: units.Bool(true)},
+	}
+	if e.SSHKey != nil {
+		lc.KeyName = e.SSHKey.TerraformLink()
+	}
+	if e.IAMInstanceProfile != nil {
+		lc.IAMInstanceProfile = e.IAMInstanceProfile.TerraformLink()
+	}
+	if e.UserData != nil {
+		userData, err := buildTerraformUserData(t, "aws_launch_configuration", e.Key(), e.UserData)
+		if err != nil {
+			return err
+		}
+		lc.UserData = userData
+	}
+	err := t.RenderResource("aws_launch_configuration", e.Key(), lc)
+	if err != nil {
+		return err
+	}
+
+	tf := &terraformAutoscalingGroup{
+		Name:                e.Name,
+		LaunchConfiguration: units.String(fi.TerraformReference("aws_launch_configuration", e.Key(), "id")),
+		MinSize:             e.MinSize,
+		MaxSize:             e.MaxSize,
+	}
+	if e.Subnet != nil {
+		tf.VPCZoneIdentifier = []*string{e.Subnet.TerraformLink()}
+	}
+	for k, v := range e.buildTags(t.Cloud) {
+		tf.Tags = append(tf.Tags, &terraformAutoscalingGroupTag{
+			Key:               units.String(k),
+			Value:             units.String(v),
+			PropagateAtLaunch: units.Bool(true),
+		})
+	}
+	sort.Sort(terraformAutoscalingGroupTagsByKey(tf.Tags))
+
+	return t.RenderResource("aws_autoscaling_group", e.Key(), tf)
+}
+
+type cloudformationLaunchConfiguration struct {
+	ImageID                  interface{}                  `json:"ImageId,omitempty"`
+	InstanceType             interface{}                  `json:"InstanceType,omitempty"`
+	KeyName                  interface{}                  `json:"KeyName,omitempty"`
+	SecurityGroups           []interface{}                `json:"SecurityGroups,omitempty"`
+	AssociatePublicIPAddress *bool                        `json:"AssociatePublicIpAddress,omitempty"`
+	IAMInstanceProfile       interface{}                  `json:"IamInstanceProfile,omitempty"`
+	UserData                 *string                      `json:"UserData,omitempty"`
+	BlockDeviceMappings      []*cloudformationBlockDevice `json:"BlockDeviceMappings,omitempty"`
+}
+
+type cloudformationAutoscalingGroupTag struct {
+	Key               *string `json:"Key"`
+	Value             *string `json:"Value"`
+	PropagateAtLaunch *bool   `json:"PropagateAtLaunch"`
+}
+
+type cloudformationAutoscalingGroup struct {
+	Name                *string                              `json:"AutoScalingGroupName,omitempty"`
+	LaunchConfiguration interface{}                          `json:"LaunchConfigurationName,omitempty"`
+	MinSize             *string                              `json:"MinSize,omitempty"`
+	MaxSize             *string                              `json:"MaxSize,omitempty"`
+	VPCZoneIdentifier   []interface{}                        `json:"VPCZoneIdentifier,omitempty"`
+	Tags                []*cloudformationAutoscalingGroupTag `json:"Tags,omitempty"`
+}
+
+func (_ *AutoscalingGroup) RenderCloudformation(t *fi.CloudFormationTarget, a, ue, changes fi.Unit) error {
+	e := ue.(*AutoscalingGroup)
+
+	imageID, instanceType, err := e.buildCloudformationParameters(t, e.Key())
+	if err != nil {
+		return err
+	}
+
+	// Cloudformation names the launch configuration, and replaces it when it changes
+	lc := &cloudformationLaunchConfiguration{
+		ImageID:                  imageID,
+		InstanceType:             instanceType,
+		SecurityGroups:           e.buildCloudformationSecurityGroups(),
+		AssociatePublicIPAddress: e.AssociatePublicIP,
+		BlockDeviceMappings:      e.buildCloudformationBlockDevices(),
+	}
+	if e.SSHKey != nil {
+		lc.KeyName = e.SSHKey.CloudformationLink()
+	}
+	if e.IAMInstanceProfile != nil {
+		lc.IAMInstanceProfile = e.IAMInstanceProfile.CloudformationLink()
+	}
+	if e.UserData != nil {
+		lc.UserData, err = buildCloudformationUserData(e.UserData)
+		if err != nil {
+			return err
+		}
+	}
+	err = t.RenderResource("AWS::AutoScaling::LaunchConfiguration", e.Key(), lc)
+	if err != nil {
+		return err
+	}
+
+	cf := &cloudformationAutoscalingGroup{
+		Name:                e.Name,
+		LaunchConfiguration: fi.CloudFormationRef("AWS::AutoScaling::LaunchConfiguration", e.Key()),
+	}
+	if e.MinSize != nil {
+		cf.MinSize = units.String(strconv.FormatInt(*e.MinSize, 10))
+	}
+	if e.MaxSize != nil {
+		cf.MaxSize = units.String(strconv.FormatInt(*e.MaxSize, 10))
+	}
+	if e.Subnet != nil {
+		cf.VPCZoneIdentifier = []interface{}{e.Subnet.CloudformationLink()}
+	}
+	tags := e.buildTags(t.Cloud)
+	var keys []string
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cf.Tags = append(cf.Tags, &cloudformationAutoscalingGroupTag{
+			Key:               units.String(k),
+			Value:             units.String(tags[k]),
+			PropagateAtLaunch: units.Bool(true),
+		})
+	}
+
+	return t.RenderResource("AWS::AutoScaling::AutoScalingGroup", e.Key(), cf)
+}
+
+type terraformAutoscalingGroupTagsByKey []*terraformAutoscalingGroupTag
+
+func (a terraformAutoscalingGroupTagsByKey) Len() int {
+	return len(a)
+}
+func (a terraformAutoscalingGroupTagsByKey) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a terraformAutoscalingGroupTagsByKey) Less(i, j int) bool {
+	return *a[i].Key < *a[j].Key
+}
+
 /*
 func (g *AutoscalingGroup) Destroy(cloud *AWSCloud, output *BashTarget) error {
 	existing, err := g.findExisting(cloud)
@@ -285,7 +504,6 @@ func (g *AutoscalingGroup) Destroy(cloud *AWSCloud, output *BashTarget) error {
 }
 */
 
-
 func (e *AutoscalingGroup) findLaunchConfiguration(c *fi.RunContext, name string, dest *AutoscalingGroup) (bool, error) {
 	cloud := c.Cloud().(*fi.AWSCloud)
 
@@ -308,14 +526,13 @@ func (e *AutoscalingGroup) findLaunchConfiguration(c *fi.RunContext, name string
 
 	glog.V(2).Info("found existing AutoscalingLaunchConfiguration")
 	i := response.LaunchConfigurations[0]
-	dest.Name = i.LaunchConfigurationName
 	dest.ImageID = i.ImageId
 	dest.InstanceType = i.InstanceType
-	dest.SSHKey = &SSHKey{Name:i.KeyName}
+	dest.SSHKey = &SSHKey{Name: i.KeyName}
 
 	securityGroups := []*SecurityGroup{}
 	for _, sgID := range i.SecurityGroups {
-		securityGroups = append(securityGroups, &SecurityGroup{ID:sgID})
+		securityGroups = append(securityGroups, &SecurityGroup{ID: sgID})
 	}
 	dest.SecurityGroups = securityGroups
 	dest.AssociatePublicIP = i.AssociatePublicIpAddress
@@ -329,7 +546,13 @@ func (e *AutoscalingGroup) findLaunchConfiguration(c *fi.RunContext, name string
 		return false, fmt.Errorf("error decoding UserData: %v", err)
 	}
 	dest.UserData = fi.NewStringResource(string(userData))
-	dest.IAMInstanceProfile = &IAMInstanceProfile{ID: i.IamInstanceProfile }
+	if i.IamInstanceProfile != nil {
+		// The launch configuration records the name of the profile, but we compare profiles by ID
+		dest.IAMInstanceProfile = &IAMInstanceProfile{Name: i.IamInstanceProfile}
+		if e.IAMInstanceProfile != nil && aws.StringValue(e.IAMInstanceProfile.Name) == *i.IamInstanceProfile {
+			dest.IAMInstanceProfile = e.IAMInstanceProfile
+		}
+	}
 	dest.AssociatePublicIP = i.AssociatePublicIpAddress
 
 	return true, nil
@@ -373,28 +596,31 @@ func renderAutoscalingLaunchConfigurationAWS(t *fi.AWSAPITarget, name string, e
 	if err != nil {
 		return fmt.Errorf("error creating AutoscalingLaunchConfiguration: %v", err)
 	}
+	if err := t.RecordCreation(fi.JournalTypeLaunchConfiguration, name); err != nil {
+		return err
+	}
 
 	return nil //return output.AddAWSTags(cloud.Tags(), v, "vpc")
 }
 
-func renderAutoscalingLaunchConfigurationBash(t *fi.BashTarget, name string, e *AutoscalingGroup) error {
-	t.CreateVar(e)
+func renderAutoscalingLaunchConfigurationBash(t *fi.BashTarget, name string, e *AutoscalingGroup) (*fi.BashCommand, error) {
 	glog.V(2).Infof("Creating AutoscalingLaunchConfiguration with Name:%q", *e.Name)
 
 	args := []string{"create-launch-configuration"}
 	args = append(args, "--launch-configuration-name", name)
-	args = append(args, e.buildAutoscalingCreateArgs(t)...)
+	createArgs, err := e.buildAutoscalingCreateArgs(t)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, createArgs...)
 
 	if e.UserData != nil {
 		tempFile, err := t.AddLocalResource(e.UserData)
 		if err != nil {
 			glog.Fatalf("error adding resource: %v", err)
 		}
-		args = append(args, "--user-data", "file://" + tempFile)
+		args = append(args, "--user-data", "file://"+tempFile)
 	}
 
-	t.AddAutoscalingCommand(args...)
-
-	return nil
+	return t.AddAutoscalingCommand(args...), nil
 }
-
@@ -2,11 +2,13 @@ package awsunits
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type DHCPOptions struct {
@@ -27,15 +29,19 @@ func (s *DHCPOptions) Key() string {
 }
 
 func (s *DHCPOptions) String() string {
-	return JsonString(s)
+	return units.JsonString(s)
 }
 
 func (e *DHCPOptions) find(c *fi.RunContext) (*DHCPOptions, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	request := &ec2.DescribeDhcpOptionsInput{}
 	if e.ID != nil {
-		request.DhcpOptionsIds = []*string{e.ID }
+		request.DhcpOptionsIds = []*string{e.ID}
 	} else {
 		request.Filters = cloud.BuildFilters(e.Name)
 	}
@@ -65,7 +71,7 @@ func (e *DHCPOptions) find(c *fi.RunContext) (*DHCPOptions, error) {
 			}
 			v = v + *av.Value
 		}
-		switch (k) {
+		switch k {
 		case "domain-name":
 			actual.DomainName = &v
 		case "domain-name-servers":
@@ -91,7 +97,7 @@ func (e *DHCPOptions) Run(c *fi.RunContext) error {
 	}
 
 	changes := &DHCPOptions{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -107,32 +113,34 @@ func (e *DHCPOptions) Run(c *fi.RunContext) error {
 func (s *DHCPOptions) checkChanges(a, e, changes *DHCPOptions) error {
 	if a == nil {
 		if e.Name == nil {
-			return MissingValueError("Name must be specified when creating a DHCPOptions")
+			return units.MissingValueError("Name must be specified when creating a DHCPOptions")
 		}
 	}
 	if a != nil {
 		if changes.ID != nil {
-			return InvalidChangeError("Cannot change DHCPOptions ID", changes.ID, e.ID)
+			return units.InvalidChangeError("Cannot change DHCPOptions ID", changes.ID, e.ID)
 		}
 	}
 	return nil
 }
 
-func (_*DHCPOptions) RenderAWS(t *fi.AWSAPITarget, a, e, changes *DHCPOptions) error {
+func (_ *DHCPOptions) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*DHCPOptions), ue.(*DHCPOptions)
+
 	if a == nil {
 		glog.V(2).Infof("Creating DHCPOptions with Name:%q", *e.Name)
 
 		request := &ec2.CreateDhcpOptionsInput{}
 		if e.DomainNameServers != nil {
 			o := &ec2.NewDhcpConfiguration{
-				Key: aws.String("domain-name-servers"),
+				Key:    aws.String("domain-name-servers"),
 				Values: []*string{e.DomainNameServers},
 			}
 			request.DhcpConfigurations = append(request.DhcpConfigurations, o)
 		}
 		if e.DomainName != nil {
 			o := &ec2.NewDhcpConfiguration{
-				Key: aws.String("domain-name"),
+				Key:    aws.String("domain-name"),
 				Values: []*string{e.DomainName},
 			}
 			request.DhcpConfigurations = append(request.DhcpConfigurations, o)
@@ -144,28 +152,101 @@ func (_*DHCPOptions) RenderAWS(t *fi.AWSAPITarget, a, e, changes *DHCPOptions) e
 		}
 
 		e.ID = response.DhcpOptions.DhcpOptionsId
+		if err := t.RecordCreation(fi.JournalTypeDHCPOptions, *e.ID); err != nil {
+			return err
+		}
 	}
 
 	return t.AddAWSTags(*e.ID, t.Cloud.BuildTags(e.Name))
 }
 
-func (_*DHCPOptions) RenderBash(t *fi.BashTarget, a, e, changes *DHCPOptions) error {
+func (_ *DHCPOptions) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*DHCPOptions), ue.(*DHCPOptions)
+
 	t.CreateVar(e)
 	if a == nil {
 		glog.V(2).Infof("Creating DHCPOptions with Name:%q", *e.Name)
 
+		e.AddBashLookup(t)
+
 		args := []string{"create-dhcp-options", "--dhcp-configuration"}
 		if e.DomainName != nil {
-			args = append(args, "Key=%s,Values=%s", "domain-name", *e.DomainName)
+			args = append(args, fmt.Sprintf("Key=%s,Values=%s", "domain-name", *e.DomainName))
 		}
 		if e.DomainNameServers != nil {
-			args = append(args, "Key=%s,Values=%s", "domain-name-servers", *e.DomainNameServers)
+			args = append(args, fmt.Sprintf("Key=%s,Values=%s", "domain-name-servers", *e.DomainNameServers))
 		}
 		args = append(args, "--query", "DhcpOptions.DhcpOptionsId")
-		t.AddEC2Command(args...).AssignTo(e)
+		t.AddEC2Command(args...).AssignTo(e).IfMissing(e)
 	} else {
-		t.AddAssignment(e, StringValue(a.ID))
+		t.AddAssignment(e, units.StringValue(a.ID))
 	}
 
 	return t.AddAWSTags(e, t.Cloud.BuildTags(e.Name))
 }
+
+// AddBashLookup finds an existing DHCPOptions by its tags
+func (e *DHCPOptions) AddBashLookup(t *fi.BashTarget) *fi.BashCommand {
+	return t.AddEC2TagLookup(e, e.Name, "describe-dhcp-options", "DhcpOptions[0].DhcpOptionsId")
+}
+
+type terraformDHCPOptions struct {
+	DomainName        *string           `json:"domain_name,omitempty"`
+	DomainNameServers []string          `json:"domain_name_servers,omitempty"`
+	Tags              map[string]string `json:"tags,omitempty"`
+}
+
+func (_ *DHCPOptions) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*DHCPOptions)
+
+	if e.ID != nil {
+		// Existing DHCP options; not managed by terraform
+		return nil
+	}
+
+	tf := &terraformDHCPOptions{
+		DomainName: e.DomainName,
+		Tags:       t.Cloud.BuildTags(e.Name),
+	}
+	if e.DomainNameServers != nil {
+		tf.DomainNameServers = strings.Split(*e.DomainNameServers, ",")
+	}
+	return t.RenderResource("aws_vpc_dhcp_options", e.Key(), tf)
+}
+
+func (e *DHCPOptions) TerraformLink() *string {
+	if e.ID != nil {
+		return e.ID
+	}
+	return units.String(fi.TerraformReference("aws_vpc_dhcp_options", e.Key(), "id"))
+}
+
+type cloudformationDHCPOptions struct {
+	DomainName        *string  `json:"DomainName,omitempty"`
+	DomainNameServers []string `json:"DomainNameServers,omitempty"`
+}
+
+func (_ *DHCPOptions) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*DHCPOptions)
+
+	if e.ID != nil {
+		// Existing DHCP options; not managed by the stack
+		return nil
+	}
+
+	cf := &cloudformationDHCPOptions{
+		DomainName: e.DomainName,
+	}
+	if e.DomainNameServers != nil {
+		cf.DomainNameServers = strings.Split(*e.DomainNameServers, ",")
+	}
+	t.AddTags("AWS::EC2::DHCPOptions", e.Key(), t.Cloud.BuildTags(e.Name))
+	return t.RenderResource("AWS::EC2::DHCPOptions", e.Key(), cf)
+}
+
+func (e *DHCPOptions) CloudformationLink() interface{} {
+	if e.ID != nil {
+		return *e.ID
+	}
+	return fi.CloudFormationRef("AWS::EC2::DHCPOptions", e.Key())
+}
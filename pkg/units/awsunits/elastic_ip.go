@@ -3,17 +3,19 @@ package awsunits
 import (
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/kopeio/kope/pkg/units"
+	"github.com/kopeio/kope/pkg/units/k8sunits"
 )
 
 type ElasticIP struct {
 	fi.SimpleUnit
 
-	ID            *string
-	PublicIP      *string
+	ID       *string
+	PublicIP *string
 
 	// Because ElasticIPs don't supporting tagging (sadly), we instead tag on
 	// a different resource
@@ -30,17 +32,21 @@ func (e *ElasticIP) Key() string {
 }
 
 func (e *ElasticIP) String() string {
-	return JsonString(e)
+	return units.JsonString(e)
 }
 
 func (e *ElasticIP) find(c *fi.RunContext) (*ElasticIP, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	publicIP := e.PublicIP
 	allocationID := e.ID
 
 	// Find via tag on foreign resource
-	if allocationID == nil && publicIP == nil && e.TagUsingKey != nil &&e.TagOnResource != nil &&  e.TagOnResource.GetID() != nil {
+	if allocationID == nil && publicIP == nil && e.TagUsingKey != nil && e.TagOnResource != nil && e.TagOnResource.GetID() != nil {
 		var filters []*ec2.Filter
 		filters = append(filters, fi.NewEC2Filter("key", *e.TagUsingKey))
 		filters = append(filters, fi.NewEC2Filter("resource-id", *e.TagOnResource.GetID()))
@@ -71,7 +77,7 @@ func (e *ElasticIP) find(c *fi.RunContext) (*ElasticIP, error) {
 		if allocationID != nil {
 			request.AllocationIds = []*string{allocationID}
 		} else if publicIP != nil {
-			request.Filters = []*ec2.Filter{fi.NewEC2Filter("public-ip", *publicIP) }
+			request.Filters = []*ec2.Filter{fi.NewEC2Filter("public-ip", *publicIP)}
 		}
 
 		response, err := cloud.EC2.DescribeAddresses(request)
@@ -90,12 +96,29 @@ func (e *ElasticIP) find(c *fi.RunContext) (*ElasticIP, error) {
 		actual := &ElasticIP{}
 		actual.ID = a.AllocationId
 		actual.PublicIP = a.PublicIp
+
+		// These are used to find the ElasticIP, and are re-applied whenever we render
+		actual.TagUsingKey = e.TagUsingKey
+		actual.TagOnResource = e.TagOnResource
 		return actual, nil
 	}
 
 	return nil, nil
 }
 
+var _ k8sunits.MasterPublicIP = &ElasticIP{}
+
+func (e *ElasticIP) FindPublicIP(c *fi.RunContext) (*string, error) {
+	if c.IsDeclarative() {
+		return e.PublicIP, nil
+	}
+	actual, err := e.find(c)
+	if err != nil || actual == nil {
+		return nil, err
+	}
+	return actual.PublicIP, nil
+}
+
 func (e *ElasticIP) Run(c *fi.RunContext) error {
 	a, err := e.find(c)
 	if err != nil {
@@ -112,7 +135,7 @@ func (e *ElasticIP) Run(c *fi.RunContext) error {
 	}
 
 	changes := &ElasticIP{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -129,7 +152,9 @@ func (s *ElasticIP) checkChanges(a, e, changes *ElasticIP) error {
 	return nil
 }
 
-func (_*ElasticIP) RenderAWS(t *fi.AWSAPITarget, a, e, changes *ElasticIP) error {
+func (_ *ElasticIP) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*ElasticIP), ue.(*ElasticIP)
+
 	var publicIP *string
 	var tagOnResourceID *string
 	if e.TagOnResource != nil {
@@ -151,6 +176,9 @@ func (_*ElasticIP) RenderAWS(t *fi.AWSAPITarget, a, e, changes *ElasticIP) error
 		}
 
 		e.ID = response.AllocationId
+		if err := t.RecordCreation(fi.JournalTypeElasticIP, *e.ID); err != nil {
+			return err
+		}
 		e.PublicIP = response.PublicIp
 		publicIP = response.PublicIp
 	} else {
@@ -169,41 +197,169 @@ func (_*ElasticIP) RenderAWS(t *fi.AWSAPITarget, a, e, changes *ElasticIP) error
 	return nil
 }
 
-func (_*ElasticIP) RenderBash(t *fi.BashTarget, a, e, changes *ElasticIP) error {
+func (_ *ElasticIP) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*ElasticIP), ue.(*ElasticIP)
+
+	var tagOnUnit fi.Unit
+	if e.TagOnResource != nil {
+		u, ok := e.TagOnResource.(fi.Unit)
+		if !ok {
+			return fmt.Errorf("Expected TagOnResource to be a Unit: %T", e.TagOnResource)
+		}
+		tagOnUnit = u
+	}
+
 	t.CreateVar(e)
 	if a == nil {
-		if e.TagOnResource == nil || e.TagUsingKey == nil {
+		if tagOnUnit == nil || e.TagUsingKey == nil {
 			return fmt.Errorf("cannot create ElasticIP without TagOnResource being set (would leak)")
 		}
 
 		glog.V(2).Infof("Creating ElasticIP for VPC")
 
+		tagOnID, err := t.ReadVar(tagOnUnit)
+		if err != nil {
+			return err
+		}
+
+		// An existing IP is found through the tag on the resource
+		t.AddEC2Command("describe-tags",
+			"--filters", "Name=resource-id,Values="+tagOnID, "Name=key,Values="+*e.TagUsingKey,
+			"--query", "Tags[0].Value").AssignToSuffixedVariable(e, "_PUBLICIP")
+		publicIP, err := t.ReadVarWithSuffix(e, "_PUBLICIP")
+		if err != nil {
+			return err
+		}
+		t.AddEC2Command("describe-addresses",
+			"--public-ips", publicIP,
+			"--query", "Addresses[0].AllocationId").AssignTo(e).If("! missing " + publicIP)
+
 		t.AddEC2Command("allocate-address",
 			"--domain", "vpc",
-			"--query", "AllocationId").AssignTo(e)
+			"--query", "AllocationId").AssignTo(e).IfMissing(e)
 	} else {
-		t.AddAssignment(e, StringValue(a.ID))
+		t.AddAssignment(e, units.StringValue(a.ID))
 	}
 
-	if e.TagOnResource != nil && e.TagUsingKey != nil {
-		tagOnUnit, ok := e.TagOnResource.(fi.Unit)
-		if !ok {
-			return fmt.Errorf("Expected TagOnResource to be a Unit: %T", e.TagOnResource)
-		}
-
+	if tagOnUnit != nil && e.TagUsingKey != nil {
 		if a != nil && a.PublicIP != nil {
 			tags := map[string]string{
-				*e.TagUsingKey:*a.PublicIP,
+				*e.TagUsingKey: *a.PublicIP,
 			}
 			err := t.AddAWSTags(tagOnUnit, tags)
 			if err != nil {
 				return fmt.Errorf("error adding tags to resource for ElasticIP: %v", err)
 			}
 		} else {
-			t.AddEC2Command("describe-addresses", "--allocation-ids", t.ReadVar(e), "--query", "Addresses[].PublicIp").AssignToSuffixedVariable(e, "_PUBLICIP")
-			t.AddBashCommand("add-tag", t.ReadVar(tagOnUnit), *e.TagUsingKey, t.ReadVarWithSuffix(e, "_PUBLICIP"))
+			allocationID, err := t.ReadVar(e)
+			if err != nil {
+				return err
+			}
+			tagOnID, err := t.ReadVar(tagOnUnit)
+			if err != nil {
+				return err
+			}
+			t.AddEC2Command("describe-addresses", "--allocation-ids", allocationID, "--query", "Addresses[].PublicIp").AssignToSuffixedVariable(e, "_PUBLICIP")
+			publicIP, err := t.ReadVarWithSuffix(e, "_PUBLICIP")
+			if err != nil {
+				return err
+			}
+			t.AddBashCommand("add-tag", tagOnID, *e.TagUsingKey, publicIP)
+		}
+	}
+
+	return nil
+}
+
+type terraformElasticIP struct {
+	VPC *bool `json:"vpc,omitempty"`
+}
+
+func (_ *ElasticIP) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*ElasticIP)
+
+	if e.ID != nil {
+		// Existing ElasticIP; not managed by terraform
+		return nil
+	}
+	if e.PublicIP != nil {
+		return fmt.Errorf("terraform target requires the allocation ID (not the public IP) of an existing ElasticIP")
+	}
+
+	tf := &terraformElasticIP{
+		VPC: units.Bool(true),
+	}
+	err := t.RenderResource("aws_eip", e.Key(), tf)
+	if err != nil {
+		return err
+	}
+
+	if e.TagOnResource != nil && e.TagUsingKey != nil {
+		// Record the IP on the tagged resource, as we do when running directly
+		tags := map[string]string{
+			*e.TagUsingKey: fi.TerraformReference("aws_eip", e.Key(), "public_ip"),
+		}
+		switch r := e.TagOnResource.(type) {
+		case *PersistentVolume:
+			err = t.RenderResource("aws_ebs_volume", r.Key(), &terraformPersistentVolume{Tags: tags})
+		default:
+			return fmt.Errorf("unhandled TagOnResource type for terraform: %T", e.TagOnResource)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *ElasticIP) TerraformLink() *string {
+	if e.ID != nil {
+		return e.ID
+	}
+	return units.String(fi.TerraformReference("aws_eip", e.Key(), "id"))
+}
+
+type cloudformationElasticIP struct {
+	Domain *string `json:"Domain,omitempty"`
+}
+
+func (_ *ElasticIP) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*ElasticIP)
+
+	if e.ID != nil {
+		// Existing ElasticIP; not managed by the stack
+		return nil
+	}
+	if e.PublicIP != nil {
+		return fmt.Errorf("cloudformation target requires the allocation ID (not the public IP) of an existing ElasticIP")
+	}
+
+	cf := &cloudformationElasticIP{
+		Domain: units.String("vpc"),
+	}
+	err := t.RenderResource("AWS::EC2::EIP", e.Key(), cf)
+	if err != nil {
+		return err
+	}
+
+	if e.TagOnResource != nil && e.TagUsingKey != nil {
+		// Record the IP on the tagged resource, as we do when running directly (Ref returns the public IP)
+		publicIP := fi.CloudFormationRef("AWS::EC2::EIP", e.Key())
+		switch r := e.TagOnResource.(type) {
+		case *PersistentVolume:
+			t.AddTag("AWS::EC2::Volume", r.Key(), *e.TagUsingKey, publicIP)
+		default:
+			return fmt.Errorf("unhandled TagOnResource type for cloudformation: %T", e.TagOnResource)
 		}
 	}
 
 	return nil
 }
+
+func (e *ElasticIP) CloudformationAllocationID() interface{} {
+	if e.ID != nil {
+		return *e.ID
+	}
+	return fi.CloudFormationGetAtt("AWS::EC2::EIP", e.Key(), "AllocationId")
+}
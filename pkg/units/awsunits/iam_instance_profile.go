@@ -3,9 +3,11 @@ package awsunits
 import (
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type IAMInstanceProfile struct {
@@ -24,11 +26,20 @@ func (s *IAMInstanceProfile) GetID() *string {
 }
 
 func (e *IAMInstanceProfile) find(c *fi.RunContext) (*IAMInstanceProfile, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	request := &iam.GetInstanceProfileInput{InstanceProfileName: e.Name}
 
 	response, err := cloud.IAM.GetInstanceProfile(request)
+	if awsErr, ok := err.(awserr.Error); ok {
+		if awsErr.Code() == "NoSuchEntity" {
+			return nil, nil
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error getting IAMInstanceProfile: %v", err)
 	}
@@ -53,7 +64,7 @@ func (e *IAMInstanceProfile) Run(c *fi.RunContext) error {
 	}
 
 	changes := &IAMInstanceProfile{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -69,13 +80,15 @@ func (e *IAMInstanceProfile) Run(c *fi.RunContext) error {
 func (s *IAMInstanceProfile) checkChanges(a, e, changes *IAMInstanceProfile) error {
 	if a != nil {
 		if e.Name == nil {
-			return MissingValueError("Name is required when creating IAMInstanceProfile")
+			return units.MissingValueError("Name is required when creating IAMInstanceProfile")
 		}
 	}
 	return nil
 }
 
-func (_*IAMInstanceProfile) RenderAWS(t *fi.AWSAPITarget, a, e, changes *IAMInstanceProfile) error {
+func (_ *IAMInstanceProfile) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*IAMInstanceProfile), ue.(*IAMInstanceProfile)
+
 	if a == nil {
 		glog.V(2).Infof("Creating IAMInstanceProfile with Name:%q", *e.Name)
 
@@ -88,19 +101,65 @@ func (_*IAMInstanceProfile) RenderAWS(t *fi.AWSAPITarget, a, e, changes *IAMInst
 		}
 
 		e.ID = response.InstanceProfile.InstanceProfileId
+		if err := t.RecordCreation(fi.JournalTypeIAMInstanceProfile, *e.Name); err != nil {
+			return err
+		}
 	}
 
 	return nil //return output.AddAWSTags(cloud.Tags(), v, "vpc")
 }
 
-func (_*IAMInstanceProfile) RenderBash(t *fi.BashTarget, a, e, changes *IAMInstanceProfile) error {
+func (_ *IAMInstanceProfile) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*IAMInstanceProfile), ue.(*IAMInstanceProfile)
+
 	t.CreateVar(e)
 	if a == nil {
 		glog.V(2).Infof("Creating IAMInstanceProfile with Name:%q", *e.Name)
 
+		t.AddIAMCommand("get-instance-profile",
+			"--instance-profile-name", *e.Name,
+			"--query", "InstanceProfile.InstanceProfileId").AssignTo(e).IgnoreErrors()
 		t.AddIAMCommand("create-instance-profile",
-			"--instance-profile-name", *e.Name)
+			"--instance-profile-name", *e.Name,
+			"--query", "InstanceProfile.InstanceProfileId").AssignTo(e).IfMissing(e)
 	}
 
 	return nil
 }
+
+type terraformIAMInstanceProfile struct {
+	Name *string `json:"name,omitempty"`
+	Role *string `json:"role,omitempty"`
+}
+
+func (_ *IAMInstanceProfile) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*IAMInstanceProfile)
+
+	tf := &terraformIAMInstanceProfile{
+		Name: e.Name,
+	}
+	return t.RenderResource("aws_iam_instance_profile", e.Key(), tf)
+}
+
+func (e *IAMInstanceProfile) TerraformLink() *string {
+	return units.String(fi.TerraformReference("aws_iam_instance_profile", e.Key(), "name"))
+}
+
+type cloudformationIAMInstanceProfile struct {
+	Name  *string       `json:"InstanceProfileName,omitempty"`
+	Roles []interface{} `json:"Roles,omitempty"`
+}
+
+func (_ *IAMInstanceProfile) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*IAMInstanceProfile)
+
+	cf := &cloudformationIAMInstanceProfile{
+		Name: e.Name,
+	}
+	return t.RenderResource("AWS::IAM::InstanceProfile", e.Key(), cf)
+}
+
+// CloudformationLink returns a reference to the instance profile; Ref returns the profile name
+func (e *IAMInstanceProfile) CloudformationLink() interface{} {
+	return fi.CloudFormationRef("AWS::IAM::InstanceProfile", e.Key())
+}
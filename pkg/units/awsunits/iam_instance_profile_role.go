@@ -4,9 +4,11 @@ import (
 	"fmt"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type IAMInstanceProfileRole struct {
@@ -21,6 +23,10 @@ func (s *IAMInstanceProfileRole) Key() string {
 }
 
 func (e *IAMInstanceProfileRole) find(c *fi.RunContext) (*IAMInstanceProfileRole, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	if e.Role == nil || e.Role.ID == nil {
@@ -32,6 +38,11 @@ func (e *IAMInstanceProfileRole) find(c *fi.RunContext) (*IAMInstanceProfileRole
 	request := &iam.GetInstanceProfileInput{InstanceProfileName: e.InstanceProfile.Name}
 
 	response, err := cloud.IAM.GetInstanceProfile(request)
+	if awsErr, ok := err.(awserr.Error); ok {
+		if awsErr.Code() == "NoSuchEntity" {
+			return nil, nil
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error getting IAMInstanceProfile: %v", err)
 	}
@@ -56,7 +67,7 @@ func (e *IAMInstanceProfileRole) Run(c *fi.RunContext) error {
 	}
 
 	changes := &IAMInstanceProfileRole{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -72,38 +83,73 @@ func (e *IAMInstanceProfileRole) Run(c *fi.RunContext) error {
 func (s *IAMInstanceProfileRole) checkChanges(a, e, changes *IAMInstanceProfileRole) error {
 	if a != nil {
 		if e.Role == nil {
-			return MissingValueError("Role is required when creating IAMInstanceProfileRole")
+			return units.MissingValueError("Role is required when creating IAMInstanceProfileRole")
 		}
 		if e.InstanceProfile == nil {
-			return MissingValueError("InstanceProfile is required when creating IAMInstanceProfileRole")
+			return units.MissingValueError("InstanceProfile is required when creating IAMInstanceProfileRole")
 		}
 	}
 	return nil
 }
 
-func (_*IAMInstanceProfileRole) RenderAWS(t *fi.AWSAPITarget, a, e, changes *IAMInstanceProfileRole) error {
+func (_ *IAMInstanceProfileRole) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*IAMInstanceProfileRole), ue.(*IAMInstanceProfileRole)
+
 	if a == nil {
 		request := &iam.AddRoleToInstanceProfileInput{}
 		request.InstanceProfileName = e.InstanceProfile.Name
 		request.RoleName = e.Role.Name
 
-		_, err := t.Cloud.IAM.AddRoleToInstanceProfile(request)
+		// The role or instance profile may have only just been created
+		err := t.Cloud.RetryOnError("adding Role to InstanceProfile", func() error {
+			_, err := t.Cloud.IAM.AddRoleToInstanceProfile(request)
+			return err
+		})
 		if err != nil {
 			return fmt.Errorf("error creating IAMInstanceProfileRole: %v", err)
 		}
+		if err := t.RecordCreation(fi.JournalTypeIAMInstanceProfileRole, *e.InstanceProfile.Name+"/"+*e.Role.Name); err != nil {
+			return err
+		}
 	}
 
 	return nil //return output.AddAWSTags(cloud.Tags(), v, "vpc")
 }
 
-func (_*IAMInstanceProfileRole) RenderBash(t *fi.BashTarget, a, e, changes *IAMInstanceProfileRole) error {
+func (_ *IAMInstanceProfileRole) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*IAMInstanceProfileRole), ue.(*IAMInstanceProfileRole)
+
 	if a == nil {
 		glog.V(2).Infof("Creating IAMInstanceProfileRole")
 
+		t.CreateVar(e)
+		t.AddIAMCommand("get-instance-profile",
+			"--instance-profile-name", *e.InstanceProfile.Name,
+			"--query", fmt.Sprintf("InstanceProfile.Roles[?RoleName=='%s'].RoleName", *e.Role.Name)).AssignTo(e).IgnoreErrors()
 		t.AddIAMCommand("add-role-to-instance-profile",
 			"--instance-profile-name", *e.InstanceProfile.Name,
-			"--role-name", *e.Role.Name)
+			"--role-name", *e.Role.Name).IfMissing(e)
 	}
 
 	return nil
 }
+
+func (_ *IAMInstanceProfileRole) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*IAMInstanceProfileRole)
+
+	// Terraform models the role as part of the instance profile
+	tf := &terraformIAMInstanceProfile{
+		Role: e.Role.TerraformLink(),
+	}
+	return t.RenderResource("aws_iam_instance_profile", e.InstanceProfile.Key(), tf)
+}
+
+func (_ *IAMInstanceProfileRole) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*IAMInstanceProfileRole)
+
+	// Cloudformation models the role as part of the instance profile
+	cf := &cloudformationIAMInstanceProfile{
+		Roles: []interface{}{e.Role.CloudformationLink()},
+	}
+	return t.RenderResource("AWS::IAM::InstanceProfile", e.InstanceProfile.Key(), cf)
+}
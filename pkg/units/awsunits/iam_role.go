@@ -1,12 +1,16 @@
 package awsunits
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/golang/glog"
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type IAMRole struct {
@@ -26,11 +30,20 @@ func (s *IAMRole) GetID() *string {
 }
 
 func (e *IAMRole) find(c *fi.RunContext) (*IAMRole, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	request := &iam.GetRoleInput{RoleName: e.Name}
 
 	response, err := cloud.IAM.GetRole(request)
+	if awsErr, ok := err.(awserr.Error); ok {
+		if awsErr.Code() == "NoSuchEntity" {
+			return nil, nil
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error getting role: %v", err)
 	}
@@ -40,7 +53,12 @@ func (e *IAMRole) find(c *fi.RunContext) (*IAMRole, error) {
 	actual.ID = r.RoleId
 	actual.Name = r.RoleName
 	if r.AssumeRolePolicyDocument != nil {
-		actual.RolePolicyDocument = fi.NewStringResource(*r.AssumeRolePolicyDocument)
+		// IAM returns the policy document URL-encoded
+		policy, err := url.QueryUnescape(*r.AssumeRolePolicyDocument)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding role policy document: %v", err)
+		}
+		actual.RolePolicyDocument = fi.NewStringResource(policy)
 	}
 	glog.V(2).Infof("found matching IAMRole %q", *actual.ID)
 	return actual, nil
@@ -57,7 +75,7 @@ func (e *IAMRole) Run(c *fi.RunContext) error {
 	}
 
 	changes := &IAMRole{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -73,13 +91,15 @@ func (e *IAMRole) Run(c *fi.RunContext) error {
 func (s *IAMRole) checkChanges(a, e, changes *IAMRole) error {
 	if a != nil {
 		if e.Name == nil {
-			return MissingValueError("Name is required when creating IAMRole")
+			return units.MissingValueError("Name is required when creating IAMRole")
 		}
 	}
 	return nil
 }
 
-func (_*IAMRole) RenderAWS(t *fi.AWSAPITarget, a, e, changes *IAMRole) error {
+func (_ *IAMRole) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*IAMRole), ue.(*IAMRole)
+
 	if a == nil {
 		glog.V(2).Infof("Creating IAMRole with Name:%q", *e.Name)
 
@@ -98,12 +118,17 @@ func (_*IAMRole) RenderAWS(t *fi.AWSAPITarget, a, e, changes *IAMRole) error {
 		}
 
 		e.ID = response.Role.RoleId
+		if err := t.RecordCreation(fi.JournalTypeIAMRole, *e.Name); err != nil {
+			return err
+		}
 	}
 
 	return nil //return output.AddAWSTags(cloud.Tags(), v, "vpc")
 }
 
-func (_*IAMRole) RenderBash(t *fi.BashTarget, a, e, changes *IAMRole) error {
+func (_ *IAMRole) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*IAMRole), ue.(*IAMRole)
+
 	t.CreateVar(e)
 	if a == nil {
 		glog.V(2).Infof("Creating IAMRole with Name:%q", *e.Name)
@@ -113,12 +138,79 @@ func (_*IAMRole) RenderBash(t *fi.BashTarget, a, e, changes *IAMRole) error {
 			return err
 		}
 
+		t.AddIAMCommand("get-role",
+			"--role-name", *e.Name,
+			"--query", "Role.RoleId").AssignTo(e).IgnoreErrors()
 		t.AddIAMCommand("create-role",
 			"--role-name", *e.Name,
-			"--assume-role-policy-document", rolePolicyDocument)
+			"--assume-role-policy-document", "file://"+rolePolicyDocument,
+			"--query", "Role.RoleId").AssignTo(e).IfMissing(e)
 	} else {
 		t.AddAssignment(e, *e.ID)
 	}
 
 	return nil
 }
+
+type terraformIAMRole struct {
+	Name             *string `json:"name,omitempty"`
+	AssumeRolePolicy *string `json:"assume_role_policy,omitempty"`
+}
+
+func (_ *IAMRole) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*IAMRole)
+
+	tf := &terraformIAMRole{
+		Name: e.Name,
+	}
+	if e.RolePolicyDocument != nil {
+		policy, err := t.AddFile("aws_iam_role", e.Key(), "policy", e.RolePolicyDocument)
+		if err != nil {
+			return err
+		}
+		tf.AssumeRolePolicy = &policy
+	}
+	return t.RenderResource("aws_iam_role", e.Key(), tf)
+}
+
+func (e *IAMRole) TerraformLink() *string {
+	return units.String(fi.TerraformReference("aws_iam_role", e.Key(), "name"))
+}
+
+type cloudformationIAMRole struct {
+	Name             *string         `json:"RoleName,omitempty"`
+	AssumeRolePolicy json.RawMessage `json:"AssumeRolePolicyDocument,omitempty"`
+}
+
+func (_ *IAMRole) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*IAMRole)
+
+	cf := &cloudformationIAMRole{
+		Name: e.Name,
+	}
+	if e.RolePolicyDocument != nil {
+		policy, err := buildCloudformationPolicyDocument(e.RolePolicyDocument)
+		if err != nil {
+			return fmt.Errorf("error rendering IAMRole RolePolicyDocument: %v", err)
+		}
+		cf.AssumeRolePolicy = policy
+	}
+	return t.RenderResource("AWS::IAM::Role", e.Key(), cf)
+}
+
+// CloudformationLink returns a reference to the role; Ref returns the role name
+func (e *IAMRole) CloudformationLink() interface{} {
+	return fi.CloudFormationRef("AWS::IAM::Role", e.Key())
+}
+
+// buildCloudformationPolicyDocument returns the policy document as JSON, because cloudformation embeds it in the template
+func buildCloudformationPolicyDocument(r fi.Resource) (json.RawMessage, error) {
+	d, err := fi.ResourceAsBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(d) {
+		return nil, fmt.Errorf("policy document is not valid JSON")
+	}
+	return json.RawMessage(d), nil
+}
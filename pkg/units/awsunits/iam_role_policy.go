@@ -1,12 +1,16 @@
 package awsunits
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/golang/glog"
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type IAMRolePolicy struct {
@@ -27,6 +31,10 @@ func (s *IAMRolePolicy) GetID() *string {
 }
 
 func (e *IAMRolePolicy) find(c *fi.RunContext) (*IAMRolePolicy, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	request := &iam.GetRolePolicyInput{
@@ -35,15 +43,26 @@ func (e *IAMRolePolicy) find(c *fi.RunContext) (*IAMRolePolicy, error) {
 	}
 
 	response, err := cloud.IAM.GetRolePolicy(request)
+	if awsErr, ok := err.(awserr.Error); ok {
+		if awsErr.Code() == "NoSuchEntity" {
+			return nil, nil
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error getting role: %v", err)
 	}
 
 	p := response
 	actual := &IAMRolePolicy{}
-	actual.Role = &IAMRole{Name: p.RoleName}
+	// We looked up the policy by the role name
+	actual.Role = e.Role
 	if p.PolicyDocument != nil {
-		actual.PolicyDocument = fi.NewStringResource(*p.PolicyDocument)
+		// IAM returns the policy document URL-encoded
+		policy, err := url.QueryUnescape(*p.PolicyDocument)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding policy document: %v", err)
+		}
+		actual.PolicyDocument = fi.NewStringResource(policy)
 	}
 	actual.Name = p.PolicyName
 	return actual, nil
@@ -56,7 +75,7 @@ func (e *IAMRolePolicy) Run(c *fi.RunContext) error {
 	}
 
 	changes := &IAMRolePolicy{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -72,13 +91,15 @@ func (e *IAMRolePolicy) Run(c *fi.RunContext) error {
 func (s *IAMRolePolicy) checkChanges(a, e, changes *IAMRolePolicy) error {
 	if a != nil {
 		if e.Name == nil {
-			return MissingValueError("Name is required when creating IAMRolePolicy")
+			return units.MissingValueError("Name is required when creating IAMRolePolicy")
 		}
 	}
 	return nil
 }
 
-func (_*IAMRolePolicy) RenderAWS(t *fi.AWSAPITarget, a, e, changes *IAMRolePolicy) error {
+func (_ *IAMRolePolicy) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*IAMRolePolicy), ue.(*IAMRolePolicy)
+
 	if a == nil {
 		glog.V(2).Infof("Creating IAMRolePolicy")
 
@@ -96,12 +117,17 @@ func (_*IAMRolePolicy) RenderAWS(t *fi.AWSAPITarget, a, e, changes *IAMRolePolic
 		if err != nil {
 			return fmt.Errorf("error creating IAMRolePolicy: %v", err)
 		}
+		if err := t.RecordCreation(fi.JournalTypeIAMRolePolicy, *request.RoleName+"/"+*request.PolicyName); err != nil {
+			return err
+		}
 	}
 
 	return nil //return output.AddAWSTags(cloud.Tags(), v, "vpc")
 }
 
-func (_*IAMRolePolicy) RenderBash(t *fi.BashTarget, a, e, changes *IAMRolePolicy) error {
+func (_ *IAMRolePolicy) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*IAMRolePolicy), ue.(*IAMRolePolicy)
+
 	t.CreateVar(e)
 	if a == nil {
 		glog.V(2).Infof("Creating IAMRolePolicy with Name:%q", *e.Name)
@@ -111,12 +137,61 @@ func (_*IAMRolePolicy) RenderBash(t *fi.BashTarget, a, e, changes *IAMRolePolicy
 			return err
 		}
 
+		t.AddIAMCommand("get-role-policy",
+			"--role-name", *e.Role.Name,
+			"--policy-name", *e.Name,
+			"--query", "PolicyName").AssignTo(e).IgnoreErrors()
 		t.AddIAMCommand("put-role-policy",
 			"--role-name", *e.Role.Name,
 			"--policy-name", *e.Name,
-			"--policy-document", rolePolicyDocument)
+			"--policy-document", "file://"+rolePolicyDocument).IfMissing(e)
 	}
 
 	return nil
 }
 
+type terraformIAMRolePolicy struct {
+	Name           *string `json:"name,omitempty"`
+	Role           *string `json:"role,omitempty"`
+	PolicyDocument *string `json:"policy,omitempty"`
+}
+
+func (_ *IAMRolePolicy) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*IAMRolePolicy)
+
+	tf := &terraformIAMRolePolicy{
+		Name: e.Name,
+		Role: e.Role.TerraformLink(),
+	}
+	if e.PolicyDocument != nil {
+		policy, err := t.AddFile("aws_iam_role_policy", e.Key(), "policy", e.PolicyDocument)
+		if err != nil {
+			return err
+		}
+		tf.PolicyDocument = &policy
+	}
+	return t.RenderResource("aws_iam_role_policy", e.Key(), tf)
+}
+
+type cloudformationIAMRolePolicy struct {
+	Name           *string         `json:"PolicyName,omitempty"`
+	Roles          []interface{}   `json:"Roles,omitempty"`
+	PolicyDocument json.RawMessage `json:"PolicyDocument,omitempty"`
+}
+
+func (_ *IAMRolePolicy) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*IAMRolePolicy)
+
+	cf := &cloudformationIAMRolePolicy{
+		Name:  e.Name,
+		Roles: []interface{}{e.Role.CloudformationLink()},
+	}
+	if e.PolicyDocument != nil {
+		policy, err := buildCloudformationPolicyDocument(e.PolicyDocument)
+		if err != nil {
+			return fmt.Errorf("error rendering IAMRolePolicy PolicyDocument: %v", err)
+		}
+		cf.PolicyDocument = policy
+	}
+	return t.RenderResource("AWS::IAM::Policy", e.Key(), cf)
+}
@@ -3,11 +3,15 @@ package awsunits
 import (
 	"fmt"
 
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
-	"encoding/base64"
+	"github.com/kopeio/kope/pkg/units"
+	"time"
 )
 
 const MaxUserDataSize = 16384
@@ -15,15 +19,15 @@ const MaxUserDataSize = 16384
 type Instance struct {
 	fi.SimpleUnit
 
-	ID               *string
+	ID *string
 	InstanceCommonConfig
-	UserData         fi.Resource
+	UserData fi.Resource
 
 	Subnet           *Subnet
 	PrivateIPAddress *string
 
-	Name             *string
-	Tags             map[string]string
+	Name *string
+	Tags map[string]string
 }
 
 func (s *Instance) Key() string {
@@ -35,6 +39,10 @@ func (s *Instance) GetID() *string {
 }
 
 func (e *Instance) find(c *fi.RunContext) (*Instance, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	filters := cloud.BuildFilters(e.Name)
@@ -78,6 +86,39 @@ func (e *Instance) find(c *fi.RunContext) (*Instance, error) {
 	if i.SubnetId != nil {
 		actual.Subnet = &Subnet{ID: i.SubnetId}
 	}
+
+	actual.ImageID = i.ImageId
+	actual.InstanceType = i.InstanceType
+	if i.KeyName != nil {
+		actual.SSHKey = &SSHKey{Name: i.KeyName}
+	}
+	securityGroups := []*SecurityGroup{}
+	for _, sg := range i.SecurityGroups {
+		securityGroups = append(securityGroups, &SecurityGroup{ID: sg.GroupId})
+	}
+	actual.SecurityGroups = securityGroups
+	if i.IamInstanceProfile != nil {
+		actual.IAMInstanceProfile = &IAMInstanceProfile{ID: i.IamInstanceProfile.Id}
+	}
+
+	// These can only be set at launch, and DescribeInstances does not return them
+	actual.AssociatePublicIP = e.AssociatePublicIP
+	actual.BlockDeviceMappings = e.BlockDeviceMappings
+	actual.UserData = e.UserData
+
+	// The cluster tags are added by buildTags; Tags only holds the extra tags
+	clusterTags := cloud.BuildTags(e.Name)
+	for _, tag := range i.Tags {
+		k := aws.StringValue(tag.Key)
+		if _, found := clusterTags[k]; found {
+			continue
+		}
+		if actual.Tags == nil {
+			actual.Tags = make(map[string]string)
+		}
+		actual.Tags[k] = aws.StringValue(tag.Value)
+	}
+
 	return actual, nil
 }
 
@@ -92,7 +133,7 @@ func (e *Instance) Run(c *fi.RunContext) error {
 	}
 
 	changes := &Instance{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -108,7 +149,7 @@ func (e *Instance) Run(c *fi.RunContext) error {
 func (s *Instance) checkChanges(a, e, changes *Instance) error {
 	if a != nil {
 		if e.Name == nil {
-			return MissingValueError("Name is required when creating Instance")
+			return units.MissingValueError("Name is required when creating Instance")
 		}
 	}
 	return nil
@@ -125,7 +166,15 @@ func (e *Instance) buildTags(cloud *fi.AWSCloud) map[string]string {
 	return tags
 }
 
-func (_*Instance) RenderAWS(t *fi.AWSAPITarget, a, e, changes *Instance) error {
+// buildClientToken returns an idempotency token for a create call; EC2 allows up to 64 characters
+func buildClientToken(name string) string {
+	hash := sha1.Sum([]byte(fmt.Sprintf("%s-%d", name, time.Now().UnixNano())))
+	return hex.EncodeToString(hash[:])
+}
+
+func (_ *Instance) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*Instance), ue.(*Instance)
+
 	if a == nil {
 		glog.V(2).Infof("Creating Instance with Name:%q", *e.Name)
 
@@ -141,11 +190,11 @@ func (_*Instance) RenderAWS(t *fi.AWSAPITarget, a, e, changes *Instance) error {
 		}
 		request.NetworkInterfaces = []*ec2.InstanceNetworkInterfaceSpecification{
 			{
-				DeviceIndex:aws.Int64(0),
-				AssociatePublicIpAddress:e.AssociatePublicIP,
-				SubnetId:e.Subnet.ID,
-				PrivateIpAddress:e.PrivateIPAddress,
-				Groups: securityGroupIDs,
+				DeviceIndex:              aws.Int64(0),
+				AssociatePublicIpAddress: e.AssociatePublicIP,
+				SubnetId:                 e.Subnet.ID,
+				PrivateIpAddress:         e.PrivateIPAddress,
+				Groups:                   securityGroupIDs,
 			},
 		}
 
@@ -178,24 +227,43 @@ func (_*Instance) RenderAWS(t *fi.AWSAPITarget, a, e, changes *Instance) error {
 			}
 		}
 
-		response, err := t.Cloud.EC2.RunInstances(request)
+		// Launches that fail with a transient error are retried; if the instance was in fact launched, the token
+		// makes EC2 return it rather than launching another.  The token is only reused within this run.
+		request.ClientToken = aws.String(buildClientToken(*e.Name))
+
+		// The instance profile may not yet have propagated from IAM
+		var response *ec2.Reservation
+		err := t.Cloud.RetryOnError("creating Instance", func() error {
+			var err error
+			response, err = t.Cloud.EC2.RunInstances(request)
+			return err
+		})
 		if err != nil {
 			return fmt.Errorf("error creating Instance: %v", err)
 		}
 
 		e.ID = response.Instances[0].InstanceId
+		if err := t.RecordCreation(fi.JournalTypeInstance, *e.ID); err != nil {
+			return err
+		}
 	}
 
 	return t.AddAWSTags(*e.ID, e.buildTags(t.Cloud))
 }
 
-func (_*Instance) RenderBash(t *fi.BashTarget, a, e, changes *Instance) error {
+func (_ *Instance) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*Instance), ue.(*Instance)
+
 	t.CreateVar(e)
 	if a == nil {
 		glog.V(2).Infof("Creating Instance with Name:%q", *e.Name)
 
 		args := []string{"run-instances"}
-		args = append(args, e.buildEC2CreateArgs(t)...)
+		createArgs, err := e.buildEC2CreateArgs(t)
+		if err != nil {
+			return err
+		}
+		args = append(args, createArgs...)
 
 		if e.UserData != nil {
 			d, err := fi.ResourceAsBytes(e.UserData)
@@ -213,11 +281,15 @@ func (_*Instance) RenderBash(t *fi.BashTarget, a, e, changes *Instance) error {
 			if err != nil {
 				glog.Fatalf("error adding resource: %v", err)
 			}
-			args = append(args, "--user-data", "file://" + tempFile)
+			args = append(args, "--user-data", "fileb://"+tempFile)
 		}
 
 		if e.Subnet != nil {
-			args = append(args, "--subnet-id", t.ReadVar(e.Subnet))
+			subnetID, err := t.ReadVar(e.Subnet)
+			if err != nil {
+				return err
+			}
+			args = append(args, "--subnet-id", subnetID)
 		}
 		if e.PrivateIPAddress != nil {
 			args = append(args, "--private-ip-address", *e.PrivateIPAddress)
@@ -225,7 +297,8 @@ func (_*Instance) RenderBash(t *fi.BashTarget, a, e, changes *Instance) error {
 
 		args = append(args, "--query", "Instances[0].InstanceId")
 
-		t.AddEC2Command(args...).AssignTo(e)
+		e.AddBashLookup(t)
+		t.AddEC2Command(args...).AssignTo(e).IfMissing(e)
 	} else {
 		t.AddAssignment(e, aws.StringValue(a.ID))
 	}
@@ -233,6 +306,123 @@ func (_*Instance) RenderBash(t *fi.BashTarget, a, e, changes *Instance) error {
 	return t.AddAWSTags(e, e.buildTags(t.Cloud))
 }
 
+// AddBashLookup finds an existing instance by its tags
+func (e *Instance) AddBashLookup(t *fi.BashTarget) *fi.BashCommand {
+	return t.AddEC2TagLookup(e, e.Name, "describe-instances", "Reservations[0].Instances[0].InstanceId",
+		"Name=instance-state-name,Values=pending,running,stopping,stopped")
+}
+
+type terraformInstance struct {
+	AMI                      *string                 `json:"ami,omitempty"`
+	InstanceType             *string                 `json:"instance_type,omitempty"`
+	KeyName                  *string                 `json:"key_name,omitempty"`
+	SubnetID                 *string                 `json:"subnet_id,omitempty"`
+	PrivateIP                *string                 `json:"private_ip,omitempty"`
+	SecurityGroupIDs         []*string               `json:"vpc_security_group_ids,omitempty"`
+	AssociatePublicIPAddress *bool                   `json:"associate_public_ip_address,omitempty"`
+	IAMInstanceProfile       *string                 `json:"iam_instance_profile,omitempty"`
+	UserData                 *string                 `json:"user_data_base64,omitempty"`
+	EphemeralBlockDevices    []*terraformBlockDevice `json:"ephemeral_block_device,omitempty"`
+	Tags                     map[string]string       `json:"tags,omitempty"`
+}
+
+func (_ *Instance) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*Instance)
+
+	tf := &terraformInstance{
+		AMI:                      e.ImageID,
+		InstanceType:             e.InstanceType,
+		PrivateIP:                e.PrivateIPAddress,
+		SecurityGroupIDs:         e.buildTerraformSecurityGroups(),
+		AssociatePublicIPAddress: e.AssociatePublicIP,
+		EphemeralBlockDevices:    e.buildTerraformEphemeralBlockDevices(),
+		Tags:                     e.buildTags(t.Cloud),
+	}
+	if e.SSHKey != nil {
+		tf.KeyName = e.SSHKey.TerraformLink()
+	}
+	if e.Subnet != nil {
+		tf.SubnetID = e.Subnet.TerraformLink()
+	}
+	if e.IAMInstanceProfile != nil {
+		tf.IAMInstanceProfile = e.IAMInstanceProfile.TerraformLink()
+	}
+	if e.UserData != nil {
+		userData, err := buildTerraformUserData(t, "aws_instance", e.Key(), e.UserData)
+		if err != nil {
+			return err
+		}
+		tf.UserData = userData
+	}
+	return t.RenderResource("aws_instance", e.Key(), tf)
+}
+
+func (e *Instance) TerraformLink() *string {
+	return units.String(fi.TerraformReference("aws_instance", e.Key(), "id"))
+}
+
+type cloudformationInstanceNetworkInterface struct {
+	DeviceIndex              *string       `json:"DeviceIndex,omitempty"`
+	AssociatePublicIPAddress *bool         `json:"AssociatePublicIpAddress,omitempty"`
+	SubnetID                 interface{}   `json:"SubnetId,omitempty"`
+	PrivateIPAddress         *string       `json:"PrivateIpAddress,omitempty"`
+	SecurityGroups           []interface{} `json:"GroupSet,omitempty"`
+}
+
+type cloudformationInstance struct {
+	ImageID             interface{}                               `json:"ImageId,omitempty"`
+	InstanceType        interface{}                               `json:"InstanceType,omitempty"`
+	KeyName             interface{}                               `json:"KeyName,omitempty"`
+	NetworkInterfaces   []*cloudformationInstanceNetworkInterface `json:"NetworkInterfaces,omitempty"`
+	IAMInstanceProfile  interface{}                               `json:"IamInstanceProfile,omitempty"`
+	UserData            *string                                   `json:"UserData,omitempty"`
+	BlockDeviceMappings []*cloudformationBlockDevice              `json:"BlockDeviceMappings,omitempty"`
+}
+
+func (_ *Instance) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*Instance)
+
+	imageID, instanceType, err := e.buildCloudformationParameters(t, e.Key())
+	if err != nil {
+		return err
+	}
+
+	cf := &cloudformationInstance{
+		ImageID:             imageID,
+		InstanceType:        instanceType,
+		BlockDeviceMappings: e.buildCloudformationBlockDevices(),
+	}
+	ni := &cloudformationInstanceNetworkInterface{
+		DeviceIndex:              units.String("0"),
+		AssociatePublicIPAddress: e.AssociatePublicIP,
+		PrivateIPAddress:         e.PrivateIPAddress,
+		SecurityGroups:           e.buildCloudformationSecurityGroups(),
+	}
+	if e.Subnet != nil {
+		ni.SubnetID = e.Subnet.CloudformationLink()
+	}
+	cf.NetworkInterfaces = []*cloudformationInstanceNetworkInterface{ni}
+	if e.SSHKey != nil {
+		cf.KeyName = e.SSHKey.CloudformationLink()
+	}
+	if e.IAMInstanceProfile != nil {
+		cf.IAMInstanceProfile = e.IAMInstanceProfile.CloudformationLink()
+	}
+	if e.UserData != nil {
+		cf.UserData, err = buildCloudformationUserData(e.UserData)
+		if err != nil {
+			return err
+		}
+	}
+
+	t.AddTags("AWS::EC2::Instance", e.Key(), e.buildTags(t.Cloud))
+	return t.RenderResource("AWS::EC2::Instance", e.Key(), cf)
+}
+
+func (e *Instance) CloudformationLink() interface{} {
+	return fi.CloudFormationRef("AWS::EC2::Instance", e.Key())
+}
+
 /*
 func (i *Instance) Destroy(cloud *AWSCloud, output *BashTarget) error {
 	existing, err := i.findExisting(cloud)
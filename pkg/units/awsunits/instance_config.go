@@ -1,12 +1,14 @@
 package awsunits
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"strings"
 
+	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
-	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/kopeio/kope/pkg/fi"
 )
 
@@ -22,7 +24,7 @@ func BlockDeviceMappingFromEC2(i *ec2.BlockDeviceMapping) *BlockDeviceMapping {
 	return o
 }
 
-func (i*BlockDeviceMapping) ToEC2() *ec2.BlockDeviceMapping {
+func (i *BlockDeviceMapping) ToEC2() *ec2.BlockDeviceMapping {
 	o := &ec2.BlockDeviceMapping{}
 	o.DeviceName = i.DeviceName
 	o.VirtualName = i.VirtualName
@@ -36,7 +38,7 @@ func BlockDeviceMappingFromAutoscaling(i *autoscaling.BlockDeviceMapping) *Block
 	return o
 }
 
-func (i*BlockDeviceMapping) ToAutoscaling() *autoscaling.BlockDeviceMapping {
+func (i *BlockDeviceMapping) ToAutoscaling() *autoscaling.BlockDeviceMapping {
 	o := &autoscaling.BlockDeviceMapping{}
 	o.DeviceName = i.DeviceName
 	o.VirtualName = i.VirtualName
@@ -80,13 +82,13 @@ func (i *InstanceCommonConfig) buildCommonCreateArgs(output *fi.BashTarget) []st
 		bdm = strings.Replace(bdm, "\"NoDevice\":null,", "", -1)
 		bdm = strings.Replace(bdm, "\"VirtualName\":null,", "", -1)
 
-		args = append(args, "--block-device-mappings", fi.BashQuoteString(bdm))
+		args = append(args, "--block-device-mappings", bdm)
 	}
 
 	return args
 }
 
-func (i *InstanceCommonConfig) buildEC2CreateArgs(output *fi.BashTarget) []string {
+func (i *InstanceCommonConfig) buildEC2CreateArgs(output *fi.BashTarget) ([]string, error) {
 	args := i.buildCommonCreateArgs(output)
 	if i.SecurityGroups != nil {
 		ids := ""
@@ -94,17 +96,21 @@ func (i *InstanceCommonConfig) buildEC2CreateArgs(output *fi.BashTarget) []strin
 			if ids != "" {
 				ids = ids + ","
 			}
-			ids = ids + output.ReadVar(sg)
+			id, err := output.ReadVar(sg)
+			if err != nil {
+				return nil, err
+			}
+			ids = ids + id
 		}
 		args = append(args, "--security-group-ids", ids)
 	}
 	if i.IAMInstanceProfile != nil {
-		args = append(args, "--iam-instance-profile", "Name=" + *i.IAMInstanceProfile.Name)
+		args = append(args, "--iam-instance-profile", "Name="+*i.IAMInstanceProfile.Name)
 	}
-	return args
+	return args, nil
 }
 
-func (i *InstanceCommonConfig) buildAutoscalingCreateArgs(output *fi.BashTarget) []string {
+func (i *InstanceCommonConfig) buildAutoscalingCreateArgs(output *fi.BashTarget) ([]string, error) {
 	args := i.buildCommonCreateArgs(output)
 	if i.SecurityGroups != nil {
 		ids := ""
@@ -112,12 +118,111 @@ func (i *InstanceCommonConfig) buildAutoscalingCreateArgs(output *fi.BashTarget)
 			if ids != "" {
 				ids = ids + ","
 			}
-			ids = ids + output.ReadVar(sg)
+			id, err := output.ReadVar(sg)
+			if err != nil {
+				return nil, err
+			}
+			ids = ids + id
 		}
 		args = append(args, "--security-groups", ids)
 	}
 	if i.IAMInstanceProfile != nil {
 		args = append(args, "--iam-instance-profile", *i.IAMInstanceProfile.Name)
 	}
-	return args
+	return args, nil
+}
+
+type terraformBlockDevice struct {
+	DeviceName  *string `json:"device_name,omitempty"`
+	VirtualName *string `json:"virtual_name,omitempty"`
+}
+
+func (i *InstanceCommonConfig) buildTerraformEphemeralBlockDevices() []*terraformBlockDevice {
+	var devices []*terraformBlockDevice
+	for _, b := range i.BlockDeviceMappings {
+		devices = append(devices, &terraformBlockDevice{DeviceName: b.DeviceName, VirtualName: b.VirtualName})
+	}
+	return devices
+}
+
+func (i *InstanceCommonConfig) buildTerraformSecurityGroups() []*string {
+	var ids []*string
+	for _, sg := range i.SecurityGroups {
+		ids = append(ids, sg.TerraformLink())
+	}
+	return ids
+}
+
+// buildTerraformUserData stores the user-data alongside the configuration, returning an expression for user_data_base64.
+// We always use the base64 form, because large user-data is gzipped.
+func buildTerraformUserData(t *fi.TerraformTarget, resourceType, key string, userData fi.Resource) (*string, error) {
+	d, err := fi.ResourceAsBytes(userData)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering UserData: %v", err)
+	}
+	if len(d) > MaxUserDataSize {
+		d, err = fi.GzipBytes(d)
+		if err != nil {
+			return nil, fmt.Errorf("error while gzipping UserData: %v", err)
+		}
+	}
+	encoded := base64.StdEncoding.EncodeToString(d)
+	expr, err := t.AddFile(resourceType, key, "user_data", fi.NewStringResource(encoded))
+	if err != nil {
+		return nil, err
+	}
+	return &expr, nil
+}
+
+type cloudformationBlockDevice struct {
+	DeviceName  *string `json:"DeviceName,omitempty"`
+	VirtualName *string `json:"VirtualName,omitempty"`
+}
+
+func (i *InstanceCommonConfig) buildCloudformationBlockDevices() []*cloudformationBlockDevice {
+	var devices []*cloudformationBlockDevice
+	for _, b := range i.BlockDeviceMappings {
+		devices = append(devices, &cloudformationBlockDevice{DeviceName: b.DeviceName, VirtualName: b.VirtualName})
+	}
+	return devices
+}
+
+func (i *InstanceCommonConfig) buildCloudformationSecurityGroups() []interface{} {
+	var ids []interface{}
+	for _, sg := range i.SecurityGroups {
+		ids = append(ids, sg.CloudformationLink())
+	}
+	return ids
+}
+
+// buildCloudformationParameters exposes the image & instance type as stack parameters, so they can be changed on the stack
+func (i *InstanceCommonConfig) buildCloudformationParameters(t *fi.CloudFormationTarget, key string) (imageID interface{}, instanceType interface{}, err error) {
+	if i.ImageID != nil {
+		imageID, err = t.AddParameter("ImageId", *i.ImageID, "AMI for instances")
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if i.InstanceType != nil {
+		instanceType, err = t.AddParameter(fi.CloudFormationLogicalID("InstanceType", key), *i.InstanceType, "Instance type for "+key)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return imageID, instanceType, nil
+}
+
+func buildCloudformationUserData(userData fi.Resource) (*string, error) {
+	d, err := fi.ResourceAsBytes(userData)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering UserData: %v", err)
+	}
+	if len(d) > MaxUserDataSize {
+		d, err = fi.GzipBytes(d)
+		if err != nil {
+			return nil, fmt.Errorf("error while gzipping UserData: %v", err)
+		}
+	}
+	encoded := base64.StdEncoding.EncodeToString(d)
+	return &encoded, nil
 }
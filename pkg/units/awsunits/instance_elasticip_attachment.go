@@ -6,6 +6,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type InstanceElasticIPAttachment struct {
@@ -20,6 +21,10 @@ func (s *InstanceElasticIPAttachment) Key() string {
 }
 
 func (e *InstanceElasticIPAttachment) find(c *fi.RunContext) (*InstanceElasticIPAttachment, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	instanceID := e.Instance.ID
@@ -48,9 +53,9 @@ func (e *InstanceElasticIPAttachment) find(c *fi.RunContext) (*InstanceElasticIP
 	a := response.Addresses[0]
 	actual := &InstanceElasticIPAttachment{}
 	if a.InstanceId != nil {
-		actual.Instance = &Instance{ID: a.InstanceId }
+		actual.Instance = &Instance{ID: a.InstanceId}
 	}
-	actual.ElasticIP = &ElasticIP{ID: a.AllocationId }
+	actual.ElasticIP = &ElasticIP{ID: a.AllocationId}
 	return actual, nil
 }
 
@@ -61,7 +66,7 @@ func (e *InstanceElasticIPAttachment) Run(c *fi.RunContext) error {
 	}
 
 	changes := &InstanceElasticIPAttachment{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -78,7 +83,9 @@ func (s *InstanceElasticIPAttachment) checkChanges(a, e, changes *InstanceElasti
 	return nil
 }
 
-func (_*InstanceElasticIPAttachment) RenderAWS(t *fi.AWSAPITarget, a, e, changes *InstanceElasticIPAttachment) error {
+func (_ *InstanceElasticIPAttachment) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e, changes := ua.(*InstanceElasticIPAttachment), ue.(*InstanceElasticIPAttachment), uchanges.(*InstanceElasticIPAttachment)
+
 	if changes.Instance != nil {
 		err := t.WaitForInstanceRunning(*e.Instance.ID)
 		if err != nil {
@@ -89,27 +96,79 @@ func (_*InstanceElasticIPAttachment) RenderAWS(t *fi.AWSAPITarget, a, e, changes
 		request.InstanceId = e.Instance.ID
 		request.AllocationId = a.ElasticIP.ID
 
-		_, err = t.Cloud.EC2.AssociateAddress(request)
+		var response *ec2.AssociateAddressOutput
+		err = t.Cloud.RetryOnError("associating ElasticIP", func() error {
+			var err error
+			response, err = t.Cloud.EC2.AssociateAddress(request)
+			return err
+		})
 		if err != nil {
 			return fmt.Errorf("error creating InstanceElasticIPAttachment: %v", err)
 		}
+		if response.AssociationId != nil {
+			if err := t.RecordCreation(fi.JournalTypeElasticIPAssociation, *response.AssociationId); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil // no tags
 }
 
-func (_*InstanceElasticIPAttachment) RenderBash(t *fi.BashTarget, a, e, changes *InstanceElasticIPAttachment) error {
-	//t.CreateVar(e)
+func (_ *InstanceElasticIPAttachment) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*InstanceElasticIPAttachment), ue.(*InstanceElasticIPAttachment)
+
+	t.CreateVar(e)
 	if a == nil {
-		t.WaitForInstanceRunning(e.Instance)
+		err := t.WaitForInstanceRunning(e.Instance)
+		if err != nil {
+			return err
+		}
 
-		instanceID := t.ReadVar(e.Instance)
-		allocationID := t.ReadVar(e.ElasticIP)
+		instanceID, err := t.ReadVar(e.Instance)
+		if err != nil {
+			return err
+		}
+		allocationID, err := t.ReadVar(e.ElasticIP)
+		if err != nil {
+			return err
+		}
 
-		t.AddEC2Command("associate-address", "--allocation-id", allocationID, "--instance-id", instanceID)
+		t.AddEC2Command("describe-addresses", "--allocation-ids", allocationID, "--query", "Addresses[0].AssociationId").AssignTo(e)
+		t.AddEC2Command("associate-address", "--allocation-id", allocationID, "--instance-id", instanceID).IfMissing(e)
 	} else {
-		//t.AddAssignment(e, StringValue(a.ID))
+		//t.AddAssignment(e, units.StringValue(a.ID))
 	}
 
 	return nil // no tags
 }
+
+type terraformInstanceElasticIPAttachment struct {
+	InstanceID   *string `json:"instance_id,omitempty"`
+	AllocationID *string `json:"allocation_id,omitempty"`
+}
+
+func (_ *InstanceElasticIPAttachment) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*InstanceElasticIPAttachment)
+
+	tf := &terraformInstanceElasticIPAttachment{
+		InstanceID:   e.Instance.TerraformLink(),
+		AllocationID: e.ElasticIP.TerraformLink(),
+	}
+	return t.RenderResource("aws_eip_association", e.Key(), tf)
+}
+
+type cloudformationInstanceElasticIPAttachment struct {
+	InstanceID   interface{} `json:"InstanceId,omitempty"`
+	AllocationID interface{} `json:"AllocationId,omitempty"`
+}
+
+func (_ *InstanceElasticIPAttachment) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*InstanceElasticIPAttachment)
+
+	cf := &cloudformationInstanceElasticIPAttachment{
+		InstanceID:   e.Instance.CloudformationLink(),
+		AllocationID: e.ElasticIP.CloudformationAllocationID(),
+	}
+	return t.RenderResource("AWS::EC2::EIPAssociation", e.Key(), cf)
+}
@@ -3,10 +3,11 @@ package awsunits
 import (
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type InstanceVolumeAttachment struct {
@@ -22,6 +23,10 @@ func (s *InstanceVolumeAttachment) Key() string {
 }
 
 func (e *InstanceVolumeAttachment) find(c *fi.RunContext) (*InstanceVolumeAttachment, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	instanceID := e.Instance.ID
@@ -45,8 +50,8 @@ func (e *InstanceVolumeAttachment) find(c *fi.RunContext) (*InstanceVolumeAttach
 		}
 
 		actual := &InstanceVolumeAttachment{}
-		actual.Instance = &Instance{ID: e.Instance.ID }
-		actual.Volume = &PersistentVolume{ID: e.Volume.ID }
+		actual.Instance = &Instance{ID: e.Instance.ID}
+		actual.Volume = &PersistentVolume{ID: e.Volume.ID}
 		actual.Device = bdm.DeviceName
 		glog.V(2).Infof("found matching InstanceVolumeAttachment %q", *actual.Device)
 		return actual, nil
@@ -62,7 +67,7 @@ func (e *InstanceVolumeAttachment) Run(c *fi.RunContext) error {
 	}
 
 	changes := &InstanceVolumeAttachment{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -79,19 +84,21 @@ func (s *InstanceVolumeAttachment) checkChanges(a, e, changes *InstanceVolumeAtt
 	if a != nil {
 		if changes.Device != nil {
 			// TODO: Support this?
-			return InvalidChangeError("Cannot change InstanceVolumeAttachment Device", changes.Device, e.Device)
+			return units.InvalidChangeError("Cannot change InstanceVolumeAttachment Device", changes.Device, e.Device)
 		}
 	}
 
 	if a == nil {
 		if e.Device == nil {
-			return MissingValueError("Must specify Device for InstanceVolumeAttachment create")
+			return units.MissingValueError("Must specify Device for InstanceVolumeAttachment create")
 		}
 	}
 	return nil
 }
 
-func (_*InstanceVolumeAttachment) RenderAWS(t *fi.AWSAPITarget,a, e, changes *InstanceVolumeAttachment) error {
+func (_ *InstanceVolumeAttachment) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*InstanceVolumeAttachment), ue.(*InstanceVolumeAttachment)
+
 	if a == nil {
 		err := t.WaitForInstanceRunning(*e.Instance.ID)
 		if err != nil {
@@ -103,27 +110,79 @@ func (_*InstanceVolumeAttachment) RenderAWS(t *fi.AWSAPITarget,a, e, changes *In
 		request.VolumeId = e.Volume.ID
 		request.Device = e.Device
 
-		_, err = t.Cloud.EC2.AttachVolume(request)
+		err = t.Cloud.RetryOnError("attaching Volume", func() error {
+			_, err := t.Cloud.EC2.AttachVolume(request)
+			return err
+		})
 		if err != nil {
 			return fmt.Errorf("error creating InstanceVolumeAttachment: %v", err)
 		}
+		if err := t.RecordCreation(fi.JournalTypeVolumeAttachment, *e.Volume.ID+"/"+*e.Instance.ID); err != nil {
+			return err
+		}
 	}
 
 	return nil // no tags
 }
 
-func (_*InstanceVolumeAttachment) RenderBash(t *fi.BashTarget, a, e, changes *InstanceVolumeAttachment) error {
-	//t.CreateVar(e)
+func (_ *InstanceVolumeAttachment) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*InstanceVolumeAttachment), ue.(*InstanceVolumeAttachment)
+
+	t.CreateVar(e)
 	if a == nil {
-		t.WaitForInstanceRunning(e.Instance)
+		err := t.WaitForInstanceRunning(e.Instance)
+		if err != nil {
+			return err
+		}
 
-		instanceID := t.ReadVar(e.Instance)
-		volumeID := t.ReadVar(e.Volume)
+		instanceID, err := t.ReadVar(e.Instance)
+		if err != nil {
+			return err
+		}
+		volumeID, err := t.ReadVar(e.Volume)
+		if err != nil {
+			return err
+		}
 
-		t.AddEC2Command("attach-volume", "--volume-id", volumeID, "--instance-id", instanceID, "--device", *e.Device)
+		t.AddEC2Command("describe-volumes", "--volume-ids", volumeID, "--query", "Volumes[0].Attachments[0].InstanceId").AssignTo(e)
+		t.AddEC2Command("attach-volume", "--volume-id", volumeID, "--instance-id", instanceID, "--device", *e.Device).IfMissing(e)
 	} else {
-		//t.AddAssignment(e, StringValue(a.ID))
+		//t.AddAssignment(e, units.StringValue(a.ID))
 	}
 
 	return nil // no tags
 }
+
+type terraformInstanceVolumeAttachment struct {
+	DeviceName *string `json:"device_name,omitempty"`
+	VolumeID   *string `json:"volume_id,omitempty"`
+	InstanceID *string `json:"instance_id,omitempty"`
+}
+
+func (_ *InstanceVolumeAttachment) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*InstanceVolumeAttachment)
+
+	tf := &terraformInstanceVolumeAttachment{
+		DeviceName: e.Device,
+		VolumeID:   e.Volume.TerraformLink(),
+		InstanceID: e.Instance.TerraformLink(),
+	}
+	return t.RenderResource("aws_volume_attachment", e.Key(), tf)
+}
+
+type cloudformationInstanceVolumeAttachment struct {
+	Device     *string     `json:"Device,omitempty"`
+	VolumeID   interface{} `json:"VolumeId,omitempty"`
+	InstanceID interface{} `json:"InstanceId,omitempty"`
+}
+
+func (_ *InstanceVolumeAttachment) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*InstanceVolumeAttachment)
+
+	cf := &cloudformationInstanceVolumeAttachment{
+		Device:     e.Device,
+		VolumeID:   e.Volume.CloudformationLink(),
+		InstanceID: e.Instance.CloudformationLink(),
+	}
+	return t.RenderResource("AWS::EC2::VolumeAttachment", e.Key(), cf)
+}
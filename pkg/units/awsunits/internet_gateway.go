@@ -3,10 +3,11 @@ package awsunits
 import (
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
-"github.com/aws/aws-sdk-go/aws"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type InternetGateway struct {
@@ -25,6 +26,10 @@ func (s *InternetGateway) GetID() *string {
 }
 
 func (e *InternetGateway) find(c *fi.RunContext) (*InternetGateway, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	request := &ec2.DescribeInternetGatewaysInput{}
@@ -66,7 +71,7 @@ func (e *InternetGateway) Run(c *fi.RunContext) error {
 	}
 
 	changes := &InternetGateway{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -85,12 +90,13 @@ func (s *InternetGateway) checkChanges(a, e, changes *InternetGateway) error {
 	return nil
 }
 
-func (_*InternetGateway) RenderAWS(t *fi.AWSAPITarget, a, e, changes *InternetGateway) error {
+func (_ *InternetGateway) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*InternetGateway), ue.(*InternetGateway)
+
 	if a == nil {
 		glog.V(2).Infof("Creating InternetGateway")
 
-		request := &ec2.CreateInternetGatewayInput{
-		}
+		request := &ec2.CreateInternetGatewayInput{}
 
 		response, err := t.Cloud.EC2.CreateInternetGateway(request)
 		if err != nil {
@@ -99,22 +105,33 @@ func (_*InternetGateway) RenderAWS(t *fi.AWSAPITarget, a, e, changes *InternetGa
 
 		igw := response.InternetGateway
 		e.ID = igw.InternetGatewayId
+		if err := t.RecordCreation(fi.JournalTypeInternetGateway, *e.ID); err != nil {
+			return err
+		}
 	}
 
 	return t.AddAWSTags(*e.ID, t.Cloud.BuildTags(e.Name))
 }
 
-func (_*InternetGateway) RenderBash(t *fi.BashTarget, a, e, changes *InternetGateway) error {
+func (_ *InternetGateway) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*InternetGateway), ue.(*InternetGateway)
+
 	t.CreateVar(e)
 	if a == nil {
-		t.AddEC2Command("create-internet-gateway", "--query", "InternetGateway.InternetGatewayId").AssignTo(e)
+		e.AddBashLookup(t)
+		t.AddEC2Command("create-internet-gateway", "--query", "InternetGateway.InternetGatewayId").AssignTo(e).IfMissing(e)
 	} else {
-		t.AddAssignment(e, StringValue(a.ID))
+		t.AddAssignment(e, units.StringValue(a.ID))
 	}
 
 	return t.AddAWSTags(e, t.Cloud.BuildTags(e.Name))
 }
 
+// AddBashLookup finds an existing InternetGateway by its tags
+func (e *InternetGateway) AddBashLookup(t *fi.BashTarget) *fi.BashCommand {
+	return t.AddEC2TagLookup(e, e.Name, "describe-internet-gateways", "InternetGateways[0].InternetGatewayId")
+}
+
 func findNameTag(tags []*ec2.Tag) *string {
 	for _, tag := range tags {
 		if aws.StringValue(tag.Key) == "Name" {
@@ -122,4 +139,62 @@ func findNameTag(tags []*ec2.Tag) *string {
 		}
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+type terraformInternetGateway struct {
+	VPCID *string           `json:"vpc_id,omitempty"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+func (_ *InternetGateway) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*InternetGateway)
+
+	if e.ID != nil {
+		// Existing gateway; not managed by terraform
+		return nil
+	}
+
+	tf := &terraformInternetGateway{
+		Tags: t.Cloud.BuildTags(e.Name),
+	}
+	return t.RenderResource("aws_internet_gateway", e.Key(), tf)
+}
+
+func (e *InternetGateway) TerraformLink() *string {
+	if e.ID != nil {
+		return e.ID
+	}
+	return units.String(fi.TerraformReference("aws_internet_gateway", e.Key(), "id"))
+}
+
+type cloudformationInternetGateway struct {
+}
+
+func (_ *InternetGateway) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*InternetGateway)
+
+	if e.ID != nil {
+		// Existing gateway; not managed by the stack
+		return nil
+	}
+
+	cf := &cloudformationInternetGateway{}
+	t.AddTags("AWS::EC2::InternetGateway", e.Key(), t.Cloud.BuildTags(e.Name))
+	return t.RenderResource("AWS::EC2::InternetGateway", e.Key(), cf)
+}
+
+func (e *InternetGateway) CloudformationLink() interface{} {
+	if e.ID != nil {
+		return *e.ID
+	}
+	return fi.CloudFormationRef("AWS::EC2::InternetGateway", e.Key())
+}
+
+// CloudformationAttachmentID returns the logical ID of the attachment of a gateway managed by the stack,
+// so that routes can depend on it
+func (e *InternetGateway) CloudformationAttachmentID() string {
+	if e.ID != nil {
+		return ""
+	}
+	return fi.CloudFormationLogicalID("AWS::EC2::VPCGatewayAttachment", e.Key())
+}
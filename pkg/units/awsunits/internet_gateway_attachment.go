@@ -3,10 +3,11 @@ package awsunits
 import (
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type InternetGatewayAttachment struct {
@@ -21,6 +22,10 @@ func (s *InternetGatewayAttachment) Key() string {
 }
 
 func (e *InternetGatewayAttachment) find(c *fi.RunContext) (*InternetGatewayAttachment, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	vpcID := e.VPC.ID
 	if vpcID == nil {
 		return nil, nil
@@ -45,7 +50,7 @@ func (e *InternetGatewayAttachment) find(c *fi.RunContext) (*InternetGatewayAtta
 
 	if len(response.InternetGateways) != 1 {
 		for _, ig := range response.InternetGateways {
-			glog.Infof("gateway: %v", DebugPrint(ig))
+			glog.Infof("gateway: %v", units.DebugPrint(ig))
 		}
 		glog.Fatalf("found multiple InternetGatewayAttachments matching ID")
 	}
@@ -53,8 +58,8 @@ func (e *InternetGatewayAttachment) find(c *fi.RunContext) (*InternetGatewayAtta
 	for _, attachment := range igw.Attachments {
 		if aws.StringValue(attachment.VpcId) == *vpcID {
 			actual := &InternetGatewayAttachment{
-				VPC: &VPC{ID:vpcID},
-				InternetGateway: &InternetGateway{ID:e.InternetGateway.ID},
+				VPC:             &VPC{ID: vpcID},
+				InternetGateway: &InternetGateway{ID: e.InternetGateway.ID},
 			}
 			glog.V(2).Infof("found matching InternetGatewayAttachment")
 			return actual, nil
@@ -71,7 +76,7 @@ func (e *InternetGatewayAttachment) Run(c *fi.RunContext) error {
 	}
 
 	changes := &InternetGatewayAttachment{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -88,40 +93,93 @@ func (s *InternetGatewayAttachment) checkChanges(a, e, changes *InternetGatewayA
 	if a != nil {
 		// TODO: I think we can change it; we just detach & attach
 		if changes.VPC != nil {
-			return InvalidChangeError("Cannot change InternetGatewayAttachment VPC", changes.VPC.ID, e.VPC.ID)
+			return units.InvalidChangeError("Cannot change InternetGatewayAttachment VPC", changes.VPC.ID, e.VPC.ID)
 		}
 	}
 	return nil
 }
 
-func (_*InternetGatewayAttachment) RenderAWS(t *fi.AWSAPITarget, a, e, changes *InternetGatewayAttachment) error {
+func (_ *InternetGatewayAttachment) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*InternetGatewayAttachment), ue.(*InternetGatewayAttachment)
+
 	if a == nil {
 		glog.V(2).Infof("Creating InternetGatewayAttachment")
 
 		attachRequest := &ec2.AttachInternetGatewayInput{
-			VpcId: e.VPC.ID,
+			VpcId:             e.VPC.ID,
 			InternetGatewayId: e.InternetGateway.ID,
 		}
 
-		_, err := t.Cloud.EC2.AttachInternetGateway(attachRequest)
+		err := t.Cloud.RetryOnError("attaching InternetGateway", func() error {
+			_, err := t.Cloud.EC2.AttachInternetGateway(attachRequest)
+			return err
+		})
 		if err != nil {
 			return fmt.Errorf("error attaching InternetGatewayAttachment: %v", err)
 		}
+		if err := t.RecordCreation(fi.JournalTypeInternetGatewayAttach, *e.InternetGateway.ID+"/"+*e.VPC.ID); err != nil {
+			return err
+		}
 	}
 
 	return nil // No tags
 }
 
-func (_*InternetGatewayAttachment) RenderBash(t *fi.BashTarget, a, e, changes *InternetGatewayAttachment) error {
-	//t.CreateVar(e)
+func (_ *InternetGatewayAttachment) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*InternetGatewayAttachment), ue.(*InternetGatewayAttachment)
+
+	t.CreateVar(e)
 	if a == nil {
-		vpcID := t.ReadVar(e.VPC)
-		igwID := t.ReadVar(e.InternetGateway)
+		vpcID, err := t.ReadVar(e.VPC)
+		if err != nil {
+			return err
+		}
+		igwID, err := t.ReadVar(e.InternetGateway)
+		if err != nil {
+			return err
+		}
 
-		t.AddEC2Command("attach-internet-gateway", "--internet-gateway-id", igwID, "--vpc-id", vpcID)
+		t.AddEC2Command("describe-internet-gateways", "--internet-gateway-ids", igwID, "--query", "InternetGateways[0].Attachments[0].VpcId").AssignTo(e)
+		t.AddEC2Command("attach-internet-gateway", "--internet-gateway-id", igwID, "--vpc-id", vpcID).IfMissing(e)
 	} else {
-		//t.AddAssignment(e, StringValue(a.ID))
+		//t.AddAssignment(e, units.StringValue(a.ID))
 	}
 
 	return nil // No tags
 }
+
+func (_ *InternetGatewayAttachment) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*InternetGatewayAttachment)
+
+	if e.InternetGateway.ID != nil {
+		// Existing gateway; we assume it is already attached
+		return nil
+	}
+
+	// Terraform models the attachment as part of the gateway
+	tf := &terraformInternetGateway{
+		VPCID: e.VPC.TerraformLink(),
+	}
+	return t.RenderResource("aws_internet_gateway", e.InternetGateway.Key(), tf)
+}
+
+type cloudformationInternetGatewayAttachment struct {
+	VPCID             interface{} `json:"VpcId,omitempty"`
+	InternetGatewayID interface{} `json:"InternetGatewayId,omitempty"`
+}
+
+func (_ *InternetGatewayAttachment) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*InternetGatewayAttachment)
+
+	if e.InternetGateway.ID != nil {
+		// Existing gateway; we assume it is already attached
+		return nil
+	}
+
+	cf := &cloudformationInternetGatewayAttachment{
+		VPCID:             e.VPC.CloudformationLink(),
+		InternetGatewayID: e.InternetGateway.CloudformationLink(),
+	}
+	// Keyed by the gateway, so that routes can depend on the attachment (see CloudformationAttachmentID)
+	return t.RenderResource("AWS::EC2::VPCGatewayAttachment", e.InternetGateway.Key(), cf)
+}
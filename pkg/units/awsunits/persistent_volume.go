@@ -7,6 +7,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type PersistentVolume struct {
@@ -28,10 +29,14 @@ func (s *PersistentVolume) Key() string {
 }
 
 func (s *PersistentVolume) String() string {
-	return JsonString(s)
+	return units.JsonString(s)
 }
 
 func (e *PersistentVolume) find(c *fi.RunContext) (*PersistentVolume, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	filters := cloud.BuildFilters(e.Name)
@@ -75,7 +80,7 @@ func (e *PersistentVolume) Run(c *fi.RunContext) error {
 	}
 
 	changes := &PersistentVolume{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -91,18 +96,20 @@ func (e *PersistentVolume) Run(c *fi.RunContext) error {
 func (s *PersistentVolume) checkChanges(a, e, changes *PersistentVolume) error {
 	if a == nil {
 		if e.Name == nil {
-			return MissingValueError("Name must be specified when creating a PersistentVolume")
+			return units.MissingValueError("Name must be specified when creating a PersistentVolume")
 		}
 	}
 	if a != nil {
 		if changes.ID != nil {
-			return InvalidChangeError("Cannot change PersistentVolume ID", changes.ID, e.ID)
+			return units.InvalidChangeError("Cannot change PersistentVolume ID", changes.ID, e.ID)
 		}
 	}
 	return nil
 }
 
-func (_*PersistentVolume) RenderAWS(t *fi.AWSAPITarget, a, e, changes *PersistentVolume) error {
+func (_ *PersistentVolume) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*PersistentVolume), ue.(*PersistentVolume)
+
 	if a == nil {
 		glog.V(2).Infof("Creating PersistentVolume with Name:%q", *e.Name)
 
@@ -117,24 +124,80 @@ func (_*PersistentVolume) RenderAWS(t *fi.AWSAPITarget, a, e, changes *Persisten
 		}
 
 		e.ID = response.VolumeId
+		if err := t.RecordCreation(fi.JournalTypeVolume, *e.ID); err != nil {
+			return err
+		}
 	}
 
 	return t.AddAWSTags(*e.ID, t.Cloud.BuildTags(e.Name))
 }
 
-func (_*PersistentVolume) RenderBash(t *fi.BashTarget, a, e, changes *PersistentVolume) error {
+func (_ *PersistentVolume) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*PersistentVolume), ue.(*PersistentVolume)
+
 	t.CreateVar(e)
 	if a == nil {
 		glog.V(2).Infof("Creating PersistentVolume with Name:%q", *e.Name)
 
+		e.AddBashLookup(t)
 		t.AddEC2Command("create-volume",
 			"--availability-zone", *e.AvailabilityZone,
 			"--volume-type", *e.VolumeType,
 			"--size", strconv.FormatInt(*e.Size, 10),
-			"--query", "VolumeId").AssignTo(e)
+			"--query", "VolumeId").AssignTo(e).IfMissing(e)
 	} else {
-		t.AddAssignment(e, StringValue(a.ID))
+		t.AddAssignment(e, units.StringValue(a.ID))
 	}
 
 	return t.AddAWSTags(e, t.Cloud.BuildTags(e.Name))
 }
+
+// AddBashLookup finds an existing PersistentVolume by its tags
+func (e *PersistentVolume) AddBashLookup(t *fi.BashTarget) *fi.BashCommand {
+	return t.AddEC2TagLookup(e, e.Name, "describe-volumes", "Volumes[0].VolumeId")
+}
+
+type terraformPersistentVolume struct {
+	AvailabilityZone *string           `json:"availability_zone,omitempty"`
+	Size             *int64            `json:"size,omitempty"`
+	Type             *string           `json:"type,omitempty"`
+	Tags             map[string]string `json:"tags,omitempty"`
+}
+
+func (_ *PersistentVolume) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*PersistentVolume)
+
+	tf := &terraformPersistentVolume{
+		AvailabilityZone: e.AvailabilityZone,
+		Size:             e.Size,
+		Type:             e.VolumeType,
+		Tags:             t.Cloud.BuildTags(e.Name),
+	}
+	return t.RenderResource("aws_ebs_volume", e.Key(), tf)
+}
+
+func (e *PersistentVolume) TerraformLink() *string {
+	return units.String(fi.TerraformReference("aws_ebs_volume", e.Key(), "id"))
+}
+
+type cloudformationPersistentVolume struct {
+	AvailabilityZone *string `json:"AvailabilityZone,omitempty"`
+	Size             *int64  `json:"Size,omitempty"`
+	Type             *string `json:"VolumeType,omitempty"`
+}
+
+func (_ *PersistentVolume) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*PersistentVolume)
+
+	cf := &cloudformationPersistentVolume{
+		AvailabilityZone: e.AvailabilityZone,
+		Size:             e.Size,
+		Type:             e.VolumeType,
+	}
+	t.AddTags("AWS::EC2::Volume", e.Key(), t.Cloud.BuildTags(e.Name))
+	return t.RenderResource("AWS::EC2::Volume", e.Key(), cf)
+}
+
+func (e *PersistentVolume) CloudformationLink() interface{} {
+	return fi.CloudFormationRef("AWS::EC2::Volume", e.Key())
+}
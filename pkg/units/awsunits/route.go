@@ -7,6 +7,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type Route struct {
@@ -22,6 +23,10 @@ func (r *Route) Key() string {
 }
 
 func (e *Route) find(c *fi.RunContext) (*Route, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	var routeTableID *string
@@ -73,7 +78,7 @@ func (e *Route) Run(c *fi.RunContext) error {
 	}
 
 	changes := &Route{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -89,20 +94,22 @@ func (e *Route) Run(c *fi.RunContext) error {
 func (s *Route) checkChanges(a, e, changes *Route) error {
 	if a != nil {
 		if changes.RouteTable != nil {
-			return InvalidChangeError("Cannot change Route RouteTable", changes.RouteTable, e.RouteTable)
+			return units.InvalidChangeError("Cannot change Route RouteTable", changes.RouteTable, e.RouteTable)
 		}
 		if changes.CIDR != nil {
-			return InvalidChangeError("Cannot change Route CIDR", changes.CIDR, e.CIDR)
+			return units.InvalidChangeError("Cannot change Route CIDR", changes.CIDR, e.CIDR)
 		}
 	}
 	return nil
 }
 
-func (_*Route) RenderAWS(t *fi.AWSAPITarget, a, e, changes *Route) error {
+func (_ *Route) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*Route), ue.(*Route)
+
 	if a == nil {
 		cidr := e.CIDR
 		if cidr == nil {
-			return MissingValueError("Must specify CIDR for Route create")
+			return units.MissingValueError("Must specify CIDR for Route create")
 		}
 
 		var igwID *string
@@ -110,7 +117,7 @@ func (_*Route) RenderAWS(t *fi.AWSAPITarget, a, e, changes *Route) error {
 			igwID = e.InternetGateway.ID
 		}
 		if igwID == nil {
-			return MissingValueError("Must specify InternetGateway for Route create")
+			return units.MissingValueError("Must specify InternetGateway for Route create")
 		}
 
 		var routeTableID *string
@@ -118,7 +125,7 @@ func (_*Route) RenderAWS(t *fi.AWSAPITarget, a, e, changes *Route) error {
 			routeTableID = e.RouteTable.ID
 		}
 		if routeTableID == nil {
-			return MissingValueError("Must specify RouteTable for Route create")
+			return units.MissingValueError("Must specify RouteTable for Route create")
 		}
 
 		glog.V(2).Infof("Creating Route with RouteTable:%q CIDR:%q", *routeTableID, *cidr)
@@ -141,21 +148,78 @@ func (_*Route) RenderAWS(t *fi.AWSAPITarget, a, e, changes *Route) error {
 	return nil
 }
 
-func (_*Route) RenderBash(t *fi.BashTarget, a, e, changes *Route) error {
-	//t.CreateVar(e)
+func (_ *Route) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*Route), ue.(*Route)
+
+	t.CreateVar(e)
 	if a == nil {
 		cidr := e.CIDR
 		if cidr == nil {
-			return MissingValueError("Must specify CIDR for Route create")
+			return units.MissingValueError("Must specify CIDR for Route create")
 		}
 
+		routeTableID, err := t.ReadVar(e.RouteTable)
+		if err != nil {
+			return err
+		}
+		igwID, err := t.ReadVar(e.InternetGateway)
+		if err != nil {
+			return err
+		}
+
+		t.AddEC2Command("describe-route-tables",
+			"--route-table-ids", routeTableID,
+			"--query", fmt.Sprintf("RouteTables[0].Routes[?DestinationCidrBlock=='%s'].DestinationCidrBlock", *cidr)).AssignTo(e)
 		t.AddEC2Command("create-route",
-			"--route-table-id", t.ReadVar(e.RouteTable),
+			"--route-table-id", routeTableID,
 			"--destination-cidr-block", *cidr,
-			"--gateway-id", t.ReadVar(e.InternetGateway))
+			"--gateway-id", igwID).IfMissing(e)
 	} else {
-		//t.AddAssignment(e, StringValue(a.ID))
+		//t.AddAssignment(e, units.StringValue(a.ID))
 	}
 
 	return nil
 }
+
+type terraformRoute struct {
+	RouteTableID *string `json:"route_table_id,omitempty"`
+	CIDR         *string `json:"destination_cidr_block,omitempty"`
+	GatewayID    *string `json:"gateway_id,omitempty"`
+}
+
+func (_ *Route) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*Route)
+
+	tf := &terraformRoute{
+		RouteTableID: e.RouteTable.TerraformLink(),
+		CIDR:         e.CIDR,
+	}
+	if e.InternetGateway != nil {
+		tf.GatewayID = e.InternetGateway.TerraformLink()
+	}
+	return t.RenderResource("aws_route", e.Key(), tf)
+}
+
+type cloudformationRoute struct {
+	RouteTableID interface{} `json:"RouteTableId,omitempty"`
+	CIDR         *string     `json:"DestinationCidrBlock,omitempty"`
+	GatewayID    interface{} `json:"GatewayId,omitempty"`
+}
+
+func (_ *Route) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*Route)
+
+	cf := &cloudformationRoute{
+		RouteTableID: e.RouteTable.CloudformationLink(),
+		CIDR:         e.CIDR,
+	}
+	var dependsOn []string
+	if e.InternetGateway != nil {
+		cf.GatewayID = e.InternetGateway.CloudformationLink()
+		// The route can't be created until the gateway is attached to the VPC
+		if id := e.InternetGateway.CloudformationAttachmentID(); id != "" {
+			dependsOn = append(dependsOn, id)
+		}
+	}
+	return t.RenderResource("AWS::EC2::Route", e.Key(), cf, dependsOn...)
+}
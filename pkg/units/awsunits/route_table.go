@@ -6,6 +6,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type RouteTable struct {
@@ -25,6 +26,10 @@ func (s *RouteTable) GetID() *string {
 }
 
 func (e *RouteTable) find(c *fi.RunContext) (*RouteTable, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	request := &ec2.DescribeRouteTablesInput{}
@@ -67,7 +72,7 @@ func (e *RouteTable) Run(c *fi.RunContext) error {
 	}
 
 	changes := &RouteTable{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -83,17 +88,19 @@ func (e *RouteTable) Run(c *fi.RunContext) error {
 func (s *RouteTable) checkChanges(a, e, changes *RouteTable) error {
 	if a != nil {
 		if changes.VPC != nil && changes.VPC.ID != nil {
-			return InvalidChangeError("Cannot change RouteTable VPC", changes.VPC.ID, e.VPC.ID)
+			return units.InvalidChangeError("Cannot change RouteTable VPC", changes.VPC.ID, e.VPC.ID)
 		}
 	}
 	return nil
 }
 
-func (_*RouteTable) RenderAWS(t *fi.AWSAPITarget, a, e, changes *RouteTable) error {
+func (_ *RouteTable) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*RouteTable), ue.(*RouteTable)
+
 	if a == nil {
 		vpcID := e.VPC.ID
 		if vpcID == nil {
-			return MissingValueError("Must specify VPC for RouteTable create")
+			return units.MissingValueError("Must specify VPC for RouteTable create")
 		}
 
 		glog.V(2).Infof("Creating RouteTable with VPC: %q", *vpcID)
@@ -108,22 +115,89 @@ func (_*RouteTable) RenderAWS(t *fi.AWSAPITarget, a, e, changes *RouteTable) err
 
 		rt := response.RouteTable
 		e.ID = rt.RouteTableId
+		if err := t.RecordCreation(fi.JournalTypeRouteTable, *e.ID); err != nil {
+			return err
+		}
 	}
 
 	return t.AddAWSTags(*e.ID, t.Cloud.BuildTags(e.Name))
 }
 
-func (_*RouteTable) RenderBash(t *fi.BashTarget, a, e, changes *RouteTable) error {
+func (_ *RouteTable) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*RouteTable), ue.(*RouteTable)
+
 	t.CreateVar(e)
 	if a == nil {
-		vpcID := t.ReadVar(e.VPC)
+		vpcID, err := t.ReadVar(e.VPC)
+		if err != nil {
+			return err
+		}
 
 		glog.V(2).Infof("Creating RouteTable with VPC: %q", vpcID)
 
-		t.AddEC2Command("create-route-table", "--vpc-id", vpcID, "--query", "RouteTable.RouteTableId").AssignTo(e)
+		e.AddBashLookup(t)
+		t.AddEC2Command("create-route-table", "--vpc-id", vpcID, "--query", "RouteTable.RouteTableId").AssignTo(e).IfMissing(e)
 	} else {
-		t.AddAssignment(e, StringValue(a.ID))
+		t.AddAssignment(e, units.StringValue(a.ID))
 	}
 
 	return t.AddAWSTags(e, t.Cloud.BuildTags(e.Name))
 }
+
+// AddBashLookup finds an existing RouteTable by its tags
+func (e *RouteTable) AddBashLookup(t *fi.BashTarget) *fi.BashCommand {
+	return t.AddEC2TagLookup(e, e.Name, "describe-route-tables", "RouteTables[0].RouteTableId")
+}
+
+type terraformRouteTable struct {
+	VPCID *string           `json:"vpc_id,omitempty"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+func (_ *RouteTable) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*RouteTable)
+
+	if e.ID != nil {
+		// Existing route table; not managed by terraform
+		return nil
+	}
+
+	tf := &terraformRouteTable{
+		VPCID: e.VPC.TerraformLink(),
+		Tags:  t.Cloud.BuildTags(e.Name),
+	}
+	return t.RenderResource("aws_route_table", e.Key(), tf)
+}
+
+func (e *RouteTable) TerraformLink() *string {
+	if e.ID != nil {
+		return e.ID
+	}
+	return units.String(fi.TerraformReference("aws_route_table", e.Key(), "id"))
+}
+
+type cloudformationRouteTable struct {
+	VPCID interface{} `json:"VpcId,omitempty"`
+}
+
+func (_ *RouteTable) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*RouteTable)
+
+	if e.ID != nil {
+		// Existing route table; not managed by the stack
+		return nil
+	}
+
+	cf := &cloudformationRouteTable{
+		VPCID: e.VPC.CloudformationLink(),
+	}
+	t.AddTags("AWS::EC2::RouteTable", e.Key(), t.Cloud.BuildTags(e.Name))
+	return t.RenderResource("AWS::EC2::RouteTable", e.Key(), cf)
+}
+
+func (e *RouteTable) CloudformationLink() interface{} {
+	if e.ID != nil {
+		return *e.ID
+	}
+	return fi.CloudFormationRef("AWS::EC2::RouteTable", e.Key())
+}
@@ -3,10 +3,11 @@ package awsunits
 import (
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type RouteTableAssociation struct {
@@ -30,6 +31,10 @@ func (s *RouteTableAssociation) GetID() *string {
 }
 
 func (e *RouteTableAssociation) find(c *fi.RunContext) (*RouteTableAssociation, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	routeTableID := e.RouteTable.ID
@@ -61,7 +66,7 @@ func (e *RouteTableAssociation) find(c *fi.RunContext) (*RouteTableAssociation,
 		}
 		actual := &RouteTableAssociation{}
 		actual.ID = rta.RouteTableAssociationId
-		actual.RouteTable = &RouteTable{ID: rta.RouteTableId }
+		actual.RouteTable = &RouteTable{ID: rta.RouteTableId}
 		actual.Subnet = &Subnet{ID: rta.SubnetId}
 		glog.V(2).Infof("found matching RouteTableAssociation %q", *actual.ID)
 		return actual, nil
@@ -81,7 +86,7 @@ func (e *RouteTableAssociation) Run(c *fi.RunContext) error {
 	}
 
 	changes := &RouteTableAssociation{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -97,25 +102,27 @@ func (e *RouteTableAssociation) Run(c *fi.RunContext) error {
 func (s *RouteTableAssociation) checkChanges(a, e, changes *RouteTableAssociation) error {
 	if a != nil {
 		if changes.RouteTable != nil {
-			return InvalidChangeError("Cannot change RouteTableAssociation RouteTable", changes.RouteTable.ID, e.RouteTable.ID)
+			return units.InvalidChangeError("Cannot change RouteTableAssociation RouteTable", changes.RouteTable.ID, e.RouteTable.ID)
 		}
 		if changes.Subnet != nil {
-			return InvalidChangeError("Cannot change RouteTableAssociation Subnet", changes.Subnet.ID, e.Subnet.ID)
+			return units.InvalidChangeError("Cannot change RouteTableAssociation Subnet", changes.Subnet.ID, e.Subnet.ID)
 		}
 	}
 	return nil
 }
 
-func (_*RouteTableAssociation) RenderAWS(t *fi.AWSAPITarget, a, e, changes *RouteTableAssociation) error {
+func (_ *RouteTableAssociation) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*RouteTableAssociation), ue.(*RouteTableAssociation)
+
 	if a == nil {
 		subnetID := e.Subnet.ID
 		if subnetID == nil {
-			return MissingValueError("Must specify Subnet for RouteTableAssociation create")
+			return units.MissingValueError("Must specify Subnet for RouteTableAssociation create")
 		}
 
 		routeTableID := e.RouteTable.ID
 		if routeTableID == nil {
-			return MissingValueError("Must specify RouteTable for RouteTableAssociation create")
+			return units.MissingValueError("Must specify RouteTable for RouteTableAssociation create")
 		}
 
 		glog.V(2).Infof("Creating RouteTableAssociation with RouteTable:%q Subnet:%q", *routeTableID, *subnetID)
@@ -130,23 +137,68 @@ func (_*RouteTableAssociation) RenderAWS(t *fi.AWSAPITarget, a, e, changes *Rout
 		}
 
 		e.ID = response.AssociationId
+		if err := t.RecordCreation(fi.JournalTypeRouteTableAssociation, *e.ID); err != nil {
+			return err
+		}
 	}
 
 	return nil // no tags
 }
 
-func (_*RouteTableAssociation) RenderBash(t *fi.BashTarget, a, e, changes *RouteTableAssociation) error {
+func (_ *RouteTableAssociation) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*RouteTableAssociation), ue.(*RouteTableAssociation)
+
 	t.CreateVar(e)
 	if a == nil {
-		subnetID := t.ReadVar(e.Subnet)
-		routeTableID := t.ReadVar(e.RouteTable)
+		subnetID, err := t.ReadVar(e.Subnet)
+		if err != nil {
+			return err
+		}
+		routeTableID, err := t.ReadVar(e.RouteTable)
+		if err != nil {
+			return err
+		}
 
 		glog.V(2).Infof("Creating RouteTableAssociation with RouteTable:%q Subnet:%q", routeTableID, subnetID)
 
-		t.AddEC2Command("associate-route-table", "--route-table-id", routeTableID, "--subnet-id", subnetID)
+		t.AddEC2Command("describe-route-tables",
+			"--route-table-ids", routeTableID,
+			"--query", "RouteTables[0].Associations[?SubnetId=='"+subnetID+"'].RouteTableAssociationId").AssignTo(e)
+		t.AddEC2Command("associate-route-table", "--route-table-id", routeTableID, "--subnet-id", subnetID,
+			"--query", "AssociationId").AssignTo(e).IfMissing(e)
 	} else {
-		t.AddAssignment(e, StringValue(a.ID))
+		t.AddAssignment(e, units.StringValue(a.ID))
 	}
 
 	return nil // no tags
 }
+
+type terraformRouteTableAssociation struct {
+	SubnetID     *string `json:"subnet_id,omitempty"`
+	RouteTableID *string `json:"route_table_id,omitempty"`
+}
+
+func (_ *RouteTableAssociation) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*RouteTableAssociation)
+
+	tf := &terraformRouteTableAssociation{
+		SubnetID:     e.Subnet.TerraformLink(),
+		RouteTableID: e.RouteTable.TerraformLink(),
+	}
+	return t.RenderResource("aws_route_table_association", e.Key(), tf)
+}
+
+type cloudformationRouteTableAssociation struct {
+	SubnetID     interface{} `json:"SubnetId,omitempty"`
+	RouteTableID interface{} `json:"RouteTableId,omitempty"`
+}
+
+func (_ *RouteTableAssociation) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*RouteTableAssociation)
+
+	cf := &cloudformationRouteTableAssociation{
+		SubnetID:     e.Subnet.CloudformationLink(),
+		RouteTableID: e.RouteTable.CloudformationLink(),
+	}
+	return t.RenderResource("AWS::EC2::SubnetRouteTableAssociation", e.Key(), cf)
+}
@@ -3,10 +3,11 @@ package awsunits
 import (
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type S3Bucket struct {
@@ -23,7 +24,7 @@ func (s *S3Bucket) Prefix() string {
 	return "S3Bucket"
 }
 
-func (e*S3Bucket) findBucketIfExists(c *fi.RunContext) (*fi.S3Bucket, error) {
+func (e *S3Bucket) findBucketIfExists(c *fi.RunContext) (*fi.S3Bucket, error) {
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	return cloud.S3.FindBucketIfExists(*e.Name)
@@ -52,7 +53,7 @@ func (e *S3Bucket) Run(c *fi.RunContext) error {
 	}
 
 	changes := &S3Bucket{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -68,16 +69,18 @@ func (e *S3Bucket) Run(c *fi.RunContext) error {
 func (s *S3Bucket) checkChanges(a, e, changes *S3Bucket) error {
 	if a != nil {
 		if e.Name == nil {
-			return MissingValueError("Name is required when creating S3Bucket")
+			return units.MissingValueError("Name is required when creating S3Bucket")
 		}
 		if changes.Region != nil {
-			return InvalidChangeError("Cannot change region of existing S3Bucket", a.Region, e.Region)
+			return units.InvalidChangeError("Cannot change region of existing S3Bucket", a.Region, e.Region)
 		}
 	}
 	return nil
 }
 
-func (_*S3Bucket) RenderAWS(t *fi.AWSAPITarget, a, e, changes *S3Bucket) error {
+func (_ *S3Bucket) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*S3Bucket), ue.(*S3Bucket)
+
 	if a == nil {
 		glog.V(2).Infof("Creating S3Bucket with Name:%q", *e.Name)
 
@@ -93,14 +96,19 @@ func (_*S3Bucket) RenderAWS(t *fi.AWSAPITarget, a, e, changes *S3Bucket) error {
 	return nil //return output.AddAWSTags(cloud.Tags(), v, "vpc")
 }
 
-func (_*S3Bucket) RenderBash(t *fi.BashTarget, a, e, changes *S3Bucket) error {
+func (_ *S3Bucket) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*S3Bucket), ue.(*S3Bucket)
+
 	if a == nil {
 		glog.V(2).Infof("Creating S3Bucket with Name:%q", *e.Name)
 
+		t.CreateVar(e)
+		t.AddS3APICommand(*e.Region, "list-buckets", "--query", "Buckets[?Name=='"+*e.Name+"'].Name").AssignTo(e)
+
 		args := []string{"mb"}
-		args = append(args, "s3://" + *e.Name)
+		args = append(args, "s3://"+*e.Name)
 
-		t.AddS3Command(*e.Region, args...)
+		t.AddS3Command(*e.Region, args...).IfMissing(e)
 	}
 
 	return nil
@@ -1,30 +1,27 @@
 package awsunits
 
 import (
-	"crypto/md5"
-	"encoding/hex"
 	"fmt"
-	"io"
-	"os"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type S3File struct {
 	fi.SimpleUnit
 
-	Bucket    *S3Bucket
-	Key       *string
-	Source    fi.Resource
-	Public    *bool
+	Bucket *S3Bucket
+	Key    *string
+	Source fi.Resource
+	Public *bool
 
 	//rendered  bool
 	publicURL *string
 
-	etag      *string
+	etag *string
 }
 
 func (s *S3File) Prefix() string {
@@ -72,7 +69,7 @@ func (e *S3File) Run(c *fi.RunContext) error {
 	}
 
 	changes := &S3File{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -88,23 +85,20 @@ func (e *S3File) Run(c *fi.RunContext) error {
 func (s *S3File) checkChanges(a, e, changes *S3File) error {
 	if a != nil {
 		if e.Key == nil {
-			return MissingValueError("Key is required when creating S3File")
+			return units.MissingValueError("Key is required when creating S3File")
 		}
 	}
 	return nil
 }
 
-func (_*S3File) RenderAWS(t *fi.AWSAPITarget, a, e, changes *S3File) error {
+func (_ *S3File) RenderAWS(t *fi.AWSAPITarget, a, e, changes fi.Unit) error {
 	panic("S3 Render to AWSAPITarget not implemented")
 }
 
-func (_*S3File) RenderBash(t *fi.BashTarget, a, e, changes *S3File) error {
-	needToUpload := true
+func (_ *S3File) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e, changes := ua.(*S3File), ue.(*S3File), uchanges.(*S3File)
 
-	localPath, err := t.AddLocalResource(e.Source)
-	if err != nil {
-		return err
-	}
+	needToUpload := true
 
 	if e.Bucket.Region == nil {
 		panic("Bucket region not set")
@@ -112,21 +106,10 @@ func (_*S3File) RenderBash(t *fi.BashTarget, a, e, changes *S3File) error {
 	region := *e.Bucket.Region
 
 	if a != nil {
-		hasher := md5.New()
-		f, err := os.Open(localPath)
+		localHash, err := fi.HashForResource(e.Source, fi.HashAlgorithmMD5)
 		if err != nil {
-			return err
-		}
-		defer func() {
-			err := f.Close()
-			if err != nil {
-				glog.Warning("error closing local resource: ", err)
-			}
-		}()
-		if _, err := io.Copy(hasher, f); err != nil {
 			return fmt.Errorf("error while hashing local file: %v", err)
 		}
-		localHash := hex.EncodeToString(hasher.Sum(nil))
 		s3Hash := aws.StringValue(a.etag)
 		s3Hash = strings.Replace(s3Hash, "\"", "", -1)
 		if localHash == s3Hash {
@@ -137,6 +120,11 @@ func (_*S3File) RenderBash(t *fi.BashTarget, a, e, changes *S3File) error {
 		}
 	}
 	if needToUpload {
+		localPath, err := t.AddLocalResource(e.Source)
+		if err != nil {
+			return err
+		}
+
 		// We use put-object instead of cp so that we don't do multipart, so the etag is the simple md5
 		args := []string{"put-object"}
 		args = append(args, "--bucket", *e.Bucket.Name)
@@ -156,7 +144,7 @@ func (_*S3File) RenderBash(t *fi.BashTarget, a, e, changes *S3File) error {
 		args := []string{"put-object-acl"}
 		args = append(args, "--bucket", *e.Bucket.Name)
 		args = append(args, "--key", *e.Key)
-		args = append(args, "--grant-read", "uri=\"http://acs.amazonaws.com/groups/global/AllUsers\"")
+		args = append(args, "--grant-read", "uri=http://acs.amazonaws.com/groups/global/AllUsers")
 		t.AddS3APICommand(region, args...)
 
 		publicURLBase := "https://s3-" + region + ".amazonaws.com"
@@ -184,6 +172,3 @@ func (f *S3File) PublicURL() string {
 func (f *S3File) String() string {
 	return fmt.Sprintf("S3File (s3://%s/%s)", *f.Bucket.Name, *f.Key)
 }
-
-
-
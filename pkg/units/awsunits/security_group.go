@@ -6,6 +6,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type SecurityGroup struct {
@@ -26,6 +27,10 @@ func (s *SecurityGroup) GetID() *string {
 }
 
 func (e *SecurityGroup) find(c *fi.RunContext) (*SecurityGroup, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	var vpcID *string
@@ -61,7 +66,7 @@ func (e *SecurityGroup) find(c *fi.RunContext) (*SecurityGroup, error) {
 	actual.ID = sg.GroupId
 	actual.Name = sg.GroupName
 	actual.Description = sg.Description
-	actual.VPC = &VPC{ID:sg.VpcId}
+	actual.VPC = &VPC{ID: sg.VpcId}
 	glog.V(2).Infof("found matching SecurityGroup %q", *actual.ID)
 	return actual, nil
 }
@@ -77,7 +82,7 @@ func (e *SecurityGroup) Run(c *fi.RunContext) error {
 	}
 
 	changes := &SecurityGroup{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -93,19 +98,21 @@ func (e *SecurityGroup) Run(c *fi.RunContext) error {
 func (s *SecurityGroup) checkChanges(a, e, changes *SecurityGroup) error {
 	if a != nil {
 		if changes.ID != nil {
-			return InvalidChangeError("Cannot change SecurityGroup ID", changes.ID, e.ID)
+			return units.InvalidChangeError("Cannot change SecurityGroup ID", changes.ID, e.ID)
 		}
 		if changes.Name != nil {
-			return InvalidChangeError("Cannot change SecurityGroup Name", changes.Name, e.Name)
+			return units.InvalidChangeError("Cannot change SecurityGroup Name", changes.Name, e.Name)
 		}
 		if changes.VPC != nil {
-			return InvalidChangeError("Cannot change SecurityGroup VPC", changes.VPC, e.VPC)
+			return units.InvalidChangeError("Cannot change SecurityGroup VPC", changes.VPC, e.VPC)
 		}
 	}
 	return nil
 }
 
-func (_*SecurityGroup) RenderAWS(t *fi.AWSAPITarget, a, e, changes *SecurityGroup) error {
+func (_ *SecurityGroup) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*SecurityGroup), ue.(*SecurityGroup)
+
 	if a == nil {
 		vpcID := e.VPC.ID
 
@@ -122,27 +129,43 @@ func (_*SecurityGroup) RenderAWS(t *fi.AWSAPITarget, a, e, changes *SecurityGrou
 		}
 
 		e.ID = response.GroupId
+		if err := t.RecordCreation(fi.JournalTypeSecurityGroup, *e.ID); err != nil {
+			return err
+		}
 	}
 
 	return t.AddAWSTags(*e.ID, t.Cloud.BuildTags(e.Name))
 }
 
-func (_*SecurityGroup) RenderBash(t *fi.BashTarget, a, e, changes *SecurityGroup) error {
+func (_ *SecurityGroup) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*SecurityGroup), ue.(*SecurityGroup)
+
 	t.CreateVar(e)
 	if a == nil {
 		glog.V(2).Infof("Creating SecurityGroup with Name:%q", *e.Name)
 
+		vpcID, err := t.ReadVar(e.VPC)
+		if err != nil {
+			return err
+		}
+
+		e.AddBashLookup(t)
 		t.AddEC2Command("create-security-group", "--group-name", *e.Name,
-			"--description", fi.BashQuoteString(*e.Description),
-			"--vpc-id", t.ReadVar(e.VPC),
-			"--query", "GroupId").AssignTo(e)
+			"--description", *e.Description,
+			"--vpc-id", vpcID,
+			"--query", "GroupId").AssignTo(e).IfMissing(e)
 	} else {
-		t.AddAssignment(e, StringValue(a.ID))
+		t.AddAssignment(e, units.StringValue(a.ID))
 	}
 
 	return t.AddAWSTags(e, t.Cloud.BuildTags(e.Name))
 }
 
+// AddBashLookup finds an existing SecurityGroup by its tags
+func (e *SecurityGroup) AddBashLookup(t *fi.BashTarget) *fi.BashCommand {
+	return t.AddEC2TagLookup(e, e.Name, "describe-security-groups", "SecurityGroups[0].GroupId")
+}
+
 func (s *SecurityGroup) AllowFrom(source *SecurityGroup) *SecurityGroupIngress {
 	return &SecurityGroupIngress{SecurityGroup: s, SourceGroup: source}
 }
@@ -159,3 +182,64 @@ func (s *SecurityGroup) AllowTCP(cidr string, fromPort int, toPort int) *Securit
 		ToPort:        &toPort64,
 	}
 }
+
+type terraformSecurityGroup struct {
+	Name        *string           `json:"name,omitempty"`
+	Description *string           `json:"description,omitempty"`
+	VPCID       *string           `json:"vpc_id,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+func (_ *SecurityGroup) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*SecurityGroup)
+
+	if e.ID != nil {
+		// Existing security group; not managed by terraform
+		return nil
+	}
+
+	tf := &terraformSecurityGroup{
+		Name:        e.Name,
+		Description: e.Description,
+		VPCID:       e.VPC.TerraformLink(),
+		Tags:        t.Cloud.BuildTags(e.Name),
+	}
+	return t.RenderResource("aws_security_group", e.Key(), tf)
+}
+
+func (e *SecurityGroup) TerraformLink() *string {
+	if e.ID != nil {
+		return e.ID
+	}
+	return units.String(fi.TerraformReference("aws_security_group", e.Key(), "id"))
+}
+
+type cloudformationSecurityGroup struct {
+	Name        *string     `json:"GroupName,omitempty"`
+	Description *string     `json:"GroupDescription,omitempty"`
+	VPCID       interface{} `json:"VpcId,omitempty"`
+}
+
+func (_ *SecurityGroup) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*SecurityGroup)
+
+	if e.ID != nil {
+		// Existing security group; not managed by the stack
+		return nil
+	}
+
+	cf := &cloudformationSecurityGroup{
+		Name:        e.Name,
+		Description: e.Description,
+		VPCID:       e.VPC.CloudformationLink(),
+	}
+	t.AddTags("AWS::EC2::SecurityGroup", e.Key(), t.Cloud.BuildTags(e.Name))
+	return t.RenderResource("AWS::EC2::SecurityGroup", e.Key(), cf)
+}
+
+func (e *SecurityGroup) CloudformationLink() interface{} {
+	if e.ID != nil {
+		return *e.ID
+	}
+	return fi.CloudFormationRef("AWS::EC2::SecurityGroup", e.Key())
+}
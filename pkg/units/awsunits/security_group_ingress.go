@@ -7,6 +7,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
 	"strconv"
 )
 
@@ -42,6 +43,10 @@ func (s *SecurityGroupIngress) Key() string {
 }
 
 func (e *SecurityGroupIngress) find(c *fi.RunContext) (*SecurityGroupIngress, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	if e.SecurityGroup == nil || e.SecurityGroup.ID == nil {
@@ -69,7 +74,6 @@ func (e *SecurityGroupIngress) find(c *fi.RunContext) (*SecurityGroupIngress, er
 	sg := response.SecurityGroups[0]
 	//glog.V(2).Info("found existing security group")
 
-
 	var foundRule *ec2.IpPermission
 
 	matchProtocol := "-1" // Wildcard
@@ -146,7 +150,7 @@ func (e *SecurityGroupIngress) Run(c *fi.RunContext) error {
 	}
 
 	changes := &SecurityGroupIngress{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -162,13 +166,15 @@ func (e *SecurityGroupIngress) Run(c *fi.RunContext) error {
 func (s *SecurityGroupIngress) checkChanges(a, e, changes *SecurityGroupIngress) error {
 	if a == nil {
 		if e.SecurityGroup == nil {
-			return MissingValueError("Must specify SecurityGroup when creating SecurityGroupIngress")
+			return units.MissingValueError("Must specify SecurityGroup when creating SecurityGroupIngress")
 		}
 	}
 	return nil
 }
 
-func (_*SecurityGroupIngress) RenderAWS(t *fi.AWSAPITarget, a, e, changes *SecurityGroupIngress) error {
+func (_ *SecurityGroupIngress) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*SecurityGroupIngress), ue.(*SecurityGroupIngress)
+
 	if a == nil {
 		request := &ec2.AuthorizeSecurityGroupIngressInput{}
 		request.GroupId = e.SecurityGroup.ID
@@ -196,18 +202,30 @@ func (_*SecurityGroupIngress) RenderAWS(t *fi.AWSAPITarget, a, e, changes *Secur
 	return nil //return output.AddAWSTags(cloud.Tags(), v, "vpc")
 }
 
-func (_*SecurityGroupIngress) RenderBash(t *fi.BashTarget, a, e, changes *SecurityGroupIngress) error {
+func (_ *SecurityGroupIngress) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*SecurityGroupIngress), ue.(*SecurityGroupIngress)
+
 	if a == nil {
 		glog.V(2).Infof("Creating SecurityGroupIngress")
 
+		groupID, err := t.ReadVar(e.SecurityGroup)
+		if err != nil {
+			return err
+		}
+
 		args := []string{"authorize-security-group-ingress"}
-		args = append(args, "--group-id", t.ReadVar(e.SecurityGroup))
+		args = append(args, "--group-id", groupID)
 
+		// The query to find the existing permission
+		protocol := "-1"
 		if e.Protocol != nil {
 			args = append(args, "--protocol", *e.Protocol)
+			protocol = *e.Protocol
 		} else {
 			args = append(args, "--protocol", "all")
 		}
+		match := fmt.Sprintf("IpProtocol=='%s'", protocol)
+
 		fromPort := aws.Int64Value(e.FromPort)
 		toPort := aws.Int64Value(e.ToPort)
 		if fromPort != 0 || toPort != 0 {
@@ -216,16 +234,27 @@ func (_*SecurityGroupIngress) RenderBash(t *fi.BashTarget, a, e, changes *Securi
 			} else {
 				args = append(args, "--port", fmt.Sprintf("%d-%d", fromPort, toPort))
 			}
+			match += fmt.Sprintf(" && FromPort==`%d` && ToPort==`%d`", fromPort, toPort)
 		}
+
+		query := "SecurityGroups[0].IpPermissions[?" + match + "]"
 		if e.CIDR != nil {
 			args = append(args, "--cidr", *e.CIDR)
+			query += ".IpRanges[][?CidrIp=='" + *e.CIDR + "'][].CidrIp"
 		}
 
 		if e.SourceGroup != nil {
-			args = append(args, "--source-group", t.ReadVar(e.SourceGroup))
+			sourceGroupID, err := t.ReadVar(e.SourceGroup)
+			if err != nil {
+				return err
+			}
+			args = append(args, "--source-group", sourceGroupID)
+			query += ".UserIdGroupPairs[][?GroupId=='" + sourceGroupID + "'][].GroupId"
 		}
 
-		t.AddEC2Command(args...)
+		t.CreateVar(e)
+		t.AddEC2Command("describe-security-groups", "--group-ids", groupID, "--query", query).AssignTo(e)
+		t.AddEC2Command(args...).IfMissing(e)
 	}
 
 	return nil
@@ -234,3 +263,67 @@ func (_*SecurityGroupIngress) RenderBash(t *fi.BashTarget, a, e, changes *Securi
 func (s *SecurityGroupIngress) String() string {
 	return fmt.Sprintf("SecurityGroupIngress (Port=%d-%d)", s.FromPort, s.ToPort)
 }
+
+type terraformSecurityGroupIngress struct {
+	Type                  *string  `json:"type,omitempty"`
+	SecurityGroupID       *string  `json:"security_group_id,omitempty"`
+	SourceSecurityGroupID *string  `json:"source_security_group_id,omitempty"`
+	Protocol              *string  `json:"protocol,omitempty"`
+	FromPort              *int64   `json:"from_port"`
+	ToPort                *int64   `json:"to_port"`
+	CIDRBlocks            []string `json:"cidr_blocks,omitempty"`
+}
+
+func (_ *SecurityGroupIngress) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*SecurityGroupIngress)
+
+	tf := &terraformSecurityGroupIngress{
+		Type:            units.String("ingress"),
+		SecurityGroupID: e.SecurityGroup.TerraformLink(),
+		Protocol:        e.Protocol,
+		FromPort:        e.FromPort,
+		ToPort:          e.ToPort,
+	}
+	if tf.Protocol == nil {
+		// All protocols; terraform requires the ports to be set
+		tf.Protocol = units.String("-1")
+		tf.FromPort = units.Int64(0)
+		tf.ToPort = units.Int64(0)
+	}
+	if e.SourceGroup != nil {
+		tf.SourceSecurityGroupID = e.SourceGroup.TerraformLink()
+	}
+	if e.CIDR != nil {
+		tf.CIDRBlocks = []string{*e.CIDR}
+	}
+	return t.RenderResource("aws_security_group_rule", e.Key(), tf)
+}
+
+type cloudformationSecurityGroupIngress struct {
+	SecurityGroupID       interface{} `json:"GroupId,omitempty"`
+	SourceSecurityGroupID interface{} `json:"SourceSecurityGroupId,omitempty"`
+	Protocol              *string     `json:"IpProtocol,omitempty"`
+	FromPort              *int64      `json:"FromPort,omitempty"`
+	ToPort                *int64      `json:"ToPort,omitempty"`
+	CIDR                  *string     `json:"CidrIp,omitempty"`
+}
+
+func (_ *SecurityGroupIngress) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*SecurityGroupIngress)
+
+	cf := &cloudformationSecurityGroupIngress{
+		SecurityGroupID: e.SecurityGroup.CloudformationLink(),
+		Protocol:        e.Protocol,
+		FromPort:        e.FromPort,
+		ToPort:          e.ToPort,
+		CIDR:            e.CIDR,
+	}
+	if cf.Protocol == nil {
+		// All protocols
+		cf.Protocol = units.String("-1")
+	}
+	if e.SourceGroup != nil {
+		cf.SourceSecurityGroupID = e.SourceGroup.CloudformationLink()
+	}
+	return t.RenderResource("AWS::EC2::SecurityGroupIngress", e.Key(), cf)
+}
@@ -1,19 +1,23 @@
 package awsunits
 
 import (
+	"crypto/md5"
 	"fmt"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
+	"golang.org/x/crypto/ssh"
 )
 
 type SSHKey struct {
 	fi.SimpleUnit
 
-	Name        *string
-	PublicKey   fi.Resource
+	Name      *string
+	PublicKey fi.Resource
 
 	fingerprint *string
 }
@@ -27,10 +31,14 @@ func (s *SSHKey) GetID() *string {
 }
 
 func (k *SSHKey) String() string {
-	return fmt.Sprintf("SSHKey (name=%s)", k.Name)
+	return fmt.Sprintf("SSHKey (name=%s)", units.StringValue(k.Name))
 }
 
 func (e *SSHKey) find(c *fi.RunContext) (*SSHKey, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	request := &ec2.DescribeKeyPairsInput{
@@ -61,9 +69,40 @@ func (e *SSHKey) find(c *fi.RunContext) (*SSHKey, error) {
 	actual.Name = k.KeyName
 	actual.fingerprint = k.KeyFingerprint
 
+	// AWS does not return the public key, so we compare fingerprints instead
+	if e.PublicKey != nil && actual.fingerprint != nil {
+		publicKey, err := fi.ResourceAsString(e.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("error reading SSH public key: %v", err)
+		}
+		fingerprint, err := computeAWSKeyFingerprint(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		if fingerprint == *actual.fingerprint {
+			actual.PublicKey = e.PublicKey
+		}
+	}
+
 	return actual, nil
 }
 
+// computeAWSKeyFingerprint computes the fingerprint that AWS reports for an imported key:
+// the MD5 of the key in SSH wire format, as hex bytes separated by colons
+func computeAWSKeyFingerprint(publicKey string) (string, error) {
+	sshPublicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return "", fmt.Errorf("error parsing SSH public key: %v", err)
+	}
+
+	h := md5.Sum(sshPublicKey.Marshal())
+	var tokens []string
+	for _, b := range h {
+		tokens = append(tokens, fmt.Sprintf("%02x", b))
+	}
+	return strings.Join(tokens, ":"), nil
+}
+
 func (e *SSHKey) Run(c *fi.RunContext) error {
 	a, err := e.find(c)
 	if err != nil {
@@ -71,7 +110,7 @@ func (e *SSHKey) Run(c *fi.RunContext) error {
 	}
 
 	changes := &SSHKey{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -87,13 +126,15 @@ func (e *SSHKey) Run(c *fi.RunContext) error {
 func (s *SSHKey) checkChanges(a, e, changes *SSHKey) error {
 	if a != nil {
 		if changes.Name != nil {
-			return InvalidChangeError("Cannot change SSHKey Name", changes.Name, e.Name)
+			return units.InvalidChangeError("Cannot change SSHKey Name", changes.Name, e.Name)
 		}
 	}
 	return nil
 }
 
-func (_*SSHKey) RenderAWS(t *fi.AWSAPITarget, a, e, changes *SSHKey) error {
+func (_ *SSHKey) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*SSHKey), ue.(*SSHKey)
+
 	if a == nil {
 		glog.V(2).Infof("Creating SSHKey with Name:%q", *e.Name)
 
@@ -113,12 +154,17 @@ func (_*SSHKey) RenderAWS(t *fi.AWSAPITarget, a, e, changes *SSHKey) error {
 		}
 
 		e.fingerprint = response.KeyFingerprint
+		if err := t.RecordCreation(fi.JournalTypeSSHKey, *e.Name); err != nil {
+			return err
+		}
 	}
 
 	return nil //return output.AddAWSTags(cloud.Tags(), v, "vpc")
 }
 
-func (_*SSHKey) RenderBash(t *fi.BashTarget, a, e, changes *SSHKey) error {
+func (_ *SSHKey) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*SSHKey), ue.(*SSHKey)
+
 	if a == nil {
 		glog.V(2).Infof("Creating SSHKey with Name:%q", *e.Name)
 
@@ -126,9 +172,61 @@ func (_*SSHKey) RenderBash(t *fi.BashTarget, a, e, changes *SSHKey) error {
 		if err != nil {
 			return err
 		}
-		t.AddEC2Command("import-key-pair", "--key-name", *e.Name, "--public-key-material", "file://" + file)
+		t.CreateVar(e)
+		t.AddEC2Command("describe-key-pairs", "--key-names", *e.Name, "--query", "KeyPairs[0].KeyName").AssignTo(e).IgnoreErrors()
+		t.AddEC2Command("import-key-pair", "--key-name", *e.Name, "--public-key-material", "file://"+file).IfMissing(e)
 	}
 
 	return nil
 }
 
+type terraformSSHKey struct {
+	Name      *string `json:"key_name,omitempty"`
+	PublicKey *string `json:"public_key,omitempty"`
+}
+
+func (_ *SSHKey) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*SSHKey)
+
+	tf := &terraformSSHKey{
+		Name: e.Name,
+	}
+	if e.PublicKey != nil {
+		publicKey, err := t.AddFile("aws_key_pair", e.Key(), "public_key", e.PublicKey)
+		if err != nil {
+			return err
+		}
+		tf.PublicKey = &publicKey
+	}
+	return t.RenderResource("aws_key_pair", e.Key(), tf)
+}
+
+func (e *SSHKey) TerraformLink() *string {
+	return units.String(fi.TerraformReference("aws_key_pair", e.Key(), "key_name"))
+}
+
+type cloudformationSSHKey struct {
+	Name      *string `json:"KeyName,omitempty"`
+	PublicKey *string `json:"PublicKeyMaterial,omitempty"`
+}
+
+func (_ *SSHKey) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*SSHKey)
+
+	cf := &cloudformationSSHKey{
+		Name: e.Name,
+	}
+	if e.PublicKey != nil {
+		d, err := fi.ResourceAsString(e.PublicKey)
+		if err != nil {
+			return fmt.Errorf("error rendering SSHKey PublicKey: %v", err)
+		}
+		cf.PublicKey = &d
+	}
+	return t.RenderResource("AWS::EC2::KeyPair", e.Key(), cf)
+}
+
+// CloudformationLink returns a reference to the key; Ref returns the key name
+func (e *SSHKey) CloudformationLink() interface{} {
+	return fi.CloudFormationRef("AWS::EC2::KeyPair", e.Key())
+}
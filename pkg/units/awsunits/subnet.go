@@ -6,6 +6,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type Subnet struct {
@@ -27,11 +28,15 @@ func (s *Subnet) GetID() *string {
 }
 
 func (e *Subnet) find(c *fi.RunContext) (*Subnet, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	request := &ec2.DescribeSubnetsInput{}
 	if e.ID != nil {
-		request.SubnetIds = []*string{e.ID }
+		request.SubnetIds = []*string{e.ID}
 	} else {
 		request.Filters = cloud.BuildFilters(e.Name)
 	}
@@ -72,7 +77,7 @@ func (e *Subnet) Run(c *fi.RunContext) error {
 	}
 
 	changes := &Subnet{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -89,25 +94,27 @@ func (s *Subnet) checkChanges(a, e, changes *Subnet) error {
 	if a != nil {
 		if changes.VPC != nil {
 			// TODO: Do we want to destroy & recreate the CIDR?
-			return InvalidChangeError("Cannot change subnet VPC", changes.VPC.ID, e.VPC.ID)
+			return units.InvalidChangeError("Cannot change subnet VPC", changes.VPC.ID, e.VPC.ID)
 		}
 		if changes.AvailabilityZone != nil {
 			// TODO: Do we want to destroy & recreate the CIDR?
-			return InvalidChangeError("Cannot change subnet AvailabilityZone", changes.AvailabilityZone, e.AvailabilityZone)
+			return units.InvalidChangeError("Cannot change subnet AvailabilityZone", changes.AvailabilityZone, e.AvailabilityZone)
 		}
 		if changes.CIDR != nil {
 			// TODO: Do we want to destroy & recreate the CIDR?
-			return InvalidChangeError("Cannot change subnet CIDR", changes.CIDR, e.CIDR)
+			return units.InvalidChangeError("Cannot change subnet CIDR", changes.CIDR, e.CIDR)
 		}
 	}
 	return nil
 }
 
-func (_*Subnet) RenderAWS(t *fi.AWSAPITarget, a, e, changes *Subnet) error {
+func (_ *Subnet) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*Subnet), ue.(*Subnet)
+
 	if a == nil {
 		if e.CIDR == nil {
 			// TODO: Auto-assign CIDR
-			return MissingValueError("Must specify CIDR for Subnet create")
+			return units.MissingValueError("Must specify CIDR for Subnet create")
 		}
 
 		glog.V(2).Infof("Creating Subnet with CIDR: %q", *e.CIDR)
@@ -129,30 +136,105 @@ func (_*Subnet) RenderAWS(t *fi.AWSAPITarget, a, e, changes *Subnet) error {
 
 		subnet := response.Subnet
 		e.ID = subnet.SubnetId
+		if err := t.RecordCreation(fi.JournalTypeSubnet, *e.ID); err != nil {
+			return err
+		}
 	}
 
 	return t.AddAWSTags(*e.ID, t.Cloud.BuildTags(e.Name))
 }
 
-func (_*Subnet) RenderBash(t *fi.BashTarget, a, e, changes *Subnet) error {
+func (_ *Subnet) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*Subnet), ue.(*Subnet)
+
 	t.CreateVar(e)
 	if a == nil {
 		if e.CIDR == nil {
 			// TODO: Auto-assign CIDR
-			return MissingValueError("Must specify CIDR for Subnet create")
+			return units.MissingValueError("Must specify CIDR for Subnet create")
 		}
 
-		vpcID := t.ReadVar(e.VPC)
+		vpcID, err := t.ReadVar(e.VPC)
+		if err != nil {
+			return err
+		}
 
 		args := []string{"create-subnet", "--cidr-block", *e.CIDR, "--vpc-id", vpcID, "--query", "Subnet.SubnetId"}
 		if e.AvailabilityZone != nil {
 			args = append(args, "--availability-zone", *e.AvailabilityZone)
 		}
 
-		t.AddEC2Command(args...).AssignTo(e)
+		e.AddBashLookup(t)
+		t.AddEC2Command(args...).AssignTo(e).IfMissing(e)
 	} else {
-		t.AddAssignment(e, StringValue(a.ID))
+		t.AddAssignment(e, units.StringValue(a.ID))
 	}
 
 	return t.AddAWSTags(e, t.Cloud.BuildTags(e.Name))
-}
\ No newline at end of file
+}
+
+// AddBashLookup finds an existing Subnet by its tags
+func (e *Subnet) AddBashLookup(t *fi.BashTarget) *fi.BashCommand {
+	return t.AddEC2TagLookup(e, e.Name, "describe-subnets", "Subnets[0].SubnetId")
+}
+
+type terraformSubnet struct {
+	VPCID            *string           `json:"vpc_id,omitempty"`
+	CIDR             *string           `json:"cidr_block,omitempty"`
+	AvailabilityZone *string           `json:"availability_zone,omitempty"`
+	Tags             map[string]string `json:"tags,omitempty"`
+}
+
+func (_ *Subnet) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*Subnet)
+
+	if e.ID != nil {
+		// Existing subnet; not managed by terraform
+		return nil
+	}
+
+	tf := &terraformSubnet{
+		VPCID:            e.VPC.TerraformLink(),
+		CIDR:             e.CIDR,
+		AvailabilityZone: e.AvailabilityZone,
+		Tags:             t.Cloud.BuildTags(e.Name),
+	}
+	return t.RenderResource("aws_subnet", e.Key(), tf)
+}
+
+func (e *Subnet) TerraformLink() *string {
+	if e.ID != nil {
+		return e.ID
+	}
+	return units.String(fi.TerraformReference("aws_subnet", e.Key(), "id"))
+}
+
+type cloudformationSubnet struct {
+	VPCID            interface{} `json:"VpcId,omitempty"`
+	CIDR             *string     `json:"CidrBlock,omitempty"`
+	AvailabilityZone *string     `json:"AvailabilityZone,omitempty"`
+}
+
+func (_ *Subnet) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*Subnet)
+
+	if e.ID != nil {
+		// Existing subnet; not managed by the stack
+		return nil
+	}
+
+	cf := &cloudformationSubnet{
+		VPCID:            e.VPC.CloudformationLink(),
+		CIDR:             e.CIDR,
+		AvailabilityZone: e.AvailabilityZone,
+	}
+	t.AddTags("AWS::EC2::Subnet", e.Key(), t.Cloud.BuildTags(e.Name))
+	return t.RenderResource("AWS::EC2::Subnet", e.Key(), cf)
+}
+
+func (e *Subnet) CloudformationLink() interface{} {
+	if e.ID != nil {
+		return *e.ID
+	}
+	return fi.CloudFormationRef("AWS::EC2::Subnet", e.Key())
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type VPC struct {
@@ -46,6 +47,10 @@ func (v *VPC) String() string {
 }
 
 func (e *VPC) find(c *fi.RunContext) (*VPC, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	request := &ec2.DescribeVpcsInput{}
@@ -71,6 +76,7 @@ func (e *VPC) find(c *fi.RunContext) (*VPC, error) {
 	actual := &VPC{}
 	actual.ID = vpc.VpcId
 	actual.CIDR = vpc.CidrBlock
+	actual.Name = findNameTag(vpc.Tags)
 	glog.V(2).Infof("found matching VPC %q", *actual.ID)
 
 	if actual.ID != nil {
@@ -106,7 +112,7 @@ func (e *VPC) Run(c *fi.RunContext) error {
 	}
 
 	changes := &VPC{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		glog.V(2).Infof("No changes: %v", e)
 		return nil
@@ -115,11 +121,13 @@ func (e *VPC) Run(c *fi.RunContext) error {
 	return c.Render(a, e, changes)
 }
 
-func (_*VPC) RenderAWS(t *fi.AWSAPITarget, a, e, changes *VPC) error {
+func (_ *VPC) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e, changes := ua.(*VPC), ue.(*VPC), uchanges.(*VPC)
+
 	if a == nil {
 		if e.CIDR == nil {
 			// TODO: Auto-assign CIDR
-			return MissingValueError("Must specify CIDR for VPC create")
+			return units.MissingValueError("Must specify CIDR for VPC create")
 		}
 
 		glog.V(2).Infof("Creating VPC with CIDR: %q", *e.CIDR)
@@ -133,10 +141,13 @@ func (_*VPC) RenderAWS(t *fi.AWSAPITarget, a, e, changes *VPC) error {
 		}
 
 		e.ID = response.Vpc.VpcId
+		if err := t.RecordCreation(fi.JournalTypeVPC, *e.ID); err != nil {
+			return err
+		}
 	} else {
 		if changes.CIDR != nil {
 			// TODO: Do we want to destroy & recreate the CIDR?
-			return InvalidChangeError("VPC did not have the correct CIDR", changes.CIDR, e.CIDR)
+			return units.InvalidChangeError("VPC did not have the correct CIDR", changes.CIDR, e.CIDR)
 		}
 		if e.ID == nil {
 			e.ID = a.ID
@@ -168,36 +179,110 @@ func (_*VPC) RenderAWS(t *fi.AWSAPITarget, a, e, changes *VPC) error {
 	return t.AddAWSTags(*e.ID, t.Cloud.BuildTags(e.Name))
 }
 
-func (_*VPC) RenderBash(t *fi.BashTarget, a, e, changes *VPC) error {
+func (_ *VPC) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e, changes := ua.(*VPC), ue.(*VPC), uchanges.(*VPC)
+
 	t.CreateVar(e)
 
 	if a == nil {
 		if e.CIDR == nil {
 			// TODO: Auto-assign CIDR
-			return MissingValueError("Must specify CIDR for VPC create")
+			return units.MissingValueError("Must specify CIDR for VPC create")
 		}
 
 		glog.V(2).Infof("Creating VPC with CIDR: %q", *e.CIDR)
 
-		t.AddEC2Command("create-vpc", "--cidr-block", *e.CIDR, "--query", "Vpc.VpcId").AssignTo(e)
+		e.AddBashLookup(t)
+		t.AddEC2Command("create-vpc", "--cidr-block", *e.CIDR, "--query", "Vpc.VpcId").AssignTo(e).IfMissing(e)
 	} else {
 		if changes.CIDR != nil {
 			// TODO: Do we want to destroy & recreate the CIDR?
-			return InvalidChangeError("VPC did not have the correct CIDR", changes.CIDR, e.CIDR)
+			return units.InvalidChangeError("VPC did not have the correct CIDR", changes.CIDR, e.CIDR)
 		}
 
-		t.AddAssignment(e, StringValue(a.ID))
+		t.AddAssignment(e, units.StringValue(a.ID))
+	}
+
+	vpcID, err := t.ReadVar(e)
+	if err != nil {
+		return err
 	}
 
 	if changes.EnableDNSSupport != nil {
-		s := fmt.Sprintf("'{\"Value\": %v}'", *changes.EnableDNSSupport)
-		t.AddEC2Command("modify-vpc-attribute", "--vpc-id", t.ReadVar(e), "--enable-dns-support", s)
+		s := fmt.Sprintf("{\"Value\": %v}", *changes.EnableDNSSupport)
+		t.AddEC2Command("modify-vpc-attribute", "--vpc-id", vpcID, "--enable-dns-support", s)
 	}
 
 	if changes.EnableDNSHostnames != nil {
-		s := fmt.Sprintf("'{\"Value\": %v}'", *changes.EnableDNSHostnames)
-		t.AddEC2Command("modify-vpc-attribute", "--vpc-id", t.ReadVar(e), "--enable-dns-hostnames", s)
+		s := fmt.Sprintf("{\"Value\": %v}", *changes.EnableDNSHostnames)
+		t.AddEC2Command("modify-vpc-attribute", "--vpc-id", vpcID, "--enable-dns-hostnames", s)
 	}
 
 	return t.AddAWSTags(e, t.Cloud.BuildTags(e.Name))
 }
+
+// AddBashLookup finds an existing VPC by its tags
+func (e *VPC) AddBashLookup(t *fi.BashTarget) *fi.BashCommand {
+	return t.AddEC2TagLookup(e, e.Name, "describe-vpcs", "Vpcs[0].VpcId")
+}
+
+type terraformVPC struct {
+	CIDR               *string           `json:"cidr_block,omitempty"`
+	EnableDNSHostnames *bool             `json:"enable_dns_hostnames,omitempty"`
+	EnableDNSSupport   *bool             `json:"enable_dns_support,omitempty"`
+	Tags               map[string]string `json:"tags,omitempty"`
+}
+
+func (_ *VPC) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*VPC)
+
+	if e.ID != nil {
+		// Existing VPC; not managed by terraform
+		return nil
+	}
+
+	tf := &terraformVPC{
+		CIDR:               e.CIDR,
+		EnableDNSHostnames: e.EnableDNSHostnames,
+		EnableDNSSupport:   e.EnableDNSSupport,
+		Tags:               t.Cloud.BuildTags(e.Name),
+	}
+	return t.RenderResource("aws_vpc", e.Key(), tf)
+}
+
+func (e *VPC) TerraformLink() *string {
+	if e.ID != nil {
+		return e.ID
+	}
+	return units.String(fi.TerraformReference("aws_vpc", e.Key(), "id"))
+}
+
+type cloudformationVPC struct {
+	CIDR               *string `json:"CidrBlock,omitempty"`
+	EnableDNSHostnames *bool   `json:"EnableDnsHostnames,omitempty"`
+	EnableDNSSupport   *bool   `json:"EnableDnsSupport,omitempty"`
+}
+
+func (_ *VPC) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*VPC)
+
+	if e.ID != nil {
+		// Existing VPC; not managed by the stack
+		return nil
+	}
+
+	cf := &cloudformationVPC{
+		CIDR:               e.CIDR,
+		EnableDNSHostnames: e.EnableDNSHostnames,
+		EnableDNSSupport:   e.EnableDNSSupport,
+	}
+	t.AddTags("AWS::EC2::VPC", e.Key(), t.Cloud.BuildTags(e.Name))
+	return t.RenderResource("AWS::EC2::VPC", e.Key(), cf)
+}
+
+func (e *VPC) CloudformationLink() interface{} {
+	if e.ID != nil {
+		return *e.ID
+	}
+	return fi.CloudFormationRef("AWS::EC2::VPC", e.Key())
+}
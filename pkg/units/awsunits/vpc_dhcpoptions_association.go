@@ -5,6 +5,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
 )
 
 type VPCDHCPOptionsAssociation struct {
@@ -19,6 +20,10 @@ func (s *VPCDHCPOptionsAssociation) Key() string {
 }
 
 func (e *VPCDHCPOptionsAssociation) find(c *fi.RunContext) (*VPCDHCPOptionsAssociation, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
 	cloud := c.Cloud().(*fi.AWSCloud)
 
 	vpcID := e.VPC.ID
@@ -34,8 +39,8 @@ func (e *VPCDHCPOptionsAssociation) find(c *fi.RunContext) (*VPCDHCPOptionsAssoc
 	}
 
 	actual := &VPCDHCPOptionsAssociation{}
-	actual.VPC = &VPC{ID: vpc.VpcId }
-	actual.DHCPOptions = &DHCPOptions{ID: vpc.DhcpOptionsId }
+	actual.VPC = &VPC{ID: vpc.VpcId}
+	actual.DHCPOptions = &DHCPOptions{ID: vpc.DhcpOptionsId}
 	return actual, nil
 }
 
@@ -46,7 +51,7 @@ func (e *VPCDHCPOptionsAssociation) Run(c *fi.RunContext) error {
 	}
 
 	changes := &VPCDHCPOptionsAssociation{}
-	changed := BuildChanges(a, e, changes)
+	changed := units.BuildChanges(a, e, changes)
 	if !changed {
 		return nil
 	}
@@ -62,14 +67,17 @@ func (e *VPCDHCPOptionsAssociation) Run(c *fi.RunContext) error {
 func (s *VPCDHCPOptionsAssociation) checkChanges(a, e, changes *VPCDHCPOptionsAssociation) error {
 	if a == nil {
 		if e.DHCPOptions == nil || e.VPC == nil {
-			return MissingValueError("Must specify VPC and DHCPOptions for VPCDHCPOptionsAssociation creation")
+			return units.MissingValueError("Must specify VPC and DHCPOptions for VPCDHCPOptionsAssociation creation")
 		}
 	}
 	return nil
 }
 
-func (_*VPCDHCPOptionsAssociation) RenderAWS(t *fi.AWSAPITarget, a, e, changes *VPCDHCPOptionsAssociation) error {
-	if a == nil {
+func (_ *VPCDHCPOptionsAssociation) RenderAWS(t *fi.AWSAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e, changes := ua.(*VPCDHCPOptionsAssociation), ue.(*VPCDHCPOptionsAssociation), uchanges.(*VPCDHCPOptionsAssociation)
+
+	// Every VPC has DHCP options, so we also associate if they are not the ones we want
+	if a == nil || changes.DHCPOptions != nil {
 		request := &ec2.AssociateDhcpOptionsInput{}
 		request.VpcId = e.VPC.ID
 		request.DhcpOptionsId = e.DHCPOptions.ID
@@ -83,16 +91,61 @@ func (_*VPCDHCPOptionsAssociation) RenderAWS(t *fi.AWSAPITarget, a, e, changes *
 	return nil // no tags
 }
 
-func (_*VPCDHCPOptionsAssociation) RenderBash(t *fi.BashTarget, a, e, changes *VPCDHCPOptionsAssociation) error {
-	//t.CreateVar(e)
+func (_ *VPCDHCPOptionsAssociation) RenderBash(t *fi.BashTarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*VPCDHCPOptionsAssociation), ue.(*VPCDHCPOptionsAssociation)
+
+	t.CreateVar(e)
 	if a == nil {
-		vpcID := t.ReadVar(e.VPC)
-		dhcpOptionsID := t.ReadVar(e.DHCPOptions)
+		vpcID, err := t.ReadVar(e.VPC)
+		if err != nil {
+			return err
+		}
+		dhcpOptionsID, err := t.ReadVar(e.DHCPOptions)
+		if err != nil {
+			return err
+		}
+
+		currentID, err := t.ReadVar(e)
+		if err != nil {
+			return err
+		}
 
-		t.AddEC2Command("associate-dhcp-options", "--dhcp-options-id", dhcpOptionsID, "--vpc-id", vpcID)
+		// Every VPC has DHCP options, so we check they are the ones we want
+		t.AddEC2Command("describe-vpcs", "--vpc-ids", vpcID, "--query", "Vpcs[0].DhcpOptionsId").AssignTo(e)
+		t.AddEC2Command("associate-dhcp-options", "--dhcp-options-id", dhcpOptionsID, "--vpc-id", vpcID).If("[[ " + currentID + " != " + dhcpOptionsID + " ]]")
 	} else {
-		//t.AddAssignment(e, StringValue(a.ID))
+		//t.AddAssignment(e, units.StringValue(a.ID))
 	}
 
 	return nil // no tags
 }
+
+type terraformVPCDHCPOptionsAssociation struct {
+	VPCID         *string `json:"vpc_id,omitempty"`
+	DHCPOptionsID *string `json:"dhcp_options_id,omitempty"`
+}
+
+func (_ *VPCDHCPOptionsAssociation) RenderTerraform(t *fi.TerraformTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*VPCDHCPOptionsAssociation)
+
+	tf := &terraformVPCDHCPOptionsAssociation{
+		VPCID:         e.VPC.TerraformLink(),
+		DHCPOptionsID: e.DHCPOptions.TerraformLink(),
+	}
+	return t.RenderResource("aws_vpc_dhcp_options_association", e.Key(), tf)
+}
+
+type cloudformationVPCDHCPOptionsAssociation struct {
+	VPCID         interface{} `json:"VpcId,omitempty"`
+	DHCPOptionsID interface{} `json:"DhcpOptionsId,omitempty"`
+}
+
+func (_ *VPCDHCPOptionsAssociation) RenderCloudformation(t *fi.CloudFormationTarget, ua, ue, uchanges fi.Unit) error {
+	e := ue.(*VPCDHCPOptionsAssociation)
+
+	cf := &cloudformationVPCDHCPOptionsAssociation{
+		VPCID:         e.VPC.CloudformationLink(),
+		DHCPOptionsID: e.DHCPOptions.CloudformationLink(),
+	}
+	return t.RenderResource("AWS::EC2::VPCDHCPOptionsAssociation", e.Key(), cf)
+}
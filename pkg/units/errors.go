@@ -1,4 +1,4 @@
-package awsunits
+package units
 
 import "fmt"
 
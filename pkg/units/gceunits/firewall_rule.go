@@ -0,0 +1,174 @@
+package gceunits
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
+	"google.golang.org/api/compute/v1"
+)
+
+type FirewallRule struct {
+	fi.SimpleUnit
+
+	Name         *string
+	Network      *Network
+	SourceTags   []string
+	SourceRanges []string
+	TargetTags   []string
+	// Allowed is a list of protocol or protocol:ports values, e.g. tcp:22 or icmp
+	Allowed []string
+}
+
+func (e *FirewallRule) Key() string {
+	return *e.Name
+}
+
+func (e *FirewallRule) GetID() *string {
+	return e.Name
+}
+
+func (e *FirewallRule) String() string {
+	return units.JsonString(e)
+}
+
+func (e *FirewallRule) find(c *fi.RunContext) (*FirewallRule, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
+	cloud := c.Cloud().(*fi.GCECloud)
+
+	r, err := cloud.Compute.Firewalls.Get(cloud.Project, *e.Name).Do()
+	if err != nil {
+		if fi.IsGCENotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting FirewallRule %q: %v", *e.Name, err)
+	}
+
+	glog.V(2).Infof("found existing FirewallRule %q", r.Name)
+	actual := &FirewallRule{}
+	actual.Name = &r.Name
+	actual.Network = &Network{Name: units.String(fi.LastComponent(r.Network))}
+	actual.SourceTags = sortedCopy(r.SourceTags)
+	actual.SourceRanges = sortedCopy(r.SourceRanges)
+	actual.TargetTags = sortedCopy(r.TargetTags)
+	for _, allowed := range r.Allowed {
+		if len(allowed.Ports) == 0 {
+			actual.Allowed = append(actual.Allowed, allowed.IPProtocol)
+		} else {
+			for _, ports := range allowed.Ports {
+				actual.Allowed = append(actual.Allowed, allowed.IPProtocol+":"+ports)
+			}
+		}
+	}
+	actual.Allowed = sortedCopy(actual.Allowed)
+	return actual, nil
+}
+
+func (e *FirewallRule) Run(c *fi.RunContext) error {
+	e.SourceTags = sortedCopy(e.SourceTags)
+	e.SourceRanges = sortedCopy(e.SourceRanges)
+	e.TargetTags = sortedCopy(e.TargetTags)
+	e.Allowed = sortedCopy(e.Allowed)
+
+	a, err := e.find(c)
+	if err != nil {
+		return err
+	}
+
+	changes := &FirewallRule{}
+	changed := units.BuildChanges(a, e, changes)
+	if !changed {
+		return nil
+	}
+
+	err = e.checkChanges(a, e, changes)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(a, e, changes)
+}
+
+func (s *FirewallRule) checkChanges(a, e, changes *FirewallRule) error {
+	if a == nil {
+		if e.Network == nil {
+			return units.MissingValueError("Network must be specified when creating a FirewallRule")
+		}
+		if len(e.Allowed) == 0 {
+			return units.MissingValueError("Allowed must be specified when creating a FirewallRule")
+		}
+	}
+	if a != nil {
+		if changes.Network != nil {
+			return units.InvalidChangeError("Cannot change FirewallRule Network", changes.Network, e.Network)
+		}
+	}
+	return nil
+}
+
+func (e *FirewallRule) buildFirewall(project string) *compute.Firewall {
+	firewall := &compute.Firewall{
+		Name:         *e.Name,
+		Network:      e.Network.URL(project),
+		SourceTags:   e.SourceTags,
+		SourceRanges: e.SourceRanges,
+		TargetTags:   e.TargetTags,
+	}
+
+	// Group the ports by protocol, as GCE expects
+	byProtocol := make(map[string]*compute.FirewallAllowed)
+	for _, allowed := range e.Allowed {
+		protocol := allowed
+		ports := ""
+		colon := strings.Index(allowed, ":")
+		if colon != -1 {
+			protocol = allowed[:colon]
+			ports = allowed[colon+1:]
+		}
+		fa := byProtocol[protocol]
+		if fa == nil {
+			fa = &compute.FirewallAllowed{IPProtocol: protocol}
+			byProtocol[protocol] = fa
+			firewall.Allowed = append(firewall.Allowed, fa)
+		}
+		if ports != "" {
+			fa.Ports = append(fa.Ports, ports)
+		}
+	}
+	return firewall
+}
+
+func (_ *FirewallRule) RenderGCE(t *fi.GCEAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*FirewallRule), ue.(*FirewallRule)
+
+	firewall := e.buildFirewall(t.Cloud.Project)
+
+	if a == nil {
+		glog.V(2).Infof("Creating FirewallRule with Name:%q", *e.Name)
+
+		op, err := t.Cloud.Compute.Firewalls.Insert(t.Cloud.Project, firewall).Do()
+		if err != nil {
+			return fmt.Errorf("error creating FirewallRule: %v", err)
+		}
+		if err := t.Cloud.WaitForOp(op); err != nil {
+			return fmt.Errorf("error creating FirewallRule: %v", err)
+		}
+	} else {
+		glog.V(2).Infof("Updating FirewallRule with Name:%q", *e.Name)
+
+		op, err := t.Cloud.Compute.Firewalls.Update(t.Cloud.Project, *e.Name, firewall).Do()
+		if err != nil {
+			return fmt.Errorf("error updating FirewallRule: %v", err)
+		}
+		if err := t.Cloud.WaitForOp(op); err != nil {
+			return fmt.Errorf("error updating FirewallRule: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,274 @@
+package gceunits
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
+	"google.golang.org/api/compute/v1"
+)
+
+type Instance struct {
+	fi.SimpleUnit
+
+	Name        *string
+	Zone        *string
+	MachineType *string
+	Image       *string
+	Network     *Network
+	// NetworkIP is the internal IP address; if not set GCE will assign one
+	NetworkIP    *string
+	IPAddress    *IPAddress
+	Tags         []string
+	CanIPForward *bool
+	// Scopes are the service account scopes, either as URLs or as aliases such as compute-rw
+	Scopes []string
+	// Disks are additional persistent disks to attach, keyed by device name
+	Disks    map[string]*PersistentDisk
+	Metadata map[string]fi.Resource
+}
+
+func (e *Instance) Key() string {
+	return *e.Name
+}
+
+func (e *Instance) GetID() *string {
+	return e.Name
+}
+
+func (e *Instance) String() string {
+	return units.JsonString(e)
+}
+
+func (e *Instance) find(c *fi.RunContext) (*Instance, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
+	cloud := c.Cloud().(*fi.GCECloud)
+
+	r, err := cloud.Compute.Instances.Get(cloud.Project, *e.Zone, *e.Name).Do()
+	if err != nil {
+		if fi.IsGCENotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting Instance %q: %v", *e.Name, err)
+	}
+
+	glog.V(2).Infof("found existing Instance %q", r.Name)
+	actual := &Instance{}
+	actual.Name = &r.Name
+	actual.Zone = units.String(fi.LastComponent(r.Zone))
+	actual.MachineType = units.String(fi.LastComponent(r.MachineType))
+	actual.CanIPForward = &r.CanIpForward
+
+	// The image is only recorded on the boot disk, and may be an alias, so we can't compare it
+	actual.Image = e.Image
+
+	if r.Tags != nil {
+		actual.Tags = sortedCopy(r.Tags.Items)
+	}
+
+	for _, ni := range r.NetworkInterfaces {
+		actual.Network = &Network{Name: units.String(fi.LastComponent(ni.Network))}
+		actual.NetworkIP = units.String(ni.NetworkIP)
+		for _, ac := range ni.AccessConfigs {
+			if ac.NatIP == "" {
+				continue
+			}
+			if e.IPAddress != nil && e.IPAddress.Address != nil && *e.IPAddress.Address == ac.NatIP {
+				actual.IPAddress = e.IPAddress
+			} else {
+				actual.IPAddress = &IPAddress{Address: units.String(ac.NatIP)}
+			}
+		}
+	}
+
+	for _, disk := range r.Disks {
+		if disk.Boot {
+			continue
+		}
+		if actual.Disks == nil {
+			actual.Disks = make(map[string]*PersistentDisk)
+		}
+		actual.Disks[disk.DeviceName] = &PersistentDisk{Name: units.String(fi.LastComponent(disk.Source))}
+	}
+
+	for _, sa := range r.ServiceAccounts {
+		for _, scope := range sa.Scopes {
+			actual.Scopes = append(actual.Scopes, scopeFromURL(scope))
+		}
+	}
+	actual.Scopes = sortedCopy(actual.Scopes)
+
+	actual.Metadata = metadataFromGCE(r.Metadata)
+
+	return actual, nil
+}
+
+func (e *Instance) Run(c *fi.RunContext) error {
+	e.Tags = sortedCopy(e.Tags)
+	e.Scopes = sortedCopy(e.Scopes)
+
+	a, err := e.find(c)
+	if err != nil {
+		return err
+	}
+
+	changes := &Instance{}
+	changed := units.BuildChanges(a, e, changes)
+	if !changed {
+		return nil
+	}
+
+	err = e.checkChanges(a, e, changes)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(a, e, changes)
+}
+
+func (s *Instance) checkChanges(a, e, changes *Instance) error {
+	if a == nil {
+		if e.Zone == nil {
+			return units.MissingValueError("Zone must be specified when creating an Instance")
+		}
+		if e.MachineType == nil {
+			return units.MissingValueError("MachineType must be specified when creating an Instance")
+		}
+		if e.Image == nil {
+			return units.MissingValueError("Image must be specified when creating an Instance")
+		}
+		if e.Network == nil {
+			return units.MissingValueError("Network must be specified when creating an Instance")
+		}
+	}
+	if a != nil {
+		if changes.Zone != nil {
+			return units.InvalidChangeError("Cannot change Instance Zone", changes.Zone, e.Zone)
+		}
+	}
+	return nil
+}
+
+func (e *Instance) buildInstance(project string) (*compute.Instance, error) {
+	metadata, err := buildMetadata(e.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &compute.Instance{
+		Name:         *e.Name,
+		MachineType:  zonalURL(project, *e.Zone, "machineTypes", *e.MachineType),
+		CanIpForward: units.BoolValue(e.CanIPForward),
+		Metadata:     metadata,
+		Tags:         &compute.Tags{Items: e.Tags},
+	}
+
+	i.Disks = append(i.Disks, &compute.AttachedDisk{
+		Boot:       true,
+		AutoDelete: true,
+		Type:       "PERSISTENT",
+		Mode:       "READ_WRITE",
+		InitializeParams: &compute.AttachedDiskInitializeParams{
+			SourceImage: *e.Image,
+		},
+	})
+
+	// Attach the disks in a stable order
+	var deviceNames []string
+	for deviceName := range e.Disks {
+		deviceNames = append(deviceNames, deviceName)
+	}
+	sort.Strings(deviceNames)
+	for _, deviceName := range deviceNames {
+		i.Disks = append(i.Disks, &compute.AttachedDisk{
+			DeviceName: deviceName,
+			Source:     e.Disks[deviceName].URL(project),
+			Type:       "PERSISTENT",
+			Mode:       "READ_WRITE",
+		})
+	}
+
+	ni := &compute.NetworkInterface{
+		Network:   e.Network.URL(project),
+		NetworkIP: units.StringValue(e.NetworkIP),
+	}
+	ac := &compute.AccessConfig{
+		Name: "external-nat",
+		Type: "ONE_TO_ONE_NAT",
+	}
+	if e.IPAddress != nil {
+		ac.NatIP = units.StringValue(e.IPAddress.Address)
+	}
+	ni.AccessConfigs = append(ni.AccessConfigs, ac)
+	i.NetworkInterfaces = append(i.NetworkInterfaces, ni)
+
+	if len(e.Scopes) != 0 {
+		sa := &compute.ServiceAccount{Email: "default"}
+		for _, scope := range e.Scopes {
+			sa.Scopes = append(sa.Scopes, scopeToURL(scope))
+		}
+		i.ServiceAccounts = append(i.ServiceAccounts, sa)
+	}
+
+	return i, nil
+}
+
+func (_ *Instance) RenderGCE(t *fi.GCEAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*Instance), ue.(*Instance)
+
+	if a == nil {
+		glog.V(2).Infof("Creating Instance with Name:%q", *e.Name)
+
+		i, err := e.buildInstance(t.Cloud.Project)
+		if err != nil {
+			return err
+		}
+		op, err := t.Cloud.Compute.Instances.Insert(t.Cloud.Project, *e.Zone, i).Do()
+		if err != nil {
+			return fmt.Errorf("error creating Instance: %v", err)
+		}
+		if err := t.Cloud.WaitForOp(op); err != nil {
+			return fmt.Errorf("error creating Instance: %v", err)
+		}
+	} else {
+		// Like the AWS Instance, we don't reconfigure a running instance
+		glog.Warningf("Instance %q already exists; ignoring changes: %s", *e.Name, units.DebugPrint(uchanges))
+	}
+
+	return nil
+}
+
+// buildMetadata converts metadata resources into the GCE form, sorted by key so the result is stable
+func buildMetadata(metadata map[string]fi.Resource) (*compute.Metadata, error) {
+	var keys []string
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	m := &compute.Metadata{}
+	for _, k := range keys {
+		v, err := fi.ResourceAsString(metadata[k])
+		if err != nil {
+			return nil, fmt.Errorf("error reading metadata %q: %v", k, err)
+		}
+		m.Items = append(m.Items, &compute.MetadataItems{Key: k, Value: units.String(v)})
+	}
+	return m, nil
+}
+
+func metadataFromGCE(m *compute.Metadata) map[string]fi.Resource {
+	if m == nil || len(m.Items) == 0 {
+		return nil
+	}
+	metadata := make(map[string]fi.Resource)
+	for _, item := range m.Items {
+		metadata[item.Key] = fi.NewStringResource(units.StringValue(item.Value))
+	}
+	return metadata
+}
@@ -0,0 +1,174 @@
+package gceunits
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
+	"google.golang.org/api/compute/v1"
+)
+
+// InstanceTemplate is the GCE equivalent of a LaunchConfiguration.  Templates can't be changed once created,
+// so the name is the NamePrefix plus a hash of the configuration, and a changed configuration is a new template.
+type InstanceTemplate struct {
+	fi.SimpleUnit
+
+	NamePrefix *string
+	// Name is computed from NamePrefix and the configuration
+	Name *string
+
+	MachineType  *string
+	Image        *string
+	Network      *Network
+	Tags         []string
+	CanIPForward *bool
+	Scopes       []string
+	Metadata     map[string]fi.Resource
+}
+
+func (e *InstanceTemplate) Key() string {
+	return *e.NamePrefix
+}
+
+func (e *InstanceTemplate) GetID() *string {
+	return e.Name
+}
+
+func (e *InstanceTemplate) String() string {
+	return units.JsonString(e)
+}
+
+func (e *InstanceTemplate) URL(project string) string {
+	return globalURL(project, "instanceTemplates", *e.Name)
+}
+
+func (e *InstanceTemplate) find(c *fi.RunContext) (*InstanceTemplate, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
+	cloud := c.Cloud().(*fi.GCECloud)
+
+	r, err := cloud.Compute.InstanceTemplates.Get(cloud.Project, *e.Name).Do()
+	if err != nil {
+		if fi.IsGCENotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting InstanceTemplate %q: %v", *e.Name, err)
+	}
+
+	glog.V(2).Infof("found existing InstanceTemplate %q", r.Name)
+
+	// The name is derived from the configuration, so a template with the same name has the same configuration
+	actual := &InstanceTemplate{}
+	*actual = *e
+	actual.Name = &r.Name
+	return actual, nil
+}
+
+func (e *InstanceTemplate) Run(c *fi.RunContext) error {
+	e.Tags = sortedCopy(e.Tags)
+	e.Scopes = sortedCopy(e.Scopes)
+
+	if e.Name == nil {
+		if e.NamePrefix == nil || e.Network == nil || e.MachineType == nil || e.Image == nil {
+			return units.MissingValueError("NamePrefix, Network, MachineType and Image must be specified for an InstanceTemplate")
+		}
+
+		cloud := c.Cloud().(*fi.GCECloud)
+		properties, err := e.buildProperties(cloud.Project)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(properties)
+		if err != nil {
+			return fmt.Errorf("error serializing InstanceTemplate: %v", err)
+		}
+		hash := sha256.Sum256(data)
+		e.Name = units.String(*e.NamePrefix + "-" + hex.EncodeToString(hash[:])[:12])
+	}
+
+	a, err := e.find(c)
+	if err != nil {
+		return err
+	}
+
+	changes := &InstanceTemplate{}
+	changed := units.BuildChanges(a, e, changes)
+	if !changed {
+		return nil
+	}
+
+	return c.Render(a, e, changes)
+}
+
+func (e *InstanceTemplate) buildProperties(project string) (*compute.InstanceProperties, error) {
+	metadata, err := buildMetadata(e.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &compute.InstanceProperties{
+		MachineType:  *e.MachineType,
+		CanIpForward: units.BoolValue(e.CanIPForward),
+		Metadata:     metadata,
+		Tags:         &compute.Tags{Items: e.Tags},
+	}
+
+	p.Disks = append(p.Disks, &compute.AttachedDisk{
+		Boot:       true,
+		AutoDelete: true,
+		Type:       "PERSISTENT",
+		Mode:       "READ_WRITE",
+		InitializeParams: &compute.AttachedDiskInitializeParams{
+			SourceImage: *e.Image,
+		},
+	})
+
+	p.NetworkInterfaces = append(p.NetworkInterfaces, &compute.NetworkInterface{
+		Network: e.Network.URL(project),
+		AccessConfigs: []*compute.AccessConfig{
+			{Name: "external-nat", Type: "ONE_TO_ONE_NAT"},
+		},
+	})
+
+	if len(e.Scopes) != 0 {
+		sa := &compute.ServiceAccount{Email: "default"}
+		for _, scope := range e.Scopes {
+			sa.Scopes = append(sa.Scopes, scopeToURL(scope))
+		}
+		p.ServiceAccounts = append(p.ServiceAccounts, sa)
+	}
+
+	return p, nil
+}
+
+func (_ *InstanceTemplate) RenderGCE(t *fi.GCEAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*InstanceTemplate), ue.(*InstanceTemplate)
+
+	if a == nil {
+		glog.V(2).Infof("Creating InstanceTemplate with Name:%q", *e.Name)
+
+		properties, err := e.buildProperties(t.Cloud.Project)
+		if err != nil {
+			return err
+		}
+		template := &compute.InstanceTemplate{
+			Name:       *e.Name,
+			Properties: properties,
+		}
+		op, err := t.Cloud.Compute.InstanceTemplates.Insert(t.Cloud.Project, template).Do()
+		if err != nil {
+			return fmt.Errorf("error creating InstanceTemplate: %v", err)
+		}
+		if err := t.Cloud.WaitForOp(op); err != nil {
+			return fmt.Errorf("error creating InstanceTemplate: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package gceunits
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
+	"github.com/kopeio/kope/pkg/units/k8sunits"
+	"google.golang.org/api/compute/v1"
+)
+
+// IPAddress is a static external IP address, the GCE equivalent of an ElasticIP
+type IPAddress struct {
+	fi.SimpleUnit
+
+	Name    *string
+	Address *string
+}
+
+var _ k8sunits.MasterPublicIP = &IPAddress{}
+
+func (e *IPAddress) Key() string {
+	return *e.Name
+}
+
+func (e *IPAddress) GetID() *string {
+	return e.Name
+}
+
+func (e *IPAddress) String() string {
+	return units.JsonString(e)
+}
+
+func (e *IPAddress) find(c *fi.RunContext) (*IPAddress, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
+	cloud := c.Cloud().(*fi.GCECloud)
+
+	r, err := cloud.Compute.Addresses.Get(cloud.Project, cloud.Region, *e.Name).Do()
+	if err != nil {
+		if fi.IsGCENotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting IPAddress %q: %v", *e.Name, err)
+	}
+
+	glog.V(2).Infof("found existing IPAddress %q", r.Name)
+	actual := &IPAddress{}
+	actual.Name = &r.Name
+	actual.Address = &r.Address
+
+	// Copy the address, so that units that depend on us can use it
+	e.Address = actual.Address
+
+	return actual, nil
+}
+
+func (e *IPAddress) FindPublicIP(c *fi.RunContext) (*string, error) {
+	if c.IsDeclarative() {
+		return e.Address, nil
+	}
+	actual, err := e.find(c)
+	if err != nil || actual == nil {
+		return nil, err
+	}
+	return actual.Address, nil
+}
+
+func (e *IPAddress) Run(c *fi.RunContext) error {
+	a, err := e.find(c)
+	if err != nil {
+		return err
+	}
+
+	// The address is assigned by GCE
+	if a != nil && e.Address == nil {
+		e.Address = a.Address
+	}
+
+	changes := &IPAddress{}
+	changed := units.BuildChanges(a, e, changes)
+	if !changed {
+		return nil
+	}
+
+	err = e.checkChanges(a, e, changes)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(a, e, changes)
+}
+
+func (s *IPAddress) checkChanges(a, e, changes *IPAddress) error {
+	if a != nil {
+		if changes.Address != nil {
+			return units.InvalidChangeError("Cannot change IPAddress Address", changes.Address, e.Address)
+		}
+	}
+	return nil
+}
+
+func (_ *IPAddress) RenderGCE(t *fi.GCEAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*IPAddress), ue.(*IPAddress)
+
+	if a == nil {
+		glog.V(2).Infof("Creating IPAddress with Name:%q", *e.Name)
+
+		// If Address is set, we reserve that (ephemeral) address
+		address := &compute.Address{
+			Name:    *e.Name,
+			Address: units.StringValue(e.Address),
+		}
+		op, err := t.Cloud.Compute.Addresses.Insert(t.Cloud.Project, t.Cloud.Region, address).Do()
+		if err != nil {
+			return fmt.Errorf("error creating IPAddress: %v", err)
+		}
+		if err := t.Cloud.WaitForOp(op); err != nil {
+			return fmt.Errorf("error creating IPAddress: %v", err)
+		}
+
+		r, err := t.Cloud.Compute.Addresses.Get(t.Cloud.Project, t.Cloud.Region, *e.Name).Do()
+		if err != nil {
+			return fmt.Errorf("error reading created IPAddress: %v", err)
+		}
+		e.Address = &r.Address
+	}
+
+	return nil
+}
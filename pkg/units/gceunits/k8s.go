@@ -0,0 +1,154 @@
+package gceunits
+
+import (
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
+	"github.com/kopeio/kope/pkg/units/k8sunits"
+)
+
+// DefaultImage is the image we use when ImageID is not set; it is the image used by kube-up on GCE
+const DefaultImage = "https://www.googleapis.com/compute/v1/projects/google-containers/global/images/container-v1-3-v20160604"
+
+func init() {
+	k8sunits.RegisterCloudProvider("gce", addK8sUnits)
+}
+
+// addK8sUnits adds the units for a cluster on GCE
+func addK8sUnits(k *k8sunits.K8s, c *fi.BuildContext) {
+	clusterID := k.ClusterID
+
+	if k.ImageID == "" {
+		k.ImageID = DefaultImage
+	}
+
+	network := &Network{
+		Name: units.String("kubernetes-" + clusterID),
+		CIDR: units.String("10.240.0.0/16"),
+	}
+	c.Add(network)
+
+	c.Add(&FirewallRule{
+		Name:         units.String(clusterID + "-default-internal"),
+		Network:      network,
+		SourceRanges: []string{"10.0.0.0/8"},
+		Allowed:      []string{"icmp", "tcp:1-65535", "udp:1-65535"},
+	})
+
+	// SSH is open to the world
+	c.Add(&FirewallRule{
+		Name:         units.String(clusterID + "-default-ssh"),
+		Network:      network,
+		SourceRanges: []string{"0.0.0.0/0"},
+		Allowed:      []string{"tcp:22"},
+	})
+
+	// HTTPS to the master is allowed (for API access)
+	c.Add(&FirewallRule{
+		Name:         units.String(k.MasterName + "-https"),
+		Network:      network,
+		SourceRanges: []string{"0.0.0.0/0"},
+		TargetTags:   []string{k.MasterName},
+		Allowed:      []string{"tcp:443"},
+	})
+
+	// Pods can talk to the nodes
+	c.Add(&FirewallRule{
+		Name:         units.String(k.NodeInstancePrefix + "-all"),
+		Network:      network,
+		SourceRanges: []string{k.ClusterIPRange},
+		TargetTags:   []string{k.NodeInstancePrefix},
+		Allowed:      []string{"tcp", "udp", "icmp", "esp", "ah", "sctp"},
+	})
+
+	masterVolumeSize := k8sunits.DefaultMasterVolumeSize
+	if k.MasterVolumeSize != nil {
+		masterVolumeSize = *k.MasterVolumeSize
+	}
+	masterPD := &PersistentDisk{
+		Name:       units.String(k.MasterName + "-pd"),
+		Zone:       units.String(k.Zone),
+		VolumeType: units.String(k.MasterVolumeType),
+		SizeGB:     units.Int64(int64(masterVolumeSize)),
+	}
+	c.Add(masterPD)
+
+	masterIP := &IPAddress{
+		Name:    units.String(k.MasterName + "-ip"),
+		Address: k.MasterElasticIP,
+	}
+	c.Add(masterIP)
+
+	certBuilder := &k8sunits.CertBuilder{Kubernetes: k, MasterIP: masterIP}
+	c.Add(certBuilder)
+
+	masterKubeEnv := &k8sunits.KubeEnv{Config: k, Certificates: certBuilder, IsMaster: true}
+	c.Add(masterKubeEnv)
+
+	nodeKubeEnv := &k8sunits.KubeEnv{Config: k, Certificates: certBuilder, IsMaster: false}
+	c.Add(nodeKubeEnv)
+
+	// GCE doesn't have key pairs; the key is added to the instance metadata instead
+	var sshKeys fi.Resource
+	if k.SSHPublicKey != nil {
+		sshPublicKey, err := fi.ResourceAsString(k.SSHPublicKey)
+		if err != nil {
+			glog.Exitf("error reading SSH public key: %v", err)
+		}
+		sshKeys = fi.NewStringResource(k.KubeUser + ":" + strings.TrimSpace(sshPublicKey))
+	}
+
+	masterMetadata := map[string]fi.Resource{
+		"startup-script": k.BootstrapScript,
+		"kube-env":       masterKubeEnv,
+		"cluster-name":   fi.NewStringResource(clusterID),
+	}
+	nodeMetadata := map[string]fi.Resource{
+		"startup-script": k.BootstrapScript,
+		"kube-env":       nodeKubeEnv,
+		"cluster-name":   fi.NewStringResource(clusterID),
+	}
+	if sshKeys != nil {
+		masterMetadata["ssh-keys"] = sshKeys
+		nodeMetadata["ssh-keys"] = sshKeys
+	}
+
+	masterInstance := &Instance{
+		Name:         units.String(k.MasterName),
+		Zone:         units.String(k.Zone),
+		MachineType:  units.String(k.MasterInstanceType),
+		Image:        units.String(k.ImageID),
+		Network:      network,
+		NetworkIP:    units.String(k.MasterInternalIP),
+		IPAddress:    masterIP,
+		Tags:         []string{k.MasterName},
+		CanIPForward: units.Bool(true),
+		Scopes:       []string{"storage-ro", "compute-rw", "logging-write", "monitoring"},
+		// configure-vm.sh expects the master PD at /dev/disk/by-id/google-master-pd
+		Disks:    map[string]*PersistentDisk{"master-pd": masterPD},
+		Metadata: masterMetadata,
+	}
+	c.Add(masterInstance)
+
+	nodeTemplate := &InstanceTemplate{
+		NamePrefix:   units.String(k.NodeInstancePrefix + "-template"),
+		MachineType:  units.String(k.NodeInstanceType),
+		Image:        units.String(k.ImageID),
+		Network:      network,
+		Tags:         []string{k.NodeInstancePrefix},
+		CanIPForward: units.Bool(true),
+		Scopes:       []string{"compute-rw", "monitoring", "logging-write", "storage-ro"},
+		Metadata:     nodeMetadata,
+	}
+	c.Add(nodeTemplate)
+
+	c.Add(&ManagedInstanceGroup{
+		Name:             units.String(k.NodeInstancePrefix + "-group"),
+		Zone:             units.String(k.Zone),
+		BaseInstanceName: units.String(k.NodeInstancePrefix),
+		InstanceTemplate: nodeTemplate,
+		TargetSize:       units.Int64(int64(k.NodeCount)),
+	})
+}
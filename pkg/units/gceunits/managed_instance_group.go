@@ -0,0 +1,157 @@
+package gceunits
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
+	"google.golang.org/api/compute/v1"
+)
+
+// ManagedInstanceGroup is the GCE equivalent of an AutoscalingGroup
+type ManagedInstanceGroup struct {
+	fi.SimpleUnit
+
+	Name             *string
+	Zone             *string
+	BaseInstanceName *string
+	InstanceTemplate *InstanceTemplate
+	TargetSize       *int64
+}
+
+func (e *ManagedInstanceGroup) Key() string {
+	return *e.Name
+}
+
+func (e *ManagedInstanceGroup) GetID() *string {
+	return e.Name
+}
+
+func (e *ManagedInstanceGroup) String() string {
+	return units.JsonString(e)
+}
+
+func (e *ManagedInstanceGroup) find(c *fi.RunContext) (*ManagedInstanceGroup, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
+	cloud := c.Cloud().(*fi.GCECloud)
+
+	r, err := cloud.Compute.InstanceGroupManagers.Get(cloud.Project, *e.Zone, *e.Name).Do()
+	if err != nil {
+		if fi.IsGCENotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting ManagedInstanceGroup %q: %v", *e.Name, err)
+	}
+
+	glog.V(2).Infof("found existing ManagedInstanceGroup %q", r.Name)
+	actual := &ManagedInstanceGroup{}
+	actual.Name = &r.Name
+	actual.Zone = units.String(fi.LastComponent(r.Zone))
+	actual.BaseInstanceName = &r.BaseInstanceName
+	actual.InstanceTemplate = &InstanceTemplate{Name: units.String(fi.LastComponent(r.InstanceTemplate))}
+	actual.TargetSize = &r.TargetSize
+	return actual, nil
+}
+
+func (e *ManagedInstanceGroup) Run(c *fi.RunContext) error {
+	a, err := e.find(c)
+	if err != nil {
+		return err
+	}
+
+	changes := &ManagedInstanceGroup{}
+	changed := units.BuildChanges(a, e, changes)
+	if !changed {
+		return nil
+	}
+
+	err = e.checkChanges(a, e, changes)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(a, e, changes)
+}
+
+func (s *ManagedInstanceGroup) checkChanges(a, e, changes *ManagedInstanceGroup) error {
+	if a == nil {
+		if e.Zone == nil {
+			return units.MissingValueError("Zone must be specified when creating a ManagedInstanceGroup")
+		}
+		if e.InstanceTemplate == nil {
+			return units.MissingValueError("InstanceTemplate must be specified when creating a ManagedInstanceGroup")
+		}
+		if e.BaseInstanceName == nil {
+			return units.MissingValueError("BaseInstanceName must be specified when creating a ManagedInstanceGroup")
+		}
+		if e.TargetSize == nil {
+			return units.MissingValueError("TargetSize must be specified when creating a ManagedInstanceGroup")
+		}
+	}
+	if a != nil {
+		if changes.Zone != nil {
+			return units.InvalidChangeError("Cannot change ManagedInstanceGroup Zone", changes.Zone, e.Zone)
+		}
+		if changes.BaseInstanceName != nil {
+			return units.InvalidChangeError("Cannot change ManagedInstanceGroup BaseInstanceName", changes.BaseInstanceName, e.BaseInstanceName)
+		}
+	}
+	return nil
+}
+
+func (_ *ManagedInstanceGroup) RenderGCE(t *fi.GCEAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e, changes := ua.(*ManagedInstanceGroup), ue.(*ManagedInstanceGroup), uchanges.(*ManagedInstanceGroup)
+
+	project := t.Cloud.Project
+
+	if a == nil {
+		glog.V(2).Infof("Creating ManagedInstanceGroup with Name:%q", *e.Name)
+
+		mig := &compute.InstanceGroupManager{
+			Name:             *e.Name,
+			BaseInstanceName: *e.BaseInstanceName,
+			InstanceTemplate: e.InstanceTemplate.URL(project),
+			TargetSize:       *e.TargetSize,
+		}
+		op, err := t.Cloud.Compute.InstanceGroupManagers.Insert(project, *e.Zone, mig).Do()
+		if err != nil {
+			return fmt.Errorf("error creating ManagedInstanceGroup: %v", err)
+		}
+		if err := t.Cloud.WaitForOp(op); err != nil {
+			return fmt.Errorf("error creating ManagedInstanceGroup: %v", err)
+		}
+		return nil
+	}
+
+	if changes.InstanceTemplate != nil {
+		// Only new instances will use the template; existing instances must be recreated to pick it up
+		glog.V(2).Infof("Setting InstanceTemplate on ManagedInstanceGroup %q to %q", *e.Name, *e.InstanceTemplate.Name)
+		request := &compute.InstanceGroupManagersSetInstanceTemplateRequest{
+			InstanceTemplate: e.InstanceTemplate.URL(project),
+		}
+		op, err := t.Cloud.Compute.InstanceGroupManagers.SetInstanceTemplate(project, *e.Zone, *e.Name, request).Do()
+		if err != nil {
+			return fmt.Errorf("error setting InstanceTemplate on ManagedInstanceGroup: %v", err)
+		}
+		if err := t.Cloud.WaitForOp(op); err != nil {
+			return fmt.Errorf("error setting InstanceTemplate on ManagedInstanceGroup: %v", err)
+		}
+	}
+
+	if changes.TargetSize != nil {
+		glog.V(2).Infof("Resizing ManagedInstanceGroup %q to %d", *e.Name, *e.TargetSize)
+		op, err := t.Cloud.Compute.InstanceGroupManagers.Resize(project, *e.Zone, *e.Name, *e.TargetSize).Do()
+		if err != nil {
+			return fmt.Errorf("error resizing ManagedInstanceGroup: %v", err)
+		}
+		if err := t.Cloud.WaitForOp(op); err != nil {
+			return fmt.Errorf("error resizing ManagedInstanceGroup: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package gceunits
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
+	"google.golang.org/api/compute/v1"
+)
+
+type Network struct {
+	fi.SimpleUnit
+
+	Name *string
+	CIDR *string
+}
+
+func (e *Network) Key() string {
+	return *e.Name
+}
+
+// GetID returns the name; in GCE the name identifies a resource
+func (e *Network) GetID() *string {
+	return e.Name
+}
+
+func (e *Network) String() string {
+	return units.JsonString(e)
+}
+
+func (e *Network) URL(project string) string {
+	return globalURL(project, "networks", *e.Name)
+}
+
+func (e *Network) find(c *fi.RunContext) (*Network, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
+	cloud := c.Cloud().(*fi.GCECloud)
+
+	r, err := cloud.Compute.Networks.Get(cloud.Project, *e.Name).Do()
+	if err != nil {
+		if fi.IsGCENotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting Network %q: %v", *e.Name, err)
+	}
+
+	glog.V(2).Infof("found existing Network %q", r.Name)
+	actual := &Network{}
+	actual.Name = &r.Name
+	if r.IPv4Range != "" {
+		actual.CIDR = &r.IPv4Range
+	}
+	return actual, nil
+}
+
+func (e *Network) Run(c *fi.RunContext) error {
+	a, err := e.find(c)
+	if err != nil {
+		return err
+	}
+
+	changes := &Network{}
+	changed := units.BuildChanges(a, e, changes)
+	if !changed {
+		return nil
+	}
+
+	err = e.checkChanges(a, e, changes)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(a, e, changes)
+}
+
+func (s *Network) checkChanges(a, e, changes *Network) error {
+	if a == nil {
+		if e.CIDR == nil {
+			return units.MissingValueError("CIDR must be specified when creating a Network")
+		}
+	}
+	if a != nil {
+		if changes.CIDR != nil {
+			return units.InvalidChangeError("Cannot change Network CIDR", changes.CIDR, e.CIDR)
+		}
+	}
+	return nil
+}
+
+func (_ *Network) RenderGCE(t *fi.GCEAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*Network), ue.(*Network)
+
+	if a == nil {
+		glog.V(2).Infof("Creating Network with Name:%q", *e.Name)
+
+		network := &compute.Network{
+			Name:      *e.Name,
+			IPv4Range: *e.CIDR,
+		}
+		op, err := t.Cloud.Compute.Networks.Insert(t.Cloud.Project, network).Do()
+		if err != nil {
+			return fmt.Errorf("error creating Network: %v", err)
+		}
+		if err := t.Cloud.WaitForOp(op); err != nil {
+			return fmt.Errorf("error creating Network: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package gceunits
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
+	"google.golang.org/api/compute/v1"
+)
+
+type PersistentDisk struct {
+	fi.SimpleUnit
+
+	Name       *string
+	Zone       *string
+	VolumeType *string
+	SizeGB     *int64
+}
+
+func (e *PersistentDisk) Key() string {
+	return *e.Name
+}
+
+func (e *PersistentDisk) GetID() *string {
+	return e.Name
+}
+
+func (e *PersistentDisk) String() string {
+	return units.JsonString(e)
+}
+
+func (e *PersistentDisk) URL(project string) string {
+	return zonalURL(project, *e.Zone, "disks", *e.Name)
+}
+
+func (e *PersistentDisk) find(c *fi.RunContext) (*PersistentDisk, error) {
+	if c.IsDeclarative() {
+		return nil, nil
+	}
+
+	cloud := c.Cloud().(*fi.GCECloud)
+
+	r, err := cloud.Compute.Disks.Get(cloud.Project, *e.Zone, *e.Name).Do()
+	if err != nil {
+		if fi.IsGCENotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting PersistentDisk %q: %v", *e.Name, err)
+	}
+
+	glog.V(2).Infof("found existing PersistentDisk %q", r.Name)
+	actual := &PersistentDisk{}
+	actual.Name = &r.Name
+	actual.Zone = units.String(fi.LastComponent(r.Zone))
+	actual.VolumeType = units.String(fi.LastComponent(r.Type))
+	actual.SizeGB = &r.SizeGb
+	return actual, nil
+}
+
+func (e *PersistentDisk) Run(c *fi.RunContext) error {
+	a, err := e.find(c)
+	if err != nil {
+		return err
+	}
+
+	changes := &PersistentDisk{}
+	changed := units.BuildChanges(a, e, changes)
+	if !changed {
+		return nil
+	}
+
+	err = e.checkChanges(a, e, changes)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(a, e, changes)
+}
+
+func (s *PersistentDisk) checkChanges(a, e, changes *PersistentDisk) error {
+	if a == nil {
+		if e.Zone == nil {
+			return units.MissingValueError("Zone must be specified when creating a PersistentDisk")
+		}
+		if e.SizeGB == nil {
+			return units.MissingValueError("SizeGB must be specified when creating a PersistentDisk")
+		}
+	}
+	if a != nil {
+		if changes.Zone != nil {
+			return units.InvalidChangeError("Cannot change PersistentDisk Zone", changes.Zone, e.Zone)
+		}
+		if changes.VolumeType != nil {
+			return units.InvalidChangeError("Cannot change PersistentDisk VolumeType", changes.VolumeType, e.VolumeType)
+		}
+		if changes.SizeGB != nil {
+			return units.InvalidChangeError("Cannot change PersistentDisk SizeGB", changes.SizeGB, e.SizeGB)
+		}
+	}
+	return nil
+}
+
+func (_ *PersistentDisk) RenderGCE(t *fi.GCEAPITarget, ua, ue, uchanges fi.Unit) error {
+	a, e := ua.(*PersistentDisk), ue.(*PersistentDisk)
+
+	if a == nil {
+		glog.V(2).Infof("Creating PersistentDisk with Name:%q", *e.Name)
+
+		disk := &compute.Disk{
+			Name:   *e.Name,
+			SizeGb: *e.SizeGB,
+		}
+		if e.VolumeType != nil {
+			disk.Type = zonalURL(t.Cloud.Project, *e.Zone, "diskTypes", *e.VolumeType)
+		}
+		op, err := t.Cloud.Compute.Disks.Insert(t.Cloud.Project, *e.Zone, disk).Do()
+		if err != nil {
+			return fmt.Errorf("error creating PersistentDisk: %v", err)
+		}
+		if err := t.Cloud.WaitForOp(op); err != nil {
+			return fmt.Errorf("error creating PersistentDisk: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package gceunits
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const computeURLPrefix = "https://www.googleapis.com/compute/v1/"
+const scopeURLPrefix = "https://www.googleapis.com/auth/"
+
+func globalURL(project string, collection string, name string) string {
+	return fmt.Sprintf("%sprojects/%s/global/%s/%s", computeURLPrefix, project, collection, name)
+}
+
+func zonalURL(project string, zone string, collection string, name string) string {
+	return fmt.Sprintf("%sprojects/%s/zones/%s/%s/%s", computeURLPrefix, project, zone, collection, name)
+}
+
+// scopeAliases are the short names for service account scopes, as accepted by gcloud
+var scopeAliases = map[string]string{
+	"compute-ro":    "compute.readonly",
+	"compute-rw":    "compute",
+	"logging-write": "logging.write",
+	"monitoring":    "monitoring",
+	"storage-ro":    "devstorage.read_only",
+	"storage-rw":    "devstorage.read_write",
+}
+
+// scopeToURL expands a scope alias (e.g. compute-rw) to the URL that GCE uses
+func scopeToURL(s string) string {
+	if strings.HasPrefix(s, "https://") {
+		return s
+	}
+	if alias, found := scopeAliases[s]; found {
+		s = alias
+	}
+	return scopeURLPrefix + s
+}
+
+// scopeFromURL is the reverse of scopeToURL, so that we can compare with the configured scopes
+func scopeFromURL(s string) string {
+	if !strings.HasPrefix(s, scopeURLPrefix) {
+		return s
+	}
+	name := strings.TrimPrefix(s, scopeURLPrefix)
+	for alias, v := range scopeAliases {
+		if v == name {
+			return alias
+		}
+	}
+	return name
+}
+
+// sortedCopy returns a sorted copy of the strings, so that we don't depend on the order GCE returns them in
+func sortedCopy(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	c := append([]string{}, s...)
+	sort.Strings(c)
+	return c
+}
@@ -0,0 +1,454 @@
+package k8sunits
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+	"net"
+	"reflect"
+	"sort"
+)
+
+type CertBuilder struct {
+	fi.SimpleUnit
+
+	Kubernetes *K8s
+	MasterIP   MasterPublicIP
+}
+
+// MasterPublicIP is implemented by the unit that allocates the public IP of the master (an ElasticIP on AWS,
+// a static IP on GCE), so that the IP can be included in the master certificate
+type MasterPublicIP interface {
+	fi.Unit
+
+	// FindPublicIP returns the IP, or nil if it has not been allocated.  Declarative targets don't
+	// look for existing resources, so only return the IP if it was configured.
+	FindPublicIP(c *fi.RunContext) (*string, error)
+}
+
+func (c *CertBuilder) Key() string {
+	return "certbuilder"
+}
+
+func buildCertificateAlternateNames(k8s *K8s) ([]string, error) {
+	var sans []string
+	apiServerIP, err := k8s.GetWellKnownServiceIP(1)
+	if err != nil {
+		return nil, err
+	}
+	sans = append(sans, apiServerIP.String())
+	for _, s := range k8s.MasterExtraSans {
+		sans = append(sans, s)
+	}
+	sans = append(sans, "kubernetes")
+	sans = append(sans, "kubernetes.default")
+	sans = append(sans, "kubernetes.default.svc")
+	sans = append(sans, "kubernetes.default.svc."+k8s.DNSDomain)
+
+	if k8s.MasterName != "" {
+		sans = append(sans, k8s.MasterName)
+	}
+
+	if k8s.MasterInternalIP != "" {
+		sans = append(sans, k8s.MasterInternalIP)
+	}
+
+	return sans, nil
+}
+
+func (b *CertBuilder) Run(c *fi.RunContext) error {
+	k8s := b.Kubernetes
+
+	certs := c.CAStore()
+
+	if k8s.CACert == nil {
+		// During a CA rotation we distribute both CAs
+		caCerts, err := certs.GetTrustedCACerts()
+		if err != nil {
+			return err
+		}
+
+		k8s.CACert = certsToResource(caCerts)
+	}
+
+	if k8s.CAKey == nil {
+		caKey, err := certs.FindCAKey()
+		if err != nil {
+			return err
+		}
+
+		if caKey != nil {
+			k8s.CAKey = keyToResource(caKey)
+		} else {
+			// We allow this for upgrades
+			glog.Warningf("CA key is not set")
+		}
+	}
+
+	kubecfgSubject := &pkix.Name{
+		CommonName: "kubecfg",
+	}
+
+	if k8s.KubecfgCert == nil {
+		kubecfgCert, err := certs.FindCert(kubecfgSubject)
+		if err != nil {
+			return err
+		}
+
+		if kubecfgCert == nil && c.IsValidate() {
+			// We don't issue certificates when validating
+			glog.Infof("certificate %q has not been issued", kubecfgSubject.CommonName)
+			c.MarkDirty()
+		} else if kubecfgCert == nil {
+			template := &x509.Certificate{
+				Subject:               *kubecfgSubject,
+				KeyUsage:              x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+				BasicConstraintsValid: true,
+				IsCA:                  false,
+			}
+
+			privateKey, err := certs.CreatePrivateKey(kubecfgSubject, "")
+			if err != nil {
+				return err
+			}
+			kubecfgCert, err = certs.IssueCert(privateKey, template)
+			if err != nil {
+				return err
+			}
+		}
+
+		if kubecfgCert != nil {
+			k8s.KubecfgCert = certToResource(kubecfgCert)
+		}
+	}
+
+	if k8s.KubecfgKey == nil {
+		key, err := certs.FindPrivateKey(kubecfgSubject)
+		if err != nil {
+			return err
+		}
+
+		if key == nil && c.IsValidate() {
+			c.MarkDirty()
+		} else if key == nil {
+			return fmt.Errorf("kubecfg key not found")
+		} else {
+			k8s.KubecfgKey = keyToResource(key)
+		}
+	}
+
+	kubeletSubject := &pkix.Name{
+		CommonName: "kubelet",
+	}
+
+	if k8s.KubeletCert == nil {
+		kubeletCert, err := certs.FindCert(kubeletSubject)
+		if err != nil {
+			return err
+		}
+
+		if kubeletCert == nil && c.IsValidate() {
+			// We don't issue certificates when validating
+			glog.Infof("certificate %q has not been issued", kubeletSubject.CommonName)
+			c.MarkDirty()
+		} else if kubeletCert == nil {
+			template := &x509.Certificate{
+				Subject:               *kubeletSubject,
+				KeyUsage:              x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+				BasicConstraintsValid: true,
+				IsCA:                  false,
+			}
+
+			privateKey, err := certs.CreatePrivateKey(kubeletSubject, "")
+			if err != nil {
+				return err
+			}
+			kubeletCert, err = certs.IssueCert(privateKey, template)
+			if err != nil {
+				return err
+			}
+		}
+
+		if kubeletCert != nil {
+			k8s.KubeletCert = certToResource(kubeletCert)
+		}
+	}
+
+	if k8s.KubeletKey == nil {
+		key, err := certs.FindPrivateKey(kubeletSubject)
+		if err != nil {
+			return err
+		}
+
+		if key == nil && c.IsValidate() {
+			c.MarkDirty()
+		} else if key == nil {
+			return fmt.Errorf("kubelet key not found")
+		} else {
+			k8s.KubeletKey = keyToResource(key)
+		}
+	}
+
+	masterSubject := &pkix.Name{
+		CommonName: "kubernetes-master",
+	}
+
+	if k8s.MasterCert == nil {
+		masterCert, err := certs.FindCert(masterSubject)
+		if err != nil {
+			return err
+		}
+
+		if masterCert == nil && c.IsValidate() {
+			// We don't issue certificates when validating
+			glog.Infof("certificate %q has not been issued", masterSubject.CommonName)
+			c.MarkDirty()
+		} else if masterCert == nil {
+			alternateNames, complete, err := b.buildMasterAlternateNames(c)
+			if err != nil {
+				return err
+			}
+			_, isDryRun := c.Target.(*fi.DryRunTarget)
+			if !complete && isDryRun {
+				// The IP is allocated, and the certificate issued, when the plan is applied
+				err = renderPendingMasterCert(c, alternateNames)
+				if err != nil {
+					return err
+				}
+				k8s.MasterCert = fi.NewPendingResource("master certificate")
+				k8s.MasterKey = fi.NewPendingResource("master key")
+			} else {
+				if !complete {
+					_, isBash := c.Target.(*fi.BashTarget)
+					if !c.IsDeclarative() && !isBash {
+						return fmt.Errorf("cannot build SANs for master cert until master Public IP is allocated")
+					}
+					// The IP will only be allocated when the configuration (or script) is applied; the certificate
+					// is reissued with the IP by the next run against the cloud
+					glog.Warningf("master public IP is not yet known; it will not be included in the master certificate")
+				}
+
+				privateKey, err := certs.CreatePrivateKey(masterSubject, fi.ServiceAccountKeyAlgorithm)
+				if err != nil {
+					return err
+				}
+				masterCert, err = issueMasterCert(certs, masterSubject, privateKey, alternateNames)
+				if err != nil {
+					return err
+				}
+			}
+		} else {
+			masterCert, err = b.checkMasterCertSANs(c, masterSubject, masterCert)
+			if err != nil {
+				return err
+			}
+		}
+
+		if masterCert != nil {
+			k8s.MasterCert = certToResource(masterCert)
+		}
+	}
+
+	if k8s.MasterKey == nil {
+		key, err := certs.FindPrivateKey(masterSubject)
+		if err != nil {
+			return err
+		}
+
+		if key == nil && c.IsValidate() {
+			c.MarkDirty()
+		} else if key == nil {
+			return fmt.Errorf("kubernetes-master key not found")
+		} else if _, isRSA := key.(*rsa.PrivateKey); !isRSA {
+			return fmt.Errorf("kubernetes-master key is a %T, but it signs the service account tokens, so must be RSA; replace it with: kope update certificates --certs master --new-master-key --yes", key)
+		} else {
+			k8s.MasterKey = keyToResource(key)
+		}
+	}
+
+	return nil
+}
+
+// buildMasterAlternateNames returns the SANs for the master certificate; complete is false if the master public IP
+// should be included but is not yet known
+func (b *CertBuilder) buildMasterAlternateNames(c *fi.RunContext) ([]string, bool, error) {
+	alternateNames, err := buildCertificateAlternateNames(b.Kubernetes)
+	if err != nil {
+		return nil, false, err
+	}
+	if b.MasterIP != nil {
+		publicIP, err := b.MasterIP.FindPublicIP(c)
+		if err != nil {
+			return nil, false, fmt.Errorf("error querying for Master PublicIP: %v", err)
+		}
+		if publicIP == nil || *publicIP == "" {
+			return alternateNames, false, nil
+		}
+		alternateNames = append(alternateNames, *publicIP)
+	}
+	return alternateNames, true, nil
+}
+
+func issueMasterCert(certs fi.CAStore, subject *pkix.Name, privateKey crypto.PrivateKey, alternateNames []string) (*fi.Certificate, error) {
+	template := &x509.Certificate{
+		Subject:               *subject,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+	}
+
+	for _, san := range alternateNames {
+		if ip := net.ParseIP(san); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, san)
+		}
+	}
+
+	glog.V(2).Infof("X509 SANS IPAddresses: %v", template.IPAddresses)
+	glog.V(2).Infof("X509 SANS DNSNames: %v", template.DNSNames)
+
+	return certs.IssueCert(privateKey, template)
+}
+
+// MasterCertificate is rendered when the master certificate needs to be reissued, so that the dry-run & validate
+// reports include the change.  (On other targets the certificate is just reissued.)
+type MasterCertificate struct {
+	fi.SimpleUnit
+
+	SANs []string
+}
+
+// renderPendingMasterCert reports the master certificate that will be issued when the plan is applied; its SANs
+// include the master public IP, which is not yet allocated
+func renderPendingMasterCert(c *fi.RunContext, alternateNames []string) error {
+	var sans []string
+	for _, san := range alternateNames {
+		if ip := net.ParseIP(san); ip != nil {
+			san = ip.String()
+		}
+		sans = append(sans, san)
+	}
+	sans = append(sans, fi.PlanValueKnownAfterApply)
+	sans = normalizeSANs(sans)
+
+	var a *MasterCertificate
+	e := &MasterCertificate{SANs: sans}
+	changes := &MasterCertificate{SANs: sans}
+	for _, u := range []*MasterCertificate{e, changes} {
+		u.SetKey("master-certificate")
+	}
+	return c.Render(a, e, changes)
+}
+
+// checkMasterCertSANs reissues the master certificate (with the same key) if its SANs do not match the configuration
+func (b *CertBuilder) checkMasterCertSANs(c *fi.RunContext, subject *pkix.Name, cert *fi.Certificate) (*fi.Certificate, error) {
+	alternateNames, complete, err := b.buildMasterAlternateNames(c)
+	if err != nil {
+		return nil, err
+	}
+	if !complete {
+		// Otherwise we would reissue the certificate without the IP
+		glog.V(2).Infof("master public IP is not known; not checking the SANs of the master certificate")
+		return cert, nil
+	}
+
+	var actual []string
+	for _, ip := range cert.Certificate.IPAddresses {
+		actual = append(actual, ip.String())
+	}
+	actual = append(actual, cert.Certificate.DNSNames...)
+	actual = normalizeSANs(actual)
+
+	var expected []string
+	for _, san := range alternateNames {
+		if ip := net.ParseIP(san); ip != nil {
+			san = ip.String()
+		}
+		expected = append(expected, san)
+	}
+	expected = normalizeSANs(expected)
+
+	if reflect.DeepEqual(actual, expected) {
+		return cert, nil
+	}
+
+	glog.Infof("SANs of the master certificate have changed from %v to %v", actual, expected)
+
+	if _, isDryRun := c.Target.(*fi.DryRunTarget); isDryRun || c.IsValidate() {
+		a := &MasterCertificate{SANs: actual}
+		e := &MasterCertificate{SANs: expected}
+		changes := &MasterCertificate{SANs: expected}
+		for _, u := range []*MasterCertificate{a, e, changes} {
+			u.SetKey("master-certificate")
+		}
+		return cert, c.Render(a, e, changes)
+	}
+
+	certs := c.CAStore()
+	privateKey, err := certs.FindPrivateKey(subject)
+	if err != nil {
+		return nil, err
+	}
+	if privateKey == nil {
+		privateKey, err = certs.CreatePrivateKey(subject, fi.ServiceAccountKeyAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	glog.Infof("Reissuing master certificate")
+	return issueMasterCert(certs, subject, privateKey, alternateNames)
+}
+
+// normalizeSANs returns the SANs sorted and without duplicates, for comparison
+func normalizeSANs(sans []string) []string {
+	seen := make(map[string]bool)
+	var normalized []string
+	for _, san := range sans {
+		if seen[san] {
+			continue
+		}
+		seen[san] = true
+		normalized = append(normalized, san)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+func certToResource(cert *fi.Certificate) fi.Resource {
+	var data bytes.Buffer
+	err := cert.WriteCertificate(&data)
+	if err != nil {
+		glog.Fatalf("error writing CA certificate: %v", err)
+	}
+	return fi.NewBytesResource(data.Bytes())
+}
+
+// certsToResource returns a PEM bundle of the certificates
+func certsToResource(certs []*fi.Certificate) fi.Resource {
+	var data bytes.Buffer
+	for _, cert := range certs {
+		err := cert.WriteCertificate(&data)
+		if err != nil {
+			glog.Fatalf("error writing CA certificate: %v", err)
+		}
+	}
+	return fi.NewBytesResource(data.Bytes())
+}
+
+func keyToResource(privateKey crypto.PrivateKey) fi.Resource {
+	var data bytes.Buffer
+	err := fi.WritePrivateKey(privateKey, &data)
+	if err != nil {
+		glog.Fatalf("error writing private key: %v", err)
+	}
+	return fi.NewBytesResource(data.Bytes())
+}
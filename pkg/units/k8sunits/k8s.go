@@ -0,0 +1,600 @@
+package k8sunits
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/kopeio/kope/pkg/fi"
+	"github.com/kopeio/kope/pkg/units"
+	"gopkg.in/yaml.v2"
+	"math/big"
+	"net"
+	"strconv"
+)
+
+const (
+	DefaultMasterVolumeSize = 20
+)
+
+type K8s struct {
+	fi.SimpleUnit
+
+	S3Region     string
+	S3BucketName string
+
+	CloudProvider       string
+	CloudProviderConfig string
+
+	// GCEProject is the Google Cloud project, when CloudProvider is gce
+	GCEProject    string
+	GCSBucketName string
+
+	ClusterID string
+
+	MasterInstanceType string
+	NodeInstanceType   string
+
+	ImageID string
+
+	MasterInternalIP string
+	// TODO: Just move to master volume?
+	MasterVolume       string
+	MasterVolumeSize   *int
+	MasterVolumeType   string
+	MasterCIDR         string
+	MasterRoleDocument fi.Resource
+	MasterRolePolicy   fi.Resource
+
+	NodeRoleDocument fi.Resource
+	NodeRolePolicy   fi.Resource
+
+	NodeCount int
+
+	InstancePrefix     string
+	NodeInstancePrefix string
+	ClusterIPRange     string
+	MasterIPRange      string
+	AllocateNodeCIDRs  bool
+
+	ServerBinaryTar fi.Resource
+	SaltTar         fi.Resource
+	BootstrapScript fi.Resource
+
+	Zone         string
+	KubeUser     string
+	KubePassword string
+
+	//SaltMaster                    string
+	MasterName string
+
+	ServiceClusterIPRange        string
+	EnableL7LoadBalancing        string
+	EnableClusterMonitoring      string
+	EnableClusterLogging         bool
+	EnableNodeLogging            bool
+	LoggingDestination           string
+	ElasticsearchLoggingReplicas int
+
+	EnableClusterRegistry   *bool
+	ClusterRegistryDisk     *string
+	ClusterRegistryDiskSize *int
+
+	EnableClusterDNS bool
+	DNSReplicas      int
+	DNSServerIP      string
+	DNSDomain        string
+
+	RuntimeConfig string
+
+	// KeyAlgorithm is the type of key generated for the CA & certificates: rsa-2048 (default), rsa-4096, ecdsa-p256 or ecdsa-p384.
+	// The master key is always RSA, because it also signs the service account tokens.
+	KeyAlgorithm string
+
+	CACert         fi.Resource
+	CAKey          fi.Resource
+	KubeletCert    fi.Resource
+	KubeletKey     fi.Resource
+	KubeletToken   string
+	KubeProxyToken string
+	BearerToken    string
+	MasterCert     fi.Resource
+	MasterKey      fi.Resource
+	KubecfgCert    fi.Resource
+	KubecfgKey     fi.Resource
+
+	RegisterMasterKubelet *bool
+	//KubeletApiserver              string
+
+	EnableManifestURL *bool
+	ManifestURL       *string
+	ManifestURLHeader *string
+
+	NetworkProvider string
+
+	HairpinMode string
+
+	OpencontrailTag           string
+	OpencontrailKubernetesTag string
+	OpencontrailPublicSubnet  string
+
+	KubeImageTag       string
+	KubeDockerRegistry string
+	KubeAddonRegistry  string
+
+	Multizone *bool
+
+	NonMasqueradeCidr string
+
+	E2EStorageTestEnvironment string
+
+	EnableClusterUI bool
+
+	AdmissionControl string
+
+	KubeletPort *int
+
+	KubeApiserverRequestTimeout *int
+
+	TerminatedPodGcThreshold *string
+
+	KubeManifestsTarURL    string
+	KubeManifestsTarSha256 string
+
+	TestCluster string
+
+	DockerOptions string
+	DockerStorage string
+
+	MasterExtraSans []string
+
+	// TODO: Make struct?
+	KubeletTestArgs               string
+	KubeletTestLogLevel           string
+	DockerTestArgs                string
+	DockerTestLogLevel            string
+	ApiserverTestArgs             string
+	ApiserverTestLogLevel         string
+	ControllerManagerTestArgs     string
+	ControllerManagerTestLogLevel string
+	SchedulerTestArgs             string
+	SchedulerTestLogLevel         string
+	KubeProxyTestArgs             string
+	KubeProxyTestLogLevel         string
+
+	NodeLabels     string
+	OsDistribution string
+
+	ExtraDockerOpts string
+
+	ContainerRuntime        string
+	RktVersion              string
+	RktPath                 string
+	KubernetesConfigureCbr0 string
+
+	EnableCustomMetrics bool
+
+	SSHPublicKey fi.Resource
+
+	// For upgrades
+	SubnetID          *string
+	VPCID             *string
+	InternetGatewayID *string
+	RouteTableID      *string
+	DHCPOptionsID     *string
+	MasterElasticIP   *string
+}
+
+func (k *K8s) Key() string {
+	return k.ClusterID
+}
+
+func (k *K8s) BuildEnv(c *fi.RunContext, isMaster bool) (map[string]string, error) {
+	// The bootstrap script requires some variables to be set...
+	// We use this as a marker for future cleanup
+	legacyEmptyVar := ""
+
+	// For now, we add everything as a string...
+	// The first problem is that the python parser converts true / false to "True" and "False",
+	// which breaks string-based comparisons (as done in bash)
+	y := map[string]string{}
+	// ENV_TIMESTAMP breaks our deltas!
+	//y["ENV_TIMESTAMP"] = time.Now().UTC().Format("2006-01-02T03:04:05+0000")
+	y["INSTANCE_PREFIX"] = k.InstancePrefix
+	y["NODE_INSTANCE_PREFIX"] = k.NodeInstancePrefix
+	y["CLUSTER_IP_RANGE"] = k.ClusterIPRange
+
+	{
+		url, hash, err := c.Target.PutResource("server", k.ServerBinaryTar, fi.HashAlgorithmSHA1)
+		if err != nil {
+			return nil, err
+		}
+		y["SERVER_BINARY_TAR_URL"] = url
+		y["SERVER_BINARY_TAR_HASH"] = hash
+	}
+
+	{
+		url, hash, err := c.Target.PutResource("salt", k.SaltTar, fi.HashAlgorithmSHA1)
+		if err != nil {
+			return nil, err
+		}
+		y["SALT_TAR_URL"] = url
+		y["SALT_TAR_HASH"] = hash
+	}
+
+	y["SERVICE_CLUSTER_IP_RANGE"] = k.ServiceClusterIPRange
+
+	y["KUBERNETES_MASTER_NAME"] = k.MasterName
+
+	y["ALLOCATE_NODE_CIDRS"] = strconv.FormatBool(k.AllocateNodeCIDRs)
+
+	y["ENABLE_CLUSTER_MONITORING"] = k.EnableClusterMonitoring
+	y["ENABLE_L7_LOADBALANCING"] = k.EnableL7LoadBalancing
+	y["ENABLE_CLUSTER_LOGGING"] = strconv.FormatBool(k.EnableClusterLogging)
+	y["ENABLE_CLUSTER_UI"] = strconv.FormatBool(k.EnableClusterUI)
+	y["ENABLE_NODE_LOGGING"] = strconv.FormatBool(k.EnableNodeLogging)
+	y["LOGGING_DESTINATION"] = k.LoggingDestination
+	y["ELASTICSEARCH_LOGGING_REPLICAS"] = strconv.Itoa(k.ElasticsearchLoggingReplicas)
+	y["ENABLE_CLUSTER_DNS"] = strconv.FormatBool(k.EnableClusterDNS)
+	if k.EnableClusterRegistry != nil {
+		y["ENABLE_CLUSTER_REGISTRY"] = strconv.FormatBool(*k.EnableClusterRegistry)
+	} else {
+		y["ENABLE_CLUSTER_REGISTRY"] = legacyEmptyVar
+	}
+	if k.ClusterRegistryDisk != nil {
+		y["CLUSTER_REGISTRY_DISK"] = *k.ClusterRegistryDisk
+	}
+	if k.ClusterRegistryDiskSize != nil {
+		y["CLUSTER_REGISTRY_DISK_SIZE"] = strconv.Itoa(*k.ClusterRegistryDiskSize)
+	}
+	y["DNS_REPLICAS"] = strconv.Itoa(k.DNSReplicas)
+	y["DNS_SERVER_IP"] = k.DNSServerIP
+	y["DNS_DOMAIN"] = k.DNSDomain
+
+	y["KUBELET_TOKEN"] = k.KubeletToken
+	y["KUBE_PROXY_TOKEN"] = k.KubeProxyToken
+	y["ADMISSION_CONTROL"] = k.AdmissionControl
+	y["MASTER_IP_RANGE"] = k.MasterIPRange
+	y["RUNTIME_CONFIG"] = k.RuntimeConfig
+	y["CA_CERT"] = ResourceAsBase64String(k.CACert)
+	y["KUBELET_CERT"] = ResourceAsBase64String(k.KubeletCert)
+	y["KUBELET_KEY"] = ResourceAsBase64String(k.KubeletKey)
+	y["NETWORK_PROVIDER"] = k.NetworkProvider
+	y["HAIRPIN_MODE"] = k.HairpinMode
+	y["OPENCONTRAIL_TAG"] = k.OpencontrailTag
+	y["OPENCONTRAIL_KUBERNETES_TAG"] = k.OpencontrailKubernetesTag
+	y["OPENCONTRAIL_PUBLIC_SUBNET"] = k.OpencontrailPublicSubnet
+	y["E2E_STORAGE_TEST_ENVIRONMENT"] = k.E2EStorageTestEnvironment
+	y["KUBE_IMAGE_TAG"] = k.KubeImageTag
+	y["KUBE_DOCKER_REGISTRY"] = k.KubeDockerRegistry
+	y["KUBE_ADDON_REGISTRY"] = k.KubeAddonRegistry
+	if units.BoolValue(k.Multizone) {
+		y["MULTIZONE"] = "1"
+	}
+	y["NON_MASQUERADE_CIDR"] = k.NonMasqueradeCidr
+
+	if k.KubeletPort != nil {
+		y["KUBELET_PORT"] = strconv.Itoa(*k.KubeletPort)
+	}
+
+	if k.KubeApiserverRequestTimeout != nil {
+		y["KUBE_APISERVER_REQUEST_TIMEOUT"] = strconv.Itoa(*k.KubeApiserverRequestTimeout)
+	}
+
+	if k.TerminatedPodGcThreshold != nil {
+		y["TERMINATED_POD_GC_THRESHOLD"] = *k.TerminatedPodGcThreshold
+	}
+
+	if k.OsDistribution == "trusty" {
+		y["KUBE_MANIFESTS_TAR_URL"] = k.KubeManifestsTarURL
+		y["KUBE_MANIFESTS_TAR_HASH"] = k.KubeManifestsTarSha256
+	}
+
+	if k.TestCluster != "" {
+		y["TEST_CLUSTER"] = k.TestCluster
+	}
+
+	if k.KubeletTestArgs != "" {
+		y["KUBELET_TEST_ARGS"] = k.KubeletTestArgs
+	}
+
+	if k.KubeletTestLogLevel != "" {
+		y["KUBELET_TEST_LOG_LEVEL"] = k.KubeletTestLogLevel
+	}
+
+	if k.DockerTestLogLevel != "" {
+		y["DOCKER_TEST_LOG_LEVEL"] = k.DockerTestLogLevel
+	}
+
+	if k.EnableCustomMetrics {
+		y["ENABLE_CUSTOM_METRICS"] = strconv.FormatBool(k.EnableCustomMetrics)
+	}
+
+	if isMaster {
+		// If the user requested that the master be part of the cluster, set the
+		// environment variable to program the master kubelet to register itself.
+		if units.BoolValue(k.RegisterMasterKubelet) {
+			y["KUBELET_APISERVER"] = k.MasterName
+		}
+
+		y["KUBERNETES_MASTER"] = strconv.FormatBool(true)
+		y["KUBE_USER"] = k.KubeUser
+		y["KUBE_PASSWORD"] = k.KubePassword
+		y["KUBE_BEARER_TOKEN"] = k.BearerToken
+		y["MASTER_CERT"] = ResourceAsBase64String(k.MasterCert)
+		y["MASTER_KEY"] = ResourceAsBase64String(k.MasterKey)
+		y["KUBECFG_CERT"] = ResourceAsBase64String(k.KubecfgCert)
+		y["KUBECFG_KEY"] = ResourceAsBase64String(k.KubecfgKey)
+
+		if k.EnableManifestURL != nil {
+			y["ENABLE_MANIFEST_URL"] = strconv.FormatBool(*k.EnableManifestURL)
+		} else {
+			y["ENABLE_MANIFEST_URL"] = legacyEmptyVar
+		}
+		if k.ManifestURL != nil {
+			y["MANIFEST_URL"] = *k.ManifestURL
+		} else {
+			y["MANIFEST_URL"] = legacyEmptyVar
+		}
+		if k.ManifestURLHeader != nil {
+			y["MANIFEST_URL_HEADER"] = *k.ManifestURLHeader
+		} else {
+			y["MANIFEST_URL_HEADER"] = legacyEmptyVar
+		}
+		y["NUM_NODES"] = strconv.Itoa(k.NodeCount)
+
+		if k.ApiserverTestArgs != "" {
+			y["APISERVER_TEST_ARGS"] = k.ApiserverTestArgs
+		}
+
+		if k.ApiserverTestLogLevel != "" {
+			y["APISERVER_TEST_LOG_LEVEL"] = k.ApiserverTestLogLevel
+		}
+
+		if k.ControllerManagerTestArgs != "" {
+			y["CONTROLLER_MANAGER_TEST_ARGS"] = k.ControllerManagerTestArgs
+		}
+
+		if k.ControllerManagerTestLogLevel != "" {
+			y["CONTROLLER_MANAGER_TEST_LOG_LEVEL"] = k.ControllerManagerTestLogLevel
+		}
+
+		if k.SchedulerTestArgs != "" {
+			y["SCHEDULER_TEST_ARGS"] = k.SchedulerTestArgs
+		}
+
+		if k.SchedulerTestLogLevel != "" {
+			y["SCHEDULER_TEST_LOG_LEVEL"] = k.SchedulerTestLogLevel
+		}
+
+	}
+
+	if !isMaster {
+		// Node-only vars
+
+		y["KUBERNETES_MASTER"] = strconv.FormatBool(false)
+		y["ZONE"] = k.Zone
+		y["EXTRA_DOCKER_OPTS"] = k.ExtraDockerOpts
+		if k.ManifestURL != nil {
+			y["MANIFEST_URL"] = *k.ManifestURL
+		}
+
+		if k.KubeProxyTestArgs != "" {
+			y["KUBEPROXY_TEST_ARGS"] = k.KubeProxyTestArgs
+		}
+
+		if k.KubeProxyTestLogLevel != "" {
+			y["KUBEPROXY_TEST_LOG_LEVEL"] = k.KubeProxyTestLogLevel
+		}
+	}
+
+	if k.NodeLabels != "" {
+		y["NODE_LABELS"] = k.NodeLabels
+	}
+
+	if k.OsDistribution == "coreos" {
+		// CoreOS-only env vars. TODO(yifan): Make them available on other distros.
+		y["KUBE_MANIFESTS_TAR_URL"] = k.KubeManifestsTarURL
+		y["KUBE_MANIFESTS_TAR_HASH"] = k.KubeManifestsTarSha256
+		y["KUBERNETES_CONTAINER_RUNTIME"] = k.ContainerRuntime
+		y["RKT_VERSION"] = k.RktVersion
+		y["RKT_PATH"] = k.RktPath
+		y["KUBERNETES_CONFIGURE_CBR0"] = k.KubernetesConfigureCbr0
+
+	}
+
+	// This next bit for changes vs kube-up:
+	y["CA_KEY"] = ResourceAsBase64String(k.CAKey) // https://github.com/kubernetes/kubernetes/issues/23264
+
+	return y, nil
+}
+
+func (k *K8s) Init() {
+	k.NodeCount = 2
+	k.DockerStorage = "aufs"
+	k.MasterIPRange = "10.246.0.0/24"
+	k.MasterVolumeSize = units.Int(DefaultMasterVolumeSize)
+	k.EnableClusterUI = true
+	k.EnableClusterDNS = true
+	k.EnableClusterLogging = true
+	k.LoggingDestination = "elasticsearch"
+	k.EnableClusterMonitoring = "influxdb" // "none" ?
+	k.EnableL7LoadBalancing = "none"
+	k.EnableNodeLogging = true
+	k.ElasticsearchLoggingReplicas = 1
+	k.DNSReplicas = 1
+	k.DNSServerIP = "10.0.0.10"
+	k.DNSDomain = "cluster.local"
+	k.AdmissionControl = "NamespaceLifecycle,LimitRanger,SecurityContextDeny,ServiceAccount,ResourceQuota,PersistentVolumeLabel"
+	k.ServiceClusterIPRange = "10.0.0.0/16"
+	k.ClusterIPRange = "10.244.0.0/16"
+
+	k.NetworkProvider = "none"
+
+	k.ContainerRuntime = "docker"
+	k.KubernetesConfigureCbr0 = "true"
+
+	// Required to work with autoscaling minions
+	k.AllocateNodeCIDRs = true
+
+	k.CloudProvider = "aws"
+}
+
+// SetCloudDefaults sets the defaults that depend on the CloudProvider, for any values that were not configured.
+// It must be called after the state has been loaded.
+func (k *K8s) SetCloudDefaults() {
+	var machineType, masterInternalIP, volumeType, zone string
+	switch k.CloudProvider {
+	case "gce":
+		machineType = "n1-standard-1"
+		masterInternalIP = "10.240.0.9"
+		volumeType = "pd-ssd"
+		zone = "us-central1-b"
+	default:
+		machineType = "m3.medium"
+		masterInternalIP = "172.20.0.9"
+		volumeType = "gp2"
+		zone = "us-east-1b"
+	}
+
+	if k.MasterInstanceType == "" {
+		k.MasterInstanceType = machineType
+	}
+	if k.NodeInstanceType == "" {
+		k.NodeInstanceType = machineType
+	}
+	if k.MasterInternalIP == "" {
+		k.MasterInternalIP = masterInternalIP
+	}
+	if k.MasterVolumeType == "" {
+		k.MasterVolumeType = volumeType
+	}
+	if k.Zone == "" {
+		k.Zone = zone
+	}
+}
+
+// CloudUnitsBuilder adds the cloud-specific units for a cluster
+type CloudUnitsBuilder func(k *K8s, c *fi.BuildContext)
+
+var cloudUnitsBuilders = make(map[string]CloudUnitsBuilder)
+
+// RegisterCloudProvider registers the units for a CloudProvider; the package that implements them
+// (e.g. awsunits) calls this from init
+func RegisterCloudProvider(cloudProvider string, builder CloudUnitsBuilder) {
+	cloudUnitsBuilders[cloudProvider] = builder
+}
+
+func (k *K8s) MergeState(state []byte) error {
+	glog.V(4).Infof("Loading yaml: %s", string(state))
+
+	var yamlObj map[string]interface{}
+	err := yaml.Unmarshal(state, &yamlObj)
+	if err != nil {
+		return fmt.Errorf("error loading state (yaml read phase): %v", err)
+	}
+
+	jsonBytes, err := json.Marshal(yamlObj)
+	if err != nil {
+		return fmt.Errorf("error loading state (json write phase): %v", err)
+	}
+
+	err = json.Unmarshal(jsonBytes, k)
+	if err != nil {
+		return fmt.Errorf("error loading state (json read phase): %v", err)
+	}
+
+	return nil
+}
+
+func (k *K8s) Add(c *fi.BuildContext) {
+	clusterID := k.ClusterID
+	if clusterID == "" {
+		glog.Exit("cluster-id is required")
+	}
+
+	if len(k.Zone) <= 2 {
+		glog.Exit("Invalid AZ: ", k.Zone)
+	}
+
+	// Simplifications
+	instancePrefix := k.ClusterID
+	if k.InstancePrefix == "" {
+		k.InstancePrefix = instancePrefix
+	}
+
+	if k.NodeInstancePrefix == "" {
+		k.NodeInstancePrefix = instancePrefix + "-minion"
+	}
+	if k.MasterName == "" {
+		k.MasterName = instancePrefix + "-master"
+	}
+
+	if k.KubeUser == "" {
+		k.KubeUser = "admin"
+	}
+	if k.KubePassword == "" {
+		k.KubePassword = units.RandomToken(16)
+	}
+
+	if k.KubeletToken == "" {
+		k.KubeletToken = units.RandomToken(32)
+	}
+
+	if k.KubeProxyToken == "" {
+		k.KubeProxyToken = units.RandomToken(32)
+	}
+
+	builder := cloudUnitsBuilders[k.CloudProvider]
+	if builder == nil {
+		glog.Exitf("unsupported CloudProvider %q", k.CloudProvider)
+	}
+	builder(k, c)
+}
+
+func (k *K8s) GetWellKnownServiceIP(id int) (net.IP, error) {
+	_, cidr, err := net.ParseCIDR(k.ServiceClusterIPRange)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing ServiceClusterIPRange: %v", err)
+	}
+
+	ip4 := cidr.IP.To4()
+	if ip4 != nil {
+		n := binary.BigEndian.Uint32(ip4)
+		n += uint32(id)
+		serviceIP := make(net.IP, len(ip4))
+		binary.BigEndian.PutUint32(serviceIP, n)
+		return serviceIP, nil
+	}
+
+	ip6 := cidr.IP.To16()
+	if ip6 != nil {
+		baseIPInt := big.NewInt(0)
+		baseIPInt.SetBytes(ip6)
+		serviceIPInt := big.NewInt(0)
+		serviceIPInt.Add(big.NewInt(int64(id)), baseIPInt)
+		serviceIP := make(net.IP, len(ip6))
+		serviceIPBytes := serviceIPInt.Bytes()
+		for i := range serviceIPBytes {
+			serviceIP[len(serviceIP)-len(serviceIPBytes)+i] = serviceIPBytes[i]
+		}
+		return serviceIP, nil
+	}
+
+	return nil, fmt.Errorf("Unexpected IP address type for ServiceClusterIPRange: %s", k.ServiceClusterIPRange)
+
+}
+
+func ResourceAsBase64String(r fi.Resource) string {
+	if r == nil {
+		return ""
+	}
+
+	data, err := fi.ResourceAsBytes(r)
+	if err != nil {
+		glog.Fatalf("error reading resource: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(data)
+}
@@ -1,4 +1,4 @@
-package awsunits
+package k8sunits
 
 import (
 	"fmt"
@@ -14,8 +14,11 @@ type MasterScript struct {
 	fi.SimpleUnit
 
 	Config   *K8s
+	// Certificates populates the certificates in Config, so must run first
+	Certificates *CertBuilder
 
 	contents string
+	knownAfterApply bool
 }
 
 func (s *MasterScript) Key() string {
@@ -23,11 +26,14 @@ func (s *MasterScript) Key() string {
 }
 
 var _ fi.Resource = &MasterScript{}
+var _ fi.KnownAfterApply = &MasterScript{}
 
 type NodeScript struct {
 	fi.SimpleUnit
 
 	Config   *K8s
+	// Certificates populates the certificates in Config, so must run first
+	Certificates *CertBuilder
 
 	contents string
 }
@@ -42,6 +48,75 @@ func (s *NodeScript) Key() string {
 //	return "node_script"
 //}
 
+// KubeEnv is the kube_env.yaml for the master or the nodes.  On GCE it is passed to the instances as metadata,
+// rather than being embedded in the startup script.
+type KubeEnv struct {
+	fi.SimpleUnit
+
+	Config   *K8s
+	// Certificates populates the certificates in Config, so must run first
+	Certificates *CertBuilder
+	IsMaster bool
+
+	contents string
+	knownAfterApply bool
+}
+
+var _ fi.Resource = &KubeEnv{}
+var _ fi.KnownAfterApply = &KubeEnv{}
+
+func (s *KubeEnv) Key() string {
+	if s.IsMaster {
+		return "master-kube-env"
+	}
+	return "node-kube-env"
+}
+
+func (s *KubeEnv) Run(c *fi.RunContext) error {
+	yamlData, err := buildKubeEnv(c, s.Config, s.IsMaster)
+	if err != nil {
+		return err
+	}
+	s.contents = string(yamlData)
+	s.knownAfterApply = isKubeEnvKnownAfterApply(s.Config, s.IsMaster)
+	return nil
+}
+
+func (s *KubeEnv) Open() (io.ReadSeeker, error) {
+	if s.contents == "" {
+		panic("executed out of sequence")
+	}
+	return bytes.NewReader([]byte(s.contents)), nil
+}
+
+func (s *KubeEnv) IsKnownAfterApply() bool {
+	return s.knownAfterApply
+}
+
+// isKubeEnvKnownAfterApply returns true if the kube env includes resources that are only built when a plan is applied
+func isKubeEnvKnownAfterApply(k *K8s, isMaster bool) bool {
+	return isMaster && (fi.IsKnownAfterApply(k.MasterCert) || fi.IsKnownAfterApply(k.MasterKey))
+}
+
+func buildKubeEnv(c *fi.RunContext, k *K8s, isMaster bool) ([]byte, error) {
+	data, err := k.BuildEnv(c, isMaster)
+	if err != nil {
+		return nil, err
+	}
+	data["AUTO_UPGRADE"] = strconv.FormatBool(true)
+	if k.CloudProvider == "aws" {
+		// TODO: get rid of these exceptions / harmonize with common or GCE
+		data["DOCKER_STORAGE"] = k.DockerStorage
+		data["API_SERVERS"] = k.MasterInternalIP
+	}
+
+	yamlData, err := yaml.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling env to yaml: %v", err)
+	}
+	return yamlData, nil
+}
+
 func buildScript(c *fi.RunContext, k *K8s, isMaster bool) (string, error) {
 	var bootstrapScriptURL string
 
@@ -53,19 +128,10 @@ func buildScript(c *fi.RunContext, k *K8s, isMaster bool) (string, error) {
 		bootstrapScriptURL = url
 	}
 
-	data, err := k.BuildEnv(c, isMaster)
+	yamlData, err := buildKubeEnv(c, k, isMaster)
 	if err != nil {
 		return "", err
 	}
-	data["AUTO_UPGRADE"] = strconv.FormatBool(true)
-	// TODO: get rid of these exceptions / harmonize with common or GCE
-	data["DOCKER_STORAGE"] = k.DockerStorage
-	data["API_SERVERS"] = k.MasterInternalIP
-
-	yamlData, err := yaml.Marshal(data)
-	if err != nil {
-		return "", fmt.Errorf("error marshaling env to yaml: %v", err)
-	}
 
 	// We send this to the ami as a startup script in the user-data field.  Requires a compatible ami
 	var s fi.ScriptWriter
@@ -75,7 +141,8 @@ func buildScript(c *fi.RunContext, k *K8s, isMaster bool) (string, error) {
 
 	s.WriteHereDoc("kube_env.yaml", string(yamlData))
 
-	s.WriteString("wget -O bootstrap " + bootstrapScriptURL + "\n")
+	// Presigned URLs contain &, so the URL must be quoted
+	s.WriteString("wget -O bootstrap " + fi.BashQuoteString(bootstrapScriptURL) + "\n")
 	s.WriteString("chmod +x bootstrap\n")
 	s.WriteString("mkdir -p /etc/kubernetes\n")
 	s.WriteString("mv kube_env.yaml /etc/kubernetes\n")
@@ -99,6 +166,7 @@ func (m*MasterScript) Run(c *fi.RunContext) error {
 		return err
 	}
 	m.contents = contents
+	m.knownAfterApply = isKubeEnvKnownAfterApply(m.Config, isMaster)
 	return nil
 }
 
@@ -109,6 +177,10 @@ func (m *MasterScript) Open() (io.ReadSeeker, error) {
 	return bytes.NewReader([]byte(m.contents)), nil
 }
 
+func (m *MasterScript) IsKnownAfterApply() bool {
+	return m.knownAfterApply
+}
+
 func (m*NodeScript) Run(c *fi.RunContext) error {
 	isMaster := false
 	contents, err := buildScript(c, m.Config, isMaster)
@@ -1,4 +1,4 @@
-package awsunits
+package units
 
 import (
 	"reflect"
@@ -95,6 +95,43 @@ func equalFieldValues(a, e reflect.Value) bool {
 			}
 		}
 	}
+	// Compare structs, maps & slices element by element, so that units they reference are compared by ID
+	if a.Kind() == reflect.Struct && a.Type() == e.Type() {
+		for i := 0; i < a.NumField(); i++ {
+			if a.Type().Field(i).PkgPath != "" {
+				// unexported
+				continue
+			}
+			if !equalFieldValues(a.Field(i), e.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	if a.Kind() == reflect.Map && a.Type() == e.Type() {
+		if a.Len() != e.Len() {
+			return false
+		}
+		for _, k := range e.MapKeys() {
+			av := a.MapIndex(k)
+			if !av.IsValid() || !equalFieldValues(av, e.MapIndex(k)) {
+				return false
+			}
+		}
+		return true
+	}
+	if a.Kind() == reflect.Slice && a.Type() == e.Type() {
+		if a.Len() != e.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equalFieldValues(a.Index(i), e.Index(i)) {
+				return false
+			}
+		}
+		return true
+	}
+
 	//if a.Kind() == reflect.Ptr && !a.IsNil() && e.Kind() == reflect.Ptr && !e.IsNil() {
 	//	if reflect.DeepEqual(a.Elem().Interface(), e.Elem().Interface()) {
 	//		return true